@@ -17,6 +17,7 @@ import (
 	"github.com/mjl-/bstore"
 
 	"github.com/mjl-/mox/dmarcdb"
+	"github.com/mjl-/mox/mlist"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxvar"
 	"github.com/mjl-/mox/mtastsdb"
@@ -288,6 +289,7 @@ func backupctl(ctx context.Context, ctl *ctl) {
 	backupDB(mtastsdb.DB, "mtasts.db")
 	backupDB(tlsrptdb.ReportDB, "tlsrpt.db")
 	backupDB(tlsrptdb.ResultDB, "tlsrptresult.db")
+	backupDB(mlist.DB, "mlist.db")
 	backupFile("receivedid.key")
 
 	// Acme directory is optional.
@@ -433,8 +435,8 @@ func backupctl(ctx context.Context, ctl *ctl) {
 		tmMsgs := time.Now()
 		seen := map[string]struct{}{}
 		var nlinked, ncopied int
-		err = bstore.QueryDB[store.Message](ctx, db).FilterEqual("Expunged", false).ForEach(func(m store.Message) error {
-			mp := store.MessagePath(m.ID)
+		linkMessage := func(id int64) {
+			mp := store.MessagePath(id)
 			seen[mp] = struct{}{}
 			amp := filepath.Join("accounts", acc.Name, "msg", mp)
 			srcpath := filepath.Join(srcDataDir, amp)
@@ -446,8 +448,18 @@ func backupctl(ctx context.Context, ctl *ctl) {
 			} else {
 				ncopied++
 			}
+		}
+		err = bstore.QueryDB[store.Message](ctx, db).FilterEqual("Expunged", false).ForEach(func(m store.Message) error {
+			linkMessage(m.ID)
 			return nil
 		})
+		if err == nil {
+			// Deleted messages that are kept so they can be recovered.
+			err = bstore.QueryDB[store.DeletedMessage](ctx, db).ForEach(func(dm store.DeletedMessage) error {
+				linkMessage(dm.ID)
+				return nil
+			})
+		}
 		if err != nil {
 			xerrx("processing account messages (not backed up properly)", err, slog.Duration("duration", time.Since(tmMsgs)))
 		} else {
@@ -541,7 +553,7 @@ func backupctl(ctx context.Context, ctl *ctl) {
 		}
 
 		switch p {
-		case "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "receivedid.key", "ctl":
+		case "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "mlist.db", "receivedid.key", "ctl":
 			// Already handled.
 			return nil
 		case "lastknownversion": // Optional file, not yet handled.
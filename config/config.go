@@ -72,6 +72,7 @@ type Static struct {
 	NoOutgoingTLSReports            bool  `sconf:"optional" sconf-doc:"Do not send TLS reports. By default, reports about failed SMTP STARTTLS connections and related MTA-STS/DANE policies are sent to domains if their TLSRPT DNS record requests them. Reports covering a 24 hour UTC interval are sent daily. Reports are sent from the postmaster address of the configured domain the mailhostname is in. If there is no such domain, or it does not have DKIM configured, no reports are sent."`
 	OutgoingTLSReportsForAllSuccess bool  `sconf:"optional" sconf-doc:"Also send TLS reports if there were no SMTP STARTTLS connection failures. By default, reports are only sent when at least one failure occurred. If a report is sent, it does always include the successful connection counts as well."`
 	QuotaMessageSize                int64 `sconf:"optional" sconf-doc:"Default maximum total message size in bytes for each individual account, only applicable if greater than zero. Can be overridden per account. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage. The quota only applies to the email message files, not to any file system overhead and also not the message index database file (account for approximately 15% overhead)."`
+	QuotaMessageCount               int64 `sconf:"optional" sconf-doc:"Default maximum number of messages for each individual account, only applicable if greater than zero. Can be overridden per account. Attempting to add new messages to an account beyond its maximum number of messages will result in an error. Messages kept after being expunged do not count."`
 
 	// All IPs that were explicitly listen on for external SMTP. Only set when there
 	// are no unspecified external SMTP listeners and there is at most one for IPv4 and
@@ -174,6 +175,11 @@ type Listener struct {
 		Enabled bool
 		Port    int `sconf:"optional" sconf-doc:"Default 993."`
 	} `sconf:"optional" sconf-doc:"IMAP over TLS for reading email, by email applications. Requires a TLS config."`
+	ManageSieve struct {
+		Enabled           bool
+		Port              int  `sconf:"optional" sconf-doc:"Default 4190."`
+		NoRequireSTARTTLS bool `sconf:"optional" sconf-doc:"Allow authentication without TLS."`
+	} `sconf:"optional" sconf-doc:"ManageSieve for managing Sieve filtering scripts of accounts, e.g. by email applications. Starts out in plain text, can be upgraded to TLS with the STARTTLS command."`
 	AccountHTTP  WebService `sconf:"optional" sconf-doc:"Account web interface, for email users wanting to change their accounts, e.g. set new password, set new delivery rulesets. Default path is /."`
 	AccountHTTPS WebService `sconf:"optional" sconf-doc:"Account web interface listener like AccountHTTP, but for HTTPS. Requires a TLS config."`
 	AdminHTTP    WebService `sconf:"optional" sconf-doc:"Admin web interface, for managing domains, accounts, etc. Default path is /admin/. Preferably only enable on non-public IPs. Hint: use 'ssh -L 8080:localhost:80 you@yourmachine' and open http://localhost:8080/admin/, or set up a tunnel (e.g. WireGuard) and add its IP to the mox 'internal' listener."`
@@ -198,6 +204,11 @@ type Listener struct {
 		Port    int  `sconf:"optional" sconf-doc:"TLS port, 443 by default. You should only override this if you cannot listen on port 443 directly. MTA-STS requests will be made to port 443, so you'll have to add an external mechanism to get the connection here, e.g. by configuring port forwarding."`
 		NonTLS  bool `sconf:"optional" sconf-doc:"If set, plain HTTP instead of HTTPS is spoken on the configured port. Can be useful when the mta-sts domain is reverse proxied."`
 	} `sconf:"optional" sconf-doc:"Serve MTA-STS policies describing SMTP TLS requirements. Requires a TLS config."`
+	MailingListHTTPS struct {
+		Enabled bool
+		Port    int  `sconf:"optional" sconf-doc:"TLS port, 443 by default. URLs in List-Unsubscribe headers don't include a port, you'll have to add an external mechanism to get connections on port 443 here if you override it, or configure NonTLS."`
+		NonTLS  bool `sconf:"optional" sconf-doc:"If set, plain HTTP instead of HTTPS is spoken on the configured port. Can be useful when the hostname is reverse proxied."`
+	} `sconf:"optional" sconf-doc:"Serve one-click unsubscribe requests for hosted mailing lists at /mlist/unsubscribe/ on the hostname of the listener, as linked to from the List-Unsubscribe header in messages sent to subscribers. Requires a TLS config. If not enabled, messages only have an email address for unsubscribing."`
 	WebserverHTTP struct {
 		Enabled bool
 		Port    int `sconf:"optional" sconf-doc:"Port for plain HTTP (non-TLS) webserver."`
@@ -261,20 +272,57 @@ type TransportSocks struct {
 }
 
 type Domain struct {
-	Description                string  `sconf:"optional" sconf-doc:"Free-form description of domain."`
-	ClientSettingsDomain       string  `sconf:"optional" sconf-doc:"Hostname for client settings instead of the mail server hostname. E.g. mail.<domain>. For future migration to another mail operator without requiring all clients to update their settings, it is convenient to have client settings that reference a subdomain of the hosted domain instead of the hostname of the server where the mail is currently hosted. If empty, the hostname of the mail server is used for client configurations."`
-	LocalpartCatchallSeparator string  `sconf:"optional" sconf-doc:"If not empty, only the string before the separator is used to for email delivery decisions. For example, if set to \"+\", you+anything@example.com will be delivered to you@example.com."`
-	LocalpartCaseSensitive     bool    `sconf:"optional" sconf-doc:"If set, upper/lower case is relevant for email delivery."`
-	DKIM                       DKIM    `sconf:"optional" sconf-doc:"With DKIM signing, a domain is taking responsibility for (content of) emails it sends, letting receiving mail servers build up a (hopefully positive) reputation of the domain, which can help with mail delivery."`
-	DMARC                      *DMARC  `sconf:"optional" sconf-doc:"With DMARC, a domain publishes, in DNS, a policy on how other mail servers should handle incoming messages with the From-header matching this domain and/or subdomain (depending on the configured alignment). Receiving mail servers use this to build up a reputation of this domain, which can help with mail delivery. A domain can also publish an email address to which reports about DMARC verification results can be sent by verifying mail servers, useful for monitoring. Incoming DMARC reports are automatically parsed, validated, added to metrics and stored in the reporting database for later display in the admin web pages."`
-	MTASTS                     *MTASTS `sconf:"optional" sconf-doc:"With MTA-STS a domain publishes, in DNS, presence of a policy for using/requiring TLS for SMTP connections. The policy is served over HTTPS."`
-	TLSRPT                     *TLSRPT `sconf:"optional" sconf-doc:"With TLSRPT a domain specifies in DNS where reports about encountered SMTP TLS behaviour should be sent. Useful for monitoring. Incoming TLS reports are automatically parsed, validated, added to metrics and stored in the reporting database for later display in the admin web pages."`
-	Routes                     []Route `sconf:"optional" sconf-doc:"Routes for delivering outgoing messages through the queue. Each delivery attempt evaluates account routes, these domain routes and finally global routes. The transport of the first matching route is used in the delivery attempt. If no routes match, which is the default with no configured routes, messages are delivered directly from the queue."`
+	Description                string                 `sconf:"optional" sconf-doc:"Free-form description of domain."`
+	ClientSettingsDomain       string                 `sconf:"optional" sconf-doc:"Hostname for client settings instead of the mail server hostname. E.g. mail.<domain>. For future migration to another mail operator without requiring all clients to update their settings, it is convenient to have client settings that reference a subdomain of the hosted domain instead of the hostname of the server where the mail is currently hosted. If empty, the hostname of the mail server is used for client configurations."`
+	LocalpartCatchallSeparator string                 `sconf:"optional" sconf-doc:"If not empty, only the string before the separator is used to for email delivery decisions. For example, if set to \"+\", you+anything@example.com will be delivered to you@example.com."`
+	LocalpartCaseSensitive     bool                   `sconf:"optional" sconf-doc:"If set, upper/lower case is relevant for email delivery."`
+	DKIM                       DKIM                   `sconf:"optional" sconf-doc:"With DKIM signing, a domain is taking responsibility for (content of) emails it sends, letting receiving mail servers build up a (hopefully positive) reputation of the domain, which can help with mail delivery."`
+	DMARC                      *DMARC                 `sconf:"optional" sconf-doc:"With DMARC, a domain publishes, in DNS, a policy on how other mail servers should handle incoming messages with the From-header matching this domain and/or subdomain (depending on the configured alignment). Receiving mail servers use this to build up a reputation of this domain, which can help with mail delivery. A domain can also publish an email address to which reports about DMARC verification results can be sent by verifying mail servers, useful for monitoring. Incoming DMARC reports are automatically parsed, validated, added to metrics and stored in the reporting database for later display in the admin web pages."`
+	MTASTS                     *MTASTS                `sconf:"optional" sconf-doc:"With MTA-STS a domain publishes, in DNS, presence of a policy for using/requiring TLS for SMTP connections. The policy is served over HTTPS."`
+	TLSRPT                     *TLSRPT                `sconf:"optional" sconf-doc:"With TLSRPT a domain specifies in DNS where reports about encountered SMTP TLS behaviour should be sent. Useful for monitoring. Incoming TLS reports are automatically parsed, validated, added to metrics and stored in the reporting database for later display in the admin web pages."`
+	Routes                     []Route                `sconf:"optional" sconf-doc:"Routes for delivering outgoing messages through the queue. Each delivery attempt evaluates account routes, these domain routes and finally global routes. The transport of the first matching route is used in the delivery attempt. If no routes match, which is the default with no configured routes, messages are delivered directly from the queue."`
+	Aliases                    map[string]Alias       `sconf:"optional" sconf-doc:"Aliases for distributing incoming messages to multiple addresses, e.g. a team@ address. Keys are the localparts of the alias addresses in this domain, in canonical form: lower-case unless LocalpartCaseSensitive is set, and without the LocalpartCatchallSeparator. Alias addresses cannot also be account addresses."`
+	SubaddressMailboxes        *SubaddressMailboxes   `sconf:"optional" sconf-doc:"If set, messages to an address with a subaddress (the detail after the LocalpartCatchallSeparator, e.g. \"shop\" in you+shop@example.com) are delivered to a mailbox named after the detail, created automatically under a parent mailbox. Only applies to messages that don't match a ruleset of the destination. Requires LocalpartCatchallSeparator."`
+	MailingLists               map[string]MailingList `sconf:"optional" sconf-doc:"Mailing lists hosted for this domain. Keys are the localparts of the list addresses, in canonical form like for Aliases. Messages to the list address are sent to all subscribers. People subscribe by sending a message to the <list>-subscribe address and replying to the confirmation request, and unsubscribe through the <list>-unsubscribe address or the link in the List-Unsubscribe header. Messages to <list>-owner are forwarded to the owners. Subscribers are stored in the mailing list database, not in this file."`
+	Admins                     []string               `sconf:"optional" sconf-doc:"Accounts that can administer this domain through the admin web interface, logging in with an email address and password of the account. Domain administrators can manage accounts, addresses and aliases of their domains, and view their DNS records and reports, but cannot change server-wide settings. An account can only be managed if its default domain and the domains of all its addresses are administered by the domain administrator."`
 
 	Domain                  dns.Domain `sconf:"-" json:"-"`
 	ClientSettingsDNSDomain dns.Domain `sconf:"-" json:"-"`
 }
 
+type SubaddressMailboxes struct {
+	Parent       string   `sconf:"optional" sconf-doc:"Mailbox under which mailboxes for subaddresses are created. Default: Plus."`
+	AllowRegexp  []string `sconf-doc:"Only subaddresses matching (a substring of) one of these regular expressions are delivered to their own mailbox. At least one is required, so senders cannot create mailboxes for arbitrary subaddresses unless explicitly allowed, e.g. with '.'. Subaddresses are converted to lower case before matching. E.g. '^(shop|travel)$'."`
+	MaxMailboxes int      `sconf:"optional" sconf-doc:"Maximum number of mailboxes under the parent mailbox of an account. When reached, messages for subaddresses without an existing mailbox are delivered as if this option was not set. Default: 100."`
+
+	AllowRegexpCompiled []*regexp.Regexp `sconf:"-" json:"-"`
+}
+
+type Alias struct {
+	Addresses    []string `sconf-doc:"Addresses of the members of the alias. Messages for the alias are delivered to local addresses, which must be account addresses, as if sent to them directly. Messages are forwarded to external addresses, with the envelope sender rewritten with SRS. Spam filtering for external addresses is done with the account of the first local address, so at least one local address is required if there are external addresses."`
+	PostPublic   bool     `sconf:"optional" sconf-doc:"If set, anyone can send messages to the alias. Otherwise, only members and the addresses in AllowMsgFrom can send messages, as verified through the message From header with DKIM and/or SPF alignment, like DMARC."`
+	AllowMsgFrom []string `sconf:"optional" sconf-doc:"Additional addresses, besides members, that can send messages to the alias if PostPublic is not set, e.g. an address of a ticket system."`
+
+	ParsedAddresses []AliasAddress `sconf:"-" json:"-"`
+}
+
+// AliasAddress is a member of an alias. Local members have an account.
+type AliasAddress struct {
+	Address     smtp.Address
+	AccountName string      // Empty for external addresses.
+	Destination Destination // Only for local addresses.
+}
+
+type MailingList struct {
+	Description string   `sconf:"optional" sconf-doc:"Short description of the list, used in the List-Id header and in messages about subscriptions."`
+	Owners      []string `sconf-doc:"Addresses of the owners of the list. Messages to the <list>-owner address are forwarded to them. Owners can always post to the list. At least one owner must be a local account address: posts are checked for spam and delivery rates with the account of the first local owner, like messages for that account, but not stored in it."`
+	PostPolicy  string   `sconf:"optional" sconf-doc:"Who can post messages to the list: subscribers (default), owners (for announcement lists), or anyone. Except for anyone, the message From header must be verified through DKIM and/or SPF alignment, like DMARC."`
+	FromRewrite bool     `sconf:"optional" sconf-doc:"If set, the From header of posts from domains with a DMARC policy of reject or quarantine is rewritten to the list address, with the name of the original sender. The original address is added as Reply-To header, if not present. Without rewriting, subscribers may reject such posts when the DKIM signature of the sender no longer verifies, e.g. due to modifications made by mail servers in between, because SPF fails for the server of the list."`
+
+	ParsedOwners []smtp.Address `sconf:"-" json:"-"`
+	OwnerAccount string         `sconf:"-" json:"-"` // Account of first local owner, for checking posts.
+}
+
 type DMARC struct {
 	Localpart string `sconf-doc:"Address-part before the @ that accepts DMARC reports. Must be non-internationalized. Recommended value: dmarc-reports."`
 	Domain    string `sconf:"optional" sconf-doc:"Alternative domain for report recipient address. Can be used to receive reports for other domains. Unicode name."`
@@ -349,9 +397,14 @@ type Account struct {
 	SubjectPass  struct {
 		Period time.Duration `sconf-doc:"How long unique values are accepted after generating, e.g. 12h."` // todo: have a reasonable default for this?
 	} `sconf:"optional" sconf-doc:"If configured, messages classified as weakly spam are rejected with instructions to retry delivery, but this time with a signed token added to the subject. During the next delivery attempt, the signed token will bypass the spam filter. Messages with a clear spam signal, such as a known bad reputation, are rejected/delayed without a signed token."`
-	QuotaMessageSize   int64  `sconf:"optional" sconf-doc:"Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage."`
-	RejectsMailbox     string `sconf:"optional" sconf-doc:"Mail that looks like spam will be rejected, but a copy can be stored temporarily in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can look there. The mail still isn't accepted, so the remote mail server may retry (hopefully, if legitimate), or give up (hopefully, if indeed a spammer). Messages are automatically removed from this mailbox, so do not set it to a mailbox that has messages you want to keep."`
-	KeepRejects        bool   `sconf:"optional" sconf-doc:"Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training."`
+	QuotaMessageSize   int64           `sconf:"optional" sconf-doc:"Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage."`
+	QuotaMessageCount  int64           `sconf:"optional" sconf-doc:"Maximum number of messages for the account, overriding any globally configured default maximum if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum number of messages will result in an error."`
+	RejectsMailbox     string          `sconf:"optional" sconf-doc:"Mail that looks like spam will be rejected, but a copy can be stored temporarily in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can look there. The mail still isn't accepted, so the remote mail server may retry (hopefully, if legitimate), or give up (hopefully, if indeed a spammer). Messages are automatically removed from this mailbox, so do not set it to a mailbox that has messages you want to keep."`
+	KeepRejects        bool            `sconf:"optional" sconf-doc:"Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training."`
+	RetentionRules     []RetentionRule `sconf:"optional" sconf-doc:"Rules for automatically expunging old messages from mailboxes, or moving them to another mailbox. Rules are applied periodically in the background, in order. For example to remove messages from Trash and Junk after 30 days, or to move messages older than a year from Inbox to Archive. Expunged messages are removed from the junk filter training."`
+	KeepDeletedPeriod  time.Duration   `sconf:"optional" sconf-doc:"Period to keep messages after they are expunged, e.g. by emptying the Trash mailbox, so they can be recovered through webmail, the account web page or the \"mox recoverdeleted\" command. E.g. 720h for 30 days. Kept messages do not count toward the disk usage quota. If zero, the default, message files are removed immediately. Messages are not kept when they are expunged from the Rejects mailbox or moved to another account."`
+	CompressMessages   bool            `sconf:"optional" sconf-doc:"Store message files of newly delivered messages compressed. Compressed files are read transparently, so compressed and uncompressed messages can be mixed. Use \"mox compressmessages\" to compress the message files of existing messages. Message sizes, e.g. for the disk usage quota, are always of the uncompressed message."`
+	EncryptMessages    bool            `sconf:"optional" sconf-doc:"Store message files of newly delivered messages encrypted with a key pair for the account. The private key is stored in the account database, sealed with a key derived from the account password. The key pair is created at the first login with password, or when the password is set. Messages can be delivered while the user is logged out. Reading encrypted messages is only possible after a login with password or app password (not with SCRAM or CRAM-MD5 authentication), until the key hasn't been used for 24 hours or mox restarts. The password can only be changed, and app passwords only added, while the key is unlocked. App passwords added before the key was created cannot unlock it. Encrypted messages cannot be read anymore if the password is lost. Use \"mox encryptmessages\" to encrypt the message files of existing messages. Only message files are encrypted: the account database, with message metadata such as subjects and addresses, and the word statistics of the junk filter, are not encrypted. To keep message text out of the database, accounts with encrypted messages have no word index, no text extracted from attachments and no cached previews, so searches read all message files. Text stored before enabling encryption is removed by \"mox encryptmessages\", but may remain in unused parts of the database file. Messages whose contents happen to look like an encrypted file are stored as regular messages, whether a file is encrypted is recorded in the database."`
 	AutomaticJunkFlags struct {
 		Enabled              bool   `sconf-doc:"If enabled, flags will be set automatically if they match a regular expression below. When two of the three mailbox regular expressions are set, the remaining one will match all unmatched messages. Messages are matched in the order specified and the search stops on the first match. Mailboxes are lowercased before matching."`
 		JunkMailboxRegexp    string `sconf:"optional" sconf-doc:"Example: ^(junk|spam)."`
@@ -369,6 +422,15 @@ type Account struct {
 	NotJunkMailbox *regexp.Regexp `sconf:"-" json:"-"`
 }
 
+// RetentionRule expunges or moves old messages in a mailbox.
+type RetentionRule struct {
+	Mailbox          string        `sconf:"optional" sconf-doc:"Mailbox to apply the rule to, e.g. Inbox. Exactly one of Mailbox and SpecialUse must be set."`
+	SpecialUse       string        `sconf:"optional" sconf-doc:"Special-use flag of the mailbox to apply the rule to, instead of a mailbox name. One of: Archive, Drafts, Junk, Sent, Trash."`
+	Age              time.Duration `sconf-doc:"Messages added to the mailbox longer ago are expunged or moved, e.g. 720h for 30 days. The time a message was added to the mailbox (its save date) is used, or the time it was received for messages without save date."`
+	MoveToMailbox    string        `sconf:"optional" sconf-doc:"If set, old messages are moved to this mailbox instead of expunged. At most one of MoveToMailbox and MoveToSpecialUse can be set."`
+	MoveToSpecialUse string        `sconf:"optional" sconf-doc:"If set, old messages are moved to the mailbox with this special-use flag instead of expunged, e.g. Archive."`
+}
+
 type JunkFilter struct {
 	Threshold float64 `sconf-doc:"Approximate spaminess score between 0 and 1 above which emails are rejected as spam. Each delivery attempt adds a little noise to make it slightly harder for spammers to identify words that strongly indicate non-spaminess and use it to bypass the filter. E.g. 0.95."`
 	junk.Params
@@ -379,6 +441,9 @@ type Destination struct {
 	Rulesets []Ruleset `sconf:"optional" sconf-doc:"Delivery rules based on message and SMTP transaction. You may want to match each mailing list by SMTP MailFrom address, VerifiedDomain and/or List-ID header (typically <listname.example.org> if the list address is listname@example.org), delivering them to their own mailbox."`
 	FullName string    `sconf:"optional" sconf-doc:"Full name to use in message From header when composing messages coming from this address with webmail."`
 
+	ForwardTo       []string `sconf:"optional" sconf-doc:"Forward incoming messages to these (typically external) addresses. The envelope sender (SMTP MAIL FROM) of forwarded messages is rewritten with the Sender Rewriting Scheme (SRS), using the domain of this address, so SPF checks at the receiving mail server pass, and delivery status notifications (bounces) are routed back to the original sender. Messages rejected by spam filtering are not forwarded. DMARC and TLS reports are not forwarded."`
+	ForwardKeepCopy bool     `sconf:"optional" sconf-doc:"If set, forwarded messages are also delivered to the account, as without ForwardTo. Otherwise no copy is kept."`
+
 	DMARCReports     bool `sconf:"-" json:"-"`
 	HostTLSReports   bool `sconf:"-" json:"-"`
 	DomainTLSReports bool `sconf:"-" json:"-"`
@@ -386,9 +451,14 @@ type Destination struct {
 
 // Equal returns whether d and o are equal, only looking at their user-changeable fields.
 func (d Destination) Equal(o Destination) bool {
-	if d.Mailbox != o.Mailbox || len(d.Rulesets) != len(o.Rulesets) {
+	if d.Mailbox != o.Mailbox || len(d.Rulesets) != len(o.Rulesets) || len(d.ForwardTo) != len(o.ForwardTo) || d.ForwardKeepCopy != o.ForwardKeepCopy {
 		return false
 	}
+	for i, s := range d.ForwardTo {
+		if s != o.ForwardTo[i] {
+			return false
+		}
+	}
 	for i, rs := range d.Rulesets {
 		if !rs.Equal(o.Rulesets[i]) {
 			return false
@@ -400,6 +470,7 @@ func (d Destination) Equal(o Destination) bool {
 type Ruleset struct {
 	SMTPMailFromRegexp string            `sconf:"optional" sconf-doc:"Matches if this regular expression matches (a substring of) the SMTP MAIL FROM address (not the message From-header). E.g. '^user@example\\.org$'."`
 	VerifiedDomain     string            `sconf:"optional" sconf-doc:"Matches if this domain matches an SPF- and/or DKIM-verified (sub)domain."`
+	SubaddressRegexp   string            `sconf:"optional" sconf-doc:"Matches if this regular expression matches (a substring of) the subaddress of the recipient address, i.e. the part after the LocalpartCatchallSeparator of the domain, converted to lower case. E.g. '^shop$' for you+shop@example.com. An address without subaddress has an empty subaddress, so '^$' matches messages to the plain address."`
 	HeadersRegexp      map[string]string `sconf:"optional" sconf-doc:"Matches if these header field/value regular expressions all match (substrings of) the message headers. Header fields and valuees are converted to lower case before matching. Whitespace is trimmed from the value before matching. A header field can occur multiple times in a message, only one instance has to match. For mailing lists, you could match on ^list-id$ with the value typically the mailing list address in angled brackets with @ replaced with a dot, e.g. <name\\.lists\\.example\\.org>."`
 	// todo: add a SMTPRcptTo check, and MessageFrom that works on a properly parsed From header.
 
@@ -411,6 +482,7 @@ type Ruleset struct {
 	Mailbox string `sconf-doc:"Mailbox to deliver to if this ruleset matches."`
 
 	SMTPMailFromRegexpCompiled *regexp.Regexp      `sconf:"-" json:"-"`
+	SubaddressRegexpCompiled   *regexp.Regexp      `sconf:"-" json:"-"`
 	VerifiedDNSDomain          dns.Domain          `sconf:"-"`
 	HeadersRegexpCompiled      [][2]*regexp.Regexp `sconf:"-" json:"-"`
 	ListAllowDNSDomain         dns.Domain          `sconf:"-"`
@@ -418,7 +490,7 @@ type Ruleset struct {
 
 // Equal returns whether r and o are equal, only looking at their user-changeable fields.
 func (r Ruleset) Equal(o Ruleset) bool {
-	if r.SMTPMailFromRegexp != o.SMTPMailFromRegexp || r.VerifiedDomain != o.VerifiedDomain || r.IsForward != o.IsForward || r.ListAllowDomain != o.ListAllowDomain || r.AcceptRejectsToMailbox != o.AcceptRejectsToMailbox || r.Mailbox != o.Mailbox {
+	if r.SMTPMailFromRegexp != o.SMTPMailFromRegexp || r.SubaddressRegexp != o.SubaddressRegexp || r.VerifiedDomain != o.VerifiedDomain || r.IsForward != o.IsForward || r.ListAllowDomain != o.ListAllowDomain || r.AcceptRejectsToMailbox != o.AcceptRejectsToMailbox || r.Mailbox != o.Mailbox {
 		return false
 	}
 	if !reflect.DeepEqual(r.HeadersRegexp, o.HeadersRegexp) {
@@ -291,6 +291,18 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# Default 993. (optional)
 				Port: 0
 
+			# ManageSieve for managing Sieve filtering scripts of accounts, e.g. by email
+			# applications. Starts out in plain text, can be upgraded to TLS with the STARTTLS
+			# command. (optional)
+			ManageSieve:
+				Enabled: false
+
+				# Default 4190. (optional)
+				Port: 0
+
+				# Allow authentication without TLS. (optional)
+				NoRequireSTARTTLS: false
+
 			# Account web interface, for email users wanting to change their accounts, e.g.
 			# set new password, set new delivery rulesets. Default path is /. (optional)
 			AccountHTTP:
@@ -429,6 +441,23 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# useful when the mta-sts domain is reverse proxied. (optional)
 				NonTLS: false
 
+			# Serve one-click unsubscribe requests for hosted mailing lists at
+			# /mlist/unsubscribe/ on the hostname of the listener, as linked to from the
+			# List-Unsubscribe header in messages sent to subscribers. Requires a TLS config.
+			# If not enabled, messages only have an email address for unsubscribing.
+			# (optional)
+			MailingListHTTPS:
+				Enabled: false
+
+				# TLS port, 443 by default. URLs in List-Unsubscribe headers don't include a port,
+				# you'll have to add an external mechanism to get connections on port 443 here if
+				# you override it, or configure NonTLS. (optional)
+				Port: 0
+
+				# If set, plain HTTP instead of HTTPS is spoken on the configured port. Can be
+				# useful when the hostname is reverse proxied. (optional)
+				NonTLS: false
+
 			# All configured WebHandlers will serve on an enabled listener. (optional)
 			WebserverHTTP:
 				Enabled: false
@@ -666,6 +695,12 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 	# (optional)
 	QuotaMessageSize: 0
 
+	# Default maximum number of messages for each individual account, only applicable
+	# if greater than zero. Can be overridden per account. Attempting to add new
+	# messages to an account beyond its maximum number of messages will result in an
+	# error. Messages kept after being expunged do not count. (optional)
+	QuotaMessageCount: 0
+
 # domains.conf
 
 	# NOTE: This config file is in 'sconf' format. Indent with tabs. Comments must be
@@ -833,6 +868,102 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					MinimumAttempts: 0
 					Transport:
 
+			# Aliases for distributing incoming messages to multiple addresses, e.g. a team@
+			# address. Keys are the localparts of the alias addresses in this domain, in
+			# canonical form: lower-case unless LocalpartCaseSensitive is set, and without the
+			# LocalpartCatchallSeparator. Alias addresses cannot also be account addresses.
+			# (optional)
+			Aliases:
+				x:
+
+					# Addresses of the members of the alias. Messages for the alias are delivered to
+					# local addresses, which must be account addresses, as if sent to them directly.
+					# Messages are forwarded to external addresses, with the envelope sender rewritten
+					# with SRS. Spam filtering for external addresses is done with the account of the
+					# first local address, so at least one local address is required if there are
+					# external addresses.
+					Addresses:
+						-
+
+					# If set, anyone can send messages to the alias. Otherwise, only members and the
+					# addresses in AllowMsgFrom can send messages, as verified through the message
+					# From header with DKIM and/or SPF alignment, like DMARC. (optional)
+					PostPublic: false
+
+					# Additional addresses, besides members, that can send messages to the alias if
+					# PostPublic is not set, e.g. an address of a ticket system. (optional)
+					AllowMsgFrom:
+						-
+
+			# If set, messages to an address with a subaddress (the detail after the
+			# LocalpartCatchallSeparator, e.g. "shop" in you+shop@example.com) are delivered
+			# to a mailbox named after the detail, created automatically under a parent
+			# mailbox. Only applies to messages that don't match a ruleset of the destination.
+			# Requires LocalpartCatchallSeparator. (optional)
+			SubaddressMailboxes:
+
+				# Mailbox under which mailboxes for subaddresses are created. Default: Plus.
+				# (optional)
+				Parent:
+
+				# Only subaddresses matching (a substring of) one of these regular expressions are
+				# delivered to their own mailbox. At least one is required, so senders cannot
+				# create mailboxes for arbitrary subaddresses unless explicitly allowed, e.g. with
+				# '.'. Subaddresses are converted to lower case before matching. E.g.
+				# '^(shop|travel)$'.
+				AllowRegexp:
+					-
+
+				# Maximum number of mailboxes under the parent mailbox of an account. When
+				# reached, messages for subaddresses without an existing mailbox are delivered as
+				# if this option was not set. Default: 100. (optional)
+				MaxMailboxes: 0
+
+			# Mailing lists hosted for this domain. Keys are the localparts of the list
+			# addresses, in canonical form like for Aliases. Messages to the list address are
+			# sent to all subscribers. People subscribe by sending a message to the
+			# <list>-subscribe address and replying to the confirmation request, and
+			# unsubscribe through the <list>-unsubscribe address or the link in the
+			# List-Unsubscribe header. Messages to <list>-owner are forwarded to the owners.
+			# Subscribers are stored in the mailing list database, not in this file.
+			# (optional)
+			MailingLists:
+				x:
+
+					# Short description of the list, used in the List-Id header and in messages about
+					# subscriptions. (optional)
+					Description:
+
+					# Addresses of the owners of the list. Messages to the <list>-owner address are
+					# forwarded to them. Owners can always post to the list. At least one owner must
+					# be a local account address: posts are checked for spam and delivery rates with
+					# the account of the first local owner, like messages for that account, but not
+					# stored in it.
+					Owners:
+						-
+
+					# Who can post messages to the list: subscribers (default), owners (for
+					# announcement lists), or anyone. Except for anyone, the message From header must
+					# be verified through DKIM and/or SPF alignment, like DMARC. (optional)
+					PostPolicy:
+
+					# If set, the From header of posts from domains with a DMARC policy of reject or
+					# quarantine is rewritten to the list address, with the name of the original
+					# sender. The original address is added as Reply-To header, if not present.
+					# Without rewriting, subscribers may reject such posts when the DKIM signature of
+					# the sender no longer verifies, e.g. due to modifications made by mail servers in
+					# between, because SPF fails for the server of the list. (optional)
+					FromRewrite: false
+
+			# Accounts that can administer this domain through the admin web interface,
+			# logging in with an email address and password of the account. Domain
+			# administrators can manage accounts, addresses and aliases of their domains, and
+			# view their DNS records and reports, but cannot change server-wide settings. An
+			# account can only be managed if its default domain and the domains of all its
+			# addresses are administered by the domain administrator. (optional)
+			Admins:
+				-
+
 	# Accounts to which email can be delivered. An account can accept email for
 	# multiple domains, for multiple localparts, and deliver to multiple mailboxes.
 	Accounts:
@@ -875,6 +1006,13 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 							# (optional)
 							VerifiedDomain:
 
+							# Matches if this regular expression matches (a substring of) the subaddress of
+							# the recipient address, i.e. the part after the LocalpartCatchallSeparator of the
+							# domain, converted to lower case. E.g. '^shop$' for you+shop@example.com. An
+							# address without subaddress has an empty subaddress, so '^$' matches messages to
+							# the plain address. (optional)
+							SubaddressRegexp:
+
 							# Matches if these header field/value regular expressions all match (substrings
 							# of) the message headers. Header fields and valuees are converted to lower case
 							# before matching. Whitespace is trimmed from the value before matching. A header
@@ -925,6 +1063,19 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					# address with webmail. (optional)
 					FullName:
 
+					# Forward incoming messages to these (typically external) addresses. The envelope
+					# sender (SMTP MAIL FROM) of forwarded messages is rewritten with the Sender
+					# Rewriting Scheme (SRS), using the domain of this address, so SPF checks at the
+					# receiving mail server pass, and delivery status notifications (bounces) are
+					# routed back to the original sender. Messages rejected by spam filtering are not
+					# forwarded. DMARC and TLS reports are not forwarded. (optional)
+					ForwardTo:
+						-
+
+					# If set, forwarded messages are also delivered to the account, as without
+					# ForwardTo. Otherwise no copy is kept. (optional)
+					ForwardKeepCopy: false
+
 			# If configured, messages classified as weakly spam are rejected with instructions
 			# to retry delivery, but this time with a signed token added to the subject.
 			# During the next delivery attempt, the signed token will bypass the spam filter.
@@ -942,6 +1093,12 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			# Useful to prevent a single account from filling storage. (optional)
 			QuotaMessageSize: 0
 
+			# Maximum number of messages for the account, overriding any globally configured
+			# default maximum if non-zero. A negative value can be used to have no limit in
+			# case there is a limit by default. Attempting to add new messages to an account
+			# beyond its maximum number of messages will result in an error. (optional)
+			QuotaMessageCount: 0
+
 			# Mail that looks like spam will be rejected, but a copy can be stored temporarily
 			# in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can
 			# look there. The mail still isn't accepted, so the remote mail server may retry
@@ -954,6 +1111,72 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			# useful, e.g. for future spam training. (optional)
 			KeepRejects: false
 
+			# Rules for automatically expunging old messages from mailboxes, or moving them to
+			# another mailbox. Rules are applied periodically in the background, in order. For
+			# example to remove messages from Trash and Junk after 30 days, or to move
+			# messages older than a year from Inbox to Archive. Expunged messages are removed
+			# from the junk filter training. (optional)
+			RetentionRules:
+				-
+
+					# Mailbox to apply the rule to, e.g. Inbox. Exactly one of Mailbox and SpecialUse
+					# must be set. (optional)
+					Mailbox:
+
+					# Special-use flag of the mailbox to apply the rule to, instead of a mailbox name.
+					# One of: Archive, Drafts, Junk, Sent, Trash. (optional)
+					SpecialUse:
+
+					# Messages added to the mailbox longer ago are expunged or moved, e.g. 720h for 30
+					# days. The time a message was added to the mailbox (its save date) is used, or
+					# the time it was received for messages without save date.
+					Age: 0s
+
+					# If set, old messages are moved to this mailbox instead of expunged. At most one
+					# of MoveToMailbox and MoveToSpecialUse can be set. (optional)
+					MoveToMailbox:
+
+					# If set, old messages are moved to the mailbox with this special-use flag instead
+					# of expunged, e.g. Archive. (optional)
+					MoveToSpecialUse:
+
+			# Period to keep messages after they are expunged, e.g. by emptying the Trash
+			# mailbox, so they can be recovered through webmail, the account web page or the
+			# "mox recoverdeleted" command. E.g. 720h for 30 days. Kept messages do not count
+			# toward the disk usage quota. If zero, the default, message files are removed
+			# immediately. Messages are not kept when they are expunged from the Rejects
+			# mailbox or moved to another account. (optional)
+			KeepDeletedPeriod: 0s
+
+			# Store message files of newly delivered messages compressed. Compressed files are
+			# read transparently, so compressed and uncompressed messages can be mixed. Use
+			# "mox compressmessages" to compress the message files of existing messages.
+			# Message sizes, e.g. for the disk usage quota, are always of the uncompressed
+			# message. (optional)
+			CompressMessages: false
+
+			# Store message files of newly delivered messages encrypted with a key pair for
+			# the account. The private key is stored in the account database, sealed with a
+			# key derived from the account password. The key pair is created at the first
+			# login with password, or when the password is set. Messages can be delivered
+			# while the user is logged out. Reading encrypted messages is only possible after
+			# a login with password or app password (not with SCRAM or CRAM-MD5
+			# authentication), until the key hasn't been used for 24 hours or mox restarts.
+			# The password can only be changed, and app passwords only added, while the key is
+			# unlocked. App passwords added before the key was created cannot unlock it.
+			# Encrypted messages cannot be read anymore if the password is lost. Use "mox
+			# encryptmessages" to encrypt the message files of existing messages. Only message
+			# files are encrypted: the account database, with message metadata such as
+			# subjects and addresses, and the word statistics of the junk filter, are not
+			# encrypted. To keep message text out of the database, accounts with encrypted
+			# messages have no word index, no text extracted from attachments and no cached
+			# previews, so searches read all message files. Text stored before enabling
+			# encryption is removed by "mox encryptmessages", but may remain in unused parts
+			# of the database file. Messages whose contents happen to look like an encrypted
+			# file are stored as regular messages, whether a file is encrypted is recorded in
+			# the database. (optional)
+			EncryptMessages: false
+
 			# Automatically set $Junk and $NotJunk flags based on mailbox messages are
 			# delivered/moved/copied to. Email clients typically have too limited
 			# functionality to conveniently set these flags, especially $NonJunk, but they can
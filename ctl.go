@@ -333,6 +333,8 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 			Received: time.Now(),
 			Size:     mw.Size,
 		}
+		err = a.PrepareAttachmentText(log, m, msgFile)
+		ctl.xcheck(err, "preparing message")
 
 		a.WithWLock(func() {
 			err := a.DeliverDestination(log, addr, m, msgFile)
@@ -371,6 +373,31 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 		acc = nil
 		ctl.xwriteok()
 
+	case "resettotp":
+		/* protocol:
+		> "resettotp"
+		> account
+		< "ok" or error
+		*/
+
+		account := ctl.xread()
+
+		acc, err := store.OpenAccount(ctl.log, account)
+		ctl.xcheck(err, "open account")
+		defer func() {
+			if acc != nil {
+				err := acc.Close()
+				log.Check(err, "closing account after resetting two-factor authentication")
+			}
+		}()
+
+		err = acc.TOTPReset(ctx, ctl.log)
+		ctl.xcheck(err, "resetting two-factor authentication")
+		err = acc.Close()
+		ctl.xcheck(err, "closing account")
+		acc = nil
+		ctl.xwriteok()
+
 	case "queue":
 		/* protocol:
 		> "queue"
@@ -662,6 +689,51 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 		})
 		ctl.xwriteok()
 
+	case "recoverdeleted":
+		/* protocol:
+		> "recoverdeleted"
+		> account
+		> message ids separated by space, or empty for listing
+		< "ok" or error
+		< stream
+		*/
+		account := ctl.xread()
+		idsOpt := ctl.xread()
+		var ids []int64
+		for _, s := range strings.Fields(idsOpt) {
+			id, err := strconv.ParseInt(s, 10, 64)
+			ctl.xcheck(err, "parsing message id")
+			ids = append(ids, id)
+		}
+
+		acc, err := store.OpenAccount(ctl.log, account)
+		ctl.xcheck(err, "open account")
+		defer func() {
+			err := acc.Close()
+			log.Check(err, "closing account after recovering deleted messages")
+		}()
+
+		if len(ids) == 0 {
+			l, err := acc.DeletedList(ctx)
+			ctl.xcheck(err, "listing deleted messages")
+			ctl.xwriteok()
+			w := ctl.writer()
+			for _, dm := range l {
+				_, err := fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", dm.ID, dm.Expunged.Format(time.RFC3339), dm.MailboxName, dm.From, dm.Subject)
+				ctl.xcheck(err, "write")
+			}
+			w.xclose()
+			break
+		}
+
+		recovered, err := acc.DeletedRecover(ctx, ctl.log, ids)
+		ctl.xcheck(err, "recovering deleted messages")
+		ctl.xwriteok()
+		w := ctl.writer()
+		_, err = fmt.Fprintf(w, "Recovered %d message(s).\n", len(recovered))
+		ctl.xcheck(err, "write")
+		w.xclose()
+
 	case "recalculatemailboxcounts":
 		/* protocol:
 		> "recalculatemailboxcounts"
@@ -772,7 +844,7 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 							n++
 
 							p := acc.MessagePath(m.ID)
-							st, err := os.Stat(p)
+							filesize, err := store.MessageFileSize(p, m.FileCompressed, m.FileEncrypted)
 							if err != nil {
 								mb := store.Mailbox{ID: m.MailboxID}
 								if xerr := tx.Get(&mb); xerr != nil {
@@ -783,7 +855,6 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 								ctl.xcheck(werr, "write")
 								return nil
 							}
-							filesize := st.Size()
 							correctSize := int64(len(m.MsgPrefix)) + filesize
 							if m.Size == correctSize {
 								return nil
@@ -999,6 +1070,137 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 		}
 		w.xclose()
 
+	case "rebuildwordindex":
+		/* protocol:
+		> "rebuildwordindex"
+		> account or empty
+		< "ok" or error
+		< stream
+		*/
+
+		accountOpt := ctl.xread()
+		ctl.xwriteok()
+		w := ctl.writer()
+
+		xrebuildWordIndex := func(accName string) {
+			acc, err := store.OpenAccount(ctl.log, accName)
+			ctl.xcheck(err, "open account")
+			defer func() {
+				err := acc.Close()
+				log.Check(err, "closing account after rebuilding word index")
+			}()
+
+			const batchSize = 1000
+			total, err := acc.WordIndexRebuild(ctx, ctl.log, batchSize, w)
+			ctl.xcheck(err, "rebuilding word index")
+			_, err = fmt.Fprintf(w, "Word index rebuilt with %d message(s).\n", total)
+			ctl.xcheck(err, "write")
+		}
+
+		if accountOpt != "" {
+			xrebuildWordIndex(accountOpt)
+		} else {
+			for i, accName := range mox.Conf.Accounts() {
+				var line string
+				if i > 0 {
+					line = "\n"
+				}
+				_, err := fmt.Fprintf(w, "%sRebuilding word index for account %s...\n", line, accName)
+				ctl.xcheck(err, "write")
+				xrebuildWordIndex(accName)
+			}
+		}
+		w.xclose()
+
+	case "compressmessages":
+		/* protocol:
+		> "compressmessages"
+		> account or empty
+		< "ok" or error
+		< stream
+		*/
+
+		accountOpt := ctl.xread()
+		ctl.xwriteok()
+		w := ctl.writer()
+
+		xcompressMessages := func(accName string) {
+			acc, err := store.OpenAccount(ctl.log, accName)
+			ctl.xcheck(err, "open account")
+			defer func() {
+				err := acc.Close()
+				log.Check(err, "closing account after compressing messages")
+			}()
+
+			const batchSize = 100
+			n, size, compressedSize, err := acc.CompressMessages(ctx, ctl.log, batchSize)
+			ctl.xcheck(err, "compressing messages")
+			_, err = fmt.Fprintf(w, "Compressed %d message file(s), from %d to %d bytes.\n", n, size, compressedSize)
+			ctl.xcheck(err, "write")
+		}
+
+		if accountOpt != "" {
+			xcompressMessages(accountOpt)
+		} else {
+			for i, accName := range mox.Conf.Accounts() {
+				var line string
+				if i > 0 {
+					line = "\n"
+				}
+				_, err := fmt.Fprintf(w, "%sCompressing messages for account %s...\n", line, accName)
+				ctl.xcheck(err, "write")
+				xcompressMessages(accName)
+			}
+		}
+		w.xclose()
+
+	case "encryptmessages":
+		/* protocol:
+		> "encryptmessages"
+		> account or empty
+		< "ok" or error
+		< stream
+		*/
+
+		accountOpt := ctl.xread()
+		ctl.xwriteok()
+		w := ctl.writer()
+
+		xencryptMessages := func(accName string) {
+			acc, err := store.OpenAccount(ctl.log, accName)
+			ctl.xcheck(err, "open account")
+			defer func() {
+				err := acc.Close()
+				log.Check(err, "closing account after encrypting messages")
+			}()
+
+			const batchSize = 100
+			n, size, encryptedSize, err := acc.EncryptMessages(ctx, ctl.log, batchSize)
+			ctl.xcheck(err, "encrypting messages")
+			_, err = fmt.Fprintf(w, "Encrypted %d message file(s), from %d to %d bytes.\n", n, size, encryptedSize)
+			ctl.xcheck(err, "write")
+		}
+
+		if accountOpt != "" {
+			xencryptMessages(accountOpt)
+		} else {
+			var i int
+			for _, accName := range mox.Conf.Accounts() {
+				if accConf, ok := mox.Conf.Account(accName); !ok || !accConf.EncryptMessages {
+					continue
+				}
+				var line string
+				if i > 0 {
+					line = "\n"
+				}
+				i++
+				_, err := fmt.Fprintf(w, "%sEncrypting messages for account %s...\n", line, accName)
+				ctl.xcheck(err, "write")
+				xencryptMessages(accName)
+			}
+		}
+		w.xclose()
+
 	case "backup":
 		backupctl(ctx, ctl)
 
@@ -12,6 +12,7 @@ import (
 
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlist"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/mtastsdb"
@@ -64,6 +65,11 @@ func TestCtl(t *testing.T) {
 		ctlcmdSetaccountpassword(ctl, "mjl", "test4321")
 	})
 
+	// "resettotp"
+	testctl(func(ctl *ctl) {
+		ctlcmdResetTOTP(ctl, "mjl")
+	})
+
 	err := queue.Init()
 	tcheck(t, err, "queue init")
 
@@ -161,6 +167,35 @@ func TestCtl(t *testing.T) {
 		ctlcmdRecalculateMailboxCounts(ctl, "mjl")
 	})
 
+	// The commands below run before "fixmsgsize", which leaves the account in a
+	// state the consistency check on account close panics on.
+
+	// "rebuildwordindex"
+	testctl(func(ctl *ctl) {
+		ctlcmdRebuildWordIndex(ctl, "mjl")
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdRebuildWordIndex(ctl, "")
+	})
+
+	// "compressmessages"
+	testctl(func(ctl *ctl) {
+		ctlcmdCompressMessages(ctl, "mjl")
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdCompressMessages(ctl, "")
+	})
+
+	// "encryptmessages", no accounts with encryption enabled.
+	testctl(func(ctl *ctl) {
+		ctlcmdEncryptMessages(ctl, "")
+	})
+
+	// "recoverdeleted"
+	testctl(func(ctl *ctl) {
+		ctlcmdRecoverDeleted(ctl, "mjl", "")
+	})
+
 	// "fixmsgsize"
 	testctl(func(ctl *ctl) {
 		ctlcmdFixmsgsize(ctl, "mjl")
@@ -232,6 +267,8 @@ func TestCtl(t *testing.T) {
 	tcheck(t, err, "mtastsdb init")
 	err = tlsrptdb.Init()
 	tcheck(t, err, "tlsrptdb init")
+	err = mlist.Init()
+	tcheck(t, err, "mlist init")
 	testctl(func(ctl *ctl) {
 		os.RemoveAll("testdata/ctl/data/tmp/backup-data")
 		err := os.WriteFile("testdata/ctl/data/receivedid.key", make([]byte, 16), 0600)
@@ -23,6 +23,7 @@ any parameters. Followed by the help and usage information for each command.
 	mox quickstart [-existing-webserver] [-hostname host] user@domain [user | uid]
 	mox stop
 	mox setaccountpassword account
+	mox resettotp account
 	mox setadminpassword
 	mox loglevels [level [pkg]]
 	mox queue list
@@ -73,6 +74,7 @@ any parameters. Followed by the help and usage information for each command.
 	mox dnsbl checkhealth zone
 	mox mtasts lookup domain
 	mox retrain accountname
+	mox recoverdeleted account [messageid ...]
 	mox sendmail [-Fname] [ignoredflags] [-t] [<message]
 	mox spf check domain ip
 	mox spf lookup domain
@@ -89,6 +91,9 @@ any parameters. Followed by the help and usage information for each command.
 	mox recalculatemailboxcounts account
 	mox message parse message.eml
 	mox reassignthreads [account]
+	mox rebuildwordindex [account]
+	mox compressmessages [account]
+	mox encryptmessages [account]
 
 # mox serve
 
@@ -171,6 +176,19 @@ for an account.
 
 	usage: mox setaccountpassword account
 
+# mox resettotp
+
+Disable two-factor authentication for an account.
+
+For an account that lost access to its authenticator app and recovery codes.
+After the reset, logins to the account and webmail interfaces only require the
+password. The account can enable two-factor authentication again.
+
+To disable two-factor authentication for the admin, remove the file
+"adminpasswd.totp" in the config directory.
+
+	usage: mox resettotp account
+
 # mox setadminpassword
 
 Set a new admin password, for the web interface.
@@ -178,6 +196,11 @@ Set a new admin password, for the web interface.
 The password is read from stdin. Its bcrypt hash is stored in a file named
 "adminpasswd" in the configuration directory.
 
+Two-factor authentication for the admin, if enabled in the web interface, is
+stored in "adminpasswd.totp". Remove that file to disable two-factor
+authentication, e.g. after losing both the authenticator app and the recovery
+codes.
+
 	usage: mox setadminpassword
 
 # mox loglevels
@@ -380,14 +403,19 @@ These files can then be stored elsewhere for long-term storage, or used to fall
 back to should an upgrade fail. Simply copying files in the data directory
 while mox is running can result in unusable database files.
 
-Message files never change (they are read-only, though can be removed) and are
-hard-linked so they don't consume additional space. If hardlinking fails, for
-example when the backup destination directory is on a different file system, a
-regular copy is made. Using a destination directory like "data/tmp/backup"
+Message files never change (they are read-only, though can be removed, or
+replaced with a compressed or encrypted version) and are hard-linked so they
+don't consume additional space. If hardlinking fails, for example when the
+backup destination directory is on a different file system, a regular copy is
+made. Using a destination directory like "data/tmp/backup"
 increases the odds hardlinking succeeds: the default systemd service file
 specifically mounts the data directory, causing attempts to hardlink outside it
 to fail with an error about cross-device linking.
 
+Compressed and encrypted message files are backed up as is. Encrypted messages
+of a restored backup can be read after logging in with the account password at
+the time of the backup.
+
 All files in the data directory that aren't recognized (i.e. other than known
 database files, message files, an acme directory, the "tmp" directory, etc),
 are stored, but with a warning.
@@ -837,6 +865,21 @@ implementation has changed.
 
 	usage: mox retrain accountname
 
+# mox recoverdeleted
+
+List or recover deleted messages of an account.
+
+Messages that are expunged, e.g. by emptying the Trash mailbox, are kept for
+the period configured in KeepDeletedPeriod in the account configuration. Until
+then, they can be recovered.
+
+Without message ids, the deleted messages are listed, with their id, time of
+expunge, mailbox, sender and subject. With message ids, those messages are
+recovered: added again as new messages to the mailbox they were expunged from,
+or the Inbox if that mailbox no longer exists.
+
+	usage: mox recoverdeleted account [messageid ...]
+
 # mox sendmail
 
 Sendmail is a drop-in replacement for /usr/sbin/sendmail to deliver emails sent by unix processes like cron.
@@ -1036,6 +1079,64 @@ ancestors. If the direct parent already wasn't available while matching, this is
 stored as the message having a "missing link" to its stored ancestors.
 
 	usage: mox reassignthreads [account]
+
+# mox rebuildwordindex
+
+Rebuild the word index used for full-text search.
+
+For all accounts, or optionally only the specified account.
+
+The word index holds the words in headers and text parts of all messages, so
+searches in the webmail and IMAP don't have to read all message files. New
+messages are added to the index during delivery. Accounts created before the
+word index was introduced get their index built in the background when the
+account is first opened. Until then, and while rebuilding, searches read all
+messages.
+
+Text is extracted again from PDF, OpenDocument, Office Open XML and plain text
+attachments, for searching. Messages delivered before attachment text
+extraction was introduced only have their attachments searchable after a
+rebuild.
+
+Accounts with EncryptMessages enabled don't have a word index.
+
+	usage: mox rebuildwordindex [account]
+
+# mox compressmessages
+
+Compress the message files of existing messages.
+
+For all accounts, or optionally only the specified account.
+
+Message files that are not yet compressed are replaced with a compressed
+version, unless that wouldn't be smaller. Compressed message files are read
+transparently, so the account can be used while its messages are being
+compressed. Only newly delivered messages of accounts with CompressMessages
+enabled in their configuration are compressed automatically.
+
+Message files that were linked to share storage, e.g. for copied messages,
+become separate files when compressed.
+
+	usage: mox compressmessages [account]
+
+# mox encryptmessages
+
+Encrypt the message files of existing messages.
+
+For all accounts with EncryptMessages enabled, or optionally only the specified
+account.
+
+The account must have an encryption key, which is created at the first login
+with password after enabling EncryptMessages in the account configuration. Only
+the public key is needed for encrypting, so the account does not have to be
+unlocked. Message files are also compressed if CompressMessages is enabled.
+Message files that are already encrypted are left as is.
+
+The word index, text extracted from attachments and cached message previews are
+removed from the account database first, they are not kept for accounts with
+encrypted messages.
+
+	usage: mox encryptmessages [account]
 */
 package main
 
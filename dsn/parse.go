@@ -224,6 +224,7 @@ func parseRecipientHeader(mr *textproto.Reader, utf8 bool) (Recipient, error) {
 			if !ok {
 				err = fmt.Errorf("unrecognized action %q", v)
 			}
+			r.Action = a
 		case "Status":
 			// todo: parse the enhanced status code?
 			r.Status = v
@@ -16,6 +16,7 @@ import (
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dmarcrpt"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlist"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxvar"
@@ -220,6 +221,10 @@ Accounts:
 	err = tlsrptdb.AddReport(ctxbg, c.log, dns.Domain{ASCII: "mox.example"}, "tlsrpt@mox.example", false, &tlsr)
 	xcheckf(err, "adding tls report")
 
+	// Create mlist.db.
+	err = mlist.Init()
+	xcheckf(err, "mlist init")
+
 	// Populate queue, with a message.
 	err = queue.Init()
 	xcheckf(err, "queue init")
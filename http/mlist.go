@@ -0,0 +1,83 @@
+package http
+
+import (
+	"errors"
+	htmltemplate "html/template"
+	"net/http"
+	"strings"
+
+	"github.com/mjl-/mox/mlist"
+	"github.com/mjl-/mox/mlog"
+)
+
+var mlistUnsubscribeTemplate = htmltemplate.Must(htmltemplate.New("unsubscribe").Parse(`<!doctype html>
+<html>
+	<head>
+		<meta charset="utf-8" />
+		<meta name="viewport" content="width=device-width, initial-scale=1" />
+		<title>Unsubscribe</title>
+		<style>
+body { font-family: 'ubuntu', 'lato', sans-serif; }
+		</style>
+	</head>
+	<body>
+{{ if .Done }}
+		<p>You have been unsubscribed from mailing list {{ .List }}.</p>
+{{ else }}
+		<form method="POST">
+			<p>Unsubscribe from the mailing list?</p>
+			<button type="submit" name="List-Unsubscribe" value="One-Click">Unsubscribe</button>
+		</form>
+{{ end }}
+	</body>
+</html>
+`))
+
+// mlistUnsubscribeHandle handles one-click unsubscribe for mailing lists, RFC
+// 8058. Mail clients send a POST request with body "List-Unsubscribe=One-Click".
+// A GET request, e.g. when a user opens the URL in a browser, shows a form that
+// must be submitted, so link scanners don't unsubscribe users.
+func mlistUnsubscribeHandle(w http.ResponseWriter, r *http.Request) {
+	log := func() mlog.Log {
+		return pkglog.WithContext(r.Context())
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, "/mlist/unsubscribe/")
+	if token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var args struct {
+		Done bool
+		List string
+	}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if r.FormValue("List-Unsubscribe") != "One-Click" {
+			http.Error(w, "400 - bad request - missing List-Unsubscribe=One-Click", http.StatusBadRequest)
+			return
+		}
+		list, err := mlist.Unsubscribe(r.Context(), log(), token)
+		if errors.Is(err, mlist.ErrUnknownToken) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			log().Errorx("unsubscribing from mailing list", err)
+			http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+			return
+		}
+		args.Done = true
+		args.List = list
+	default:
+		http.Error(w, "405 - method not allowed - get or post required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := mlistUnsubscribeTemplate.Execute(w, args); err != nil {
+		log().Errorx("executing unsubscribe template", err)
+	}
+}
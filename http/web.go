@@ -648,6 +648,18 @@ func Listen() {
 			}
 			srv.Handle("mtasts", mtastsMatch, "/.well-known/mta-sts.txt", safeHeaders(http.HandlerFunc(mtastsPolicyHandle)))
 		}
+		if l.MailingListHTTPS.Enabled {
+			port := config.Port(l.MailingListHTTPS.Port, 443)
+			srv := ensureServe(!l.MailingListHTTPS.NonTLS, port, "mlist-https")
+			hostname := l.HostnameDomain
+			if hostname.IsZero() {
+				hostname = mox.Conf.Static.HostnameDomain
+			}
+			mlistMatch := func(dom dns.Domain) bool {
+				return dom == hostname
+			}
+			srv.Handle("mlist", mlistMatch, "/mlist/unsubscribe/", safeHeaders(http.HandlerFunc(mlistUnsubscribeHandle)))
+		}
 		if l.PprofHTTP.Enabled {
 			// Importing net/http/pprof registers handlers on the default serve mux.
 			port := config.Port(l.PprofHTTP.Port, 8011)
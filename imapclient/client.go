@@ -16,8 +16,10 @@ package imapclient
 
 import (
 	"bufio"
+	"compress/flate"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"reflect"
 	"strings"
@@ -301,3 +303,23 @@ func (c *Conn) Close() error {
 	}
 	return err
 }
+
+// flateConn is a connection with DEFLATE compression, after the COMPRESS command.
+type flateConn struct {
+	net.Conn
+	fr io.Reader
+	fw *flate.Writer
+}
+
+func (c *flateConn) Read(buf []byte) (int, error) {
+	return c.fr.Read(buf)
+}
+
+// Write compresses and flushes buf, so the server sees complete commands.
+func (c *flateConn) Write(buf []byte) (int, error) {
+	n, err := c.fw.Write(buf)
+	if err == nil {
+		err = c.fw.Flush()
+	}
+	return n, err
+}
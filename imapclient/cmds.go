@@ -2,6 +2,7 @@ package imapclient
 
 import (
 	"bufio"
+	"compress/flate"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
@@ -47,6 +48,19 @@ func (c *Conn) Starttls(config *tls.Config) (untagged []Untagged, result Result,
 	return untagged, result, nil
 }
 
+// CompressDeflate enables compression with DEFLATE on the connection with the
+// COMPRESS command.
+func (c *Conn) CompressDeflate() (untagged []Untagged, result Result, rerr error) {
+	defer c.recover(&rerr)
+	untagged, result, rerr = c.Transactf("compress deflate")
+	c.xcheckf(rerr, "compress command")
+	fw, err := flate.NewWriter(c.conn, flate.DefaultCompression)
+	c.xcheckf(err, "new flate writer")
+	c.conn = &flateConn{c.conn, flate.NewReader(c.conn), fw}
+	c.r = bufio.NewReader(c.conn)
+	return untagged, result, nil
+}
+
 // Login authenticates with username and password
 func (c *Conn) Login(username, password string) (untagged []Untagged, result Result, rerr error) {
 	defer c.recover(&rerr)
@@ -212,6 +226,20 @@ func (c *Conn) Status(mailbox string) (untagged []Untagged, result Result, rerr
 	return c.Transactf("status %s", astring(mailbox))
 }
 
+// GetQuotaRoot returns the quota roots for a mailbox in an UntaggedQuotaroot
+// response, followed by UntaggedQuota responses with usage and limits.
+func (c *Conn) GetQuotaRoot(mailbox string) (untagged []Untagged, result Result, rerr error) {
+	defer c.recover(&rerr)
+	return c.Transactf("getquotaroot %s", astring(mailbox))
+}
+
+// GetQuota returns the resource usage and limits for a quota root in an
+// UntaggedQuota response.
+func (c *Conn) GetQuota(root string) (untagged []Untagged, result Result, rerr error) {
+	defer c.recover(&rerr)
+	return c.Transactf("getquota %s", astring(root))
+}
+
 // Append adds message to mailbox with flags and optional receive time.
 func (c *Conn) Append(mailbox string, flags []string, received *time.Time, message []byte) (untagged []Untagged, result Result, rerr error) {
 	defer c.recover(&rerr)
@@ -116,10 +116,11 @@ func (c *Conn) xrespText() RespText {
 
 var knownCodes = stringMap(
 	// Without parameters.
-	"ALERT", "PARSE", "READ-ONLY", "READ-WRITE", "TRYCREATE", "UIDNOTSTICKY", "UNAVAILABLE", "AUTHENTICATIONFAILED", "AUTHORIZATIONFAILED", "EXPIRED", "PRIVACYREQUIRED", "CONTACTADMIN", "NOPERM", "INUSE", "EXPUNGEISSUED", "CORRUPTION", "SERVERBUG", "CLIENTBUG", "CANNOT", "LIMIT", "OVERQUOTA", "ALREADYEXISTS", "NONEXISTENT", "NOTSAVED", "HASCHILDREN", "CLOSED", "UNKNOWN-CTE", "OVERQUOTA",
+	"ALERT", "PARSE", "READ-ONLY", "READ-WRITE", "TRYCREATE", "UIDNOTSTICKY", "UNAVAILABLE", "AUTHENTICATIONFAILED", "AUTHORIZATIONFAILED", "EXPIRED", "PRIVACYREQUIRED", "CONTACTADMIN", "NOPERM", "INUSE", "EXPUNGEISSUED", "CORRUPTION", "SERVERBUG", "CLIENTBUG", "CANNOT", "LIMIT", "OVERQUOTA", "ALREADYEXISTS", "NONEXISTENT", "NOTSAVED", "HASCHILDREN", "CLOSED", "UNKNOWN-CTE", "OVERQUOTA", "NOMODSEQ",
 	// With parameters.
 	"BADCHARSET", "CAPABILITY", "PERMANENTFLAGS", "UIDNEXT", "UIDVALIDITY", "UNSEEN", "APPENDUID", "COPYUID",
 	"HIGHESTMODSEQ", "MODIFIED",
+	"MAILBOXID",
 )
 
 func stringMap(l ...string) map[string]struct{} {
@@ -193,8 +194,13 @@ func (c *Conn) xrespCode() (string, CodeArg) {
 		c.xspace()
 		destUIDValidity := c.xnzuint32()
 		c.xspace()
-		uid := c.xnzuint32()
-		codeArg = CodeAppendUID{destUIDValidity, uid}
+		// With MULTIAPPEND, a set of UIDs. ../rfc/3502
+		uids := c.xuidset()
+		if len(uids) == 1 && uids[0].Last == nil {
+			codeArg = CodeAppendUID{UIDValidity: destUIDValidity, UID: uids[0].First}
+		} else {
+			codeArg = CodeAppendUID{UIDValidity: destUIDValidity, UIDs: uids}
+		}
 	case "COPYUID":
 		c.xspace()
 		destUIDValidity := c.xnzuint32()
@@ -210,10 +216,34 @@ func (c *Conn) xrespCode() (string, CodeArg) {
 		c.xspace()
 		modified := c.xuidset()
 		codeArg = CodeModified(NumSet{Ranges: modified})
+	case "MAILBOXID":
+		// ../rfc/8474
+		c.xspace()
+		c.xtake("(")
+		codeArg = CodeMailboxID(c.xobjectid())
+		c.xtake(")")
 	}
 	return W, codeArg
 }
 
+// ../rfc/8474
+func (c *Conn) xobjectid() string {
+	var s string
+	for {
+		b := c.xbyte()
+		if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '_' || b == '-' {
+			s += string(rune(b))
+			continue
+		}
+		c.unreadbyte()
+		break
+	}
+	if s == "" || len(s) > 255 {
+		c.xerrorf("invalid objectid %q", s)
+	}
+	return s
+}
+
 func (c *Conn) xbyte() byte {
 	b, err := c.readbyte()
 	c.xcheckf(err, "read byte")
@@ -363,13 +393,21 @@ func (c *Conn) xuntagged() Untagged {
 		c.xspace()
 		c.xtake("(")
 		attrs := map[string]int64{}
+		var mailboxID string
 		for !c.take(')') {
-			if len(attrs) > 0 {
+			if len(attrs) > 0 || mailboxID != "" {
 				c.xspace()
 			}
 			s := c.xword()
 			c.xspace()
 			S := strings.ToUpper(s)
+			if S == "MAILBOXID" {
+				// ../rfc/8474
+				c.xtake("(")
+				mailboxID = c.xobjectid()
+				c.xtake(")")
+				continue
+			}
 			var num int64
 			// ../rfc/9051:7059
 			switch S {
@@ -396,6 +434,8 @@ func (c *Conn) xuntagged() Untagged {
 				}
 			case "HIGHESTMODSEQ":
 				num = c.xint64()
+			case "DELETED-STORAGE":
+				num = c.xint64()
 			default:
 				c.xerrorf("status: unknown attribute %q", s)
 			}
@@ -404,7 +444,7 @@ func (c *Conn) xuntagged() Untagged {
 			}
 			attrs[S] = num
 		}
-		r := UntaggedStatus{mailbox, attrs}
+		r := UntaggedStatus{mailbox, attrs, mailboxID}
 		c.xcrlf()
 		return r
 
@@ -445,6 +485,36 @@ func (c *Conn) xuntagged() Untagged {
 		c.xcrlf()
 		return r
 
+	case "SORT":
+		// ../rfc/5256
+		var nums []uint32
+		for c.take(' ') {
+			// ../rfc/7162:2557
+			if c.take('(') {
+				c.xtake("MODSEQ")
+				c.xspace()
+				modseq := c.xint64()
+				c.xtake(")")
+				c.xcrlf()
+				return UntaggedSortModSeq{nums, modseq}
+			}
+			nums = append(nums, c.xnzuint32())
+		}
+		r := UntaggedSort(nums)
+		c.xcrlf()
+		return r
+
+	case "THREAD":
+		// ../rfc/5256
+		var r UntaggedThread
+		if c.take(' ') {
+			for c.peek('(') {
+				r = append(r, c.xthreadList())
+			}
+		}
+		c.xcrlf()
+		return r
+
 	case "LSUB":
 		c.xneedDisabled("untagged LSUB response", CapIMAP4rev2)
 		r := c.xlsub()
@@ -475,6 +545,108 @@ func (c *Conn) xuntagged() Untagged {
 		c.xcrlf()
 		return UntaggedID(params)
 
+	// ../rfc/9208
+	case "QUOTAROOT":
+		c.xspace()
+		mailbox := c.xastring()
+		var roots []string
+		for c.take(' ') {
+			roots = append(roots, c.xastring())
+		}
+		c.xcrlf()
+		return UntaggedQuotaroot{mailbox, roots}
+
+	// ../rfc/9208
+	case "QUOTA":
+		c.xspace()
+		root := c.xastring()
+		c.xspace()
+		c.xtake("(")
+		var resources []QuotaResource
+		for !c.take(')') {
+			if len(resources) > 0 {
+				c.xspace()
+			}
+			name := strings.ToUpper(c.xatom())
+			c.xspace()
+			usage := c.xint64()
+			c.xspace()
+			limit := c.xint64()
+			resources = append(resources, QuotaResource{name, usage, limit})
+		}
+		c.xcrlf()
+		return UntaggedQuota{root, resources}
+
+	// ../rfc/5464
+	case "METADATA":
+		c.xspace()
+		mailbox := c.xastring()
+		c.xspace()
+		if !c.take('(') {
+			// Unsolicited response, only entry names.
+			keys := []string{c.xastring()}
+			for c.take(' ') {
+				keys = append(keys, c.xastring())
+			}
+			c.xcrlf()
+			return UntaggedMetadataKeys{mailbox, keys}
+		}
+		var annotations []Annotation
+		for !c.take(')') {
+			if len(annotations) > 0 {
+				c.xspace()
+			}
+			var a Annotation
+			a.Key = c.xastring()
+			c.xspace()
+			if c.take('~') {
+				a.Value = c.xliteral()
+			} else {
+				a.IsString = true
+				a.Value = []byte(c.xstring())
+			}
+			annotations = append(annotations, a)
+		}
+		c.xcrlf()
+		return UntaggedMetadataAnnotations{mailbox, annotations}
+
+	// ../rfc/4314
+	case "ACL":
+		c.xspace()
+		mailbox := c.xastring()
+		var rights []IdentifierRights
+		for c.take(' ') {
+			id := c.xastring()
+			c.xspace()
+			rights = append(rights, IdentifierRights{id, c.xastring()})
+		}
+		c.xcrlf()
+		return UntaggedACL{mailbox, rights}
+
+	// ../rfc/4314
+	case "LISTRIGHTS":
+		c.xspace()
+		mailbox := c.xastring()
+		c.xspace()
+		identifier := c.xastring()
+		c.xspace()
+		required := c.xastring()
+		var optional []string
+		for c.take(' ') {
+			optional = append(optional, c.xastring())
+		}
+		c.xcrlf()
+		return UntaggedListRights{mailbox, identifier, required, optional}
+
+	// ../rfc/4314
+	case "MYRIGHTS":
+		c.xspace()
+		mailbox := c.xastring()
+		c.xspace()
+		rights := c.xastring()
+		c.xcrlf()
+		return UntaggedMyRights{mailbox, rights}
+
 	// ../rfc/7162:2623
 	case "VANISHED":
 		c.xspace()
@@ -646,6 +818,40 @@ func (c *Conn) xmsgatt1() FetchAttr {
 		modseq := c.xint64()
 		c.xtake(")")
 		return FetchModSeq(modseq)
+
+	case "EMAILID":
+		// ../rfc/8474
+		c.xspace()
+		c.xtake("(")
+		id := c.xobjectid()
+		c.xtake(")")
+		return FetchEmailID(id)
+
+	case "THREADID":
+		// ../rfc/8474
+		c.xspace()
+		if c.peek('n') || c.peek('N') {
+			c.xtake("nil")
+			return FetchThreadID("")
+		}
+		c.xtake("(")
+		id := c.xobjectid()
+		c.xtake(")")
+		return FetchThreadID(id)
+
+	case "PREVIEW":
+		// ../rfc/8970
+		c.xspace()
+		return FetchPreview(c.xnilString())
+
+	case "SAVEDATE":
+		// ../rfc/8514
+		c.xspace()
+		if c.peek('n') || c.peek('N') {
+			c.xtake("nil")
+			return FetchSaveDate("")
+		}
+		return FetchSaveDate(c.xquoted()) // todo: parsed time
 	}
 	c.xerrorf("unknown fetch attribute %q", f)
 	panic("not reached")
@@ -1213,6 +1419,33 @@ func (c *Conn) xesearchResponse() (r UntaggedEsearch) {
 	return
 }
 
+// ../rfc/5256
+func (c *Conn) xthreadList() ThreadNode {
+	c.xtake("(")
+	var n ThreadNode
+	if c.peek('(') {
+		// Nested threads without a common parent.
+		for c.peek('(') {
+			n.Children = append(n.Children, c.xthreadList())
+		}
+	} else {
+		n.Num = c.xnzuint32()
+		cur := &n
+		for c.take(' ') {
+			if c.peek('(') {
+				for c.peek('(') {
+					cur.Children = append(cur.Children, c.xthreadList())
+				}
+				break
+			}
+			cur.Children = []ThreadNode{{Num: c.xnzuint32()}}
+			cur = &cur.Children[0]
+		}
+	}
+	c.xtake(")")
+	return n
+}
+
 // ../rfc/9051:6441
 func (c *Conn) xcharset() string {
 	if c.peek('"') {
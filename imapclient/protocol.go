@@ -10,27 +10,39 @@ import (
 type Capability string
 
 const (
-	CapIMAP4rev1     Capability = "IMAP4rev1"
-	CapIMAP4rev2     Capability = "IMAP4rev2"
-	CapLoginDisabled Capability = "LOGINDISABLED"
-	CapStarttls      Capability = "STARTTLS"
-	CapAuthPlain     Capability = "AUTH=PLAIN"
-	CapLiteralPlus   Capability = "LITERAL+"
-	CapLiteralMinus  Capability = "LITERAL-"
-	CapIdle          Capability = "IDLE"
-	CapNamespace     Capability = "NAMESPACE"
-	CapBinary        Capability = "BINARY"
-	CapUnselect      Capability = "UNSELECT"
-	CapUidplus       Capability = "UIDPLUS"
-	CapEsearch       Capability = "ESEARCH"
-	CapEnable        Capability = "ENABLE"
-	CapSave          Capability = "SAVE"
-	CapListExtended  Capability = "LIST-EXTENDED"
-	CapSpecialUse    Capability = "SPECIAL-USE"
-	CapMove          Capability = "MOVE"
-	CapUTF8Only      Capability = "UTF8=ONLY"
-	CapUTF8Accept    Capability = "UTF8=ACCEPT"
-	CapID            Capability = "ID" // ../rfc/2971:80
+	CapIMAP4rev1       Capability = "IMAP4rev1"
+	CapIMAP4rev2       Capability = "IMAP4rev2"
+	CapLoginDisabled   Capability = "LOGINDISABLED"
+	CapStarttls        Capability = "STARTTLS"
+	CapAuthPlain       Capability = "AUTH=PLAIN"
+	CapLiteralPlus     Capability = "LITERAL+"
+	CapLiteralMinus    Capability = "LITERAL-"
+	CapIdle            Capability = "IDLE"
+	CapNamespace       Capability = "NAMESPACE"
+	CapBinary          Capability = "BINARY"
+	CapUnselect        Capability = "UNSELECT"
+	CapUidplus         Capability = "UIDPLUS"
+	CapEsearch         Capability = "ESEARCH"
+	CapEnable          Capability = "ENABLE"
+	CapSave            Capability = "SAVE"
+	CapListExtended    Capability = "LIST-EXTENDED"
+	CapSpecialUse      Capability = "SPECIAL-USE"
+	CapMove            Capability = "MOVE"
+	CapUTF8Only        Capability = "UTF8=ONLY"
+	CapUTF8Accept      Capability = "UTF8=ACCEPT"
+	CapID              Capability = "ID" // ../rfc/2971:80
+	CapQuota           Capability = "QUOTA"
+	CapNotify          Capability = "NOTIFY"
+	CapMetadata        Capability = "METADATA"
+	CapCompressDeflate Capability = "COMPRESS=DEFLATE"
+	CapObjectID        Capability = "OBJECTID"
+	CapMultiAppend     Capability = "MULTIAPPEND"
+	CapCatenate        Capability = "CATENATE"
+	CapReplace         Capability = "REPLACE"
+	CapPreview         Capability = "PREVIEW"
+	CapSaveDate        Capability = "SAVEDATE"
+	CapACL             Capability = "ACL"
+	CapPartial         Capability = "PARTIAL"
 )
 
 // Status is the tagged final result of a command.
@@ -101,13 +113,18 @@ func (c CodeUint) CodeString() string {
 	return fmt.Sprintf("%s %d", c.Code, c.Num)
 }
 
-// "APPENDUID" response code.
+// "APPENDUID" response code. With MULTIAPPEND and multiple messages, UIDs is set
+// instead of UID.
 type CodeAppendUID struct {
 	UIDValidity uint32
 	UID         uint32
+	UIDs        []NumRange
 }
 
 func (c CodeAppendUID) CodeString() string {
+	if c.UIDs != nil {
+		return fmt.Sprintf("APPENDUID %d %s", c.UIDValidity, NumSet{Ranges: c.UIDs}.String())
+	}
 	return fmt.Sprintf("APPENDUID %d %d", c.UIDValidity, c.UID)
 }
 
@@ -149,6 +166,13 @@ func (c CodeHighestModSeq) CodeString() string {
 	return fmt.Sprintf("HIGHESTMODSEQ %d", c)
 }
 
+// For OBJECTID.
+type CodeMailboxID string
+
+func (c CodeMailboxID) CodeString() string {
+	return fmt.Sprintf("MAILBOXID (%s)", string(c))
+}
+
 // RespText represents a response line minus the leading tag.
 type RespText struct {
 	Code    string  // The first word between [] after the status.
@@ -222,9 +246,28 @@ type UntaggedSearchModSeq struct {
 	Nums   []uint32
 	ModSeq int64
 }
+type UntaggedSort []uint32
+
+// ../rfc/7162:1101
+type UntaggedSortModSeq struct {
+	Nums   []uint32
+	ModSeq int64
+}
+
+// UntaggedThread is a list of threads. ../rfc/5256
+type UntaggedThread []ThreadNode
+
+// ThreadNode is a message in a thread, with its children. Num is a sequence
+// number or UID. Num is 0 for a node that only groups its children.
+type ThreadNode struct {
+	Num      uint32
+	Children []ThreadNode
+}
+
 type UntaggedStatus struct {
-	Mailbox string
-	Attrs   map[string]int64 // Upper case status attributes. ../rfc/9051:7059
+	Mailbox   string
+	Attrs     map[string]int64 // Upper case status attributes. ../rfc/9051:7059
+	MailboxID string           // For OBJECTID, not in Attrs. ../rfc/8474
 }
 type UntaggedNamespace struct {
 	Personal, Other, Shared []NamespaceDescr
@@ -259,6 +302,76 @@ type UntaggedVanished struct {
 
 type UntaggedID map[string]string
 
+// UntaggedQuotaroot lists the quota roots for a mailbox. ../rfc/9208
+type UntaggedQuotaroot struct {
+	Mailbox string
+	Roots   []string
+}
+
+// UntaggedQuota holds the resource usage and limits for a quota root. ../rfc/9208
+type UntaggedQuota struct {
+	Root      string
+	Resources []QuotaResource
+}
+
+// UntaggedMetadataAnnotations holds annotations for a mailbox, as returned by
+// GETMETADATA. An empty mailbox is for server annotations. ../rfc/5464
+type UntaggedMetadataAnnotations struct {
+	Mailbox     string
+	Annotations []Annotation
+}
+
+// UntaggedMetadataKeys holds entry names of changed annotations, sent
+// unsolicited, e.g. with NOTIFY. ../rfc/5464
+type UntaggedMetadataKeys struct {
+	Mailbox string
+	Keys    []string
+}
+
+// UntaggedACL holds the rights of identifiers on a mailbox, as returned by
+// GETACL. ../rfc/4314
+type UntaggedACL struct {
+	Mailbox string
+	Rights  []IdentifierRights
+}
+
+// IdentifierRights are the rights of an identifier, e.g. an account name or
+// "anyone".
+type IdentifierRights struct {
+	Identifier string
+	Rights     string
+}
+
+// UntaggedListRights holds the rights that can be granted to an identifier on a
+// mailbox, as returned by LISTRIGHTS. ../rfc/4314
+type UntaggedListRights struct {
+	Mailbox    string
+	Identifier string
+	Required   string   // Rights always granted.
+	Optional   []string // Groups of rights that can be granted.
+}
+
+// UntaggedMyRights holds the rights of the user on a mailbox. ../rfc/4314
+type UntaggedMyRights struct {
+	Mailbox string
+	Rights  string
+}
+
+// Annotation is a metadata entry with its value.
+type Annotation struct {
+	Key      string
+	IsString bool // Whether the value was a string, otherwise it was binary data in a literal8.
+	Value    []byte
+}
+
+// QuotaResource is a resource with its usage and limit, e.g. STORAGE, in units of
+// 1024 octets.
+type QuotaResource struct {
+	Name  string // Upper case, e.g. STORAGE or MESSAGE.
+	Usage int64
+	Limit int64
+}
+
 // Extended data in an ESEARCH response.
 type EsearchDataExt struct {
 	Tag   string
@@ -490,3 +603,23 @@ func (f FetchUID) Attr() string { return "UID" }
 type FetchModSeq int64
 
 func (f FetchModSeq) Attr() string { return "MODSEQ" }
+
+// "EMAILID" fetch response, for OBJECTID.
+type FetchEmailID string
+
+func (f FetchEmailID) Attr() string { return "EMAILID" }
+
+// "THREADID" fetch response, for OBJECTID. Empty for NIL.
+type FetchThreadID string
+
+func (f FetchThreadID) Attr() string { return "THREADID" }
+
+// "PREVIEW" fetch response. Empty for NIL.
+type FetchPreview string
+
+func (f FetchPreview) Attr() string { return "PREVIEW" }
+
+// "SAVEDATE" fetch response. Empty for NIL.
+type FetchSaveDate string
+
+func (f FetchSaveDate) Attr() string { return "SAVEDATE" }
@@ -0,0 +1,485 @@
+package imapserver
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// Mailboxes of other accounts that are shared with us through an ACL are in the
+// "#Other Users" namespace, as "#Other Users/<account>/<mailbox>". ../rfc/2342
+// ../rfc/4314
+
+// otherUsersName returns the name in the "#Other Users" namespace for a mailbox
+// of another account.
+func otherUsersName(accountName, mailboxName string) string {
+	return store.OtherUsersPrefix + accountName + "/" + mailboxName
+}
+
+// parseOtherUsersName returns the account and mailbox name for a name in the
+// "#Other Users" namespace. The mailbox name can be empty. If name is not in the
+// namespace, ok is false.
+func parseOtherUsersName(name string) (accountName, mailboxName string, ok bool) {
+	if !strings.HasPrefix(name, store.OtherUsersPrefix) {
+		return "", "", false
+	}
+	accountName, mailboxName, _ = strings.Cut(name[len(store.OtherUsersPrefix):], "/")
+	return accountName, mailboxName, true
+}
+
+// xmailboxAccount returns the account of a mailbox in our namespace, and the
+// checked name of the mailbox in that account. For names in the "#Other Users"
+// namespace, the other account is opened and closeAcc must be called by the
+// caller. For our own mailboxes, closeAcc does nothing. The mailbox does not have
+// to exist.
+func (c *conn) xmailboxAccount(name string, allowInbox bool) (acc *store.Account, mailboxName string, closeAcc func()) {
+	accName, mbName, ok := parseOtherUsersName(name)
+	if !ok {
+		return c.account, xcheckmailboxname(name, allowInbox), func() {}
+	}
+	if accName == "" || mbName == "" || accName == c.account.Name {
+		xuserErrorf("%w", store.ErrUnknownMailbox)
+	}
+	mbName = xcheckmailboxname(mbName, true)
+
+	acc, err := store.OpenAccount(c.log, accName)
+	if err == store.ErrAccountUnknown {
+		// Don't reveal whether the account exists.
+		xuserErrorf("%w", store.ErrUnknownMailbox)
+	}
+	xcheckf(err, "open account")
+	return acc, mbName, func() {
+		err := acc.Close()
+		c.xsanity(err, "closing account")
+	}
+}
+
+// xmailboxRights looks up a mailbox of account acc by name, and returns our rights
+// on it. We have all rights on our own mailboxes. Mailboxes of other accounts that
+// aren't shared with us are treated as non-existent.
+func (c *conn) xmailboxRights(tx *bstore.Tx, acc *store.Account, name string, missingErrCode string) (store.Mailbox, store.Rights) {
+	mb, err := acc.MailboxFind(tx, name)
+	xcheckf(err, "finding mailbox")
+	var rights store.Rights
+	if mb != nil {
+		rights, err = acc.MailboxRights(tx, mb.ID, c.account.Name)
+		xcheckf(err, "looking up rights for mailbox")
+	}
+	if rights == "" {
+		xusercodeErrorf(missingErrCode, "%w", store.ErrUnknownMailbox)
+	}
+	return *mb, rights
+}
+
+// xmailboxDst looks up a destination mailbox of account acc for APPEND, COPY,
+// MOVE and REPLACE, and checks the right to insert messages. Only mailboxes of
+// our own account can be created by the client, so TRYCREATE is only returned for
+// those.
+func (c *conn) xmailboxDst(tx *bstore.Tx, acc *store.Account, name string) (store.Mailbox, store.Rights) {
+	code := "TRYCREATE"
+	if acc != c.account {
+		code = ""
+	}
+	mb, rights := c.xmailboxRights(tx, acc, name, code)
+	xcheckRights(rights, "i")
+	return mb, rights
+}
+
+// restrictFlags clears the flags and keywords that rights don't allow setting on
+// messages added to a mailbox. ../rfc/4314
+func restrictFlags(rights store.Rights, flags store.Flags, keywords []string) (store.Flags, []string) {
+	if !rights.Has("w") {
+		flags = store.Flags{Seen: flags.Seen, Deleted: flags.Deleted}
+		keywords = nil
+	}
+	flags.Seen = flags.Seen && rights.Has("s")
+	flags.Deleted = flags.Deleted && rights.Has("t")
+	return flags, keywords
+}
+
+// xselectedRights returns our rights on the selected mailbox, and fails unless
+// all rights in need are present. Tx must be on the account of the selected
+// mailbox. Rights on a shared mailbox can change while it is selected.
+func (c *conn) xselectedRights(tx *bstore.Tx, need string) store.Rights {
+	rights, err := c.mbAccount.MailboxRights(tx, c.mailboxID, c.account.Name)
+	xcheckf(err, "looking up rights for selected mailbox")
+	xcheckRights(rights, need)
+	return rights
+}
+
+// xcheckRights fails with a NOPERM response code if not all rights in need are
+// present. ../rfc/4314
+func xcheckRights(rights store.Rights, need string) {
+	if !rights.Has(need) {
+		xusercodeErrorf("NOPERM", "missing rights %q for mailbox", need)
+	}
+}
+
+// xaclIdentifier returns the account name for an ACL identifier, which can be an
+// account name, an email address of an account, or "anyone". Negative rights, with
+// identifiers starting with a dash, are not supported. ../rfc/4314
+func (c *conn) xaclIdentifier(identifier string) string {
+	if identifier == store.ACLAnyone {
+		return identifier
+	}
+	if strings.HasPrefix(identifier, "-") {
+		xuserErrorf("negative rights not supported")
+	}
+	if _, ok := mox.Conf.Account(identifier); ok {
+		return identifier
+	}
+	if addr, err := smtp.ParseAddress(identifier); err == nil {
+		if accName, _, _, err := mox.FindAccount(addr.Localpart, addr.Domain, false); err == nil {
+			return accName
+		}
+	}
+	xuserErrorf("unknown identifier %q", identifier)
+	return ""
+}
+
+// xaclMailbox opens the account of a mailbox in our namespace, and calls fn with
+// a transaction on that account, the mailbox and our rights on it. If write is
+// set, fn is called with a writable transaction while holding the account write
+// lock. Fails unless we have the rights in need. The checked mailbox name in our
+// namespace is returned, for use in responses.
+func (c *conn) xaclMailbox(name string, write bool, need string, fn func(tx *bstore.Tx, acc *store.Account, mb store.Mailbox, rights store.Rights)) (mailboxName string) {
+	acc, mbName, closeAcc := c.xmailboxAccount(name, true)
+	defer closeAcc()
+	mailboxName = mbName
+	if acc != c.account {
+		mailboxName = otherUsersName(acc.Name, mbName)
+	}
+
+	xfn := func(tx *bstore.Tx) {
+		mb, rights := c.xmailboxRights(tx, acc, mbName, "NONEXISTENT")
+		xcheckRights(rights, need)
+		fn(tx, acc, mb, rights)
+	}
+	if write {
+		acc.WithWLock(func() {
+			xdbwriteAccount(acc, xfn)
+		})
+	} else {
+		acc.WithRLock(func() {
+			xdbreadAccount(acc, xfn)
+		})
+	}
+	return mailboxName
+}
+
+// Setacl changes the rights granted to an identifier on a mailbox. Rights are
+// replaced, or added or removed when starting with "+" or "-". Requires the
+// administer right "a". The owner of a mailbox always has all rights.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdSetacl(tag, cmd string, p *parser) {
+	// Command: ../rfc/4314
+	// Request syntax: ../rfc/4314
+	p.xspace()
+	name := p.xmailbox()
+	p.xspace()
+	identifier := p.xastring()
+	p.xspace()
+	rightsStr := p.xastring()
+	p.xempty()
+
+	c.xsetacl(tag, cmd, name, identifier, rightsStr)
+}
+
+// Deleteacl removes all rights granted to an identifier on a mailbox. Requires
+// the administer right "a".
+//
+// State: Authenticated and selected.
+func (c *conn) cmdDeleteacl(tag, cmd string, p *parser) {
+	// Command: ../rfc/4314
+	// Request syntax: ../rfc/4314
+	p.xspace()
+	name := p.xmailbox()
+	p.xspace()
+	identifier := p.xastring()
+	p.xempty()
+
+	c.xsetacl(tag, cmd, name, identifier, "")
+}
+
+func (c *conn) xsetacl(tag, cmd, name, identifier, rightsStr string) {
+	var mod string
+	if strings.HasPrefix(rightsStr, "+") || strings.HasPrefix(rightsStr, "-") {
+		mod, rightsStr = rightsStr[:1], rightsStr[1:]
+	}
+	rights, err := store.ParseRights(rightsStr)
+	if err != nil {
+		// ../rfc/4314
+		xsyntaxErrorf("%s", err)
+	}
+	accName := c.xaclIdentifier(identifier)
+
+	c.xaclMailbox(name, true, "a", func(tx *bstore.Tx, acc *store.Account, mb store.Mailbox, _ store.Rights) {
+		if accName == acc.Name {
+			xuserErrorf("cannot change rights of owner of mailbox")
+		}
+		if mod != "" {
+			// Only the rights granted to the identifier itself are modified, not those
+			// granted through "anyone".
+			acls, err := acc.MailboxACLs(tx, mb.ID)
+			xcheckf(err, "listing current rights")
+			var cur store.Rights
+			for _, acl := range acls {
+				if acl.Identifier == accName {
+					cur = acl.Rights
+				}
+			}
+			if mod == "+" {
+				rights = cur.Union(rights)
+			} else {
+				rights = cur.Remove(rights)
+			}
+		}
+		err := acc.MailboxACLSet(tx, mb.ID, accName, rights)
+		xcheckf(err, "setting rights")
+	})
+
+	c.ok(tag, cmd)
+}
+
+// Getacl returns the identifiers and their rights on a mailbox, including the
+// owner. Requires the administer right "a".
+//
+// State: Authenticated and selected.
+func (c *conn) cmdGetacl(tag, cmd string, p *parser) {
+	// Command: ../rfc/4314
+	// Request syntax: ../rfc/4314
+	p.xspace()
+	name := p.xmailbox()
+	p.xempty()
+
+	var l []string
+	name = c.xaclMailbox(name, false, "a", func(tx *bstore.Tx, acc *store.Account, mb store.Mailbox, _ store.Rights) {
+		l = append(l, astring(acc.Name).pack(c), string(store.AllRights))
+		acls, err := acc.MailboxACLs(tx, mb.ID)
+		xcheckf(err, "listing rights")
+		for _, acl := range acls {
+			l = append(l, astring(acl.Identifier).pack(c), string(acl.Rights))
+		}
+	})
+
+	// Response syntax: ../rfc/4314
+	c.bwritelinef("* ACL %s %s", astring(c.encodeMailbox(name)).pack(c), strings.Join(l, " "))
+	c.ok(tag, cmd)
+}
+
+// Listrights returns the rights that can be granted to an identifier on a
+// mailbox. The owner always has all rights. Other identifiers have no rights
+// that are always granted, and each right can be granted separately. Requires
+// the administer right "a".
+//
+// State: Authenticated and selected.
+func (c *conn) cmdListrights(tag, cmd string, p *parser) {
+	// Command: ../rfc/4314
+	// Request syntax: ../rfc/4314
+	p.xspace()
+	name := p.xmailbox()
+	p.xspace()
+	identifier := p.xastring()
+	p.xempty()
+
+	accName := c.xaclIdentifier(identifier)
+
+	var owner bool
+	name = c.xaclMailbox(name, false, "a", func(tx *bstore.Tx, acc *store.Account, mb store.Mailbox, _ store.Rights) {
+		owner = accName == acc.Name
+	})
+
+	// Response syntax: ../rfc/4314
+	var rights string
+	if owner {
+		rights = string(store.AllRights)
+	} else {
+		rights = `""`
+		for _, r := range store.AllRights {
+			rights += " " + string(r)
+		}
+	}
+	c.bwritelinef("* LISTRIGHTS %s %s %s", astring(c.encodeMailbox(name)).pack(c), astring(identifier).pack(c), rights)
+	c.ok(tag, cmd)
+}
+
+// Myrights returns our rights on a mailbox.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdMyrights(tag, cmd string, p *parser) {
+	// Command: ../rfc/4314
+	// Request syntax: ../rfc/4314
+	p.xspace()
+	name := p.xmailbox()
+	p.xempty()
+
+	var rights store.Rights
+	name = c.xaclMailbox(name, false, "", func(tx *bstore.Tx, acc *store.Account, mb store.Mailbox, mbRights store.Rights) {
+		rights = mbRights
+	})
+
+	// Response syntax: ../rfc/4314
+	c.bwritelinef("* MYRIGHTS %s %s", astring(c.encodeMailbox(name)).pack(c), rights)
+	c.ok(tag, cmd)
+}
+
+// xsharedStatusLine returns a STATUS response for a shared mailbox, with its name
+// in our namespace.
+func (c *conn) xsharedStatusLine(sm store.SharedMailbox, attrs []string) (line string) {
+	acc, err := store.OpenAccount(c.log, sm.Account)
+	xcheckf(err, "open account")
+	defer func() {
+		err := acc.Close()
+		c.xsanity(err, "closing account")
+	}()
+
+	acc.WithRLock(func() {
+		xdbreadAccount(acc, func(tx *bstore.Tx) {
+			mb := c.xmailboxID(tx, sm.Mailbox.ID)
+			mb.Name = otherUsersName(acc.Name, mb.Name)
+			line = c.xstatusLine(tx, mb, attrs)
+		})
+	})
+	return line
+}
+
+// xcopyAccount copies messages from the selected mailbox to mailbox name of
+// another account acc, for COPY and MOVE between our own mailboxes and mailboxes
+// shared with us, and for COPY from a virtual mailbox. Messages are read into
+// temporary files while holding the lock of the source account, and then
+// delivered like with APPEND while holding the lock of the destination account.
+// The locks of two accounts are never held at the same time. The messages must be
+// in uids, in ascending order.
+func (c *conn) xcopyAccount(acc *store.Account, name string, uids []store.UID, uidargs []any) (mbDst store.Mailbox, newUIDs []store.UID) {
+	ar := &appendReader{c: c, acc: acc}
+	defer ar.close()
+
+	if len(uidargs) == 0 {
+		xuserErrorf("no matching messages to copy")
+	}
+
+	c.mbAccount.WithRLock(func() {
+		c.xmbdbread(func(tx *bstore.Tx) {
+			c.xcheckSelected(tx) // Validate.
+			c.xselectedRights(tx, "r")
+
+			q := c.selectedQuery(tx, uidargs)
+			if c.virtual != nil {
+				q.SortAsc("ID")
+			} else {
+				q.SortAsc("UID")
+			}
+			msgs, err := q.List()
+			xcheckf(err, "fetching messages")
+			if len(msgs) != len(uids) {
+				xuserErrorf("messages changed, could not fetch requested uids")
+			}
+
+			for _, m := range msgs {
+				a := &appendMsg{flags: m.Flags, keywords: m.Keywords, received: m.Received}
+				a.m.ParsedBuf = m.ParsedBuf
+				ar.msgs = append(ar.msgs, a)
+				a.file, err = store.CreateMessageTemp(c.log, "imap-copy")
+				xcheckf(err, "creating temp file for message")
+				a.mw = message.NewWriter(a.file)
+				msgr := c.mbAccount.MessageReader(m)
+				_, err = io.Copy(a.mw, &moxio.AtReader{R: msgr})
+				xerr := msgr.Close()
+				c.xsanity(xerr, "closing message reader")
+				xcheckf(err, "copying message to temp file")
+			}
+		})
+	})
+
+	ar.xprepare()
+
+	acc.WithWLock(func() {
+		var changes []store.Change
+		xdbwriteAccount(acc, func(tx *bstore.Tx) {
+			var rights store.Rights
+			mbDst, rights = c.xmailboxDst(tx, acc, name)
+			for _, a := range ar.msgs {
+				a.flags, a.keywords = restrictFlags(rights, a.flags, a.keywords)
+			}
+
+			modseq, err := acc.NextModSeq(tx)
+			xcheckf(err, "assigning next modseq")
+
+			changes = ar.xdeliver(tx, &mbDst, modseq)
+			changes = append(changes, mbDst.ChangeCounts())
+		})
+		ar.delivered = nil
+
+		c.broadcastAccount(acc, changes)
+	})
+
+	for _, a := range ar.msgs {
+		newUIDs = append(newUIDs, a.m.UID)
+	}
+	return mbDst, newUIDs
+}
+
+// xmoveAccount moves messages from the selected mailbox to mailbox name of
+// another account acc. The messages are copied, and then expunged from the
+// selected mailbox. The expunged UIDs are returned, messages expunged by another
+// session in the meantime are left out.
+func (c *conn) xmoveAccount(acc *store.Account, name string, uids []store.UID, uidargs []any) (mbDst store.Mailbox, newUIDs, expunged []store.UID, modseq store.ModSeq) {
+	// Check for the rights to remove messages before copying.
+	c.mbAccount.WithRLock(func() {
+		c.xmbdbread(func(tx *bstore.Tx) {
+			c.xselectedRights(tx, "rte")
+		})
+	})
+
+	mbDst, newUIDs = c.xcopyAccount(acc, name, uids, uidargs)
+
+	var remove []store.Message
+	c.mbAccount.WithWLock(func() {
+		var changes []store.Change
+		c.xmbdbwrite(func(tx *bstore.Tx) {
+			mbSrc := c.xmailboxID(tx, c.mailboxID)
+			c.xselectedRights(tx, "te")
+
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: c.mailboxID})
+			q.FilterEqual("UID", uidargs...)
+			q.FilterEqual("Expunged", false)
+			q.SortAsc("UID")
+			var err error
+			remove, err = q.List()
+			xcheckf(err, "listing messages to expunge after copy")
+			if len(remove) == 0 {
+				modseq = c.xhighestModSeq(tx, c.mailboxID)
+				return
+			}
+
+			modseq, err = c.mbAccount.NextModSeq(tx)
+			xcheckf(err, "assigning next modseq")
+			c.xexpungeMessages(tx, &mbSrc, remove, modseq)
+
+			for _, m := range remove {
+				expunged = append(expunged, m.UID)
+			}
+			changes = []store.Change{
+				store.ChangeRemoveUIDs{MailboxID: c.mailboxID, UIDs: expunged, ModSeq: modseq},
+				mbSrc.ChangeCounts(),
+			}
+		})
+		c.broadcastSelected(changes)
+	})
+
+	for _, m := range remove {
+		err := os.Remove(c.mbAccount.MessagePath(m.ID))
+		c.xsanity(err, "removing message file for move")
+	}
+	return mbDst, newUIDs, expunged, modseq
+}
@@ -0,0 +1,151 @@
+package imapserver
+
+import (
+	"testing"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestACL(t *testing.T) {
+	defer mockUIDValidity()()
+	tc := start(t)
+	defer tc.close()
+	tc.client.Login("mjl@mox.example", "testtest")
+
+	tc2 := startArgs(t, false, false, true, true, "other")
+	defer tc2.close()
+	tc2.client.Login("other@mox.example", "testtest")
+
+	const shared = "#Other Users/mjl/Inbox"
+
+	tc.transactf("ok", "myrights inbox")
+	tc.xuntagged(imapclient.UntaggedMyRights{Mailbox: "Inbox", Rights: "lrswipkxtea"})
+
+	tc.transactf("ok", "getacl inbox")
+	tc.xuntagged(imapclient.UntaggedACL{Mailbox: "Inbox", Rights: []imapclient.IdentifierRights{{Identifier: "mjl", Rights: "lrswipkxtea"}}})
+
+	tc.transactf("bad", "setacl inbox other")        // Missing rights.
+	tc.transactf("bad", "setacl inbox other lrz")    // Unknown right.
+	tc.transactf("no", "setacl inbox mjl lr")        // Owner always has all rights.
+	tc.transactf("no", "setacl inbox bogus lr")      // Unknown identifier.
+	tc.transactf("no", "setacl inbox -other lr")     // Negative rights not supported.
+	tc.transactf("no", "setacl bogus other lr")      // Mailbox does not exist.
+	tc.transactf("no", `create "#Other Users/test"`) // Reserved namespace.
+	tc.transactf("ok", `create "Other Users/test"`)  // Regular mailbox, not the namespace.
+	tc.transactf("ok", `delete "Other Users/test"`)
+
+	// Not visible before it is shared.
+	tc2.transactf("no", `select "%s"`, shared)
+	tc2.transactf("ok", `list "" "#Other Users/*"`)
+	tc2.xuntagged()
+
+	tc.transactf("ok", "setacl inbox other@mox.example lr") // Email address of account.
+	tc.transactf("ok", "setacl inbox other +sd")            // Obsolete "d" right.
+	tc.transactf("ok", "getacl inbox")
+	tc.xuntagged(imapclient.UntaggedACL{Mailbox: "Inbox", Rights: []imapclient.IdentifierRights{{Identifier: "mjl", Rights: "lrswipkxtea"}, {Identifier: "other", Rights: "lrsxte"}}})
+	tc.transactf("ok", "setacl inbox other -xte")
+	tc.transactf("ok", "getacl inbox")
+	tc.xuntagged(imapclient.UntaggedACL{Mailbox: "Inbox", Rights: []imapclient.IdentifierRights{{Identifier: "mjl", Rights: "lrswipkxtea"}, {Identifier: "other", Rights: "lrs"}}})
+
+	tc.transactf("ok", "listrights inbox other")
+	tc.xuntagged(imapclient.UntaggedListRights{Mailbox: "Inbox", Identifier: "other", Required: "", Optional: []string{"l", "r", "s", "w", "i", "p", "k", "x", "t", "e", "a"}})
+	tc.transactf("ok", "listrights inbox mjl")
+	tc.xuntagged(imapclient.UntaggedListRights{Mailbox: "Inbox", Identifier: "mjl", Required: "lrswipkxtea"})
+
+	tc.client.Append("inbox", []string{`\Flagged`}, nil, []byte(exampleMsg))
+	tc.client.Select("inbox")
+
+	tc2.transactf("ok", "namespace")
+	tc2.xuntagged(imapclient.UntaggedNamespace{
+		Personal: []imapclient.NamespaceDescr{{Prefix: "", Separator: '/'}},
+		Other:    []imapclient.NamespaceDescr{{Prefix: "#Other Users/", Separator: '/'}},
+	})
+
+	tc2.transactf("ok", `list "" "#Other Users/*"`)
+	tc2.xuntagged(
+		imapclient.UntaggedList{Flags: []string{`\Noselect`}, Separator: '/', Mailbox: "#Other Users/mjl"},
+		imapclient.UntaggedList{Separator: '/', Mailbox: shared},
+	)
+
+	tc2.transactf("ok", `status "%s" (messages)`, shared)
+	tc2.xuntagged(imapclient.UntaggedStatus{Mailbox: shared, Attrs: map[string]int64{"MESSAGES": 1}})
+
+	tc2.transactf("ok", `myrights "%s"`, shared)
+	tc2.xuntagged(imapclient.UntaggedMyRights{Mailbox: shared, Rights: "lrs"})
+	tc2.transactf("no", `getacl "%s"`, shared) // Requires administer right.
+	tc2.xcode("NOPERM")
+	tc2.transactf("no", `setacl "%s" other lrswipkxtea`, shared)
+	tc2.xcode("NOPERM")
+
+	// Wrong account names are treated like unknown mailboxes.
+	tc2.transactf("no", `select "#Other Users/bogus/Inbox"`)
+	tc2.transactf("no", `select "#Other Users/other/Inbox"`)
+
+	tc2.transactf("ok", `select "%s"`, shared)
+	tc2.xcode("READ-WRITE")
+
+	// Changes are broadcast to sessions of both accounts.
+	tc2.transactf("ok", `store 1 +flags (\Seen \Answered)`) // Only \Seen is allowed.
+	tc2.xuntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchFlags{`\Seen`, `\Flagged`}}})
+	tc.transactf("ok", "noop")
+	tc.xuntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchFlags{`\Seen`, `\Flagged`}}})
+
+	tc.client.Append("inbox", nil, nil, []byte(exampleMsg))
+	tc2.transactf("ok", "noop")
+	tc2.xuntagged(
+		imapclient.UntaggedExists(2),
+		imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(2), imapclient.FetchFlags(nil)}},
+	)
+
+	tc2.transactf("ok", `store 1 +flags (\Deleted)`) // Missing right "t", silently ignored.
+	tc2.xuntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchFlags{`\Seen`, `\Flagged`}}})
+	tc2.transactf("no", "expunge")
+	tc2.xcode("NOPERM")
+	tc2.transactf("no", `move 1 Inbox`)
+	tc2.xcode("NOPERM")
+
+	// Copy to our own mailbox.
+	tc2.transactf("ok", "copy 1:2 Inbox")
+	ptr := func(v uint32) *uint32 { return &v }
+	tc2.xcodeArg(imapclient.CodeCopyUID{DestUIDValidity: 1, From: []imapclient.NumRange{{First: 1, Last: ptr(2)}}, To: []imapclient.NumRange{{First: 1, Last: ptr(2)}}})
+
+	// Inserting requires right "i".
+	tc2.transactf("no", `append "%s" {%d+}`+"\r\n"+"%s", shared, len(exampleMsg), exampleMsg)
+	tc2.xcode("NOPERM")
+
+	// Messages can be moved to the shared mailbox with rights "i", and away with "te".
+	tc.transactf("ok", "setacl inbox other +ite")
+	tc2.transactf("ok", `append "%s" (\Draft) {%d+}`+"\r\n"+"%s", shared, len(exampleMsg), exampleMsg)
+	tc2.xuntagged(imapclient.UntaggedExists(3))
+	tc2.transactf("ok", "move 3 Inbox")
+	tc2.xuntaggedOpt(false, imapclient.UntaggedExpunge(3))
+	tc.transactf("ok", "noop")
+	tc.xuntaggedOpt(false, imapclient.UntaggedExpunge(3))
+
+	tc2.transactf("ok", "select inbox")
+	tc2.transactf("ok", "fetch 3 flags")
+	tc2.xuntagged(imapclient.UntaggedFetch{Seq: 3, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(3), imapclient.FetchFlags(nil)}}) // No \Draft without right "w".
+	tc2.transactf("ok", `move 3 "%s"`, shared)
+	tc.transactf("ok", "noop")
+	tc.xuntaggedOpt(false, imapclient.UntaggedExists(3))
+
+	tc2.transactf("ok", `select "%s"`, shared)
+	tc2.transactf("ok", `store 3 +flags.silent (\Deleted)`)
+	tc2.transactf("ok", "expunge")
+	tc2.xuntagged(imapclient.UntaggedExpunge(3))
+
+	// After removing the rights, the mailbox can no longer be used.
+	tc.transactf("ok", "deleteacl inbox other")
+	tc2.transactf("no", "fetch 1 flags")
+	tc2.xcode("NOPERM")
+	tc2.transactf("ok", "unselect")
+	tc2.transactf("no", `select "%s"`, shared)
+	tc2.transactf("ok", `list "" "#Other Users/*"`)
+	tc2.xuntagged()
+
+	// Rights granted to "anyone" apply to all accounts.
+	tc.transactf("ok", "setacl inbox anyone lr")
+	tc2.transactf("ok", `examine "%s"`, shared)
+	tc2.transactf("ok", `myrights "%s"`, shared)
+	tc2.xuntagged(imapclient.UntaggedMyRights{Mailbox: shared, Rights: "lr"})
+}
@@ -0,0 +1,658 @@
+package imapserver
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/store"
+)
+
+// appendMsg is a message for APPEND or REPLACE. The message data is read into a
+// temporary file before the message is added to the mailbox.
+type appendMsg struct {
+	flags    store.Flags
+	keywords []string
+	received time.Time
+	file     *os.File
+	mw       *message.Writer
+	m        store.Message // Set when delivered.
+}
+
+// appendReader reads the messages of an APPEND (with MULTIAPPEND) or REPLACE
+// command. Message data is sent in literals, after which the command continues on
+// the next line, so the parser is replaced after each literal.
+//
+// Errors about the destination mailbox and CATENATE URLs are kept until the
+// command has been read, so data of non-synchronizing literals isn't interpreted
+// as commands. A kept error is returned instead of asking for a synchronizing
+// literal, the client won't send the literal then. ../rfc/3502 ../rfc/4469
+type appendReader struct {
+	c         *conn
+	p         *parser
+	acc       *store.Account // Of destination mailbox, can be another account for shared mailboxes.
+	err       error          // First user error while reading the command.
+	msgs      []*appendMsg
+	delivered []int64 // IDs of delivered messages, files are removed if the transaction fails.
+}
+
+// close removes the temporary files, and the files of messages delivered in a
+// transaction that failed.
+func (ar *appendReader) close() {
+	c := ar.c
+	for _, a := range ar.msgs {
+		if a.file == nil {
+			continue
+		}
+		p := a.file.Name()
+		err := a.file.Close()
+		c.xsanity(err, "closing APPEND temporary file")
+		err = os.Remove(p)
+		c.xsanity(err, "removing APPEND temporary file")
+	}
+	for _, id := range ar.delivered {
+		p := ar.acc.MessagePath(id)
+		err := os.Remove(p)
+		c.xsanity(err, "removing delivered message file after failed transaction")
+	}
+}
+
+// xkeep calls fn, keeping a user error to return after the command has been read.
+func (ar *appendReader) xkeep(fn func()) {
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(userError); ok {
+			if ar.err == nil {
+				ar.err = err
+			}
+			return
+		}
+		panic(x)
+	}()
+	fn()
+}
+
+// xcheckErr returns a kept error, called when the command has been read.
+func (ar *appendReader) xcheckErr() {
+	if ar.err != nil {
+		panic(ar.err)
+	}
+}
+
+// xmessage reads a message with optional flags and time, and data as literal,
+// UTF8 literal or CATENATE parts.
+func (ar *appendReader) xmessage() {
+	// Request syntax: ../rfc/9051:6325 ../rfc/6855:219 ../rfc/3501:4547 ../rfc/3502 ../rfc/4469
+
+	c := ar.c
+	a := &appendMsg{}
+	ar.msgs = append(ar.msgs, a)
+
+	if ar.p.hasPrefix("(") {
+		// Error must be a syntax error, to properly abort the connection due to literal.
+		var err error
+		a.flags, a.keywords, err = store.ParseFlagsKeywords(ar.p.xflagList())
+		if err != nil {
+			xsyntaxErrorf("parsing flags: %v", err)
+		}
+		ar.p.xspace()
+	}
+	if ar.p.hasPrefix(`"`) {
+		a.received = ar.p.xdateTime()
+		ar.p.xspace()
+	} else {
+		a.received = time.Now()
+	}
+
+	var err error
+	a.file, err = store.CreateMessageTemp(c.log, "imap-append")
+	xcheckf(err, "creating temp file for message")
+	a.mw = message.NewWriter(a.file)
+
+	if ar.p.take("CATENATE (") {
+		// ../rfc/4469
+		for {
+			if ar.p.take("URL ") {
+				s := ar.p.xastring()
+				if ar.err == nil {
+					ar.xkeep(func() {
+						c.xcatenateURL(a.mw, s)
+					})
+				}
+			} else {
+				ar.p.xtake("TEXT ")
+				size, sync := ar.p.xliteralSize(0, false)
+				ar.xliteral(a.mw, size, sync)
+			}
+			if ar.p.take(")") {
+				return
+			}
+			ar.p.xspace()
+		}
+	}
+
+	// todo: only with utf8 should we we accept message headers with utf-8. we currently always accept them.
+	// ../rfc/6855:204
+	utf8 := ar.p.take("UTF8 (")
+	size, sync := ar.p.xliteralSize(0, utf8)
+	ar.xliteral(a.mw, size, sync)
+	if utf8 {
+		ar.p.xtake(")")
+	}
+}
+
+// xliteral reads the data of a literal into w, and continues with the remainder
+// of the command line.
+func (ar *appendReader) xliteral(w io.Writer, size int64, sync bool) {
+	c := ar.c
+	if sync {
+		ar.xcheckErr()
+		c.writelinef("+ ")
+	}
+
+	restore := c.xtrace(mlog.LevelTracedata)
+	n, err := io.Copy(w, io.LimitReader(c.br, size))
+	restore()
+	if err != nil {
+		// Cannot use xcheckf due to %w handling of errIO.
+		panic(fmt.Errorf("reading literal message: %s (%w)", err, errIO))
+	}
+	if n != size {
+		xserverErrorf("read %d bytes for message, expected %d (%w)", n, size, errIO)
+	}
+
+	ar.p = newParser(c.readline(false), c)
+}
+
+// xprepare parses the messages if not yet done and extracts the text from their
+// attachments, before the account is locked for delivery.
+func (ar *appendReader) xprepare() {
+	for _, a := range ar.msgs {
+		a.m.Size = a.mw.Size
+		err := ar.acc.PrepareAttachmentText(ar.c.log, &a.m, a.file)
+		xcheckf(err, "preparing message")
+	}
+}
+
+// xdeliver adds the messages to mailbox mb, which is reloaded afterwards. The
+// changes to broadcast for the new messages are returned, without the mailbox
+// counts, the caller adds those.
+func (ar *appendReader) xdeliver(tx *bstore.Tx, mb *store.Mailbox, modseq store.ModSeq) (changes []store.Change) {
+	c := ar.c
+
+	// Ensure keywords are stored in mailbox.
+	var keywords []string
+	var totalSize int64
+	for _, a := range ar.msgs {
+		keywords = append(keywords, a.keywords...)
+		totalSize += a.mw.Size
+	}
+	var mbKwChanged bool
+	mb.Keywords, mbKwChanged = store.MergeKeywords(mb.Keywords, keywords)
+	if mbKwChanged {
+		changes = append(changes, mb.ChangeKeywords())
+	}
+
+	ok, maxSize, err := ar.acc.CanAddMessageSize(tx, totalSize)
+	xcheckf(err, "checking quota")
+	if !ok {
+		// ../rfc/9051:5155
+		xusercodeErrorf("OVERQUOTA", "account over maximum total message size %d", maxSize)
+	}
+	ok, maxCount, err := ar.acc.CanAddMessages(tx, int64(len(ar.msgs)))
+	xcheckf(err, "checking quota")
+	if !ok {
+		xusercodeErrorf("OVERQUOTA", "account over maximum number of messages %d", maxCount)
+	}
+
+	for _, a := range ar.msgs {
+		// Message was prepared by xprepare.
+		a.m.MailboxID = mb.ID
+		a.m.MailboxOrigID = mb.ID
+		a.m.Received = a.received
+		a.m.Flags = a.flags
+		a.m.Keywords = a.keywords
+		a.m.CreateSeq = modseq
+		a.m.ModSeq = modseq
+		mb.Add(a.m.MailboxCounts())
+	}
+
+	// Update mailbox before delivering, which updates uidnext which we mustn't overwrite.
+	err = tx.Update(mb)
+	xcheckf(err, "updating mailbox counts")
+
+	for _, a := range ar.msgs {
+		err := ar.acc.DeliverMessage(c.log, tx, &a.m, a.file, true, false, false, true)
+		xcheckf(err, "delivering message")
+		ar.delivered = append(ar.delivered, a.m.ID)
+		changes = append(changes, a.m.ChangeAddUID())
+	}
+
+	err = tx.Get(mb)
+	xcheckf(err, "reloading mailbox after delivery")
+	return changes
+}
+
+// Append adds one or more messages to a mailbox. With MULTIAPPEND, all messages
+// are added in a single transaction, or none at all. With CATENATE, a message
+// can be composed of literals and (parts of) existing messages.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdAppend(tag, cmd string, p *parser) {
+	// Command: ../rfc/9051:3406 ../rfc/6855:204 ../rfc/3501:2527 ../rfc/3502 ../rfc/4469
+	// Examples: ../rfc/9051:3482 ../rfc/3501:2589 ../rfc/3502 ../rfc/4469
+
+	// Request syntax: ../rfc/9051:6325 ../rfc/6855:219 ../rfc/3501:4547 ../rfc/3502
+	p.xspace()
+	name := p.xmailbox()
+	p.xspace()
+
+	closeAcc := func() {}
+	defer func() {
+		closeAcc()
+	}()
+	ar := &appendReader{c: c, p: p}
+	defer ar.close()
+
+	// Check the mailbox before asking for the data, the client can create it when we
+	// respond with TRYCREATE. The mailbox can be shared with us by another account.
+	ar.xkeep(func() {
+		ar.acc, name, closeAcc = c.xmailboxAccount(name, true)
+		xdbreadAccount(ar.acc, func(tx *bstore.Tx) {
+			c.xmailboxDst(tx, ar.acc, name)
+		})
+	})
+
+	// With MULTIAPPEND, more messages follow. ../rfc/3502
+	for {
+		ar.xmessage()
+		if !ar.p.space() {
+			break
+		}
+	}
+	ar.p.xempty()
+	ar.xcheckErr()
+	ar.xprepare()
+
+	var mb store.Mailbox
+	var pendingChanges [2][]store.Change
+
+	ar.acc.WithWLock(func() {
+		var changes []store.Change
+		xdbwriteAccount(ar.acc, func(tx *bstore.Tx) {
+			var rights store.Rights
+			mb, rights = c.xmailboxDst(tx, ar.acc, name)
+			for _, a := range ar.msgs {
+				a.flags, a.keywords = restrictFlags(rights, a.flags, a.keywords)
+			}
+
+			modseq, err := ar.acc.NextModSeq(tx)
+			xcheckf(err, "assigning next modseq")
+
+			changes = ar.xdeliver(tx, &mb, modseq)
+			changes = append(changes, mb.ChangeCounts())
+		})
+		ar.delivered = nil
+
+		// Fetch pending changes, possibly with new UIDs, so we can apply them before adding our own new UIDs.
+		pendingChanges = c.takePending()
+
+		// Broadcast the change to other connections.
+		c.broadcastAccount(ar.acc, changes)
+	})
+
+	c.applyTaken(pendingChanges, false)
+	var uids numSet
+	for _, a := range ar.msgs {
+		uids.append(uint32(a.m.UID))
+	}
+	if c.isSelected(ar.acc, mb.ID) {
+		for _, a := range ar.msgs {
+			c.uidAppend(a.m.UID)
+		}
+		// todo spec: with condstore/qresync, is there a mechanism to the client know the modseq for the appended uid? in theory an untagged fetch with the modseq after the OK APPENDUID could make sense, but this probably isn't allowed.
+		c.bwritelinef("* %d EXISTS", len(c.uids))
+	}
+
+	// With multiple messages, the UIDs are a set. ../rfc/3502 ../rfc/4315
+	c.writeresultf("%s OK [APPENDUID %d %s] appended", tag, mb.UIDValidity, uids.String())
+}
+
+// State: Selected
+func (c *conn) cmdReplace(tag, cmd string, p *parser) {
+	c.cmdxReplace(false, tag, cmd, p)
+}
+
+// State: Selected
+func (c *conn) cmdUIDReplace(tag, cmd string, p *parser) {
+	c.cmdxReplace(true, tag, cmd, p)
+}
+
+// Replace adds a message to a mailbox and expunges a message from the selected
+// mailbox, in a single transaction. Clients use it to save a new version of a
+// draft message. The message can be composed with CATENATE, e.g. to keep the
+// attachments of the previous version.
+//
+// State: Selected
+func (c *conn) cmdxReplace(isUID bool, tag, cmd string, p *parser) {
+	// Command: ../rfc/8508
+	// Examples: ../rfc/8508
+
+	// Request syntax: ../rfc/8508
+	p.xspace()
+	num := p.xnznumber()
+	p.xspace()
+	name := p.xmailbox()
+	p.xspace()
+
+	closeAcc := func() {}
+	defer func() {
+		closeAcc()
+	}()
+	ar := &appendReader{c: c, p: p}
+	defer ar.close()
+
+	var uid store.UID
+	ar.xkeep(func() {
+		if c.readonly {
+			xuserErrorf("mailbox open in read-only mode")
+		}
+		c.xnotVirtual()
+		if isUID {
+			uid = store.UID(num)
+			if uidSearch(c.uids, uid) == 0 {
+				xuserErrorf("unknown uid %d", uid)
+			}
+		} else {
+			if int(num) > len(c.uids) {
+				xuserErrorf("invalid message sequence number %d", num)
+			}
+			uid = c.uids[num-1]
+		}
+		ar.acc, name, closeAcc = c.xmailboxAccount(name, true)
+		if ar.acc != c.mbAccount {
+			// Cannot be done in a single transaction.
+			xusercodeErrorf("CANNOT", "cannot replace with message in mailbox of other account")
+		}
+		xdbreadAccount(ar.acc, func(tx *bstore.Tx) {
+			c.xmailboxDst(tx, ar.acc, name)
+		})
+	})
+
+	ar.xmessage()
+	ar.p.xempty()
+	ar.xcheckErr()
+	ar.xprepare()
+
+	var mb, mbSrc store.Mailbox
+	var om store.Message
+	var modseq store.ModSeq
+	var pendingChanges [2][]store.Change
+
+	c.mbAccount.WithWLock(func() {
+		var changes []store.Change
+		c.xmbdbwrite(func(tx *bstore.Tx) {
+			mbSrc = c.xmailboxID(tx, c.mailboxID)
+			c.xselectedRights(tx, "te") // ../rfc/8508 ../rfc/4314
+
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: mbSrc.ID, UID: uid})
+			q.FilterEqual("Expunged", false)
+			var err error
+			om, err = q.Get()
+			if err == bstore.ErrAbsent {
+				// ../rfc/2180:343
+				xusercodeErrorf("EXPUNGEISSUED", "message to replace was expunged")
+			}
+			xcheckf(err, "looking up message to replace")
+
+			modseq, err = c.mbAccount.NextModSeq(tx)
+			xcheckf(err, "assigning next modseq")
+
+			// We expunge first, the mailbox counts are then current when the destination is
+			// the selected mailbox.
+			remove := []store.Message{om}
+			c.xexpungeMessages(tx, &mbSrc, remove, modseq)
+			changes = append(changes, store.ChangeRemoveUIDs{MailboxID: mbSrc.ID, UIDs: []store.UID{uid}, ModSeq: modseq})
+
+			var rights store.Rights
+			mb, rights = c.xmailboxDst(tx, ar.acc, name)
+			a := ar.msgs[0]
+			a.flags, a.keywords = restrictFlags(rights, a.flags, a.keywords)
+			changes = append(changes, ar.xdeliver(tx, &mb, modseq)...)
+			if mb.ID == mbSrc.ID {
+				mbSrc = mb
+			} else {
+				changes = append(changes, mbSrc.ChangeCounts())
+			}
+			changes = append(changes, mb.ChangeCounts())
+		})
+		ar.delivered = nil
+
+		// Fetch pending changes, possibly with new UIDs, so we can apply them before adding our own new UID.
+		pendingChanges = c.takePending()
+
+		// Broadcast the change to other connections.
+		c.broadcastSelected(changes)
+	})
+
+	// The replaced message may be kept as deleted message, like expunged messages.
+	c.mbAccount.ExpungedFilesRemove(c.log, []store.Message{om})
+
+	// Response syntax: ../rfc/8508
+	c.applyTaken(pendingChanges, false)
+	m := ar.msgs[0].m
+	c.bwritelinef("* OK [APPENDUID %d %d] replacement message", mb.UIDValidity, m.UID)
+	if c.mailboxID == mb.ID {
+		c.uidAppend(m.UID)
+		c.bwritelinef("* %d EXISTS", len(c.uids))
+	}
+	seq := c.xsequence(uid)
+	c.sequenceRemove(seq, uid)
+	if c.enabled[capQresync] {
+		// ../rfc/7162:2004
+		c.bwritelinef("* VANISHED %d", uid)
+	} else {
+		c.bwritelinef("* %d EXPUNGE", seq)
+	}
+
+	if c.enabled[capCondstore] {
+		c.writeresultf("%s OK [HIGHESTMODSEQ %d] replaced", tag, modseq.Client())
+	} else {
+		c.ok(tag, cmd)
+	}
+}
+
+// xcatenateURL writes the data referenced by an IMAP URL for CATENATE to w. The
+// URL is relative to the server, or an absolute URL for this host and user. The
+// message can be in any mailbox of the account, or in a mailbox shared with the
+// account. ../rfc/4469 ../rfc/5092
+func (c *conn) xcatenateURL(w io.Writer, s string) {
+	// The URL is in the response code, which cannot contain "]".
+	code := "BADURL " + strings.ReplaceAll(s, "]", "%5D")
+	xbadurl := func(format string, args ...any) {
+		xusercodeErrorf(code, format, args...)
+	}
+
+	path := s
+	if len(s) >= len("imap://") && strings.EqualFold(s[:len("imap://")], "imap://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			xbadurl("parsing url: %v", err)
+		}
+		if !strings.EqualFold(u.Hostname(), mox.Conf.Static.HostnameDomain.ASCII) {
+			xbadurl("url for other host")
+		}
+		if u.User != nil {
+			// Userinfo can have an ;AUTH= parameter. ../rfc/5092
+			user, _, _ := strings.Cut(u.User.Username(), ";")
+			if !strings.EqualFold(user, c.username) {
+				xbadurl("url for other user")
+			}
+		}
+		if u.RawQuery != "" || u.Fragment != "" {
+			xbadurl("url with query or fragment")
+		}
+		path = u.EscapedPath()
+	}
+	if !strings.HasPrefix(path, "/") {
+		xbadurl("url must be an absolute path or imap url")
+	}
+
+	// Path is: /mailbox[;UIDVALIDITY=n]/;UID=n[/;SECTION=s][/;PARTIAL=o[.n]]
+	// ../rfc/5092
+	segs := strings.Split(path[1:], "/;")
+	if len(segs) < 2 {
+		xbadurl("url without uid")
+	}
+	mbparams := strings.Split(segs[0], ";")
+	name, err := url.PathUnescape(mbparams[0])
+	if err != nil {
+		xbadurl("unescaping mailbox name: %v", err)
+	}
+	var uidvalidity uint32
+	for _, param := range mbparams[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(k, "UIDVALIDITY") {
+			xbadurl("unknown mailbox parameter %q", k)
+		}
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || n == 0 {
+			xbadurl("invalid uidvalidity %q", v)
+		}
+		uidvalidity = uint32(n)
+	}
+	var uid store.UID
+	var section string
+	var offset, length int64 = 0, -1
+	for i, seg := range segs[1:] {
+		k, v, _ := strings.Cut(seg, "=")
+		switch strings.ToUpper(k) {
+		case "UID":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if i != 0 || err != nil || n == 0 {
+				xbadurl("invalid uid %q", v)
+			}
+			uid = store.UID(n)
+		case "SECTION":
+			section, err = url.PathUnescape(v)
+			if i == 0 || section == "" || err != nil {
+				xbadurl("invalid section %q", v)
+			}
+		case "PARTIAL":
+			o, n, hasLength := strings.Cut(v, ".")
+			offset, err = strconv.ParseInt(o, 10, 64)
+			if i == 0 || err != nil || offset < 0 {
+				xbadurl("invalid partial offset %q", v)
+			}
+			if hasLength {
+				length, err = strconv.ParseInt(n, 10, 64)
+				if err != nil || length <= 0 {
+					xbadurl("invalid partial length %q", v)
+				}
+			}
+		default:
+			// Includes URLAUTH, which we don't support.
+			xbadurl("unsupported url parameter %q", k)
+		}
+	}
+
+	// Parse the section and mailbox name as in a FETCH command. A syntax error would
+	// result in BAD and possibly abort the connection, so it becomes a BADURL.
+	// The mailbox can be shared by another account.
+	var sec *sectionSpec
+	var acc *store.Account
+	closeAcc := func() {}
+	func() {
+		defer func() {
+			x := recover()
+			if err, ok := x.(syntaxError); ok {
+				xbadurl("%s", err.errmsg)
+			} else if err, ok := x.(userError); ok {
+				xbadurl("%s", err.err)
+			} else if x != nil {
+				panic(x)
+			}
+		}()
+		if section != "" {
+			sp := newParser("["+section+"]", c)
+			sec = sp.xsection()
+			sp.xempty()
+		}
+		acc, name, closeAcc = c.xmailboxAccount(name, true)
+	}()
+	defer closeAcc()
+
+	acc.WithRLock(func() {
+		xdbreadAccount(acc, func(tx *bstore.Tx) {
+			mb, rights := c.xmailboxRights(tx, acc, name, code)
+			if !rights.Has("r") {
+				xbadurl("no right to read messages in mailbox")
+			}
+			if uidvalidity != 0 && mb.UIDValidity != uidvalidity {
+				xbadurl("uidvalidity mismatch")
+			}
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: mb.ID, UID: uid})
+			q.FilterEqual("Expunged", false)
+			m, err := q.Get()
+			if err == bstore.ErrAbsent {
+				xbadurl("no message with uid %d", uid)
+			}
+			xcheckf(err, "looking up message")
+
+			cmd := &fetchCmd{conn: c, account: acc, mailboxID: mb.ID, uid: uid, tx: tx, m: &m}
+			defer func() {
+				if cmd.msgr != nil {
+					err := cmd.msgr.Close()
+					c.xsanity(err, "closing message reader")
+				}
+			}()
+
+			// Errors for the message, e.g. a part that doesn't exist, make the URL invalid.
+			var r io.Reader
+			func() {
+				defer func() {
+					x := recover()
+					if err, ok := x.(attrError); ok {
+						xbadurl("%v", err)
+					} else if x != nil {
+						panic(x)
+					}
+				}()
+				msgr, part := cmd.xensureParsed()
+				if sec == nil {
+					r = &moxio.AtReader{R: msgr}
+				} else {
+					r = cmd.xsection(sec, part)
+				}
+			}()
+
+			if offset > 0 {
+				_, err := io.CopyN(io.Discard, r, offset)
+				if err == io.EOF {
+					return
+				}
+				xcheckf(err, "skipping to partial offset")
+			}
+			if length >= 0 {
+				r = io.LimitReader(r, length)
+			}
+			_, err = io.Copy(w, r)
+			xcheckf(err, "copying message data")
+		})
+	})
+}
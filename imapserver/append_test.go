@@ -1,6 +1,7 @@
 package imapserver
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/mjl-/mox/imapclient"
@@ -90,3 +91,105 @@ func TestAppend(t *testing.T) {
 	tclimit.transactf("no", "append inbox (\\Seen Label1 $label2) \" 1-Jan-2022 10:10:00 +0100\" {1+}\r\nx")
 	tclimit.xcode("OVERQUOTA")
 }
+
+func TestMultiappend(t *testing.T) {
+	defer mockUIDValidity()()
+
+	tc := start(t)
+	defer tc.close()
+
+	tc2 := startNoSwitchboard(t)
+	defer tc2.close()
+
+	tc.client.Login("mjl@mox.example", "testtest")
+	tc.client.Select("inbox")
+	tc2.client.Login("mjl@mox.example", "testtest")
+	tc2.client.Select("inbox")
+
+	tc.transactf("ok", "append inbox (\\Seen) {1+}\r\nx (label1) \" 1-Jan-2022 10:10:00 +0100\" {2+}\r\nxx {3+}\r\nxxx")
+	tc.xuntagged(imapclient.UntaggedExists(3))
+	tc.xcodeArg(imapclient.CodeAppendUID{UIDValidity: 1, UIDs: xparseNumSet("1:3").Ranges})
+
+	tc2.transactf("ok", "noop")
+	tc2.xuntagged(
+		imapclient.UntaggedExists(3),
+		imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchFlags{`\Seen`}}},
+		imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(2), imapclient.FetchFlags{"label1"}}},
+		imapclient.UntaggedFetch{Seq: 3, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(3), imapclient.FetchFlags(nil)}},
+	)
+
+	// Error for the mailbox is returned after reading all non-sync literals. The
+	// connection stays usable.
+	tc.transactf("no", "append nobox {1+}\r\nx {1+}\r\nx")
+	tc.xcode("TRYCREATE")
+	tc.transactf("ok", "noop")
+
+	// With a sync literal, the error is returned before the literal.
+	tc.transactf("no", "append nobox {1+}\r\nx {1}")
+	tc.xcode("TRYCREATE")
+	tc.transactf("ok", "noop")
+
+	// None of the messages are added when going over quota.
+	tclimit := startArgs(t, false, false, true, true, "limit")
+	defer tclimit.close()
+	tclimit.client.Login("limit@mox.example", "testtest")
+	tclimit.client.Select("inbox")
+	tclimit.transactf("no", "append inbox {1+}\r\nx {1+}\r\nx {1+}\r\nx")
+	tclimit.xcode("OVERQUOTA")
+	tclimit.transactf("ok", "status inbox (messages)")
+	tclimit.xuntagged(imapclient.UntaggedStatus{Mailbox: "Inbox", Attrs: map[string]int64{"MESSAGES": 0}})
+}
+
+func TestCatenate(t *testing.T) {
+	defer mockUIDValidity()()
+
+	tc := start(t)
+	defer tc.close()
+
+	tc.client.Login("mjl@mox.example", "testtest")
+	tc.client.Select("inbox")
+
+	tc.transactf("ok", "append inbox {%d+}\r\n%s", len(exampleMsg), exampleMsg)
+	tc.xcodeArg(imapclient.CodeAppendUID{UIDValidity: 1, UID: 1})
+
+	// Headers of the existing message with a new body.
+	tc.transactf("ok", "append inbox catenate (url \"/INBOX;UIDVALIDITY=1/;UID=1/;SECTION=HEADER\" text {6+}\r\nbody\r\n)")
+	tc.xcodeArg(imapclient.CodeAppendUID{UIDValidity: 1, UID: 2})
+	tc.transactf("ok", "uid fetch 2 body.peek[]")
+	hdr := exampleMsg[:strings.Index(exampleMsg, "\r\n\r\n")+4]
+	tc.xuntagged(imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(2), imapclient.FetchBody{RespAttr: "BODY[]", Body: hdr + "body\r\n"}}})
+
+	// Full message, with partial, and an absolute url.
+	tc.transactf("ok", "append inbox catenate (url \"imap://mjl%%40mox.example@mox.example/Inbox/;UID=1/;PARTIAL=0.4\" url \"/Inbox/;UID=2/;SECTION=TEXT\")")
+	tc.transactf("ok", "uid fetch 3 body.peek[]")
+	tc.xuntagged(imapclient.UntaggedFetch{Seq: 3, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(3), imapclient.FetchBody{RespAttr: "BODY[]", Body: exampleMsg[:4] + "body\r\n"}}})
+
+	// Bad urls.
+	tc.transactf("no", `append inbox catenate (url "/Inbox/;UID=10")`) // Unknown message.
+	tc.xcode("BADURL")
+	tc.transactf("no", `append inbox catenate (url "/Inbox;UIDVALIDITY=2/;UID=1")`) // Wrong uidvalidity.
+	tc.xcode("BADURL")
+	tc.transactf("no", `append inbox catenate (url "/Bogus/;UID=1")`) // Unknown mailbox.
+	tc.xcode("BADURL")
+	tc.transactf("no", `append inbox catenate (url "/Inbox/;UID=1/;SECTION=9")`) // Unknown part.
+	tc.xcode("BADURL")
+	tc.transactf("no", `append inbox catenate (url "/Inbox/;UID=1/;SECTION=bogus")`) // Bad section.
+	tc.xcode("BADURL")
+	tc.transactf("no", `append inbox catenate (url "/Inbox/;UID=1;URLAUTH=anonymous")`) // URLAUTH not supported.
+	tc.xcode("BADURL")
+	tc.transactf("no", `append inbox catenate (url "imap://other.example/Inbox/;UID=1")`) // Other host.
+	tc.xcode("BADURL")
+	tc.transactf("no", `append inbox catenate (url "Inbox/;UID=1")`) // Relative url.
+	tc.xcode("BADURL")
+
+	// After a bad url, further non-sync literals are read, a sync literal is refused.
+	tc.transactf("no", "append inbox catenate (url \"/Inbox/;UID=10\" text {1+}\r\nx)")
+	tc.xcode("BADURL")
+	tc.transactf("no", "append inbox catenate (url \"/Inbox/;UID=10\" text {1}")
+	tc.xcode("BADURL")
+	tc.transactf("ok", "noop")
+
+	tc.transactf("bad", `append inbox catenate ()`)           // Missing parts.
+	tc.transactf("bad", `append inbox catenate (bogus "x")`)  // Unknown part type.
+	tc.transactf("bad", `append inbox catenate (url "/x") x`) // Leftover data.
+}
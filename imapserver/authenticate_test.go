@@ -128,6 +128,17 @@ func testAuthenticateSCRAM(t *testing.T, tls bool, method string, h func() hash.
 	tc = startArgs(t, true, tls, true, true, "mjl")
 	auth("no", scram.ErrInvalidProof, "mjl@mox.example", "badpass")
 	auth("no", scram.ErrInvalidProof, "mjl@mox.example", "")
+
+	// App passwords are accepted, but only for their scope.
+	_, imapPassword, err := tc.account.AppPasswordAdd(ctxbg, pkglog, "imap", true, false)
+	tc.check(err, "add app password")
+	_, submissionPassword, err := tc.account.AppPasswordAdd(ctxbg, pkglog, "submission", false, true)
+	tc.check(err, "add app password")
+	auth("no", scram.ErrInvalidProof, "mjl@mox.example", submissionPassword)
+	auth("ok", nil, "mjl@mox.example", imapPassword)
+	tc.close()
+
+	tc = startArgs(t, true, tls, true, true, "mjl")
 	// todo: server aborts due to invalid username. we should probably make client continue with fake determinisitically generated salt and result in error in the end.
 	// auth("no", nil, "other@mox.example", "testtest")
 
@@ -179,6 +190,16 @@ func TestAuthenticateCRAMMD5(t *testing.T) {
 	auth("no", "mjl@mox.example", "")
 	auth("no", "other@mox.example", "testtest")
 
+	_, imapPassword, err := tc.account.AppPasswordAdd(ctxbg, pkglog, "imap", true, false)
+	tc.check(err, "add app password")
+	_, submissionPassword, err := tc.account.AppPasswordAdd(ctxbg, pkglog, "submission", false, true)
+	tc.check(err, "add app password")
+	auth("no", "mjl@mox.example", submissionPassword)
+
+	auth("ok", "mjl@mox.example", imapPassword)
+	tc.close()
+
+	tc = start(t)
 	auth("ok", "mjl@mox.example", "testtest")
 
 	tc.close()
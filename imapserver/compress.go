@@ -0,0 +1,118 @@
+package imapserver
+
+import (
+	"bufio"
+	"compress/flate"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mjl-/mox/moxio"
+)
+
+// compressState is kept for a connection after COMPRESS=DEFLATE is enabled. The
+// byte counters are used for metrics on the compression ratio when the
+// connection closes. Reads happen in a separate goroutine during IDLE and NOTIFY,
+// so the counters are atomic.
+type compressState struct {
+	fw      *flate.Writer // Flushed in xflush, after flushing c.bw.
+	flushed int64         // Value of written at last flush, to skip flushes without new data.
+
+	readCompressed  atomic.Int64 // From the connection.
+	read            atomic.Int64 // After decompressing.
+	writeCompressed atomic.Int64 // To the connection.
+	written         atomic.Int64 // Before compressing.
+}
+
+// xflush writes out all compressed data, not waiting for a full block. A flush
+// without new data would still write an empty block, so it is skipped.
+// ../rfc/4978
+func (cs *compressState) xflush() {
+	if n := cs.written.Load(); n != cs.flushed {
+		err := cs.fw.Flush()
+		xcheckf(err, "flush compressed data")
+		cs.flushed = n
+	}
+}
+
+// observe records the compression ratios for the connection in the metrics.
+func (cs *compressState) observe() {
+	if n := cs.readCompressed.Load(); n > 0 {
+		metricIMAPCompressRatio.WithLabelValues("in").Observe(float64(cs.read.Load()) / float64(n))
+	}
+	if n := cs.writeCompressed.Load(); n > 0 {
+		metricIMAPCompressRatio.WithLabelValues("out").Observe(float64(cs.written.Load()) / float64(n))
+	}
+}
+
+// countReader counts the bytes read.
+type countReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (r countReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// countWriter counts the bytes written.
+type countWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (w countWriter) Write(buf []byte) (int, error) {
+	n, err := w.w.Write(buf)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+// Compress enables compression on the connection, in both directions, after the
+// OK response. Only DEFLATE is specified. Clients typically enable it after
+// authentication, when large amounts of data are about to be transferred.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdCompress(tag, cmd string, p *parser) {
+	// Command: ../rfc/4978
+	// Request syntax: ../rfc/4978
+
+	p.xspace()
+	alg := p.xatom()
+	p.xempty()
+
+	if c.compress != nil {
+		// ../rfc/4978
+		xusercodeErrorf("COMPRESSIONACTIVE", "compression already active")
+	}
+	if !strings.EqualFold(alg, "DEFLATE") {
+		// ../rfc/4978
+		xsyntaxErrorf("unsupported compression algorithm %q", alg)
+	}
+
+	// Clients must not pipeline commands after COMPRESS, but any data already
+	// buffered is compressed data, like with STARTTLS.
+	var r io.Reader = c.conn
+	if n := c.br.Buffered(); n > 0 {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(c.br, buf)
+		xcheckf(err, "reading buffered data for compression")
+		r = &prefixConn{buf, c.conn}
+	}
+	// The OK response is sent uncompressed. ../rfc/4978
+	c.ok(tag, cmd)
+
+	// Tracing is done on the uncompressed data, and writes still go through c.Write
+	// for slow connections and i/o errors. TLS, if any, is below the compression layer.
+	cs := &compressState{}
+	fw, err := flate.NewWriter(countWriter{c, &cs.writeCompressed}, flate.DefaultCompression)
+	xcheckf(err, "new flate writer")
+	cs.fw = fw
+	fr := flate.NewReader(countReader{r, &cs.readCompressed})
+	c.tr = moxio.NewTraceReader(c.log, "C: ", countReader{fr, &cs.read})
+	c.tw = moxio.NewTraceWriter(c.log, "S: ", countWriter{fw, &cs.written})
+	c.br = bufio.NewReader(c.tr)
+	c.bw = bufio.NewWriter(c.tw)
+	c.compress = cs
+}
@@ -0,0 +1,36 @@
+package imapserver
+
+import (
+	"testing"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestCompress(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+
+	tc.transactf("no", "compress deflate") // Not authenticated.
+
+	tc.client.Login("mjl@mox.example", "testtest")
+
+	tc.transactf("bad", "compress")           // Missing algorithm.
+	tc.transactf("bad", "compress bogus")     // Unknown algorithm.
+	tc.transactf("bad", "compress deflate ")  // Leftover data.
+	tc.transactf("bad", "compress (deflate)") // Not an atom.
+
+	_, _, err := tc.client.CompressDeflate()
+	tc.check(err, "compress deflate")
+
+	tc.transactf("no", "compress deflate")
+	tc.xcode("COMPRESSIONACTIVE")
+
+	// Regular commands work over the compressed connection, including literals and
+	// larger responses.
+	tc.client.Select("inbox")
+	tc.transactf("ok", "append inbox () {%d+}\r\n%s", len(exampleMsg), exampleMsg)
+	tc.transactf("ok", "noop")
+	tc.transactf("ok", "fetch 1 rfc822.size")
+	tc.xuntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchRFC822Size(len(exampleMsg))}})
+	tc.transactf("ok", "uid fetch 1 body.peek[]")
+}
@@ -580,6 +580,7 @@ func testQresync(t *testing.T, tc *testconn, clientModseq int64) {
 		upermflags,
 		imapclient.UntaggedList{Separator: '/', Mailbox: "Inbox"},
 		imapclient.UntaggedResult{Status: imapclient.OK, RespText: imapclient.RespText{Code: "UIDNEXT", CodeArg: imapclient.CodeUint{Code: "UIDNEXT", Num: 7}, More: "x"}},
+		imapclient.UntaggedResult{Status: imapclient.OK, RespText: imapclient.RespText{Code: "MAILBOXID", CodeArg: imapclient.CodeMailboxID("M1"), More: "x"}},
 		imapclient.UntaggedResult{Status: imapclient.OK, RespText: imapclient.RespText{Code: "UIDVALIDITY", CodeArg: imapclient.CodeUint{Code: "UIDVALIDITY", Num: 1}, More: "x"}},
 		imapclient.UntaggedResult{Status: imapclient.OK, RespText: imapclient.RespText{Code: "UNSEEN", CodeArg: imapclient.CodeUint{Code: "UNSEEN", Num: 1}, More: "x"}},
 		imapclient.UntaggedRecent(0),
@@ -25,18 +25,22 @@ import (
 // functions to handle fetch attribute requests are defined on fetchCmd.
 type fetchCmd struct {
 	conn            *conn
+	account         *store.Account // Of the mailbox, differs from conn.account for shared mailboxes.
 	mailboxID       int64
+	virtual         bool // Mailbox is virtual, UIDs are message IDs.
 	uid             store.UID
 	tx              *bstore.Tx     // Writable tx, for storing message when first parsed as mime parts.
+	readonlyTx      bool           // Tx is read-only, for notifications about new messages. Previews are not stored.
 	changes         []store.Change // For updated Seen flag.
 	markSeen        bool
 	needFlags       bool
-	needModseq      bool                // Whether untagged responses needs modseq.
-	expungeIssued   bool                // Set if a message cannot be read. Can happen for expunged messages.
-	modseq          store.ModSeq        // Initialized on first change, for marking messages as seen.
-	isUID           bool                // If this is a UID FETCH command.
-	hasChangedSince bool                // Whether CHANGEDSINCE was set. Enables MODSEQ in response.
-	deltaCounts     store.MailboxCounts // By marking \Seen, the number of unread/unseen messages will go down. We update counts at the end.
+	needModseq      bool                          // Whether untagged responses needs modseq.
+	expungeIssued   bool                          // Set if a message cannot be read. Can happen for expunged messages.
+	modseq          store.ModSeq                  // Initialized on first change, for marking messages as seen.
+	isUID           bool                          // If this is a UID FETCH command.
+	hasChangedSince bool                          // Whether CHANGEDSINCE was set. Enables MODSEQ in response.
+	peekOnly        bool                          // Never mark messages as seen, for notifications about new messages, and for shared mailboxes without the right to change the seen flag.
+	deltaCounts     map[int64]store.MailboxCounts // Per mailbox. By marking \Seen, the number of unread/unseen messages will go down. We update counts at the end.
 
 	// Loaded when first needed, closed when message was processed.
 	m    *store.Message // Message currently being processed.
@@ -80,6 +84,7 @@ func (c *conn) cmdxFetch(isUID bool, tag, cmdstr string, p *parser) {
 	var changedSince int64
 	var haveChangedSince bool
 	var vanished bool
+	var partial *partialRange
 	if p.space() {
 		// ../rfc/4466:542
 		// ../rfc/7162:2479
@@ -89,9 +94,9 @@ func (c *conn) cmdxFetch(isUID bool, tag, cmdstr string, p *parser) {
 			var w string
 			if isUID && p.conn.enabled[capQresync] {
 				// Vanished only valid for uid fetch, and only for qresync. ../rfc/7162:1693
-				w = p.xtakelist("CHANGEDSINCE", "VANISHED")
+				w = p.xtakelist("CHANGEDSINCE", "VANISHED", "PARTIAL")
 			} else {
-				w = p.xtakelist("CHANGEDSINCE")
+				w = p.xtakelist("CHANGEDSINCE", "PARTIAL")
 			}
 			if seen[w] {
 				xsyntaxErrorf("duplicate fetch modifier %s", w)
@@ -111,6 +116,11 @@ func (c *conn) cmdxFetch(isUID bool, tag, cmdstr string, p *parser) {
 				haveChangedSince = true
 			case "VANISHED":
 				vanished = true
+			case "PARTIAL":
+				// Only fetch the range of the requested messages. ../rfc/9394
+				p.xspace()
+				pr := p.xpartialRange()
+				partial = &pr
 			}
 			if p.take(")") {
 				break
@@ -125,23 +135,32 @@ func (c *conn) cmdxFetch(isUID bool, tag, cmdstr string, p *parser) {
 	}
 	p.xempty()
 
-	// We don't use c.account.WithRLock because we write to the client while reading messages.
+	// Virtual mailboxes don't have mod-sequences. ../rfc/7162
+	if c.virtual != nil && haveChangedSince {
+		xsyntaxErrorf("no CHANGEDSINCE in virtual mailbox without mod-sequences")
+	}
+
+	// We don't use c.mbAccount.WithRLock because we write to the client while reading messages.
 	// We get the rlock, then we check the mailbox, release the lock and read the messages.
 	// The db transaction still locks out any changes to the database...
-	c.account.RLock()
-	runlock := c.account.RUnlock
+	c.mbAccount.RLock()
+	runlock := c.mbAccount.RUnlock
 	// Note: we call runlock in a closure because we replace it below.
 	defer func() {
 		runlock()
 	}()
 
 	var vanishedUIDs []store.UID
-	cmd := &fetchCmd{conn: c, mailboxID: c.mailboxID, isUID: isUID, hasChangedSince: haveChangedSince}
-	c.xdbwrite(func(tx *bstore.Tx) {
+	cmd := &fetchCmd{conn: c, account: c.mbAccount, mailboxID: c.mailboxID, virtual: c.virtual != nil, isUID: isUID, hasChangedSince: haveChangedSince, deltaCounts: map[int64]store.MailboxCounts{}}
+	c.xmbdbwrite(func(tx *bstore.Tx) {
 		cmd.tx = tx
 
 		// Ensure the mailbox still exists.
-		mb := c.xmailboxID(tx, c.mailboxID)
+		c.xcheckSelected(tx)
+
+		// ../rfc/4314
+		rights := c.xselectedRights(tx, "r")
+		cmd.peekOnly = !rights.Has("s")
 
 		var uids []store.UID
 
@@ -174,10 +193,18 @@ func (c *conn) cmdxFetch(isUID bool, tag, cmdstr string, p *parser) {
 		} else {
 			uids = c.xnumSetUIDs(isUID, nums)
 		}
+		if partial != nil {
+			if changedSince > 0 {
+				sort.Slice(uids, func(i, j int) bool {
+					return uids[i] < uids[j]
+				})
+			}
+			uids = partial.apply(uids)
+		}
 
 		// Send vanished for all missing requested UIDs. ../rfc/7162:1718
 		if vanished {
-			delModSeq, err := c.account.HighestDeletedModSeq(tx)
+			delModSeq, err := c.mbAccount.HighestDeletedModSeq(tx)
 			xcheckf(err, "looking up highest deleted modseq")
 			if changedSince < delModSeq.Client() {
 				// First sort the uids we already found, for fast lookup.
@@ -238,8 +265,12 @@ func (c *conn) cmdxFetch(isUID bool, tag, cmdstr string, p *parser) {
 		}
 
 		var zeromc store.MailboxCounts
-		if cmd.deltaCounts != zeromc {
-			mb.Add(cmd.deltaCounts) // Unseen/Unread will be <= 0.
+		for mbID, delta := range cmd.deltaCounts {
+			if delta == zeromc {
+				continue
+			}
+			mb := c.xmailboxID(tx, mbID)
+			mb.Add(delta) // Unseen/Unread will be <= 0.
 			err := tx.Update(&mb)
 			xcheckf(err, "updating mailbox counts")
 			cmd.changes = append(cmd.changes, mb.ChangeCounts())
@@ -249,7 +280,7 @@ func (c *conn) cmdxFetch(isUID bool, tag, cmdstr string, p *parser) {
 
 	if len(cmd.changes) > 0 {
 		// Broadcast seen updates to other connections.
-		c.broadcast(cmd.changes)
+		c.broadcastSelected(cmd.changes)
 	}
 
 	if cmd.expungeIssued {
@@ -263,7 +294,7 @@ func (c *conn) cmdxFetch(isUID bool, tag, cmdstr string, p *parser) {
 func (cmd *fetchCmd) xmodseq() store.ModSeq {
 	if cmd.modseq == 0 {
 		var err error
-		cmd.modseq, err = cmd.conn.account.NextModSeq(cmd.tx)
+		cmd.modseq, err = cmd.account.NextModSeq(cmd.tx)
 		cmd.xcheckf(err, "assigning next modseq")
 	}
 	return cmd.modseq
@@ -275,7 +306,11 @@ func (cmd *fetchCmd) xensureMessage() *store.Message {
 	}
 
 	q := bstore.QueryTx[store.Message](cmd.tx)
-	q.FilterNonzero(store.Message{MailboxID: cmd.mailboxID, UID: cmd.uid})
+	if cmd.virtual {
+		q.FilterID(int64(cmd.uid))
+	} else {
+		q.FilterNonzero(store.Message{MailboxID: cmd.mailboxID, UID: cmd.uid})
+	}
 	q.FilterEqual("Expunged", false)
 	m, err := q.Get()
 	cmd.xcheckf(err, "get message for uid %d", cmd.uid)
@@ -290,7 +325,7 @@ func (cmd *fetchCmd) xensureParsed() (*store.MsgReader, *message.Part) {
 
 	m := cmd.xensureMessage()
 
-	cmd.msgr = cmd.conn.account.MessageReader(*m)
+	cmd.msgr = cmd.account.MessageReader(*m)
 	defer func() {
 		if cmd.part == nil {
 			err := cmd.msgr.Close()
@@ -343,10 +378,12 @@ func (cmd *fetchCmd) process(atts []fetchAtt) {
 
 	if cmd.markSeen {
 		m := cmd.xensureMessage()
-		cmd.deltaCounts.Sub(m.MailboxCounts())
+		delta := cmd.deltaCounts[m.MailboxID]
+		delta.Sub(m.MailboxCounts())
 		origFlags := m.Flags
 		m.Seen = true
-		cmd.deltaCounts.Add(m.MailboxCounts())
+		delta.Add(m.MailboxCounts())
+		cmd.deltaCounts[m.MailboxID] = delta
 		m.ModSeq = cmd.xmodseq()
 		err := cmd.tx.Update(m)
 		xcheckf(err, "marking message as seen")
@@ -487,6 +524,49 @@ func (cmd *fetchCmd) xprocessAtt(a fetchAtt) []token {
 	case "MODSEQ":
 		cmd.needModseq = true
 
+	case "EMAILID":
+		// ../rfc/8474
+		m := cmd.xensureMessage()
+		return []token{bare("EMAILID"), listspace{bare(emailObjectID(m.ID))}}
+
+	case "THREADID":
+		// Messages added before threading was enabled for the account don't have a thread
+		// ID yet. ../rfc/8474
+		m := cmd.xensureMessage()
+		if m.ThreadID == 0 {
+			return []token{bare("THREADID"), nilt}
+		}
+		return []token{bare("THREADID"), listspace{bare(threadObjectID(m.ThreadID))}}
+
+	case "PREVIEW":
+		// ../rfc/8970
+		m := cmd.xensureMessage()
+		if m.Preview == nil {
+			_, p := cmd.xensureParsed()
+			s, err := p.Preview(cmd.conn.log.Logger)
+			if err != nil {
+				cmd.conn.log.Infox("generating preview, continuing with partial preview", err, slog.Any("uid", cmd.uid))
+			}
+			m.Preview = &s
+			// Fetches for notifications are done in a read-only transaction, the preview will
+			// be stored on a next regular fetch. The preview is also stored when we cannot
+			// change the seen flag, e.g. in a shared mailbox. Accounts that encrypt messages
+			// don't store previews.
+			if !cmd.readonlyTx && cmd.account.StoreMessageText() {
+				err := cmd.tx.Update(m)
+				xcheckf(err, "storing preview")
+			}
+		}
+		return []token{bare("PREVIEW"), string0(*m.Preview)}
+
+	case "SAVEDATE":
+		// Messages saved before the save date was kept don't have one. ../rfc/8514
+		m := cmd.xensureMessage()
+		if m.SaveDate == nil {
+			return []token{bare("SAVEDATE"), nilt}
+		}
+		return []token{bare("SAVEDATE"), dquote(m.SaveDate.Format("_2-Jan-2006 15:04:05 -0700"))}
+
 	default:
 		xserverErrorf("field %q not yet implemented", a.field)
 	}
@@ -562,7 +642,7 @@ func xenvelope(p *message.Part) token {
 }
 
 func (cmd *fetchCmd) peekOrSeen(peek bool) {
-	if cmd.conn.readonly || peek {
+	if cmd.conn.readonly || peek || cmd.peekOnly {
 		return
 	}
 	m := cmd.xensureMessage()
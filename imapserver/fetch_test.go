@@ -295,6 +295,15 @@ func TestFetch(t *testing.T) {
 	tc.transactf("ok", "uid fetch 2:2 bodystructure")
 	tc.xuntagged(imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{uid2, bodystructure2}})
 
+	// PARTIAL fetch modifier, only a range of the requested messages. ../rfc/9394
+	tc.transactf("ok", "uid fetch 1:* bodystructure (partial -1:-1)")
+	tc.xuntagged(imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{uid2, bodystructure2}})
+	tc.transactf("ok", "fetch 1:* bodystructure (partial 1:1)")
+	tc.xuntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{uid1, bodystructure1}})
+	tc.transactf("ok", "fetch 1:* bodystructure (partial 3:10)")
+	tc.xuntagged()
+	tc.transactf("bad", "fetch 1:* bodystructure (partial 1:-1)")
+
 	// todo: read the bodies/headers of the parts, and of the nested message.
 	tc.transactf("ok", "fetch 2 body.peek[]")
 	tc.xuntagged(imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{uid2, imapclient.FetchBody{RespAttr: "BODY[]", Body: nestedMessage}}})
@@ -11,7 +11,10 @@ import (
 )
 
 // LIST command, for listing mailboxes with various attributes, including about subscriptions and children.
-// We don't have flags Marked, Unmarked, NoSelect and NoInferiors and we don't have REMOTE mailboxes.
+// We don't have flags Marked, Unmarked and NoInferiors and we don't have REMOTE mailboxes.
+// Mailboxes shared with us by other accounts are listed in the "#Other Users"
+// namespace, with NoSelect for the levels above them. Saved searches are listed
+// as virtual mailboxes under "#Virtual", which has NoSelect.
 //
 // State: Authenticated and selected.
 func (c *conn) cmdList(tag, cmd string, p *parser) {
@@ -118,11 +121,32 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 	re := xmailboxPatternMatcher(reference, patterns)
 	var responseLines []string
 
+	// Shared mailboxes we can see, by name in our namespace. Status for shared
+	// mailboxes is gathered before locking our own account, it requires a
+	// transaction on the other account.
+	shared := map[string]store.SharedMailbox{}
+	sharedStatus := map[string]string{}
+	sharedList, err := store.SharedMailboxes(c.log, c.account.Name)
+	xcheckf(err, "listing shared mailboxes")
+	for _, sm := range sharedList {
+		name := otherUsersName(sm.Account, sm.Mailbox.Name)
+		if !sm.Rights.Has("l") {
+			continue
+		}
+		shared[name] = sm
+		if retStatusAttrs != nil && sm.Rights.Has("r") && re.MatchString(name) {
+			sharedStatus[name] = c.xsharedStatusLine(sm, retStatusAttrs)
+		}
+	}
+
 	c.account.WithRLock(func() {
 		c.xdbread(func(tx *bstore.Tx) {
 			type info struct {
 				mailbox    *store.Mailbox
 				subscribed bool
+				shared     bool               // Mailbox of other account.
+				noselect   bool               // Level above shared or virtual mailboxes.
+				virtual    *store.SavedSearch // Virtual mailbox.
 			}
 			names := map[string]info{}
 			hasSubscribedChild := map[string]bool{}
@@ -140,6 +164,35 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 			})
 			xcheckf(err, "listing mailboxes")
 
+			for name, sm := range shared {
+				mb := sm.Mailbox
+				names[name] = info{mailbox: &mb, shared: true}
+				nameList = append(nameList, name)
+				for p := path.Dir(name); p != "."; p = path.Dir(p) {
+					hasChild[p] = true
+					_, isShared := shared[p]
+					if _, ok := names[p]; !ok && !isShared {
+						names[p] = info{noselect: true}
+						nameList = append(nameList, p)
+					}
+				}
+			}
+
+			qss := bstore.QueryTx[store.SavedSearch](tx)
+			err = qss.ForEach(func(ss store.SavedSearch) error {
+				name := store.VirtualPrefix + ss.Name
+				names[name] = info{virtual: &ss}
+				nameList = append(nameList, name)
+				p := path.Dir(name)
+				hasChild[p] = true
+				if _, ok := names[p]; !ok {
+					names[p] = info{noselect: true}
+					nameList = append(nameList, p)
+				}
+				return nil
+			})
+			xcheckf(err, "listing saved searches")
+
 			qs := bstore.QueryTx[store.Subscription](tx)
 			err = qs.ForEach(func(sub store.Subscription) error {
 				info, ok := names[sub.Name]
@@ -174,9 +227,12 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 						flags = append(flags, bare(`\NonExistent`))
 					}
 				}
-				if (info.mailbox == nil || listSubscribed) && flags == nil && extended == nil {
+				if (info.mailbox == nil && info.virtual == nil && !info.noselect || listSubscribed) && flags == nil && extended == nil {
 					continue
 				}
+				if info.noselect {
+					flags = append(flags, bare(`\Noselect`))
+				}
 
 				if retChildren {
 					var f string
@@ -190,7 +246,8 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 				if !listSubscribed && retSubscribed && info.subscribed {
 					flags = append(flags, bare(`\Subscribed`))
 				}
-				if info.mailbox != nil {
+				// Special-use flags of shared mailboxes are not for us.
+				if info.mailbox != nil && !info.shared {
 					if info.mailbox.Archive {
 						flags = append(flags, bare(`\Archive`))
 					}
@@ -207,6 +264,9 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 						flags = append(flags, bare(`\Trash`))
 					}
 				}
+				if info.virtual != nil && info.virtual.All {
+					flags = append(flags, bare(`\All`))
+				}
 
 				var extStr string
 				if extended != nil {
@@ -215,8 +275,14 @@ func (c *conn) cmdList(tag, cmd string, p *parser) {
 				line := fmt.Sprintf(`* LIST %s "/" %s%s`, flags.pack(c), astring(c.encodeMailbox(name)).pack(c), extStr)
 				responseLines = append(responseLines, line)
 
-				if retStatusAttrs != nil && info.mailbox != nil {
+				if retStatusAttrs != nil && info.shared {
+					if line, ok := sharedStatus[name]; ok {
+						responseLines = append(responseLines, line)
+					}
+				} else if retStatusAttrs != nil && info.mailbox != nil {
 					responseLines = append(responseLines, c.xstatusLine(tx, *info.mailbox, retStatusAttrs))
+				} else if retStatusAttrs != nil && info.virtual != nil {
+					responseLines = append(responseLines, c.xvirtualStatusLine(tx, *info.virtual, retStatusAttrs))
 				}
 			}
 		})
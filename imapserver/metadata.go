@@ -0,0 +1,289 @@
+package imapserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/store"
+)
+
+// Limits for METADATA annotations. The value size is reported with MAXSIZE. The
+// number of annotations and their total size are for the whole account, both
+// mailbox and server annotations, exceeding them results in TOOMANY. Private
+// entries of other accounts on shared mailboxes are counted for those accounts.
+const (
+	metadataMaxValueSize = 64 * 1024
+	metadataMaxKeys      = 1000
+	metadataMaxTotalSize = 1024 * 1024 // Sum of entry names and values.
+)
+
+// xcheckMetadataEntry checks the syntax of an entry name and returns it in lower
+// case, entry names are case-insensitive. For GETMETADATA, the top-level entries
+// "/private" and "/shared" are allowed, for retrieving all entries with DEPTH.
+// ../rfc/5464
+func xcheckMetadataEntry(name string, set bool) string {
+	lname := strings.ToLower(name)
+	if !set && (lname == "/private" || lname == "/shared") {
+		return lname
+	}
+	if !strings.HasPrefix(lname, "/private/") && !strings.HasPrefix(lname, "/shared/") {
+		xsyntaxErrorf("entry name must start with /private/ or /shared/")
+	}
+	if strings.HasSuffix(lname, "/") || strings.Contains(lname, "//") {
+		xsyntaxErrorf("entry name cannot end with slash or contain consecutive slashes")
+	}
+	for _, c := range lname {
+		if c <= ' ' || c >= 0x7f || c == '*' || c == '%' {
+			xsyntaxErrorf("invalid character %q in entry name", c)
+		}
+	}
+	return lname
+}
+
+// metadataMatch returns whether key is requested through entry with depth "0",
+// "1" or "INFINITY".
+func metadataMatch(key, entry, depth string) bool {
+	if key == entry {
+		return true
+	}
+	if depth == "0" || !strings.HasPrefix(key, entry+"/") {
+		return false
+	}
+	return depth == "INFINITY" || !strings.Contains(key[len(entry)+1:], "/")
+}
+
+// annotationAccount returns the value for Annotation.Account for entries we
+// access in a mailbox of account acc: empty for /shared entries and for our own
+// mailboxes, and our account name for /private entries on mailboxes shared with
+// us.
+func (c *conn) annotationAccount(acc *store.Account, key string) string {
+	if acc == c.account || strings.HasPrefix(key, "/shared/") {
+		return ""
+	}
+	return c.account.Name
+}
+
+// Getmetadata returns annotations for a mailbox, or server annotations for
+// the empty mailbox name. For mailboxes shared with us, the private entries are
+// our own, not those of the owner.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdGetmetadata(tag, cmd string, p *parser) {
+	// Command: ../rfc/5464
+	// Examples: ../rfc/5464
+	// Request syntax: ../rfc/5464
+
+	p.xspace()
+	maxSize := int64(-1)
+	depth := "0"
+	if p.take("(") {
+		seen := map[string]bool{}
+		for {
+			w := p.xtakelist("MAXSIZE", "DEPTH")
+			if seen[w] {
+				xsyntaxErrorf("duplicate option %s", w)
+			}
+			seen[w] = true
+			p.xspace()
+			switch w {
+			case "MAXSIZE":
+				maxSize = p.xnumber64()
+			case "DEPTH":
+				depth = p.xtakelist("0", "1", "INFINITY")
+			}
+			if p.take(")") {
+				break
+			}
+			p.xspace()
+		}
+		p.xspace()
+	}
+	name := p.xmailbox()
+	p.xspace()
+	entries := p.xmetadataEntries()
+	p.xempty()
+
+	for i, e := range entries {
+		entries[i] = xcheckMetadataEntry(e, false)
+	}
+
+	var annotations []store.Annotation
+	var longest int
+	get := func(tx *bstore.Tx, acc *store.Account, mailboxID int64) {
+		q := bstore.QueryTx[store.Annotation](tx)
+		q.FilterEqual("MailboxID", mailboxID)
+		q.SortAsc("Key")
+		err := q.ForEach(func(a store.Annotation) error {
+			// Only our own private entries on a shared mailbox.
+			if a.Account != c.annotationAccount(acc, a.Key) {
+				return nil
+			}
+			var match bool
+			for _, e := range entries {
+				if metadataMatch(a.Key, e, depth) {
+					match = true
+					break
+				}
+			}
+			if !match {
+				return nil
+			}
+			if maxSize >= 0 && int64(len(a.Value)) > maxSize {
+				if len(a.Value) > longest {
+					longest = len(a.Value)
+				}
+				return nil
+			}
+			annotations = append(annotations, a)
+			return nil
+		})
+		xcheckf(err, "looking up annotations")
+	}
+	if name == "" {
+		c.account.WithRLock(func() {
+			c.xdbread(func(tx *bstore.Tx) {
+				get(tx, c.account, 0)
+			})
+		})
+	} else {
+		// Reading annotations of a mailbox requires the read right.
+		name = c.xaclMailbox(name, false, "r", func(tx *bstore.Tx, acc *store.Account, mb store.Mailbox, rights store.Rights) {
+			get(tx, acc, mb.ID)
+		})
+	}
+
+	// Response syntax: ../rfc/5464
+	if len(annotations) > 0 {
+		l := listspace{}
+		for _, a := range annotations {
+			var v token
+			if a.IsString {
+				v = string0(a.Value)
+			} else {
+				v = literal8(a.Value)
+			}
+			l = append(l, astring(a.Key), v)
+		}
+		fmt.Fprintf(c.bw, "* METADATA %s ", astring(c.encodeMailbox(name)).pack(c))
+		l.writeTo(c, c.bw)
+		c.bw.Write([]byte("\r\n"))
+	}
+
+	if longest > 0 {
+		// ../rfc/5464
+		c.writeresultf("%s OK [METADATA LONGENTRIES %d] %s done", tag, longest, cmd)
+	} else {
+		c.ok(tag, cmd)
+	}
+}
+
+// Setmetadata sets or removes annotations for a mailbox, or server annotations for
+// the empty mailbox name.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdSetmetadata(tag, cmd string, p *parser) {
+	// Command: ../rfc/5464
+	// Examples: ../rfc/5464
+	// Request syntax: ../rfc/5464
+
+	p.xspace()
+	name := p.xmailbox()
+	p.xspace()
+	p.xtake("(")
+	var l []store.Annotation
+	for {
+		key := xcheckMetadataEntry(p.xastring(), true)
+		p.xspace()
+		value, isString := p.xmetadataValue()
+		l = append(l, store.Annotation{Key: key, IsString: isString, Value: value})
+		if p.take(")") {
+			break
+		}
+		p.xspace()
+	}
+	p.xempty()
+
+	for _, a := range l {
+		if len(a.Value) > metadataMaxValueSize {
+			// ../rfc/5464
+			xusercodeErrorf(fmt.Sprintf("METADATA MAXSIZE %d", metadataMaxValueSize), "value too large")
+		}
+	}
+
+	// Changes are broadcast to the sessions of the account of the mailbox. Our private
+	// entries on a shared mailbox are not visible to that account, so not broadcast.
+	set := func(tx *bstore.Tx, acc *store.Account, mailboxID int64, mailboxName string) (changes []store.Change) {
+		for _, a := range l {
+			a.Account = c.annotationAccount(acc, a.Key)
+
+			q := bstore.QueryTx[store.Annotation](tx)
+			q.FilterEqual("MailboxID", mailboxID)
+			q.FilterEqual("Account", a.Account)
+			q.FilterNonzero(store.Annotation{Key: a.Key})
+			_, err := q.Delete()
+			xcheckf(err, "removing existing annotation")
+
+			// A nil value removes the annotation. ../rfc/5464
+			if a.Value != nil {
+				a.MailboxID = mailboxID
+				err := tx.Insert(&a)
+				xcheckf(err, "inserting annotation")
+			}
+			if a.Account == "" {
+				changes = append(changes, store.ChangeAnnotation{MailboxID: mailboxID, MailboxName: mailboxName, Key: a.Key})
+			}
+		}
+
+		// Check the limits for the whole account, or for our private entries in the
+		// other account. Returning an error rolls back the changes.
+		var n, size int
+		err := bstore.QueryTx[store.Annotation](tx).ForEach(func(a store.Annotation) error {
+			if acc != c.account && a.Account != c.account.Name {
+				return nil
+			}
+			n++
+			size += len(a.Key) + len(a.Value)
+			return nil
+		})
+		xcheckf(err, "checking annotation limits")
+		if n > metadataMaxKeys || size > metadataMaxTotalSize {
+			// ../rfc/5464
+			xusercodeErrorf("METADATA TOOMANY", "too many annotations")
+		}
+		return changes
+	}
+
+	if name == "" {
+		c.account.WithWLock(func() {
+			var changes []store.Change
+			c.xdbwrite(func(tx *bstore.Tx) {
+				changes = set(tx, c.account, 0, "")
+			})
+			c.broadcast(changes)
+		})
+	} else {
+		// Setting private entries requires the read right, like reading. Setting shared
+		// entries on a mailbox of another account also requires the write right.
+		need := "r"
+		for _, a := range l {
+			if strings.HasPrefix(a.Key, "/shared/") {
+				need = "rw"
+			}
+		}
+		acc, mbName, closeAcc := c.xmailboxAccount(name, true)
+		defer closeAcc()
+		acc.WithWLock(func() {
+			var changes []store.Change
+			xdbwriteAccount(acc, func(tx *bstore.Tx) {
+				mb, rights := c.xmailboxRights(tx, acc, mbName, "NONEXISTENT")
+				xcheckRights(rights, need)
+				changes = set(tx, acc, mb.ID, mb.Name)
+			})
+			c.broadcastAccount(acc, changes)
+		})
+	}
+
+	c.ok(tag, cmd)
+}
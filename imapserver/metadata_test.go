@@ -0,0 +1,180 @@
+package imapserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestMetadata(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+
+	tc.client.Login("mjl@mox.example", "testtest")
+
+	tc.transactf("ok", `getmetadata "" /private/comment`)
+	tc.xuntagged()
+
+	tc.transactf("ok", `getmetadata inbox (/private/comment /shared/comment)`)
+	tc.xuntagged()
+
+	tc.transactf("bad", `getmetadata`)                                    // Missing parameters.
+	tc.transactf("bad", `getmetadata "" /other`)                          // Must start with /private/ or /shared/.
+	tc.transactf("bad", `getmetadata "" /private/a//b`)                   // Consecutive slashes.
+	tc.transactf("bad", `getmetadata "" /private/a/`)                     // Trailing slash.
+	tc.transactf("bad", `getmetadata "" /private/*`)                      // Wildcard.
+	tc.transactf("bad", `getmetadata (depth 2) "" /private/comment`)      // Bad depth.
+	tc.transactf("bad", `getmetadata (depth 0 depth 0) "" /private/x`)    // Duplicate option.
+	tc.transactf("bad", `setmetadata "" (/private)`)                      // Missing value.
+	tc.transactf("bad", `setmetadata "" (/private "x")`)                  // Only top-level entry.
+	tc.transactf("no", `getmetadata bogus /private/comment`)              // Mailbox does not exist.
+	tc.transactf("no", `setmetadata bogus (/private/comment "test")`)     // Mailbox does not exist.
+	tc.transactf("bad", `setmetadata "" (/private/comment "test") extra`) // Leftover data.
+
+	tc.transactf("ok", `setmetadata "" (/PRIVATE/COMMENT "global" /shared/comment "shared")`)
+	tc.transactf("ok", `setmetadata inbox (/private/comment "mailbox value" /private/vendor/a/b "binary" /private/vendor/a/b/c ~{3+}`+"\r\n"+"a\x00b"+`)`)
+
+	tc.transactf("ok", `getmetadata "" /private/comment`)
+	tc.xuntagged(imapclient.UntaggedMetadataAnnotations{
+		Mailbox: "",
+		Annotations: []imapclient.Annotation{
+			{Key: "/private/comment", IsString: true, Value: []byte("global")},
+		},
+	})
+
+	tc.transactf("ok", `getmetadata (depth infinity) "" /shared`)
+	tc.xuntagged(imapclient.UntaggedMetadataAnnotations{
+		Mailbox: "",
+		Annotations: []imapclient.Annotation{
+			{Key: "/shared/comment", IsString: true, Value: []byte("shared")},
+		},
+	})
+
+	tc.transactf("ok", `getmetadata (depth 1) inbox /private/vendor/a`)
+	tc.xuntagged(imapclient.UntaggedMetadataAnnotations{
+		Mailbox: "Inbox",
+		Annotations: []imapclient.Annotation{
+			{Key: "/private/vendor/a/b", IsString: true, Value: []byte("binary")},
+		},
+	})
+
+	tc.transactf("ok", `getmetadata (depth infinity) inbox /private/vendor`)
+	tc.xuntagged(imapclient.UntaggedMetadataAnnotations{
+		Mailbox: "Inbox",
+		Annotations: []imapclient.Annotation{
+			{Key: "/private/vendor/a/b", IsString: true, Value: []byte("binary")},
+			{Key: "/private/vendor/a/b/c", IsString: false, Value: []byte("a\x00b")},
+		},
+	})
+
+	// Values larger than MAXSIZE are left out, and the largest size is returned.
+	tc.transactf("ok", `getmetadata (maxsize 4) inbox (/private/comment /private/vendor/a/b/c)`)
+	tc.xcodeArg(imapclient.CodeOther{Code: "METADATA", Args: []string{"LONGENTRIES", "13"}})
+	tc.xuntagged(imapclient.UntaggedMetadataAnnotations{
+		Mailbox: "Inbox",
+		Annotations: []imapclient.Annotation{
+			{Key: "/private/vendor/a/b/c", IsString: false, Value: []byte("a\x00b")},
+		},
+	})
+
+	// Remove annotation.
+	tc.transactf("ok", `setmetadata inbox (/private/comment nil)`)
+	tc.transactf("ok", `getmetadata inbox /private/comment`)
+	tc.xuntagged()
+
+	// Value too large, with sync literal, rejected before reading the literal.
+	tc.client.Commandf("", "setmetadata inbox (/private/comment {%d}", metadataMaxValueSize+1)
+	tc.response("no")
+	tc.xcodeArg(imapclient.CodeOther{Code: "METADATA", Args: []string{"MAXSIZE", "65536"}})
+
+	// Value too large, with non-sync literal.
+	large := strings.Repeat("x", metadataMaxValueSize+1)
+	tc.transactf("no", "setmetadata inbox (/private/comment {%d+}\r\n%s)", len(large), large)
+	tc.xcodeArg(imapclient.CodeOther{Code: "METADATA", Args: []string{"MAXSIZE", "65536"}})
+
+	// Too many annotations, exceeding the total size.
+	value := strings.Repeat("x", metadataMaxValueSize)
+	var cmd string
+	for i := 0; i < metadataMaxTotalSize/metadataMaxValueSize+1; i++ {
+		cmd += " /private/large" + string(rune('a'+i)) + " {" + "65536+}\r\n" + value
+	}
+	tc.transactf("no", "setmetadata inbox (%s)", cmd[1:])
+	tc.xcodeArg(imapclient.CodeOther{Code: "METADATA", Args: []string{"TOOMANY"}})
+
+	// Annotations are removed with the mailbox, and are kept with a rename.
+	tc.transactf("ok", "create a")
+	tc.transactf("ok", `setmetadata a (/private/comment "a")`)
+	tc.transactf("ok", "rename a b")
+	tc.transactf("ok", `getmetadata b /private/comment`)
+	tc.xuntagged(imapclient.UntaggedMetadataAnnotations{
+		Mailbox:     "b",
+		Annotations: []imapclient.Annotation{{Key: "/private/comment", IsString: true, Value: []byte("a")}},
+	})
+	tc.transactf("ok", "delete b")
+	tc.transactf("ok", "create b")
+	tc.transactf("ok", `getmetadata b /private/comment`)
+	tc.xuntagged()
+
+	// Changes are sent with NOTIFY.
+	tc2 := startNoSwitchboard(t)
+	defer tc2.close()
+	tc2.client.Login("mjl@mox.example", "testtest")
+	tc2.transactf("ok", "notify set (personal (mailboxmetadatachange servermetadatachange))")
+
+	tc.transactf("ok", `setmetadata inbox (/private/comment "x")`)
+	tc.transactf("ok", `setmetadata "" (/private/comment "x")`)
+	tc2.transactf("ok", "noop")
+	tc2.xuntagged(
+		imapclient.UntaggedMetadataKeys{Mailbox: "Inbox", Keys: []string{"/private/comment"}},
+		imapclient.UntaggedMetadataKeys{Mailbox: "", Keys: []string{"/private/comment"}},
+	)
+}
+
+// Private entries on a shared mailbox are per account, shared entries are
+// visible to all.
+func TestMetadataShared(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+	tc.client.Login("mjl@mox.example", "testtest")
+
+	tc2 := startArgs(t, false, false, true, true, "other")
+	defer tc2.close()
+	tc2.client.Login("other@mox.example", "testtest")
+
+	const shared = "#Other Users/mjl/Inbox"
+
+	tc2.transactf("no", `getmetadata "%s" /private/comment`, shared) // Not shared yet.
+
+	tc.transactf("ok", "setacl inbox other lr")
+	tc.transactf("ok", `setmetadata inbox (/private/comment "owner" /shared/comment "owner shared")`)
+
+	tc2.transactf("ok", `getmetadata "%s" (/private/comment /shared/comment)`, shared)
+	tc2.xuntagged(imapclient.UntaggedMetadataAnnotations{
+		Mailbox:     shared,
+		Annotations: []imapclient.Annotation{{Key: "/shared/comment", IsString: true, Value: []byte("owner shared")}},
+	})
+
+	tc2.transactf("ok", `setmetadata "%s" (/private/comment "other")`, shared)
+	tc2.transactf("no", `setmetadata "%s" (/shared/comment "other shared")`, shared) // Needs write right.
+	tc2.transactf("ok", `getmetadata "%s" /private/comment`, shared)
+	tc2.xuntagged(imapclient.UntaggedMetadataAnnotations{
+		Mailbox:     shared,
+		Annotations: []imapclient.Annotation{{Key: "/private/comment", IsString: true, Value: []byte("other")}},
+	})
+
+	// Owner still sees its own private entry.
+	tc.transactf("ok", `getmetadata inbox /private/comment`)
+	tc.xuntagged(imapclient.UntaggedMetadataAnnotations{
+		Mailbox:     "Inbox",
+		Annotations: []imapclient.Annotation{{Key: "/private/comment", IsString: true, Value: []byte("owner")}},
+	})
+
+	tc.transactf("ok", "setacl inbox other lrw")
+	tc2.transactf("ok", `setmetadata "%s" (/shared/comment "other shared")`, shared)
+	tc.transactf("ok", `getmetadata inbox /shared/comment`)
+	tc.xuntagged(imapclient.UntaggedMetadataAnnotations{
+		Mailbox:     "Inbox",
+		Annotations: []imapclient.Annotation{{Key: "/shared/comment", IsString: true, Value: []byte("other shared")}},
+	})
+}
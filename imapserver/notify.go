@@ -0,0 +1,424 @@
+package imapserver
+
+import (
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+// notify holds the configuration of a NOTIFY SET command, for sending
+// notifications about changes, also while no command is executing. ../rfc/5465
+type notify struct {
+	// For the "selected" or "selected-delayed" filter, nil if absent.
+	selected *eventGroup
+
+	// Other event groups, in order of the command. The first matching group applies
+	// to a mailbox.
+	groups []eventGroup
+}
+
+// event returns the event from the group, or nil if absent. Group can be nil.
+func (eg *eventGroup) event(name string) *notifyEvent {
+	if eg == nil {
+		return nil
+	}
+	for i := range eg.events {
+		if eg.events[i].name == name {
+			return &eg.events[i]
+		}
+	}
+	return nil
+}
+
+// selectedEvent returns the event for the "selected" filter, or nil if absent.
+// Can be called on a nil notify.
+func (n *notify) selectedEvent(name string) *notifyEvent {
+	if n == nil {
+		return nil
+	}
+	return n.selected.event(name)
+}
+
+// serverEvent returns whether any of the event groups for mailboxes has the
+// event, for events that are not about a mailbox.
+func (n *notify) serverEvent(name string) bool {
+	for i := range n.groups {
+		if n.groups[i].event(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the first event group that matches the mailbox, or nil if none
+// matches. The selected mailbox is not matched against the "selected" filters,
+// callers handle it. Subscribed is called to check if a mailbox is subscribed.
+func (n *notify) match(c *conn, name string, subscribed func(name string) bool) *eventGroup {
+	for i := range n.groups {
+		eg := &n.groups[i]
+		switch eg.filter {
+		case "PERSONAL":
+			// All mailboxes are in the personal namespace.
+			return eg
+		case "INBOXES":
+			if c.isDeliveryMailbox(name) {
+				return eg
+			}
+		case "SUBSCRIBED":
+			if subscribed(name) {
+				return eg
+			}
+		case "SUBTREE":
+			for _, s := range eg.mailboxes {
+				if name == s || strings.HasPrefix(name, s+"/") {
+					return eg
+				}
+			}
+		case "MAILBOXES":
+			for _, s := range eg.mailboxes {
+				if name == s {
+					return eg
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isDeliveryMailbox returns whether messages can be delivered to the mailbox,
+// i.e. whether it is the Inbox or a mailbox configured for a destination of the
+// account. Used for the "inboxes" filter of NOTIFY. ../rfc/5465
+func (c *conn) isDeliveryMailbox(name string) bool {
+	if name == "Inbox" {
+		return true
+	}
+	conf, _ := c.account.Conf()
+	for _, dest := range conf.Destinations {
+		if dest.Mailbox == name {
+			return true
+		}
+		for _, rs := range dest.Rulesets {
+			if rs.Mailbox == name || rs.AcceptRejectsToMailbox == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// notifyStatusAttrs returns the attributes for STATUS responses sent as
+// notification about message changes in a mailbox other than the selected
+// mailbox. ../rfc/5465
+func (c *conn) notifyStatusAttrs() []string {
+	attrs := []string{"MESSAGES", "UIDNEXT", "UIDVALIDITY", "UNSEEN"}
+	if c.enabled[capCondstore] {
+		attrs = append(attrs, "HIGHESTMODSEQ")
+	}
+	return attrs
+}
+
+// xsubscribedFunc returns a function that checks if a mailbox is subscribed.
+// Subscriptions are read from tx on first use.
+func xsubscribedFunc(tx *bstore.Tx) func(name string) bool {
+	var subscribed map[string]bool
+	return func(name string) bool {
+		if subscribed == nil {
+			subscribed = map[string]bool{}
+			err := bstore.QueryTx[store.Subscription](tx).ForEach(func(sub store.Subscription) error {
+				subscribed[sub.Name] = true
+				return nil
+			})
+			xcheckf(err, "listing subscriptions")
+		}
+		return subscribed[name]
+	}
+}
+
+// notifyFilter returns the changes the client should be notified about, and
+// STATUS responses for message changes in mailboxes other than the selected
+// mailbox. Changes for the selected mailbox are kept, except flag changes if the
+// client did not ask for them. ../rfc/5465
+func (c *conn) notifyFilter(changes []store.Change) (keep []store.Change, statusLines []string) {
+	n := c.notify
+
+	c.account.WithRLock(func() {
+		c.xdbread(func(tx *bstore.Tx) {
+			subscribed := xsubscribedFunc(tx)
+
+			mailboxEvent := func(name, event string) bool {
+				return n.match(c, name, subscribed).event(event) != nil
+			}
+
+			// Mailboxes with message changes, with the events that happened.
+			events := map[int64]map[string]bool{}
+			var mailboxIDs []int64
+
+			for _, change := range changes {
+				var mbID int64
+				var event string
+				switch ch := change.(type) {
+				case store.ChangeAddUID:
+					mbID, event = ch.MailboxID, "MESSAGENEW"
+				case store.ChangeRemoveUIDs:
+					mbID, event = ch.MailboxID, "MESSAGEEXPUNGE"
+				case store.ChangeFlags:
+					mbID, event = ch.MailboxID, "FLAGCHANGE"
+				case store.ChangeRemoveMailbox:
+					if mailboxEvent(ch.Name, "MAILBOXNAME") {
+						keep = append(keep, change)
+					}
+					continue
+				case store.ChangeAddMailbox:
+					if mailboxEvent(ch.Mailbox.Name, "MAILBOXNAME") {
+						keep = append(keep, change)
+					}
+					continue
+				case store.ChangeRenameMailbox:
+					if mailboxEvent(ch.OldName, "MAILBOXNAME") || mailboxEvent(ch.NewName, "MAILBOXNAME") {
+						keep = append(keep, change)
+					}
+					continue
+				case store.ChangeAddSubscription:
+					if mailboxEvent(ch.Name, "SUBSCRIPTIONCHANGE") {
+						keep = append(keep, change)
+					}
+					continue
+				case store.ChangeRemoveSubscription:
+					if mailboxEvent(ch.Name, "SUBSCRIPTIONCHANGE") {
+						keep = append(keep, change)
+					}
+					continue
+				case store.ChangeAnnotation:
+					if ch.MailboxID != 0 && mailboxEvent(ch.MailboxName, "MAILBOXMETADATACHANGE") || ch.MailboxID == 0 && n.serverEvent("SERVERMETADATACHANGE") {
+						keep = append(keep, change)
+					}
+					continue
+				default:
+					keep = append(keep, change)
+					continue
+				}
+
+				if c.isSelected(c.account, mbID) {
+					// New and expunged messages must always be processed to keep the session
+					// consistent.
+					if event != "FLAGCHANGE" || n.selected == nil || n.selected.event(event) != nil {
+						keep = append(keep, change)
+					}
+					continue
+				}
+				if events[mbID] == nil {
+					events[mbID] = map[string]bool{}
+					mailboxIDs = append(mailboxIDs, mbID)
+				}
+				events[mbID][event] = true
+			}
+
+			for _, mbID := range mailboxIDs {
+				mb := store.Mailbox{ID: mbID}
+				if err := tx.Get(&mb); err == bstore.ErrAbsent {
+					continue
+				} else {
+					xcheckf(err, "get mailbox")
+				}
+				eg := n.match(c, mb.Name, subscribed)
+				for event := range events[mbID] {
+					if eg.event(event) != nil {
+						statusLines = append(statusLines, c.xstatusLine(tx, mb, c.notifyStatusAttrs()))
+						break
+					}
+				}
+			}
+		})
+	})
+	return
+}
+
+// xnotifyStatus writes STATUS responses for all mailboxes the client asked for
+// message events for, for NOTIFY SET STATUS. ../rfc/5465
+func (c *conn) xnotifyStatus() {
+	var lines []string
+	c.account.WithRLock(func() {
+		c.xdbread(func(tx *bstore.Tx) {
+			subscribed := xsubscribedFunc(tx)
+			err := bstore.QueryTx[store.Mailbox](tx).ForEach(func(mb store.Mailbox) error {
+				if c.isSelected(c.account, mb.ID) {
+					return nil
+				}
+				if c.notify.match(c, mb.Name, subscribed).event("MESSAGENEW") != nil {
+					lines = append(lines, c.xstatusLine(tx, mb, c.notifyStatusAttrs()))
+				}
+				return nil
+			})
+			xcheckf(err, "listing mailboxes")
+		})
+	})
+	for _, line := range lines {
+		c.bwritelinef("%s", line)
+	}
+}
+
+// xnotifyFetch writes FETCH responses with the attributes from the MessageNew
+// event for new messages in the selected mailbox. ../rfc/5465
+func (c *conn) xnotifyFetch(uids []store.UID, atts []fetchAtt) {
+	cmd := &fetchCmd{conn: c, account: c.mbAccount, mailboxID: c.mailboxID, virtual: c.virtual != nil, peekOnly: true, readonlyTx: true, hasChangedSince: c.enabled[capCondstore]}
+	c.xmbdbread(func(tx *bstore.Tx) {
+		cmd.tx = tx
+		for _, uid := range uids {
+			cmd.uid = uid
+			cmd.process(atts)
+		}
+	})
+}
+
+// xnotifyWait waits for the next command from the client, meanwhile writing
+// notifications about changes as requested with NOTIFY. ../rfc/5465
+func (c *conn) xnotifyWait() {
+	line := c.lineChan()
+	for {
+		select {
+		case le := <-line:
+			// Put the line back for readline, the goroutine reading it is done.
+			line <- le
+			return
+		case <-c.comm.Pending:
+			now, held := c.notifyHold(c.account, c.comm.Get())
+			c.comm.Unget(held)
+			c.applyChanges(now, false)
+			c.xflush()
+		case <-c.sharedPending():
+			now, held := c.notifyHold(c.mbAccount, c.mbComm.Get())
+			c.mbComm.Unget(held)
+			c.applySharedChanges(now, false)
+			c.xflush()
+		case <-mox.Shutdown.Done():
+			// ../rfc/9051:5375
+			c.writelinef("* BYE shutting down")
+			panic(errIO)
+		}
+	}
+}
+
+// notifyHold splits changes of account acc into changes to send now, and changes
+// for the selected mailbox to hold back until the next command.
+//
+// Changes for the selected mailbox are only sent outside of commands when the
+// client asked for them. With "selected-delayed", expunges, and changes after
+// them, are held back until a command is executed, so message sequence numbers
+// don't change unexpectedly. ../rfc/5465
+func (c *conn) notifyHold(acc *store.Account, changes []store.Change) (now, held []store.Change) {
+	for _, change := range changes {
+		var mbID int64
+		var expunge bool
+		switch ch := change.(type) {
+		case store.ChangeAddUID:
+			mbID = ch.MailboxID
+		case store.ChangeRemoveUIDs:
+			mbID, expunge = ch.MailboxID, true
+		case store.ChangeFlags:
+			mbID = ch.MailboxID
+		}
+		sel := c.notify.selected
+		if c.isSelected(acc, mbID) && (len(held) > 0 || sel == nil || len(sel.events) == 0 || expunge && sel.filter == "SELECTED-DELAYED") {
+			held = append(held, change)
+		} else {
+			now = append(now, change)
+		}
+	}
+	return now, held
+}
+
+// Notify configures which notifications about changes to messages and mailboxes
+// are sent to the client, also while no command is executing.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdNotify(tag, cmd string, p *parser) {
+	// Command: ../rfc/5465
+	// Examples: ../rfc/5465
+	// Request syntax: ../rfc/5465
+
+	p.xspace()
+	if p.take("NONE") {
+		p.xempty()
+		c.notify = nil
+		c.ok(tag, cmd)
+		return
+	}
+
+	p.xtake("SET")
+	status := p.take(" STATUS")
+	p.xspace()
+	groups := []eventGroup{p.xeventGroup()}
+	for !p.empty() {
+		p.xspace()
+		groups = append(groups, p.xeventGroup())
+	}
+
+	n := &notify{}
+	var badEvent bool
+	for _, eg := range groups {
+		eg := eg
+		events := map[string]bool{}
+		for i, ev := range eg.events {
+			switch ev.name {
+			case "MESSAGENEW", "MESSAGEEXPUNGE", "FLAGCHANGE", "MAILBOXNAME", "SUBSCRIPTIONCHANGE", "MAILBOXMETADATACHANGE", "SERVERMETADATACHANGE":
+			default:
+				// E.g. AnnotationChange, or unknown events.
+				badEvent = true
+			}
+			if events[ev.name] {
+				xsyntaxErrorf("duplicate event %s", ev.name)
+			}
+			events[ev.name] = true
+
+			if len(ev.fetchAtts) > 0 {
+				if eg.filter != "SELECTED" && eg.filter != "SELECTED-DELAYED" {
+					xsyntaxErrorf("fetch attributes only allowed for selected mailbox")
+				}
+				// We always include the flags, like with regular new message notifications.
+				var haveFlags bool
+				for _, a := range ev.fetchAtts {
+					haveFlags = haveFlags || a.field == "FLAGS"
+				}
+				if !haveFlags {
+					eg.events[i].fetchAtts = append(ev.fetchAtts, fetchAtt{field: "FLAGS"})
+				}
+			}
+		}
+		// ../rfc/5465
+		if events["MESSAGENEW"] != events["MESSAGEEXPUNGE"] {
+			xsyntaxErrorf("MessageNew and MessageExpunge must be specified together")
+		}
+		if events["FLAGCHANGE"] && !events["MESSAGENEW"] {
+			xsyntaxErrorf("FlagChange requires MessageNew and MessageExpunge")
+		}
+
+		switch eg.filter {
+		case "SELECTED", "SELECTED-DELAYED":
+			if n.selected != nil {
+				xsyntaxErrorf("duplicate selected filter")
+			}
+			if events["MAILBOXNAME"] || events["SUBSCRIPTIONCHANGE"] || events["MAILBOXMETADATACHANGE"] || events["SERVERMETADATACHANGE"] {
+				xsyntaxErrorf("mailbox events not allowed for selected mailbox")
+			}
+			n.selected = &eg
+		default:
+			for i, name := range eg.mailboxes {
+				eg.mailboxes[i] = xcheckmailboxname(name, true)
+			}
+			n.groups = append(n.groups, eg)
+		}
+	}
+	if badEvent {
+		// ../rfc/5465
+		xusercodeErrorf("BADEVENT (MessageNew MessageExpunge FlagChange MailboxName SubscriptionChange MailboxMetadataChange ServerMetadataChange)", "unsupported event")
+	}
+
+	c.notify = n
+	if status {
+		c.xnotifyStatus()
+	}
+	c.ok(tag, cmd)
+}
@@ -0,0 +1,136 @@
+package imapserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestNotify(t *testing.T) {
+	defer mockUIDValidity()()
+	tc := start(t)
+	defer tc.close()
+	tc.client.Login("mjl@mox.example", "testtest")
+	tc.client.Select("inbox")
+
+	// Check for some syntax errors.
+	tc.transactf("bad", "Notify")
+	tc.transactf("bad", "Notify bogus")
+	tc.transactf("bad", "Notify None ")                                                              // Leftover data.
+	tc.transactf("bad", "Notify Set")                                                                // Missing event groups.
+	tc.transactf("bad", "Notify Set Status")                                                         // Missing event groups.
+	tc.transactf("bad", "Notify Set (bogus (messagenew messageexpunge))")                            // Unknown filter.
+	tc.transactf("bad", "Notify Set (personal (messagenew))")                                        // MessageExpunge missing.
+	tc.transactf("bad", "Notify Set (personal (flagchange))")                                        // MessageNew and MessageExpunge missing.
+	tc.transactf("bad", "Notify Set (personal (messagenew messagenew messageexpunge))")              // Duplicate event.
+	tc.transactf("bad", "Notify Set (personal (messagenew (rfc822.size) messageexpunge))")           // Fetch attributes only for selected.
+	tc.transactf("bad", "Notify Set (selected (mailboxname))")                                       // No mailbox events for selected.
+	tc.transactf("bad", "Notify Set (selected (messagenew messageexpunge)) (selected-delayed none)") // Duplicate selected.
+
+	tc.transactf("no", "Notify Set (personal (annotationchange))")
+	tc.xcode("BADEVENT")
+	tc.transactf("no", "Notify Set (personal (messagenew messageexpunge bogus))")
+	tc.xcode("BADEVENT")
+
+	tc.transactf("ok", "Notify None")
+	tc.transactf("ok", "Notify Set (personal none)")
+
+	// With STATUS, we get a STATUS response for mailboxes other than the selected mailbox.
+	tc.transactf("ok", "Notify Set Status (personal (messagenew messageexpunge))")
+	if len(tc.lastUntagged) == 0 {
+		t.Fatalf("no untagged responses for notify set status")
+	}
+	for _, ut := range tc.lastUntagged {
+		var st imapclient.UntaggedStatus
+		tuntagged(t, ut, &st)
+		if st.Mailbox == "Inbox" {
+			t.Fatalf("got status for selected mailbox")
+		}
+	}
+	tc.transactf("ok", "Notify Set Status (mailboxes Archive (messagenew messageexpunge))")
+	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "Archive", Attrs: map[string]int64{"MESSAGES": 0, "UIDNEXT": 1, "UIDVALIDITY": 1, "UNSEEN": 0}})
+
+	tc2 := startNoSwitchboard(t)
+	defer tc2.close()
+	tc2.client.Login("mjl@mox.example", "testtest")
+	tc2.client.Select("inbox")
+
+	// Read an untagged response sent while no command is executing.
+	xreadUntagged := func(exp imapclient.Untagged) {
+		t.Helper()
+		err := tc.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		tc.check(err, "set read deadline")
+		ut, err := tc.client.ReadUntagged()
+		tc.check(err, "read untagged")
+		if !reflect.DeepEqual(ut, exp) {
+			t.Fatalf("got untagged %#v, expected %#v", ut, exp)
+		}
+		err = tc.conn.SetReadDeadline(time.Time{})
+		tc.check(err, "clear read deadline")
+	}
+
+	tc.transactf("ok", "Notify Set (selected (messagenew (rfc822.size) messageexpunge flagchange)) (subtree Archive (messagenew messageexpunge)) (personal (mailboxname subscriptionchange))")
+
+	// New message in selected mailbox, with requested attributes.
+	tc2.transactf("ok", "append inbox () {%d+}\r\n%s", len(exampleMsg), exampleMsg)
+	xreadUntagged(imapclient.UntaggedExists(1))
+	xreadUntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchRFC822Size(len(exampleMsg)), imapclient.FetchFlags(nil)}})
+
+	// Flag change in selected mailbox.
+	tc2.transactf("ok", `store 1 +flags (\Seen)`)
+	xreadUntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchFlags{`\Seen`}}})
+
+	// New message in other mailbox, we get a STATUS.
+	tc2.transactf("ok", "append Archive () {%d+}\r\n%s", len(exampleMsg), exampleMsg)
+	xreadUntagged(imapclient.UntaggedStatus{Mailbox: "Archive", Attrs: map[string]int64{"MESSAGES": 1, "UIDNEXT": 2, "UIDVALIDITY": 1, "UNSEEN": 1}})
+
+	// New message in mailbox we did not ask message events for, no notification. The
+	// mailbox creation is announced.
+	tc2.transactf("ok", "create other")
+	xreadUntagged(imapclient.UntaggedList{Flags: []string{`\Subscribed`}, Separator: '/', Mailbox: "other"})
+	tc2.transactf("ok", "append other () {%d+}\r\n%s", len(exampleMsg), exampleMsg)
+
+	tc2.transactf("ok", "unsubscribe other")
+	xreadUntagged(imapclient.UntaggedList{Separator: '/', Mailbox: "other"})
+
+	tc2.transactf("ok", "rename other renamed")
+	xreadUntagged(imapclient.UntaggedList{Separator: '/', Mailbox: "renamed", OldName: "other"})
+
+	// Expunge in selected mailbox.
+	tc2.transactf("ok", `store 1 +flags (\Deleted)`)
+	xreadUntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchFlags{`\Seen`, `\Deleted`}}})
+	tc2.transactf("ok", "expunge")
+	xreadUntagged(imapclient.UntaggedExpunge(1))
+
+	// With selected-delayed, expunges are only sent with a command.
+	tc.transactf("ok", "Notify Set (selected-delayed (messagenew messageexpunge))")
+	tc2.transactf("ok", "append inbox () {%d+}\r\n%s", len(exampleMsg), exampleMsg)
+	xreadUntagged(imapclient.UntaggedExists(1))
+	xreadUntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(2), imapclient.FetchFlags(nil)}})
+	tc2.transactf("ok", `store 1 +flags (\Deleted)`) // Flag changes were not requested.
+	tc2.transactf("ok", "expunge")
+	tc.transactf("ok", "noop")
+	tc.xuntagged(imapclient.UntaggedExpunge(1))
+
+	// Without selected filter, changes for the selected mailbox are only sent with a
+	// command.
+	tc.transactf("ok", "Notify Set (subtree Archive (messagenew messageexpunge))")
+	tc2.transactf("ok", "append inbox () {%d+}\r\n%s", len(exampleMsg), exampleMsg)
+	tc2.transactf("ok", "append Archive () {%d+}\r\n%s", len(exampleMsg), exampleMsg)
+	xreadUntagged(imapclient.UntaggedStatus{Mailbox: "Archive", Attrs: map[string]int64{"MESSAGES": 2, "UIDNEXT": 3, "UIDVALIDITY": 1, "UNSEEN": 2}})
+	tc.transactf("ok", "noop")
+	tc.xuntagged(
+		imapclient.UntaggedExists(1),
+		imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(3), imapclient.FetchFlags(nil)}},
+	)
+
+	tc.transactf("ok", "Notify None")
+	tc2.transactf("ok", "append inbox () {%d+}\r\n%s", len(exampleMsg), exampleMsg)
+	tc.transactf("ok", "noop")
+	tc.xuntagged(
+		imapclient.UntaggedExists(2),
+		imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(4), imapclient.FetchFlags(nil)}},
+	)
+}
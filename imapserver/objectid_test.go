@@ -0,0 +1,60 @@
+package imapserver
+
+import (
+	"testing"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestObjectID(t *testing.T) {
+	defer mockUIDValidity()()
+	tc := start(t)
+	defer tc.close()
+
+	tc.client.Login("mjl@mox.example", "testtest")
+
+	// CREATE returns the new mailbox ID, which is kept when renaming.
+	tc.transactf("ok", "create a")
+	mbid, ok := tc.lastResult.CodeArg.(imapclient.CodeMailboxID)
+	if !ok {
+		t.Fatalf("create: got code %v, expected MAILBOXID", tc.lastResult.CodeArg)
+	}
+	tc.transactf("ok", "rename a b")
+	tc.transactf("ok", "status b (mailboxid messages)")
+	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "b", Attrs: map[string]int64{"MESSAGES": 0}, MailboxID: string(mbid)})
+
+	// A new mailbox with the same name gets a new ID.
+	tc.transactf("ok", "delete b")
+	tc.transactf("ok", "create b")
+	tc.xcode("MAILBOXID")
+	if tc.lastResult.CodeArg == mbid {
+		t.Fatalf("recreated mailbox has same mailbox id %q", mbid)
+	}
+
+	// Both messages have the same subject and are in the same thread.
+	tc.client.Append("inbox", nil, nil, []byte(exampleMsg))
+	tc.client.Append("inbox", nil, nil, []byte(exampleMsg))
+	tc.client.Select("inbox")
+
+	tc.transactf("ok", "fetch 1:2 (emailid threadid)")
+	tc.xuntagged(
+		imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchEmailID("E1"), imapclient.FetchThreadID("T1")}},
+		imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(2), imapclient.FetchEmailID("E2"), imapclient.FetchThreadID("T1")}},
+	)
+
+	tc.transactf("ok", "uid search emailid E2")
+	tc.xsearch(2)
+	tc.transactf("ok", "uid search threadid T1")
+	tc.xsearch(1, 2)
+	tc.transactf("ok", "uid search emailid T1") // Wrong type of object id.
+	tc.xsearch()
+	tc.transactf("ok", "uid search emailid bogus")
+	tc.xsearch()
+	tc.transactf("bad", "uid search emailid (E1)") // Not an objectid.
+
+	// The email ID stays the same after moving, so clients can recognize the message.
+	tc.transactf("ok", "uid move 2 Archive")
+	tc.client.Select("Archive")
+	tc.transactf("ok", "uid fetch 1 emailid")
+	tc.xuntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchEmailID("E2")}})
+}
@@ -93,6 +93,18 @@ func (t syncliteral) writeTo(c *conn, w io.Writer) {
 	w.Write([]byte(t))
 }
 
+// literal8 is a literal that can contain any octet, including NUL. ../rfc/3516:357
+type literal8 string
+
+func (t literal8) pack(c *conn) string {
+	return fmt.Sprintf("~{%d}\r\n", len(t)) + string(t)
+}
+
+func (t literal8) writeTo(c *conn, w io.Writer) {
+	fmt.Fprintf(w, "~{%d}\r\n", len(t))
+	w.Write([]byte(t))
+}
+
 // data from reader with known size.
 type readerSizeSyncliteral struct {
 	r    io.Reader
@@ -17,6 +17,7 @@ var (
 	respSpecials   = "]"
 	atomChar       = charRemove(char, "(){ "+ctl+listWildcards+quotedSpecials+respSpecials)
 	astringChar    = atomChar + respSpecials
+	objectidChar   = charRange('a', 'z') + charRange('A', 'Z') + charRange('0', '9') + "_-"
 )
 
 func charRange(first, last rune) string {
@@ -390,6 +391,15 @@ func (p *parser) xatom() string {
 	return p.xtakechars(atomChar, "atom")
 }
 
+// ../rfc/8474
+func (p *parser) xobjectid() string {
+	s := p.xtakechars(objectidChar, "objectid")
+	if len(s) > 255 {
+		p.xerrorf("objectid too long")
+	}
+	return s
+}
+
 func (p *parser) xdecodeMailbox(s string) string {
 	// UTF-7 is deprecated for IMAP4rev2-only clients, and not used with UTF8=ACCEPT.
 	// The future should be without UTF-7, we don't encode/decode it with modern
@@ -436,9 +446,9 @@ func (p *parser) xmboxOrPat() ([]string, bool) {
 	return l, true
 }
 
-// ../rfc/9051:7056, RECENT ../rfc/3501:5047, APPENDLIMIT ../rfc/7889:252, HIGHESTMODSEQ ../rfc/7162:2452
+// ../rfc/9051:7056, RECENT ../rfc/3501:5047, APPENDLIMIT ../rfc/7889:252, HIGHESTMODSEQ ../rfc/7162:2452, DELETED-STORAGE ../rfc/9208, MAILBOXID ../rfc/8474
 func (p *parser) xstatusAtt() string {
-	w := p.xtakelist("MESSAGES", "UIDNEXT", "UIDVALIDITY", "UNSEEN", "DELETED", "SIZE", "RECENT", "APPENDLIMIT", "HIGHESTMODSEQ")
+	w := p.xtakelist("MESSAGES", "UIDNEXT", "UIDVALIDITY", "UNSEEN", "DELETED-STORAGE", "DELETED", "SIZE", "RECENT", "APPENDLIMIT", "HIGHESTMODSEQ", "MAILBOXID")
 	if w == "HIGHESTMODSEQ" {
 		// HIGHESTMODSEQ is a CONDSTORE-enabling parameter. ../rfc/7162:375
 		p.conn.enabled[capCondstore] = true
@@ -573,7 +583,10 @@ func (p *parser) xsectionBinary() (r []uint32) {
 var fetchAttWords = []string{
 	"ENVELOPE", "FLAGS", "INTERNALDATE", "RFC822.SIZE", "BODYSTRUCTURE", "UID", "BODY.PEEK", "BODY", "BINARY.PEEK", "BINARY.SIZE", "BINARY",
 	"RFC822.HEADER", "RFC822.TEXT", "RFC822", // older IMAP
-	"MODSEQ", // CONDSTORE extension.
+	"MODSEQ",              // CONDSTORE extension.
+	"EMAILID", "THREADID", // OBJECTID extension.
+	"PREVIEW",  // PREVIEW extension.
+	"SAVEDATE", // SAVEDATE extension.
 }
 
 // ../rfc/9051:6557 ../rfc/3501:4751 ../rfc/7162:2483
@@ -605,6 +618,18 @@ func (p *parser) xfetchAtt(isUID bool) (r fetchAtt) {
 		// The wording about when to respond with a MODSEQ attribute could be more clear. ../rfc/7162:923 ../rfc/7162:388
 		// MODSEQ attribute is a CONDSTORE-enabling parameter. ../rfc/7162:377
 		p.conn.xensureCondstore(nil)
+	case "PREVIEW":
+		// Previews are generated when requested and stored, they are always available, so
+		// the LAZY modifier is accepted but has no effect. ../rfc/8970
+		if p.take(" (") {
+			for {
+				p.xtakelist("LAZY")
+				if p.take(")") {
+					break
+				}
+				p.xspace()
+			}
+		}
 	}
 	return
 }
@@ -777,7 +802,132 @@ var searchKeyWords = []string{
 	"SENTBEFORE", "SENTON",
 	"SENTSINCE", "SMALLER",
 	"UID", "UNDRAFT",
-	"MODSEQ", // CONDSTORE extension.
+	"MODSEQ",              // CONDSTORE extension.
+	"EMAILID", "THREADID", // OBJECTID extension.
+	"SAVEDBEFORE", "SAVEDON", "SAVEDSINCE", "SAVEDATESUPPORTED", // SAVEDATE extension.
+}
+
+// ../rfc/5256 ../rfc/5957
+func (p *parser) xsortCriteria() []sortCriterion {
+	p.xtake("(")
+	var l []sortCriterion
+	for {
+		var sc sortCriterion
+		sc.reverse = p.take("REVERSE ")
+		sc.key = p.xtakelist("ARRIVAL", "CC", "DATE", "DISPLAYFROM", "DISPLAYTO", "FROM", "SIZE", "SUBJECT", "TO")
+		l = append(l, sc)
+		if p.take(")") {
+			return l
+		}
+		p.xspace()
+	}
+}
+
+// ../rfc/9394
+func (p *parser) xpartialRange() partialRange {
+	fromEnd := p.take("-")
+	first := p.xnznumber()
+	p.xtake(":")
+	if fromEnd {
+		p.xtake("-")
+	}
+	last := p.xnznumber()
+	if first > last {
+		first, last = last, first
+	}
+	return partialRange{fromEnd, first, last}
+}
+
+// ../rfc/5465
+func (p *parser) xeventGroup() eventGroup {
+	p.xtake("(")
+	var eg eventGroup
+	eg.filter = p.xtakelist("SELECTED-DELAYED", "SELECTED", "INBOXES", "PERSONAL", "SUBSCRIBED", "SUBTREE", "MAILBOXES")
+	if eg.filter == "SUBTREE" || eg.filter == "MAILBOXES" {
+		p.xspace()
+		if p.take("(") {
+			eg.mailboxes = []string{p.xmailbox()}
+			for !p.take(")") {
+				p.xspace()
+				eg.mailboxes = append(eg.mailboxes, p.xmailbox())
+			}
+		} else {
+			eg.mailboxes = []string{p.xmailbox()}
+		}
+	}
+	p.xspace()
+	if !p.take("NONE") {
+		p.xtake("(")
+		for {
+			// Unknown events are checked by the caller, they result in a BADEVENT response.
+			ev := notifyEvent{name: strings.ToUpper(p.xatom())}
+			if ev.name == "MESSAGENEW" && p.take(" (") {
+				ev.fetchAtts = []fetchAtt{p.xfetchAtt(false)}
+				for !p.take(")") {
+					p.xspace()
+					ev.fetchAtts = append(ev.fetchAtts, p.xfetchAtt(false))
+				}
+			}
+			eg.events = append(eg.events, ev)
+			if p.take(")") {
+				break
+			}
+			p.xspace()
+		}
+	}
+	p.xtake(")")
+	return eg
+}
+
+// xmetadataEntries parses a single entry name or a list of entry names, for
+// GETMETADATA. ../rfc/5464
+func (p *parser) xmetadataEntries() []string {
+	if !p.take("(") {
+		return []string{p.xastring()}
+	}
+	l := []string{p.xastring()}
+	for !p.take(")") {
+		p.xspace()
+		l = append(l, p.xastring())
+	}
+	return l
+}
+
+// xmetadataValue parses a value for SETMETADATA. A nil value means the annotation
+// must be removed. ../rfc/5464
+func (p *parser) xmetadataValue() (value []byte, isString bool) {
+	if p.take("NIL") {
+		return nil, false
+	}
+	if p.hasPrefix(`"`) {
+		return []byte(p.xstring()), true
+	}
+	isString = !p.hasPrefix("~")
+	// Larger non-sync literals are read so we can respond with a proper error after
+	// parsing the command. Sync literals that are too large are rejected before the
+	// client sends them.
+	size, sync := p.xliteralSize(1024*1024, true)
+	if sync && size > metadataMaxValueSize {
+		xusercodeErrorf(fmt.Sprintf("METADATA MAXSIZE %d", metadataMaxValueSize), "value too large")
+	}
+	s := p.conn.xreadliteral(size, sync)
+	line := p.conn.readline(false)
+	p.orig, p.upper, p.o = line, toUpper(line), 0
+	return []byte(s), isString
+}
+
+// xsearchProgram parses one or more space-separated search keys, as used at the
+// end of SEARCH, SORT and THREAD commands. The keys are returned as a single
+// top-level search key.
+func (p *parser) xsearchProgram() *searchKey {
+	sk := &searchKey{
+		searchKeys: []searchKey{*p.xsearchKey()},
+	}
+	for !p.empty() {
+		p.xspace()
+		sk.searchKeys = append(sk.searchKeys, *p.xsearchKey())
+	}
+	return sk
 }
 
 // ../rfc/9051:6923 ../rfc/3501:4957, MODSEQ ../rfc/7162:2492
@@ -901,6 +1051,16 @@ func (p *parser) xsearchKey() *searchKey {
 		sk.clientModseq = &v
 		// MODSEQ is a CONDSTORE-enabling parameter. ../rfc/7162:377
 		p.conn.enabled[capCondstore] = true
+	case "EMAILID", "THREADID":
+		// ../rfc/8474
+		p.xspace()
+		sk.atom = p.xobjectid()
+	case "SAVEDBEFORE", "SAVEDON", "SAVEDSINCE":
+		// ../rfc/8514
+		p.xspace()
+		sk.date = p.xdate()
+	case "SAVEDATESUPPORTED":
+		// ../rfc/8514
 	default:
 		p.xerrorf("missing case for op %q", sk.op)
 	}
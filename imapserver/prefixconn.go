@@ -5,7 +5,8 @@ import (
 )
 
 // prefixConn is a net.Conn with a buffer from which the first reads are satisfied.
-// used for STARTTLS where already did a buffered read of initial TLS data.
+// used for STARTTLS where already did a buffered read of initial TLS data, and for
+// COMPRESS where already did a buffered read of initial compressed data.
 type prefixConn struct {
 	prefix []byte
 	net.Conn
@@ -0,0 +1,78 @@
+package imapserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/imapclient"
+	"github.com/mjl-/mox/store"
+)
+
+func TestPreview(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+
+	tc.client.Login("mjl@mox.example", "testtest")
+	tc.client.Select("inbox")
+
+	reply := strings.ReplaceAll(`From: mjl@mox.example
+Subject: re: afternoon meeting
+Content-Type: multipart/mixed; boundary=x
+
+--x
+Content-Type: text/plain; charset=utf-8
+
+On Mon, 7 Feb 1994, Fred Foobar wrote:
+> Hello Joe, do you think we can meet at 3:30 tomorrow?
+
+Sure,   see you then. ☺
+
+-- 
+Joe
+--x
+Content-Type: text/plain
+Content-Disposition: attachment; filename=notes.txt
+
+notes
+--x--
+`, "\n", "\r\n")
+
+	tc.client.Append("inbox", nil, nil, []byte(exampleMsg))
+	tc.client.Append("inbox", nil, nil, []byte(reply))
+
+	tc.transactf("ok", "fetch 1:2 preview")
+	tc.xuntagged(
+		imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchPreview("Hello Joe, do you think we can meet at 3:30 tomorrow?")}},
+		imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(2), imapclient.FetchPreview("[...] Sure, see you then. ☺")}},
+	)
+
+	// Preview was stored, LAZY returns it too. Fetching a preview does not mark a
+	// message as read.
+	tc.transactf("ok", "fetch 1 (flags preview (lazy))")
+	tc.xuntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchPreview("Hello Joe, do you think we can meet at 3:30 tomorrow?"), imapclient.FetchFlags(nil)}})
+
+	// Without UTF8=ACCEPT, non-ASCII previews are sent as literal.
+	tc.transactf("ok", "uid fetch 2 preview")
+	tc.xuntagged(imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(2), imapclient.FetchPreview("[...] Sure, see you then. ☺")}})
+
+	tc.transactf("bad", "fetch 1 preview (bogus)")
+	tc.transactf("bad", "fetch 1 preview ()")
+
+	// Preview is also stored when fetched from a shared mailbox without the right to
+	// change the seen flag.
+	tc.client.Append("inbox", nil, nil, []byte(exampleMsg))
+	tc.transactf("ok", "setacl inbox other lr")
+	tc2 := startArgs(t, false, false, true, true, "other")
+	defer tc2.close()
+	tc2.client.Login("other@mox.example", "testtest")
+	tc2.client.Select("#Other Users/mjl/Inbox")
+	tc2.transactf("ok", "fetch 3 preview")
+	tc2.xuntagged(imapclient.UntaggedFetch{Seq: 3, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(3), imapclient.FetchPreview("Hello Joe, do you think we can meet at 3:30 tomorrow?")}})
+	m, err := bstore.QueryDB[store.Message](ctxbg, tc.account.DB).FilterNonzero(store.Message{UID: 3}).Get()
+	tcheck(t, err, "get message")
+	if m.Preview == nil {
+		t.Fatalf("preview not stored for fetch from shared mailbox")
+	}
+}
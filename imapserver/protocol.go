@@ -2,6 +2,8 @@ package imapserver
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mjl-/mox/store"
@@ -316,6 +318,60 @@ type fetchAtt struct {
 	partial       *partial
 }
 
+// sortCriterion is a key to sort messages by, for SORT.
+type sortCriterion struct {
+	reverse bool
+	key     string // Uppercase, e.g. "ARRIVAL", "DISPLAYFROM".
+}
+
+// partialRange is a 1-based range of results, for the PARTIAL search return option
+// and fetch modifier. With fromEnd, the range counts back from the last result,
+// with -1 being the last result.
+type partialRange struct {
+	fromEnd     bool
+	first, last uint32 // Inclusive, first <= last.
+}
+
+func (pr partialRange) String() string {
+	if pr.fromEnd {
+		return fmt.Sprintf("-%d:-%d", pr.first, pr.last)
+	}
+	return fmt.Sprintf("%d:%d", pr.first, pr.last)
+}
+
+// apply returns the part of l that is in the range.
+func (pr partialRange) apply(l []store.UID) []store.UID {
+	n := int64(len(l))
+	start, end := int64(pr.first)-1, int64(pr.last)
+	if pr.fromEnd {
+		start, end = n-int64(pr.last), n-int64(pr.first)+1
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > n {
+		end = n
+	}
+	if start >= end {
+		return nil
+	}
+	return l[start:end]
+}
+
+// eventGroup is a mailbox filter with the events to send notifications about,
+// for NOTIFY.
+type eventGroup struct {
+	filter    string        // Uppercase, e.g. "SELECTED-DELAYED", "PERSONAL", "SUBTREE".
+	mailboxes []string      // For SUBTREE and MAILBOXES.
+	events    []notifyEvent // Empty for NONE.
+}
+
+// notifyEvent is an event in an eventGroup.
+type notifyEvent struct {
+	name      string     // Uppercase, e.g. "MESSAGENEW".
+	fetchAtts []fetchAtt // Only for MESSAGENEW for the selected mailbox.
+}
+
 type searchKey struct {
 	// Only one of searchKeys, seqSet and op can be non-nil/non-empty.
 	searchKeys   []searchKey // In case of nested/multiple keys. Also for the top-level command.
@@ -347,3 +403,22 @@ func compactUIDSet(l []store.UID) (r numSet) {
 	}
 	return
 }
+
+// Object IDs for OBJECTID are based on database IDs, which don't change for the
+// lifetime of a mailbox (also not when renamed) or message (also not when moved).
+// The prefixes keep object IDs for different types distinct. A thread ID is the
+// message ID of the first message in the thread. ../rfc/8474
+func mailboxObjectID(id int64) string { return fmt.Sprintf("M%d", id) }
+func emailObjectID(id int64) string   { return fmt.Sprintf("E%d", id) }
+func threadObjectID(id int64) string  { return fmt.Sprintf("T%d", id) }
+func virtualObjectID(id int64) string { return fmt.Sprintf("V%d", id) }
+
+// parseObjectID returns the database ID for object ID s with prefix, or false if s
+// is not such an object ID.
+func parseObjectID(prefix, s string) (int64, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(s[len(prefix):], 10, 64)
+	return id, err == nil && id > 0
+}
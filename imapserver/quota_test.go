@@ -0,0 +1,66 @@
+package imapserver
+
+import (
+	"testing"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestQuota(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+
+	tc.client.Login("mjl@mox.example", "testtest")
+
+	tc.transactf("bad", "getquotaroot")         // Missing param.
+	tc.transactf("bad", "getquotaroot inbox x") // Leftover data.
+	tc.transactf("bad", "getquota")             // Missing param.
+	tc.transactf("bad", `getquota "" x`)        // Leftover data.
+	tc.transactf("no", `getquota ""`)           // No quota for account, so no quota root.
+	tc.transactf("no", `getquota "other"`)      // Unknown quota root.
+
+	// Account without quota, no quota roots.
+	tc.transactf("ok", "getquotaroot inbox")
+	tc.xuntagged(imapclient.UntaggedQuotaroot{Mailbox: "Inbox"})
+
+	tclimit := startArgs(t, false, false, true, true, "limit")
+	defer tclimit.close()
+	tclimit.client.Login("limit@mox.example", "testtest")
+
+	tclimit.transactf("ok", "getquotaroot inbox")
+	tclimit.xuntagged(
+		imapclient.UntaggedQuotaroot{Mailbox: "Inbox", Roots: []string{""}},
+		imapclient.UntaggedQuota{Root: "", Resources: []imapclient.QuotaResource{{Name: "STORAGE", Usage: 0, Limit: 1}}},
+	)
+
+	// Mailbox does not have to exist.
+	tclimit.transactf("ok", "getquotaroot nonexistent")
+	tclimit.xuntagged(
+		imapclient.UntaggedQuotaroot{Mailbox: "nonexistent", Roots: []string{""}},
+		imapclient.UntaggedQuota{Root: "", Resources: []imapclient.QuotaResource{{Name: "STORAGE", Usage: 0, Limit: 1}}},
+	)
+
+	tclimit.transactf("ok", "append inbox {1+}\r\nx")
+	tclimit.transactf("ok", `getquota ""`)
+	tclimit.xuntagged(imapclient.UntaggedQuota{Root: "", Resources: []imapclient.QuotaResource{{Name: "STORAGE", Usage: 1, Limit: 1}}})
+	tclimit.transactf("no", `getquota "other"`)
+
+	// Limit on number of messages.
+	tccount := startArgs(t, false, false, true, true, "limitcount")
+	defer tccount.close()
+	tccount.client.Login("limitcount@mox.example", "testtest")
+
+	tccount.transactf("ok", "getquotaroot inbox")
+	tccount.xuntagged(
+		imapclient.UntaggedQuotaroot{Mailbox: "Inbox", Roots: []string{""}},
+		imapclient.UntaggedQuota{Root: "", Resources: []imapclient.QuotaResource{{Name: "MESSAGE", Usage: 0, Limit: 1}}},
+	)
+
+	tccount.transactf("ok", "append inbox {1+}\r\nx")
+	tccount.transactf("ok", `getquota ""`)
+	tccount.xuntagged(imapclient.UntaggedQuota{Root: "", Resources: []imapclient.QuotaResource{{Name: "MESSAGE", Usage: 1, Limit: 1}}})
+
+	// Second message would take account past limit.
+	tccount.transactf("no", "append inbox {1+}\r\nx")
+	tccount.xcode("OVERQUOTA")
+}
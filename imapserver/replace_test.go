@@ -0,0 +1,71 @@
+package imapserver
+
+import (
+	"testing"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestReplace(t *testing.T) {
+	defer mockUIDValidity()()
+
+	tc := start(t)
+	defer tc.close()
+
+	tc2 := startNoSwitchboard(t)
+	defer tc2.close()
+
+	tc.client.Login("mjl@mox.example", "testtest")
+	tc.client.Select("inbox")
+	tc.transactf("ok", "append inbox (\\Seen) {1+}\r\nx {1+}\r\nx")
+
+	tc2.client.Login("mjl@mox.example", "testtest")
+	tc2.client.Select("inbox")
+
+	// Replace the first message by sequence number, in the selected mailbox.
+	tc.transactf("ok", "replace 1 inbox {1+}\r\ny")
+	tc.xuntagged(
+		imapclient.UntaggedResult{Status: imapclient.OK, RespText: imapclient.RespText{Code: "APPENDUID", CodeArg: imapclient.CodeAppendUID{UIDValidity: 1, UID: 3}, More: "replacement message"}},
+		imapclient.UntaggedExists(3),
+		imapclient.UntaggedExpunge(1),
+	)
+
+	tc2.transactf("ok", "noop")
+	tc2.xuntagged(
+		imapclient.UntaggedExpunge(1),
+		imapclient.UntaggedExists(2),
+		imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(3), imapclient.FetchFlags(nil)}},
+	)
+
+	// Replace by uid into another mailbox.
+	tc.transactf("ok", "uid replace 2 Archive (\\Seen) {1+}\r\nz")
+	tc.xuntagged(
+		imapclient.UntaggedResult{Status: imapclient.OK, RespText: imapclient.RespText{Code: "APPENDUID", CodeArg: imapclient.CodeAppendUID{UIDValidity: 1, UID: 1}, More: "replacement message"}},
+		imapclient.UntaggedExpunge(1),
+	)
+	tc.transactf("ok", "status Archive (messages)")
+	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "Archive", Attrs: map[string]int64{"MESSAGES": 1}})
+
+	// With QRESYNC, VANISHED is returned instead of EXPUNGE.
+	tc.transactf("ok", "enable qresync")
+	tc.transactf("ok", "uid replace 3 inbox {1+}\r\nw")
+	tc.xuntagged(
+		imapclient.UntaggedResult{Status: imapclient.OK, RespText: imapclient.RespText{Code: "APPENDUID", CodeArg: imapclient.CodeAppendUID{UIDValidity: 1, UID: 4}, More: "replacement message"}},
+		imapclient.UntaggedExists(2),
+		imapclient.UntaggedVanished{UIDs: xparseNumSet("3")},
+	)
+
+	tc.transactf("no", "uid replace 3 inbox {1+}\r\nx") // Message no longer exists.
+	tc.transactf("no", "replace 2 inbox {1+}\r\nx")     // Sequence number out of range.
+	tc.transactf("no", "uid replace 4 bogus {1+}\r\nx")
+	tc.xcode("TRYCREATE")
+	tc.transactf("bad", "replace 1:2 inbox x") // Only a single message.
+	tc.transactf("bad", "replace 1 inbox")     // Missing message.
+
+	// Not allowed in a read-only mailbox.
+	tc.client.Examine("inbox")
+	tc.transactf("no", "uid replace 4 inbox {1+}\r\nx")
+
+	tc.client.Unselect()
+	tc.transactf("no", "uid replace 4 inbox {1+}\r\nx") // Not in authenticated state.
+}
@@ -0,0 +1,68 @@
+package imapserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestSaveDate(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+
+	tc.client.Login("mjl@mox.example", "testtest")
+	tc.client.Select("inbox")
+
+	// Internal date is in the past, save date is now.
+	tc.transactf("ok", "append inbox \" 1-Jan-2022 10:10:00 +0100\" {%d+}\r\n%s", len(exampleMsg), exampleMsg)
+
+	xsavedate := func(seq uint32) time.Time {
+		t.Helper()
+		tc.transactf("ok", "fetch %d savedate", seq)
+		var f imapclient.UntaggedFetch
+		tuntagged(t, tc.lastUntagged[0], &f)
+		for _, a := range f.Attrs {
+			if sd, ok := a.(imapclient.FetchSaveDate); ok {
+				tm, err := time.Parse("_2-Jan-2006 15:04:05 -0700", string(sd))
+				tcheck(t, err, "parsing savedate")
+				if d := time.Since(tm); d < -time.Second || d > time.Minute {
+					t.Fatalf("savedate %v not close to now", tm)
+				}
+				return tm
+			}
+		}
+		t.Fatalf("missing savedate in fetch response %v", f)
+		return time.Time{}
+	}
+	xsavedate(1)
+
+	today := time.Now().Format("2-Jan-2006")
+	tomorrow := time.Now().Add(24 * time.Hour).Format("2-Jan-2006")
+
+	tc.transactf("ok", "search savedon %s", today)
+	tc.xsearch(1)
+	tc.transactf("ok", "search savedsince %s", today)
+	tc.xsearch(1)
+	tc.transactf("ok", "search savedbefore %s", today)
+	tc.xsearch()
+	tc.transactf("ok", "search savedbefore %s", tomorrow)
+	tc.xsearch(1)
+	tc.transactf("ok", "search savedsince %s", tomorrow)
+	tc.xsearch()
+	tc.transactf("ok", "search savedatesupported")
+	tc.xsearch(1)
+	tc.transactf("ok", "search on 1-Jan-2022")
+	tc.xsearch(1)
+
+	// Copied and moved messages get a new save date.
+	tc.transactf("ok", "copy 1 Archive")
+	tc.transactf("ok", "move 1 Trash")
+	tc.client.Select("Archive")
+	xsavedate(1)
+	tc.client.Select("Trash")
+	xsavedate(1)
+
+	tc.transactf("bad", "search savedbefore")        // Missing date.
+	tc.transactf("bad", "search savedon 2022-01-01") // Bad date.
+}
@@ -24,6 +24,7 @@ func (c *conn) cmdxSearch(isUID bool, tag, cmd string, p *parser) {
 	// We will respond with ESEARCH instead of SEARCH if "RETURN" is present or for IMAP4rev2.
 	var eargs map[string]bool // Options except SAVE. Nil means old-style SEARCH response.
 	var save bool             // For SAVE option. Kept separately for easier handling of MIN/MAX later.
+	var partial *partialRange // For PARTIAL option. ../rfc/9394
 
 	// IMAP4rev2 always returns ESEARCH, even with absent RETURN.
 	if c.enabled[capIMAP4rev2] {
@@ -34,12 +35,19 @@ func (c *conn) cmdxSearch(isUID bool, tag, cmd string, p *parser) {
 		eargs = map[string]bool{}
 
 		for !p.take(")") {
-			if len(eargs) > 0 || save {
+			if len(eargs) > 0 || save || partial != nil {
 				p.xspace()
 			}
-			if w, ok := p.takelist("MIN", "MAX", "ALL", "COUNT", "SAVE"); ok {
+			if w, ok := p.takelist("MIN", "MAX", "ALL", "COUNT", "SAVE", "PARTIAL"); ok {
 				if w == "SAVE" {
 					save = true
+				} else if w == "PARTIAL" {
+					if partial != nil {
+						xsyntaxErrorf("duplicate PARTIAL result option")
+					}
+					p.xspace()
+					pr := p.xpartialRange()
+					partial = &pr
 				} else {
 					eargs[w] = true
 				}
@@ -50,87 +58,38 @@ func (c *conn) cmdxSearch(isUID bool, tag, cmd string, p *parser) {
 		}
 	}
 	// ../rfc/4731:149 ../rfc/9051:3737
-	if eargs != nil && len(eargs) == 0 && !save {
+	if eargs != nil && len(eargs) == 0 && !save && partial == nil {
 		eargs["ALL"] = true
 	}
 
-	// If UTF8=ACCEPT is enabled, we should not accept any charset. We are a bit more
-	// relaxed (reasonable?) and still allow US-ASCII and UTF-8. ../rfc/6855:198
 	if p.take(" CHARSET ") {
-		charset := strings.ToUpper(p.xastring())
-		if charset != "US-ASCII" && charset != "UTF-8" {
-			// ../rfc/3501:2771 ../rfc/9051:3836
-			xusercodeErrorf("BADCHARSET", "only US-ASCII and UTF-8 supported")
-		}
+		xcheckSearchCharset(p.xastring())
 	}
 	p.xspace()
-	sk := &searchKey{
-		searchKeys: []searchKey{*p.xsearchKey()},
-	}
-	for !p.empty() {
-		p.xspace()
-		sk.searchKeys = append(sk.searchKeys, *p.xsearchKey())
-	}
+	sk := p.xsearchProgram()
 
 	// Even in case of error, we ensure search result is changed.
 	if save {
 		c.searchResult = []store.UID{}
 	}
 
-	// We gather word and not-word searches from the top-level, turn them
-	// into a WordSearch for a more efficient search.
-	// todo optimize: also gather them out of AND searches.
-	var textWords, textNotWords, bodyWords, bodyNotWords []string
-	n := 0
-	for _, xsk := range sk.searchKeys {
-		switch xsk.op {
-		case "BODY":
-			bodyWords = append(bodyWords, xsk.astring)
-			continue
-		case "TEXT":
-			textWords = append(textWords, xsk.astring)
-			continue
-		case "NOT":
-			switch xsk.searchKey.op {
-			case "BODY":
-				bodyNotWords = append(bodyNotWords, xsk.searchKey.astring)
-				continue
-			case "TEXT":
-				textNotWords = append(textNotWords, xsk.searchKey.astring)
-				continue
-			}
-		}
-		sk.searchKeys[n] = xsk
-		n++
-	}
-	// We may be left with an empty but non-nil sk.searchKeys, which is important for
-	// matching.
-	sk.searchKeys = sk.searchKeys[:n]
-	var bodySearch, textSearch *store.WordSearch
-	if len(bodyWords) > 0 || len(bodyNotWords) > 0 {
-		ws := store.PrepareWordSearch(bodyWords, bodyNotWords)
-		bodySearch = &ws
-	}
-	if len(textWords) > 0 || len(textNotWords) > 0 {
-		ws := store.PrepareWordSearch(textWords, textNotWords)
-		textSearch = &ws
-	}
+	bodySearch, textSearch := xsearchWords(sk)
 
 	// Note: we only hold the account rlock for verifying the mailbox at the start.
-	c.account.RLock()
-	runlock := c.account.RUnlock
+	c.mbAccount.RLock()
+	runlock := c.mbAccount.RUnlock
 	// Note: in a defer because we replace it below.
 	defer func() {
 		runlock()
 	}()
 
 	// If we only have a MIN and/or MAX, we can stop processing as soon as we
-	// have those matches.
+	// have those matches. PARTIAL needs all matches.
 	var min, max int
-	if eargs["MIN"] {
+	if eargs["MIN"] && partial == nil {
 		min = 1
 	}
-	if eargs["MAX"] {
+	if eargs["MAX"] && partial == nil {
 		max = 1
 	}
 
@@ -138,10 +97,12 @@ func (c *conn) cmdxSearch(isUID bool, tag, cmd string, p *parser) {
 	var maxModSeq store.ModSeq
 
 	var uids []store.UID
-	c.xdbread(func(tx *bstore.Tx) {
-		c.xmailboxID(tx, c.mailboxID) // Validate.
+	c.xmbdbread(func(tx *bstore.Tx) {
+		c.xcheckSelected(tx)       // Validate.
+		c.xselectedRights(tx, "r") // ../rfc/4314
 		runlock()
 		runlock = func() {}
+		c.xsearchUseWordIndex(tx, bodySearch, textSearch)
 
 		// Normal forward search when we don't have MAX only.
 		var lastIndex = -1
@@ -221,7 +182,7 @@ func (c *conn) cmdxSearch(isUID bool, tag, cmd string, p *parser) {
 		}
 
 		// No untagged ESEARCH response if nothing was requested. ../rfc/9051:4160
-		if len(eargs) > 0 {
+		if len(eargs) > 0 || partial != nil {
 			// The tag was originally a string, became an astring in IMAP4rev2, better stick to
 			// string. ../rfc/4466:707 ../rfc/5259:1163 ../rfc/9051:7087
 			resp := fmt.Sprintf(`* ESEARCH (TAG "%s")`, tag)
@@ -256,6 +217,14 @@ func (c *conn) cmdxSearch(isUID bool, tag, cmd string, p *parser) {
 			if eargs["ALL"] && len(uids) > 0 {
 				resp += fmt.Sprintf(" ALL %s", compactUIDSet(uids).String())
 			}
+			if partial != nil {
+				// ../rfc/9394
+				set := "NIL"
+				if l := partial.apply(uids); len(l) > 0 {
+					set = compactUIDSet(l).String()
+				}
+				resp += fmt.Sprintf(" PARTIAL (%s %s)", partial, set)
+			}
 
 			// Interaction between ESEARCH and CONDSTORE: ../rfc/7162:1211 ../rfc/4731:273
 			// Summary: send the highest modseq of the returned messages.
@@ -274,6 +243,152 @@ func (c *conn) cmdxSearch(isUID bool, tag, cmd string, p *parser) {
 	}
 }
 
+// xcheckSearchCharset checks the charset for a search. If UTF8=ACCEPT is
+// enabled, we should not accept any charset. We are a bit more relaxed
+// (reasonable?) and still allow US-ASCII and UTF-8. ../rfc/6855:198
+func xcheckSearchCharset(charset string) {
+	charset = strings.ToUpper(charset)
+	if charset != "US-ASCII" && charset != "UTF-8" {
+		// ../rfc/3501:2771 ../rfc/9051:3836
+		xusercodeErrorf("BADCHARSET", "only US-ASCII and UTF-8 supported")
+	}
+}
+
+// xsearchWords gathers word and not-word searches from the top-level of sk,
+// removing them from sk and turning them into a WordSearch for a more efficient
+// search.
+// todo optimize: also gather them out of AND searches.
+func xsearchWords(sk *searchKey) (bodySearch, textSearch *store.WordSearch) {
+	var textWords, textNotWords, bodyWords, bodyNotWords []string
+	n := 0
+	for _, xsk := range sk.searchKeys {
+		switch xsk.op {
+		case "BODY":
+			bodyWords = append(bodyWords, xsk.astring)
+			continue
+		case "TEXT":
+			textWords = append(textWords, xsk.astring)
+			continue
+		case "NOT":
+			switch xsk.searchKey.op {
+			case "BODY":
+				bodyNotWords = append(bodyNotWords, xsk.searchKey.astring)
+				continue
+			case "TEXT":
+				textNotWords = append(textNotWords, xsk.searchKey.astring)
+				continue
+			}
+		}
+		sk.searchKeys[n] = xsk
+		n++
+	}
+	// We may be left with an empty but non-nil sk.searchKeys, which is important for
+	// matching.
+	sk.searchKeys = sk.searchKeys[:n]
+	if len(bodyWords) > 0 || len(bodyNotWords) > 0 {
+		ws := store.PrepareWordSearch(bodyWords, bodyNotWords)
+		bodySearch = &ws
+	}
+	if len(textWords) > 0 || len(textNotWords) > 0 {
+		ws := store.PrepareWordSearch(textWords, textNotWords)
+		textSearch = &ws
+	}
+	return
+}
+
+// xsearchUseWordIndex looks up the words of the body and text searches in the
+// word index, so messages that cannot match don't have to be read.
+func (c *conn) xsearchUseWordIndex(tx *bstore.Tx, bodySearch, textSearch *store.WordSearch) {
+	for _, ws := range []*store.WordSearch{bodySearch, textSearch} {
+		if ws != nil {
+			err := ws.UseWordIndex(tx, c.mbAccount)
+			xcheckf(err, "using word index")
+		}
+	}
+}
+
+// xsearchMatchUIDs returns the UIDs of all messages in the selected mailbox
+// that match sk, in ascending order, and the highest modseq of the matching
+// messages.
+func (c *conn) xsearchMatchUIDs(tx *bstore.Tx, sk searchKey, bodySearch, textSearch *store.WordSearch, expungeIssued *bool) (uids []store.UID, maxModSeq store.ModSeq) {
+	for i, uid := range c.uids {
+		if match, modseq := c.searchMatch(tx, msgseq(i+1), uid, sk, bodySearch, textSearch, expungeIssued); match {
+			uids = append(uids, uid)
+			if modseq > maxModSeq {
+				maxModSeq = modseq
+			}
+		}
+	}
+	return
+}
+
+// xsearchMessages returns the messages in the selected mailbox matching sk, in
+// order of UID, along with the highest modseq of the matching messages.
+// Messages that are expunged while searching are left out, and expungeIssued is
+// set. For virtual mailboxes, the UID of the returned messages is set to the UID
+// in the virtual mailbox.
+func (c *conn) xsearchMessages(sk *searchKey, expungeIssued *bool) (msgs []store.Message, maxModSeq store.ModSeq) {
+	bodySearch, textSearch := xsearchWords(sk)
+
+	// Note: we only hold the account rlock for verifying the mailbox at the start.
+	c.mbAccount.RLock()
+	runlock := c.mbAccount.RUnlock
+	// Note: in a defer because we replace it below.
+	defer func() {
+		runlock()
+	}()
+
+	c.xmbdbread(func(tx *bstore.Tx) {
+		c.xcheckSelected(tx)       // Validate.
+		c.xselectedRights(tx, "r") // ../rfc/4314
+		runlock()
+		runlock = func() {}
+		c.xsearchUseWordIndex(tx, bodySearch, textSearch)
+
+		var uids []store.UID
+		uids, maxModSeq = c.xsearchMatchUIDs(tx, *sk, bodySearch, textSearch, expungeIssued)
+		if len(uids) == 0 {
+			return
+		}
+
+		uidMap := map[store.UID]struct{}{}
+		for _, uid := range uids {
+			uidMap[uid] = struct{}{}
+		}
+		q := bstore.QueryTx[store.Message](tx)
+		if c.virtual != nil {
+			ids := make([]int64, len(uids))
+			for i, uid := range uids {
+				ids[i] = int64(uid)
+			}
+			q.FilterIDs(ids)
+			q.SortAsc("ID")
+		} else {
+			q.FilterNonzero(store.Message{MailboxID: c.mailboxID})
+			q.SortAsc("UID")
+		}
+		q.FilterEqual("Expunged", false)
+		q.FilterFn(func(m store.Message) bool {
+			_, ok := uidMap[c.selectedUID(m)]
+			return ok
+		})
+		var err error
+		msgs, err = q.List()
+		xcheckf(err, "listing matching messages")
+		if c.virtual != nil {
+			// Callers use the UID in the virtual mailbox.
+			for i := range msgs {
+				msgs[i].UID = store.UID(msgs[i].ID)
+			}
+		}
+		if len(msgs) != len(uids) {
+			// ../rfc/2180:607
+			*expungeIssued = true
+		}
+	})
+	return
+}
+
 type search struct {
 	c             *conn
 	tx            *bstore.Tx
@@ -312,21 +427,21 @@ func (s *search) match(sk searchKey, bodySearch, textSearch *store.WordSearch) (
 
 	match = s.match0(sk)
 	if match && bodySearch != nil {
-		if !s.xensurePart() {
+		if !s.xensureMessage() || !bodySearch.Candidate(s.m.ID) || !s.xensurePart() {
 			match = false
 			return
 		}
 		var err error
-		match, err = bodySearch.MatchPart(s.c.log, s.p, false)
+		match, err = bodySearch.MatchMessage(s.c.log, s.tx, s.m.ID, s.p, false)
 		xcheckf(err, "search words in bodies")
 	}
 	if match && textSearch != nil {
-		if !s.xensurePart() {
+		if !s.xensureMessage() || !textSearch.Candidate(s.m.ID) || !s.xensurePart() {
 			match = false
 			return
 		}
 		var err error
-		match, err = textSearch.MatchPart(s.c.log, s.p, true)
+		match, err = textSearch.MatchMessage(s.c.log, s.tx, s.m.ID, s.p, true)
 		xcheckf(err, "search words in headers and bodies")
 	}
 	return
@@ -338,7 +453,11 @@ func (s *search) xensureMessage() bool {
 	}
 
 	q := bstore.QueryTx[store.Message](s.tx)
-	q.FilterNonzero(store.Message{MailboxID: s.c.mailboxID, UID: s.uid})
+	if s.c.virtual != nil {
+		q.FilterID(int64(s.uid))
+	} else {
+		q.FilterNonzero(store.Message{MailboxID: s.c.mailboxID, UID: s.uid})
+	}
 	m, err := q.Get()
 	if err == bstore.ErrAbsent || err == nil && m.Expunged {
 		// ../rfc/2180:607
@@ -362,7 +481,7 @@ func (s *search) xensurePart() bool {
 	}
 
 	// Closed by searchMatch after all (recursive) search.match calls are finished.
-	s.mr = s.c.account.MessageReader(s.m)
+	s.mr = s.c.mbAccount.MessageReader(s.m)
 
 	if s.m.ParsedBuf == nil {
 		s.c.log.Error("missing parsed message")
@@ -515,6 +634,35 @@ func (s *search) match0(sk searchKey) bool {
 	case "MODSEQ":
 		// ../rfc/7162:1045
 		return s.m.ModSeq.Client() >= *sk.clientModseq
+	case "EMAILID":
+		// ../rfc/8474
+		id, ok := parseObjectID("E", sk.atom)
+		return ok && s.m.ID == id
+	case "THREADID":
+		// ../rfc/8474
+		id, ok := parseObjectID("T", sk.atom)
+		return ok && s.m.ThreadID == id
+	case "SAVEDBEFORE", "SAVEDON", "SAVEDSINCE":
+		// Messages saved before the save date was kept are matched on their internal
+		// date. ../rfc/8514
+		t := s.m.Received
+		if s.m.SaveDate != nil {
+			t = *s.m.SaveDate
+		}
+		skdt := sk.date.Format("2006-01-02")
+		sdt := t.Format("2006-01-02")
+		switch sk.op {
+		case "SAVEDBEFORE":
+			return sdt < skdt
+		case "SAVEDON":
+			return sdt == skdt
+		case "SAVEDSINCE":
+			return sdt >= skdt
+		}
+		panic("missing case")
+	case "SAVEDATESUPPORTED":
+		// All mailboxes keep save dates. ../rfc/8514
+		return true
 	}
 
 	if s.p == nil {
@@ -531,7 +679,7 @@ func (s *search) match0(sk searchKey) bool {
 		// nested.
 		// todo optimize: handle deeper nested word/not-word searches more efficiently.
 		headerToo := sk.op == "TEXT"
-		match, err := store.PrepareWordSearch([]string{sk.astring}, nil).MatchPart(s.c.log, s.p, headerToo)
+		match, err := store.PrepareWordSearch([]string{sk.astring}, nil).MatchMessage(s.c.log, s.tx, s.m.ID, s.p, headerToo)
 		xcheckf(err, "word search")
 		return match
 	case "CC":
@@ -56,6 +56,13 @@ func (tc *testconn) xesearch(exp imapclient.UntaggedEsearch) {
 	tc.xuntagged(exp)
 }
 
+// esearchPartial returns the PARTIAL return data of an ESEARCH response, which
+// the client parses as an extension.
+func esearchPartial(rng, set string) imapclient.EsearchDataExt {
+	comp := imapclient.TaggedExtComp{Comps: []imapclient.TaggedExtComp{{String: rng}, {String: set}}}
+	return imapclient.EsearchDataExt{Tag: "PARTIAL", Value: imapclient.TaggedExtVal{Comp: &comp}}
+}
+
 func TestSearch(t *testing.T) {
 	tc := start(t)
 	defer tc.close()
@@ -334,6 +341,18 @@ func TestSearch(t *testing.T) {
 	tc.transactf("ok", "fetch $ (uid)")
 	tc.xuntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(5)}})
 
+	// PARTIAL. ../rfc/9394
+	tc.transactf("ok", "search return (partial 1:2) all")
+	tc.xesearch(imapclient.UntaggedEsearch{Exts: []imapclient.EsearchDataExt{esearchPartial("1:2", "1:2")}})
+
+	tc.transactf("ok", "uid search return (min partial -1:-1) all")
+	tc.xesearch(imapclient.UntaggedEsearch{UID: true, Min: 5, Exts: []imapclient.EsearchDataExt{esearchPartial("-1:-1", "7")}})
+
+	tc.transactf("ok", "search return (partial 5:6) all")
+	tc.xesearch(imapclient.UntaggedEsearch{Exts: []imapclient.EsearchDataExt{esearchPartial("5:6", "NIL")}})
+
+	tc.transactf("bad", "search return (partial 1:-2) all")
+
 	// Do a seemingly old-style search command with IMAP4rev2 enabled. We'll still get ESEARCH responses.
 	tc.client.Enable("IMAP4rev2")
 	tc.transactf("ok", `search undraft`)
@@ -371,3 +390,66 @@ func esearchall0(ss string) imapclient.NumSet {
 	}
 	return seqset
 }
+
+// Searches use the word index, which must be kept up to date for copies.
+func TestSearchWordIndex(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+	tc.client.Login("mjl@mox.example", "testtest")
+	tc.client.Append("inbox", nil, nil, []byte(exampleMsg))
+	tc.client.Append("inbox", nil, nil, []byte(searchMsg))
+	tc.client.Select("inbox")
+
+	tc.transactf("ok", `search body "plain text"`)
+	tc.xsearch(2)
+	tc.transactf("ok", `search text "plain text" body "html"`)
+	tc.xsearch(2)
+	tc.transactf("ok", `search text "Blurdybloop"`)
+	tc.xsearch(1)
+
+	tc.transactf("ok", "copy 1:2 Archive")
+	tc.client.Select("Archive")
+	tc.transactf("ok", `search body "plain text"`)
+	tc.xsearch(2)
+	tc.transactf("ok", `search text "Blurdybloop"`)
+	tc.xsearch(1)
+}
+
+func TestSearchAttachmentText(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+	tc.client.Login("mjl@mox.example", "testtest")
+
+	msg := strings.ReplaceAll(`Subject: report
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=x
+
+--x
+Content-Type: text/plain
+
+see attached
+--x
+Content-Type: application/octet-stream; name="notes.txt"
+Content-Transfer-Encoding: base64
+
+UXVhcnRlcmx5IGZpZ3VyZXM=
+--x--
+`, "\n", "\r\n")
+	tc.client.Append("inbox", nil, nil, []byte(exampleMsg))
+	tc.client.Append("inbox", nil, nil, []byte(msg))
+	tc.client.Select("inbox")
+
+	tc.transactf("ok", `search body "quarterly figures"`)
+	tc.xsearch(2)
+	tc.transactf("ok", `search text "figures" subject "report"`)
+	tc.xsearch(2)
+	tc.transactf("ok", `search not body "quarterly"`)
+	tc.xsearch(1)
+	tc.transactf("ok", `search or body "quarterly" body "bogus"`) // Nested word search.
+	tc.xsearch(2)
+
+	tc.transactf("ok", "copy 2 Archive")
+	tc.client.Select("Archive")
+	tc.transactf("ok", `search body "quarterly"`)
+	tc.xsearch(1)
+}
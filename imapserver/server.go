@@ -28,7 +28,8 @@ non-ASCII UTF-8. Until that's enabled, we do use UTF-7 for mailbox names. See
 - todo: do not return binary data for a fetch body. at least not for imap4rev1. we should be encoding it as base64?
 - todo: on expunge we currently remove the message even if other sessions still have a reference to the uid. if they try to query the uid, they'll get an error. we could be nicer and only actually remove the message when the last reference has gone. we could add a new flag to store.Message marking the message as expunged, not give new session access to such messages, and make store remove them at startup, and clean them when the last session referencing the session goes. however, it will get much more complicated. renaming messages would need special handling. and should we do the same for removed mailboxes?
 - todo: try to recover from syntax errors when the last command line ends with a }, i.e. a literal. we currently abort the entire connection. we may want to read some amount of literal data and continue with a next command.
-- todo future: more extensions: OBJECTID, MULTISEARCH, REPLACE, NOTIFY, CATENATE, MULTIAPPEND, SORT, THREAD, CREATE-SPECIAL-USE.
+- todo future: more extensions: MULTISEARCH, CREATE-SPECIAL-USE.
+- todo future: CONTEXT=SORT and CONTEXT=SEARCH, with UPDATE for results that are kept up to date. We implement the PARTIAL return option through the PARTIAL extension.
 */
 
 import (
@@ -66,7 +67,6 @@ import (
 	"github.com/mjl-/bstore"
 
 	"github.com/mjl-/mox/config"
-	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
@@ -98,6 +98,16 @@ var (
 			"result", // ok, panic, ioerror, badsyntax, servererror, usererror, error
 		},
 	)
+	metricIMAPCompressRatio = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_imap_compress_ratio",
+			Help:    "Ratio of uncompressed to compressed bytes for IMAP connections with COMPRESS=DEFLATE, observed when the connection closes.",
+			Buckets: []float64{1, 1.5, 2, 3, 4, 5, 6, 8, 10, 15, 20},
+		},
+		[]string{
+			"direction", // in, out
+		},
+	)
 )
 
 var limiterConnectionrate, limiterConnections *ratelimit.Limiter
@@ -154,12 +164,27 @@ var authFailDelay = time.Second  // After authentication failure.
 // CONDSTORE: ../rfc/7162:411
 // QRESYNC: ../rfc/7162:1323
 // STATUS=SIZE: ../rfc/8438 ../rfc/9051:8024
+// QUOTA, QUOTA=RES-STORAGE, QUOTA=RES-MESSAGE: ../rfc/9208
+// SORT, THREAD=ORDEREDSUBJECT, THREAD=REFERENCES: ../rfc/5256
+// SORT=DISPLAY: ../rfc/5957
+// ESORT: ../rfc/5267
+// NOTIFY: ../rfc/5465
+// METADATA, METADATA-SERVER: ../rfc/5464
+// COMPRESS=DEFLATE: ../rfc/4978
+// OBJECTID: ../rfc/8474
+// MULTIAPPEND: ../rfc/3502
+// CATENATE: ../rfc/4469
+// REPLACE: ../rfc/8508
+// PREVIEW: ../rfc/8970
+// SAVEDATE: ../rfc/8514
+// ACL, RIGHTS=texk: ../rfc/4314
+// PARTIAL: ../rfc/9394
 //
 // We always announce support for SCRAM PLUS-variants, also on connections without
 // TLS. The client should not be selecting PLUS variants on non-TLS connections,
 // instead opting to do the bare SCRAM variant without indicating the server claims
 // to support the PLUS variant (skipping the server downgrade detection check).
-const serverCapabilities = "IMAP4rev2 IMAP4rev1 ENABLE LITERAL+ IDLE SASL-IR BINARY UNSELECT UIDPLUS ESEARCH SEARCHRES MOVE UTF8=ACCEPT LIST-EXTENDED SPECIAL-USE LIST-STATUS AUTH=SCRAM-SHA-256-PLUS AUTH=SCRAM-SHA-256 AUTH=SCRAM-SHA-1-PLUS AUTH=SCRAM-SHA-1 AUTH=CRAM-MD5 ID APPENDLIMIT=9223372036854775807 CONDSTORE QRESYNC STATUS=SIZE"
+const serverCapabilities = "IMAP4rev2 IMAP4rev1 ENABLE LITERAL+ IDLE SASL-IR BINARY UNSELECT UIDPLUS ESEARCH SEARCHRES MOVE UTF8=ACCEPT LIST-EXTENDED SPECIAL-USE LIST-STATUS AUTH=SCRAM-SHA-256-PLUS AUTH=SCRAM-SHA-256 AUTH=SCRAM-SHA-1-PLUS AUTH=SCRAM-SHA-1 AUTH=CRAM-MD5 ID APPENDLIMIT=9223372036854775807 CONDSTORE QRESYNC STATUS=SIZE QUOTA QUOTA=RES-STORAGE QUOTA=RES-MESSAGE SORT SORT=DISPLAY THREAD=ORDEREDSUBJECT THREAD=REFERENCES ESORT NOTIFY METADATA METADATA-SERVER COMPRESS=DEFLATE OBJECTID MULTIAPPEND CATENATE REPLACE PREVIEW SAVEDATE ACL RIGHTS=texk PARTIAL"
 
 type conn struct {
 	cid               int64
@@ -172,9 +197,10 @@ type conn struct {
 	bw                *bufio.Writer      // To remote, with TLS added in case of TLS.
 	tr                *moxio.TraceReader // Kept to change trace level when reading/writing cmd/auth/data.
 	tw                *moxio.TraceWriter
-	slow              bool        // If set, reads are done with a 1 second sleep, and writes are done 1 byte at a time, to keep spammers busy.
-	lastlog           time.Time   // For printing time since previous log line.
-	tlsConfig         *tls.Config // TLS config to use for handshake.
+	compress          *compressState // If set, COMPRESS=DEFLATE is active, with tr/tw on the uncompressed data.
+	slow              bool           // If set, reads are done with a 1 second sleep, and writes are done 1 byte at a time, to keep spammers busy.
+	lastlog           time.Time      // For printing time since previous log line.
+	tlsConfig         *tls.Config    // TLS config to use for handshake.
 	remoteIP          net.IP
 	noRequireSTARTTLS bool
 	cmd               string // Currently executing, for deciding to applyChanges and logging.
@@ -192,6 +218,10 @@ type conn struct {
 	// ../rfc/5182:13 ../rfc/9051:4040
 	searchResult []store.UID
 
+	// Set by NOTIFY SET, cleared by NOTIFY NONE. If set, changes are also sent while
+	// no command is executing. ../rfc/5465
+	notify *notify
+
 	// Only when authenticated.
 	authFailed int    // Number of failed auth attempts. For slowing down remote with many failures.
 	username   string // Full username as used during login.
@@ -201,6 +231,17 @@ type conn struct {
 	mailboxID int64       // Only for StateSelected.
 	readonly  bool        // If opened mailbox is readonly.
 	uids      []store.UID // UIDs known in this session, sorted. todo future: store more space-efficiently, as ranges.
+
+	// Account and comm of the selected mailbox. The same as account and comm, except
+	// for a mailbox of another account in the "#Other Users" namespace, shared with us
+	// through an ACL. Then the other account is opened and a comm registered for it
+	// while the mailbox is selected, for changes made by sessions of the other
+	// account.
+	mbAccount *store.Account
+	mbComm    *store.Comm
+
+	// For a selected virtual mailbox of a saved search. The mailboxID is 0.
+	virtual *virtualMailbox
 }
 
 // capability for use with ENABLED and CAPABILITY. We always keep this upper case,
@@ -239,8 +280,8 @@ func stateCommands(cmds ...string) map[string]struct{} {
 var (
 	commandsStateAny              = stateCommands("capability", "noop", "logout", "id")
 	commandsStateNotAuthenticated = stateCommands("starttls", "authenticate", "login")
-	commandsStateAuthenticated    = stateCommands("enable", "select", "examine", "create", "delete", "rename", "subscribe", "unsubscribe", "list", "namespace", "status", "append", "idle", "lsub")
-	commandsStateSelected         = stateCommands("close", "unselect", "expunge", "search", "fetch", "store", "copy", "move", "uid expunge", "uid search", "uid fetch", "uid store", "uid copy", "uid move")
+	commandsStateAuthenticated    = stateCommands("enable", "select", "examine", "create", "delete", "rename", "subscribe", "unsubscribe", "list", "namespace", "status", "append", "idle", "lsub", "getquotaroot", "getquota", "notify", "getmetadata", "setmetadata", "compress", "setacl", "deleteacl", "getacl", "listrights", "myrights")
+	commandsStateSelected         = stateCommands("close", "unselect", "expunge", "search", "fetch", "store", "copy", "move", "uid expunge", "uid search", "uid fetch", "uid store", "uid copy", "uid move", "sort", "uid sort", "thread", "uid thread", "replace", "uid replace")
 )
 
 var commands = map[string]func(c *conn, tag, cmd string, p *parser){
@@ -256,20 +297,31 @@ var commands = map[string]func(c *conn, tag, cmd string, p *parser){
 	"login":        (*conn).cmdLogin,
 
 	// Authenticated and selected.
-	"enable":      (*conn).cmdEnable,
-	"select":      (*conn).cmdSelect,
-	"examine":     (*conn).cmdExamine,
-	"create":      (*conn).cmdCreate,
-	"delete":      (*conn).cmdDelete,
-	"rename":      (*conn).cmdRename,
-	"subscribe":   (*conn).cmdSubscribe,
-	"unsubscribe": (*conn).cmdUnsubscribe,
-	"list":        (*conn).cmdList,
-	"lsub":        (*conn).cmdLsub,
-	"namespace":   (*conn).cmdNamespace,
-	"status":      (*conn).cmdStatus,
-	"append":      (*conn).cmdAppend,
-	"idle":        (*conn).cmdIdle,
+	"enable":       (*conn).cmdEnable,
+	"select":       (*conn).cmdSelect,
+	"examine":      (*conn).cmdExamine,
+	"create":       (*conn).cmdCreate,
+	"delete":       (*conn).cmdDelete,
+	"rename":       (*conn).cmdRename,
+	"subscribe":    (*conn).cmdSubscribe,
+	"unsubscribe":  (*conn).cmdUnsubscribe,
+	"list":         (*conn).cmdList,
+	"lsub":         (*conn).cmdLsub,
+	"namespace":    (*conn).cmdNamespace,
+	"status":       (*conn).cmdStatus,
+	"append":       (*conn).cmdAppend,
+	"idle":         (*conn).cmdIdle,
+	"getquotaroot": (*conn).cmdGetquotaroot,
+	"notify":       (*conn).cmdNotify,
+	"getmetadata":  (*conn).cmdGetmetadata,
+	"setmetadata":  (*conn).cmdSetmetadata,
+	"compress":     (*conn).cmdCompress,
+	"getquota":     (*conn).cmdGetquota,
+	"setacl":       (*conn).cmdSetacl,
+	"deleteacl":    (*conn).cmdDeleteacl,
+	"getacl":       (*conn).cmdGetacl,
+	"listrights":   (*conn).cmdListrights,
+	"myrights":     (*conn).cmdMyrights,
 
 	// Selected.
 	"check":       (*conn).cmdCheck,
@@ -287,6 +339,12 @@ var commands = map[string]func(c *conn, tag, cmd string, p *parser){
 	"uid copy":    (*conn).cmdUIDCopy,
 	"move":        (*conn).cmdMove,
 	"uid move":    (*conn).cmdUIDMove,
+	"sort":        (*conn).cmdSort,
+	"uid sort":    (*conn).cmdUIDSort,
+	"thread":      (*conn).cmdThread,
+	"uid thread":  (*conn).cmdUIDThread,
+	"replace":     (*conn).cmdReplace,
+	"uid replace": (*conn).cmdUIDReplace,
 }
 
 var errIO = errors.New("io error")             // For read/write errors and errors that should close the connection.
@@ -393,15 +451,33 @@ func (c *conn) encodeMailbox(s string) string {
 }
 
 func (c *conn) xdbwrite(fn func(tx *bstore.Tx)) {
-	err := c.account.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
+	xdbwriteAccount(c.account, fn)
+}
+
+func (c *conn) xdbread(fn func(tx *bstore.Tx)) {
+	xdbreadAccount(c.account, fn)
+}
+
+// xmbdbwrite is like xdbwrite, but for the account of the selected mailbox.
+func (c *conn) xmbdbwrite(fn func(tx *bstore.Tx)) {
+	xdbwriteAccount(c.mbAccount, fn)
+}
+
+// xmbdbread is like xdbread, but for the account of the selected mailbox.
+func (c *conn) xmbdbread(fn func(tx *bstore.Tx)) {
+	xdbreadAccount(c.mbAccount, fn)
+}
+
+func xdbwriteAccount(acc *store.Account, fn func(tx *bstore.Tx)) {
+	err := acc.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
 		fn(tx)
 		return nil
 	})
 	xcheckf(err, "transaction")
 }
 
-func (c *conn) xdbread(fn func(tx *bstore.Tx)) {
-	err := c.account.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+func xdbreadAccount(acc *store.Account, fn func(tx *bstore.Tx)) {
+	err := acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
 		fn(tx)
 		return nil
 	})
@@ -414,10 +490,24 @@ func (c *conn) unselect() {
 	if c.state == stateSelected {
 		c.state = stateAuthenticated
 	}
+	if c.mbAccount != nil && c.mbAccount != c.account {
+		c.mbComm.Unregister()
+		err := c.mbAccount.Close()
+		c.xsanity(err, "closing account of shared mailbox")
+	}
+	c.mbAccount = nil
+	c.mbComm = nil
+	c.virtual = nil
 	c.mailboxID = 0
 	c.uids = nil
 }
 
+// isSelected returns whether mailbox mailboxID of account acc is the selected
+// mailbox.
+func (c *conn) isSelected(acc *store.Account, mailboxID int64) bool {
+	return c.state == stateSelected && c.mbAccount == acc && c.mailboxID == mailboxID
+}
+
 func (c *conn) setSlow(on bool) {
 	if on && !c.slow {
 		c.log.Debug("connection changed to slow")
@@ -552,9 +642,7 @@ func (c *conn) bwriteresultf(format string, args ...any) {
 	case "fetch", "store", "search":
 		// ../rfc/9051:5862 ../rfc/7162:2033
 	default:
-		if c.comm != nil {
-			c.applyChanges(c.comm.Get(), false)
-		}
+		c.applyPending(false)
 	}
 	c.bwritelinef(format, args...)
 }
@@ -573,9 +661,15 @@ func (c *conn) bwritelinef(format string, args ...any) {
 func (c *conn) xflush() {
 	err := c.bw.Flush()
 	xcheckf(err, "flush") // Should never happen, the Write caused by the Flush should panic on i/o error.
+	if c.compress != nil {
+		c.compress.xflush()
+	}
 }
 
 func (c *conn) readCommand(tag *string) (cmd string, p *parser) {
+	if c.notify != nil {
+		c.xnotifyWait()
+	}
 	line := c.readline(true)
 	p = newParser(line, c)
 	p.context("tag")
@@ -686,7 +780,12 @@ func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, x
 	defer func() {
 		c.conn.Close()
 
+		if c.compress != nil {
+			c.compress.observe()
+		}
+
 		if c.account != nil {
+			c.unselect()
 			c.comm.Unregister()
 			err := c.account.Close()
 			c.xsanity(err, "close account")
@@ -888,6 +987,31 @@ func (c *conn) broadcast(changes []store.Change) {
 	c.comm.Broadcast(changes)
 }
 
+// broadcastSelected broadcasts changes for the selected mailbox to other sessions
+// of the account of the selected mailbox.
+func (c *conn) broadcastSelected(changes []store.Change) {
+	if len(changes) == 0 {
+		return
+	}
+	c.log.Debug("broadcast changes for selected mailbox", slog.Any("changes", changes))
+	c.mbComm.Broadcast(changes)
+}
+
+// broadcastAccount broadcasts changes to other sessions of account acc, which can
+// be our own account, the account of the selected mailbox, or another account
+// with a mailbox shared with us.
+func (c *conn) broadcastAccount(acc *store.Account, changes []store.Change) {
+	switch {
+	case acc == c.account:
+		c.broadcast(changes)
+	case acc == c.mbAccount:
+		c.broadcastSelected(changes)
+	case len(changes) > 0:
+		c.log.Debug("broadcast changes for shared mailbox", slog.Any("changes", changes))
+		store.BroadcastChanges(acc, changes)
+	}
+}
+
 // matchStringer matches a string against reference + mailbox patterns.
 type matchStringer interface {
 	MatchString(s string) bool
@@ -1205,6 +1329,19 @@ func (c *conn) applyChanges(changes []store.Change, initial bool) {
 
 	c.log.Debug("applying changes", slog.Any("changes", changes))
 
+	// With NOTIFY, the client specified which changes it wants to know about. Message
+	// changes in other mailboxes are sent as STATUS responses.
+	// Changes for messages in a selected virtual mailbox are added as changes for
+	// mailbox ID 0.
+	if c.virtual != nil && c.state == stateSelected {
+		changes = c.virtualChanges(changes)
+	}
+
+	var statusLines []string
+	if c.notify != nil {
+		changes, statusLines = c.notifyFilter(changes)
+	}
+
 	// Only keep changes for the selected mailbox, and changes that are always relevant.
 	var n []store.Change
 	for _, change := range changes {
@@ -1219,16 +1356,99 @@ func (c *conn) applyChanges(changes []store.Change, initial bool) {
 		case store.ChangeRemoveMailbox, store.ChangeAddMailbox, store.ChangeRenameMailbox, store.ChangeAddSubscription:
 			n = append(n, change)
 			continue
+		case store.ChangeRemoveSubscription, store.ChangeAnnotation:
+			// Only sent with NOTIFY, there is no way to announce these changes otherwise.
+			if c.notify != nil {
+				n = append(n, change)
+			}
+			continue
 		case store.ChangeMailboxCounts, store.ChangeMailboxSpecialUse, store.ChangeMailboxKeywords, store.ChangeThread:
+			continue
 		default:
 			panic(fmt.Errorf("missing case for %#v", change))
 		}
-		if c.state == stateSelected && mbID == c.mailboxID {
+		if c.isSelected(c.account, mbID) {
 			n = append(n, change)
 		}
 	}
-	changes = n
+	c.writeChanges(n, initial)
+
+	if !initial {
+		for _, line := range statusLines {
+			c.bwritelinef("%s", line)
+		}
+	}
+}
 
+// applyPending applies pending changes of our account, and of the account of a
+// selected shared mailbox.
+func (c *conn) applyPending(initial bool) {
+	c.applyTaken(c.takePending(), initial)
+}
+
+// takePending returns pending changes of our account, and of the account of a
+// selected shared mailbox. Commands adding messages take them while holding the
+// account lock, so they can be applied before the UIDs of the new messages.
+func (c *conn) takePending() (pending [2][]store.Change) {
+	if c.comm != nil {
+		pending[0] = c.comm.Get()
+	}
+	if c.mbComm != nil && c.mbComm != c.comm {
+		pending[1] = c.mbComm.Get()
+	}
+	return pending
+}
+
+// applyTaken applies changes returned by takePending.
+func (c *conn) applyTaken(pending [2][]store.Change, initial bool) {
+	c.applyChanges(pending[0], initial)
+	c.applySharedChanges(pending[1], initial)
+}
+
+// sharedPending returns the channel that is ready when changes are pending for
+// the account of a selected shared mailbox. For other mailboxes, the returned nil
+// channel is never ready.
+func (c *conn) sharedPending() <-chan struct{} {
+	if c.mbComm == nil || c.mbComm == c.comm {
+		return nil
+	}
+	return c.mbComm.Pending
+}
+
+// applySharedChanges applies changes from the account of a selected shared
+// mailbox. Only changes to messages in the selected mailbox are relevant, changes
+// to mailboxes of the other account are not announced, the ACLs of those
+// mailboxes determine if they are visible.
+func (c *conn) applySharedChanges(changes []store.Change, initial bool) {
+	var n []store.Change
+	for _, change := range changes {
+		var mbID int64
+		switch ch := change.(type) {
+		case store.ChangeAddUID:
+			mbID = ch.MailboxID
+		case store.ChangeRemoveUIDs:
+			mbID = ch.MailboxID
+		case store.ChangeFlags:
+			mbID = ch.MailboxID
+		}
+		if mbID != 0 && c.isSelected(c.mbAccount, mbID) {
+			n = append(n, change)
+		}
+	}
+	if len(n) == 0 {
+		return
+	}
+
+	err := c.conn.SetWriteDeadline(time.Now().Add(5 * time.Minute))
+	c.log.Check(err, "setting write deadline")
+
+	c.log.Debug("applying changes for shared mailbox", slog.Any("changes", n))
+	c.writeChanges(n, initial)
+}
+
+// writeChanges updates the session state for the changes, and writes them to the
+// client unless initial is set. Changes must have been filtered for the session.
+func (c *conn) writeChanges(changes []store.Change, initial bool) {
 	qresync := c.enabled[capQresync]
 	condstore := c.enabled[capCondstore]
 
@@ -1256,6 +1476,15 @@ func (c *conn) applyChanges(changes []store.Change, initial bool) {
 			// long enough after the EXISTS to see these messages, and doesn't request them
 			// again with a FETCH.
 			c.bwritelinef("* %d EXISTS", len(c.uids))
+			if ev := c.notify.selectedEvent("MESSAGENEW"); ev != nil && len(ev.fetchAtts) > 0 {
+				// With NOTIFY, the client can ask for more attributes of new messages.
+				uids := make([]store.UID, len(adds))
+				for j, add := range adds {
+					uids[j] = add.UID
+				}
+				c.xnotifyFetch(uids, ev.fetchAtts)
+				continue
+			}
 			for _, add := range adds {
 				seq := c.xsequence(add.UID)
 				var modseqStr string
@@ -1323,12 +1552,18 @@ func (c *conn) applyChanges(changes []store.Change, initial bool) {
 		case store.ChangeRenameMailbox:
 			// OLDNAME only with IMAP4rev2 or NOTIFY ../rfc/9051:2726 ../rfc/5465:628
 			var oldname string
-			if c.enabled[capIMAP4rev2] {
+			if c.enabled[capIMAP4rev2] || c.notify != nil {
 				oldname = fmt.Sprintf(` ("OLDNAME" (%s))`, string0(c.encodeMailbox(ch.OldName)).pack(c))
 			}
 			c.bwritelinef(`* LIST (%s) "/" %s%s`, strings.Join(ch.Flags, " "), astring(c.encodeMailbox(ch.NewName)).pack(c), oldname)
 		case store.ChangeAddSubscription:
 			c.bwritelinef(`* LIST (%s) "/" %s`, strings.Join(append([]string{`\Subscribed`}, ch.Flags...), " "), astring(c.encodeMailbox(ch.Name)).pack(c))
+		case store.ChangeRemoveSubscription:
+			// ../rfc/5465
+			c.bwritelinef(`* LIST (%s) "/" %s`, strings.Join(ch.Flags, " "), astring(c.encodeMailbox(ch.Name)).pack(c))
+		case store.ChangeAnnotation:
+			// Only the entry name, without value. ../rfc/5465 ../rfc/5464
+			c.bwritelinef(`* METADATA %s %s`, astring(c.encodeMailbox(ch.MailboxName)).pack(c), astring(ch.Key).pack(c))
 		default:
 			panic(fmt.Sprintf("internal error, missing case for %#v", change))
 		}
@@ -1504,10 +1739,10 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 		}
 	}()
 
-	var authVariant string
+	var authVariant, authCredential string
 	authResult := "error"
 	defer func() {
-		metrics.AuthenticationInc("imap", authVariant, authResult)
+		metrics.AuthenticationInc("imap", authVariant, authCredential, authResult)
 		switch authResult {
 		case "ok":
 			mox.LimiterFailedAuth.Reset(c.remoteIP, time.Now())
@@ -1584,7 +1819,7 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 			xusercodeErrorf("AUTHORIZATIONFAILED", "cannot assume role")
 		}
 
-		acc, err := store.OpenEmailAuth(c.log, authc, password)
+		acc, appPassword, err := store.OpenEmailAuth(c.log, authc, password, store.AuthScopeIMAP)
 		if err != nil {
 			if errors.Is(err, store.ErrUnknownCredentials) {
 				authResult = "badcreds"
@@ -1593,6 +1828,7 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 			}
 			xusercodeErrorf("", "error")
 		}
+		authCredential = c.credential(appPassword)
 		c.account = acc
 		c.username = authc
 
@@ -1627,10 +1863,12 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 				c.xsanity(err, "close account")
 			}
 		}()
-		var ipadhash, opadhash hash.Hash
+		var password store.Password
+		var appPasswords []store.AppPassword
 		acc.WithRLock(func() {
 			err := acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
-				password, err := bstore.QueryTx[store.Password](tx).Get()
+				var err error
+				password, err = bstore.QueryTx[store.Password](tx).Get()
 				if err == bstore.ErrAbsent {
 					c.log.Info("failed authentication attempt", slog.String("username", addr), slog.Any("remote", c.remoteIP))
 					xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
@@ -1638,27 +1876,21 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 				if err != nil {
 					return err
 				}
-
-				ipadhash = password.CRAMMD5.Ipad
-				opadhash = password.CRAMMD5.Opad
-				return nil
+				appPasswords, err = store.AppPasswordsForScope(tx, store.AuthScopeIMAP)
+				return err
 			})
 			xcheckf(err, "tx read")
 		})
-		if ipadhash == nil || opadhash == nil {
+		if password.CRAMMD5.Ipad == nil || password.CRAMMD5.Opad == nil {
 			c.log.Info("cram-md5 auth attempt without derived secrets set, save password again to store secrets", slog.String("username", addr))
-			c.log.Info("failed authentication attempt", slog.String("username", addr), slog.Any("remote", c.remoteIP))
-			xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
 		}
 
-		// ../rfc/2195:138 ../rfc/2104:142
-		ipadhash.Write([]byte(chal))
-		opadhash.Write(ipadhash.Sum(nil))
-		digest := fmt.Sprintf("%x", opadhash.Sum(nil))
-		if digest != t[1] {
+		ok, appPassword := acc.AuthCRAMMD5(c.log, password, appPasswords, chal, t[1])
+		if !ok {
 			c.log.Info("failed authentication attempt", slog.String("username", addr), slog.Any("remote", c.remoteIP))
 			xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
 		}
+		authCredential = c.credential(appPassword)
 
 		c.account = acc
 		acc = nil // Cancel cleanup.
@@ -1713,6 +1945,7 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 			xuserErrorf("authentication with authorization for different user not supported")
 		}
 		var xscram store.SCRAM
+		var appPasswords []store.AppPassword
 		acc.WithRLock(func() {
 			err := acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
 				password, err := bstore.QueryTx[store.Password](tx).Get()
@@ -1729,6 +1962,7 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 					xuserErrorf("scram not possible")
 				}
 				xcheckf(err, "fetching credentials")
+				appPasswords, err = store.AppPasswordsForScope(tx, store.AuthScopeIMAP)
 				return err
 			})
 			xcheckf(err, "read tx")
@@ -1737,7 +1971,7 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 		xcheckf(err, "scram first server step")
 		c.writelinef("+ %s", base64.StdEncoding.EncodeToString([]byte(s1)))
 		c2 := xreadContinuation()
-		s3, err := ss.Finish(c2, xscram.SaltedPassword)
+		s3, appPassword, err := acc.AuthSCRAMFinish(c.log, ss, c2, xscram, appPasswords, h().Size() == sha256.Size)
 		if len(s3) > 0 {
 			c.writelinef("+ %s", base64.StdEncoding.EncodeToString([]byte(s3)))
 		}
@@ -1755,6 +1989,7 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 		// The message should be empty. todo: should we require it is empty?
 		xreadContinuation()
 
+		authCredential = c.credential(appPassword)
 		c.account = acc
 		acc = nil // Cancel cleanup.
 		c.username = ss.Authentication
@@ -1771,15 +2006,26 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 	c.writeresultf("%s OK [CAPABILITY %s] authenticate done", tag, c.capabilities())
 }
 
+// credential returns the credential used for authentication for metrics, for an
+// app password name as returned by the store, empty for the account password.
+func (c *conn) credential(appPassword string) string {
+	if appPassword == "" {
+		return "password"
+	}
+	c.log.Debug("authenticated with app password", slog.String("apppassword", appPassword))
+	return "apppassword"
+}
+
 // Login logs in with username and password.
 //
 // Status: Not authenticated.
 func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 	// Command: ../rfc/9051:1597 ../rfc/3501:1663
 
+	var authCredential string
 	authResult := "error"
 	defer func() {
-		metrics.AuthenticationInc("imap", "login", authResult)
+		metrics.AuthenticationInc("imap", "login", authCredential, authResult)
 	}()
 
 	// todo: get this line logged with traceauth. the plaintext password is included on the command line, which we've already read (before dispatching to this function).
@@ -1809,7 +2055,7 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 		}
 	}()
 
-	acc, err := store.OpenEmailAuth(c.log, userid, password)
+	acc, appPassword, err := store.OpenEmailAuth(c.log, userid, password, store.AuthScopeIMAP)
 	if err != nil {
 		authResult = "badcreds"
 		var code string
@@ -1819,6 +2065,7 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 		}
 		xusercodeErrorf(code, "login failed")
 	}
+	authCredential = c.credential(appPassword)
 	c.account = acc
 	c.username = userid
 	c.authFailed = 0
@@ -1892,7 +2139,7 @@ func (c *conn) xensureCondstore(tx *bstore.Tx) {
 		if tx != nil {
 			modseq = c.xhighestModSeq(tx, c.mailboxID)
 		} else {
-			c.xdbread(func(tx *bstore.Tx) {
+			c.xmbdbread(func(tx *bstore.Tx) {
 				modseq = c.xhighestModSeq(tx, c.mailboxID)
 			})
 		}
@@ -1987,15 +2234,37 @@ func (c *conn) cmdSelectExamine(isselect bool, tag, cmd string, p *parser) {
 		c.unselect()
 	}
 
-	name = xcheckmailboxname(name, true)
+	if ssName, ok := parseVirtualName(name); ok {
+		c.xvirtualSelect(isselect, tag, ssName)
+		return
+	}
+
+	// The account of a shared mailbox is closed by unselect, also when the select
+	// fails below.
+	mbAcc, mbName, _ := c.xmailboxAccount(name, true)
+	c.mbAccount, c.mbComm = mbAcc, c.comm
+	if mbAcc == c.account {
+		name = mbName
+	} else {
+		name = otherUsersName(mbAcc.Name, mbName)
+		// Register for changes before reading the messages, so we won't miss any.
+		c.mbComm = store.RegisterComm(mbAcc)
+	}
+	defer func() {
+		if c.state != stateSelected {
+			c.unselect()
+		}
+	}()
 
 	var highestModSeq store.ModSeq
 	var highDeletedModSeq store.ModSeq
 	var firstUnseen msgseq = 0
 	var mb store.Mailbox
-	c.account.WithRLock(func() {
-		c.xdbread(func(tx *bstore.Tx) {
-			mb = c.xmailbox(tx, name, "")
+	var rights store.Rights
+	c.mbAccount.WithRLock(func() {
+		c.xmbdbread(func(tx *bstore.Tx) {
+			mb, rights = c.xmailboxRights(tx, c.mbAccount, mbName, "")
+			xcheckRights(rights, "r") // ../rfc/4314
 
 			q := bstore.QueryTx[store.Message](tx)
 			q.FilterNonzero(store.Message{MailboxID: mb.ID})
@@ -2023,12 +2292,12 @@ func (c *conn) cmdSelectExamine(isselect bool, tag, cmd string, p *parser) {
 			// For QRESYNC, we need to know the highest modset of deleted expunged records to
 			// maintain synchronization.
 			if c.enabled[capQresync] {
-				highDeletedModSeq, err = c.account.HighestDeletedModSeq(tx)
+				highDeletedModSeq, err = c.mbAccount.HighestDeletedModSeq(tx)
 				xcheckf(err, "getting highest deleted modseq")
 			}
 		})
 	})
-	c.applyChanges(c.comm.Get(), true)
+	c.applyPending(true)
 
 	var flags string
 	if len(mb.Keywords) > 0 {
@@ -2046,7 +2315,8 @@ func (c *conn) cmdSelectExamine(isselect bool, tag, cmd string, p *parser) {
 	}
 	c.bwritelinef(`* OK [UIDVALIDITY %d] x`, mb.UIDValidity)
 	c.bwritelinef(`* OK [UIDNEXT %d] x`, mb.UIDNext)
-	c.bwritelinef(`* LIST () "/" %s`, astring(c.encodeMailbox(mb.Name)).pack(c))
+	c.bwritelinef(`* OK [MAILBOXID (%s)] x`, mailboxObjectID(mb.ID)) // ../rfc/8474
+	c.bwritelinef(`* LIST () "/" %s`, astring(c.encodeMailbox(name)).pack(c))
 	if c.enabled[capCondstore] {
 		// ../rfc/7162:417
 		// ../rfc/7162-eid5055 ../rfc/7162:484 ../rfc/7162:1167
@@ -2105,7 +2375,7 @@ func (c *conn) cmdSelectExamine(isselect bool, tag, cmd string, p *parser) {
 		// We are reading without account lock. Similar to when we process FETCH/SEARCH
 		// requests. We don't have to reverify existence of the mailbox, so we don't
 		// rlock, even briefly.
-		c.xdbread(func(tx *bstore.Tx) {
+		c.xmbdbread(func(tx *bstore.Tx) {
 			if oldClientUID > 0 {
 				// The client sent a UID that is now removed. This is typically fine. But we check
 				// that it is consistent with the modseq the client sent. If the UID already didn't
@@ -2192,7 +2462,8 @@ func (c *conn) cmdSelectExamine(isselect bool, tag, cmd string, p *parser) {
 		}
 	}
 
-	if isselect {
+	// Without rights to make changes, a shared mailbox is opened read-only. ../rfc/4314
+	if isselect && strings.ContainsAny(string(rights), "swtei") {
 		c.bwriteresultf("%s OK [READ-WRITE] x", tag)
 		c.readonly = false
 	} else {
@@ -2224,6 +2495,7 @@ func (c *conn) cmdCreate(tag, cmd string, p *parser) {
 
 	var changes []store.Change
 	var created []string // Created mailbox names.
+	var mailboxID int64
 
 	c.account.WithWLock(func() {
 		c.xdbwrite(func(tx *bstore.Tx) {
@@ -2235,6 +2507,12 @@ func (c *conn) cmdCreate(tag, cmd string, p *parser) {
 				xuserErrorf("mailbox already exists")
 			}
 			xcheckf(err, "creating mailbox")
+
+			for _, ch := range changes {
+				if ch, ok := ch.(store.ChangeAddMailbox); ok && ch.Mailbox.Name == name {
+					mailboxID = ch.Mailbox.ID
+				}
+			}
 		})
 
 		c.broadcast(changes)
@@ -2248,7 +2526,8 @@ func (c *conn) cmdCreate(tag, cmd string, p *parser) {
 		}
 		c.bwritelinef(`* LIST (\Subscribed) "/" %s%s`, astring(c.encodeMailbox(n)).pack(c), oldname)
 	}
-	c.ok(tag, cmd)
+	// ../rfc/8474
+	c.writeresultf("%s OK [MAILBOXID (%s)] %s done", tag, mailboxObjectID(mailboxID), cmd)
 }
 
 // Delete removes a mailbox and all its messages.
@@ -2267,7 +2546,7 @@ func (c *conn) cmdDelete(tag, cmd string, p *parser) {
 	name = xcheckmailboxname(name, false)
 
 	// Messages to remove after having broadcasted the removal of messages.
-	var removeMessageIDs []int64
+	var removeMessages []store.Message
 
 	c.account.WithWLock(func() {
 		var mb store.Mailbox
@@ -2278,7 +2557,7 @@ func (c *conn) cmdDelete(tag, cmd string, p *parser) {
 
 			var hasChildren bool
 			var err error
-			changes, removeMessageIDs, hasChildren, err = c.account.MailboxDelete(context.TODO(), c.log, tx, mb)
+			changes, removeMessages, hasChildren, err = c.account.MailboxDelete(context.TODO(), c.log, tx, mb)
 			if hasChildren {
 				xusercodeErrorf("HASCHILDREN", "mailbox has a child, only leaf mailboxes can be deleted")
 			}
@@ -2288,11 +2567,7 @@ func (c *conn) cmdDelete(tag, cmd string, p *parser) {
 		c.broadcast(changes)
 	})
 
-	for _, mID := range removeMessageIDs {
-		p := c.account.MessagePath(mID)
-		err := os.Remove(p)
-		c.log.Check(err, "removing message file for mailbox delete", slog.String("path", p))
-	}
+	c.account.ExpungedFilesRemove(c.log, removeMessages)
 
 	c.ok(tag, cmd)
 }
@@ -2441,7 +2716,12 @@ func (c *conn) cmdSubscribe(tag, cmd string, p *parser) {
 	name := p.xmailbox()
 	p.xempty()
 
-	name = xcheckmailboxname(name, true)
+	// Subscriptions to shared mailboxes are stored with the name in our namespace.
+	if accName, mbName, ok := parseOtherUsersName(name); ok {
+		name = otherUsersName(accName, xcheckmailboxname(mbName, true))
+	} else {
+		name = xcheckmailboxname(name, true)
+	}
 
 	c.account.WithWLock(func() {
 		var changes []store.Change
@@ -2473,6 +2753,7 @@ func (c *conn) cmdUnsubscribe(tag, cmd string, p *parser) {
 	name = xcheckmailboxname(name, true)
 
 	c.account.WithWLock(func() {
+		var changes []store.Change
 		c.xdbwrite(func(tx *bstore.Tx) {
 			// It's OK if not currently subscribed, ../rfc/9051:2215
 			err := tx.Delete(&store.Subscription{Name: name})
@@ -2485,9 +2766,18 @@ func (c *conn) cmdUnsubscribe(tag, cmd string, p *parser) {
 				return
 			}
 			xcheckf(err, "removing subscription")
+
+			// Other sessions with NOTIFY are told the mailbox is no longer subscribed.
+			var flags []string
+			exists, err := c.account.MailboxExists(tx, name)
+			xcheckf(err, "checking if mailbox exists")
+			if !exists {
+				flags = []string{`\NonExistent`}
+			}
+			changes = []store.Change{store.ChangeRemoveSubscription{Name: name, Flags: flags}}
 		})
 
-		// todo: can we send untagged message about a mailbox no longer being subscribed?
+		c.broadcast(changes)
 	})
 
 	c.ok(tag, cmd)
@@ -2560,8 +2850,10 @@ func (c *conn) cmdLsub(tag, cmd string, p *parser) {
 	c.ok(tag, cmd)
 }
 
-// The namespace command returns the mailbox path separator. We only implement
-// the personal mailbox hierarchy, no shared/other.
+// The namespace command returns the mailbox path separator, and the prefixes of
+// the namespaces. Besides the personal namespace, mailboxes of other accounts
+// shared with us are in the "#Other Users" namespace. All mailboxes belong to an
+// account, so there is no shared namespace.
 //
 // In IMAP4rev2, it was an extension before.
 //
@@ -2573,10 +2865,106 @@ func (c *conn) cmdNamespace(tag, cmd string, p *parser) {
 	p.xempty()
 
 	// Response syntax: ../rfc/9051:6778 ../rfc/2342:415
-	c.bwritelinef(`* NAMESPACE (("" "/")) NIL NIL`)
+	c.bwritelinef(`* NAMESPACE (("" "/")) ((%s "/")) NIL`, string0(store.OtherUsersPrefix).pack(c))
+	c.ok(tag, cmd)
+}
+
+// The getquotaroot command returns the quota roots that apply to a mailbox,
+// followed by the usage and limits of those quota roots. We only have a single
+// account-wide quota on the total message size and number of messages, with root
+// name "". The mailbox does not have to exist.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdGetquotaroot(tag, cmd string, p *parser) {
+	// Command: ../rfc/9208
+	// Request syntax: ../rfc/9208
+	p.xspace()
+	name := p.xmailbox()
+	p.xempty()
+
+	name = xcheckmailboxname(name, true)
+
+	qu := c.xquotaUsage()
+
+	// Response syntax: ../rfc/9208
+	if qu.maxSize > 0 || qu.maxCount > 0 {
+		c.bwritelinef(`* QUOTAROOT %s ""`, astring(c.encodeMailbox(name)).pack(c))
+		c.xwriteQuota(qu)
+	} else {
+		// Without a limit, no quota root applies to the mailbox.
+		c.bwritelinef(`* QUOTAROOT %s`, astring(c.encodeMailbox(name)).pack(c))
+	}
 	c.ok(tag, cmd)
 }
 
+// The getquota command returns the usage and limits for a quota root. We only
+// have the root "", and only if a quota is configured for the account.
+//
+// State: Authenticated and selected.
+func (c *conn) cmdGetquota(tag, cmd string, p *parser) {
+	// Command: ../rfc/9208
+	// Request syntax: ../rfc/9208
+	p.xspace()
+	root := p.xastring()
+	p.xempty()
+
+	qu := c.xquotaUsage()
+	if root != "" || qu.maxSize <= 0 && qu.maxCount <= 0 {
+		xusercodeErrorf("NONEXISTENT", "unknown quota root")
+	}
+
+	c.xwriteQuota(qu)
+	c.ok(tag, cmd)
+}
+
+// quotaUsage is the usage and limits for the account. A zero maximum means there
+// is no limit for that resource.
+type quotaUsage struct {
+	size, maxSize   int64
+	count, maxCount int64
+}
+
+// xquotaUsage returns the current total message size and number of messages for
+// the account, and their limits.
+func (c *conn) xquotaUsage() (qu quotaUsage) {
+	c.account.WithRLock(func() {
+		qu.maxSize = c.account.QuotaMessageSize()
+		qu.maxCount = c.account.QuotaMessageCount()
+		if qu.maxSize <= 0 && qu.maxCount <= 0 {
+			return
+		}
+		c.xdbread(func(tx *bstore.Tx) {
+			if qu.maxSize > 0 {
+				du := store.DiskUsage{ID: 1}
+				err := tx.Get(&du)
+				xcheckf(err, "get disk usage")
+				qu.size = du.MessageSize
+			}
+			if qu.maxCount > 0 {
+				var err error
+				qu.count, err = c.account.MessageCount(tx)
+				xcheckf(err, "count messages")
+			}
+		})
+	})
+	return
+}
+
+// xwriteQuota writes a QUOTA response for our single quota root, with the
+// resources that have a limit. The STORAGE resource is in units of 1024 octets,
+// we round up.
+func (c *conn) xwriteQuota(qu quotaUsage) {
+	// Response syntax: ../rfc/9208
+	var l []string
+	if qu.maxSize > 0 {
+		l = append(l, fmt.Sprintf("STORAGE %d %d", (qu.size+1024-1)/1024, (qu.maxSize+1024-1)/1024))
+	}
+	if qu.maxCount > 0 {
+		l = append(l, fmt.Sprintf("MESSAGE %d %d", qu.count, qu.maxCount))
+	}
+	c.bwritelinef(`* QUOTA "" (%s)`, strings.Join(l, " "))
+}
+
 // The status command returns information about a mailbox, such as the number of
 // messages, "uid validity", etc. Nowadays, the extended LIST command can return
 // the same information about many mailboxes for one command.
@@ -2598,14 +2986,29 @@ func (c *conn) cmdStatus(tag, cmd string, p *parser) {
 	}
 	p.xempty()
 
-	name = xcheckmailboxname(name, true)
+	var responseLine string
+	if ssName, ok := parseVirtualName(name); ok {
+		c.account.WithRLock(func() {
+			c.xdbread(func(tx *bstore.Tx) {
+				responseLine = c.xvirtualStatusLine(tx, c.xsavedSearch(tx, ssName), attrs)
+			})
+		})
+		c.bwritelinef("%s", responseLine)
+		c.ok(tag, cmd)
+		return
+	}
 
-	var mb store.Mailbox
+	acc, mbName, closeAcc := c.xmailboxAccount(name, true)
+	defer closeAcc()
 
-	var responseLine string
-	c.account.WithRLock(func() {
-		c.xdbread(func(tx *bstore.Tx) {
-			mb = c.xmailbox(tx, name, "")
+	acc.WithRLock(func() {
+		xdbreadAccount(acc, func(tx *bstore.Tx) {
+			mb, rights := c.xmailboxRights(tx, acc, mbName, "")
+			xcheckRights(rights, "r") // ../rfc/4314
+			if acc != c.account {
+				// Response has the name in our namespace.
+				mb.Name = otherUsersName(acc.Name, mb.Name)
+			}
 			responseLine = c.xstatusLine(tx, mb, attrs)
 		})
 	})
@@ -2632,6 +3035,19 @@ func (c *conn) xstatusLine(tx *bstore.Tx, mb store.Mailbox, attrs []string) stri
 			status = append(status, A, fmt.Sprintf("%d", mb.Deleted))
 		case "SIZE":
 			status = append(status, A, fmt.Sprintf("%d", mb.Size))
+		case "DELETED-STORAGE":
+			// Total size of messages marked \Deleted, in units of 1024 octets. ../rfc/9208
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: mb.ID})
+			q.FilterEqual("Deleted", true)
+			q.FilterEqual("Expunged", false)
+			var size int64
+			err := q.ForEach(func(m store.Message) error {
+				size += m.Size
+				return nil
+			})
+			xcheckf(err, "gathering size of deleted messages")
+			status = append(status, A, fmt.Sprintf("%d", (size+1024-1)/1024))
 		case "RECENT":
 			status = append(status, A, "0")
 		case "APPENDLIMIT":
@@ -2640,6 +3056,9 @@ func (c *conn) xstatusLine(tx *bstore.Tx, mb store.Mailbox, attrs []string) stri
 		case "HIGHESTMODSEQ":
 			// ../rfc/7162:366
 			status = append(status, A, fmt.Sprintf("%d", c.xhighestModSeq(tx, mb.ID).Client()))
+		case "MAILBOXID":
+			// ../rfc/8474
+			status = append(status, A, fmt.Sprintf("(%s)", mailboxObjectID(mb.ID)))
 		default:
 			xsyntaxErrorf("unknown attribute %q", a)
 		}
@@ -2670,148 +3089,6 @@ func flaglist(fl store.Flags, keywords []string) listspace {
 	return l
 }
 
-// Append adds a message to a mailbox.
-//
-// State: Authenticated and selected.
-func (c *conn) cmdAppend(tag, cmd string, p *parser) {
-	// Command: ../rfc/9051:3406 ../rfc/6855:204 ../rfc/3501:2527
-	// Examples: ../rfc/9051:3482 ../rfc/3501:2589
-
-	// Request syntax: ../rfc/9051:6325 ../rfc/6855:219 ../rfc/3501:4547
-	p.xspace()
-	name := p.xmailbox()
-	p.xspace()
-	var storeFlags store.Flags
-	var keywords []string
-	if p.hasPrefix("(") {
-		// Error must be a syntax error, to properly abort the connection due to literal.
-		var err error
-		storeFlags, keywords, err = store.ParseFlagsKeywords(p.xflagList())
-		if err != nil {
-			xsyntaxErrorf("parsing flags: %v", err)
-		}
-		p.xspace()
-	}
-	var tm time.Time
-	if p.hasPrefix(`"`) {
-		tm = p.xdateTime()
-		p.xspace()
-	} else {
-		tm = time.Now()
-	}
-	// todo: only with utf8 should we we accept message headers with utf-8. we currently always accept them.
-	// todo: this is only relevant if we also support the CATENATE extension?
-	// ../rfc/6855:204
-	utf8 := p.take("UTF8 (")
-	size, sync := p.xliteralSize(0, utf8)
-
-	name = xcheckmailboxname(name, true)
-	c.xdbread(func(tx *bstore.Tx) {
-		c.xmailbox(tx, name, "TRYCREATE")
-	})
-	if sync {
-		c.writelinef("+ ")
-	}
-
-	// Read the message into a temporary file.
-	msgFile, err := store.CreateMessageTemp(c.log, "imap-append")
-	xcheckf(err, "creating temp file for message")
-	defer func() {
-		p := msgFile.Name()
-		err := msgFile.Close()
-		c.xsanity(err, "closing APPEND temporary file")
-		err = os.Remove(p)
-		c.xsanity(err, "removing APPEND temporary file")
-	}()
-	defer c.xtrace(mlog.LevelTracedata)()
-	mw := message.NewWriter(msgFile)
-	msize, err := io.Copy(mw, io.LimitReader(c.br, size))
-	c.xtrace(mlog.LevelTrace) // Restore.
-	if err != nil {
-		// Cannot use xcheckf due to %w handling of errIO.
-		panic(fmt.Errorf("reading literal message: %s (%w)", err, errIO))
-	}
-	if msize != size {
-		xserverErrorf("read %d bytes for message, expected %d (%w)", msize, size, errIO)
-	}
-
-	if utf8 {
-		line := c.readline(false)
-		np := newParser(line, c)
-		np.xtake(")")
-		np.xempty()
-	} else {
-		line := c.readline(false)
-		np := newParser(line, c)
-		np.xempty()
-	}
-	p.xempty()
-	if !sync {
-		name = xcheckmailboxname(name, true)
-	}
-
-	var mb store.Mailbox
-	var m store.Message
-	var pendingChanges []store.Change
-
-	c.account.WithWLock(func() {
-		var changes []store.Change
-		c.xdbwrite(func(tx *bstore.Tx) {
-			mb = c.xmailbox(tx, name, "TRYCREATE")
-
-			// Ensure keywords are stored in mailbox.
-			var mbKwChanged bool
-			mb.Keywords, mbKwChanged = store.MergeKeywords(mb.Keywords, keywords)
-			if mbKwChanged {
-				changes = append(changes, mb.ChangeKeywords())
-			}
-
-			m = store.Message{
-				MailboxID:     mb.ID,
-				MailboxOrigID: mb.ID,
-				Received:      tm,
-				Flags:         storeFlags,
-				Keywords:      keywords,
-				Size:          mw.Size,
-			}
-
-			ok, maxSize, err := c.account.CanAddMessageSize(tx, m.Size)
-			xcheckf(err, "checking quota")
-			if !ok {
-				// ../rfc/9051:5155
-				xusercodeErrorf("OVERQUOTA", "account over maximum total message size %d", maxSize)
-			}
-
-			mb.Add(m.MailboxCounts())
-
-			// Update mailbox before delivering, which updates uidnext which we mustn't overwrite.
-			err = tx.Update(&mb)
-			xcheckf(err, "updating mailbox counts")
-
-			err = c.account.DeliverMessage(c.log, tx, &m, msgFile, true, false, false, true)
-			xcheckf(err, "delivering message")
-		})
-
-		// Fetch pending changes, possibly with new UIDs, so we can apply them before adding our own new UID.
-		if c.comm != nil {
-			pendingChanges = c.comm.Get()
-		}
-
-		// Broadcast the change to other connections.
-		changes = append(changes, m.ChangeAddUID(), mb.ChangeCounts())
-		c.broadcast(changes)
-	})
-
-	if c.mailboxID == mb.ID {
-		c.applyChanges(pendingChanges, false)
-		c.uidAppend(m.UID)
-		// todo spec: with condstore/qresync, is there a mechanism to the client know the modseq for the appended uid? in theory an untagged fetch with the modseq after the OK APPENDUID could make sense, but this probably isn't allowed.
-		c.bwritelinef("* %d EXISTS", len(c.uids))
-	}
-
-	c.writeresultf("%s OK [APPENDUID %d %d] appended", tag, mb.UIDValidity, m.UID)
-}
-
 // Idle makes a client wait until the server sends untagged updates, e.g. about
 // message delivery or mailbox create/rename/delete/subscription, etc. It allows a
 // client to get updates in real-time, not needing the use for NOOP.
@@ -2826,6 +3103,12 @@ func (c *conn) cmdIdle(tag, cmd string, p *parser) {
 
 	c.writelinef("+ waiting")
 
+	// With NOTIFY, changes may have been held back while waiting for this command.
+	if c.notify != nil {
+		c.applyPending(false)
+		c.xflush()
+	}
+
 	var line string
 wait:
 	for {
@@ -2838,6 +3121,9 @@ wait:
 		case <-c.comm.Pending:
 			c.applyChanges(c.comm.Get(), false)
 			c.xflush()
+		case <-c.sharedPending():
+			c.applySharedChanges(c.mbComm.Get(), false)
+			c.xflush()
 		case <-mox.Shutdown.Done():
 			// ../rfc/9051:5375
 			c.writelinef("* BYE shutting down")
@@ -2867,9 +3153,9 @@ func (c *conn) cmdCheck(tag, cmd string, p *parser) {
 	// Request syntax: ../rfc/3501:4679
 	p.xempty()
 
-	c.account.WithRLock(func() {
-		c.xdbread(func(tx *bstore.Tx) {
-			c.xmailboxID(tx, c.mailboxID) // Validate.
+	c.mbAccount.WithRLock(func() {
+		c.xmbdbread(func(tx *bstore.Tx) {
+			c.xcheckSelected(tx) // Validate.
 		})
 	})
 
@@ -2886,21 +3172,17 @@ func (c *conn) cmdClose(tag, cmd string, p *parser) {
 	// Request syntax: ../rfc/9051:6476 ../rfc/3501:4679
 	p.xempty()
 
-	if c.readonly {
+	// Messages are not removed from virtual mailboxes.
+	if c.readonly || c.virtual != nil {
 		c.unselect()
 		c.ok(tag, cmd)
 		return
 	}
 
+	acc := c.mbAccount
 	remove, _ := c.xexpunge(nil, true)
 
-	defer func() {
-		for _, m := range remove {
-			p := c.account.MessagePath(m.ID)
-			err := os.Remove(p)
-			c.xsanity(err, "removing message file for expunge for close")
-		}
-	}()
+	defer acc.ExpungedFilesRemove(c.log, remove)
 
 	c.unselect()
 	c.ok(tag, cmd)
@@ -2915,13 +3197,16 @@ func (c *conn) cmdClose(tag, cmd string, p *parser) {
 // the highest modseq in the mailbox is returned, typically associated with the
 // removal of the messages, but if no messages were expunged the current latest max
 // modseq for the mailbox is returned.
+//
+// Without the right to expunge in a shared mailbox, nothing is expunged if
+// missingMailboxOK is set, for CLOSE.
 func (c *conn) xexpunge(uidSet *numSet, missingMailboxOK bool) (remove []store.Message, highestModSeq store.ModSeq) {
 	var modseq store.ModSeq
 
-	c.account.WithWLock(func() {
+	c.mbAccount.WithWLock(func() {
 		var mb store.Mailbox
 
-		c.xdbwrite(func(tx *bstore.Tx) {
+		c.xmbdbwrite(func(tx *bstore.Tx) {
 			mb = store.Mailbox{ID: c.mailboxID}
 			err := tx.Get(&mb)
 			if err == bstore.ErrAbsent {
@@ -2931,6 +3216,14 @@ func (c *conn) xexpunge(uidSet *numSet, missingMailboxOK bool) (remove []store.M
 				xuserErrorf("%w", store.ErrUnknownMailbox)
 			}
 
+			// ../rfc/4314
+			rights, err := c.mbAccount.MailboxRights(tx, c.mailboxID, c.account.Name)
+			xcheckf(err, "looking up rights for selected mailbox")
+			if missingMailboxOK && !rights.Has("e") {
+				return
+			}
+			xcheckRights(rights, "e")
+
 			qm := bstore.QueryTx[store.Message](tx)
 			qm.FilterNonzero(store.Message{MailboxID: c.mailboxID})
 			qm.FilterEqual("Deleted", true)
@@ -2949,49 +3242,11 @@ func (c *conn) xexpunge(uidSet *numSet, missingMailboxOK bool) (remove []store.M
 			}
 
 			// Assign new modseq.
-			modseq, err = c.account.NextModSeq(tx)
+			modseq, err = c.mbAccount.NextModSeq(tx)
 			xcheckf(err, "assigning next modseq")
 			highestModSeq = modseq
 
-			removeIDs := make([]int64, len(remove))
-			anyIDs := make([]any, len(remove))
-			var totalSize int64
-			for i, m := range remove {
-				removeIDs[i] = m.ID
-				anyIDs[i] = m.ID
-				mb.Sub(m.MailboxCounts())
-				totalSize += m.Size
-				// Update "remove", because RetrainMessage below will save the message.
-				remove[i].Expunged = true
-				remove[i].ModSeq = modseq
-			}
-			qmr := bstore.QueryTx[store.Recipient](tx)
-			qmr.FilterEqual("MessageID", anyIDs...)
-			_, err = qmr.Delete()
-			xcheckf(err, "removing message recipients")
-
-			qm = bstore.QueryTx[store.Message](tx)
-			qm.FilterIDs(removeIDs)
-			n, err := qm.UpdateNonzero(store.Message{Expunged: true, ModSeq: modseq})
-			if err == nil && n != len(removeIDs) {
-				err = fmt.Errorf("only %d messages set to expunged, expected %d", n, len(removeIDs))
-			}
-			xcheckf(err, "marking messages marked for deleted as expunged")
-
-			err = tx.Update(&mb)
-			xcheckf(err, "updating mailbox counts")
-
-			err = c.account.AddMessageSize(c.log, tx, -totalSize)
-			xcheckf(err, "updating disk usage")
-
-			// Mark expunged messages as not needing training, then retrain them, so if they
-			// were trained, they get untrained.
-			for i := range remove {
-				remove[i].Junk = false
-				remove[i].Notjunk = false
-			}
-			err = c.account.RetrainMessages(context.TODO(), c.log, tx, remove, true)
-			xcheckf(err, "untraining expunged messages")
+			c.xexpungeMessages(tx, &mb, remove, modseq)
 		})
 
 		// Broadcast changes to other connections. We may not have actually removed any
@@ -3005,12 +3260,20 @@ func (c *conn) xexpunge(uidSet *numSet, missingMailboxOK bool) (remove []store.M
 				store.ChangeRemoveUIDs{MailboxID: c.mailboxID, UIDs: ouids, ModSeq: modseq},
 				mb.ChangeCounts(),
 			}
-			c.broadcast(changes)
+			c.broadcastSelected(changes)
 		}
 	})
 	return remove, highestModSeq
 }
 
+// xexpungeMessages marks messages as expunged in the database with modseq, see
+// store.Account.ExpungeMessages. Mailbox mb is the selected mailbox, tx is on its
+// account. The message files must be removed after the transaction is committed.
+func (c *conn) xexpungeMessages(tx *bstore.Tx, mb *store.Mailbox, remove []store.Message, modseq store.ModSeq) {
+	err := c.mbAccount.ExpungeMessages(context.TODO(), c.log, tx, mb, remove, modseq)
+	xcheckf(err, "expunging messages")
+}
+
 // Unselect is similar to close in that it closes the currently active mailbox, but
 // it does not remove messages marked for deletion.
 //
@@ -3068,15 +3331,11 @@ func (c *conn) cmdUIDExpunge(tag, cmd string, p *parser) {
 func (c *conn) cmdxExpunge(tag, cmd string, uidSet *numSet) {
 	// Command: ../rfc/9051:3687 ../rfc/3501:2695
 
+	c.xnotVirtual()
+
 	remove, highestModSeq := c.xexpunge(uidSet, false)
 
-	defer func() {
-		for _, m := range remove {
-			p := c.account.MessagePath(m.ID)
-			err := os.Remove(p)
-			c.xsanity(err, "removing message file for expunge")
-		}
-	}()
+	defer c.mbAccount.ExpungedFilesRemove(c.log, remove)
 
 	// Response syntax: ../rfc/9051:6742 ../rfc/3501:4864
 	var vanishedUIDs numSet
@@ -3124,6 +3383,22 @@ func (c *conn) cmdUIDFetch(tag, cmd string, p *parser) {
 	c.cmdxFetch(true, tag, cmd, p)
 }
 
+func (c *conn) cmdSort(tag, cmd string, p *parser) {
+	c.cmdxSort(false, tag, cmd, p)
+}
+
+func (c *conn) cmdUIDSort(tag, cmd string, p *parser) {
+	c.cmdxSort(true, tag, cmd, p)
+}
+
+func (c *conn) cmdThread(tag, cmd string, p *parser) {
+	c.cmdxThread(false, tag, cmd, p)
+}
+
+func (c *conn) cmdUIDThread(tag, cmd string, p *parser) {
+	c.cmdxThread(true, tag, cmd, p)
+}
+
 // State: Selected
 func (c *conn) cmdStore(tag, cmd string, p *parser) {
 	c.cmdxStore(false, tag, cmd, p)
@@ -3186,10 +3461,21 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 	name := p.xmailbox()
 	p.xempty()
 
-	name = xcheckmailboxname(name, true)
+	// The destination can be a mailbox of another account, shared with us.
+	acc, name, closeAcc := c.xmailboxAccount(name, true)
+	defer closeAcc()
 
 	uids, uidargs := c.gatherCopyMoveUIDs(isUID, nums)
 
+	// Messages in virtual mailboxes are in multiple mailboxes, they are copied like
+	// messages of another account.
+	if acc != c.mbAccount || c.virtual != nil {
+		mbDst, newUIDs := c.xcopyAccount(acc, name, uids, uidargs)
+		// ../rfc/9051:6881 ../rfc/4315:183
+		c.writeresultf("%s OK [COPYUID %d %s %s] copied", tag, mbDst.UIDValidity, compactUIDSet(uids).String(), compactUIDSet(newUIDs).String())
+		return
+	}
+
 	// Files that were created during the copy. Remove them if the operation fails.
 	var createdIDs []int64
 	defer func() {
@@ -3198,7 +3484,7 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 			return
 		}
 		for _, id := range createdIDs {
-			p := c.account.MessagePath(id)
+			p := c.mbAccount.MessagePath(id)
 			err := os.Remove(p)
 			c.xsanity(err, "cleaning up created file")
 		}
@@ -3211,12 +3497,14 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 	var keywords [][]string
 	var modseq store.ModSeq // For messages in new mailbox, assigned when first message is copied.
 
-	c.account.WithWLock(func() {
+	c.mbAccount.WithWLock(func() {
 		var mbKwChanged bool
 
-		c.xdbwrite(func(tx *bstore.Tx) {
+		c.xmbdbwrite(func(tx *bstore.Tx) {
 			mbSrc := c.xmailboxID(tx, c.mailboxID) // Validate.
-			mbDst = c.xmailbox(tx, name, "TRYCREATE")
+			c.xselectedRights(tx, "r")
+			var dstRights store.Rights
+			mbDst, dstRights = c.xmailboxDst(tx, c.mbAccount, name)
 			if mbDst.ID == mbSrc.ID {
 				xuserErrorf("cannot copy to currently selected mailbox")
 			}
@@ -3226,7 +3514,7 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 			}
 
 			var err error
-			modseq, err = c.account.NextModSeq(tx)
+			modseq, err = c.mbAccount.NextModSeq(tx)
 			xcheckf(err, "assigning next modseq")
 
 			// Reserve the uids in the destination mailbox.
@@ -3250,13 +3538,18 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 			for _, m := range xmsgs {
 				totalSize += m.Size
 			}
-			if ok, maxSize, err := c.account.CanAddMessageSize(tx, totalSize); err != nil {
+			if ok, maxSize, err := c.mbAccount.CanAddMessageSize(tx, totalSize); err != nil {
 				xcheckf(err, "checking quota")
 			} else if !ok {
 				// ../rfc/9051:5155
 				xusercodeErrorf("OVERQUOTA", "account over maximum total message size %d", maxSize)
 			}
-			err = c.account.AddMessageSize(c.log, tx, totalSize)
+			if ok, maxCount, err := c.mbAccount.CanAddMessages(tx, int64(len(xmsgs))); err != nil {
+				xcheckf(err, "checking quota")
+			} else if !ok {
+				xusercodeErrorf("OVERQUOTA", "account over maximum number of messages %d", maxCount)
+			}
+			err = c.mbAccount.AddMessageSize(c.log, tx, totalSize)
 			xcheckf(err, "updating disk usage")
 
 			msgs := map[store.UID]store.Message{}
@@ -3265,12 +3558,13 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 			}
 			nmsgs := make([]store.Message, len(xmsgs))
 
-			conf, _ := c.account.Conf()
+			conf, _ := c.mbAccount.Conf()
 
 			mbKeywords := map[string]struct{}{}
 
 			// Insert new messages into database.
 			var origMsgIDs, newMsgIDs []int64
+			now := time.Now()
 			for i, uid := range uids {
 				m, ok := msgs[uid]
 				if !ok {
@@ -3283,6 +3577,8 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 				m.CreateSeq = modseq
 				m.ModSeq = modseq
 				m.MailboxID = mbDst.ID
+				m.SaveDate = &now
+				m.Flags, m.Keywords = restrictFlags(dstRights, m.Flags, m.Keywords)
 				if m.IsReject && m.MailboxDestinedID != 0 {
 					// Incorrectly delivered to Rejects mailbox. Adjust MailboxOrigID so this message
 					// is used for reputation calculation during future deliveries.
@@ -3314,6 +3610,8 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 					err := tx.Insert(&mr)
 					xcheckf(err, "inserting message recipient")
 				}
+				err = c.mbAccount.WordIndexCopy(tx, origID, m.ID)
+				xcheckf(err, "copying words for message")
 
 				mbDst.Add(m.MailboxCounts())
 			}
@@ -3326,8 +3624,8 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 			// Copy message files to new message ID's.
 			syncDirs := map[string]struct{}{}
 			for i := range origMsgIDs {
-				src := c.account.MessagePath(origMsgIDs[i])
-				dst := c.account.MessagePath(newMsgIDs[i])
+				src := c.mbAccount.MessagePath(origMsgIDs[i])
+				dst := c.mbAccount.MessagePath(newMsgIDs[i])
 				dstdir := filepath.Dir(dst)
 				if _, ok := syncDirs[dstdir]; !ok {
 					os.MkdirAll(dstdir, 0770)
@@ -3343,7 +3641,7 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 				xcheckf(err, "sync directory")
 			}
 
-			err = c.account.RetrainMessages(context.TODO(), c.log, tx, nmsgs, false)
+			err = c.mbAccount.RetrainMessages(context.TODO(), c.log, tx, nmsgs, false)
 			xcheckf(err, "train copied messages")
 		})
 
@@ -3357,7 +3655,7 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 			if mbKwChanged {
 				changes = append(changes, mbDst.ChangeKeywords())
 			}
-			c.broadcast(changes)
+			c.broadcastSelected(changes)
 		}
 	})
 
@@ -3381,23 +3679,70 @@ func (c *conn) cmdxMove(isUID bool, tag, cmd string, p *parser) {
 	name := p.xmailbox()
 	p.xempty()
 
-	name = xcheckmailboxname(name, true)
+	// The destination can be a mailbox of another account, shared with us.
+	acc, name, closeAcc := c.xmailboxAccount(name, true)
+	defer closeAcc()
 
 	if c.readonly {
 		xuserErrorf("mailbox open in read-only mode")
 	}
+	c.xnotVirtual()
 
 	uids, uidargs := c.gatherCopyMoveUIDs(isUID, nums)
 
-	var mbSrc, mbDst store.Mailbox
-	var changes []store.Change
+	var mbDst store.Mailbox
 	var newUIDs []store.UID
 	var modseq store.ModSeq
+	removed := uids
+	if acc != c.mbAccount {
+		// Messages expunged by another session while copying to the other account are
+		// announced to us as usual, they are not removed by us.
+		mbDst, newUIDs, removed, modseq = c.xmoveAccount(acc, name, uids, uidargs)
+	} else {
+		mbDst, newUIDs, modseq = c.xmove(name, uids, uidargs)
+	}
 
-	c.account.WithWLock(func() {
-		c.xdbwrite(func(tx *bstore.Tx) {
+	// ../rfc/9051:4708 ../rfc/6851:254
+	// ../rfc/9051:4713
+	c.bwritelinef("* OK [COPYUID %d %s %s] moved", mbDst.UIDValidity, compactUIDSet(uids).String(), compactUIDSet(newUIDs).String())
+	qresync := c.enabled[capQresync]
+	var vanishedUIDs numSet
+	for _, uid := range removed {
+		seq := c.xsequence(uid)
+		c.sequenceRemove(seq, uid)
+		if qresync {
+			vanishedUIDs.append(uint32(uid))
+		} else {
+			c.bwritelinef("* %d EXPUNGE", seq)
+		}
+	}
+	if !vanishedUIDs.empty() {
+		// VANISHED without EARLIER. ../rfc/7162:2004
+		for _, s := range vanishedUIDs.Strings(4*1024 - 32) {
+			c.bwritelinef("* VANISHED %s", s)
+		}
+	}
+
+	if c.enabled[capQresync] {
+		// ../rfc/9051:6744 ../rfc/7162:1334
+		c.writeresultf("%s OK [HIGHESTMODSEQ %d] move", tag, modseq.Client())
+	} else {
+		c.ok(tag, cmd)
+	}
+}
+
+// xmove moves messages from the selected mailbox to mailbox name of the same
+// account.
+func (c *conn) xmove(name string, uids []store.UID, uidargs []any) (mbDst store.Mailbox, newUIDs []store.UID, modseq store.ModSeq) {
+	var mbSrc store.Mailbox
+	var changes []store.Change
+
+	c.mbAccount.WithWLock(func() {
+		c.xmbdbwrite(func(tx *bstore.Tx) {
 			mbSrc = c.xmailboxID(tx, c.mailboxID) // Validate.
-			mbDst = c.xmailbox(tx, name, "TRYCREATE")
+			c.xselectedRights(tx, "rte")          // ../rfc/4314
+			var dstRights store.Rights
+			mbDst, dstRights = c.xmailboxDst(tx, c.mbAccount, name)
 			if mbDst.ID == c.mailboxID {
 				xuserErrorf("cannot move to currently selected mailbox")
 			}
@@ -3413,7 +3758,7 @@ func (c *conn) cmdxMove(isUID bool, tag, cmd string, p *parser) {
 
 			// Assign a new modseq, for the new records and for the expunged records.
 			var err error
-			modseq, err = c.account.NextModSeq(tx)
+			modseq, err = c.mbAccount.NextModSeq(tx)
 			xcheckf(err, "assigning next modseq")
 
 			// Update existing record with new UID and MailboxID in database for messages. We
@@ -3434,7 +3779,8 @@ func (c *conn) cmdxMove(isUID bool, tag, cmd string, p *parser) {
 
 			keywords := map[string]struct{}{}
 
-			conf, _ := c.account.Conf()
+			conf, _ := c.mbAccount.Conf()
+			now := time.Now()
 			for i := range msgs {
 				m := &msgs[i]
 				if m.UID != uids[i] {
@@ -3450,6 +3796,8 @@ func (c *conn) cmdxMove(isUID bool, tag, cmd string, p *parser) {
 				om.ModSeq = modseq
 
 				m.MailboxID = mbDst.ID
+				m.SaveDate = &now
+				m.Flags, m.Keywords = restrictFlags(dstRights, m.Flags, m.Keywords)
 				if m.IsReject && m.MailboxDestinedID != 0 {
 					// Incorrectly delivered to Rejects mailbox. Adjust MailboxOrigID so this message
 					// is used for reputation calculation during future deliveries.
@@ -3487,7 +3835,7 @@ func (c *conn) cmdxMove(isUID bool, tag, cmd string, p *parser) {
 			err = tx.Update(&mbDst)
 			xcheckf(err, "updating destination mailbox for uids, keywords and counts")
 
-			err = c.account.RetrainMessages(context.TODO(), c.log, tx, msgs, false)
+			err = c.mbAccount.RetrainMessages(context.TODO(), c.log, tx, msgs, false)
 			xcheckf(err, "retraining messages after move")
 
 			// Prepare broadcast changes to other connections.
@@ -3500,36 +3848,9 @@ func (c *conn) cmdxMove(isUID bool, tag, cmd string, p *parser) {
 			changes = append(changes, mbSrc.ChangeCounts(), mbDst.ChangeCounts())
 		})
 
-		c.broadcast(changes)
+		c.broadcastSelected(changes)
 	})
-
-	// ../rfc/9051:4708 ../rfc/6851:254
-	// ../rfc/9051:4713
-	c.bwritelinef("* OK [COPYUID %d %s %s] moved", mbDst.UIDValidity, compactUIDSet(uids).String(), compactUIDSet(newUIDs).String())
-	qresync := c.enabled[capQresync]
-	var vanishedUIDs numSet
-	for i := 0; i < len(uids); i++ {
-		seq := c.xsequence(uids[i])
-		c.sequenceRemove(seq, uids[i])
-		if qresync {
-			vanishedUIDs.append(uint32(uids[i]))
-		} else {
-			c.bwritelinef("* %d EXPUNGE", seq)
-		}
-	}
-	if !vanishedUIDs.empty() {
-		// VANISHED without EARLIER. ../rfc/7162:2004
-		for _, s := range vanishedUIDs.Strings(4*1024 - 32) {
-			c.bwritelinef("* VANISHED %s", s)
-		}
-	}
-
-	if c.enabled[capQresync] {
-		// ../rfc/9051:6744 ../rfc/7162:1334
-		c.writeresultf("%s OK [HIGHESTMODSEQ %d] move", tag, modseq.Client())
-	} else {
-		c.ok(tag, cmd)
-	}
+	return mbDst, newUIDs, modseq
 }
 
 // Store sets a full set of flags, or adds/removes specific flags.
@@ -3577,6 +3898,10 @@ func (c *conn) cmdxStore(isUID bool, tag, cmd string, p *parser) {
 	if c.readonly {
 		xuserErrorf("mailbox open in read-only mode")
 	}
+	// Virtual mailboxes don't have mod-sequences. ../rfc/7162
+	if c.virtual != nil && unchangedSince != nil {
+		xsyntaxErrorf("no UNCHANGEDSINCE in virtual mailbox without mod-sequences")
+	}
 
 	flags, keywords, err := store.ParseFlagsKeywords(flagstrs)
 	if err != nil {
@@ -3591,19 +3916,26 @@ func (c *conn) cmdxStore(isUID bool, tag, cmd string, p *parser) {
 		mask = store.FlagsAll
 	}
 
-	var mb, origmb store.Mailbox
 	var updated []store.Message
 	var changed []store.Message // ModSeq more recent than unchangedSince, will be in MODIFIED response code, and we will send untagged fetch responses so client is up to date.
 	var modseq store.ModSeq     // Assigned when needed.
 	modified := map[int64]bool{}
 
-	c.account.WithWLock(func() {
-		var mbKwChanged bool
+	c.mbAccount.WithWLock(func() {
 		var changes []store.Change
 
-		c.xdbwrite(func(tx *bstore.Tx) {
-			mb = c.xmailboxID(tx, c.mailboxID) // Validate.
-			origmb = mb
+		c.xmbdbwrite(func(tx *bstore.Tx) {
+			c.xcheckSelected(tx) // Validate.
+
+			// In shared mailboxes, flags we don't have the right to change are silently left
+			// alone. ../rfc/4314
+			rights := c.xselectedRights(tx, "")
+			if !strings.ContainsAny(string(rights), "swt") {
+				xusercodeErrorf("NOPERM", "no rights to change flags in mailbox")
+			}
+			other := !rights.Has("w")
+			mask = mask.Set(store.Flags{Seen: !rights.Has("s"), Answered: other, Flagged: other, Forwarded: other, Junk: other, Notjunk: other, Deleted: !rights.Has("t"), Draft: other, Phishing: other, MDNSent: other}, store.Flags{})
+			keywordsOK := rights.Has("w")
 
 			uidargs := c.xnumSetCondition(isUID, nums)
 
@@ -3611,31 +3943,50 @@ func (c *conn) cmdxStore(isUID bool, tag, cmd string, p *parser) {
 				return
 			}
 
-			// Ensure keywords are in mailbox.
-			if !minus {
-				mb.Keywords, mbKwChanged = store.MergeKeywords(mb.Keywords, keywords)
-				if mbKwChanged {
-					err := tx.Update(&mb)
-					xcheckf(err, "updating mailbox with keywords")
+			// Mailboxes of the messages, with original counts. Only the selected mailbox,
+			// except for virtual mailboxes.
+			type storeMailbox struct {
+				mb         store.Mailbox
+				origCounts store.MailboxCounts
+				kwChanged  bool
+			}
+			var mailboxes []*storeMailbox
+			xstoreMailbox := func(id int64) *storeMailbox {
+				for _, smb := range mailboxes {
+					if smb.mb.ID == id {
+						return smb
+					}
 				}
+				mb := c.xmailboxID(tx, id)
+				smb := &storeMailbox{mb: mb, origCounts: mb.MailboxCounts}
+				// Ensure keywords are in mailbox.
+				if !minus && keywordsOK {
+					smb.mb.Keywords, smb.kwChanged = store.MergeKeywords(mb.Keywords, keywords)
+				}
+				mailboxes = append(mailboxes, smb)
+				return smb
+			}
+			if c.virtual == nil {
+				xstoreMailbox(c.mailboxID)
 			}
 
-			q := bstore.QueryTx[store.Message](tx)
-			q.FilterNonzero(store.Message{MailboxID: c.mailboxID})
-			q.FilterEqual("UID", uidargs...)
-			q.FilterEqual("Expunged", false)
+			q := c.selectedQuery(tx, uidargs)
 			err := q.ForEach(func(m store.Message) error {
 				// Client may specify a message multiple times, but we only process it once. ../rfc/7162:823
 				if modified[m.ID] {
 					return nil
 				}
 
+				smb := xstoreMailbox(m.MailboxID)
+
 				mc := m.MailboxCounts()
 
 				origFlags := m.Flags
 				m.Flags = m.Flags.Set(mask, flags)
 				oldKeywords := append([]string{}, m.Keywords...)
-				if minus {
+				if !keywordsOK {
+					// Keywords are left unchanged without the write right.
+				} else if minus {
 					m.Keywords, _ = store.RemoveKeywords(m.Keywords, keywords)
 				} else if plus {
 					m.Keywords, _ = store.MergeKeywords(m.Keywords, keywords)
@@ -3677,13 +4028,13 @@ func (c *conn) cmdxStore(isUID bool, tag, cmd string, p *parser) {
 					return nil
 				}
 
-				mb.Sub(mc)
-				mb.Add(m.MailboxCounts())
+				smb.mb.Sub(mc)
+				smb.mb.Add(m.MailboxCounts())
 
 				// Assign new modseq for first actual change.
 				if modseq == 0 {
 					var err error
-					modseq, err = c.account.NextModSeq(tx)
+					modseq, err = c.mbAccount.NextModSeq(tx)
 					xcheckf(err, "next modseq")
 				}
 				m.ModSeq = modseq
@@ -3696,21 +4047,24 @@ func (c *conn) cmdxStore(isUID bool, tag, cmd string, p *parser) {
 			})
 			xcheckf(err, "storing flags in messages")
 
-			if mb.MailboxCounts != origmb.MailboxCounts {
-				err := tx.Update(&mb)
-				xcheckf(err, "updating mailbox counts")
-
-				changes = append(changes, mb.ChangeCounts())
-			}
-			if mbKwChanged {
-				changes = append(changes, mb.ChangeKeywords())
+			for _, smb := range mailboxes {
+				if smb.mb.MailboxCounts != smb.origCounts || smb.kwChanged {
+					err := tx.Update(&smb.mb)
+					xcheckf(err, "updating mailbox counts and keywords")
+				}
+				if smb.mb.MailboxCounts != smb.origCounts {
+					changes = append(changes, smb.mb.ChangeCounts())
+				}
+				if smb.kwChanged {
+					changes = append(changes, smb.mb.ChangeKeywords())
+				}
 			}
 
-			err = c.account.RetrainMessages(context.TODO(), c.log, tx, updated, false)
+			err = c.mbAccount.RetrainMessages(context.TODO(), c.log, tx, updated, false)
 			xcheckf(err, "training messages")
 		})
 
-		c.broadcast(changes)
+		c.broadcastSelected(changes)
 	})
 
 	// In the RFC, the section about STORE/UID STORE says we must return MODSEQ when
@@ -3735,7 +4089,8 @@ func (c *conn) cmdxStore(isUID bool, tag, cmd string, p *parser) {
 				modseqStr = fmt.Sprintf(" MODSEQ (%d)", m.ModSeq.Client())
 			}
 			// ../rfc/9051:6749 ../rfc/3501:4869 ../rfc/7162:2490
-			c.bwritelinef("* %d FETCH (UID %d%s%s)", c.xsequence(m.UID), m.UID, flags, modseqStr)
+			uid := c.selectedUID(m)
+			c.bwritelinef("* %d FETCH (UID %d%s%s)", c.xsequence(uid), uid, flags, modseqStr)
 		}
 	}
 
@@ -0,0 +1,253 @@
+package imapserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/store"
+)
+
+// sortMessage is a message that is sorted or threaded, with its envelope loaded
+// on first use.
+type sortMessage struct {
+	store.Message
+	seq       msgseq
+	env       *message.Envelope // Nil if not present or not yet loaded.
+	envLoaded bool
+}
+
+// envelope returns the parsed envelope of the message, or nil if it is absent.
+func (sm *sortMessage) envelope(c *conn) *message.Envelope {
+	if sm.envLoaded {
+		return sm.env
+	}
+	sm.envLoaded = true
+
+	// We only need the envelope, no need to unmarshal the full part structure.
+	var p struct {
+		Envelope *message.Envelope
+	}
+	if sm.ParsedBuf == nil {
+		c.log.Info("missing parsed message, not using envelope for sorting")
+	} else if err := json.Unmarshal(sm.ParsedBuf, &p); err != nil {
+		c.log.Errorx("unmarshal parsed message for envelope", err)
+	} else {
+		sm.env = p.Envelope
+	}
+	return sm.env
+}
+
+// sentDate returns the Date header of the message, falling back to the
+// internal/received date if the message has no valid date. ../rfc/5256
+func (sm *sortMessage) sentDate(c *conn) time.Time {
+	if env := sm.envelope(c); env != nil && !env.Date.IsZero() {
+		return env.Date
+	}
+	return sm.Received
+}
+
+// addrKey returns the string to sort on for the first address in the list. For
+// plain sort keys, that is the addr-mailbox (localpart). For display-based
+// sort keys, it is the display name, or the address if there is no display
+// name. ../rfc/5256 ../rfc/5957
+func addrKey(l []message.Address, display bool) string {
+	if len(l) == 0 {
+		return ""
+	}
+	a := l[0]
+	if !display {
+		return strings.ToLower(a.User)
+	}
+	if a.Name != "" {
+		return strings.ToLower(a.Name)
+	}
+	if a.Host == "" {
+		return strings.ToLower(a.User)
+	}
+	return strings.ToLower(a.User + "@" + a.Host)
+}
+
+// sortCompare returns -1, 0 or 1, comparing messages a and b for sort key k.
+func (c *conn) sortCompare(k string, a, b *sortMessage) int {
+	cmpTime := func(x, y time.Time) int {
+		if x.Before(y) {
+			return -1
+		} else if x.After(y) {
+			return 1
+		}
+		return 0
+	}
+	addrs := func(sm *sortMessage, field string) []message.Address {
+		env := sm.envelope(c)
+		if env == nil {
+			return nil
+		}
+		switch field {
+		case "FROM":
+			return env.From
+		case "TO":
+			return env.To
+		case "CC":
+			return env.CC
+		}
+		panic("missing case")
+	}
+
+	switch k {
+	case "ARRIVAL":
+		return cmpTime(a.Received, b.Received)
+	case "DATE":
+		return cmpTime(a.sentDate(c), b.sentDate(c))
+	case "SIZE":
+		if a.Size < b.Size {
+			return -1
+		} else if a.Size > b.Size {
+			return 1
+		}
+		return 0
+	case "SUBJECT":
+		// SubjectBase is the lower-cased base subject. ../rfc/5256
+		return strings.Compare(a.SubjectBase, b.SubjectBase)
+	case "FROM", "TO", "CC":
+		return strings.Compare(addrKey(addrs(a, k), false), addrKey(addrs(b, k), false))
+	case "DISPLAYFROM":
+		return strings.Compare(addrKey(addrs(a, "FROM"), true), addrKey(addrs(b, "FROM"), true))
+	case "DISPLAYTO":
+		return strings.Compare(addrKey(addrs(a, "TO"), true), addrKey(addrs(b, "TO"), true))
+	}
+	panic(serverError{fmt.Errorf("missing case for sort key %q", k)})
+}
+
+// Sort returns the messages matching search criteria, ordered by sort criteria.
+//
+// State: Selected
+func (c *conn) cmdxSort(isUID bool, tag, cmd string, p *parser) {
+	// Command: ../rfc/5256 ../rfc/5267
+	// Examples: ../rfc/5256 ../rfc/5267
+	// Syntax: ../rfc/5256 ../rfc/5957 ../rfc/5267 ../rfc/9394
+
+	// With RETURN, we respond with ESEARCH instead of SORT, as specified by ESORT.
+	var eargs map[string]bool // Nil means old-style SORT response.
+	var partial *partialRange // For PARTIAL return option. ../rfc/9394
+	if p.take(" RETURN (") {
+		eargs = map[string]bool{}
+		for !p.take(")") {
+			if len(eargs) > 0 || partial != nil {
+				p.xspace()
+			}
+			if w, ok := p.takelist("MIN", "MAX", "ALL", "COUNT", "PARTIAL"); !ok {
+				xsyntaxErrorf("ESORT result option not supported")
+			} else if w == "PARTIAL" {
+				if partial != nil {
+					xsyntaxErrorf("duplicate PARTIAL result option")
+				}
+				p.xspace()
+				pr := p.xpartialRange()
+				partial = &pr
+			} else {
+				eargs[w] = true
+			}
+		}
+		// Like ESEARCH, ALL is the default. ../rfc/5267 ../rfc/4731:149
+		if len(eargs) == 0 && partial == nil {
+			eargs["ALL"] = true
+		}
+	}
+	p.xspace()
+	criteria := p.xsortCriteria()
+	p.xspace()
+	// Charset is required for SORT. ../rfc/5256
+	xcheckSearchCharset(p.xastring())
+	p.xspace()
+	sk := p.xsearchProgram()
+
+	var expungeIssued bool
+	msgs, maxModSeq := c.xsearchMessages(sk, &expungeIssued)
+
+	l := make([]*sortMessage, len(msgs))
+	for i, m := range msgs {
+		l[i] = &sortMessage{Message: m, seq: c.xsequence(m.UID)}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		a, b := l[i], l[j]
+		for _, sc := range criteria {
+			r := c.sortCompare(sc.key, a, b)
+			if sc.reverse {
+				r = -r
+			}
+			if r != 0 {
+				return r < 0
+			}
+		}
+		// Ties are ordered by sequence number. ../rfc/5256
+		return a.seq < b.seq
+	})
+
+	// We keep the numbers as UIDs for convenience, even if they are message sequence
+	// numbers.
+	nums := make([]store.UID, len(l))
+	for i, sm := range l {
+		if isUID {
+			nums[i] = sm.UID
+		} else {
+			nums[i] = store.UID(sm.seq)
+		}
+	}
+
+	if eargs == nil {
+		// Response syntax: ../rfc/5256 ../rfc/7162:2557
+		var s string
+		for _, num := range nums {
+			s += fmt.Sprintf(" %d", num)
+		}
+		if sk.hasModseq() && len(nums) > 0 {
+			s += fmt.Sprintf(" (MODSEQ %d)", maxModSeq.Client())
+		}
+		c.bwritelinef("* SORT%s", s)
+	} else {
+		// ESORT returns an ESEARCH response, with numbers in sort order. ../rfc/5267
+		resp := fmt.Sprintf(`* ESEARCH (TAG "%s")`, tag)
+		if isUID {
+			resp += " UID"
+		}
+		// MIN and MAX are the first and last messages in sort order. No MIN/MAX without
+		// matches.
+		if eargs["MIN"] && len(nums) > 0 {
+			resp += fmt.Sprintf(" MIN %d", nums[0])
+		}
+		if eargs["MAX"] && len(nums) > 0 {
+			resp += fmt.Sprintf(" MAX %d", nums[len(nums)-1])
+		}
+		if eargs["COUNT"] {
+			resp += fmt.Sprintf(" COUNT %d", len(nums))
+		}
+		// compactUIDSet only merges ascending runs, so the sort order is kept.
+		if eargs["ALL"] && len(nums) > 0 {
+			resp += fmt.Sprintf(" ALL %s", compactUIDSet(nums).String())
+		}
+		if partial != nil {
+			// The part of the results in sort order, NIL if the range has no results.
+			// ../rfc/9394
+			set := "NIL"
+			if l := partial.apply(nums); len(l) > 0 {
+				set = compactUIDSet(l).String()
+			}
+			resp += fmt.Sprintf(" PARTIAL (%s %s)", partial, set)
+		}
+		if sk.hasModseq() && len(nums) > 0 {
+			resp += fmt.Sprintf(" MODSEQ %d", maxModSeq.Client())
+		}
+		c.bwritelinef("%s", resp)
+	}
+
+	if expungeIssued {
+		// ../rfc/9051:5102
+		c.writeresultf("%s OK [EXPUNGEISSUED] done", tag)
+	} else {
+		c.ok(tag, cmd)
+	}
+}
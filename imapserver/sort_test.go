@@ -0,0 +1,112 @@
+package imapserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestSort(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+
+	tc.client.Login("mjl@mox.example", "testtest")
+	tc.client.Select("inbox")
+
+	msg := func(date, from, to, subject, body string) string {
+		return strings.ReplaceAll("Date: "+date+"\nFrom: "+from+"\nTo: "+to+"\nSubject: "+subject+"\n\n"+body+"\n", "\n", "\r\n")
+	}
+	received1 := time.Date(2022, time.January, 3, 10, 0, 0, 0, time.UTC)
+	received2 := time.Date(2022, time.January, 1, 10, 0, 0, 0, time.UTC)
+	received3 := time.Date(2022, time.January, 2, 10, 0, 0, 0, time.UTC)
+	tc.client.Append("inbox", nil, &received1, []byte(msg("Sun, 2 Jan 2022 10:00:00 +0100", `"Zed" <a@mox.example>`, "<c@mox.example>", "Re: beta", "a longer body than the others")))
+	tc.client.Append("inbox", nil, &received2, []byte(msg("Sat, 1 Jan 2022 10:00:00 +0100", "<c@mox.example>", `"Bob" <b@mox.example>`, "alpha", "short")))
+	tc.client.Append("inbox", nil, &received3, []byte(msg("Mon, 3 Jan 2022 10:00:00 +0100", `"Anna" <b@mox.example>`, `"Alice" <a@mox.example>`, "Beta", "body")))
+
+	tc.transactf("bad", "sort")                                    // Missing params.
+	tc.transactf("bad", "sort (arrival)")                          // Missing charset.
+	tc.transactf("bad", "sort (arrival) utf-8")                    // Missing search key.
+	tc.transactf("bad", "sort () utf-8 all")                       // Missing sort criteria.
+	tc.transactf("bad", "sort (bogus) utf-8 all")                  // Unknown sort criteria.
+	tc.transactf("bad", "sort (reverse) utf-8 all")                // Missing sort key after reverse.
+	tc.transactf("no", "sort (arrival) bogus all")                 // Unknown charset.
+	tc.transactf("bad", "sort return (bogus) (arrival) utf-8 all") // Unknown return option.
+
+	tc.transactf("ok", "sort (arrival) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{2, 3, 1})
+
+	tc.transactf("ok", "sort (date) us-ascii all")
+	tc.xuntagged(imapclient.UntaggedSort{2, 1, 3})
+
+	tc.transactf("ok", "sort (reverse date) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{3, 1, 2})
+
+	tc.transactf("ok", "sort (from) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{1, 3, 2})
+
+	tc.transactf("ok", "sort (to) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{3, 2, 1})
+
+	tc.transactf("ok", "sort (cc) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{1, 2, 3}) // All equal, sequence order.
+
+	tc.transactf("ok", "sort (displayfrom) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{3, 2, 1})
+
+	tc.transactf("ok", "sort (displayto) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{3, 2, 1})
+
+	tc.transactf("ok", "sort (size) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{2, 3, 1})
+
+	tc.transactf("ok", "sort (subject) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{2, 1, 3})
+
+	tc.transactf("ok", "sort (subject reverse date) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{2, 3, 1})
+
+	tc.transactf("ok", "uid sort (reverse arrival) utf-8 all")
+	tc.xuntagged(imapclient.UntaggedSort{1, 3, 2})
+
+	tc.transactf("ok", "sort (arrival) utf-8 subject beta")
+	tc.xuntagged(imapclient.UntaggedSort{3, 1})
+
+	tc.transactf("ok", "sort (arrival) utf-8 subject bogus")
+	tc.xuntagged(imapclient.UntaggedSort(nil))
+
+	// ESORT.
+	tc.transactf("ok", "sort return () (arrival) utf-8 all")
+	tc.xesearch(imapclient.UntaggedEsearch{All: esearchall0("2:3,1")})
+
+	tc.transactf("ok", "uid sort return (min max count all) (reverse arrival) utf-8 all")
+	count := uint32(3)
+	tc.xesearch(imapclient.UntaggedEsearch{UID: true, Min: 1, Max: 2, Count: &count, All: esearchall0("1,3,2")})
+
+	tc.transactf("ok", "sort return (count) (arrival) utf-8 subject bogus")
+	count = 0
+	tc.xesearch(imapclient.UntaggedEsearch{Count: &count})
+
+	// PARTIAL, in sort order. ../rfc/9394
+	tc.transactf("bad", "sort return (partial) (arrival) utf-8 all")
+	tc.transactf("bad", "sort return (partial 0:1) (arrival) utf-8 all")
+	tc.transactf("bad", "sort return (partial -1:2) (arrival) utf-8 all")
+	tc.transactf("bad", "sort return (partial 1:2 partial 1:2) (arrival) utf-8 all")
+
+	tc.transactf("ok", "sort return (partial 2:5) (arrival) utf-8 all")
+	tc.xesearch(imapclient.UntaggedEsearch{Exts: []imapclient.EsearchDataExt{esearchPartial("2:5", "3,1")}})
+
+	tc.transactf("ok", "sort return (partial 5:2) (arrival) utf-8 all")
+	tc.xesearch(imapclient.UntaggedEsearch{Exts: []imapclient.EsearchDataExt{esearchPartial("2:5", "3,1")}})
+
+	tc.transactf("ok", "sort return (count partial -1:-2) (arrival) utf-8 all")
+	count = 3
+	tc.xesearch(imapclient.UntaggedEsearch{Count: &count, Exts: []imapclient.EsearchDataExt{esearchPartial("-1:-2", "3,1")}})
+
+	tc.transactf("ok", "uid sort return (partial -3:-5) (reverse arrival) utf-8 all")
+	tc.xesearch(imapclient.UntaggedEsearch{UID: true, Exts: []imapclient.EsearchDataExt{esearchPartial("-3:-5", "1")}})
+
+	tc.transactf("ok", "sort return (partial 4:5) (arrival) utf-8 all")
+	tc.xesearch(imapclient.UntaggedEsearch{Exts: []imapclient.EsearchDataExt{esearchPartial("4:5", "NIL")}})
+}
@@ -31,4 +31,11 @@ func TestStatus(t *testing.T) {
 	tc.client.StoreFlagsSet("1", true, `\Deleted`)
 	tc.transactf("ok", "status inbox (messages uidnext uidvalidity unseen deleted size recent appendlimit)")
 	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "Inbox", Attrs: map[string]int64{"MESSAGES": 1, "UIDVALIDITY": 1, "UIDNEXT": 2, "UNSEEN": 1, "DELETED": 1, "SIZE": 4, "RECENT": 0, "APPENDLIMIT": 0}})
+
+	// DELETED-STORAGE from QUOTA, in units of 1024 octets, rounded up.
+	tc.transactf("ok", "status inbox (deleted-storage)")
+	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "Inbox", Attrs: map[string]int64{"DELETED-STORAGE": 1}})
+	tc.client.StoreFlagsClear("1", true, `\Deleted`)
+	tc.transactf("ok", "status inbox (deleted-storage)")
+	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "Inbox", Attrs: map[string]int64{"DELETED-STORAGE": 0}})
 }
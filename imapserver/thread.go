@@ -0,0 +1,209 @@
+package imapserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// threadNode is a message in a thread tree, as returned by THREAD.
+type threadNode struct {
+	sm       *sortMessage // Nil for a dummy node that groups messages without a common parent.
+	children []*threadNode
+}
+
+// date returns the sent date to order the node by. For dummy nodes, that is
+// the date of its first child, so children must be sorted first. ../rfc/5256
+func (n *threadNode) date(c *conn) time.Time {
+	if n.sm == nil {
+		return n.children[0].date(c)
+	}
+	return n.sm.sentDate(c)
+}
+
+func (n *threadNode) seq() msgseq {
+	if n.sm == nil {
+		return n.children[0].seq()
+	}
+	return n.sm.seq
+}
+
+// sortThreadNodes sorts the nodes and their children recursively by sent date,
+// with ties broken by sequence number.
+func (c *conn) sortThreadNodes(l []*threadNode) {
+	for _, n := range l {
+		c.sortThreadNodes(n.children)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		a, b := l[i].date(c), l[j].date(c)
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return l[i].seq() < l[j].seq()
+	})
+}
+
+// members returns the response syntax for the node and its descendants,
+// without the outer parenthesis. A single child is written as a continuation
+// of the parent, multiple children each get their own parenthesized list.
+func (n *threadNode) members(isUID bool) string {
+	var l []string
+	for n.sm != nil {
+		if isUID {
+			l = append(l, fmt.Sprintf("%d", n.sm.UID))
+		} else {
+			l = append(l, fmt.Sprintf("%d", n.sm.seq))
+		}
+		if len(n.children) != 1 {
+			break
+		}
+		n = n.children[0]
+	}
+	var nested string
+	if n.sm == nil || len(n.children) > 1 {
+		for _, ch := range n.children {
+			nested += "(" + ch.members(isUID) + ")"
+		}
+	}
+	if nested != "" {
+		l = append(l, nested)
+	}
+	return strings.Join(l, " ")
+}
+
+// threadOrderedSubject groups messages by base subject. The oldest message is
+// the parent of the other messages with the same base subject. ../rfc/5256
+func (c *conn) threadOrderedSubject(msgs []*sortMessage) []*threadNode {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		a, b := msgs[i], msgs[j]
+		if a.SubjectBase != b.SubjectBase {
+			return a.SubjectBase < b.SubjectBase
+		}
+		da, db := a.sentDate(c), b.sentDate(c)
+		if !da.Equal(db) {
+			return da.Before(db)
+		}
+		return a.seq < b.seq
+	})
+
+	var roots []*threadNode
+	var root *threadNode
+	for _, sm := range msgs {
+		if root != nil && root.sm.SubjectBase == sm.SubjectBase {
+			root.children = append(root.children, &threadNode{sm: sm})
+			continue
+		}
+		root = &threadNode{sm: sm}
+		roots = append(roots, root)
+	}
+	// Children are already sorted, only the threads themselves are sorted by date
+	// of the first message.
+	sort.SliceStable(roots, func(i, j int) bool {
+		a, b := roots[i].date(c), roots[j].date(c)
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return roots[i].seq() < roots[j].seq()
+	})
+	return roots
+}
+
+// threadReferences builds threads using the threading information stored with
+// messages, which is based on the References, In-Reply-To and Subject headers
+// as described in RFC 5256. A message becomes a child of its closest ancestor
+// that is also in msgs. Messages of the same thread without a common ancestor in
+// msgs are grouped under a dummy node. ../rfc/5256
+func (c *conn) threadReferences(msgs []*sortMessage) []*threadNode {
+	nodes := map[int64]*threadNode{}
+	for _, sm := range msgs {
+		nodes[sm.ID] = &threadNode{sm: sm}
+	}
+
+	threadRoots := map[int64][]*threadNode{}
+	var threadIDs []int64
+	for _, sm := range msgs {
+		n := nodes[sm.ID]
+		var parent *threadNode
+		for _, pid := range sm.ThreadParentIDs {
+			if parent = nodes[pid]; parent != nil {
+				break
+			}
+		}
+		if parent != nil {
+			parent.children = append(parent.children, n)
+			continue
+		}
+		tid := sm.ThreadID
+		if tid == 0 {
+			// Not yet assigned to a thread, e.g. during upgrade, treat as its own thread.
+			tid = -sm.ID
+		}
+		if _, ok := threadRoots[tid]; !ok {
+			threadIDs = append(threadIDs, tid)
+		}
+		threadRoots[tid] = append(threadRoots[tid], n)
+	}
+
+	var roots []*threadNode
+	for _, tid := range threadIDs {
+		l := threadRoots[tid]
+		if len(l) == 1 {
+			roots = append(roots, l[0])
+		} else {
+			roots = append(roots, &threadNode{children: l})
+		}
+	}
+	c.sortThreadNodes(roots)
+	return roots
+}
+
+// Thread returns the messages matching search criteria, grouped into threads.
+//
+// State: Selected
+func (c *conn) cmdxThread(isUID bool, tag, cmd string, p *parser) {
+	// Command: ../rfc/5256
+	// Examples: ../rfc/5256
+	// Syntax: ../rfc/5256
+
+	p.xspace()
+	algorithm := p.xtakelist("ORDEREDSUBJECT", "REFERENCES")
+	p.xspace()
+	// Charset is required for THREAD. ../rfc/5256
+	xcheckSearchCharset(p.xastring())
+	p.xspace()
+	sk := p.xsearchProgram()
+
+	var expungeIssued bool
+	msgs, _ := c.xsearchMessages(sk, &expungeIssued)
+
+	l := make([]*sortMessage, len(msgs))
+	for i, m := range msgs {
+		l[i] = &sortMessage{Message: m, seq: c.xsequence(m.UID)}
+	}
+
+	var roots []*threadNode
+	switch algorithm {
+	case "ORDEREDSUBJECT":
+		roots = c.threadOrderedSubject(l)
+	case "REFERENCES":
+		roots = c.threadReferences(l)
+	}
+
+	// Response syntax: ../rfc/5256
+	var s string
+	for _, n := range roots {
+		s += "(" + n.members(isUID) + ")"
+	}
+	if s != "" {
+		s = " " + s
+	}
+	c.bwritelinef("* THREAD%s", s)
+
+	if expungeIssued {
+		// ../rfc/9051:5102
+		c.writeresultf("%s OK [EXPUNGEISSUED] done", tag)
+	} else {
+		c.ok(tag, cmd)
+	}
+}
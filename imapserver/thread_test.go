@@ -0,0 +1,64 @@
+package imapserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/imapclient"
+)
+
+func TestThread(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+
+	tc.client.Login("mjl@mox.example", "testtest")
+	tc.client.Select("inbox")
+
+	msg := func(day, msgID, inReplyTo, subject string) string {
+		s := "Date: " + day + " Jan 2022 10:00:00 +0100\nMessage-ID: <" + msgID + "@mox.example>\nSubject: " + subject + "\n"
+		if inReplyTo != "" {
+			s += "In-Reply-To: <" + inReplyTo + "@mox.example>\n"
+		}
+		s += "\nbody\n"
+		return strings.ReplaceAll(s, "\n", "\r\n")
+	}
+	tc.client.Append("inbox", nil, nil, []byte(msg("1", "1", "", "a")))
+	tc.client.Append("inbox", nil, nil, []byte(msg("2", "2", "1", "Re: a")))
+	tc.client.Append("inbox", nil, nil, []byte(msg("3", "3", "", "b")))
+	tc.client.Append("inbox", nil, nil, []byte(msg("4", "4", "1", "Re: a")))
+	tc.client.Append("inbox", nil, nil, []byte(msg("5", "5", "2", "Re: a")))
+
+	tc.transactf("bad", "thread")                       // Missing params.
+	tc.transactf("bad", "thread references")            // Missing charset.
+	tc.transactf("bad", "thread references utf-8")      // Missing search key.
+	tc.transactf("bad", "thread bogus utf-8 all")       // Unknown algorithm.
+	tc.transactf("no", "thread references bogus all")   // Unknown charset.
+	tc.transactf("bad", "thread references utf-8 all ") // Leftover data.
+
+	num := func(n uint32, children ...imapclient.ThreadNode) imapclient.ThreadNode {
+		return imapclient.ThreadNode{Num: n, Children: children}
+	}
+
+	tc.transactf("ok", "thread references utf-8 all")
+	tc.xuntagged(imapclient.UntaggedThread{num(1, num(2, num(5)), num(4)), num(3)})
+
+	tc.transactf("ok", "uid thread references utf-8 all")
+	tc.xuntagged(imapclient.UntaggedThread{num(1, num(2, num(5)), num(4)), num(3)})
+
+	// Without the root message, its children are grouped without parent.
+	tc.transactf("ok", "thread references utf-8 not 1")
+	tc.xuntagged(imapclient.UntaggedThread{num(0, num(2, num(5)), num(4)), num(3)})
+
+	// Without the intermediate message, message 5 becomes a child of message 1.
+	tc.transactf("ok", "thread references utf-8 not 2")
+	tc.xuntagged(imapclient.UntaggedThread{num(1, num(4), num(5)), num(3)})
+
+	tc.transactf("ok", "thread orderedsubject utf-8 all")
+	tc.xuntagged(imapclient.UntaggedThread{num(1, num(2), num(4), num(5)), num(3)})
+
+	tc.transactf("ok", "thread orderedsubject utf-8 subject b")
+	tc.xuntagged(imapclient.UntaggedThread{num(3)})
+
+	tc.transactf("ok", "thread references utf-8 subject bogus")
+	tc.xuntagged(imapclient.UntaggedThread(nil))
+}
@@ -0,0 +1,358 @@
+package imapserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/store"
+)
+
+// Saved searches of an account are virtual mailboxes, as "#Virtual/<name>". The
+// messages in a virtual mailbox are the messages in regular mailboxes matching the
+// saved search, with their message ID as UID, which stays the same when messages
+// are moved to another mailbox. The messages are gathered when the virtual mailbox
+// is selected. Flags can be changed through a virtual mailbox, messages cannot be
+// added or removed. Messages that are expunged or moved to a mailbox that doesn't
+// match are removed from the session, new matching messages are added. Messages
+// that no longer match after a flag change are kept until the next select.
+// Virtual mailboxes don't have persistent mod-sequences. ../rfc/7162
+
+// virtualMailbox is the state for a selected virtual mailbox.
+type virtualMailbox struct {
+	ss store.SavedSearch
+
+	// UID in virtual mailbox, for messages in the session by mailbox and UID. For
+	// translating changes of messages to changes in the virtual mailbox.
+	uids map[messageKey]store.UID
+}
+
+type messageKey struct {
+	mailboxID int64
+	uid       store.UID
+}
+
+// parseVirtualName returns the name of the saved search for name in the
+// "#Virtual" namespace. If name is not in the namespace, ok is false.
+func parseVirtualName(name string) (ssName string, ok bool) {
+	if !strings.HasPrefix(name, store.VirtualPrefix) {
+		return "", false
+	}
+	return name[len(store.VirtualPrefix):], true
+}
+
+// xsavedSearch returns the saved search by name, or a user error if it doesn't
+// exist.
+func (c *conn) xsavedSearch(tx *bstore.Tx, name string) store.SavedSearch {
+	ss, err := bstore.QueryTx[store.SavedSearch](tx).FilterNonzero(store.SavedSearch{Name: name}).Get()
+	if err == bstore.ErrAbsent {
+		xuserErrorf("%w", store.ErrUnknownMailbox)
+	}
+	xcheckf(err, "looking up saved search")
+	return ss
+}
+
+// xnotVirtual fails the command if a virtual mailbox is selected. Messages can
+// not be added to or removed from a virtual mailbox.
+func (c *conn) xnotVirtual() {
+	if c.virtual != nil {
+		xusercodeErrorf("CANNOT", "not possible in virtual mailbox")
+	}
+}
+
+// xcheckSelected checks that the selected mailbox still exists. For a virtual
+// mailbox, the saved search must still exist with the same filters.
+func (c *conn) xcheckSelected(tx *bstore.Tx) {
+	if c.virtual == nil {
+		c.xmailboxID(tx, c.mailboxID)
+		return
+	}
+	ss := store.SavedSearch{ID: c.virtual.ss.ID}
+	err := tx.Get(&ss)
+	if err == bstore.ErrAbsent || err == nil && ss.UIDValidity != c.virtual.ss.UIDValidity {
+		xuserErrorf("saved search removed or changed, select virtual mailbox again")
+	}
+	xcheckf(err, "get saved search")
+}
+
+// selectedQuery returns a query for non-expunged messages in the selected
+// mailbox with UIDs in uidargs, as returned by xnumSetCondition.
+func (c *conn) selectedQuery(tx *bstore.Tx, uidargs []any) *bstore.Query[store.Message] {
+	q := bstore.QueryTx[store.Message](tx)
+	if c.virtual != nil {
+		ids := make([]any, len(uidargs))
+		for i, uid := range uidargs {
+			ids[i] = int64(uid.(store.UID))
+		}
+		q.FilterEqual("ID", ids...)
+	} else {
+		q.FilterNonzero(store.Message{MailboxID: c.mailboxID})
+		q.FilterEqual("UID", uidargs...)
+	}
+	q.FilterEqual("Expunged", false)
+	return q
+}
+
+// selectedUID returns the UID of m in the selected mailbox.
+func (c *conn) selectedUID(m store.Message) store.UID {
+	if c.virtual != nil {
+		return store.UID(m.ID)
+	}
+	return m.UID
+}
+
+// xvirtualUIDNext returns the UIDNEXT for virtual mailboxes. New messages get a
+// higher message ID than all messages so far.
+func xvirtualUIDNext(tx *bstore.Tx) store.UID {
+	q := bstore.QueryTx[store.Message](tx)
+	q.SortDesc("ID")
+	q.Limit(1)
+	m, err := q.Get()
+	if err == bstore.ErrAbsent {
+		return 1
+	}
+	xcheckf(err, "looking up last message id")
+	return store.UID(m.ID) + 1
+}
+
+// xvirtualSelect selects the virtual mailbox for saved search ssName, for
+// SELECT and EXAMINE. The regular mailbox must already be unselected.
+func (c *conn) xvirtualSelect(isselect bool, tag, ssName string) {
+	c.mbAccount, c.mbComm = c.account, c.comm
+	defer func() {
+		if c.state != stateSelected {
+			c.unselect()
+		}
+	}()
+
+	v := &virtualMailbox{uids: map[messageKey]store.UID{}}
+	var firstUnseen msgseq
+	var uidNext store.UID
+	keywords := map[string]bool{}
+	c.account.WithRLock(func() {
+		c.xdbread(func(tx *bstore.Tx) {
+			v.ss = c.xsavedSearch(tx, ssName)
+
+			c.uids = []store.UID{}
+			err := c.account.SavedSearchMessages(c.log, tx, v.ss, func(m store.Message) error {
+				uid := store.UID(m.ID)
+				c.uids = append(c.uids, uid)
+				v.uids[messageKey{m.MailboxID, m.UID}] = uid
+				if firstUnseen == 0 && !m.Seen {
+					firstUnseen = msgseq(len(c.uids))
+				}
+				for _, kw := range m.Keywords {
+					keywords[kw] = true
+				}
+				return nil
+			})
+			xcheckf(err, "evaluating saved search")
+			uidNext = xvirtualUIDNext(tx)
+		})
+	})
+	c.applyPending(true)
+
+	var flags string
+	if len(keywords) > 0 {
+		l := make([]string, 0, len(keywords))
+		for kw := range keywords {
+			l = append(l, kw)
+		}
+		sort.Strings(l)
+		flags = " " + strings.Join(l, " ")
+	}
+	c.bwritelinef(`* FLAGS (\Seen \Answered \Flagged \Deleted \Draft $Forwarded $Junk $NotJunk $Phishing $MDNSent%s)`, flags)
+	c.bwritelinef(`* OK [PERMANENTFLAGS (\Seen \Answered \Flagged \Deleted \Draft $Forwarded $Junk $NotJunk $Phishing $MDNSent \*)] x`)
+	if !c.enabled[capIMAP4rev2] {
+		c.bwritelinef(`* 0 RECENT`)
+	}
+	c.bwritelinef(`* %d EXISTS`, len(c.uids))
+	if !c.enabled[capIMAP4rev2] && firstUnseen > 0 {
+		c.bwritelinef(`* OK [UNSEEN %d] x`, firstUnseen)
+	}
+	c.bwritelinef(`* OK [UIDVALIDITY %d] x`, v.ss.UIDValidity)
+	c.bwritelinef(`* OK [UIDNEXT %d] x`, uidNext)
+	c.bwritelinef(`* OK [MAILBOXID (%s)] x`, virtualObjectID(v.ss.ID)) // ../rfc/8474
+	var listFlags string
+	if v.ss.All {
+		listFlags = `\All`
+	}
+	c.bwritelinef(`* LIST (%s) "/" %s`, listFlags, astring(c.encodeMailbox(store.VirtualPrefix+v.ss.Name)).pack(c))
+	if c.enabled[capCondstore] {
+		// Virtual mailboxes don't have mod-sequences. ../rfc/7162
+		c.bwritelinef(`* OK [NOMODSEQ] virtual mailbox`)
+	}
+
+	// Messages cannot be added or removed, but flags can be changed.
+	if isselect {
+		c.bwriteresultf("%s OK [READ-WRITE] x", tag)
+		c.readonly = false
+	} else {
+		c.bwriteresultf("%s OK [READ-ONLY] x", tag)
+		c.readonly = true
+	}
+	c.virtual = v
+	c.state = stateSelected
+	c.searchResult = nil
+	c.xflush()
+}
+
+// xvirtualStatusLine returns a STATUS response for the virtual mailbox of ss.
+func (c *conn) xvirtualStatusLine(tx *bstore.Tx, ss store.SavedSearch, attrs []string) string {
+	var mc store.MailboxCounts
+	var deletedSize int64
+	err := c.account.SavedSearchMessages(c.log, tx, ss, func(m store.Message) error {
+		mc.Add(m.MailboxCounts())
+		if m.Deleted {
+			deletedSize += m.Size
+		}
+		return nil
+	})
+	xcheckf(err, "evaluating saved search")
+	uidNext := xvirtualUIDNext(tx)
+
+	status := []string{}
+	for _, a := range attrs {
+		A := strings.ToUpper(a)
+		switch A {
+		case "MESSAGES":
+			status = append(status, A, fmt.Sprintf("%d", mc.Total+mc.Deleted))
+		case "UIDNEXT":
+			status = append(status, A, fmt.Sprintf("%d", uidNext))
+		case "UIDVALIDITY":
+			status = append(status, A, fmt.Sprintf("%d", ss.UIDValidity))
+		case "UNSEEN":
+			status = append(status, A, fmt.Sprintf("%d", mc.Unseen))
+		case "DELETED":
+			status = append(status, A, fmt.Sprintf("%d", mc.Deleted))
+		case "SIZE":
+			status = append(status, A, fmt.Sprintf("%d", mc.Size))
+		case "DELETED-STORAGE":
+			status = append(status, A, fmt.Sprintf("%d", (deletedSize+1024-1)/1024))
+		case "RECENT":
+			status = append(status, A, "0")
+		case "APPENDLIMIT":
+			status = append(status, A, "NIL")
+		case "HIGHESTMODSEQ":
+			// No persistent mod-sequences. ../rfc/7162
+			status = append(status, A, "0")
+		case "MAILBOXID":
+			status = append(status, A, fmt.Sprintf("(%s)", virtualObjectID(ss.ID)))
+		default:
+			xsyntaxErrorf("unknown attribute %q", a)
+		}
+	}
+	return fmt.Sprintf("* STATUS %s (%s)", astring(c.encodeMailbox(store.VirtualPrefix+ss.Name)).pack(c), strings.Join(status, " "))
+}
+
+// virtualChanges returns changes with changes for the selected virtual mailbox
+// added, with mailbox ID 0 and virtual UIDs. Messages that were moved keep their
+// virtual UID. New messages are added if they match the saved search.
+func (c *conn) virtualChanges(changes []store.Change) []store.Change {
+	v := c.virtual
+
+	var flagChanges []store.Change
+	removed := map[store.UID]store.ModSeq{}
+	var adds []store.ChangeAddUID
+	for _, change := range changes {
+		switch ch := change.(type) {
+		case store.ChangeAddUID:
+			adds = append(adds, ch)
+		case store.ChangeRemoveUIDs:
+			for _, uid := range ch.UIDs {
+				k := messageKey{ch.MailboxID, uid}
+				if vuid, ok := v.uids[k]; ok {
+					delete(v.uids, k)
+					removed[vuid] = ch.ModSeq
+				}
+			}
+		case store.ChangeFlags:
+			if vuid, ok := v.uids[messageKey{ch.MailboxID, ch.UID}]; ok {
+				ch.MailboxID = 0
+				ch.UID = vuid
+				flagChanges = append(flagChanges, ch)
+			}
+		}
+	}
+
+	var newUIDs []store.ChangeAddUID
+	if len(adds) > 0 {
+		c.account.WithRLock(func() {
+			c.xdbread(func(tx *bstore.Tx) {
+				sm, err := c.account.NewSavedSearchMatcher(c.log, tx, v.ss)
+				xcheckf(err, "preparing saved search")
+				defer sm.Close()
+
+				type candidate struct {
+					key messageKey
+					ch  store.ChangeAddUID
+				}
+				candidates := map[store.UID]candidate{}
+				for _, ch := range adds {
+					q := bstore.QueryTx[store.Message](tx)
+					q.FilterNonzero(store.Message{MailboxID: ch.MailboxID, UID: ch.UID})
+					q.FilterEqual("Expunged", false)
+					m, err := q.Get()
+					if err == bstore.ErrAbsent {
+						continue
+					}
+					xcheckf(err, "get new message")
+					match, err := sm.Match(m)
+					xcheckf(err, "matching message against saved search")
+					if match {
+						candidates[store.UID(m.ID)] = candidate{messageKey{ch.MailboxID, ch.UID}, ch}
+					}
+				}
+
+				// New UIDs must be added in increasing order.
+				vuids := make([]store.UID, 0, len(candidates))
+				for vuid := range candidates {
+					vuids = append(vuids, vuid)
+				}
+				sort.Slice(vuids, func(i, j int) bool {
+					return vuids[i] < vuids[j]
+				})
+				var last store.UID
+				if len(c.uids) > 0 {
+					last = c.uids[len(c.uids)-1]
+				}
+				for _, vuid := range vuids {
+					cand := candidates[vuid]
+					if _, ok := removed[vuid]; ok {
+						// Moved to another mailbox that matches.
+						delete(removed, vuid)
+					} else if vuid > last {
+						last = vuid
+						ch := cand.ch
+						newUIDs = append(newUIDs, store.ChangeAddUID{MailboxID: 0, UID: vuid, ModSeq: ch.ModSeq, Flags: ch.Flags, Keywords: ch.Keywords})
+					} else {
+						continue
+					}
+					v.uids[cand.key] = vuid
+				}
+			})
+		})
+	}
+
+	changes = append(changes, flagChanges...)
+	if len(removed) > 0 {
+		var modseq store.ModSeq
+		uids := make([]store.UID, 0, len(removed))
+		for uid, ms := range removed {
+			uids = append(uids, uid)
+			if ms > modseq {
+				modseq = ms
+			}
+		}
+		sort.Slice(uids, func(i, j int) bool {
+			return uids[i] < uids[j]
+		})
+		changes = append(changes, store.ChangeRemoveUIDs{MailboxID: 0, UIDs: uids, ModSeq: modseq})
+	}
+	for _, ch := range newUIDs {
+		changes = append(changes, ch)
+	}
+	return changes
+}
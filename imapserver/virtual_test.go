@@ -0,0 +1,151 @@
+package imapserver
+
+import (
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/imapclient"
+	"github.com/mjl-/mox/store"
+)
+
+func TestVirtual(t *testing.T) {
+	defer mockUIDValidity()()
+	tc := start(t)
+	defer tc.close()
+	tc.client.Login("mjl@mox.example", "testtest")
+
+	tc2 := startNoSwitchboard(t)
+	defer tc2.close()
+	tc2.client.Login("mjl@mox.example", "testtest")
+
+	tc.client.Append("inbox", nil, nil, []byte(exampleMsg))               // Message ID 1.
+	tc.client.Append("inbox", []string{`\Seen`}, nil, []byte(exampleMsg)) // 2.
+	tc.client.Append("Archive", nil, nil, []byte(exampleMsg))             // 3.
+	tc.client.Append("Trash", nil, nil, []byte(exampleMsg))               // 4.
+
+	unread := store.SavedSearch{
+		Name:      "Unread",
+		Filter:    store.Filter{MailboxID: -1, MailboxChildrenIncluded: true},
+		NotFilter: store.NotFilter{Labels: []string{`\Seen`}},
+	}
+	all := store.SavedSearch{Name: "All", All: true}
+	err := tc.account.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		if err := tc.account.SavedSearchSave(tx, &unread); err != nil {
+			return err
+		}
+		return tc.account.SavedSearchSave(tx, &all)
+	})
+	tcheck(t, err, "saving saved searches")
+
+	tc.transactf("ok", `list "" "#Virtual*"`)
+	tc.xuntagged(
+		imapclient.UntaggedList{Flags: []string{`\Noselect`}, Separator: '/', Mailbox: "#Virtual"},
+		imapclient.UntaggedList{Flags: []string{`\All`}, Separator: '/', Mailbox: "#Virtual/All"},
+		imapclient.UntaggedList{Separator: '/', Mailbox: "#Virtual/Unread"},
+	)
+
+	tc.transactf("ok", `status #Virtual/Unread (messages unseen uidnext uidvalidity)`)
+	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "#Virtual/Unread", Attrs: map[string]int64{"MESSAGES": 2, "UNSEEN": 2, "UIDNEXT": 5, "UIDVALIDITY": int64(unread.UIDValidity)}})
+	tc.transactf("ok", `status #Virtual/All (messages)`)
+	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "#Virtual/All", Attrs: map[string]int64{"MESSAGES": 4}})
+	tc.transactf("no", `status #Virtual/Bogus (messages)`)
+
+	// Mailboxes cannot be created in the namespace.
+	tc.transactf("no", `create #Virtual/Test`)
+	tc.transactf("ok", `create Virtual/Test`) // Regular mailbox, not the namespace.
+	tc.transactf("ok", `delete Virtual/Test`)
+	tc.transactf("no", `append #Virtual/Unread () {%d+}`+"\r\n"+exampleMsg, len(exampleMsg))
+
+	tc.transactf("no", `select #Virtual/Bogus`)
+	tc.transactf("ok", `select #Virtual/Unread`)
+	tc.xuntaggedOpt(false,
+		imapclient.UntaggedExists(2),
+		imapclient.UntaggedResult{Status: imapclient.OK, RespText: imapclient.RespText{Code: "UIDVALIDITY", CodeArg: imapclient.CodeUint{Code: "UIDVALIDITY", Num: unread.UIDValidity}, More: "x"}},
+		imapclient.UntaggedResult{Status: imapclient.OK, RespText: imapclient.RespText{Code: "UIDNEXT", CodeArg: imapclient.CodeUint{Code: "UIDNEXT", Num: 5}, More: "x"}},
+	)
+	tc.xcode("READ-WRITE")
+
+	tc.transactf("ok", "uid fetch 1:* flags")
+	tc.xuntagged(
+		imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchFlags(nil)}},
+		imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(3), imapclient.FetchFlags(nil)}},
+	)
+
+	tc.transactf("ok", "uid search all")
+	tc.xuntagged(imapclient.UntaggedSearch{1, 3})
+
+	// Flag changes apply to the message in the Archive mailbox.
+	tc.transactf("ok", `uid store 3 +flags (\Flagged)`)
+	tc.xuntagged(imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(3), imapclient.FetchFlags{`\Flagged`}}})
+	tc2.client.Select("Archive")
+	tc2.transactf("ok", "fetch 1 flags")
+	tc2.xuntagged(imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(1), imapclient.FetchFlags{`\Flagged`}}})
+
+	// Flag changes through the regular mailbox are seen in the virtual mailbox. The
+	// message no longer matches, but stays until the next select.
+	tc2.transactf("ok", `store 1 +flags (\Seen)`)
+	tc.transactf("ok", "noop")
+	tc.xuntagged(imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(3), imapclient.FetchFlags{`\Seen`, `\Flagged`}}})
+
+	// New matching messages are added.
+	tc2.client.Append("inbox", nil, nil, []byte(exampleMsg)) // Message ID 5.
+	tc2.client.Append("inbox", []string{`\Seen`}, nil, []byte(exampleMsg))
+	tc.transactf("ok", "noop")
+	tc.xuntagged(
+		imapclient.UntaggedExists(3),
+		imapclient.UntaggedFetch{Seq: 3, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(5), imapclient.FetchFlags(nil)}},
+	)
+
+	// Moving to a matching mailbox keeps the message, moving to Trash removes it.
+	tc2.client.Select("inbox")
+	tc2.transactf("ok", "uid move 3 Archive") // Message ID 5.
+	tc2.transactf("ok", "uid move 1 Trash")   // Message ID 1.
+	tc.transactf("ok", "noop")
+	tc.xuntagged(imapclient.UntaggedExpunge(1))
+
+	tc.transactf("ok", "uid fetch 1:* (uid)")
+	tc.xuntagged(
+		imapclient.UntaggedFetch{Seq: 1, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(3)}},
+		imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(5)}},
+	)
+
+	// Marking as seen through fetch applies to the message.
+	tc.transactf("ok", "uid fetch 5 body[]")
+	tc2.client.Select("Archive")
+	tc2.transactf("ok", "uid fetch 2 flags")
+	tc2.xuntagged(imapclient.UntaggedFetch{Seq: 2, Attrs: []imapclient.FetchAttr{imapclient.FetchUID(2), imapclient.FetchFlags{`\Seen`}}})
+
+	// Messages can be copied out of a virtual mailbox.
+	ptr := func(v uint32) *uint32 { return &v }
+	tc.transactf("ok", "uid copy 3,5 Drafts")
+	tc.xcodeArg(imapclient.CodeCopyUID{DestUIDValidity: 1, From: []imapclient.NumRange{{First: 3}, {First: 5}}, To: []imapclient.NumRange{{First: 1, Last: ptr(2)}}})
+
+	// But not added or removed.
+	tc.transactf("no", "uid move 3 inbox")
+	tc.xcode("CANNOT")
+	tc.transactf("no", "expunge")
+	tc.transactf("no", "uid expunge 3")
+	tc.transactf("no", "uid replace 3 inbox {%d+}\r\n%s", len(exampleMsg), exampleMsg)
+
+	// No mod-sequences.
+	tc.client.Enable("condstore")
+	tc.transactf("ok", `select #Virtual/Unread`)
+	tc.xuntaggedOpt(false, imapclient.UntaggedResult{Status: imapclient.OK, RespText: imapclient.RespText{Code: "NOMODSEQ", More: "virtual mailbox"}})
+	tc.transactf("bad", "fetch 1 flags (changedsince 1)")
+	tc.transactf("bad", "store 1 (unchangedsince 1) +flags (\\Seen)")
+
+	tc.transactf("ok", `examine #Virtual/All`)
+	tc.xcode("READ-ONLY")
+	tc.transactf("no", `store 1 +flags (\Flagged)`)
+	tc.transactf("ok", "close")
+
+	// A changed saved search must be selected again.
+	tc.transactf("ok", `select #Virtual/All`)
+	all.MaxAgeDays = 1
+	err = tc.account.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		return tc.account.SavedSearchSave(tx, &all)
+	})
+	tcheck(t, err, "updating saved search")
+	tc.transactf("no", "fetch 1 flags")
+}
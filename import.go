@@ -320,6 +320,13 @@ func importctl(ctx context.Context, ctl *ctl, mbox bool) {
 		err = tx.Get(&du)
 		ctl.xcheck(err, "get disk usage")
 
+		maxCount := a.QuotaMessageCount()
+		var count, addCount int64
+		if maxCount > 0 {
+			count, err = a.MessageCount(tx)
+			ctl.xcheck(err, "count messages")
+		}
+
 		process := func(m *store.Message, msgf *os.File, origPath string) {
 			defer store.CloseRemoveTempFile(ctl.log, msgf, "message to import")
 
@@ -327,6 +334,10 @@ func importctl(ctx context.Context, ctl *ctl, mbox bool) {
 			if maxSize > 0 && du.MessageSize+addSize > maxSize {
 				ctl.xcheck(fmt.Errorf("account over maximum total message size %d", maxSize), "checking quota")
 			}
+			addCount++
+			if maxCount > 0 && count+addCount > maxCount {
+				ctl.xcheck(fmt.Errorf("account over maximum number of messages %d", maxCount), "checking quota")
+			}
 
 			for _, kw := range m.Keywords {
 				mailboxKeywords[kw] = true
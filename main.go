@@ -96,6 +96,7 @@ var commands = []struct {
 	{"quickstart", cmdQuickstart},
 	{"stop", cmdStop},
 	{"setaccountpassword", cmdSetaccountpassword},
+	{"resettotp", cmdResetTOTP},
 	{"setadminpassword", cmdSetadminpassword},
 	{"loglevels", cmdLoglevels},
 	{"queue list", cmdQueueList},
@@ -150,6 +151,7 @@ var commands = []struct {
 	{"dnsbl checkhealth", cmdDNSBLCheckhealth},
 	{"mtasts lookup", cmdMTASTSLookup},
 	{"retrain", cmdRetrain},
+	{"recoverdeleted", cmdRecoverDeleted},
 	{"sendmail", cmdSendmail},
 	{"spf check", cmdSPFCheck},
 	{"spf lookup", cmdSPFLookup},
@@ -167,6 +169,9 @@ var commands = []struct {
 	{"recalculatemailboxcounts", cmdRecalculateMailboxCounts},
 	{"message parse", cmdMessageParse},
 	{"reassignthreads", cmdReassignthreads},
+	{"rebuildwordindex", cmdRebuildWordIndex},
+	{"compressmessages", cmdCompressMessages},
+	{"encryptmessages", cmdEncryptMessages},
 
 	// Not listed.
 	{"helpall", cmdHelpall},
@@ -1173,14 +1178,19 @@ These files can then be stored elsewhere for long-term storage, or used to fall
 back to should an upgrade fail. Simply copying files in the data directory
 while mox is running can result in unusable database files.
 
-Message files never change (they are read-only, though can be removed) and are
-hard-linked so they don't consume additional space. If hardlinking fails, for
-example when the backup destination directory is on a different file system, a
-regular copy is made. Using a destination directory like "data/tmp/backup"
+Message files never change (they are read-only, though can be removed, or
+replaced with a compressed or encrypted version) and are hard-linked so they
+don't consume additional space. If hardlinking fails, for example when the
+backup destination directory is on a different file system, a regular copy is
+made. Using a destination directory like "data/tmp/backup"
 increases the odds hardlinking succeeds: the default systemd service file
 specifically mounts the data directory, causing attempts to hardlink outside it
 to fail with an error about cross-device linking.
 
+Compressed and encrypted message files are backed up as is. Encrypted messages
+of a restored backup can be read after logging in with the account password at
+the time of the backup.
+
 All files in the data directory that aren't recognized (i.e. other than known
 database files, message files, an acme directory, the "tmp" directory, etc),
 are stored, but with a warning.
@@ -1232,6 +1242,11 @@ func cmdSetadminpassword(c *cmd) {
 
 The password is read from stdin. Its bcrypt hash is stored in a file named
 "adminpasswd" in the configuration directory.
+
+Two-factor authentication for the admin, if enabled in the web interface, is
+stored in "adminpasswd.totp". Remove that file to disable two-factor
+authentication, e.g. after losing both the authenticator app and the recovery
+codes.
 `
 	if len(c.Parse()) != 0 {
 		c.Usage()
@@ -1304,6 +1319,31 @@ func ctlcmdSetaccountpassword(ctl *ctl, account, password string) {
 	ctl.xreadok()
 }
 
+func cmdResetTOTP(c *cmd) {
+	c.params = "account"
+	c.help = `Disable two-factor authentication for an account.
+
+For an account that lost access to its authenticator app and recovery codes.
+After the reset, logins to the account and webmail interfaces only require the
+password. The account can enable two-factor authentication again.
+
+To disable two-factor authentication for the admin, remove the file
+"adminpasswd.totp" in the config directory.
+`
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdResetTOTP(xctl(), args[0])
+}
+
+func ctlcmdResetTOTP(ctl *ctl, account string) {
+	ctl.xwrite("resettotp")
+	ctl.xwrite(account)
+	ctl.xreadok()
+}
+
 func cmdDeliver(c *cmd) {
 	c.unlisted = true
 	c.params = "address < message"
@@ -3221,6 +3261,153 @@ func ctlcmdReassignthreads(ctl *ctl, account string) {
 	ctl.xstreamto(os.Stdout)
 }
 
+func cmdRecoverDeleted(c *cmd) {
+	c.params = "account [messageid ...]"
+	c.help = `List or recover deleted messages of an account.
+
+Messages that are expunged, e.g. by emptying the Trash mailbox, are kept for
+the period configured in KeepDeletedPeriod in the account configuration. Until
+then, they can be recovered.
+
+Without message ids, the deleted messages are listed, with their id, time of
+expunge, mailbox, sender and subject. With message ids, those messages are
+recovered: added again as new messages to the mailbox they were expunged from,
+or the Inbox if that mailbox no longer exists.
+`
+	args := c.Parse()
+	if len(args) == 0 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	for _, s := range args[1:] {
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			log.Fatalf("parsing message id %q: %v", s, err)
+		}
+	}
+	ctlcmdRecoverDeleted(xctl(), args[0], strings.Join(args[1:], " "))
+}
+
+func ctlcmdRecoverDeleted(ctl *ctl, account, ids string) {
+	ctl.xwrite("recoverdeleted")
+	ctl.xwrite(account)
+	ctl.xwrite(ids)
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdRebuildWordIndex(c *cmd) {
+	c.params = "[account]"
+	c.help = `Rebuild the word index used for full-text search.
+
+For all accounts, or optionally only the specified account.
+
+The word index holds the words in headers and text parts of all messages, so
+searches in the webmail and IMAP don't have to read all message files. New
+messages are added to the index during delivery. Accounts created before the
+word index was introduced get their index built in the background when the
+account is first opened. Until then, and while rebuilding, searches read all
+messages.
+
+Text is extracted again from PDF, OpenDocument, Office Open XML and plain text
+attachments, for searching. Messages delivered before attachment text
+extraction was introduced only have their attachments searchable after a
+rebuild.
+
+Accounts with EncryptMessages enabled don't have a word index.
+`
+	args := c.Parse()
+	if len(args) > 1 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	var account string
+	if len(args) == 1 {
+		account = args[0]
+	}
+	ctlcmdRebuildWordIndex(xctl(), account)
+}
+
+func ctlcmdRebuildWordIndex(ctl *ctl, account string) {
+	ctl.xwrite("rebuildwordindex")
+	ctl.xwrite(account)
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdCompressMessages(c *cmd) {
+	c.params = "[account]"
+	c.help = `Compress the message files of existing messages.
+
+For all accounts, or optionally only the specified account.
+
+Message files that are not yet compressed are replaced with a compressed
+version, unless that wouldn't be smaller. Compressed message files are read
+transparently, so the account can be used while its messages are being
+compressed. Only newly delivered messages of accounts with CompressMessages
+enabled in their configuration are compressed automatically.
+
+Message files that were linked to share storage, e.g. for copied messages,
+become separate files when compressed.
+`
+	args := c.Parse()
+	if len(args) > 1 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	var account string
+	if len(args) == 1 {
+		account = args[0]
+	}
+	ctlcmdCompressMessages(xctl(), account)
+}
+
+func ctlcmdCompressMessages(ctl *ctl, account string) {
+	ctl.xwrite("compressmessages")
+	ctl.xwrite(account)
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdEncryptMessages(c *cmd) {
+	c.params = "[account]"
+	c.help = `Encrypt the message files of existing messages.
+
+For all accounts with EncryptMessages enabled, or optionally only the specified
+account.
+
+The account must have an encryption key, which is created at the first login
+with password after enabling EncryptMessages in the account configuration. Only
+the public key is needed for encrypting, so the account does not have to be
+unlocked. Message files are also compressed if CompressMessages is enabled.
+Message files that are already encrypted are left as is.
+
+The word index, text extracted from attachments and cached message previews are
+removed from the account database first, they are not kept for accounts with
+encrypted messages.
+`
+	args := c.Parse()
+	if len(args) > 1 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	var account string
+	if len(args) == 1 {
+		account = args[0]
+	}
+	ctlcmdEncryptMessages(xctl(), account)
+}
+
+func ctlcmdEncryptMessages(ctl *ctl, account string) {
+	ctl.xwrite("encryptmessages")
+	ctl.xwrite(account)
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
 func cmdReadmessages(c *cmd) {
 	c.unlisted = true
 	c.params = "datadir account ..."
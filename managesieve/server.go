@@ -0,0 +1,866 @@
+// Package managesieve implements a ManageSieve server, RFC 5804, for managing
+// the sieve scripts of accounts.
+//
+// Only the PLAIN SASL mechanism is supported, and requires TLS unless
+// configured otherwise.
+package managesieve
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/sieve"
+	"github.com/mjl-/mox/store"
+)
+
+var (
+	metricConnection = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mox_managesieve_connection_total",
+			Help: "Incoming ManageSieve connections.",
+		},
+	)
+	metricCommands = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_managesieve_command_duration_seconds",
+			Help:    "ManageSieve command duration and result codes in seconds.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20},
+		},
+		[]string{
+			"cmd",
+			"result", // ok, panic, ioerror, usererror, error
+		},
+	)
+)
+
+var limiterConnectionrate, limiterConnections *ratelimit.Limiter
+
+func init() {
+	// Also called by tests, so they don't trigger the rate limiter.
+	limitersInit()
+}
+
+func limitersInit() {
+	mox.LimitersInit()
+	limiterConnectionrate = &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Minute,
+				Limits: [...]int64{100, 300, 900},
+			},
+		},
+	}
+	limiterConnections = &ratelimit.Limiter{
+		WindowLimits: []ratelimit.WindowLimit{
+			{
+				Window: time.Duration(math.MaxInt64), // All of time.
+				Limits: [...]int64{10, 30, 90},
+			},
+		},
+	}
+}
+
+// Delay after authentication failure. Tests set this to zero.
+var authFailDelay = time.Second
+
+// Maximum size of a literal, scripts are limited further by the store.
+const maxLiteral = 1024 * 1024
+
+var (
+	errIO      = errors.New("io error")       // For read/write errors and errors that should close the connection.
+	cleanClose = errors.New("clean close")    // Used in panic to close the connection, e.g. after LOGOUT.
+	errSyntax  = errors.New("syntax error")   // Wrapped in userError.
+	errAuth    = errors.New("not authorized") // Wrapped in userError.
+)
+
+// userError is returned to the client as a NO response, with an optional
+// response code.
+type userError struct {
+	code string // E.g. "NONEXISTENT", "QUOTA/MAXSIZE".
+	err  error
+}
+
+func (e userError) Error() string { return e.err.Error() }
+func (e userError) Unwrap() error { return e.err }
+
+func xuserErrorf(code, format string, args ...any) {
+	panic(userError{code, fmt.Errorf(format, args...)})
+}
+
+func xsyntaxErrorf(format string, args ...any) {
+	panic(userError{"", fmt.Errorf("%w: %s", errSyntax, fmt.Sprintf(format, args...))})
+}
+
+func xcheckf(err error, format string, args ...any) {
+	if err != nil {
+		panic(fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), err))
+	}
+}
+
+type conn struct {
+	cid               int64
+	conn              net.Conn
+	tls               bool
+	br                *bufio.Reader
+	bw                *bufio.Writer
+	tr                *moxio.TraceReader
+	tw                *moxio.TraceWriter
+	tlsConfig         *tls.Config
+	remoteIP          net.IP
+	noRequireSTARTTLS bool
+	log               mlog.Log
+	lastlog           time.Time
+	authFailed        int
+
+	// Set after authentication.
+	account  *store.Account
+	username string
+}
+
+// Listen initializes all ManageSieve listeners for the configuration, and stores
+// them for Serve to start them.
+func Listen() {
+	names := maps.Keys(mox.Conf.Static.Listeners)
+	sort.Strings(names)
+	for _, name := range names {
+		listener := mox.Conf.Static.Listeners[name]
+
+		var tlsConfig *tls.Config
+		if listener.TLS != nil {
+			tlsConfig = listener.TLS.Config
+		}
+
+		if listener.ManageSieve.Enabled {
+			port := config.Port(listener.ManageSieve.Port, 4190)
+			for _, ip := range listener.IPs {
+				listen1(name, ip, port, tlsConfig, listener.ManageSieve.NoRequireSTARTTLS)
+			}
+		}
+	}
+}
+
+var servers []func()
+
+func listen1(listenerName, ip string, port int, tlsConfig *tls.Config, noRequireSTARTTLS bool) {
+	log := mlog.New("managesieve", nil)
+	addr := net.JoinHostPort(ip, fmt.Sprintf("%d", port))
+	if os.Getuid() == 0 {
+		log.Print("listening for managesieve",
+			slog.String("listener", listenerName),
+			slog.String("addr", addr))
+	}
+	network := mox.Network(ip)
+	ln, err := mox.Listen(network, addr)
+	if err != nil {
+		log.Fatalx("managesieve: listen for managesieve", err, slog.String("listener", listenerName))
+	}
+
+	serve := func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Infox("managesieve: accept", err, slog.String("listener", listenerName))
+				continue
+			}
+
+			metricConnection.Inc()
+			go serve(listenerName, mox.Cid(), tlsConfig, conn, noRequireSTARTTLS)
+		}
+	}
+
+	servers = append(servers, serve)
+}
+
+// Serve starts serving on all listeners, launching a goroutine per listener.
+func Serve() {
+	for _, serve := range servers {
+		go serve()
+	}
+	servers = nil
+}
+
+func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, noRequireSTARTTLS bool) {
+	var remoteIP net.IP
+	if a, ok := nc.RemoteAddr().(*net.TCPAddr); ok {
+		remoteIP = a.IP
+	} else {
+		// For net.Pipe, during tests.
+		remoteIP = net.ParseIP("127.0.0.10")
+	}
+
+	c := &conn{
+		cid:               cid,
+		conn:              nc,
+		tlsConfig:         tlsConfig,
+		remoteIP:          remoteIP,
+		noRequireSTARTTLS: noRequireSTARTTLS,
+		lastlog:           time.Now(),
+	}
+	var logmutex sync.Mutex
+	c.log = mlog.New("managesieve", nil).WithFunc(func() []slog.Attr {
+		logmutex.Lock()
+		defer logmutex.Unlock()
+		now := time.Now()
+		l := []slog.Attr{
+			slog.Int64("cid", c.cid),
+			slog.Duration("delta", now.Sub(c.lastlog)),
+		}
+		c.lastlog = now
+		if c.username != "" {
+			l = append(l, slog.String("username", c.username))
+		}
+		return l
+	})
+	c.setConn(nc)
+
+	c.log.Info("new connection",
+		slog.Any("remote", c.conn.RemoteAddr()),
+		slog.Any("local", c.conn.LocalAddr()),
+		slog.String("listener", listenerName))
+
+	defer func() {
+		c.conn.Close()
+
+		if c.account != nil {
+			err := c.account.Close()
+			c.log.Check(err, "close account")
+			c.account = nil
+		}
+
+		x := recover()
+		if x == nil || x == cleanClose {
+			c.log.Info("connection closed")
+		} else if err, ok := x.(error); ok && (errors.Is(err, errIO) || moxio.IsClosed(err)) {
+			c.log.Infox("connection closed", err)
+		} else {
+			c.log.Error("unhandled panic", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Managesieve)
+		}
+	}()
+
+	select {
+	case <-mox.Shutdown.Done():
+		c.bye("mox shutting down")
+		return
+	default:
+	}
+
+	if !limiterConnectionrate.Add(c.remoteIP, time.Now(), 1) {
+		c.bye("connection rate from your ip or network too high, slow down please")
+		return
+	}
+
+	// If remote IP/network resulted in too many authentication failures, refuse to serve.
+	if !mox.LimiterFailedAuth.CanAdd(c.remoteIP, time.Now(), 1) {
+		metrics.AuthenticationRatelimitedInc("managesieve")
+		c.log.Debug("refusing connection due to many auth failures", slog.Any("remoteip", c.remoteIP))
+		c.bye("too many auth failures")
+		return
+	}
+
+	if !limiterConnections.Add(c.remoteIP, time.Now(), 1) {
+		c.log.Debug("refusing connection due to many open connections", slog.Any("remoteip", c.remoteIP))
+		c.bye("too many open connections from your ip or network")
+		return
+	}
+	defer limiterConnections.Add(c.remoteIP, time.Now(), -1)
+
+	mox.Connections.Register(nc, "managesieve", listenerName)
+	defer mox.Connections.Unregister(nc)
+
+	// ../rfc/5804:539
+	c.writeCapabilities()
+	c.ok("", "mox managesieve ready")
+	c.xflush()
+
+	for {
+		c.command()
+		c.xflush()
+	}
+}
+
+func (c *conn) setConn(nc net.Conn) {
+	c.conn = nc
+	c.tr = moxio.NewTraceReader(c.log, "C: ", c.conn)
+	c.tw = moxio.NewTraceWriter(c.log, "S: ", c)
+	c.br = bufio.NewReader(c.tr)
+	c.bw = bufio.NewWriter(c.tw)
+}
+
+// Write makes a connection an io.Writer. It panics for i/o errors. These errors
+// are handled in the connection command loop.
+func (c *conn) Write(buf []byte) (int, error) {
+	err := c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	c.log.Check(err, "setting write deadline")
+	n, err := c.conn.Write(buf)
+	if err != nil {
+		panic(fmt.Errorf("write: %s (%w)", err, errIO))
+	}
+	return n, nil
+}
+
+func (c *conn) xflush() {
+	err := c.bw.Flush()
+	xcheckf(err, "flush")
+}
+
+func (c *conn) xtrace(level slog.Level) func() {
+	c.xflush()
+	c.tr.SetTrace(level)
+	c.tw.SetTrace(level)
+	return func() {
+		c.xflush()
+		c.tr.SetTrace(mlog.LevelTrace)
+		c.tw.SetTrace(mlog.LevelTrace)
+	}
+}
+
+func (c *conn) writelinef(format string, args ...any) {
+	fmt.Fprintf(c.bw, format+"\r\n", args...)
+}
+
+// quote returns s as quoted string, or as literal if it cannot be represented as
+// quoted string. ../rfc/5804:1953
+func quote(s string) string {
+	if len(s) > 1024 || strings.ContainsAny(s, "\r\n\x00") {
+		return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// bye writes a BYE response and flushes it, before the connection is closed.
+func (c *conn) bye(msg string) {
+	c.response("BYE", "", msg)
+	c.xflush()
+}
+
+func (c *conn) ok(code, msg string) {
+	c.response("OK", code, msg)
+}
+
+func (c *conn) response(kind, code, msg string) {
+	s := kind
+	if code != "" {
+		s += " (" + code + ")"
+	}
+	if msg != "" {
+		s += " " + quote(msg)
+	}
+	c.writelinef("%s", s)
+}
+
+func (c *conn) capabilities() []string {
+	// ../rfc/5804:538
+	var sasl string
+	if c.tls || c.noRequireSTARTTLS {
+		sasl = "PLAIN"
+	}
+	l := []string{
+		`"IMPLEMENTATION" ` + quote("mox "+moxvar.Version),
+		`"SIEVE" ` + quote(strings.Join(sieve.Extensions, " ")),
+		`"SASL" ` + quote(sasl),
+	}
+	if !c.tls && c.tlsConfig != nil {
+		l = append(l, `"STARTTLS"`)
+	}
+	if c.account != nil {
+		l = append(l, `"OWNER" `+quote(c.username))
+	}
+	l = append(l, `"UNAUTHENTICATE"`, `"VERSION" "1.0"`)
+	return l
+}
+
+func (c *conn) writeCapabilities() {
+	for _, s := range c.capabilities() {
+		c.writelinef("%s", s)
+	}
+}
+
+// readArgs reads a line with a command and its arguments: atoms, numbers,
+// quoted strings and literals. The first is the command, in upper case.
+func (c *conn) xreadArgs(timeout time.Duration) []string {
+	err := c.conn.SetReadDeadline(time.Now().Add(timeout))
+	c.log.Check(err, "setting read deadline")
+
+	xreadByte := func() byte {
+		b, err := c.br.ReadByte()
+		if err != nil {
+			panic(fmt.Errorf("read: %s (%w)", err, errIO))
+		}
+		return b
+	}
+
+	var args []string
+	var size int
+	for {
+		b := xreadByte()
+		switch {
+		case b == ' ':
+			continue
+		case b == '\r':
+			if xreadByte() != '\n' {
+				panic(fmt.Errorf("%w: bare carriage return", errIO))
+			}
+			return args
+		case b == '\n':
+			return args
+		case b == '"':
+			// ../rfc/5804:1959
+			var s []byte
+			for {
+				b := xreadByte()
+				if b == '"' {
+					break
+				} else if b == '\\' {
+					b = xreadByte()
+				} else if b == '\r' || b == '\n' {
+					panic(fmt.Errorf("%w: newline in quoted string", errIO))
+				}
+				s = append(s, b)
+				if len(s) > 1024 {
+					panic(fmt.Errorf("%w: quoted string too long", errIO))
+				}
+			}
+			args = append(args, string(s))
+		case b == '{':
+			// Both synchronizing and non-synchronizing literals, we don't send a continuation.
+			// ../rfc/5804:1942
+			var s []byte
+			for {
+				b := xreadByte()
+				if b == '}' {
+					break
+				}
+				s = append(s, b)
+				if len(s) > 12 {
+					panic(fmt.Errorf("%w: literal size too long", errIO))
+				}
+			}
+			n, err := strconv.ParseInt(strings.TrimSuffix(string(s), "+"), 10, 32)
+			if err != nil || n < 0 {
+				panic(fmt.Errorf("%w: bad literal size %q", errIO, s))
+			}
+			size += int(n)
+			if size > maxLiteral {
+				panic(fmt.Errorf("%w: literal too large", errIO))
+			}
+			if xreadByte() != '\r' || xreadByte() != '\n' {
+				panic(fmt.Errorf("%w: missing newline after literal size", errIO))
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(c.br, buf); err != nil {
+				panic(fmt.Errorf("reading literal: %s (%w)", err, errIO))
+			}
+			args = append(args, string(buf))
+		default:
+			// Atom or number.
+			s := []byte{b}
+			for {
+				bufs, err := c.br.Peek(1)
+				if err != nil {
+					panic(fmt.Errorf("read: %s (%w)", err, errIO))
+				}
+				if bufs[0] == ' ' || bufs[0] == '\r' || bufs[0] == '\n' {
+					break
+				}
+				s = append(s, xreadByte())
+				if len(s) > 64 {
+					panic(fmt.Errorf("%w: atom too long", errIO))
+				}
+			}
+			args = append(args, string(s))
+		}
+		if len(args) > 8 {
+			panic(fmt.Errorf("%w: too many arguments", errIO))
+		}
+	}
+}
+
+type command struct {
+	fn            func(c *conn, args []string)
+	nargs         []int // Allowed number of arguments.
+	authenticated bool  // Whether only allowed after authentication.
+}
+
+var commands = map[string]command{
+	"CAPABILITY":     {(*conn).cmdCapability, []int{0}, false},
+	"STARTTLS":       {(*conn).cmdStarttls, []int{0}, false},
+	"AUTHENTICATE":   {(*conn).cmdAuthenticate, []int{1, 2}, false},
+	"LOGOUT":         {(*conn).cmdLogout, []int{0}, false},
+	"NOOP":           {(*conn).cmdNoop, []int{0, 1}, false},
+	"UNAUTHENTICATE": {(*conn).cmdUnauthenticate, []int{0}, true},
+	"HAVESPACE":      {(*conn).cmdHavespace, []int{2}, true},
+	"PUTSCRIPT":      {(*conn).cmdPutscript, []int{2}, true},
+	"LISTSCRIPTS":    {(*conn).cmdListscripts, []int{0}, true},
+	"SETACTIVE":      {(*conn).cmdSetactive, []int{1}, true},
+	"GETSCRIPT":      {(*conn).cmdGetscript, []int{1}, true},
+	"DELETESCRIPT":   {(*conn).cmdDeletescript, []int{1}, true},
+	"RENAMESCRIPT":   {(*conn).cmdRenamescript, []int{2}, true},
+	"CHECKSCRIPT":    {(*conn).cmdCheckscript, []int{1}, true},
+}
+
+func (c *conn) command() {
+	timeout := 30 * time.Minute
+	if c.account == nil {
+		timeout = 30 * time.Second
+	}
+	args := c.xreadArgs(timeout)
+	if len(args) == 0 {
+		c.response("NO", "", "missing command")
+		return
+	}
+	cmd := strings.ToUpper(args[0])
+	args = args[1:]
+	start := time.Now()
+
+	cmdMetric := "(unrecognized)"
+	result := "panic"
+	defer func() {
+		metricCommands.WithLabelValues(cmdMetric, result).Observe(float64(time.Since(start)) / float64(time.Second))
+
+		x := recover()
+		if x == nil {
+			result = "ok"
+			c.log.Debug("managesieve command result", slog.String("cmd", cmd), slog.Duration("duration", time.Since(start)))
+			return
+		}
+		var uerr userError
+		if err, ok := x.(error); ok && errors.As(err, &uerr) {
+			result = "usererror"
+			c.log.Debugx("managesieve command result", err, slog.String("cmd", cmd))
+			c.response("NO", uerr.code, uerr.err.Error())
+			return
+		} else if ok && (x == cleanClose || errors.Is(err, errIO)) {
+			result = "ioerror"
+			panic(x)
+		}
+		result = "error"
+		c.log.Error("managesieve command error", slog.String("cmd", cmd), slog.Any("err", x))
+		metrics.PanicInc(metrics.Managesieve)
+		c.response("NO", "", "internal error")
+	}()
+
+	command, ok := commands[cmd]
+	if !ok {
+		xsyntaxErrorf("unknown command %q", cmd)
+	}
+	cmdMetric = strings.ToLower(cmd)
+	if command.authenticated && c.account == nil {
+		panic(userError{"", errAuth})
+	}
+	var nargsOK bool
+	for _, n := range command.nargs {
+		nargsOK = nargsOK || n == len(args)
+	}
+	if !nargsOK {
+		xsyntaxErrorf("bad number of arguments")
+	}
+	command.fn(c, args)
+}
+
+func (c *conn) cmdCapability(args []string) {
+	c.writeCapabilities()
+	c.ok("", "done")
+}
+
+func (c *conn) cmdNoop(args []string) {
+	// ../rfc/5804:1425
+	if len(args) == 1 {
+		c.ok("TAG "+quote(args[0]), "done")
+		return
+	}
+	c.ok("", "done")
+}
+
+func (c *conn) cmdLogout(args []string) {
+	c.ok("", "bye")
+	c.xflush()
+	panic(cleanClose)
+}
+
+func (c *conn) cmdStarttls(args []string) {
+	// ../rfc/5804:880
+	if c.tls {
+		xsyntaxErrorf("tls already active")
+	} else if c.tlsConfig == nil {
+		xsyntaxErrorf("starttls not available")
+	}
+
+	conn := c.conn
+	if n := c.br.Buffered(); n > 0 {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(c.br, buf)
+		xcheckf(err, "reading buffered data for tls handshake")
+		conn = &prefixConn{buf, conn}
+	}
+	c.ok("", "begin tls negotiation ("+mox.ReceivedID(c.cid)+")")
+	c.xflush()
+
+	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+	ctx, cancel := context.WithTimeout(cidctx, time.Minute)
+	defer cancel()
+	tlsConn := tls.Server(conn, c.tlsConfig)
+	c.log.Debug("starting tls server handshake")
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		panic(fmt.Errorf("starttls handshake: %s (%w)", err, errIO))
+	}
+	cancel()
+	tlsversion, ciphersuite := moxio.TLSInfo(tlsConn)
+	c.log.Debug("tls server handshake done", slog.String("tls", tlsversion), slog.String("ciphersuite", ciphersuite))
+
+	c.setConn(tlsConn)
+	c.tls = true
+
+	// Capabilities are sent again after TLS negotiation. ../rfc/5804:906
+	c.writeCapabilities()
+	c.ok("", "tls active")
+}
+
+func (c *conn) cmdAuthenticate(args []string) {
+	// ../rfc/5804:608
+	if c.account != nil {
+		xsyntaxErrorf("already authenticated")
+	}
+
+	// For many failed auth attempts, slow down verification attempts.
+	if c.authFailed > 3 && authFailDelay > 0 {
+		mox.Sleep(mox.Context, time.Duration(c.authFailed-3)*authFailDelay)
+	}
+	c.authFailed++ // Compensated on success.
+
+	authVariant := strings.ToLower(args[0])
+	var authCredential string
+	authResult := "error"
+	defer func() {
+		metrics.AuthenticationInc("managesieve", authVariant, authCredential, authResult)
+		switch authResult {
+		case "ok":
+			mox.LimiterFailedAuth.Reset(c.remoteIP, time.Now())
+		default:
+			mox.LimiterFailedAuth.Add(c.remoteIP, time.Now(), 1)
+		}
+	}()
+
+	if !strings.EqualFold(args[0], "PLAIN") {
+		xuserErrorf("", "unsupported authentication mechanism")
+	}
+	if !c.tls && !c.noRequireSTARTTLS {
+		xuserErrorf("ENCRYPT-NEEDED", "tls required for login") // ../rfc/5804:1261
+	}
+
+	// Plain text passwords, mark as traceauth.
+	defer c.xtrace(mlog.LevelTraceauth)()
+	var data string
+	if len(args) == 2 {
+		data = args[1]
+	} else {
+		// Empty server challenge, client responds with a string. ../rfc/5804:633
+		c.writelinef(`""`)
+		c.xflush()
+		l := c.xreadArgs(time.Minute)
+		if len(l) != 1 {
+			xsyntaxErrorf("expected single string as authentication response")
+		}
+		data = l[0]
+		if data == "*" {
+			authResult = "aborted"
+			xuserErrorf("", "authentication aborted by client")
+		}
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		xsyntaxErrorf("parsing base64: %v", err)
+	}
+	plain := bytes.Split(buf, []byte{0})
+	if len(plain) != 3 {
+		xsyntaxErrorf("bad plain auth data, expected 3 nul-separated tokens, got %d tokens", len(plain))
+	}
+	authz := string(plain[0])
+	authc := string(plain[1])
+	password := string(plain[2])
+	if authz != "" && authz != authc {
+		xuserErrorf("", "cannot assume role")
+	}
+
+	acc, appPassword, err := store.OpenEmailAuth(c.log, authc, password, store.AuthScopeIMAP)
+	if err != nil {
+		if errors.Is(err, store.ErrUnknownCredentials) {
+			authResult = "badcreds"
+			c.log.Info("authentication failed", slog.String("username", authc))
+			xuserErrorf("", "bad credentials")
+		}
+		xuserErrorf("", "error")
+	}
+	authCredential = "password"
+	if appPassword != "" {
+		authCredential = "apppassword"
+		c.log.Debug("authenticated with app password", slog.String("apppassword", appPassword))
+	}
+	c.authFailed = 0
+	c.account = acc
+	c.username = authc
+	authResult = "ok"
+	c.ok("", "authenticated")
+}
+
+func (c *conn) cmdUnauthenticate(args []string) {
+	// ../rfc/5804:1488
+	err := c.account.Close()
+	c.log.Check(err, "closing account")
+	c.account = nil
+	c.username = ""
+	c.ok("", "unauthenticated")
+}
+
+// xsieveError turns an error from the store into a user error with response code.
+func xsieveError(err error) {
+	if err == nil {
+		return
+	}
+	switch {
+	case errors.Is(err, store.ErrUnknownSieveScript):
+		xuserErrorf("NONEXISTENT", "%v", err)
+	case errors.Is(err, store.ErrSieveScriptExists):
+		xuserErrorf("ALREADYEXISTS", "%v", err)
+	case errors.Is(err, store.ErrSieveScriptActive):
+		xuserErrorf("ACTIVE", "%v", err)
+	case errors.Is(err, store.ErrSieveScriptTooLarge):
+		xuserErrorf("QUOTA/MAXSIZE", "%v", err)
+	case errors.Is(err, store.ErrSieveScriptsTooMany):
+		xuserErrorf("QUOTA/MAXSCRIPTS", "%v", err)
+	case errors.Is(err, store.ErrSieveScriptName), errors.Is(err, sieve.ErrSyntax):
+		xuserErrorf("", "%v", err)
+	}
+	xcheckf(err, "sieve script operation")
+}
+
+func (c *conn) cmdHavespace(args []string) {
+	// ../rfc/5804:1017
+	name := args[0]
+	size, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		xsyntaxErrorf("bad size: %v", err)
+	}
+	xsieveError(store.CheckSieveScriptName(name))
+	if size > store.SieveMaxScriptSize {
+		xsieveError(store.ErrSieveScriptTooLarge)
+	}
+	l, err := c.account.SieveScripts(context.TODO())
+	xcheckf(err, "listing scripts")
+	exists := false
+	for _, s := range l {
+		exists = exists || s.Name == name
+	}
+	if !exists && len(l) >= store.SieveMaxScripts {
+		xsieveError(store.ErrSieveScriptsTooMany)
+	}
+	c.ok("", "done")
+}
+
+func (c *conn) cmdPutscript(args []string) {
+	// ../rfc/5804:1069
+	err := c.account.SieveScriptPut(context.TODO(), args[0], args[1])
+	xsieveError(err)
+	c.ok("", "done")
+}
+
+func (c *conn) cmdCheckscript(args []string) {
+	// ../rfc/5804:1146
+	if len(args[0]) > store.SieveMaxScriptSize {
+		xsieveError(store.ErrSieveScriptTooLarge)
+	}
+	_, err := sieve.Parse(args[0])
+	xsieveError(err)
+	c.ok("", "done")
+}
+
+func (c *conn) cmdListscripts(args []string) {
+	// ../rfc/5804:1166
+	l, err := c.account.SieveScripts(context.TODO())
+	xcheckf(err, "listing scripts")
+	for _, s := range l {
+		if s.Active {
+			c.writelinef("%s ACTIVE", quote(s.Name))
+		} else {
+			c.writelinef("%s", quote(s.Name))
+		}
+	}
+	c.ok("", "done")
+}
+
+func (c *conn) cmdSetactive(args []string) {
+	// Empty name deactivates all scripts. ../rfc/5804:1215
+	err := c.account.SieveScriptActivate(context.TODO(), args[0])
+	xsieveError(err)
+	c.ok("", "done")
+}
+
+func (c *conn) cmdGetscript(args []string) {
+	// ../rfc/5804:1245
+	s, err := c.account.SieveScriptGet(context.TODO(), args[0])
+	xsieveError(err)
+	c.writelinef("{%d}\r\n%s", len(s.Script), s.Script)
+	c.ok("", "done")
+}
+
+func (c *conn) cmdDeletescript(args []string) {
+	// ../rfc/5804:1287
+	err := c.account.SieveScriptDelete(context.TODO(), args[0])
+	xsieveError(err)
+	c.ok("", "done")
+}
+
+func (c *conn) cmdRenamescript(args []string) {
+	// ../rfc/5804:1327
+	err := c.account.SieveScriptRename(context.TODO(), args[0], args[1])
+	xsieveError(err)
+	c.ok("", "done")
+}
+
+// prefixConn is a net.Conn prefixed with a buffer that is first returned when
+// reading, for buffered data that was already read before a TLS handshake.
+type prefixConn struct {
+	prefix []byte
+	net.Conn
+}
+
+func (c *prefixConn) Read(buf []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(buf, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(buf)
+}
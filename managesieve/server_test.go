@@ -0,0 +1,264 @@
+package managesieve
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+var pkglog = mlog.New("managesieve", nil)
+
+func init() {
+	authFailDelay = 0
+}
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+type testconn struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	done chan struct{}
+	acc  *store.Account
+}
+
+func start(t *testing.T, isTLS, noRequireSTARTTLS bool) *testconn {
+	limitersInit() // Reset rate limiters.
+
+	os.RemoveAll("../testdata/managesieve/data")
+	mox.Context = ctxbg
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/managesieve/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := store.OpenAccount(pkglog, "mjl")
+	tcheck(t, err, "open account")
+	err = acc.SetPassword(pkglog, "testtest")
+	tcheck(t, err, "set password")
+	switchStop := store.Switchboard()
+
+	serverConn, clientConn := net.Pipe()
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{fakeCert(t)},
+	}
+	done := make(chan struct{})
+	go func() {
+		serve("test", 1, tlsConfig, serverConn, noRequireSTARTTLS)
+		switchStop()
+		close(done)
+	}()
+	tc := &testconn{t: t, conn: clientConn, br: bufio.NewReader(clientConn), done: done, acc: acc}
+	tc.readResponse("OK")
+	if isTLS {
+		tc.transactf("OK", "STARTTLS")
+		tc.conn = tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+		tc.br = bufio.NewReader(tc.conn)
+		tc.readResponse("OK")
+	}
+	return tc
+}
+
+func (tc *testconn) close() {
+	tc.t.Helper()
+	tc.conn.Close()
+	<-tc.done
+	err := tc.acc.Close()
+	tcheck(tc.t, err, "close account")
+}
+
+func (tc *testconn) writelinef(format string, args ...any) {
+	tc.t.Helper()
+	_, err := fmt.Fprintf(tc.conn, format+"\r\n", args...)
+	tcheck(tc.t, err, "write")
+}
+
+// readResponse reads lines until an OK/NO/BYE response and checks its status.
+// Literals are read into the returned lines.
+func (tc *testconn) readResponse(status string) (lines []string, result string) {
+	tc.t.Helper()
+	for {
+		line, err := tc.br.ReadString('\n')
+		tcheck(tc.t, err, "read line")
+		line = strings.TrimSuffix(line, "\r\n")
+		if strings.HasPrefix(line, "{") && strings.HasSuffix(line, "}") {
+			var n int
+			_, err := fmt.Sscanf(line, "{%d}", &n)
+			tcheck(tc.t, err, "parse literal size")
+			buf := make([]byte, n)
+			_, err = io.ReadFull(tc.br, buf)
+			tcheck(tc.t, err, "read literal")
+			lines = append(lines, string(buf))
+			continue
+		}
+		for _, s := range []string{"OK", "NO", "BYE"} {
+			if line == s || strings.HasPrefix(line, s+" ") {
+				if s != status {
+					tc.t.Fatalf("got response %q, expected status %s", line, status)
+				}
+				return lines, line
+			}
+		}
+		lines = append(lines, line)
+	}
+}
+
+func (tc *testconn) transactf(status, format string, args ...any) (lines []string, result string) {
+	tc.t.Helper()
+	tc.writelinef(format, args...)
+	return tc.readResponse(status)
+}
+
+func (tc *testconn) login() {
+	tc.t.Helper()
+	auth := base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000testtest"))
+	tc.transactf("OK", `AUTHENTICATE "PLAIN" "%s"`, auth)
+}
+
+func fakeCert(t *testing.T) tls.Certificate {
+	privKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)) // Fake key, don't use this for real!
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1), // Required field...
+	}
+	localCertBuf, err := x509.CreateCertificate(cryptorand.Reader, template, template, privKey.Public(), privKey)
+	if err != nil {
+		t.Fatalf("making certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(localCertBuf)
+	if err != nil {
+		t.Fatalf("parsing generated certificate: %s", err)
+	}
+	c := tls.Certificate{
+		Certificate: [][]byte{localCertBuf},
+		PrivateKey:  privKey,
+		Leaf:        cert,
+	}
+	return c
+}
+
+func tcontains(t *testing.T, lines []string, exp string) {
+	t.Helper()
+	for _, l := range lines {
+		if l == exp {
+			return
+		}
+	}
+	t.Fatalf("missing line %q in %q", exp, lines)
+}
+
+func TestAuthenticate(t *testing.T) {
+	tc := start(t, false, false)
+	defer tc.close()
+
+	// Without TLS, no SASL mechanisms are advertised and login is refused.
+	lines, _ := tc.transactf("OK", "CAPABILITY")
+	tcontains(t, lines, `"SASL" ""`)
+	tcontains(t, lines, `"STARTTLS"`)
+	auth := base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000testtest"))
+	_, result := tc.transactf("NO", `AUTHENTICATE "PLAIN" "%s"`, auth)
+	if !strings.HasPrefix(result, "NO (ENCRYPT-NEEDED)") {
+		t.Fatalf("got %q, expected ENCRYPT-NEEDED", result)
+	}
+	tc.transactf("NO", "LISTSCRIPTS")
+
+	tc.transactf("OK", "STARTTLS")
+	tc.conn = tls.Client(tc.conn, &tls.Config{InsecureSkipVerify: true})
+	tc.br = bufio.NewReader(tc.conn)
+	lines, _ = tc.readResponse("OK")
+	tcontains(t, lines, `"SASL" "PLAIN"`)
+
+	bad := base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000bad"))
+	tc.transactf("NO", `AUTHENTICATE "PLAIN" "%s"`, bad)
+
+	// Without initial response, with a literal.
+	tc.writelinef(`AUTHENTICATE "PLAIN"`)
+	line, err := tc.br.ReadString('\n')
+	tcheck(t, err, "read challenge")
+	if line != "\"\"\r\n" {
+		t.Fatalf("got challenge %q, expected empty string", line)
+	}
+	tc.transactf("OK", "{%d+}\r\n%s", len(auth), auth)
+	lines, _ = tc.transactf("OK", "CAPABILITY")
+	tcontains(t, lines, `"OWNER" "mjl@mox.example"`)
+
+	tc.transactf("OK", "UNAUTHENTICATE")
+	tc.transactf("NO", "LISTSCRIPTS")
+	tc.transactf("OK", "LOGOUT")
+}
+
+func TestScripts(t *testing.T) {
+	tc := start(t, true, false)
+	defer tc.close()
+	tc.login()
+
+	script := "require \"fileinto\";\r\nfileinto \"Archive\";\r\n"
+	tc.transactf("OK", `HAVESPACE "test" %d`, len(script))
+	_, result := tc.transactf("NO", `HAVESPACE "test" 1000000`)
+	if !strings.HasPrefix(result, "NO (QUOTA/MAXSIZE)") {
+		t.Fatalf("got %q, expected QUOTA/MAXSIZE", result)
+	}
+	tc.transactf("OK", "CHECKSCRIPT {%d+}\r\n%s", len(script), script)
+	tc.transactf("NO", `CHECKSCRIPT "fileinto \"Archive\";"`) // Missing require.
+	tc.transactf("NO", `PUTSCRIPT "bad" "bogus;"`)
+
+	tc.transactf("OK", `PUTSCRIPT "test" {%d+}`+"\r\n%s", len(script), script)
+	tc.transactf("OK", `PUTSCRIPT "other" "keep;"`)
+	lines, _ := tc.transactf("OK", "LISTSCRIPTS")
+	tcontains(t, lines, `"other"`)
+	tcontains(t, lines, `"test"`)
+
+	tc.transactf("OK", `SETACTIVE "test"`)
+	lines, _ = tc.transactf("OK", "LISTSCRIPTS")
+	tcontains(t, lines, `"test" ACTIVE`)
+	_, result = tc.transactf("NO", `SETACTIVE "missing"`)
+	if !strings.HasPrefix(result, "NO (NONEXISTENT)") {
+		t.Fatalf("got %q, expected NONEXISTENT", result)
+	}
+
+	lines, _ = tc.transactf("OK", `GETSCRIPT "test"`)
+	tcontains(t, lines, script)
+
+	_, result = tc.transactf("NO", `DELETESCRIPT "test"`)
+	if !strings.HasPrefix(result, "NO (ACTIVE)") {
+		t.Fatalf("got %q, expected ACTIVE", result)
+	}
+	_, result = tc.transactf("NO", `RENAMESCRIPT "test" "other"`)
+	if !strings.HasPrefix(result, "NO (ALREADYEXISTS)") {
+		t.Fatalf("got %q, expected ALREADYEXISTS", result)
+	}
+	tc.transactf("OK", `RENAMESCRIPT "test" "renamed"`)
+	lines, _ = tc.transactf("OK", "LISTSCRIPTS")
+	tcontains(t, lines, `"renamed" ACTIVE`)
+
+	tc.transactf("OK", `SETACTIVE ""`)
+	tc.transactf("OK", `DELETESCRIPT "renamed"`)
+	_, result = tc.transactf("NO", `GETSCRIPT "renamed"`)
+	if !strings.HasPrefix(result, "NO (NONEXISTENT)") {
+		t.Fatalf("got %q, expected NONEXISTENT", result)
+	}
+
+	_, result = tc.transactf("OK", `NOOP "tag1"`)
+	if result != `OK (TAG "tag1") "done"` {
+		t.Fatalf("got %q, expected tagged noop response", result)
+	}
+	tc.transactf("NO", "BOGUS")
+}
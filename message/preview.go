@@ -0,0 +1,213 @@
+package message
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"strings"
+	"unicode"
+
+	"golang.org/x/exp/slog"
+	"golang.org/x/net/html"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// PreviewMaxLength is the maximum number of characters in a preview. ../rfc/8970
+const PreviewMaxLength = 256
+
+// Preview returns a short text of the message for display in a list of messages,
+// as used by IMAP PREVIEW. The first text/plain part that is not an attachment is
+// used, without quoted text and trailing signatures. If there is no such part, the
+// text of the first text/html part is used. The preview is a single line of at most
+// PreviewMaxLength characters, possibly empty.
+func (p *Part) Preview(elog *slog.Logger) (string, error) {
+	log := mlog.New("message", elog)
+
+	textPart := findPreviewPart(log, p, "PLAIN")
+	if textPart != nil {
+		s, err := FormatFirstLine(textPart.ReaderUTF8OrBinary())
+		return previewLine(s), err
+	}
+	htmlPart := findPreviewPart(log, p, "HTML")
+	if htmlPart != nil {
+		s, err := htmlText(io.LimitReader(htmlPart.ReaderUTF8OrBinary(), 128*1024))
+		return previewLine(s), err
+	}
+	return "", nil
+}
+
+// findPreviewPart returns the first text part with subtype, skipping attachments
+// and signatures of multipart/signed messages.
+func findPreviewPart(log mlog.Log, p *Part, subtype string) *Part {
+	if p.MediaType == "MULTIPART" {
+		for i := range p.Parts {
+			if p.MediaSubType == "SIGNED" && i >= 1 {
+				break
+			}
+			if pp := findPreviewPart(log, &p.Parts[i], subtype); pp != nil {
+				return pp
+			}
+		}
+		return nil
+	}
+	if (p.MediaType != "TEXT" || p.MediaSubType != subtype) && (subtype != "PLAIN" || p.MediaType != "" || p.MediaSubType != "") {
+		return nil
+	}
+	h, err := p.Header()
+	if err != nil {
+		log.Debugx("parsing part headers for preview", err)
+		return nil
+	}
+	if cd := h.Get("Content-Disposition"); cd != "" {
+		disp, _, err := mime.ParseMediaType(cd)
+		if err == nil && strings.EqualFold(disp, "attachment") {
+			return nil
+		}
+	}
+	return p
+}
+
+// previewLine turns s into a single line, with all whitespace replaced by a single
+// space, truncated to PreviewMaxLength characters.
+func previewLine(s string) string {
+	s = strings.Join(strings.FieldsFunc(s, unicode.IsSpace), " ")
+	r := []rune(s)
+	if len(r) > PreviewMaxLength {
+		s = string(r[:PreviewMaxLength-3]) + "..."
+	}
+	return s
+}
+
+// htmlText returns the text of an HTML document, without the contents of head,
+// script and style elements.
+func htmlText(r io.Reader) (string, error) {
+	var b strings.Builder
+	var skip int
+	z := html.NewTokenizer(r)
+	for b.Len() < 4*PreviewMaxLength {
+		switch z.Next() {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return b.String(), err
+			}
+			return b.String(), nil
+		case html.StartTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "head", "script", "style":
+				skip++
+			}
+		case html.EndTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "head", "script", "style":
+				if skip > 0 {
+					skip--
+				}
+			}
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(z.Text())
+				b.WriteString(" ")
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// FormatFirstLine returns a line the client can display next to the subject line
+// in a mailbox. It will replace quoted text, and any prefixing "On ... write:"
+// line with "[...]" so only new and useful information will be displayed.
+// Trailing signatures are not included.
+func FormatFirstLine(r io.Reader) (string, error) {
+	// We look quite a bit of lines ahead for trailing signatures with trailing empty lines.
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	ensureLines := func() {
+		for len(lines) < 10 && scanner.Scan() {
+			lines = append(lines, strings.TrimSpace(scanner.Text()))
+		}
+	}
+	ensureLines()
+
+	isSnipped := func(s string) bool {
+		return s == "[...]" || s == "[…]" || s == "..."
+	}
+
+	nextLineQuoted := func(i int) bool {
+		if i+1 < len(lines) && lines[i+1] == "" {
+			i++
+		}
+		return i+1 < len(lines) && (strings.HasPrefix(lines[i+1], ">") || isSnipped(lines[i+1]))
+	}
+
+	// Remainder is signature if we see a line with only and minimum 2 dashes, and
+	// there are no more empty lines, and there aren't more than 5 lines left.
+	isSignature := func() bool {
+		if len(lines) == 0 || !strings.HasPrefix(lines[0], "--") || strings.Trim(strings.TrimSpace(lines[0]), "-") != "" {
+			return false
+		}
+		l := lines[1:]
+		for len(l) > 0 && l[len(l)-1] == "" {
+			l = l[:len(l)-1]
+		}
+		if len(l) >= 5 {
+			return false
+		}
+		for _, line := range l {
+			if line == "" {
+				return false
+			}
+		}
+		return true
+	}
+
+	result := ""
+
+	resultSnipped := func() bool {
+		return strings.HasSuffix(result, "[...]\n") || strings.HasSuffix(result, "[…]")
+	}
+
+	// Quick check for initial wrapped "On ... wrote:" line.
+	if len(lines) > 3 && strings.HasPrefix(lines[0], "On ") && !strings.HasSuffix(lines[0], "wrote:") && strings.HasSuffix(lines[1], ":") && nextLineQuoted(1) {
+		result = "[...]\n"
+		lines = lines[3:]
+		ensureLines()
+	}
+
+	for ; len(lines) > 0 && !isSignature(); ensureLines() {
+		line := lines[0]
+		if strings.HasPrefix(line, ">") {
+			if !resultSnipped() {
+				result += "[...]\n"
+			}
+			lines = lines[1:]
+			continue
+		}
+		if line == "" {
+			lines = lines[1:]
+			continue
+		}
+		// Check for a "On <date>, <person> wrote:", we require digits before a quoted
+		// line, with an optional empty line in between. If we don't have any text yet, we
+		// don't require the digits.
+		if strings.HasSuffix(line, ":") && (strings.ContainsAny(line, "0123456789") || result == "") && nextLineQuoted(0) {
+			if !resultSnipped() {
+				result += "[...]\n"
+			}
+			lines = lines[1:]
+			continue
+		}
+		// Skip possibly duplicate snipping by author.
+		if !isSnipped(line) || !resultSnipped() {
+			result += line + "\n"
+		}
+		lines = lines[1:]
+		if len(result) > 250 {
+			break
+		}
+	}
+	if len(result) > 250 {
+		result = result[:230] + "..."
+	}
+	return result, scanner.Err()
+}
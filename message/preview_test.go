@@ -0,0 +1,84 @@
+package message
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+func TestFormatFirstLine(t *testing.T) {
+	check := func(body, expLine string) {
+		t.Helper()
+
+		line, err := FormatFirstLine(strings.NewReader(body))
+		tcompare(t, err, nil)
+		if line != expLine {
+			t.Fatalf("got %q, expected %q, for body %q", line, expLine, body)
+		}
+	}
+
+	check("", "")
+	check("single line", "single line\n")
+	check("single line\n", "single line\n")
+	check("> quoted\n", "[...]\n")
+	check("> quoted\nresponse\n", "[...]\nresponse\n")
+	check("> quoted\n[...]\nresponse after author snip\n", "[...]\nresponse after author snip\n")
+	check("[...]\nresponse after author snip\n", "[...]\nresponse after author snip\n")
+	check("[…]\nresponse after author snip\n", "[…]\nresponse after author snip\n")
+	check(">> quoted0\n> quoted1\n>quoted2\n[...]\nresponse after author snip\n", "[...]\nresponse after author snip\n")
+	check(">quoted\n\n>quoted\ncoalesce line-separated quotes\n", "[...]\ncoalesce line-separated quotes\n")
+	check("On <date> <user> wrote:\n> hi\nresponse", "[...]\nresponse\n")
+	check("On <longdate>\n<user> wrote:\n> hi\nresponse", "[...]\nresponse\n")
+	check("> quote\nresponse\n--\nsignature\n", "[...]\nresponse\n")
+	check("> quote\nline1\nline2\nline3\n", "[...]\nline1\nline2\nline3\n")
+}
+
+func TestPreview(t *testing.T) {
+	log := mlog.New("message", nil)
+
+	check := func(msg, exp string) {
+		t.Helper()
+
+		p, err := Parse(log.Logger, false, strings.NewReader(strings.ReplaceAll(msg, "\n", "\r\n")))
+		tcheck(t, err, "parse")
+		err = p.Walk(log.Logger, nil)
+		tcheck(t, err, "walk")
+		s, err := p.Preview(log.Logger)
+		tcheck(t, err, "preview")
+		tcompare(t, s, exp)
+	}
+
+	check("Subject: test\n\n> quoted\nresponse\n\non two  lines\n", "[...] response on two lines")
+	check("Subject: test\n\n", "")
+
+	check(`Content-Type: multipart/mixed; boundary=x
+
+--x
+Content-Type: text/plain
+Content-Disposition: attachment; filename=a.txt
+
+attachment
+--x
+Content-Type: multipart/alternative; boundary=y
+
+--y
+Content-Type: text/html
+
+<html><head><title>title</title><style>x</style></head><body><p>html</p></body></html>
+--y
+Content-Type: text/plain
+
+plain text
+--y--
+--x--
+`, "plain text")
+
+	check(`Content-Type: text/html
+
+<html><head><title>title</title></head><body><script>x</script><p>html <b>text</b></p></body></html>
+`, "html text")
+
+	// Long lines are truncated.
+	check("Subject: test\n\n"+strings.Repeat("word ", 100)+"\n", strings.Repeat("word ", 46)+"...")
+}
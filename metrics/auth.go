@@ -12,10 +12,11 @@ var (
 			Help: "Authentication attempts and results.",
 		},
 		[]string{
-			"kind",    // submission, imap, webmail, webaccount, webadmin (formerly httpaccount, httpadmin)
-			"variant", // login, plain, scram-sha-256, scram-sha-1, cram-md5, weblogin, websessionuse. formerly: httpbasic.
+			"kind",       // submission, imap, webmail, webaccount, webadmin (formerly httpaccount, httpadmin)
+			"variant",    // login, plain, scram-sha-256, scram-sha-1, cram-md5, weblogin, websessionuse. formerly: httpbasic.
+			"credential", // password, apppassword, session, totp (password with second factor). empty if not known, e.g. for failed attempts.
 			// todo: we currently only use badcreds, but known baduser can be helpful
-			"result", // ok, baduser, badpassword, badcreds, error, aborted
+			"result", // ok, baduser, badpassword, badcreds, totprequired, error, aborted
 		},
 	)
 
@@ -30,8 +31,8 @@ var (
 	)
 )
 
-func AuthenticationInc(kind, variant, result string) {
-	metricAuth.WithLabelValues(kind, variant, result).Inc()
+func AuthenticationInc(kind, variant, credential, result string) {
+	metricAuth.WithLabelValues(kind, variant, credential, result).Inc()
 }
 
 func AuthenticationRatelimitedInc(kind string) {
@@ -22,6 +22,7 @@ const (
 	Import           Panic = "import"
 	Serve            Panic = "serve"
 	Imapserver       Panic = "imapserver"
+	Managesieve      Panic = "managesieve"
 	Dmarcdb          Panic = "dmarcdb"
 	Mtastsdb         Panic = "mtastsdb"
 	Queue            Panic = "queue"
@@ -31,6 +32,7 @@ const (
 	Dkimverify       Panic = "dkimverify"
 	Spfverify        Panic = "spfverify"
 	Upgradethreads   Panic = "upgradethreads"
+	Upgradewordindex Panic = "upgradewordindex"
 	Importmanage     Panic = "importmanage"
 	Importmessages   Panic = "importmessages"
 	Store            Panic = "store"
@@ -50,6 +52,7 @@ func init() {
 		Import,
 		Serve,
 		Imapserver,
+		Managesieve,
 		Mtastsdb,
 		Queue,
 		Smtpclient,
@@ -57,6 +60,7 @@ func init() {
 		Dkimverify,
 		Spfverify,
 		Upgradethreads,
+		Upgradewordindex,
 		Importmanage,
 		Importmessages,
 		Webadmin,
@@ -0,0 +1,381 @@
+// Package mlist implements hosting mailing lists.
+//
+// Lists are configured per domain. People subscribe and unsubscribe by sending a
+// message to the command addresses of a list, and confirming the request by
+// replying to the confirmation message, proving they can receive messages at
+// the address. Messages sent to subscribers have List-* headers, including a
+// List-Unsubscribe header for one-click unsubscribe over HTTPS, see RFC 8058.
+//
+// Posts to a list are queued for each subscriber separately, with a per-subscriber
+// envelope sender (VERP). Delivery status notifications sent to that address
+// identify the subscriber. After too many bounces, delivery to a subscriber is
+// disabled.
+//
+// Addresses, for a list "team@example.org":
+//
+//   - team@example.org, for posting to the list.
+//   - team-subscribe@example.org and team-unsubscribe@example.org, for requesting
+//     subscription changes.
+//   - team-confirm-<token>@example.org, for confirming a request.
+//   - team-owner@example.org, forwarded to the owners of the list.
+//   - team-bounces-<token>@example.org, envelope sender for subscriber <token>.
+package mlist
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+var pkglog = mlog.New("mlist", nil)
+
+var (
+	ErrUnknownToken = errors.New("mlist: unknown token")
+)
+
+// Commands, the suffixes of the localpart of list command addresses.
+const (
+	CommandPost        = ""
+	CommandSubscribe   = "subscribe"
+	CommandUnsubscribe = "unsubscribe"
+	CommandOwner       = "owner"
+	CommandConfirm     = "confirm"
+	CommandBounces     = "bounces"
+)
+
+// After this many bounces, with at most bounceResetPeriod between them, delivery
+// to a subscriber is disabled.
+const (
+	bounceLimit       = 5
+	bounceResetPeriod = 7 * 24 * time.Hour
+)
+
+// Confirmation requests expire after pendingMaxAge.
+const pendingMaxAge = 7 * 24 * time.Hour
+
+// Subscriber is an address subscribed to a list.
+type Subscriber struct {
+	ID         int64
+	List       string    `bstore:"nonzero,unique List+Address"` // List address, e.g. team@example.org.
+	Address    string    `bstore:"nonzero"`                     // Address of subscriber, as used in the subscription request.
+	Token      string    `bstore:"nonzero,unique"`              // For unsubscribing and the bounce address.
+	Created    time.Time `bstore:"default now"`
+	Bounces    int       // Number of recent bounces.
+	LastBounce time.Time // Bounces are reset when the previous bounce is older than a week.
+	Disabled   bool      // Set after too many bounces, no messages are sent to disabled subscribers.
+}
+
+// Pending is a subscription or unsubscription request that awaits confirmation.
+type Pending struct {
+	ID        int64
+	Token     string `bstore:"nonzero,unique"`
+	List      string `bstore:"nonzero"`
+	Address   string `bstore:"nonzero"`
+	Subscribe bool
+	Created   time.Time `bstore:"default now,index"`
+}
+
+var DBTypes = []any{Subscriber{}, Pending{}} // Types stored in DB.
+var DB *bstore.DB                            // Exported for backups.
+var mutex sync.Mutex
+
+func database(ctx context.Context) (rdb *bstore.DB, rerr error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if DB == nil {
+		p := mox.DataDirPath("mlist.db")
+		os.MkdirAll(filepath.Dir(p), 0770)
+		db, err := bstore.Open(ctx, p, &bstore.Options{Timeout: 5 * time.Second, Perm: 0660}, DBTypes...)
+		if err != nil {
+			return nil, err
+		}
+		DB = db
+	}
+	return DB, nil
+}
+
+// Init opens the database.
+func Init() error {
+	_, err := database(mox.Shutdown)
+	return err
+}
+
+// Close closes the database.
+func Close() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if DB != nil {
+		err := DB.Close()
+		pkglog.Check(err, "closing database")
+		DB = nil
+	}
+}
+
+// Target is a list address or one of its command addresses.
+type Target struct {
+	List    config.MailingList
+	Address smtp.Address // Address of the list.
+	Command string       // One of the Command* constants.
+	Token   string       // For CommandConfirm and CommandBounces.
+}
+
+// Lookup returns the list target for an address, if it is a list address or
+// command address of a configured list.
+func Lookup(localpart smtp.Localpart, domain dns.Domain) (Target, bool) {
+	dc, ok := mox.Conf.Domain(domain)
+	if !ok || len(dc.MailingLists) == 0 {
+		return Target{}, false
+	}
+	lp := string(localpart)
+	if !dc.LocalpartCaseSensitive {
+		lp = strings.ToLower(lp)
+	}
+	if ml, ok := dc.MailingLists[lp]; ok {
+		return Target{ml, smtp.NewAddress(smtp.Localpart(lp), dc.Domain), CommandPost, ""}, true
+	}
+	for name, ml := range dc.MailingLists {
+		rest, ok := strings.CutPrefix(lp, name+"-")
+		if !ok {
+			continue
+		}
+		t := Target{ml, smtp.NewAddress(smtp.Localpart(name), dc.Domain), "", ""}
+		cmd, token, hasToken := strings.Cut(rest, "-")
+		switch cmd {
+		case CommandSubscribe, CommandUnsubscribe, CommandOwner:
+			if !hasToken {
+				t.Command = cmd
+				return t, true
+			}
+		case CommandConfirm, CommandBounces:
+			if token != "" {
+				t.Command = cmd
+				t.Token = token
+				return t, true
+			}
+		}
+	}
+	return Target{}, false
+}
+
+// CommandAddress returns the address for a command of a list, for use in
+// headers and messages.
+func CommandAddress(list smtp.Address, command, token string) smtp.Address {
+	lp := string(list.Localpart)
+	if command != CommandPost {
+		lp += "-" + command
+	}
+	if token != "" {
+		lp += "-" + token
+	}
+	return smtp.NewAddress(smtp.Localpart(lp), list.Domain)
+}
+
+// PostAllowed returns whether a message from msgFrom can be posted to the list.
+// Validated indicates if msgFrom was verified through DKIM and/or SPF alignment.
+func PostAllowed(ctx context.Context, t Target, msgFrom smtp.Address, validated bool) (bool, error) {
+	if t.List.PostPolicy == "anyone" {
+		return true, nil
+	} else if !validated {
+		return false, nil
+	}
+	for _, o := range t.List.ParsedOwners {
+		if strings.EqualFold(o.String(), msgFrom.String()) {
+			return true, nil
+		}
+	}
+	if t.List.PostPolicy == "owners" {
+		return false, nil
+	}
+	db, err := database(ctx)
+	if err != nil {
+		return false, err
+	}
+	l, err := bstore.QueryDB[Subscriber](ctx, db).FilterNonzero(Subscriber{List: t.Address.String()}).List()
+	if err != nil {
+		return false, fmt.Errorf("listing subscribers: %v", err)
+	}
+	for _, s := range l {
+		if strings.EqualFold(s.Address, msgFrom.String()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Subscribers returns the subscribers of a list, including disabled subscribers.
+func Subscribers(ctx context.Context, list smtp.Address) ([]Subscriber, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bstore.QueryDB[Subscriber](ctx, db).FilterNonzero(Subscriber{List: list.String()}).SortAsc("ID").List()
+}
+
+func newToken() string {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+}
+
+// RequestConfirm registers a request to subscribe or unsubscribe addr, and sends
+// a confirmation request to addr. If a request for the same address was made
+// within the past hour, no new request is sent.
+func RequestConfirm(ctx context.Context, log mlog.Log, t Target, addr smtp.Address, subscribe bool) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	var pending *Pending
+	err = db.Write(ctx, func(tx *bstore.Tx) error {
+		// Remove expired requests while we are here.
+		_, err := bstore.QueryTx[Pending](tx).FilterLess("Created", time.Now().Add(-pendingMaxAge)).Delete()
+		if err != nil {
+			return fmt.Errorf("removing expired requests: %v", err)
+		}
+
+		q := bstore.QueryTx[Pending](tx)
+		q.FilterNonzero(Pending{List: t.Address.String(), Address: addr.String()})
+		q.FilterGreater("Created", time.Now().Add(-time.Hour))
+		if exists, err := q.Exists(); err != nil {
+			return fmt.Errorf("looking up recent requests: %v", err)
+		} else if exists {
+			return nil
+		}
+
+		pending = &Pending{Token: newToken(), List: t.Address.String(), Address: addr.String(), Subscribe: subscribe}
+		return tx.Insert(pending)
+	})
+	if err != nil {
+		return err
+	}
+	if pending == nil {
+		log.Info("not sending confirmation request, already sent recently", slog.Any("list", t.Address), slog.Any("address", addr))
+		return nil
+	}
+	return sendConfirm(ctx, log, t, addr, *pending)
+}
+
+// Confirm executes the request for token. Subscribers that were disabled due to
+// bounces are enabled again when they subscribe again.
+func Confirm(ctx context.Context, log mlog.Log, t Target, token string) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	var p Pending
+	err = db.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[Pending](tx)
+		q.FilterNonzero(Pending{Token: token, List: t.Address.String()})
+		q.FilterGreater("Created", time.Now().Add(-pendingMaxAge))
+		var err error
+		p, err = q.Get()
+		if err == bstore.ErrAbsent {
+			return ErrUnknownToken
+		} else if err != nil {
+			return fmt.Errorf("looking up request: %v", err)
+		}
+		if err := tx.Delete(&p); err != nil {
+			return fmt.Errorf("removing request: %v", err)
+		}
+
+		qs := bstore.QueryTx[Subscriber](tx)
+		qs.FilterNonzero(Subscriber{List: p.List, Address: p.Address})
+		sub, err := qs.Get()
+		if err == bstore.ErrAbsent {
+			if !p.Subscribe {
+				return nil
+			}
+			return tx.Insert(&Subscriber{List: p.List, Address: p.Address, Token: newToken()})
+		} else if err != nil {
+			return fmt.Errorf("looking up subscriber: %v", err)
+		} else if !p.Subscribe {
+			return tx.Delete(&sub)
+		}
+		sub.Disabled = false
+		sub.Bounces = 0
+		return tx.Update(&sub)
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("mailing list request confirmed", slog.Any("list", t.Address), slog.String("address", p.Address), slog.Bool("subscribe", p.Subscribe))
+	addr, err := smtp.ParseAddress(p.Address)
+	if err != nil {
+		return fmt.Errorf("parsing address: %v", err)
+	}
+	return sendNotice(ctx, log, t, addr, p.Subscribe)
+}
+
+// Unsubscribe removes the subscriber with token, as used for one-click
+// unsubscribe. The list the subscriber was removed from is returned.
+func Unsubscribe(ctx context.Context, log mlog.Log, token string) (string, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return "", err
+	}
+	var sub Subscriber
+	err = db.Write(ctx, func(tx *bstore.Tx) error {
+		var err error
+		sub, err = bstore.QueryTx[Subscriber](tx).FilterNonzero(Subscriber{Token: token}).Get()
+		if err == bstore.ErrAbsent {
+			return ErrUnknownToken
+		} else if err != nil {
+			return fmt.Errorf("looking up subscriber: %v", err)
+		}
+		return tx.Delete(&sub)
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Info("unsubscribed from mailing list", slog.String("list", sub.List), slog.String("address", sub.Address))
+	return sub.List, nil
+}
+
+// Bounce registers a delivery failure for the subscriber with token. After too
+// many bounces, delivery to the subscriber is disabled.
+func Bounce(ctx context.Context, log mlog.Log, t Target, token string) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[Subscriber](tx)
+		q.FilterNonzero(Subscriber{Token: token, List: t.Address.String()})
+		sub, err := q.Get()
+		if err == bstore.ErrAbsent {
+			return ErrUnknownToken
+		} else if err != nil {
+			return fmt.Errorf("looking up subscriber: %v", err)
+		}
+		now := time.Now()
+		if now.Sub(sub.LastBounce) > bounceResetPeriod {
+			sub.Bounces = 0
+		}
+		sub.Bounces++
+		sub.LastBounce = now
+		if sub.Bounces >= bounceLimit && !sub.Disabled {
+			sub.Disabled = true
+			log.Info("disabling delivery to mailing list subscriber after bounces", slog.String("list", sub.List), slog.String("address", sub.Address), slog.Int("bounces", sub.Bounces))
+		}
+		return tx.Update(&sub)
+	})
+}
@@ -0,0 +1,153 @@
+package mlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+)
+
+var ctxbg = context.Background()
+
+func tcheckf(t *testing.T, err error, format string, args ...any) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", fmt.Sprintf(format, args...), err)
+	}
+}
+
+func tcompare(t *testing.T, got, expect any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got:\n%v\nexpected:\n%v", got, expect)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/mlist/mox.conf")
+	mox.MustLoadConfig(true, false)
+
+	dom := dns.Domain{ASCII: "mox.example"}
+	test := func(lp string, expOK bool, expList, expCommand, expToken string) {
+		t.Helper()
+		tg, ok := Lookup(smtp.Localpart(lp), dom)
+		tcompare(t, ok, expOK)
+		if !ok {
+			return
+		}
+		tcompare(t, string(tg.Address.Localpart), expList)
+		tcompare(t, tg.Command, expCommand)
+		tcompare(t, tg.Token, expToken)
+	}
+
+	test("team", true, "team", CommandPost, "")
+	test("Team", true, "team", CommandPost, "")
+	test("team-subscribe", true, "team", CommandSubscribe, "")
+	test("team-unsubscribe", true, "team", CommandUnsubscribe, "")
+	test("team-owner", true, "team", CommandOwner, "")
+	test("team-confirm-abc", true, "team", CommandConfirm, "abc")
+	test("team-bounces-abc", true, "team", CommandBounces, "abc")
+	test("announce-all", true, "announce-all", CommandPost, "")
+	test("announce-all-subscribe", true, "announce-all", CommandSubscribe, "")
+	test("team-confirm", false, "", "", "")
+	test("team-subscribe-abc", false, "", "", "")
+	test("team-other", false, "", "", "")
+	test("mjl", false, "", "", "")
+
+	_, ok := Lookup("team", dns.Domain{ASCII: "other.example"})
+	tcompare(t, ok, false)
+
+	tg, _ := Lookup("team", dom)
+	tcompare(t, ListID(tg), `"Team list" <team.mox.example>`)
+	tg, _ = Lookup("announce-all", dom)
+	tcompare(t, ListID(tg), `<announce-all.mox.example>`)
+
+	tcompare(t, CommandAddress(tg.Address, CommandBounces, "abc").String(), "announce-all-bounces-abc@mox.example")
+	tcompare(t, UnsubscribeURL("abc"), "https://mox.example/mlist/unsubscribe/abc")
+}
+
+func TestDistribute(t *testing.T) {
+	os.RemoveAll("../testdata/mlist/data")
+	mox.Context = ctxbg
+	mox.Shutdown = ctxbg
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/mlist/mox.conf")
+	mox.MustLoadConfig(true, false)
+	err := Init()
+	tcheckf(t, err, "init")
+	defer Close()
+
+	log := mlog.New("mlist", nil)
+
+	tg, _ := Lookup("team", dns.Domain{ASCII: "mox.example"})
+	sub := Subscriber{List: "team@mox.example", Address: "remote@example.org", Token: "token1"}
+	err = DB.Insert(ctxbg, &sub)
+	tcheckf(t, err, "insert subscriber")
+	disabled := Subscriber{List: "team@mox.example", Address: "disabled@example.org", Token: "token2", Disabled: true}
+	err = DB.Insert(ctxbg, &disabled)
+	tcheckf(t, err, "insert subscriber")
+
+	var queued []string
+	queueAdd = func(ctx context.Context, log mlog.Log, qm *queue.Msg, msgFile *os.File) error {
+		buf, err := io.ReadAll(&moxio.AtReader{R: msgFile})
+		tcheckf(t, err, "read message")
+		tcompare(t, qm.Sender().String(), "team-bounces-token1@mox.example")
+		tcompare(t, qm.Recipient().String(), "remote@example.org")
+		queued = append(queued, string(qm.MsgPrefix)+string(buf))
+		return nil
+	}
+	defer func() { queueAdd = queue.Add }()
+
+	const msg = "List-Id: <other.example.org>\r\nFrom: Remote <remote@example.org>\r\nSubject: hi\r\n\r\nbody\r\n"
+	f, err := os.CreateTemp("", "mlist-test")
+	tcheckf(t, err, "temp file")
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, err = f.WriteString(msg)
+	tcheckf(t, err, "write message")
+
+	n, err := Distribute(ctxbg, log, tg, f, "Received: test\r\n", true, false, false, "<test@example.org>")
+	tcheckf(t, err, "distribute")
+	tcompare(t, n, 1)
+	tcompare(t, len(queued), 1)
+	m := queued[0]
+	for _, s := range []string{
+		"List-Unsubscribe: <https://mox.example/mlist/unsubscribe/token1>, <mailto:team-unsubscribe@mox.example>\r\n",
+		"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n",
+		"Received: test\r\nList-Id: \"Team list\" <team.mox.example>\r\n",
+		"List-Post: <mailto:team@mox.example>\r\n",
+		"From: \"Remote via team\" <team@mox.example>\r\n",
+		"Reply-To: Remote <remote@example.org>\r\n",
+		"\r\n\r\nbody\r\n",
+	} {
+		if !strings.Contains(m, s) {
+			t.Fatalf("message does not contain %q:\n%s", s, m)
+		}
+	}
+	if strings.Contains(m, "other.example.org") {
+		t.Fatalf("original list header not removed:\n%s", m)
+	}
+
+	// One-click unsubscribe.
+	list, err := Unsubscribe(ctxbg, log, "token1")
+	tcheckf(t, err, "unsubscribe")
+	tcompare(t, list, "team@mox.example")
+	_, err = Unsubscribe(ctxbg, log, "token1")
+	if !errors.Is(err, ErrUnknownToken) {
+		t.Fatalf("got err %v, expected ErrUnknownToken", err)
+	}
+	subs, err := Subscribers(ctxbg, tg.Address)
+	tcheckf(t, err, "subscribers")
+	tcompare(t, len(subs), 1)
+}
@@ -0,0 +1,350 @@
+package mlist
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/mox/dkim"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/srs"
+	"github.com/mjl-/mox/store"
+)
+
+var queueAdd = queue.Add // Tests override this.
+
+// Headers set by the list. Existing headers with these names in posts are removed.
+var listHeaders = map[string]bool{
+	"list-id":               true,
+	"list-help":             true,
+	"list-subscribe":        true,
+	"list-unsubscribe":      true,
+	"list-unsubscribe-post": true,
+	"list-post":             true,
+	"list-owner":            true,
+	"list-archive":          true,
+	"precedence":            true,
+}
+
+// ListID returns the List-Id header value for the list. ../rfc/2919:145
+func ListID(t Target) string {
+	id := "<" + string(t.Address.Localpart) + "." + t.Address.Domain.ASCII + ">"
+	if t.List.Description == "" {
+		return id
+	}
+	// Encode description like a display name.
+	s := (&mail.Address{Name: t.List.Description, Address: "x@x"}).String()
+	return strings.TrimSuffix(s, " <x@x>") + " " + id
+}
+
+// UnsubscribeURL returns the URL for one-click unsubscribe for a token, or an
+// empty string if no listener serves unsubscribe requests.
+func UnsubscribeURL(token string) string {
+	names := maps.Keys(mox.Conf.Static.Listeners)
+	sort.Strings(names)
+	for _, name := range names {
+		l := mox.Conf.Static.Listeners[name]
+		if !l.MailingListHTTPS.Enabled {
+			continue
+		}
+		host := mox.Conf.Static.HostnameDomain.ASCII
+		if l.HostnameDomain.ASCII != "" {
+			host = l.HostnameDomain.ASCII
+		}
+		if port := l.MailingListHTTPS.Port; port != 0 && port != 443 && !l.MailingListHTTPS.NonTLS {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		return "https://" + host + "/mlist/unsubscribe/" + token
+	}
+	return ""
+}
+
+// headerFields returns the header fields, each including continuation lines and
+// the ending CRLF.
+func headerFields(hdr []byte) [][]byte {
+	var l [][]byte
+	for len(hdr) > 0 {
+		n := 0
+		for {
+			i := bytes.Index(hdr[n:], []byte("\r\n"))
+			if i < 0 {
+				n = len(hdr)
+				break
+			}
+			n += i + 2
+			if n >= len(hdr) || hdr[n] != ' ' && hdr[n] != '\t' {
+				break
+			}
+		}
+		l = append(l, hdr[:n])
+		hdr = hdr[n:]
+	}
+	return l
+}
+
+func headerName(field []byte) string {
+	k, _, _ := bytes.Cut(field, []byte(":"))
+	return strings.ToLower(strings.TrimSpace(string(k)))
+}
+
+// Distribute queues a post to the list for delivery to all subscribers that are
+// not disabled, returning the number of messages queued. The message is written
+// to a new file with recvHdr and list headers added, and existing list headers
+// removed. If rewriteFrom is set, the From header is changed to the list address
+// and a Reply-To header with the original From header is added if not present.
+// The messages are DKIM-signed for the domain of the list, with the List-*
+// headers included in the signature. ../rfc/8058:139
+func Distribute(ctx context.Context, log mlog.Log, t Target, msgFile *os.File, recvHdr string, rewriteFrom, has8bit, smtputf8 bool, messageID string) (int, error) {
+	subs, err := Subscribers(ctx, t.Address)
+	if err != nil {
+		return 0, fmt.Errorf("listing subscribers: %v", err)
+	}
+
+	hdr, err := message.ReadHeaders(bufio.NewReader(&moxio.AtReader{R: msgFile}))
+	if err != nil {
+		return 0, fmt.Errorf("reading message header: %v", err)
+	}
+
+	f, err := store.CreateMessageTemp(log, "mlist-post")
+	if err != nil {
+		return 0, fmt.Errorf("creating temporary file: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "mailing list post")
+
+	var b bytes.Buffer
+	b.WriteString(recvHdr)
+	b.WriteString("List-Id: " + ListID(t) + "\r\n")
+	if t.List.PostPolicy == "owners" {
+		b.WriteString("List-Post: NO\r\n") // ../rfc/2369:331
+	} else {
+		b.WriteString("List-Post: <mailto:" + t.Address.String() + ">\r\n")
+	}
+	b.WriteString("List-Subscribe: <mailto:" + CommandAddress(t.Address, CommandSubscribe, "").String() + ">\r\n")
+	b.WriteString("List-Owner: <mailto:" + CommandAddress(t.Address, CommandOwner, "").String() + ">\r\n")
+	b.WriteString("Precedence: list\r\n")
+	var origFrom []byte
+	var haveReplyTo bool
+	for _, field := range headerFields(hdr) {
+		k := headerName(field)
+		if listHeaders[k] {
+			continue
+		}
+		if k == "reply-to" {
+			haveReplyTo = true
+		}
+		if k == "from" && rewriteFrom && origFrom == nil {
+			_, v, _ := bytes.Cut(field, []byte(":"))
+			origFrom = v
+			name := string(bytes.TrimSpace(v))
+			if a, err := mail.ParseAddress(name); err == nil {
+				name = a.Name
+				if name == "" {
+					name = a.Address
+				}
+			}
+			from := mail.Address{Name: name + " via " + string(t.Address.Localpart), Address: t.Address.String()}
+			b.WriteString("From: " + from.String() + "\r\n")
+			continue
+		}
+		b.Write(field)
+	}
+	if origFrom != nil && !haveReplyTo {
+		b.WriteString("Reply-To:")
+		b.Write(origFrom)
+	}
+	b.WriteString("\r\n")
+	if _, err := f.Write(b.Bytes()); err != nil {
+		return 0, fmt.Errorf("writing header: %v", err)
+	}
+	if _, err := io.Copy(f, io.NewSectionReader(msgFile, int64(len(hdr))+2, 1<<62)); err != nil {
+		return 0, fmt.Errorf("writing body: %v", err)
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("size of message: %v", err)
+	}
+
+	var selectors []dkim.Selector
+	if dc, ok := mox.Conf.Domain(t.Address.Domain); ok {
+		selectors = mox.DKIMSelectors(dc.DKIM)
+		for i := range selectors {
+			selectors[i].Headers = append(append([]string{}, selectors[i].Headers...), "List-Id", "List-Unsubscribe", "List-Unsubscribe-Post", "List-Post")
+		}
+	}
+
+	var n int
+	for _, sub := range subs {
+		if sub.Disabled {
+			continue
+		}
+		to, err := smtp.ParseAddress(sub.Address)
+		if err != nil {
+			log.Errorx("parsing subscriber address", err, slog.String("address", sub.Address))
+			continue
+		}
+
+		unsub := "<mailto:" + CommandAddress(t.Address, CommandUnsubscribe, "").String() + ">"
+		prefix := ""
+		if u := UnsubscribeURL(sub.Token); u != "" {
+			unsub = "<" + u + ">, " + unsub
+			prefix = "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n"
+		}
+		prefix = "List-Unsubscribe: " + unsub + "\r\n" + prefix
+		if len(selectors) > 0 {
+			dkimHeaders, err := dkim.Sign(ctx, log.Logger, t.Address.Localpart, t.Address.Domain, selectors, smtputf8, store.FileMsgReader([]byte(prefix), f))
+			log.Check(err, "dkim signing mailing list message")
+			prefix = dkimHeaders + prefix
+		}
+
+		sender := CommandAddress(t.Address, CommandBounces, sub.Token).Path()
+		qm := queue.MakeMsg("", sender, to.Path(), has8bit, smtputf8, size+int64(len(prefix)), messageID, []byte(prefix), nil)
+		if err := queueAdd(ctx, log, &qm, f); err != nil {
+			return n, fmt.Errorf("queueing message for subscriber: %v", err)
+		}
+		n++
+	}
+	log.Info("mailing list post queued", slog.Any("list", t.Address), slog.Int("subscribers", n))
+	return n, nil
+}
+
+// ForwardOwners forwards a message sent to the owner address of the list to the
+// owners, with the envelope sender rewritten with SRS.
+func ForwardOwners(ctx context.Context, log mlog.Log, t Target, msgFile *os.File, mailFrom smtp.Path, recvHdr string, has8bit, smtputf8 bool, messageID string) error {
+	fi, err := msgFile.Stat()
+	if err != nil {
+		return fmt.Errorf("stat message: %v", err)
+	}
+	sender := srs.Forward(mox.SRSKey, mailFrom, t.Address.Domain, time.Now())
+	var queued bool
+	for _, o := range t.List.ParsedOwners {
+		qm := queue.MakeMsg("", sender, o.Path(), has8bit, smtputf8, fi.Size()+int64(len(recvHdr)), messageID, []byte(recvHdr), nil)
+		if err := queueAdd(ctx, log, &qm, msgFile); err != nil {
+			log.Errorx("forwarding message to list owner", err, slog.Any("owner", o))
+			continue
+		}
+		queued = true
+	}
+	if !queued {
+		return errors.New("forwarding to list owners failed")
+	}
+	return nil
+}
+
+func sendConfirm(ctx context.Context, log mlog.Log, t Target, to smtp.Address, p Pending) error {
+	from := CommandAddress(t.Address, CommandConfirm, p.Token)
+	var subject, action string
+	if p.Subscribe {
+		subject = "Confirm subscription to " + t.Address.String()
+		action = "subscribe to"
+	} else {
+		subject = "Confirm unsubscribing from " + t.Address.String()
+		action = "unsubscribe from"
+	}
+	text := fmt.Sprintf(`A request was made to %s the mailing list %s for your address %s.
+
+To confirm, reply to this message, or send a message to %s.
+
+If you did not make this request, you can ignore this message.
+`, action, t.Address, to, from)
+	return sendMessage(ctx, log, from, to, subject, text)
+}
+
+func sendNotice(ctx context.Context, log mlog.Log, t Target, to smtp.Address, subscribed bool) error {
+	from := CommandAddress(t.Address, CommandOwner, "")
+	var subject, text string
+	if subscribed {
+		subject = "Subscribed to " + t.Address.String()
+		text = fmt.Sprintf(`Your address %s is now subscribed to the mailing list %s.
+
+To unsubscribe, send a message to %s, or use the unsubscribe link or button of your email application.
+`, to, t.Address, CommandAddress(t.Address, CommandUnsubscribe, ""))
+	} else {
+		subject = "Unsubscribed from " + t.Address.String()
+		text = fmt.Sprintf(`Your address %s has been unsubscribed from the mailing list %s.
+`, to, t.Address)
+	}
+	return sendMessage(ctx, log, from, to, subject, text)
+}
+
+// sendMessage composes and queues a message about a subscription, sent with the
+// null reverse path to prevent loops.
+func sendMessage(ctx context.Context, log mlog.Log, from, to smtp.Address, subject, text string) error {
+	f, err := store.CreateMessageTemp(log, "mlist-msg")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "mailing list message")
+
+	messageID, has8bit, smtputf8, err := compose(f, from, to, subject, text)
+	if err != nil {
+		return err
+	}
+	buf, err := os.ReadFile(f.Name())
+	if err != nil {
+		return fmt.Errorf("reading composed message: %v", err)
+	}
+	dkimHeaders, err := mox.DKIMSign(ctx, log, from.Path(), smtputf8, buf)
+	log.Check(err, "dkim signing mailing list message")
+
+	size := int64(len(dkimHeaders) + len(buf))
+	qm := queue.MakeMsg("", smtp.Path{}, to.Path(), has8bit, smtputf8, size, messageID, []byte(dkimHeaders), nil)
+	if err := queueAdd(ctx, log, &qm, f); err != nil {
+		return fmt.Errorf("queueing message: %w", err)
+	}
+	log.Info("mailing list message queued", slog.Any("to", to), slog.String("subject", subject))
+	return nil
+}
+
+func compose(f *os.File, from, to smtp.Address, subject, text string) (messageID string, has8bit, smtputf8 bool, rerr error) {
+	xc := message.NewComposer(f, 1024*1024)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	xc.SMTPUTF8 = to.Localpart.IsInternational() || from.Localpart.IsInternational()
+
+	xc.HeaderAddrs("From", []message.NameAddress{{Address: from}})
+	xc.HeaderAddrs("To", []message.NameAddress{{Address: to}})
+	xc.Subject(subject)
+	messageID = fmt.Sprintf("<%s>", mox.MessageIDGen(xc.SMTPUTF8))
+	xc.Header("Message-Id", messageID)
+	xc.Header("Date", time.Now().Format(message.RFC5322Z))
+	xc.Header("Auto-Submitted", "auto-generated") // ../rfc/3834:237
+	xc.Header("User-Agent", "mox/"+moxvar.Version)
+	xc.Header("MIME-Version", "1.0")
+	textBody, ct, cte := xc.TextPart(text)
+	xc.Header("Content-Type", ct)
+	xc.Header("Content-Transfer-Encoding", cte)
+	xc.Line()
+	_, err := xc.Write(textBody)
+	xc.Checkf(err, "writing text")
+	xc.Flush()
+	return messageID, xc.Has8bit, xc.SMTPUTF8, nil
+}
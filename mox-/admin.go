@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"golang.org/x/exp/slog"
 
 	"github.com/mjl-/adns"
@@ -806,6 +807,20 @@ func AccountRemove(ctx context.Context, account string) (rerr error) {
 			nc.Accounts[name] = a
 		}
 	}
+	// The account is no longer a domain administrator.
+	nc.Domains = map[string]config.Domain{}
+	for name, d := range c.Domains {
+		if slices.Contains(d.Admins, account) {
+			var admins []string
+			for _, s := range d.Admins {
+				if s != account {
+					admins = append(admins, s)
+				}
+			}
+			d.Admins = admins
+		}
+		nc.Domains[name] = d
+	}
 
 	if err := writeDynamic(ctx, log, nc); err != nil {
 		return fmt.Errorf("writing domains.conf: %v", err)
@@ -826,6 +841,8 @@ func checkAddressAvailable(addr smtp.Address) error {
 		return fmt.Errorf("canonicalizing localpart: %v", err)
 	} else if _, ok := Conf.accountDestinations[smtp.NewAddress(lp, addr.Domain).String()]; ok {
 		return fmt.Errorf("canonicalized address %s already configured", smtp.NewAddress(lp, addr.Domain))
+	} else if _, ok := dc.Aliases[string(lp)]; ok {
+		return fmt.Errorf("canonicalized address %s already configured as alias", smtp.NewAddress(lp, addr.Domain))
 	} else if dc.LocalpartCatchallSeparator != "" && strings.Contains(string(addr.Localpart), dc.LocalpartCatchallSeparator) {
 		return fmt.Errorf("localpart cannot include domain catchall separator %s", dc.LocalpartCatchallSeparator)
 	}
@@ -948,6 +965,134 @@ func AddressRemove(ctx context.Context, address string) (rerr error) {
 	return nil
 }
 
+// AliasAdd adds an alias to the domain of addr and reloads the configuration.
+func AliasAdd(ctx context.Context, addr smtp.Address, alias config.Alias) error {
+	return aliasSave(ctx, "adding alias", addr, func(aliases map[string]config.Alias) error {
+		if err := checkAddressAvailable(addr); err != nil {
+			return fmt.Errorf("address not available: %v", err)
+		}
+		aliases[string(addr.Localpart)] = alias
+		return nil
+	})
+}
+
+// AliasUpdate replaces an existing alias and reloads the configuration.
+func AliasUpdate(ctx context.Context, addr smtp.Address, alias config.Alias) error {
+	return aliasSave(ctx, "updating alias", addr, func(aliases map[string]config.Alias) error {
+		if _, ok := aliases[string(addr.Localpart)]; !ok {
+			return fmt.Errorf("alias does not exist")
+		}
+		aliases[string(addr.Localpart)] = alias
+		return nil
+	})
+}
+
+// AliasRemove removes an alias and reloads the configuration.
+func AliasRemove(ctx context.Context, addr smtp.Address) error {
+	return aliasSave(ctx, "removing alias", addr, func(aliases map[string]config.Alias) error {
+		if _, ok := aliases[string(addr.Localpart)]; !ok {
+			return fmt.Errorf("alias does not exist")
+		}
+		delete(aliases, string(addr.Localpart))
+		return nil
+	})
+}
+
+// aliasSave modifies the aliases of the domain of addr with fn, and writes and
+// reloads the configuration. Localparts of aliases are in canonical form.
+func aliasSave(ctx context.Context, action string, addr smtp.Address, fn func(aliases map[string]config.Alias) error) (rerr error) {
+	log := pkglog.WithContext(ctx)
+	defer func() {
+		if rerr != nil {
+			log.Errorx(action, rerr, slog.Any("address", addr))
+		}
+	}()
+
+	Conf.dynamicMutex.Lock()
+	defer Conf.dynamicMutex.Unlock()
+
+	c := Conf.Dynamic
+	dc, ok := c.Domains[addr.Domain.Name()]
+	if !ok {
+		return fmt.Errorf("domain does not exist")
+	}
+	if lp, err := CanonicalLocalpart(addr.Localpart, dc); err != nil {
+		return fmt.Errorf("canonicalizing localpart: %v", err)
+	} else if lp != addr.Localpart {
+		return fmt.Errorf("localpart must be in canonical form %q", lp)
+	}
+
+	// Compose new config without modifying existing data structures. If we fail, we
+	// leave no trace.
+	aliases := map[string]config.Alias{}
+	for lp, a := range dc.Aliases {
+		aliases[lp] = a
+	}
+	if err := fn(aliases); err != nil {
+		return err
+	}
+	if len(aliases) == 0 {
+		aliases = nil
+	}
+	dc.Aliases = aliases
+	nc := c
+	nc.Domains = map[string]config.Domain{}
+	for name, d := range c.Domains {
+		nc.Domains[name] = d
+	}
+	nc.Domains[addr.Domain.Name()] = dc
+
+	if err := writeDynamic(ctx, log, nc); err != nil {
+		return fmt.Errorf("writing domains.conf: %v", err)
+	}
+	log.Info("aliases saved", slog.String("action", action), slog.Any("address", addr))
+	return nil
+}
+
+// DomainAdminsSave sets the accounts that are domain administrators for the
+// domain, and reloads the configuration.
+func DomainAdminsSave(ctx context.Context, domain dns.Domain, accounts []string) (rerr error) {
+	log := pkglog.WithContext(ctx)
+	defer func() {
+		if rerr != nil {
+			log.Errorx("saving domain administrators", rerr, slog.Any("domain", domain), slog.Any("accounts", accounts))
+		}
+	}()
+
+	Conf.dynamicMutex.Lock()
+	defer Conf.dynamicMutex.Unlock()
+
+	c := Conf.Dynamic
+	dc, ok := c.Domains[domain.Name()]
+	if !ok {
+		return fmt.Errorf("domain does not exist")
+	}
+	for _, acc := range accounts {
+		if _, ok := c.Accounts[acc]; !ok {
+			return fmt.Errorf("account %q does not exist", acc)
+		}
+	}
+
+	// Compose new config without modifying existing data structures. If we fail, we
+	// leave no trace.
+	if len(accounts) == 0 {
+		accounts = nil
+	}
+	dc.Admins = accounts
+	nc := c
+	nc.Domains = map[string]config.Domain{}
+	for name, d := range c.Domains {
+		nc.Domains[name] = d
+	}
+	nc.Domains[domain.Name()] = dc
+
+	if err := writeDynamic(ctx, log, nc); err != nil {
+		return fmt.Errorf("writing domains.conf: %v", err)
+	}
+	log.Info("domain administrators saved", slog.Any("domain", domain), slog.Any("accounts", accounts))
+	return nil
+}
+
 // AccountFullNameSave updates the full name for an account and reloads the configuration.
 func AccountFullNameSave(ctx context.Context, account, fullName string) (rerr error) {
 	log := pkglog.WithContext(ctx)
@@ -29,6 +29,7 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/exp/slices"
 	"golang.org/x/exp/slog"
 	"golang.org/x/text/unicode/norm"
 
@@ -200,6 +201,32 @@ func (c *Config) DomainLocalparts(d dns.Domain) map[string]string {
 	return m
 }
 
+// AccountAddresses returns the addresses of an account, including catchall
+// addresses (starting with "@") and addresses for DMARC and TLS reports.
+func (c *Config) AccountAddresses(accountName string) (l []string) {
+	c.withDynamicLock(func() {
+		for addr, ad := range c.accountDestinations {
+			if ad.Account == accountName {
+				l = append(l, addr)
+			}
+		}
+	})
+	return
+}
+
+// AdminDomains returns the names of the domains the account is a domain
+// administrator for.
+func (c *Config) AdminDomains(accountName string) (l []string) {
+	c.withDynamicLock(func() {
+		for name, dom := range c.Dynamic.Domains {
+			if slices.Contains(dom.Admins, accountName) {
+				l = append(l, name)
+			}
+		}
+	})
+	return
+}
+
 func (c *Config) Domain(d dns.Domain) (dom config.Domain, ok bool) {
 	c.withDynamicLock(func() {
 		dom, ok = c.Dynamic.Domains[d.Name()]
@@ -768,6 +795,7 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			needtls("AdminHTTPS", l.AdminHTTPS.Enabled)
 			needtls("AutoconfigHTTPS", l.AutoconfigHTTPS.Enabled && !l.AutoconfigHTTPS.NonTLS)
 			needtls("MTASTSHTTPS", l.MTASTSHTTPS.Enabled && !l.MTASTSHTTPS.NonTLS)
+			needtls("MailingListHTTPS", l.MailingListHTTPS.Enabled && !l.MailingListHTTPS.NonTLS)
 			needtls("WebserverHTTPS", l.WebserverHTTPS.Enabled)
 			if len(needsTLS) > 0 {
 				addErrorf("listener %q does not specify tls config, but requires tls for %s", name, strings.Join(needsTLS, ", "))
@@ -1205,6 +1233,30 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 			}
 		}
 
+		if sm := domain.SubaddressMailboxes; sm != nil {
+			if domain.LocalpartCatchallSeparator == "" {
+				addErrorf("domain %q: SubaddressMailboxes requires LocalpartCatchallSeparator", d)
+			}
+			checkMailboxNormf(sm.Parent, "domain %q, subaddress mailboxes parent", d)
+			if strings.EqualFold(sm.Parent, "inbox") {
+				addErrorf("domain %q: SubaddressMailboxes parent cannot be Inbox", d)
+			}
+			if sm.MaxMailboxes < 0 {
+				addErrorf("domain %q: SubaddressMailboxes MaxMailboxes cannot be negative", d)
+			}
+			if len(sm.AllowRegexp) == 0 {
+				addErrorf("domain %q: SubaddressMailboxes requires at least one AllowRegexp, e.g. \".\" to allow all subaddresses", d)
+			}
+			sm.AllowRegexpCompiled = nil
+			for _, s := range sm.AllowRegexp {
+				r, err := regexp.Compile(s)
+				if err != nil {
+					addErrorf("domain %q: invalid SubaddressMailboxes AllowRegexp %q: %v", d, s, err)
+				}
+				sm.AllowRegexpCompiled = append(sm.AllowRegexpCompiled, r)
+			}
+		}
+
 		checkRoutes("routes for domain", domain.Routes)
 
 		c.Domains[d] = domain
@@ -1223,6 +1275,24 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		}
 		checkMailboxNormf(acc.RejectsMailbox, "account %q", accName)
 
+		specialUses := map[string]bool{"": true, "archive": true, "drafts": true, "junk": true, "sent": true, "trash": true}
+		for i, rule := range acc.RetentionRules {
+			checkMailboxNormf(rule.Mailbox, "account %q, retention rule %d", accName, i+1)
+			checkMailboxNormf(rule.MoveToMailbox, "account %q, retention rule %d, destination", accName, i+1)
+			if (rule.Mailbox == "") == (rule.SpecialUse == "") {
+				addErrorf("account %q, retention rule %d: exactly one of Mailbox and SpecialUse must be set", accName, i+1)
+			}
+			if rule.MoveToMailbox != "" && rule.MoveToSpecialUse != "" {
+				addErrorf("account %q, retention rule %d: at most one of MoveToMailbox and MoveToSpecialUse can be set", accName, i+1)
+			}
+			if !specialUses[strings.ToLower(rule.SpecialUse)] || !specialUses[strings.ToLower(rule.MoveToSpecialUse)] {
+				addErrorf("account %q, retention rule %d: unknown special-use, must be one of Archive, Drafts, Junk, Sent, Trash", accName, i+1)
+			}
+			if rule.Age <= 0 {
+				addErrorf("account %q, retention rule %d: age must be positive", accName, i+1)
+			}
+		}
+
 		if acc.AutomaticJunkFlags.JunkMailboxRegexp != "" {
 			r, err := regexp.Compile(acc.AutomaticJunkFlags.JunkMailboxRegexp)
 			if err != nil {
@@ -1265,6 +1335,14 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 					}
 					c.Accounts[accName].Destinations[addrName].Rulesets[i].SMTPMailFromRegexpCompiled = r
 				}
+				if rs.SubaddressRegexp != "" {
+					n++
+					r, err := regexp.Compile(rs.SubaddressRegexp)
+					if err != nil {
+						addErrorf("invalid SubaddressRegexp regular expression: %v", err)
+					}
+					c.Accounts[accName].Destinations[addrName].Rulesets[i].SubaddressRegexpCompiled = r
+				}
 				if rs.VerifiedDomain != "" {
 					n++
 					d, err := dns.ParseDomain(rs.VerifiedDomain)
@@ -1321,6 +1399,17 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 				}
 			}
 
+			for _, fwd := range dest.ForwardTo {
+				if _, err := smtp.ParseAddress(fwd); err != nil {
+					addErrorf("account %q, destination %q: invalid ForwardTo address %q: %v", accName, addrName, fwd, err)
+				} else if strings.EqualFold(fwd, addrName) {
+					addErrorf("account %q, destination %q: cannot forward to itself", accName, addrName)
+				}
+			}
+			if dest.ForwardKeepCopy && len(dest.ForwardTo) == 0 {
+				addErrorf("account %q, destination %q: ForwardKeepCopy requires ForwardTo", accName, addrName)
+			}
+
 			// Catchall destination for domain.
 			if strings.HasPrefix(addrName, "@") {
 				d, err := dns.ParseDomain(addrName[1:])
@@ -1475,6 +1564,152 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		accDests[addrFull] = AccountDestination{false, lp, tlsrpt.Account, dest}
 	}
 
+	// Check aliases. Must be done after all account destinations are known.
+	for d, domain := range c.Domains {
+		if len(domain.Aliases) == 0 {
+			continue
+		}
+		// New map, the existing map may be in use by the current config.
+		aliases := map[string]config.Alias{}
+		for lpstr, a := range domain.Aliases {
+			lp, err := smtp.ParseLocalpart(lpstr)
+			if err != nil {
+				addErrorf("domain %s: parsing alias localpart %q: %v", d, lpstr, err)
+				continue
+			} else if clp, err := CanonicalLocalpart(lp, domain); err != nil || clp != lp || domain.LocalpartCatchallSeparator != "" && strings.Contains(lpstr, domain.LocalpartCatchallSeparator) {
+				addErrorf("domain %s: alias localpart %q must be in canonical form (lower-case if not case sensitive, without catchall separator)", d, lpstr)
+				continue
+			}
+			aliasAddr := smtp.NewAddress(lp, domain.Domain)
+			if _, ok := accDests[aliasAddr.String()]; ok {
+				addErrorf("alias %s is also an account address", aliasAddr)
+			}
+			if len(a.Addresses) == 0 {
+				addErrorf("alias %s: must have at least one address", aliasAddr)
+			}
+
+			a.ParsedAddresses = nil
+			var haveLocal bool
+			seen := map[string]bool{}
+			for _, s := range a.Addresses {
+				addr, err := smtp.ParseAddress(s)
+				if err != nil {
+					addErrorf("alias %s: parsing address %q: %v", aliasAddr, s, err)
+					continue
+				}
+				aa := config.AliasAddress{Address: addr}
+				if dc, ok := c.Domains[addr.Domain.Name()]; ok {
+					// Local addresses must be account addresses, aliases cannot be nested.
+					mlp, err := CanonicalLocalpart(addr.Localpart, dc)
+					if err != nil {
+						addErrorf("alias %s: canonicalizing address %s: %v", aliasAddr, addr, err)
+						continue
+					}
+					addr = smtp.NewAddress(mlp, addr.Domain)
+					ad, ok := accDests[addr.String()]
+					if !ok {
+						addErrorf("alias %s: local address %s is not an account address", aliasAddr, addr)
+						continue
+					}
+					aa = config.AliasAddress{Address: addr, AccountName: ad.Account, Destination: ad.Destination}
+					haveLocal = true
+				}
+				if seen[addr.String()] {
+					addErrorf("alias %s: duplicate address %s", aliasAddr, addr)
+					continue
+				}
+				seen[addr.String()] = true
+				a.ParsedAddresses = append(a.ParsedAddresses, aa)
+			}
+			if !haveLocal && len(a.ParsedAddresses) > 0 {
+				addErrorf("alias %s: at least one local address required, for spam filtering of messages forwarded to external addresses", aliasAddr)
+			}
+			for _, s := range a.AllowMsgFrom {
+				if _, err := smtp.ParseAddress(s); err != nil {
+					addErrorf("alias %s: parsing allowed message from address %q: %v", aliasAddr, s, err)
+				}
+			}
+			aliases[lpstr] = a
+		}
+		domain.Aliases = aliases
+		c.Domains[d] = domain
+	}
+
+	// Check mailing lists. The list address and its command addresses must not be
+	// account or alias addresses.
+	for d, domain := range c.Domains {
+		if len(domain.MailingLists) == 0 {
+			continue
+		}
+		lists := map[string]config.MailingList{}
+		for lpstr, ml := range domain.MailingLists {
+			lp, err := smtp.ParseLocalpart(lpstr)
+			if err != nil {
+				addErrorf("domain %s: parsing mailing list localpart %q: %v", d, lpstr, err)
+				continue
+			} else if clp, err := CanonicalLocalpart(lp, domain); err != nil || clp != lp || domain.LocalpartCatchallSeparator != "" && strings.Contains(lpstr, domain.LocalpartCatchallSeparator) {
+				addErrorf("domain %s: mailing list localpart %q must be in canonical form (lower-case if not case sensitive, without catchall separator)", d, lpstr)
+				continue
+			}
+			listAddr := smtp.NewAddress(lp, domain.Domain)
+			for _, suffix := range []string{"", "-subscribe", "-unsubscribe", "-owner"} {
+				addr := smtp.NewAddress(lp+smtp.Localpart(suffix), domain.Domain)
+				if _, ok := accDests[addr.String()]; ok {
+					addErrorf("mailing list %s: address %s is also an account address", listAddr, addr)
+				} else if _, ok := domain.Aliases[string(lp)+suffix]; ok {
+					addErrorf("mailing list %s: address %s is also an alias", listAddr, addr)
+				}
+			}
+			switch ml.PostPolicy {
+			case "", "subscribers", "owners", "anyone":
+			default:
+				addErrorf("mailing list %s: unknown post policy %q, must be subscribers, owners or anyone", listAddr, ml.PostPolicy)
+			}
+			if len(ml.Owners) == 0 {
+				addErrorf("mailing list %s: must have at least one owner", listAddr)
+			}
+			ml.ParsedOwners = nil
+			ml.OwnerAccount = ""
+			for _, s := range ml.Owners {
+				addr, err := smtp.ParseAddress(s)
+				if err != nil {
+					addErrorf("mailing list %s: parsing owner address %q: %v", listAddr, s, err)
+					continue
+				} else if addr == listAddr {
+					addErrorf("mailing list %s: list cannot be its own owner", listAddr)
+					continue
+				}
+				ml.ParsedOwners = append(ml.ParsedOwners, addr)
+				if dc, ok := c.Domains[addr.Domain.Name()]; ok && ml.OwnerAccount == "" {
+					if mlp, err := CanonicalLocalpart(addr.Localpart, dc); err == nil {
+						if ad, ok := accDests[smtp.NewAddress(mlp, addr.Domain).String()]; ok {
+							ml.OwnerAccount = ad.Account
+						}
+					}
+				}
+			}
+			if ml.OwnerAccount == "" && len(ml.ParsedOwners) > 0 {
+				addErrorf("mailing list %s: at least one owner must be a local account address, for spam filtering of posts", listAddr)
+			}
+			lists[lpstr] = ml
+		}
+		domain.MailingLists = lists
+		c.Domains[d] = domain
+	}
+
+	// Check domain administrators.
+	for d, domain := range c.Domains {
+		seen := map[string]bool{}
+		for _, accName := range domain.Admins {
+			if _, ok := c.Accounts[accName]; !ok {
+				addErrorf("domain %s: administrator account %q does not exist", d, accName)
+			} else if seen[accName] {
+				addErrorf("domain %s: duplicate administrator account %q", d, accName)
+			}
+			seen[accName] = true
+		}
+	}
+
 	// Check webserver configs.
 	if (len(c.WebDomainRedirects) > 0 || len(c.WebHandlers) > 0) && !haveWebserverListener {
 		addErrorf("WebDomainRedirects or WebHandlers configured but no listener with WebserverHTTP or WebserverHTTPS enabled")
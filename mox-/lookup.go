@@ -77,6 +77,24 @@ func FindAccount(localpart smtp.Localpart, domain dns.Domain, allowPostmaster bo
 	return accAddr.Account, canonical, accAddr.Destination, nil
 }
 
+// FindAlias looks up the alias for localpart and domain. The localpart is
+// canonicalized like for FindAccount.
+func FindAlias(localpart smtp.Localpart, domain dns.Domain) (alias config.Alias, canonicalAddress string, ok bool) {
+	d, ok := Conf.Domain(domain)
+	if !ok || len(d.Aliases) == 0 {
+		return config.Alias{}, "", false
+	}
+	lp, err := CanonicalLocalpart(localpart, d)
+	if err != nil {
+		return config.Alias{}, "", false
+	}
+	alias, ok = d.Aliases[string(lp)]
+	if !ok {
+		return config.Alias{}, "", false
+	}
+	return alias, smtp.NewAddress(lp, domain).String(), true
+}
+
 // CanonicalLocalpart returns the canonical localpart, removing optional catchall
 // separator, and optionally lower-casing the string.
 func CanonicalLocalpart(localpart smtp.Localpart, d config.Domain) (smtp.Localpart, error) {
@@ -93,3 +111,18 @@ func CanonicalLocalpart(localpart smtp.Localpart, d config.Domain) (smtp.Localpa
 	}
 	return localpart, nil
 }
+
+// LocalpartSubaddress returns the subaddress of localpart, the part after the
+// catchall separator of the domain, converted to lower case. An empty string is
+// returned if the domain has no catchall separator or localpart has no
+// subaddress.
+func LocalpartSubaddress(localpart smtp.Localpart, d config.Domain) string {
+	if d.LocalpartCatchallSeparator == "" {
+		return ""
+	}
+	t := strings.SplitN(string(localpart), d.LocalpartCatchallSeparator, 2)
+	if len(t) != 2 {
+		return ""
+	}
+	return strings.ToLower(t[1])
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
@@ -12,6 +14,11 @@ import (
 var idCipher cipher.Block
 var idRand []byte
 
+// SRSKey is the key for signing rewritten envelope senders of forwarded
+// messages, see package srs. It is derived from the receivedid key, so it is
+// persistent and backed up along with it.
+var SRSKey []byte
+
 func init() {
 	// Init for tests. Overwritten in ../serve.go.
 	err := ReceivedIDInit([]byte("0123456701234567"), []byte("01234567"))
@@ -21,11 +28,14 @@ func init() {
 }
 
 // ReceivedIDInit sets an AES key (must be 16 bytes) and random buffer (must be
-// 8 bytes) for use by ReceivedID.
+// 8 bytes) for use by ReceivedID. It also initializes SRSKey.
 func ReceivedIDInit(key, rand []byte) error {
 	var err error
 	idCipher, err = aes.NewCipher(key)
 	idRand = rand
+	mac := hmac.New(sha256.New, append(append([]byte{}, key...), rand...))
+	mac.Write([]byte("srs"))
+	SRSKey = mac.Sum(nil)
 	return err
 }
 
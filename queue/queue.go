@@ -250,6 +250,9 @@ func Add(ctx context.Context, log mlog.Log, qm *Msg, msgFile *os.File) error {
 		m := store.Message{Size: qm.Size, MsgPrefix: qm.MsgPrefix}
 		conf, _ := acc.Conf()
 		dest := conf.Destinations[qm.Sender().String()]
+		if err := acc.PrepareAttachmentText(log, &m, msgFile); err != nil {
+			return fmt.Errorf("preparing message for immediate delivery with localserve: %v", err)
+		}
 		acc.WithWLock(func() {
 			err = acc.DeliverDestination(log, dest, &m, msgFile)
 		})
@@ -633,6 +633,7 @@ many authentication failures).
 	public.SMTP.Enabled = true
 	public.Submissions.Enabled = true
 	public.IMAPS.Enabled = true
+	public.ManageSieve.Enabled = true
 
 	if existingWebserver {
 		hostbase := filepath.FromSlash("path/to/" + dnshostname.Name())
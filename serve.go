@@ -9,6 +9,8 @@ import (
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/http"
 	"github.com/mjl-/mox/imapserver"
+	"github.com/mjl-/mox/managesieve"
+	"github.com/mjl-/mox/mlist"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/mtastsdb"
@@ -56,6 +58,7 @@ func shutdown(log mlog.Log) {
 func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec bool) error {
 	smtpserver.Listen()
 	imapserver.Listen()
+	managesieve.Listen()
 	http.Listen()
 
 	if !skipForkExec {
@@ -78,6 +81,10 @@ func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec boo
 		return fmt.Errorf("tlsrpt init: %s", err)
 	}
 
+	if err := mlist.Init(); err != nil {
+		return fmt.Errorf("mlist init: %s", err)
+	}
+
 	done := make(chan struct{}, 1)
 	if err := queue.Start(dns.StrictResolver{Pkg: "queue"}, done); err != nil {
 		return fmt.Errorf("queue start: %s", err)
@@ -96,8 +103,10 @@ func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec boo
 	}
 
 	store.StartAuthCache()
+	store.StartRetention()
 	smtpserver.Serve()
 	imapserver.Serve()
+	managesieve.Serve()
 	http.Serve()
 
 	go func() {
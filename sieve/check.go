@@ -0,0 +1,672 @@
+package sieve
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mjl-/mox/smtp"
+)
+
+// Script is a parsed and checked sieve script, ready for evaluation.
+type Script struct {
+	commands []command
+}
+
+// Commands, after checking.
+
+type command interface {
+	isCommand()
+}
+
+type cmdIf struct {
+	tests     []test      // One for the "if", and one for each "elsif".
+	blocks    [][]command // Same length as tests.
+	elseBlock []command
+	hasElse   bool
+}
+
+type cmdStop struct{}
+
+type cmdKeep struct {
+	flags *[]string // Explicit :flags, otherwise the internal flags variable.
+}
+
+type cmdDiscard struct{}
+
+type cmdFileinto struct {
+	mailbox string
+	copy    bool
+	flags   *[]string
+}
+
+type cmdRedirect struct {
+	address smtp.Address
+	copy    bool
+}
+
+type cmdReject struct {
+	reason string
+	smtp   bool // For ereject.
+}
+
+type cmdVacation struct {
+	Vacation
+}
+
+type cmdFlags struct {
+	op    string // "setflag", "addflag", "removeflag".
+	flags []string
+}
+
+func (cmdIf) isCommand()       {}
+func (cmdStop) isCommand()     {}
+func (cmdKeep) isCommand()     {}
+func (cmdDiscard) isCommand()  {}
+func (cmdFileinto) isCommand() {}
+func (cmdRedirect) isCommand() {}
+func (cmdReject) isCommand()   {}
+func (cmdVacation) isCommand() {}
+func (cmdFlags) isCommand()    {}
+
+// Tests, after checking.
+
+type test interface {
+	isTest()
+}
+
+type matcher struct {
+	comparator string // "i;octet" or "i;ascii-casemap".
+	matchType  string // "is", "contains", "matches".
+}
+
+type testAddress struct {
+	matcher
+	envelope bool   // Whether this is an envelope test.
+	part     string // "all", "localpart", "domain".
+	headers  []string
+	keys     []string
+}
+
+type testHeader struct {
+	matcher
+	headers []string
+	keys    []string
+}
+
+type testExists struct {
+	headers []string
+}
+
+type testSize struct {
+	over  bool
+	limit int64
+}
+
+type testBody struct {
+	matcher
+	transform    string   // "raw", "content", "text".
+	contentTypes []string // For transform "content".
+	keys         []string
+}
+
+type testHasflag struct {
+	matcher
+	flags []string
+}
+
+type testAllof struct {
+	tests []test
+}
+
+type testAnyof struct {
+	tests []test
+}
+
+type testNot struct {
+	test test
+}
+
+type testBool bool
+
+func (testAddress) isTest() {}
+func (testHeader) isTest()  {}
+func (testExists) isTest()  {}
+func (testSize) isTest()    {}
+func (testBody) isTest()    {}
+func (testHasflag) isTest() {}
+func (testAllof) isTest()   {}
+func (testAnyof) isTest()   {}
+func (testNot) isTest()     {}
+func (testBool) isTest()    {}
+
+type checker struct {
+	require map[string]bool
+	first   bool // Whether no command other than require has been seen.
+}
+
+func (c *checker) errorf(n node, format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s: %s", ErrSyntax, n.line, n.name, fmt.Sprintf(format, args...))
+}
+
+func (c *checker) need(n node, ext string) error {
+	if !c.require[ext] {
+		return c.errorf(n, "missing require for extension %q", ext)
+	}
+	return nil
+}
+
+type argKind byte
+
+const (
+	argNone argKind = iota // For tags without value.
+	argNumber
+	argString
+	argStringList // Single string or string list.
+)
+
+// args parses the arguments of n. Tags with their kind of value are in tags.
+// Positional arguments must match kinds in positional.
+func (c *checker) args(n node, tags map[string]argKind, positional ...argKind) (map[string]argument, []argument, error) {
+	tagged := map[string]argument{}
+	var l []argument
+	for i := 0; i < len(n.args); i++ {
+		a := n.args[i]
+		if a.tag == "" {
+			l = append(l, a)
+			continue
+		}
+		if len(l) > 0 {
+			return nil, nil, c.errorf(n, "tag :%s after positional arguments", a.tag)
+		}
+		kind, ok := tags[a.tag]
+		if !ok {
+			return nil, nil, c.errorf(n, "unknown tag :%s", a.tag)
+		}
+		if _, ok := tagged[a.tag]; ok {
+			return nil, nil, c.errorf(n, "duplicate tag :%s", a.tag)
+		}
+		if kind != argNone {
+			i++
+			if i >= len(n.args) || !argMatches(n.args[i], kind) {
+				return nil, nil, c.errorf(n, "missing or bad value for tag :%s", a.tag)
+			}
+			a = n.args[i]
+			a.tag = n.args[i-1].tag
+		}
+		tagged[a.tag] = a
+	}
+	if len(l) != len(positional) {
+		return nil, nil, c.errorf(n, "got %d positional arguments, expected %d", len(l), len(positional))
+	}
+	for i, a := range l {
+		if !argMatches(a, positional[i]) {
+			return nil, nil, c.errorf(n, "bad type for argument %d", i+1)
+		}
+	}
+	return tagged, l, nil
+}
+
+func argMatches(a argument, kind argKind) bool {
+	switch kind {
+	case argNumber:
+		return a.tag == "" && a.number != nil
+	case argString:
+		return a.tag == "" && a.number == nil && !a.list && len(a.strings) == 1
+	case argStringList:
+		return a.tag == "" && a.number == nil && len(a.strings) > 0
+	}
+	return false
+}
+
+func (c *checker) noTests(n node) error {
+	if len(n.tests) > 0 {
+		return c.errorf(n, "unexpected test")
+	}
+	return nil
+}
+
+func (c *checker) commands(nodes []node, top bool) ([]command, error) {
+	var l []command
+	for _, n := range nodes {
+		if n.name != "require" {
+			c.first = false
+		}
+		if n.name == "elsif" || n.name == "else" {
+			var prev *cmdIf
+			if len(l) > 0 {
+				prev, _ = l[len(l)-1].(*cmdIf)
+			}
+			if prev == nil || prev.hasElse {
+				return nil, c.errorf(n, "without preceding if")
+			}
+			if err := c.elseCommand(n, prev); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if n.name == "require" && (!top || !c.first) {
+			// ../rfc/5228:1145
+			return nil, c.errorf(n, "must be at start of script")
+		}
+		if n.hasBlock && n.name != "if" {
+			return nil, c.errorf(n, "unexpected block")
+		}
+		cmd, err := c.command(n)
+		if err != nil {
+			return nil, err
+		}
+		if cmd != nil {
+			l = append(l, cmd)
+		}
+	}
+	return l, nil
+}
+
+func (c *checker) elseCommand(n node, prev *cmdIf) error {
+	if !n.hasBlock {
+		return c.errorf(n, "missing block")
+	}
+	block, err := c.commands(n.block, false)
+	if err != nil {
+		return err
+	}
+	if n.name == "else" {
+		if len(n.args) > 0 || len(n.tests) > 0 {
+			return c.errorf(n, "unexpected arguments")
+		}
+		prev.elseBlock = block
+		prev.hasElse = true
+		return nil
+	}
+	t, err := c.singleTest(n)
+	if err != nil {
+		return err
+	}
+	prev.tests = append(prev.tests, t)
+	prev.blocks = append(prev.blocks, block)
+	return nil
+}
+
+func (c *checker) singleTest(n node) (test, error) {
+	if len(n.args) > 0 || len(n.tests) != 1 {
+		return nil, c.errorf(n, "expected single test")
+	}
+	return c.test(n.tests[0])
+}
+
+// flagList splits the flags in the strings, which can each hold multiple space-separated flags.
+func flagList(l []string) []string {
+	var r []string
+	for _, s := range l {
+		r = append(r, strings.Fields(s)...)
+	}
+	return r
+}
+
+func (c *checker) command(n node) (command, error) {
+	flagsArg := func(tagged map[string]argument) (*[]string, error) {
+		a, ok := tagged["flags"]
+		if !ok {
+			return nil, nil
+		}
+		if err := c.need(n, "imap4flags"); err != nil {
+			return nil, err
+		}
+		l := flagList(a.strings)
+		return &l, nil
+	}
+	copyArg := func(tagged map[string]argument) (bool, error) {
+		if _, ok := tagged["copy"]; !ok {
+			return false, nil
+		}
+		return true, c.need(n, "copy")
+	}
+
+	if n.name != "if" {
+		if err := c.noTests(n); err != nil {
+			return nil, err
+		}
+	}
+
+	switch n.name {
+	case "require":
+		_, l, err := c.args(n, nil, argStringList)
+		if err != nil {
+			return nil, err
+		}
+		for _, ext := range l[0].strings {
+			ext = strings.ToLower(ext)
+			known := false
+			for _, x := range Extensions {
+				known = known || x == ext
+			}
+			if !known {
+				return nil, c.errorf(n, "unsupported extension %q", ext)
+			}
+			c.require[ext] = true
+		}
+		return nil, nil
+
+	case "if":
+		if !n.hasBlock {
+			return nil, c.errorf(n, "missing block")
+		}
+		t, err := c.singleTest(n)
+		if err != nil {
+			return nil, err
+		}
+		block, err := c.commands(n.block, false)
+		if err != nil {
+			return nil, err
+		}
+		return &cmdIf{tests: []test{t}, blocks: [][]command{block}}, nil
+
+	case "stop", "discard":
+		if _, _, err := c.args(n, nil); err != nil {
+			return nil, err
+		}
+		if n.name == "stop" {
+			return cmdStop{}, nil
+		}
+		return cmdDiscard{}, nil
+
+	case "keep":
+		tagged, _, err := c.args(n, map[string]argKind{"flags": argStringList})
+		if err != nil {
+			return nil, err
+		}
+		flags, err := flagsArg(tagged)
+		if err != nil {
+			return nil, err
+		}
+		return cmdKeep{flags}, nil
+
+	case "fileinto":
+		if err := c.need(n, "fileinto"); err != nil {
+			return nil, err
+		}
+		tagged, l, err := c.args(n, map[string]argKind{"flags": argStringList, "copy": argNone}, argString)
+		if err != nil {
+			return nil, err
+		}
+		flags, err := flagsArg(tagged)
+		if err != nil {
+			return nil, err
+		}
+		copy, err := copyArg(tagged)
+		if err != nil {
+			return nil, err
+		}
+		return cmdFileinto{l[0].strings[0], copy, flags}, nil
+
+	case "redirect":
+		tagged, l, err := c.args(n, map[string]argKind{"copy": argNone}, argString)
+		if err != nil {
+			return nil, err
+		}
+		copy, err := copyArg(tagged)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := smtp.ParseAddress(l[0].strings[0])
+		if err != nil {
+			return nil, c.errorf(n, "bad address: %v", err)
+		}
+		return cmdRedirect{addr, copy}, nil
+
+	case "reject", "ereject":
+		if err := c.need(n, n.name); err != nil {
+			return nil, err
+		}
+		_, l, err := c.args(n, nil, argString)
+		if err != nil {
+			return nil, err
+		}
+		return cmdReject{l[0].strings[0], n.name == "ereject"}, nil
+
+	case "vacation":
+		if err := c.need(n, "vacation"); err != nil {
+			return nil, err
+		}
+		tagged, l, err := c.args(n, map[string]argKind{
+			"days":      argNumber,
+			"subject":   argString,
+			"from":      argString,
+			"addresses": argStringList,
+			"mime":      argNone,
+			"handle":    argString,
+		}, argString)
+		if err != nil {
+			return nil, err
+		}
+		v := Vacation{Days: 7, Reason: l[0].strings[0]}
+		// ../rfc/5230:228
+		if a, ok := tagged["days"]; ok {
+			v.Days = int(*a.number)
+			if v.Days < 1 {
+				v.Days = 1
+			} else if v.Days > 90 {
+				v.Days = 90
+			}
+		}
+		if a, ok := tagged["subject"]; ok {
+			v.Subject = a.strings[0]
+		}
+		if a, ok := tagged["from"]; ok {
+			v.From = a.strings[0]
+			if _, err := smtp.ParseAddress(v.From); err != nil {
+				return nil, c.errorf(n, "bad from address: %v", err)
+			}
+		}
+		if a, ok := tagged["addresses"]; ok {
+			v.Addresses = a.strings
+		}
+		_, v.Mime = tagged["mime"]
+		if a, ok := tagged["handle"]; ok {
+			v.Handle = a.strings[0]
+		} else {
+			// ../rfc/5230:259
+			v.Handle = v.Subject + "\x00" + v.From + "\x00" + v.Reason
+		}
+		return cmdVacation{v}, nil
+
+	case "setflag", "addflag", "removeflag":
+		if err := c.need(n, "imap4flags"); err != nil {
+			return nil, err
+		}
+		_, l, err := c.args(n, nil, argStringList)
+		if err != nil {
+			return nil, err
+		}
+		return cmdFlags{n.name, flagList(l[0].strings)}, nil
+	}
+	return nil, c.errorf(n, "unknown command")
+}
+
+// matchArgs are the tags for comparator and match type, added to the tags for a test.
+func matchArgs(tags map[string]argKind) map[string]argKind {
+	tags["comparator"] = argString
+	tags["is"] = argNone
+	tags["contains"] = argNone
+	tags["matches"] = argNone
+	return tags
+}
+
+func (c *checker) matcher(n node, tagged map[string]argument) (matcher, error) {
+	m := matcher{comparator: "i;ascii-casemap", matchType: "is"}
+	if a, ok := tagged["comparator"]; ok {
+		m.comparator = strings.ToLower(a.strings[0])
+		switch m.comparator {
+		case "i;octet", "i;ascii-casemap":
+		default:
+			return m, c.errorf(n, "unsupported comparator %q", m.comparator)
+		}
+	}
+	var n0 int
+	for _, mt := range []string{"is", "contains", "matches"} {
+		if _, ok := tagged[mt]; ok {
+			m.matchType = mt
+			n0++
+		}
+	}
+	if n0 > 1 {
+		return m, c.errorf(n, "multiple match types")
+	}
+	return m, nil
+}
+
+func (c *checker) testList(n node) ([]test, error) {
+	if len(n.args) > 0 || len(n.tests) == 0 {
+		return nil, c.errorf(n, "expected test list")
+	}
+	var l []test
+	for _, tn := range n.tests {
+		t, err := c.test(tn)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, t)
+	}
+	return l, nil
+}
+
+func (c *checker) test(n node) (test, error) {
+	switch n.name {
+	case "allof", "anyof":
+		l, err := c.testList(n)
+		if err != nil {
+			return nil, err
+		}
+		if n.name == "allof" {
+			return testAllof{l}, nil
+		}
+		return testAnyof{l}, nil
+	case "not":
+		t, err := c.singleTest(n)
+		if err != nil {
+			return nil, err
+		}
+		return testNot{t}, nil
+	}
+
+	if err := c.noTests(n); err != nil {
+		return nil, err
+	}
+
+	switch n.name {
+	case "true", "false":
+		if _, _, err := c.args(n, nil); err != nil {
+			return nil, err
+		}
+		return testBool(n.name == "true"), nil
+
+	case "address", "envelope":
+		if n.name == "envelope" {
+			if err := c.need(n, "envelope"); err != nil {
+				return nil, err
+			}
+		}
+		tags := matchArgs(map[string]argKind{"all": argNone, "localpart": argNone, "domain": argNone})
+		tagged, l, err := c.args(n, tags, argStringList, argStringList)
+		if err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(n, tagged)
+		if err != nil {
+			return nil, err
+		}
+		t := testAddress{matcher: m, envelope: n.name == "envelope", part: "all", headers: l[0].strings, keys: l[1].strings}
+		var nparts int
+		for _, p := range []string{"all", "localpart", "domain"} {
+			if _, ok := tagged[p]; ok {
+				t.part = p
+				nparts++
+			}
+		}
+		if nparts > 1 {
+			return nil, c.errorf(n, "multiple address parts")
+		}
+		if t.envelope {
+			// ../rfc/5228:1020
+			for i, h := range t.headers {
+				t.headers[i] = strings.ToLower(h)
+				if t.headers[i] != "from" && t.headers[i] != "to" {
+					return nil, c.errorf(n, "unsupported envelope part %q", h)
+				}
+			}
+		}
+		return t, nil
+
+	case "header":
+		tagged, l, err := c.args(n, matchArgs(map[string]argKind{}), argStringList, argStringList)
+		if err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(n, tagged)
+		if err != nil {
+			return nil, err
+		}
+		return testHeader{m, l[0].strings, l[1].strings}, nil
+
+	case "exists":
+		_, l, err := c.args(n, nil, argStringList)
+		if err != nil {
+			return nil, err
+		}
+		return testExists{l[0].strings}, nil
+
+	case "size":
+		tagged, l, err := c.args(n, map[string]argKind{"over": argNone, "under": argNone}, argNumber)
+		if err != nil {
+			return nil, err
+		}
+		_, over := tagged["over"]
+		_, under := tagged["under"]
+		if over == under {
+			return nil, c.errorf(n, "need exactly one of :over and :under")
+		}
+		return testSize{over, *l[0].number}, nil
+
+	case "body":
+		if err := c.need(n, "body"); err != nil {
+			return nil, err
+		}
+		tags := matchArgs(map[string]argKind{"raw": argNone, "content": argStringList, "text": argNone})
+		tagged, l, err := c.args(n, tags, argStringList)
+		if err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(n, tagged)
+		if err != nil {
+			return nil, err
+		}
+		t := testBody{matcher: m, transform: "text", keys: l[0].strings}
+		var ntransforms int
+		for _, tr := range []string{"raw", "content", "text"} {
+			if a, ok := tagged[tr]; ok {
+				t.transform = tr
+				t.contentTypes = a.strings
+				ntransforms++
+			}
+		}
+		if ntransforms > 1 {
+			return nil, c.errorf(n, "multiple body transforms")
+		}
+		return t, nil
+
+	case "hasflag":
+		if err := c.need(n, "imap4flags"); err != nil {
+			return nil, err
+		}
+		tagged, l, err := c.args(n, matchArgs(map[string]argKind{}), argStringList)
+		if err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(n, tagged)
+		if err != nil {
+			return nil, err
+		}
+		return testHasflag{m, flagList(l[0].strings)}, nil
+	}
+	return nil, c.errorf(n, "unknown test")
+}
@@ -0,0 +1,499 @@
+package sieve
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/smtp"
+)
+
+// ErrRuntime is returned for errors during evaluation, e.g. for incompatible
+// actions.
+var ErrRuntime = errors.New("runtime error")
+
+// Maximum number of bytes of a body part that are matched in body tests.
+const maxBodyMatch = 1024 * 1024
+
+// Message is a message and its envelope, as evaluated by a script.
+type Message struct {
+	EnvelopeFrom string // SMTP MAIL FROM address, empty for the null reverse path.
+	EnvelopeTo   string // SMTP RCPT TO address.
+	Size         int64
+	Part         *message.Part // Parsed message, with subparts for body tests.
+}
+
+// Vacation is an auto-response requested by a script, RFC 5230. The caller
+// decides whether a response is sent, e.g. not to mailing lists, and tracks
+// earlier responses to the same sender.
+type Vacation struct {
+	Days      int      // Minimum number of days between responses to the same sender.
+	Subject   string   // If empty, a subject based on the original subject should be used.
+	From      string   // If empty, the recipient address is used.
+	Addresses []string // Additional addresses of the recipient, for checking the message is addressed to the recipient.
+	Mime      bool     // Whether Reason is a MIME entity including headers.
+	Handle    string   // For tracking responses sent per sender.
+	Reason    string
+}
+
+// Fileinto is a delivery to a mailbox.
+type Fileinto struct {
+	Mailbox string
+	Flags   []string
+}
+
+// Result holds the actions requested by a script.
+type Result struct {
+	Keep         bool     // Whether to deliver to the default mailbox, explicit or implicit.
+	KeepFlags    []string // Flags for delivery to the default mailbox.
+	Fileinto     []Fileinto
+	Redirects    []smtp.Address
+	Reject       bool // Whether to reject the message, with RejectReason.
+	RejectReason string
+	Vacation     *Vacation
+}
+
+type evaluator struct {
+	msg          Message
+	header       textproto.MIMEHeader
+	flags        []string // Internal variable for imap4flags.
+	implicitKeep bool
+	result       Result
+}
+
+// Evaluate executes the script for the message and returns the actions to take.
+// On error, the result only has the implicit keep, as required by RFC 5228.
+func (s *Script) Evaluate(msg Message) (Result, error) {
+	e := &evaluator{msg: msg, implicitKeep: true}
+	if msg.Part != nil {
+		h, err := msg.Part.Header()
+		if err != nil {
+			// We continue without headers.
+			h = textproto.MIMEHeader{}
+		}
+		e.header = h
+	} else {
+		e.header = textproto.MIMEHeader{}
+	}
+	_, err := e.commands(s.commands)
+	if err == nil && e.result.Reject && (len(e.result.Fileinto) > 0 || len(e.result.Redirects) > 0 || e.result.Keep) {
+		// ../rfc/5429:190
+		err = fmt.Errorf("%w: reject cannot be combined with keep, fileinto or redirect", ErrRuntime)
+	}
+	if err != nil {
+		// ../rfc/5228:697
+		return Result{Keep: true}, err
+	}
+	if e.implicitKeep {
+		e.result.Keep = true
+		e.result.KeepFlags = e.flags
+	}
+	return e.result, nil
+}
+
+// commands executes the commands, returning whether a stop was executed.
+func (e *evaluator) commands(l []command) (bool, error) {
+	for _, cmd := range l {
+		stop, err := e.command(cmd)
+		if err != nil || stop {
+			return stop, err
+		}
+	}
+	return false, nil
+}
+
+func (e *evaluator) command(cmd command) (bool, error) {
+	switch c := cmd.(type) {
+	case *cmdIf:
+		for i, t := range c.tests {
+			ok, err := e.test(t)
+			if err != nil {
+				return false, err
+			} else if ok {
+				return e.commands(c.blocks[i])
+			}
+		}
+		return e.commands(c.elseBlock)
+
+	case cmdStop:
+		return true, nil
+
+	case cmdKeep:
+		e.implicitKeep = false
+		e.result.Keep = true
+		e.result.KeepFlags = e.flags
+		if c.flags != nil {
+			e.result.KeepFlags = *c.flags
+		}
+
+	case cmdDiscard:
+		e.implicitKeep = false
+
+	case cmdFileinto:
+		if !c.copy {
+			e.implicitKeep = false
+		}
+		flags := e.flags
+		if c.flags != nil {
+			flags = *c.flags
+		}
+		for _, f := range e.result.Fileinto {
+			if f.Mailbox == c.mailbox {
+				return false, nil
+			}
+		}
+		e.result.Fileinto = append(e.result.Fileinto, Fileinto{c.mailbox, flags})
+
+	case cmdRedirect:
+		if !c.copy {
+			e.implicitKeep = false
+		}
+		for _, a := range e.result.Redirects {
+			if a == c.address {
+				return false, nil
+			}
+		}
+		if len(e.result.Redirects) >= maxRedirects {
+			return false, fmt.Errorf("%w: too many redirects", ErrRuntime)
+		}
+		e.result.Redirects = append(e.result.Redirects, c.address)
+
+	case cmdReject:
+		if e.result.Reject {
+			return false, fmt.Errorf("%w: multiple rejects", ErrRuntime)
+		} else if e.result.Vacation != nil {
+			return false, fmt.Errorf("%w: reject cannot be combined with vacation", ErrRuntime)
+		}
+		e.implicitKeep = false
+		e.result.Reject = true
+		e.result.RejectReason = c.reason
+
+	case cmdVacation:
+		if e.result.Vacation != nil {
+			return false, fmt.Errorf("%w: multiple vacation actions", ErrRuntime)
+		} else if e.result.Reject {
+			return false, fmt.Errorf("%w: vacation cannot be combined with reject", ErrRuntime)
+		}
+		v := c.Vacation
+		e.result.Vacation = &v
+
+	case cmdFlags:
+		e.flags = applyFlags(e.flags, c.op, c.flags)
+
+	default:
+		return false, fmt.Errorf("%w: unknown command %T", ErrRuntime, cmd)
+	}
+	return false, nil
+}
+
+// applyFlags returns a new list of flags after setting, adding or removing flags.
+// Flags are compared case-insensitively. ../rfc/5232:189
+func applyFlags(cur []string, op string, flags []string) []string {
+	var r []string
+	if op != "setflag" {
+		r = append(r, cur...)
+	}
+	for _, f := range flags {
+		i := -1
+		for j, x := range r {
+			if strings.EqualFold(x, f) {
+				i = j
+				break
+			}
+		}
+		if op == "removeflag" {
+			if i >= 0 {
+				r = append(r[:i], r[i+1:]...)
+			}
+		} else if i < 0 {
+			r = append(r, f)
+		}
+	}
+	return r
+}
+
+func (e *evaluator) test(t test) (bool, error) {
+	switch t := t.(type) {
+	case testBool:
+		return bool(t), nil
+
+	case testNot:
+		ok, err := e.test(t.test)
+		return !ok, err
+
+	case testAllof:
+		for _, x := range t.tests {
+			if ok, err := e.test(x); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+
+	case testAnyof:
+		for _, x := range t.tests {
+			if ok, err := e.test(x); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+
+	case testSize:
+		if t.over {
+			return e.msg.Size > t.limit, nil
+		}
+		return e.msg.Size < t.limit, nil
+
+	case testExists:
+		for _, h := range t.headers {
+			if len(e.header.Values(h)) == 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+
+	case testHeader:
+		for _, h := range t.headers {
+			for _, v := range e.header.Values(h) {
+				if t.matchAny(decodeHeader(v), t.keys) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+
+	case testAddress:
+		for _, h := range t.headers {
+			for _, addr := range e.addresses(t.envelope, h) {
+				if v, ok := addressPart(addr, t.part); ok && t.matchAny(v, t.keys) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+
+	case testHasflag:
+		for _, f := range e.flags {
+			if t.matchAny(f, t.flags) {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case testBody:
+		return e.body(t)
+	}
+	return false, fmt.Errorf("%w: unknown test %T", ErrRuntime, t)
+}
+
+// addresses returns the addresses for the envelope part or header, in
+// "localpart@domain" form. Header values that cannot be parsed as address are
+// returned as is.
+func (e *evaluator) addresses(envelope bool, h string) []string {
+	if envelope {
+		if h == "from" {
+			return []string{e.msg.EnvelopeFrom}
+		}
+		return []string{e.msg.EnvelopeTo}
+	}
+	var l []string
+	for _, v := range e.header.Values(h) {
+		addrs, err := mail.ParseAddressList(v)
+		if err != nil {
+			l = append(l, decodeHeader(v))
+			continue
+		}
+		for _, a := range addrs {
+			l = append(l, a.Address)
+		}
+	}
+	return l
+}
+
+// addressPart returns the part of address to compare, and false if the part
+// cannot be determined, e.g. for invalid addresses. ../rfc/5228:834
+func addressPart(addr, part string) (string, bool) {
+	if part == "all" {
+		return addr, true
+	}
+	i := strings.LastIndexByte(addr, '@')
+	if i < 0 {
+		return "", false
+	}
+	if part == "localpart" {
+		return addr[:i], true
+	}
+	return addr[i+1:], true
+}
+
+func (e *evaluator) body(t testBody) (bool, error) {
+	if e.msg.Part == nil {
+		return false, nil
+	}
+	// ../rfc/5173:155
+	if t.transform == "raw" {
+		buf, err := io.ReadAll(io.LimitReader(e.msg.Part.RawReader(), maxBodyMatch))
+		if err != nil {
+			return false, fmt.Errorf("%w: reading body: %v", ErrRuntime, err)
+		}
+		return t.matchAny(string(buf), t.keys), nil
+	}
+
+	contentTypes := t.contentTypes
+	if t.transform == "text" {
+		contentTypes = []string{"text"}
+	}
+	var match bool
+	var rerr error
+	var walk func(p *message.Part)
+	walk = func(p *message.Part) {
+		if match || rerr != nil {
+			return
+		}
+		if len(p.Parts) > 0 {
+			for i := range p.Parts {
+				walk(&p.Parts[i])
+			}
+			return
+		}
+		if p.Message != nil {
+			walk(p.Message)
+			return
+		}
+		if !matchContentType(p, contentTypes) {
+			return
+		}
+		buf, err := io.ReadAll(io.LimitReader(p.ReaderUTF8OrBinary(), maxBodyMatch))
+		if err != nil {
+			rerr = fmt.Errorf("%w: reading body part: %v", ErrRuntime, err)
+			return
+		}
+		match = t.matchAny(string(buf), t.keys)
+	}
+	walk(e.msg.Part)
+	return match, rerr
+}
+
+// matchContentType returns whether the content-type of p matches one of the
+// types, each a "type", "type/subtype" or empty to match all.
+func matchContentType(p *message.Part, types []string) bool {
+	mt := strings.ToLower(p.MediaType)
+	st := strings.ToLower(p.MediaSubType)
+	if mt == "" {
+		mt, st = "text", "plain"
+	}
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if t == "" || t == mt || t == mt+"/"+st {
+			return true
+		}
+	}
+	return false
+}
+
+var wordDecoder = mime.WordDecoder{
+	CharsetReader: func(charset string, r io.Reader) (io.Reader, error) {
+		return message.DecodeReader(charset, r), nil
+	},
+}
+
+func decodeHeader(s string) string {
+	if v, err := wordDecoder.DecodeHeader(s); err == nil {
+		return v
+	}
+	return s
+}
+
+// matchAny returns whether v matches any of the keys.
+func (m matcher) matchAny(v string, keys []string) bool {
+	for _, k := range keys {
+		if m.match(v, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m matcher) match(v, key string) bool {
+	if m.comparator == "i;ascii-casemap" {
+		v = asciiLower(v)
+		key = asciiLower(key)
+	}
+	switch m.matchType {
+	case "contains":
+		return strings.Contains(v, key)
+	case "matches":
+		return wildcardMatch(v, key)
+	}
+	return v == key
+}
+
+func asciiLower(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= 'A' && c <= 'Z' {
+			if b == nil {
+				b = []byte(s)
+			}
+			b[i] = c + ('a' - 'A')
+		}
+	}
+	if b == nil {
+		return s
+	}
+	return string(b)
+}
+
+// wildcardMatch matches v against pattern, with "*" matching zero or more
+// characters, "?" matching one character and backslash escaping the next
+// character. ../rfc/5228:913
+func wildcardMatch(v, pattern string) bool {
+	// Iterative matching with backtracking to the last star.
+	var vi, pi int
+	starPi, starVi := -1, 0
+	for vi < len(v) {
+		if pi < len(pattern) {
+			switch pattern[pi] {
+			case '*':
+				starPi = pi
+				starVi = vi
+				pi++
+				continue
+			case '?':
+				_, n := utf8.DecodeRuneInString(v[vi:])
+				vi += n
+				pi++
+				continue
+			default:
+				c := pattern[pi]
+				npi := pi + 1
+				if c == '\\' && npi < len(pattern) {
+					c = pattern[npi]
+					npi++
+				}
+				if v[vi] == c {
+					vi++
+					pi = npi
+					continue
+				}
+			}
+		}
+		if starPi < 0 {
+			return false
+		}
+		// Let the star consume one more character.
+		_, n := utf8.DecodeRuneInString(v[starVi:])
+		starVi += n
+		vi = starVi
+		pi = starPi + 1
+	}
+	for pi < len(pattern) && pattern[pi] == '*' {
+		pi++
+	}
+	return pi == len(pattern)
+}
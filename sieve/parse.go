@@ -0,0 +1,473 @@
+// Package sieve parses and evaluates Sieve mail filtering scripts, RFC 5228.
+//
+// Supported extensions: fileinto, reject and ereject (RFC 5429), envelope,
+// body (RFC 5173), vacation (RFC 5230), imap4flags (RFC 5232), copy (RFC
+// 3894) and the comparators i;octet and i;ascii-casemap.
+package sieve
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ../rfc/5228:1386
+
+var ErrSyntax = errors.New("syntax error")
+
+// Extensions lists the supported extensions, as used in "require" and announced
+// in ManageSieve capabilities.
+var Extensions = []string{
+	"fileinto",
+	"reject",
+	"ereject",
+	"envelope",
+	"body",
+	"vacation",
+	"imap4flags",
+	"copy",
+	"comparator-i;octet",
+	"comparator-i;ascii-casemap",
+}
+
+// Limits on scripts, to prevent abuse.
+const (
+	maxNesting   = 32
+	maxRedirects = 5
+)
+
+type tokenKind byte
+
+const (
+	tokEOF        tokenKind = iota
+	tokIdentifier           // e.g. "fileinto"
+	tokTag                  // e.g. ":is", without colon.
+	tokNumber
+	tokString // Quoted string or multi-line text.
+	tokSpecial
+)
+
+type token struct {
+	kind tokenKind
+	s    string // Identifier, tag, string value, or special character.
+	n    int64  // For tokNumber.
+	line int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of script"
+	case tokIdentifier:
+		return fmt.Sprintf("identifier %q", t.s)
+	case tokTag:
+		return fmt.Sprintf("tag :%s", t.s)
+	case tokNumber:
+		return fmt.Sprintf("number %d", t.n)
+	case tokString:
+		return "string"
+	}
+	return fmt.Sprintf("%q", t.s)
+}
+
+type lexer struct {
+	s    string
+	o    int
+	line int
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", ErrSyntax, l.line, fmt.Sprintf(format, args...))
+}
+
+// skip whitespace and comments.
+func (l *lexer) skip() error {
+	for l.o < len(l.s) {
+		c := l.s[l.o]
+		switch {
+		case c == '\n':
+			l.line++
+			l.o++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.o++
+		case c == '#':
+			for l.o < len(l.s) && l.s[l.o] != '\n' {
+				l.o++
+			}
+		case strings.HasPrefix(l.s[l.o:], "/*"):
+			end := strings.Index(l.s[l.o+2:], "*/")
+			if end < 0 {
+				return l.errorf("unterminated comment")
+			}
+			l.line += strings.Count(l.s[l.o:l.o+2+end], "\n")
+			l.o += 2 + end + 2
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *lexer) identifier() string {
+	s := l.o
+	for l.o < len(l.s) && (isAlpha(l.s[l.o]) || isDigit(l.s[l.o])) {
+		l.o++
+	}
+	return l.s[s:l.o]
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skip(); err != nil {
+		return token{}, err
+	}
+	t := token{line: l.line}
+	if l.o >= len(l.s) {
+		return t, nil
+	}
+	c := l.s[l.o]
+	switch {
+	case c == ':':
+		l.o++
+		if l.o >= len(l.s) || !isAlpha(l.s[l.o]) {
+			return t, l.errorf("expected tag after colon")
+		}
+		t.kind = tokTag
+		t.s = strings.ToLower(l.identifier())
+	case isAlpha(c):
+		t.kind = tokIdentifier
+		t.s = strings.ToLower(l.identifier())
+		if t.s == "text" && l.o < len(l.s) && l.s[l.o] == ':' {
+			l.o++
+			s, err := l.multiline()
+			if err != nil {
+				return t, err
+			}
+			t.kind = tokString
+			t.s = s
+		}
+	case isDigit(c):
+		s := l.o
+		for l.o < len(l.s) && isDigit(l.s[l.o]) {
+			l.o++
+		}
+		v, err := strconv.ParseInt(l.s[s:l.o], 10, 64)
+		if err != nil {
+			return t, l.errorf("bad number: %v", err)
+		}
+		// ../rfc/5228:464
+		if l.o < len(l.s) {
+			var mult int64
+			switch l.s[l.o] {
+			case 'k', 'K':
+				mult = 1 << 10
+			case 'm', 'M':
+				mult = 1 << 20
+			case 'g', 'G':
+				mult = 1 << 30
+			}
+			if mult > 0 {
+				l.o++
+				v *= mult
+			}
+		}
+		t.kind = tokNumber
+		t.n = v
+	case c == '"':
+		l.o++
+		var b strings.Builder
+		for {
+			if l.o >= len(l.s) {
+				return t, l.errorf("unterminated string")
+			}
+			c := l.s[l.o]
+			l.o++
+			if c == '"' {
+				break
+			} else if c == '\\' && l.o < len(l.s) {
+				// ../rfc/5228:534
+				c = l.s[l.o]
+				l.o++
+			} else if c == '\n' {
+				l.line++
+			}
+			b.WriteByte(c)
+		}
+		t.kind = tokString
+		t.s = normalizeCRLF(b.String())
+	case strings.IndexByte(";,()[]{}", c) >= 0:
+		l.o++
+		t.kind = tokSpecial
+		t.s = string(c)
+	default:
+		return t, l.errorf("unexpected character %q", c)
+	}
+	return t, nil
+}
+
+// multiline parses the text after "text:", up to and including the line with
+// only a dot. ../rfc/5228:489
+func (l *lexer) multiline() (string, error) {
+	for l.o < len(l.s) && (l.s[l.o] == ' ' || l.s[l.o] == '\t') {
+		l.o++
+	}
+	if l.o < len(l.s) && l.s[l.o] == '#' {
+		for l.o < len(l.s) && l.s[l.o] != '\n' {
+			l.o++
+		}
+	}
+	if strings.HasPrefix(l.s[l.o:], "\r\n") {
+		l.o += 2
+	} else if strings.HasPrefix(l.s[l.o:], "\n") {
+		l.o++
+	} else {
+		return "", l.errorf("expected newline after text:")
+	}
+	l.line++
+
+	var b strings.Builder
+	for {
+		if l.o >= len(l.s) {
+			return "", l.errorf("unterminated multi-line text")
+		}
+		end := strings.IndexByte(l.s[l.o:], '\n')
+		var line string
+		if end < 0 {
+			line = l.s[l.o:]
+			l.o = len(l.s)
+		} else {
+			line = l.s[l.o : l.o+end]
+			l.o += end + 1
+		}
+		l.line++
+		line = strings.TrimSuffix(line, "\r")
+		if line == "." {
+			break
+		}
+		// Dot-unstuffing.
+		if strings.HasPrefix(line, "..") {
+			line = line[1:]
+		}
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	return b.String(), nil
+}
+
+// normalizeCRLF turns bare newlines into CRLF, as strings in scripts are often
+// written with just newlines.
+func normalizeCRLF(s string) string {
+	if !strings.Contains(s, "\n") {
+		return s
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+// Generic syntax tree, before checking commands and tests against their
+// definitions.
+
+type argument struct {
+	line    int
+	tag     string   // Without colon, if this is a tag.
+	number  *int64   // If this is a number.
+	strings []string // For a string or string list.
+	list    bool     // Whether strings was specified as list.
+}
+
+type node struct {
+	line     int
+	name     string
+	args     []argument
+	tests    []node // Test or test list.
+	block    []node // For commands with a block.
+	hasBlock bool
+}
+
+type parser struct {
+	lex  lexer
+	tok  token // Current, unconsumed token.
+	nest int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", ErrSyntax, p.tok.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) advance() error {
+	t, err := p.lex.next()
+	p.tok = t
+	return err
+}
+
+func (p *parser) isSpecial(s string) bool {
+	return p.tok.kind == tokSpecial && p.tok.s == s
+}
+
+// commands parses commands until the end of the script, or a closing brace if
+// inBlock.
+func (p *parser) commands(inBlock bool) ([]node, error) {
+	var l []node
+	for {
+		if p.tok.kind == tokEOF {
+			if inBlock {
+				return nil, p.errorf("missing closing brace")
+			}
+			return l, nil
+		} else if inBlock && p.isSpecial("}") {
+			return l, p.advance()
+		}
+		n, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, n)
+	}
+}
+
+func (p *parser) command() (node, error) {
+	if p.tok.kind != tokIdentifier {
+		return node{}, p.errorf("expected command, got %s", p.tok)
+	}
+	n := node{line: p.tok.line, name: p.tok.s}
+	if err := p.advance(); err != nil {
+		return n, err
+	}
+	if err := p.arguments(&n); err != nil {
+		return n, err
+	}
+	if p.isSpecial(";") {
+		return n, p.advance()
+	} else if p.isSpecial("{") {
+		p.nest++
+		if p.nest > maxNesting {
+			return n, p.errorf("blocks nested too deep")
+		}
+		if err := p.advance(); err != nil {
+			return n, err
+		}
+		block, err := p.commands(true)
+		if err != nil {
+			return n, err
+		}
+		p.nest--
+		n.block = block
+		n.hasBlock = true
+		return n, nil
+	}
+	return n, p.errorf("expected semicolon or block, got %s", p.tok)
+}
+
+// arguments parses arguments, followed by an optional test or test list.
+func (p *parser) arguments(n *node) error {
+	for {
+		a := argument{line: p.tok.line}
+		switch {
+		case p.tok.kind == tokTag:
+			a.tag = p.tok.s
+		case p.tok.kind == tokNumber:
+			v := p.tok.n
+			a.number = &v
+		case p.tok.kind == tokString:
+			a.strings = []string{p.tok.s}
+		case p.isSpecial("["):
+			a.list = true
+			for {
+				if err := p.advance(); err != nil {
+					return err
+				}
+				if p.tok.kind != tokString {
+					return p.errorf("expected string in string list, got %s", p.tok)
+				}
+				a.strings = append(a.strings, p.tok.s)
+				if err := p.advance(); err != nil {
+					return err
+				}
+				if p.isSpecial("]") {
+					break
+				} else if !p.isSpecial(",") {
+					return p.errorf("expected comma or closing bracket in string list, got %s", p.tok)
+				}
+			}
+		default:
+			goto tests
+		}
+		n.args = append(n.args, a)
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+
+tests:
+	if p.tok.kind == tokIdentifier {
+		t, err := p.test()
+		if err != nil {
+			return err
+		}
+		n.tests = []node{t}
+	} else if p.isSpecial("(") {
+		for {
+			if err := p.advance(); err != nil {
+				return err
+			}
+			t, err := p.test()
+			if err != nil {
+				return err
+			}
+			n.tests = append(n.tests, t)
+			if p.isSpecial(")") {
+				break
+			} else if !p.isSpecial(",") {
+				return p.errorf("expected comma or closing parenthesis in test list, got %s", p.tok)
+			}
+		}
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) test() (node, error) {
+	if p.tok.kind != tokIdentifier {
+		return node{}, p.errorf("expected test, got %s", p.tok)
+	}
+	p.nest++
+	if p.nest > maxNesting {
+		return node{}, p.errorf("tests nested too deep")
+	}
+	n := node{line: p.tok.line, name: p.tok.s}
+	if err := p.advance(); err != nil {
+		return n, err
+	}
+	err := p.arguments(&n)
+	p.nest--
+	return n, err
+}
+
+// Parse parses and checks a sieve script. Unknown commands, tests, arguments and
+// extensions result in an error wrapping ErrSyntax.
+func Parse(script string) (*Script, error) {
+	p := &parser{lex: lexer{s: script, line: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	nodes, err := p.commands(false)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{require: map[string]bool{}, first: true}
+	cmds, err := c.commands(nodes, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{cmds}, nil
+}
@@ -0,0 +1,205 @@
+package sieve
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	good := []string{
+		``,
+		`keep;`,
+		`# comment
+		/* bracket
+		comment */ discard;`,
+		`require ["fileinto", "copy"]; fileinto :copy "Archive";`,
+		`require "imap4flags"; if header :contains "subject" "x" { addflag ["\\Seen", "$label1 $label2"]; } elsif true { stop; } else { keep :flags "\\Flagged"; }`,
+		`if anyof (not exists "x", size :over 10K, allof (true, false)) { discard; }`,
+		`require "vacation"; vacation :days 3 :subject "away" :addresses ["a@b.example"] :handle "h" text:
+I'm away.
+..dot
+.
+;`,
+		`require ["envelope", "body"]; if envelope :domain :is "from" "example.org" { redirect "x@example.com"; } if body :content "text/plain" :contains "word" { discard; }`,
+		`require "reject"; reject "no";`,
+		`if address :comparator "i;octet" :localpart :matches ["from", "sender"] "a*" { discard; }`,
+	}
+	for _, s := range good {
+		_, err := Parse(s)
+		tcheck(t, err, "parse "+s)
+	}
+
+	bad := []string{
+		`keep`,                  // Missing semicolon.
+		`unknown;`,              // Unknown command.
+		`fileinto "x";`,         // Missing require.
+		`require "foo";`,        // Unknown extension.
+		`keep; require "copy";`, // Require not at start.
+		`else { keep; }`,        // Else without if.
+		`if true keep;`,         // Missing block.
+		`if true { keep;`,       // Missing closing brace.
+		`keep :unknown;`,        // Unknown tag.
+		`redirect "not an address";`,
+		`if size 10 { keep; }`, // Missing :over/:under.
+		`if header :is :contains "a" "b" { keep; }`,
+		`if envelope "from" "x" { keep; }`, // Missing require.
+		`require "envelope"; if envelope "subject" "x" { keep; }`,
+		`if header :comparator "i;unknown" "a" "b" { keep; }`,
+		`keep "x";`,
+		`"unterminated`,
+		`/* unterminated`,
+		`require "vacation"; vacation text:
+no end`,
+	}
+	for _, s := range bad {
+		if _, err := Parse(s); err == nil || !errors.Is(err, ErrSyntax) {
+			t.Fatalf("parse %q: got err %v, expected ErrSyntax", s, err)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	const msg = "From: Sender <sender@example.org>\r\n" +
+		"To: mjl@mox.example, other@example.com\r\n" +
+		"Subject: =?utf-8?q?hello_w=C3=B6rld?=\r\n" +
+		"List-Id: <list.example.org>\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=x\r\n" +
+		"\r\n" +
+		"--x\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"the secret word\r\n" +
+		"--x\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"YmluYXJ5\r\n" +
+		"--x--\r\n"
+
+	log := mlog.New("sieve", nil)
+	p, err := message.EnsurePart(log.Logger, false, strings.NewReader(msg), int64(len(msg)))
+	tcheck(t, err, "parse message")
+	m := Message{
+		EnvelopeFrom: "sender@example.org",
+		EnvelopeTo:   "mjl@mox.example",
+		Size:         int64(len(msg)),
+		Part:         &p,
+	}
+
+	test := func(script string, exp Result) {
+		t.Helper()
+		s, err := Parse(script)
+		tcheck(t, err, "parse")
+		r, err := s.Evaluate(m)
+		tcheck(t, err, "evaluate")
+		if !reflect.DeepEqual(r, exp) {
+			t.Fatalf("evaluate %q:\ngot      %#v\nexpected %#v", script, r, exp)
+		}
+	}
+
+	other, err := smtp.ParseAddress("other@example.com")
+	tcheck(t, err, "parse address")
+
+	keep := Result{Keep: true}
+	discard := Result{}
+
+	test(``, keep)
+	test(`discard;`, discard)
+	test(`discard; keep;`, keep)
+	test(`stop; discard;`, keep)
+	test(`if header :is "subject" "Hello wörld" { discard; }`, discard)
+	test(`if header :comparator "i;octet" :is "subject" "Hello wörld" { discard; }`, keep)
+	test(`if header :matches "subject" "h?llo*" { discard; }`, discard)
+	test(`if header :matches "subject" "*w\\?rld" { discard; }`, keep)
+	test(`if header :contains "list-id" "list.example.org" { discard; }`, discard)
+	test(`if exists ["list-id", "x-missing"] { discard; }`, keep)
+	test(`if not exists "x-missing" { discard; }`, discard)
+	test(`if address :domain :is "to" "example.com" { discard; }`, discard)
+	test(`if address :localpart :is "from" "SENDER" { discard; }`, discard)
+	test(`if address :all :is "from" "Sender <sender@example.org>" { discard; }`, keep)
+	test(`if size :over 100 { discard; }`, discard)
+	test(`if size :under 100 { discard; }`, keep)
+	test(`if anyof (false, true) { discard; }`, discard)
+	test(`if allof (false, true) { discard; }`, keep)
+	test(`if false { discard; } elsif true { stop; } else { discard; }`, keep)
+	test(`if false { discard; } else { discard; }`, discard)
+
+	test(`require "envelope"; if envelope :domain "from" "example.org" { discard; }`, discard)
+	test(`require "envelope"; if envelope :localpart "to" "other" { discard; }`, keep)
+
+	test(`require "body"; if body :contains "secret" { discard; }`, discard)
+	test(`require "body"; if body :contains "binary" { discard; }`, keep)
+	test(`require "body"; if body :content "application" :contains "binary" { discard; }`, discard)
+	test(`require "body"; if body :raw :contains "YmluYXJ5" { discard; }`, discard)
+
+	test(`require "fileinto"; fileinto "Archive";`, Result{Fileinto: []Fileinto{{"Archive", nil}}})
+	test(`require ["fileinto", "copy"]; fileinto :copy "Archive"; fileinto :copy "Archive";`, Result{Keep: true, Fileinto: []Fileinto{{"Archive", nil}}})
+
+	test(`redirect "other@example.com";`, Result{Redirects: []smtp.Address{other}})
+	test(`require "copy"; redirect :copy "other@example.com";`, Result{Keep: true, Redirects: []smtp.Address{other}})
+
+	test(`require "reject"; reject "go away";`, Result{Reject: true, RejectReason: "go away"})
+	test(`require "ereject"; ereject "go away";`, Result{Reject: true, RejectReason: "go away"})
+
+	test(`require "imap4flags"; addflag "\\Seen $a"; removeflag "$A"; addflag "\\seen";`, Result{Keep: true, KeepFlags: []string{`\Seen`}})
+	test(`require "imap4flags"; setflag "$a"; if hasflag "$A" { keep :flags "$b"; }`, Result{Keep: true, KeepFlags: []string{"$b"}})
+	test(`require ["imap4flags", "fileinto"]; setflag "$a"; fileinto "Archive";`, Result{Fileinto: []Fileinto{{"Archive", []string{"$a"}}}})
+
+	test(`require "vacation"; vacation :days 0 "away";`, Result{Keep: true, Vacation: &Vacation{Days: 1, Handle: "\x00\x00away", Reason: "away"}})
+
+	// Runtime errors result in implicit keep.
+	runtimeError := func(script string) {
+		t.Helper()
+		s, err := Parse(script)
+		tcheck(t, err, "parse")
+		r, err := s.Evaluate(m)
+		if !errors.Is(err, ErrRuntime) {
+			t.Fatalf("evaluate %q: got err %v, expected ErrRuntime", script, err)
+		}
+		if !reflect.DeepEqual(r, keep) {
+			t.Fatalf("evaluate %q with error: got %#v, expected implicit keep", script, r)
+		}
+	}
+	runtimeError(`require ["reject", "vacation"]; vacation "away"; reject "no";`)
+	runtimeError(`require "reject"; keep; reject "no";`)
+	runtimeError(`redirect "a@x.example"; redirect "b@x.example"; redirect "c@x.example"; redirect "d@x.example"; redirect "e@x.example"; redirect "f@x.example";`)
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		v, pattern string
+		exp        bool
+	}{
+		{"", "", true},
+		{"", "*", true},
+		{"a", "", false},
+		{"abc", "a*c", true},
+		{"abc", "a?c", true},
+		{"ac", "a?c", false},
+		{"aXbXc", "*b*", true},
+		{"aaa", "a*a*a", true},
+		{"aa", "a*a*a", false},
+		{"a*c", `a\*c`, true},
+		{"abc", `a\*c`, false},
+		{"wörld", "w?rld", true},
+	}
+	for _, tc := range tests {
+		if r := wildcardMatch(tc.v, tc.pattern); r != tc.exp {
+			t.Fatalf("wildcardMatch(%q, %q) = %v, expected %v", tc.v, tc.pattern, r, tc.exp)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package smtpserver
+
+import (
+	"strings"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// expandAlias returns the recipients for the members of an alias. Messages for
+// local members are delivered as if sent to them directly, with the alias as
+// recipient. Members with the same account get a single delivery, for the first
+// of their addresses. External members get a single recipient that forwards to
+// all of them, with spam filtering using the account of the first local member.
+func expandAlias(r rcptAccount) []rcptAccount {
+	var l []rcptAccount
+	var external []string
+	accounts := map[string]bool{}
+	var firstAccount string
+	for _, aa := range r.alias.ParsedAddresses {
+		if aa.AccountName == "" {
+			external = append(external, aa.Address.String())
+			continue
+		}
+		if firstAccount == "" {
+			firstAccount = aa.AccountName
+		}
+		if accounts[aa.AccountName] {
+			continue
+		}
+		accounts[aa.AccountName] = true
+		l = append(l, rcptAccount{r.rcptTo, true, aa.AccountName, aa.Destination, aa.Address.String(), smtp.Path{}, r.alias, nil})
+	}
+	if len(external) > 0 && firstAccount != "" {
+		dest := config.Destination{ForwardTo: external}
+		l = append(l, rcptAccount{r.rcptTo, true, firstAccount, dest, r.canonicalAddress, smtp.Path{}, r.alias, nil})
+	}
+	return l
+}
+
+// aliasAllowed returns whether a message with From address msgFrom, verified
+// with validation, can be delivered to the alias. Addresses are compared
+// case-insensitively.
+func aliasAllowed(alias config.Alias, msgFrom smtp.Address, validation store.Validation) bool {
+	if alias.PostPublic {
+		return true
+	}
+	switch validation {
+	case store.ValidationStrict, store.ValidationDMARC, store.ValidationRelaxed:
+	default:
+		return false
+	}
+	for _, aa := range alias.ParsedAddresses {
+		if strings.EqualFold(aa.Address.String(), msgFrom.String()) {
+			return true
+		}
+	}
+	for _, s := range alias.AllowMsgFrom {
+		if addr, err := smtp.ParseAddress(s); err == nil && strings.EqualFold(addr.String(), msgFrom.String()) {
+			return true
+		}
+	}
+	return false
+}
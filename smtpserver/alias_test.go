@@ -0,0 +1,111 @@
+package smtpserver
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/mjl-/mox/store"
+)
+
+// Test delivery to aliases, expanding to local and external members, with posting
+// restrictions.
+func TestAlias(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtpserveralias/mox.conf"), resolver)
+	defer ts.close()
+
+	other, err := store.OpenAccount(pkglog, "other")
+	tcheck(t, err, "open account")
+	defer other.Close()
+
+	testDeliver := func(rcptTo, msg string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(err error, client *smtpclient.Client) {
+			t.Helper()
+			if err == nil {
+				err = client.Deliver(ctxbg, "remote@example.org", rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false)
+			}
+			var cerr smtpclient.Error
+			if expErr == nil && err != nil || expErr != nil && (err == nil || !errors.As(err, &cerr) || cerr.Secode != expErr.Secode) {
+				t.Fatalf("got err %#v, expected %#v", err, expErr)
+			}
+		})
+	}
+
+	checkCounts := func(expQueued, expMjl, expOther int) {
+		t.Helper()
+		n, err := queue.Count(ctxbg)
+		tcheck(t, err, "count queue")
+		tcompare(t, n, expQueued)
+		n, err = bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Count()
+		tcheck(t, err, "count messages")
+		tcompare(t, n, expMjl)
+		n, err = bstore.QueryDB[store.Message](ctxbg, other.DB).Count()
+		tcheck(t, err, "count messages")
+		tcompare(t, n, expOther)
+	}
+
+	// Allowed sender, delivered to both accounts and forwarded to the external member.
+	testDeliver("team@mox.example", deliverMessage, nil)
+	checkCounts(1, 1, 1)
+	msgs, err := queue.List(ctxbg)
+	tcheck(t, err, "list queue")
+	tcompare(t, msgs[0].Recipient().String(), "fwd@remote.example")
+
+	// Sender is not a member.
+	unauth := smtpclient.Error{Code: smtp.C550MailboxUnavail, Secode: smtp.SePol7DeliveryUnauth1}
+	testDeliver("members@mox.example", deliverMessage, &unauth)
+	checkCounts(1, 1, 1)
+
+	// Message From address is not allowed either, even with the SMTP MAIL FROM allowed.
+	msg := strings.Replace(deliverMessage, "From: <remote@example.org>", "From: <other@example.org>", 1)
+	testDeliver("team@mox.example", msg, &unauth)
+	checkCounts(1, 1, 1)
+
+	// Anyone can send to public alias.
+	testDeliver("public@mox.example", msg, nil)
+	checkCounts(1, 2, 1)
+
+	// Aliases are matched case-insensitively, like other addresses.
+	testDeliver("PUBLIC@mox.example", msg, nil)
+	checkCounts(1, 3, 1)
+
+	// If delivery to a member fails, the message is still accepted for the alias, and
+	// a DSN is queued for the failed member, besides the forward.
+	otherConf := mox.Conf.Dynamic.Accounts["other"]
+	otherConf.QuotaMessageSize = 1
+	mox.Conf.Dynamic.Accounts["other"] = otherConf
+	defer func() {
+		otherConf.QuotaMessageSize = 0
+		mox.Conf.Dynamic.Accounts["other"] = otherConf
+	}()
+	testDeliver("team@mox.example", deliverMessage, nil)
+	checkCounts(3, 4, 1)
+	msgs, err = queue.List(ctxbg)
+	tcheck(t, err, "list queue")
+	var dsn bool
+	for _, qm := range msgs {
+		dsn = dsn || qm.Recipient().String() == "remote@example.org"
+	}
+	tcompare(t, dsn, true)
+}
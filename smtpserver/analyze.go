@@ -104,6 +104,8 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 	rs := store.MessageRuleset(log, d.rcptAcc.destination, d.m, d.m.MsgPrefix, d.dataFile)
 	if rs != nil {
 		mailbox = rs.Mailbox
+	} else if mb := d.acc.SubaddressMailbox(log, d.m, mailbox); mb != "" {
+		mailbox = mb
 	}
 	if rs != nil && !rs.ListAllowDNSDomain.IsZero() {
 		// todo: on temporary failures, reject temporarily?
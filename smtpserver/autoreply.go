@@ -0,0 +1,249 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// autoReply is an automatic response to an incoming message, for a sieve
+// vacation action or the auto-responder of an account.
+type autoReply struct {
+	handle    string        // Identifies the response, for limiting responses to a sender.
+	interval  time.Duration // Minimum interval between responses to the same sender.
+	from      smtp.Address
+	subject   string   // If empty, based on subject of the incoming message.
+	body      string   // Text, or a MIME entity with headers if mime is set.
+	mime      bool     // Whether body is a MIME entity.
+	addresses []string // Addresses of the recipient, one must be in the To/Cc headers.
+}
+
+// autoReplySuppress returns a non-empty reason if no automatic response must be
+// sent for the message, e.g. because it is from a mailing list or automated
+// system. ../rfc/3834:337 ../rfc/5230:423
+func autoReplySuppress(h textproto.MIMEHeader, m *store.Message, mailFrom smtp.Path, addresses []string) string {
+	if mailFrom.IsZero() {
+		return "null reverse path"
+	}
+	lp := strings.ToLower(mailFrom.Localpart.String())
+	if lp == "mailer-daemon" || lp == "postmaster" || lp == "listserv" || lp == "majordomo" || strings.HasPrefix(lp, "owner-") || strings.HasSuffix(lp, "-request") || strings.Contains(lp, "-bounces") {
+		return "automated sender"
+	}
+	if v := strings.ToLower(strings.TrimSpace(h.Get("Auto-Submitted"))); v != "" && v != "no" {
+		return "auto-submitted message"
+	}
+	switch strings.ToLower(strings.TrimSpace(h.Get("Precedence"))) {
+	case "bulk", "list", "junk":
+		return "bulk message"
+	}
+	for _, k := range []string{"List-Id", "List-Unsubscribe", "List-Post", "List-Owner", "List-Help"} {
+		if h.Get(k) != "" {
+			return "mailing list message"
+		}
+	}
+	if m.IsMailingList {
+		return "mailing list message"
+	}
+	if m.Junk {
+		return "junk message"
+	}
+	if ct := strings.ToLower(h.Get("Content-Type")); strings.HasPrefix(strings.TrimSpace(ct), "multipart/report") {
+		return "report message"
+	}
+	// DMARC aggregate reports and TLS reports can also be sent to addresses that
+	// aren't configured as reporting address.
+	if h.Get("TLS-Report-Domain") != "" || h.Get("TLS-Report-Submitter") != "" {
+		return "tls report"
+	}
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(h.Get("Subject"))), "report domain:") {
+		return "dmarc report"
+	}
+
+	// Only respond if we are an explicit recipient. ../rfc/5230:464
+	for _, k := range []string{"To", "Cc", "Bcc", "Resent-To", "Resent-Cc"} {
+		for _, v := range h.Values(k) {
+			l, err := mail.ParseAddressList(v)
+			if err != nil {
+				continue
+			}
+			for _, a := range l {
+				for _, addr := range addresses {
+					if strings.EqualFold(a.Address, addr) {
+						return ""
+					}
+				}
+			}
+		}
+	}
+	return "recipient not in to/cc headers"
+}
+
+// queueAutoReply composes and queues an automatic response, unless it must be
+// suppressed or a response was already sent to the sender recently.
+func queueAutoReply(ctx context.Context, log mlog.Log, acc *store.Account, m *store.Message, part *message.Part, mailFrom smtp.Path, reply autoReply) error {
+	h, err := part.Header()
+	if err != nil {
+		return fmt.Errorf("parsing message header: %v", err)
+	}
+	if reason := autoReplySuppress(h, m, mailFrom, reply.addresses); reason != "" {
+		log.Debug("not sending automatic response", slog.String("reason", reason))
+		return nil
+	}
+
+	sender := strings.ToLower(mailFrom.String())
+	if ok, err := acc.AutoResponseCheck(ctx, reply.handle, sender, reply.interval); err != nil {
+		return fmt.Errorf("checking earlier responses: %v", err)
+	} else if !ok {
+		log.Debug("not sending automatic response, already sent recently", slog.String("sender", sender))
+		return nil
+	}
+
+	to, err := smtp.ParseAddress(mailFrom.String())
+	if err != nil {
+		return fmt.Errorf("parsing sender address: %v", err)
+	}
+
+	subject := reply.subject
+	if subject == "" {
+		subject = "Auto: " + strings.TrimSpace(h.Get("Subject"))
+		if part.Envelope != nil && part.Envelope.Subject != "" {
+			subject = "Auto: " + part.Envelope.Subject
+		}
+	}
+
+	msgFile, err := store.CreateMessageTemp(log, "autoreply")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, msgFile, "automatic response")
+
+	messageID, has8bit, smtputf8, err := composeAutoReply(msgFile, reply, to, subject, h)
+	if err != nil {
+		return err
+	}
+
+	buf, err := os.ReadFile(msgFile.Name())
+	if err != nil {
+		return fmt.Errorf("reading composed message: %v", err)
+	}
+	dkimHeaders, err := mox.DKIMSign(ctx, log, reply.from.Path(), smtputf8, buf)
+	log.Check(err, "dkim signing automatic response")
+
+	// Responses are sent with null reverse path, to prevent loops. ../rfc/3834:376
+	size := int64(len(dkimHeaders) + len(buf))
+	qm := queue.MakeMsg(acc.Name, smtp.Path{}, to.Path(), has8bit, smtputf8, size, messageID, []byte(dkimHeaders), nil)
+	if err := queue.Add(ctx, log, &qm, msgFile); err != nil {
+		return fmt.Errorf("queueing automatic response: %w", err)
+	}
+	log.Info("automatic response queued", slog.Any("to", to), slog.Any("from", reply.from))
+	return nil
+}
+
+func composeAutoReply(f *os.File, reply autoReply, to smtp.Address, subject string, h textproto.MIMEHeader) (messageID string, has8bit, smtputf8 bool, rerr error) {
+	xc := message.NewComposer(f, 1024*1024)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	xc.SMTPUTF8 = to.Localpart.IsInternational() || reply.from.Localpart.IsInternational()
+
+	xc.HeaderAddrs("From", []message.NameAddress{{Address: reply.from}})
+	xc.HeaderAddrs("To", []message.NameAddress{{Address: to}})
+	xc.Subject(subject)
+	messageID = fmt.Sprintf("<%s>", mox.MessageIDGen(xc.SMTPUTF8))
+	xc.Header("Message-Id", messageID)
+	if origID := strings.TrimSpace(h.Get("Message-Id")); origID != "" {
+		// ../rfc/5230:547
+		xc.Header("In-Reply-To", origID)
+		refs := strings.TrimSpace(h.Get("References"))
+		if refs != "" {
+			refs += "\r\n\t"
+		}
+		xc.Header("References", refs+origID)
+	}
+	xc.Header("Date", time.Now().Format(message.RFC5322Z))
+	xc.Header("Auto-Submitted", "auto-replied") // ../rfc/3834:245
+	xc.Header("User-Agent", "mox/"+moxvar.Version)
+	xc.Header("MIME-Version", "1.0")
+
+	if reply.mime {
+		// Body is a MIME entity with its own headers.
+		body := strings.ReplaceAll(strings.ReplaceAll(reply.body, "\r\n", "\n"), "\n", "\r\n")
+		for _, c := range body {
+			if c >= 0x80 {
+				xc.Has8bit = true
+				break
+			}
+		}
+		_, err := xc.Write([]byte(body))
+		xc.Checkf(err, "writing body")
+	} else {
+		textBody, ct, cte := xc.TextPart(reply.body)
+		xc.Header("Content-Type", ct)
+		xc.Header("Content-Transfer-Encoding", cte)
+		xc.Line()
+		_, err := xc.Write(textBody)
+		xc.Checkf(err, "writing text")
+	}
+	xc.Flush()
+	return messageID, xc.Has8bit, xc.SMTPUTF8, nil
+}
+
+// accountAutoReply returns the automatic response for the auto-responder of the
+// account, if it is active.
+func accountAutoReply(ctx context.Context, acc *store.Account, rcptTo smtp.Path) (autoReply, bool, error) {
+	ar, err := acc.AutoReplyGet(ctx)
+	if err != nil {
+		return autoReply{}, false, fmt.Errorf("get automatic reply settings: %v", err)
+	} else if !ar.Active(time.Now()) {
+		return autoReply{}, false, nil
+	}
+
+	addresses := []string{rcptTo.String()}
+	if conf, ok := acc.Conf(); ok {
+		for addr := range conf.Destinations {
+			if !strings.HasPrefix(addr, "@") {
+				addresses = append(addresses, addr)
+			}
+		}
+	}
+
+	// The handle includes the start of the period, so senders get a response again
+	// during a later period.
+	var start int64
+	if ar.Start != nil {
+		start = ar.Start.Unix()
+	}
+	reply := autoReply{
+		handle:    fmt.Sprintf("autoreply:%d", start),
+		interval:  7 * 24 * time.Hour,
+		from:      smtp.Address{Localpart: rcptTo.Localpart, Domain: rcptTo.IPDomain.Domain},
+		subject:   ar.Subject,
+		body:      ar.Body,
+		addresses: addresses,
+	}
+	return reply, true, nil
+}
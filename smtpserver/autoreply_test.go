@@ -0,0 +1,99 @@
+package smtpserver
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/mjl-/mox/store"
+)
+
+// Test the auto-responder of an account.
+func TestAutoReply(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"other.example.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"other.example."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtpservercatchall/mox.conf"), resolver)
+	defer ts.close()
+
+	err := ts.acc.AutoReplySave(ctxbg, store.AutoReply{Enabled: true, Body: "I'm away."})
+	tcheck(t, err, "save auto reply")
+
+	testDeliver := func(mailFrom, msg string) {
+		t.Helper()
+		ts.run(func(err error, client *smtpclient.Client) {
+			t.Helper()
+			if err == nil {
+				err = client.Deliver(ctxbg, mailFrom, "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			}
+			tcheck(t, err, "deliver")
+		})
+	}
+
+	checkQueued := func(exp int) {
+		t.Helper()
+		n, err := queue.Count(ctxbg)
+		tcheck(t, err, "count queue")
+		tcompare(t, n, exp)
+	}
+
+	testDeliver("remote@other.example", deliverMessage)
+	checkQueued(1)
+	msgs, err := queue.List(ctxbg)
+	tcheck(t, err, "list queue")
+	tcompare(t, msgs[0].Sender().IsZero(), true)
+	tcompare(t, msgs[0].Recipient().String(), "remote@other.example")
+	mr, err := queue.OpenMessage(ctxbg, msgs[0].ID)
+	tcheck(t, err, "open queued message")
+	buf, err := io.ReadAll(mr)
+	tcheck(t, err, "read queued message")
+	mr.Close()
+	for _, s := range []string{"Auto-Submitted: auto-replied\r\n", "In-Reply-To: <test@example.org>\r\n", "Subject: Auto: test\r\n", "I'm away."} {
+		if !strings.Contains(string(buf), s) {
+			t.Fatalf("missing %q in automatic reply %q", s, buf)
+		}
+	}
+
+	// Only one response per sender within the interval.
+	testDeliver("remote@other.example", deliverMessage2)
+	checkQueued(1)
+
+	// No responses to mailing lists.
+	listMsg := strings.Replace(deliverMessage, "Subject: test", "List-Id: <list.other.example>\r\nSubject: test", 1)
+	testDeliver("list@other.example", listMsg)
+	checkQueued(1)
+
+	// No responses to automatically submitted messages.
+	autoMsg := strings.Replace(deliverMessage, "Subject: test", "Auto-Submitted: auto-generated\r\nSubject: test", 1)
+	testDeliver("auto@other.example", autoMsg)
+	checkQueued(1)
+
+	// No responses outside the configured period.
+	end := time.Now().Add(-time.Hour)
+	err = ts.acc.AutoReplySave(ctxbg, store.AutoReply{Enabled: true, Body: "I'm away.", End: &end})
+	tcheck(t, err, "save auto reply")
+	testDeliver("other@other.example", deliverMessage)
+	checkQueued(1)
+
+	// New period, sender gets response again.
+	start := time.Now().Add(-time.Hour)
+	err = ts.acc.AutoReplySave(ctxbg, store.AutoReply{Enabled: true, Subject: "Away", Body: "I'm away.", Start: &start})
+	tcheck(t, err, "save auto reply")
+	testDeliver("remote@other.example", deliverMessage)
+	checkQueued(2)
+
+	err = ts.acc.AutoReplySave(ctxbg, store.AutoReply{Enabled: true})
+	if err == nil {
+		t.Fatalf("saved auto reply without body")
+	}
+}
@@ -0,0 +1,105 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/srs"
+	"github.com/mjl-/mox/store"
+)
+
+// forwardMessage queues the message for delivery to an external address, for a
+// destination with ForwardTo or a sieve redirect action. The envelope sender is
+// rewritten with SRS in the domain of rcptTo, so SPF checks at the next hop pass,
+// and bounces are routed back to the original sender, see srsBounce.
+func forwardMessage(ctx context.Context, log mlog.Log, acc *store.Account, m *store.Message, dataFile *os.File, mailFrom, rcptTo, to smtp.Path, prefix []byte, has8bit, smtputf8 bool, messageID string) error {
+	sender := srs.Forward(mox.SRSKey, mailFrom, rcptTo.IPDomain.Domain, time.Now())
+	size := m.Size - int64(len(m.MsgPrefix)) + int64(len(prefix))
+	qm := queue.MakeMsg(acc.Name, sender, to, has8bit, smtputf8, size, messageID, prefix, nil)
+	if err := queue.Add(ctx, log, &qm, dataFile); err != nil {
+		return fmt.Errorf("queueing forwarded message: %w", err)
+	}
+	return nil
+}
+
+// srsBounce returns whether rcptTo is an SRS address we generated for a
+// forwarded message, and the original sender to send the bounce to. Only
+// messages with the null reverse path, i.e. DSNs, are routed back.
+func (c *conn) srsBounce(rcptTo smtp.Path) (smtp.Path, bool) {
+	if c.submission || c.mailFrom == nil || !c.mailFrom.IsZero() || !srs.IsSRS(rcptTo.Localpart) {
+		return smtp.Path{}, false
+	}
+	if _, ok := mox.Conf.Domain(rcptTo.IPDomain.Domain); !ok {
+		return smtp.Path{}, false
+	}
+	orig, err := srs.Reverse(mox.SRSKey, rcptTo, time.Now())
+	if err != nil {
+		if !errors.Is(err, srs.ErrNotSRS) {
+			c.log.Infox("invalid srs bounce address", err, slog.Any("rcptto", rcptTo))
+		}
+		return smtp.Path{}, false
+	}
+	return orig, true
+}
+
+// deliverSRSBounce queues a DSN for an earlier forwarded message to the original
+// sender. The message is passed on as is, with a Received header added.
+func (c *conn) deliverSRSBounce(ctx context.Context, recvHdrFor func(string) string, msgWriter *message.Writer, dataFile *os.File) {
+	rcpt := c.recipients[0]
+	prefix := []byte(recvHdrFor(rcpt.rcptTo.String()))
+	qm := queue.MakeMsg(mox.Conf.Static.Postmaster.Account, smtp.Path{}, rcpt.srsTo, msgWriter.Has8bit, c.smtputf8, msgWriter.Size+int64(len(prefix)), "", prefix, nil)
+	if err := queue.Add(ctx, c.log, &qm, dataFile); err != nil {
+		c.log.Errorx("queueing srs bounce", err)
+		metricDelivery.WithLabelValues("delivererror", "srsbounce").Inc()
+		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error processing")
+	}
+	metricDelivery.WithLabelValues("forwarded", "srsbounce").Inc()
+	c.log.Info("srs bounce queued for original sender", slog.Any("rcptto", rcpt.rcptTo), slog.Any("sender", rcpt.srsTo))
+
+	c.transactionGood++
+	c.transactionBad-- // Compensate for early earlier pessimistic increase.
+	c.rset()
+	c.writecodeline(smtp.C250Completed, smtp.SeMailbox2Other0, "it is done", nil)
+}
+
+var errForwardLoop = errors.New("message was delivered to recipient before, possible forwarding loop")
+
+// forwardDestination forwards the message to the ForwardTo addresses of the
+// destination of rcptAcc. An error is returned if the message could not be
+// queued for any of the addresses.
+func forwardDestination(ctx context.Context, log mlog.Log, acc *store.Account, m *store.Message, dataFile *os.File, mailFrom smtp.Path, rcptAcc rcptAccount, prefix []byte, has8bit, smtputf8 bool, messageID string) error {
+	// The message prefix includes our own Delivered-To header.
+	p, err := message.EnsurePart(log.Logger, false, store.FileMsgReader(m.MsgPrefix, dataFile), m.Size)
+	log.Check(err, "parsing message for forwarding")
+	if h, err := p.Header(); err == nil && deliveredTo(h, rcptAcc.rcptTo) {
+		return errForwardLoop
+	}
+
+	var queued bool
+	for _, s := range rcptAcc.destination.ForwardTo {
+		to, err := smtp.ParseAddress(s)
+		if err == nil {
+			err = forwardMessage(ctx, log, acc, m, dataFile, mailFrom, rcptAcc.rcptTo, to.Path(), prefix, has8bit, smtputf8, messageID)
+		}
+		if err != nil {
+			log.Errorx("forwarding message", err, slog.String("to", s))
+			continue
+		}
+		log.Info("message forwarded", slog.String("to", s))
+		queued = true
+	}
+	if !queued {
+		return errors.New("forwarding message failed")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package smtpserver
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/mjl-/mox/srs"
+	"github.com/mjl-/mox/store"
+)
+
+// Test forwarding for destinations with ForwardTo, and routing bounces for SRS
+// addresses back to the original sender.
+func TestForwardTo(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"other.example.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"other.example."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtpserverforward/mox.conf"), resolver)
+	defer ts.close()
+
+	testDeliver := func(mailFrom, rcptTo, msg string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(err error, client *smtpclient.Client) {
+			t.Helper()
+			if err == nil {
+				err = client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false)
+			}
+			var cerr smtpclient.Error
+			if expErr == nil && err != nil || expErr != nil && (err == nil || !errors.As(err, &cerr) || cerr.Secode != expErr.Secode) {
+				t.Fatalf("got err %#v, expected %#v", err, expErr)
+			}
+		})
+	}
+
+	checkCounts := func(expQueued, expDelivered int) {
+		t.Helper()
+		n, err := queue.Count(ctxbg)
+		tcheck(t, err, "count queue")
+		tcompare(t, n, expQueued)
+		n, err = bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Count()
+		tcheck(t, err, "count messages")
+		tcompare(t, n, expDelivered)
+	}
+
+	// Forwarded without local copy, with rewritten envelope sender.
+	testDeliver("remote@other.example", "forward@mox.example", deliverMessage, nil)
+	checkCounts(1, 0)
+	msgs, err := queue.List(ctxbg)
+	tcheck(t, err, "list queue")
+	qm := msgs[0]
+	tcompare(t, qm.Recipient().String(), "fwd@remote.example")
+	tcompare(t, srs.IsSRS(qm.SenderLocalpart), true)
+	tcompare(t, qm.SenderDomain.Domain.ASCII, "mox.example")
+
+	// Forwarded with local copy.
+	testDeliver("remote@other.example", "copy@mox.example", deliverMessage2, nil)
+	checkCounts(2, 1)
+
+	// Message that was delivered to the destination before is not forwarded again.
+	loopMsg := "Delivered-To: forward@mox.example\r\n" + deliverMessage
+	testDeliver("remote@other.example", "forward@mox.example", loopMsg, &smtpclient.Error{Code: smtp.C554TransactionFailed, Secode: smtp.SeNet4Loop6})
+	checkCounts(2, 1)
+
+	// Bounce to the SRS address is queued for the original sender.
+	testDeliver("", qm.Sender().String(), deliverMessage, nil)
+	checkCounts(3, 1)
+	msgs, err = queue.List(ctxbg)
+	tcheck(t, err, "list queue")
+	var bounced bool
+	for _, m := range msgs {
+		bounced = bounced || m.Sender().IsZero() && m.Recipient().String() == "remote@other.example"
+	}
+	tcompare(t, bounced, true)
+
+	// A tampered SRS address is not routed, but handled as a regular destination,
+	// here delivered to the catchall account.
+	bad := qm.Sender()
+	bad.Localpart = smtp.Localpart(strings.Replace(string(bad.Localpart), "remote", "other", 1))
+	testDeliver("", bad.String(), deliverMessage, nil)
+	checkCounts(3, 1)
+}
@@ -0,0 +1,125 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/mox/dmarc"
+	"github.com/mjl-/mox/dsn"
+	"github.com/mjl-/mox/mlist"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// listResult is the outcome of handling a message for a mailing list address. A
+// zero code indicates success.
+type listResult struct {
+	code      int
+	secode    string
+	userError bool
+	errmsg    string
+}
+
+var listErrorProcessing = listResult{smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing"}
+
+// deliverList handles an incoming message for a mailing list address: a post to
+// the list, a request to one of its command addresses, or a bounce for a message
+// sent to a subscriber. Posts have already been checked for junk and delivery
+// rates with the account of the first local list owner, and are only accepted from
+// senders allowed by the post policy of the list.
+func (c *conn) deliverList(ctx context.Context, log mlog.Log, t mlist.Target, dataFile *os.File, headers textproto.MIMEHeader, msgFrom smtp.Address, msgFromValidation store.Validation, dmarcUse bool, dmarcResult dmarc.Result, recvHdr string, has8bit bool) listResult {
+	log = log.With(slog.Any("list", t.Address), slog.String("command", t.Command))
+	validated := msgFromValidation == store.ValidationStrict || msgFromValidation == store.ValidationDMARC || msgFromValidation == store.ValidationRelaxed
+	messageID := strings.TrimSpace(headers.Get("Message-Id"))
+
+	switch t.Command {
+	case mlist.CommandPost:
+		listID := strings.ToLower("<" + string(t.Address.Localpart) + "." + t.Address.Domain.ASCII + ">")
+		for _, v := range headers.Values("List-Id") {
+			if strings.Contains(strings.ToLower(v), listID) {
+				metricDelivery.WithLabelValues("reject", "mlistloop").Inc()
+				return listResult{smtp.C554TransactionFailed, smtp.SeNet4Loop6, true, "message was already sent to list, possible loop"}
+			}
+		}
+		if dmarcUse && dmarcResult.Reject {
+			metricDelivery.WithLabelValues("reject", reasonDMARCPolicy).Inc()
+			return listResult{smtp.C550MailboxUnavail, smtp.SePol7MultiAuthFails26, true, "rejecting per dmarc policy"}
+		}
+		if ok, err := mlist.PostAllowed(ctx, t, msgFrom, validated); err != nil {
+			log.Errorx("checking if sender can post to list", err)
+			return listErrorProcessing
+		} else if !ok {
+			log.Info("message from address not allowed to post to list", slog.Any("msgfrom", msgFrom), slog.Any("validation", msgFromValidation))
+			metricDelivery.WithLabelValues("reject", "mlistnotallowed").Inc()
+			return listResult{smtp.C550MailboxUnavail, smtp.SePol7DeliveryUnauth1, true, "not allowed to post to list"}
+		}
+		// Messages from domains with a DMARC policy that subscribers would enforce are
+		// rewritten, the signature of the sender may not survive the list.
+		rewriteFrom := t.List.FromRewrite && dmarcResult.Record != nil && dmarcResult.Record.Policy != dmarc.PolicyNone
+		if _, err := mlist.Distribute(ctx, log, t, dataFile, recvHdr, rewriteFrom, has8bit, c.smtputf8, messageID); err != nil {
+			log.Errorx("distributing message to list subscribers", err)
+			metricDelivery.WithLabelValues("delivererror", "mlist").Inc()
+			return listErrorProcessing
+		}
+		metricDelivery.WithLabelValues("delivered", "mlist").Inc()
+
+	case mlist.CommandSubscribe, mlist.CommandUnsubscribe:
+		// Only for verified addresses, so we don't send confirmation requests to
+		// unsuspecting addresses.
+		if msgFrom.IsZero() || !validated {
+			metricDelivery.WithLabelValues("reject", "mlistnotverified").Inc()
+			return listResult{smtp.C550MailboxUnavail, smtp.SePol7DeliveryUnauth1, true, "message from address must be verified with dkim and/or spf"}
+		}
+		if err := mlist.RequestConfirm(ctx, log, t, msgFrom, t.Command == mlist.CommandSubscribe); err != nil {
+			log.Errorx("requesting confirmation for list subscription", err)
+			return listErrorProcessing
+		}
+
+	case mlist.CommandConfirm:
+		if err := mlist.Confirm(ctx, log, t, t.Token); errors.Is(err, mlist.ErrUnknownToken) {
+			return listResult{smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, true, "no such user"}
+		} else if err != nil {
+			log.Errorx("confirming list subscription request", err)
+			return listErrorProcessing
+		}
+
+	case mlist.CommandOwner:
+		if err := mlist.ForwardOwners(ctx, log, t, dataFile, *c.mailFrom, recvHdr, has8bit, c.smtputf8, messageID); err != nil {
+			log.Errorx("forwarding message to list owners", err)
+			return listErrorProcessing
+		}
+		metricDelivery.WithLabelValues("forwarded", "mlistowner").Inc()
+
+	case mlist.CommandBounces:
+		// Only delivery status notifications with a failure count as bounce. Other
+		// messages, such as automatic replies, are ignored.
+		var failed bool
+		if c.mailFrom.IsZero() {
+			if m, _, err := dsn.Parse(log.Logger, dataFile); err != nil {
+				log.Debugx("parsing message to list bounce address as dsn, ignoring", err)
+			} else {
+				for _, r := range m.Recipients {
+					failed = failed || r.Action == dsn.Failed
+				}
+			}
+		}
+		if !failed {
+			log.Info("ignoring message to list bounce address that is not a delivery failure")
+			return listResult{}
+		}
+		if err := mlist.Bounce(ctx, log, t, t.Token); errors.Is(err, mlist.ErrUnknownToken) {
+			return listResult{smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, true, "no such user"}
+		} else if err != nil {
+			log.Errorx("registering bounce for list subscriber", err)
+			return listErrorProcessing
+		}
+		log.Info("bounce registered for list subscriber")
+	}
+	return listResult{}
+}
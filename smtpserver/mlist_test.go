@@ -0,0 +1,136 @@
+package smtpserver
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/dsn"
+	"github.com/mjl-/mox/mlist"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/mjl-/mox/store"
+)
+
+// Test subscribing to a mailing list, posting, and bounces for subscribers.
+func TestMailingList(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtpservermlist/mox.conf"), resolver)
+	defer ts.close()
+
+	testDeliver := func(mailFrom, rcptTo, msg string, expErr *smtpclient.Error) {
+		t.Helper()
+		ts.run(func(err error, client *smtpclient.Client) {
+			t.Helper()
+			if err == nil {
+				err = client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(msg)), strings.NewReader(msg), false, false, false)
+			}
+			var cerr smtpclient.Error
+			if expErr == nil && err != nil || expErr != nil && (err == nil || !errors.As(err, &cerr) || cerr.Secode != expErr.Secode) {
+				t.Fatalf("got err %#v, expected %#v", err, expErr)
+			}
+		})
+	}
+
+	checkQueued := func(exp int) []queue.Msg {
+		t.Helper()
+		msgs, err := queue.List(ctxbg)
+		tcheck(t, err, "list queue")
+		tcompare(t, len(msgs), exp)
+		return msgs
+	}
+
+	// Subscription request, a confirmation request is sent.
+	testDeliver("remote@example.org", "team-subscribe@mox.example", deliverMessage, nil)
+	msgs := checkQueued(1)
+	tcompare(t, msgs[0].Recipient().String(), "remote@example.org")
+	tcompare(t, msgs[0].Sender().IsZero(), true)
+
+	// Confirm, subscribed and a notice is sent.
+	p, err := bstore.QueryDB[mlist.Pending](ctxbg, mlist.DB).Get()
+	tcheck(t, err, "get pending request")
+	testDeliver("remote@example.org", "team-confirm-"+p.Token+"@mox.example", deliverMessage, nil)
+	checkQueued(2)
+	subs, err := mlist.Subscribers(ctxbg, smtp.NewAddress("team", dns.Domain{ASCII: "mox.example"}))
+	tcheck(t, err, "list subscribers")
+	tcompare(t, len(subs), 1)
+	sub := subs[0]
+	tcompare(t, sub.Address, "remote@example.org")
+
+	// Token can only be used once.
+	testDeliver("remote@example.org", "team-confirm-"+p.Token+"@mox.example", deliverMessage, &smtpclient.Error{Code: smtp.C550MailboxUnavail, Secode: smtp.SeAddr1UnknownDestMailbox1})
+
+	// Post by subscriber, sent to the subscriber with the bounce address as sender.
+	testDeliver("remote@example.org", "team@mox.example", deliverMessage, nil)
+	msgs = checkQueued(3)
+	qm := msgs[2]
+	tcompare(t, qm.Sender().String(), "team-bounces-"+sub.Token+"@mox.example")
+	tcompare(t, strings.Contains(string(qm.MsgPrefix), "List-Unsubscribe: <mailto:team-unsubscribe@mox.example>"), true)
+
+	// Post from address that is not subscribed.
+	unauth := smtpclient.Error{Code: smtp.C550MailboxUnavail, Secode: smtp.SePol7DeliveryUnauth1}
+	msg := strings.Replace(deliverMessage, "From: <remote@example.org>", "From: <other@example.org>", 1)
+	testDeliver("remote@example.org", "team@mox.example", msg, &unauth)
+
+	// Message that was already sent by the list.
+	msg = "List-Id: Team list <team.mox.example>\r\n" + deliverMessage
+	testDeliver("remote@example.org", "team@mox.example", msg, &smtpclient.Error{Code: smtp.C554TransactionFailed, Secode: smtp.SeNet4Loop6})
+	checkQueued(3)
+
+	// Message for owners is forwarded.
+	testDeliver("remote@example.org", "team-owner@mox.example", deliverMessage, nil)
+	msgs = checkQueued(5)
+	tcompare(t, msgs[3].Recipient().String(), "owner@remote.example")
+	tcompare(t, msgs[4].Recipient().String(), "mjl@mox.example")
+
+	// Bounces disable delivery to the subscriber.
+	dsnMsg := dsn.Message{
+		From:         smtp.Path{Localpart: "postmaster", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "example.org"}}},
+		To:           qm.Sender(),
+		Subject:      "mail delivery failed",
+		MessageID:    "<dsn@example.org>",
+		TextBody:     "delivery failed\n",
+		ReportingMTA: "example.org",
+		Recipients: []dsn.Recipient{
+			{FinalRecipient: qm.Recipient(), Action: dsn.Failed, Status: "5.1.1", LastAttemptDate: time.Now()},
+		},
+	}
+	dsnBuf, err := dsnMsg.Compose(pkglog, false)
+	tcheck(t, err, "compose dsn")
+	for i := 0; i < 5; i++ {
+		testDeliver("", qm.Sender().String(), string(dsnBuf), nil)
+	}
+	subs, err = mlist.Subscribers(ctxbg, smtp.NewAddress("team", dns.Domain{ASCII: "mox.example"}))
+	tcheck(t, err, "list subscribers")
+	tcompare(t, subs[0].Bounces, 5)
+	tcompare(t, subs[0].Disabled, true)
+
+	// Not sent to disabled subscriber.
+	testDeliver("remote@example.org", "team@mox.example", deliverMessage, nil)
+	checkQueued(5)
+
+	// Posts are checked for junk with the account of the owner. After many messages
+	// marked as junk from the sender in that account, posts are rejected.
+	for i := 0; i < 3; i++ {
+		tinsertmsg(t, ts.acc, "Inbox", &store.Message{MsgFromLocalpart: "remote", MsgFromDomain: "example.org", MsgFromOrgDomain: "example.org", MsgFromValidated: true, MsgFromValidation: store.ValidationStrict, Flags: store.Flags{Junk: true}, Size: int64(len(deliverMessage))}, deliverMessage)
+	}
+	testDeliver("remote@example.org", "team@mox.example", deliverMessage, &smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
+	checkQueued(5)
+}
@@ -44,6 +44,7 @@ import (
 	"github.com/mjl-/mox/iprev"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlist"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxio"
@@ -52,6 +53,7 @@ import (
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/ratelimit"
 	"github.com/mjl-/mox/scram"
+	"github.com/mjl-/mox/sieve"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/spf"
 	"github.com/mjl-/mox/store"
@@ -137,7 +139,7 @@ var (
 	metricDelivery = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_smtpserver_delivery_total",
-			Help: "SMTP incoming message delivery from external source, not submission. Result values: delivered, reject, unknownuser, accounterror, delivererror. Reason indicates why a message was rejected/accepted.",
+			Help: "SMTP incoming message delivery from external source, not submission. Result values: delivered, reject, unknownuser, accounterror, delivererror, forwarded. Reason indicates why a message was rejected/accepted.",
 		},
 		[]string{
 			"result",
@@ -336,6 +338,16 @@ type rcptAccount struct {
 	accountName      string
 	destination      config.Destination
 	canonicalAddress string // Optional catchall part stripped and/or lowercased.
+
+	// For SRS addresses of forwarded messages, the original sender to route the
+	// bounce to.
+	srsTo smtp.Path
+
+	// For alias recipients, and the members they are expanded to during delivery.
+	alias *config.Alias
+
+	// For mailing list addresses, including command addresses.
+	list *mlist.Target
 }
 
 func isClosed(err error) bool {
@@ -935,6 +947,16 @@ func (c *conn) cmdStarttls(p *parser) {
 }
 
 // ../rfc/4954:139
+// credential returns the credential used for authentication for metrics, for an
+// app password name as returned by the store, empty for the account password.
+func (c *conn) credential(appPassword string) string {
+	if appPassword == "" {
+		return "password"
+	}
+	c.log.Debug("authenticated with app password", slog.String("apppassword", appPassword))
+	return "apppassword"
+}
+
 func (c *conn) cmdAuth(p *parser) {
 	c.xneedHello()
 
@@ -968,10 +990,10 @@ func (c *conn) cmdAuth(p *parser) {
 		}
 	}()
 
-	var authVariant string
+	var authVariant, authCredential string
 	authResult := "error"
 	defer func() {
-		metrics.AuthenticationInc("submission", authVariant, authResult)
+		metrics.AuthenticationInc("submission", authVariant, authCredential, authResult)
 		switch authResult {
 		case "ok":
 			mox.LimiterFailedAuth.Reset(c.remoteIP, time.Now())
@@ -1061,7 +1083,7 @@ func (c *conn) cmdAuth(p *parser) {
 			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "cannot assume other role")
 		}
 
-		acc, err := store.OpenEmailAuth(c.log, authc, password)
+		acc, appPassword, err := store.OpenEmailAuth(c.log, authc, password, store.AuthScopeSubmission)
 		if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
 			// ../rfc/4954:274
 			authResult = "badcreds"
@@ -1069,6 +1091,7 @@ func (c *conn) cmdAuth(p *parser) {
 			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad user/pass")
 		}
 		xcheckf(err, "verifying credentials")
+		authCredential = c.credential(appPassword)
 
 		authResult = "ok"
 		c.authFailed = 0
@@ -1106,7 +1129,7 @@ func (c *conn) cmdAuth(p *parser) {
 		password := string(xreadContinuation())
 		c.xtrace(mlog.LevelTrace) // Restore.
 
-		acc, err := store.OpenEmailAuth(c.log, username, password)
+		acc, appPassword, err := store.OpenEmailAuth(c.log, username, password, store.AuthScopeSubmission)
 		if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
 			// ../rfc/4954:274
 			authResult = "badcreds"
@@ -1114,6 +1137,7 @@ func (c *conn) cmdAuth(p *parser) {
 			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad user/pass")
 		}
 		xcheckf(err, "verifying credentials")
+		authCredential = c.credential(appPassword)
 
 		authResult = "ok"
 		c.authFailed = 0
@@ -1153,10 +1177,12 @@ func (c *conn) cmdAuth(p *parser) {
 				c.log.Check(err, "closing account")
 			}
 		}()
-		var ipadhash, opadhash hash.Hash
+		var password store.Password
+		var appPasswords []store.AppPassword
 		acc.WithRLock(func() {
 			err := acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
-				password, err := bstore.QueryTx[store.Password](tx).Get()
+				var err error
+				password, err = bstore.QueryTx[store.Password](tx).Get()
 				if err == bstore.ErrAbsent {
 					c.log.Info("failed authentication attempt", slog.String("username", addr), slog.Any("remote", c.remoteIP))
 					xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad user/pass")
@@ -1164,27 +1190,21 @@ func (c *conn) cmdAuth(p *parser) {
 				if err != nil {
 					return err
 				}
-
-				ipadhash = password.CRAMMD5.Ipad
-				opadhash = password.CRAMMD5.Opad
-				return nil
+				appPasswords, err = store.AppPasswordsForScope(tx, store.AuthScopeSubmission)
+				return err
 			})
 			xcheckf(err, "tx read")
 		})
-		if ipadhash == nil || opadhash == nil {
+		if password.CRAMMD5.Ipad == nil || password.CRAMMD5.Opad == nil {
 			c.log.Info("cram-md5 auth attempt without derived secrets set, save password again to store secrets", slog.String("username", addr))
-			c.log.Info("failed authentication attempt", slog.String("username", addr), slog.Any("remote", c.remoteIP))
-			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad user/pass")
 		}
 
-		// ../rfc/2195:138 ../rfc/2104:142
-		ipadhash.Write([]byte(chal))
-		opadhash.Write(ipadhash.Sum(nil))
-		digest := fmt.Sprintf("%x", opadhash.Sum(nil))
-		if digest != t[1] {
+		ok, appPassword := acc.AuthCRAMMD5(c.log, password, appPasswords, chal, t[1])
+		if !ok {
 			c.log.Info("failed authentication attempt", slog.String("username", addr), slog.Any("remote", c.remoteIP))
 			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad user/pass")
 		}
+		authCredential = c.credential(appPassword)
 
 		authResult = "ok"
 		c.authFailed = 0
@@ -1244,6 +1264,7 @@ func (c *conn) cmdAuth(p *parser) {
 			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "authentication with authorization for different user not supported")
 		}
 		var xscram store.SCRAM
+		var appPasswords []store.AppPassword
 		acc.WithRLock(func() {
 			err := acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
 				password, err := bstore.QueryTx[store.Password](tx).Get()
@@ -1261,6 +1282,7 @@ func (c *conn) cmdAuth(p *parser) {
 					xsmtpUserErrorf(smtp.C454TempAuthFail, smtp.SeSys3Other0, "scram not possible")
 				}
 				xcheckf(err, "fetching credentials")
+				appPasswords, err = store.AppPasswordsForScope(tx, store.AuthScopeSubmission)
 				return err
 			})
 			xcheckf(err, "read tx")
@@ -1269,7 +1291,7 @@ func (c *conn) cmdAuth(p *parser) {
 		xcheckf(err, "scram first server step")
 		c.writelinef("%d %s", smtp.C334ContinueAuth, base64.StdEncoding.EncodeToString([]byte(s1))) // ../rfc/4954:187
 		c2 := xreadContinuation()
-		s3, err := ss.Finish(c2, xscram.SaltedPassword)
+		s3, appPassword, err := acc.AuthSCRAMFinish(c.log, ss, c2, xscram, appPasswords, h().Size() == sha256.Size)
 		if len(s3) > 0 {
 			c.writelinef("%d %s", smtp.C334ContinueAuth, base64.StdEncoding.EncodeToString([]byte(s3))) // ../rfc/4954:187
 		}
@@ -1287,6 +1309,7 @@ func (c *conn) cmdAuth(p *parser) {
 		// The message should be empty. todo: should we require it is empty?
 		xreadContinuation()
 
+		authCredential = c.credential(appPassword)
 		authResult = "ok"
 		c.authFailed = 0
 		c.setSlow(false)
@@ -1563,21 +1586,38 @@ func (c *conn) cmdRcpt(p *parser) {
 		// which is typically the mox user.
 		acc, _ := mox.Conf.Account("mox")
 		dest := acc.Destinations["mox@localhost"]
-		c.recipients = append(c.recipients, rcptAccount{fpath, true, "mox", dest, "mox@localhost"})
+		c.recipients = append(c.recipients, rcptAccount{fpath, true, "mox", dest, "mox@localhost", smtp.Path{}, nil, nil})
 	} else if len(fpath.IPDomain.IP) > 0 {
 		if !c.submission {
 			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "not accepting email for ip")
 		}
-		c.recipients = append(c.recipients, rcptAccount{fpath, false, "", config.Destination{}, ""})
+		c.recipients = append(c.recipients, rcptAccount{fpath, false, "", config.Destination{}, "", smtp.Path{}, nil, nil})
+	} else if srsTo, ok := c.srsBounce(fpath); ok {
+		// Checked before looking up the account, so catchall destinations don't receive
+		// bounces for forwarded messages.
+		c.recipients = append(c.recipients, rcptAccount{fpath, false, "", config.Destination{}, "", srsTo, nil, nil})
+	} else if t, ok := mlist.Lookup(fpath.Localpart, fpath.IPDomain.Domain); ok {
+		// Checked before looking up the account, so catchall destinations don't receive
+		// messages for mailing lists. Handled during delivery. Posts are checked with the
+		// account of the first local owner, but not delivered to it.
+		var accName string
+		if t.Command == mlist.CommandPost {
+			accName = t.List.OwnerAccount
+		}
+		c.recipients = append(c.recipients, rcptAccount{fpath, true, accName, config.Destination{}, t.Address.String(), smtp.Path{}, nil, &t})
+	} else if alias, canonical, ok := mox.FindAlias(fpath.Localpart, fpath.IPDomain.Domain); ok {
+		// Checked before looking up the account, so catchall destinations don't receive
+		// messages for aliases. Expanded to the members during delivery.
+		c.recipients = append(c.recipients, rcptAccount{fpath, true, "", config.Destination{}, canonical, smtp.Path{}, &alias, nil})
 	} else if accountName, canonical, addr, err := mox.FindAccount(fpath.Localpart, fpath.IPDomain.Domain, true); err == nil {
 		// note: a bare postmaster, without domain, is handled by FindAccount. ../rfc/5321:735
-		c.recipients = append(c.recipients, rcptAccount{fpath, true, accountName, addr, canonical})
+		c.recipients = append(c.recipients, rcptAccount{fpath, true, accountName, addr, canonical, smtp.Path{}, nil, nil})
 	} else if errors.Is(err, mox.ErrDomainNotFound) {
 		if !c.submission {
 			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "not accepting email for domain")
 		}
 		// We'll be delivering this email.
-		c.recipients = append(c.recipients, rcptAccount{fpath, false, "", config.Destination{}, ""})
+		c.recipients = append(c.recipients, rcptAccount{fpath, false, "", config.Destination{}, "", smtp.Path{}, nil, nil})
 	} else if errors.Is(err, mox.ErrAccountNotFound) {
 		if c.submission {
 			// For submission, we're transparent about which user exists. Should be fine for the typical small-scale deploy.
@@ -1587,7 +1627,7 @@ func (c *conn) cmdRcpt(p *parser) {
 		// We pretend to accept. We don't want to let remote know the user does not exist
 		// until after DATA. Because then remote has committed to sending a message.
 		// note: not local for !c.submission is the signal this address is in error.
-		c.recipients = append(c.recipients, rcptAccount{fpath, false, "", config.Destination{}, ""})
+		c.recipients = append(c.recipients, rcptAccount{fpath, false, "", config.Destination{}, "", smtp.Path{}, nil, nil})
 	} else {
 		c.log.Errorx("looking up account for delivery", err, slog.Any("rcptto", fpath))
 		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error processing")
@@ -1785,6 +1825,10 @@ func (c *conn) cmdData(p *parser) {
 	// internet traffic.
 	if c.submission {
 		c.submit(cmdctx, recvHdrFor, msgWriter, dataFile)
+	} else if len(c.recipients) == 1 && !c.recipients[0].srsTo.IsZero() {
+		// Bounce for a forwarded message, the null reverse path allows only a single
+		// recipient.
+		c.deliverSRSBounce(cmdctx, recvHdrFor, msgWriter, dataFile)
 	} else {
 		c.deliver(cmdctx, recvHdrFor, msgWriter, iprevStatus, iprevAuthentic, dataFile)
 	}
@@ -2364,6 +2408,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		errmsg    string
 	}
 	var deliverErrors []deliverError
+	aliasErrors := map[*config.Alias][]deliverError{} // Errors for members of aliases.
 	addError := func(rcptAcc rcptAccount, code int, secode string, userError bool, errmsg string) {
 		e := deliverError{rcptAcc.rcptTo, code, secode, userError, errmsg}
 		c.log.Info("deliver error",
@@ -2372,12 +2417,33 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			slog.String("secode", "secode"),
 			slog.Bool("usererror", userError),
 			slog.String("errmsg", errmsg))
+		if rcptAcc.alias != nil {
+			aliasErrors[rcptAcc.alias] = append(aliasErrors[rcptAcc.alias], e)
+			return
+		}
 		deliverErrors = append(deliverErrors, e)
 	}
 
+	// Aliases are expanded into their members. Delivery to an alias only fails if
+	// delivery to all of its members failed, errors are gathered per alias.
+	var rcpts []rcptAccount
+	aliasMembers := map[*config.Alias]int{}
+	for _, r := range c.recipients {
+		if r.alias == nil {
+			rcpts = append(rcpts, r)
+			continue
+		}
+		l := expandAlias(r)
+		aliasMembers[r.alias] = len(l)
+		rcpts = append(rcpts, l...)
+	}
+
 	// For each recipient, do final spam analysis and delivery.
-	for _, rcptAcc := range c.recipients {
+	for _, rcptAcc := range rcpts {
 		log := c.log.With(slog.Any("mailfrom", c.mailFrom), slog.Any("rcptto", rcptAcc.rcptTo))
+		if rcptAcc.alias != nil {
+			log = log.With(slog.String("member", rcptAcc.canonicalAddress))
+		}
 
 		// If this is not a valid local user, we send back a DSN. This can only happen when
 		// there are also valid recipients, and only when remote is SPF-verified, so the DSN
@@ -2393,6 +2459,21 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			continue
 		}
 
+		if rcptAcc.list != nil && rcptAcc.list.Command != mlist.CommandPost {
+			r := c.deliverList(ctx, log, *rcptAcc.list, dataFile, headers, msgFrom, msgFromValidation, dmarcUse, dmarcResult, recvHdrFor(rcptAcc.rcptTo.String()), msgWriter.Has8bit)
+			if r.code != 0 {
+				addError(rcptAcc, r.code, r.secode, r.userError, r.errmsg)
+			}
+			continue
+		}
+
+		if rcptAcc.alias != nil && !aliasAllowed(*rcptAcc.alias, msgFrom, msgFromValidation) {
+			log.Info("message from address not allowed to send to alias", slog.Any("msgfrom", msgFrom), slog.Any("validation", msgFromValidation))
+			metricDelivery.WithLabelValues("reject", "aliasnotallowed").Inc()
+			addError(rcptAcc, smtp.C550MailboxUnavail, smtp.SePol7DeliveryUnauth1, true, "not allowed to send to alias")
+			continue
+		}
+
 		acc, err := store.OpenAccount(log, rcptAcc.accountName)
 		if err != nil {
 			log.Errorx("open account", err, slog.Any("account", rcptAcc.accountName))
@@ -2715,6 +2796,24 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			log.Check(err, "adding dmarc evaluation to database for aggregate report")
 		}
 
+		// Posts to mailing lists have been checked for junk and delivery rates with the
+		// account of the list owner, they are distributed instead of delivered. Rejected
+		// posts are not stored in the rejects mailbox of the owner.
+		if rcptAcc.list != nil {
+			if !a.accept {
+				log.Info("post to mailing list rejected", slog.String("reason", a.reason), slog.Any("msgfrom", msgFrom))
+				metricDelivery.WithLabelValues("reject", a.reason).Inc()
+				c.setSlow(true)
+				addError(rcptAcc, a.code, a.secode, a.userError, a.errmsg)
+			} else if r := c.deliverList(ctx, log, *rcptAcc.list, dataFile, headers, msgFrom, msgFromValidation, dmarcUse, dmarcResult, recvHdrFor(rcptAcc.rcptTo.String()), msgWriter.Has8bit); r.code != 0 {
+				addError(rcptAcc, r.code, r.secode, r.userError, r.errmsg)
+			}
+			err = acc.Close()
+			log.Check(err, "closing account after checking mailing list post")
+			acc = nil
+			continue
+		}
+
 		if !a.accept {
 			conf, _ := acc.Conf()
 			if conf.RejectsMailbox != "" {
@@ -2728,6 +2827,9 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 					// default is to treat these as neutral, so they won't cause outright rejections
 					// due to reputation for later delivery attempts.
 					m.MessageHash = messagehash
+					if err := acc.PrepareAttachmentText(log, &m, dataFile); err != nil {
+						log.Errorx("preparing message for delivery, continuing", err)
+					}
 					acc.WithWLock(func() {
 						hasSpace := true
 						var err error
@@ -2780,6 +2882,27 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			}
 		}
 
+		// Destinations can forward messages to external addresses, optionally keeping a
+		// copy in the account. Reports to reporting addresses are not forwarded.
+		forward := len(rcptAcc.destination.ForwardTo) > 0 && a.dmarcReport == nil && a.tlsReport == nil
+		forwardOnly := forward && !rcptAcc.destination.ForwardKeepCopy
+		forwardPrefix := []byte("Delivered-To: " + rcptAcc.rcptTo.XString(c.smtputf8) + "\r\n" + rcptAuthResults.Header() + receivedSPF.Header() + recvHdrFor(rcptAcc.rcptTo.String()))
+
+		// Evaluate the active sieve script of the account, if any. Reports to reporting
+		// addresses are not filtered, and neither are messages that are only forwarded.
+		var sieveResult *sieve.Result
+		var sievePart *message.Part
+		if a.dmarcReport == nil && a.tlsReport == nil && !forwardOnly {
+			sieveResult, sievePart = sieveEvaluate(log, acc, &m, dataFile, *c.mailFrom, rcptAcc.rcptTo)
+		}
+		if sieveResult != nil && sieveResult.Reject {
+			// We reject during the SMTP transaction, instead of sending a DSN. ../rfc/5429:240
+			log.Info("incoming message rejected by sieve script", slog.String("reason", sieveResult.RejectReason))
+			metricDelivery.WithLabelValues("reject", "sieve").Inc()
+			addError(rcptAcc, smtp.C550MailboxUnavail, smtp.SePol7Other0, true, sieveRejectMessage(sieveResult.RejectReason))
+			continue
+		}
+
 		// If this is a first-time sender and not a forwarded message, wait before actually
 		// delivering. If this turns out to be a spammer, we've kept one of their
 		// connections busy.
@@ -2811,19 +2934,76 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				addError(rcptAcc, code, smtp.SeOther00, false, fmt.Sprintf("failure with code %d due to special localpart", code))
 			}
 		}
+
+		if forwardOnly {
+			err := forwardDestination(ctx, log, acc, &m, dataFile, *c.mailFrom, rcptAcc, forwardPrefix, msgWriter.Has8bit, c.smtputf8, messageID)
+			if errors.Is(err, errForwardLoop) {
+				log.Info("not forwarding message", slog.Any("err", err))
+				metricDelivery.WithLabelValues("reject", "forwardloop").Inc()
+				addError(rcptAcc, smtp.C554TransactionFailed, smtp.SeNet4Loop6, true, "forwarding loop detected")
+			} else if err != nil {
+				metricDelivery.WithLabelValues("delivererror", "forward").Inc()
+				addError(rcptAcc, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing")
+			} else {
+				metricDelivery.WithLabelValues("forwarded", a.reason).Inc()
+			}
+			continue
+		}
+
+		deliveries := []sieve.Fileinto{{Mailbox: a.mailbox}}
+		if sieveResult != nil {
+			deliveries = sieveDeliveries(log, a.mailbox, *sieveResult)
+		}
+		// Text extraction from attachments can take a while, do it before locking.
+		if err := acc.PrepareAttachmentText(log, &m, dataFile); err != nil {
+			log.Errorx("preparing message for delivery, continuing", err)
+		}
+		var delivered bool
 		acc.WithWLock(func() {
-			if err := acc.DeliverMailbox(log, a.mailbox, &m, dataFile); err != nil {
-				log.Errorx("delivering", err)
-				metricDelivery.WithLabelValues("delivererror", a.reason).Inc()
-				if errors.Is(err, store.ErrOverQuota) {
+			// With multiple sieve fileinto mailboxes, the message counts as delivered once
+			// it is in one of them. Failures for the others are only logged: returning an
+			// error would make the sender retry, delivering duplicates to the mailboxes
+			// that succeeded.
+			origm := m
+			var ndelivered int
+			var firstErr error
+			for _, d := range deliveries {
+				dm := origm
+				sieveFlags(log, &dm, d.Flags)
+				if err := acc.DeliverMailbox(log, d.Mailbox, &dm, dataFile); err != nil {
+					log.Errorx("delivering", err, slog.String("mailbox", d.Mailbox))
+					metricDelivery.WithLabelValues("delivererror", a.reason).Inc()
+					if firstErr == nil {
+						firstErr = err
+					}
+					continue
+				}
+				if len(dm.Keywords) > 0 {
+					err := sieveMailboxKeywords(ctx, acc, dm.MailboxID, dm.Keywords)
+					log.Check(err, "adding sieve keywords to mailbox")
+				}
+				if ndelivered == 0 {
+					m = dm
+				}
+				ndelivered++
+				metricDelivery.WithLabelValues("delivered", a.reason).Inc()
+				log.Info("incoming message delivered", slog.String("reason", a.reason), slog.Any("msgfrom", msgFrom), slog.String("mailbox", d.Mailbox))
+			}
+			if firstErr != nil && ndelivered == 0 {
+				if errors.Is(firstErr, store.ErrOverQuota) {
 					addError(rcptAcc, smtp.C452StorageFull, smtp.SeMailbox2Full2, true, "account storage full")
 				} else {
 					addError(rcptAcc, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing")
 				}
 				return
+			} else if firstErr != nil {
+				log.Error("message delivered to only some of the sieve mailboxes, not retrying", slog.Int("delivered", ndelivered), slog.Int("mailboxes", len(deliveries)))
 			}
-			metricDelivery.WithLabelValues("delivered", a.reason).Inc()
-			log.Info("incoming message delivered", slog.String("reason", a.reason), slog.Any("msgfrom", msgFrom))
+			if len(deliveries) == 0 {
+				metricDelivery.WithLabelValues("discarded", "sieve").Inc()
+				log.Info("incoming message discarded by sieve script", slog.Any("msgfrom", msgFrom))
+			}
+			delivered = true
 
 			conf, _ := acc.Conf()
 			if conf.RejectsMailbox != "" && m.MessageID != "" {
@@ -2833,11 +3013,81 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			}
 		})
 
+		// Execute remaining sieve actions, only after delivery succeeded, we don't want
+		// to repeat them when the sender retries.
+		if delivered && sieveResult != nil && sievePart != nil {
+			h, err := sievePart.Header()
+			if err != nil {
+				h = nil
+			}
+			if len(sieveResult.Redirects) > 0 && deliveredTo(h, rcptAcc.rcptTo) {
+				log.Info("not executing sieve redirect, message already delivered to recipient before, possible loop")
+			} else {
+				for _, to := range sieveResult.Redirects {
+					err := forwardMessage(ctx, log, acc, &m, dataFile, *c.mailFrom, rcptAcc.rcptTo, to.Path(), forwardPrefix, msgWriter.Has8bit, c.smtputf8, messageID)
+					if err != nil {
+						log.Errorx("redirecting message for sieve script", err, slog.Any("to", to))
+					} else {
+						log.Info("message redirected by sieve script", slog.Any("to", to))
+					}
+				}
+			}
+			if sieveResult.Vacation != nil {
+				reply := sieveVacation(log, acc.Name, *sieveResult.Vacation, rcptAcc.rcptTo)
+				err := queueAutoReply(ctx, log, acc, &m, sievePart, *c.mailFrom, reply)
+				log.Check(err, "sending sieve vacation response")
+			}
+		}
+
+		// Forward a copy, only after local delivery succeeded.
+		if delivered && forward {
+			err := forwardDestination(ctx, log, acc, &m, dataFile, *c.mailFrom, rcptAcc, forwardPrefix, msgWriter.Has8bit, c.smtputf8, messageID)
+			log.Check(err, "forwarding message")
+		}
+
+		// Send a response for the auto-responder of the account, unless a sieve vacation
+		// response was already considered. Reports to reporting addresses never get a
+		// response.
+		if delivered && a.dmarcReport == nil && a.tlsReport == nil && (sieveResult == nil || sieveResult.Vacation == nil) {
+			if reply, ok, err := accountAutoReply(ctx, acc, rcptAcc.rcptTo); err != nil {
+				log.Errorx("checking automatic reply", err)
+			} else if ok {
+				part := sievePart
+				if part == nil {
+					p, err := message.EnsurePart(log.Logger, false, store.FileMsgReader(m.MsgPrefix, dataFile), m.Size)
+					log.Check(err, "parsing message for automatic reply")
+					part = &p
+				}
+				err := queueAutoReply(ctx, log, acc, &m, part, *c.mailFrom, reply)
+				log.Check(err, "sending automatic reply")
+			}
+		}
+
 		err = acc.Close()
 		log.Check(err, "closing account after delivering")
 		acc = nil
 	}
 
+	// If delivery failed for only some members of an alias, the message is accepted
+	// for the alias, and the sender gets a DSN for the failed members. Returning an
+	// error would make the sender retry, delivering duplicates to the other members.
+	// The DSN has the alias address as recipient, not revealing the members.
+	var partialErrors []deliverError
+	for _, r := range c.recipients {
+		if r.alias == nil {
+			continue
+		}
+		if l := aliasErrors[r.alias]; len(l) > 0 && len(l) == aliasMembers[r.alias] {
+			deliverErrors = append(deliverErrors, l[0])
+		} else if len(l) > 0 {
+			e := l[0]
+			e.errmsg = "delivery to some members of alias failed: " + e.errmsg
+			partialErrors = append(partialErrors, e)
+		} else if aliasMembers[r.alias] == 0 {
+			addError(rcptAccount{rcptTo: r.rcptTo}, smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, true, "no such user")
+		}
+	}
+
 	// If all recipients failed to deliver, return an error.
 	if len(c.recipients) == len(deliverErrors) {
 		same := true
@@ -2874,6 +3124,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		xsmtpErrorf(code, secode, !serverError, strings.Join(lines, "\n"))
 	}
 	// Generate one DSN for all failed recipients.
+	deliverErrors = append(deliverErrors, partialErrors...)
 	if len(deliverErrors) > 0 {
 		now := time.Now()
 		dsnMsg := dsn.Message{
@@ -31,6 +31,7 @@ import (
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlist"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/queue"
@@ -134,6 +135,7 @@ func (ts *testserver) close() {
 	}
 	ts.comm.Unregister()
 	queue.Shutdown()
+	mlist.Close()
 	ts.switchStop()
 	err := ts.acc.Close()
 	tcheck(ts.t, err, "closing account")
@@ -0,0 +1,184 @@
+package smtpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/sieve"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// sieveEvaluate evaluates the active sieve script of the account, if any, for
+// an incoming message. If no script is active, or the script could not be
+// evaluated, nil is returned and the message is delivered as without script. The
+// parsed message is returned too, for use in sieve actions.
+func sieveEvaluate(log mlog.Log, acc *store.Account, m *store.Message, dataFile *os.File, mailFrom, rcptTo smtp.Path) (*sieve.Result, *message.Part) {
+	var script *sieve.Script
+	err := acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+		var err error
+		script, err = acc.SieveActive(tx)
+		return err
+	})
+	if err != nil {
+		log.Errorx("getting active sieve script, continuing without", err)
+		return nil, nil
+	} else if script == nil {
+		return nil, nil
+	}
+
+	part, err := message.EnsurePart(log.Logger, false, store.FileMsgReader(m.MsgPrefix, dataFile), m.Size)
+	if err != nil {
+		log.Debugx("parsing message for sieve, continuing", err)
+	}
+	msg := sieve.Message{
+		EnvelopeFrom: mailFrom.String(),
+		EnvelopeTo:   rcptTo.String(),
+		Size:         m.Size,
+		Part:         &part,
+	}
+	r, err := script.Evaluate(msg)
+	if err != nil {
+		// Result is an implicit keep.
+		log.Infox("evaluating sieve script, delivering to default mailbox", err)
+	}
+	log.Debug("sieve script evaluated",
+		slog.Bool("keep", r.Keep),
+		slog.Any("fileinto", r.Fileinto),
+		slog.Any("redirects", r.Redirects),
+		slog.Bool("reject", r.Reject),
+		slog.Bool("vacation", r.Vacation != nil))
+	return &r, &part
+}
+
+// sieveDeliveries returns the mailboxes, with flags, to deliver to according
+// to a sieve result. Invalid mailbox names are replaced with the default mailbox.
+// If the list is empty, the message was discarded.
+func sieveDeliveries(log mlog.Log, defaultMailbox string, r sieve.Result) []sieve.Fileinto {
+	var l []sieve.Fileinto
+	add := func(mailbox string, flags []string) {
+		for _, f := range l {
+			if f.Mailbox == mailbox {
+				return
+			}
+		}
+		l = append(l, sieve.Fileinto{Mailbox: mailbox, Flags: flags})
+	}
+	if r.Keep {
+		add(defaultMailbox, r.KeepFlags)
+	}
+	for _, f := range r.Fileinto {
+		name, _, err := store.CheckMailboxName(f.Mailbox, true)
+		if err != nil {
+			log.Infox("invalid mailbox name in sieve fileinto, delivering to default mailbox", err, slog.String("mailbox", f.Mailbox))
+			name = defaultMailbox
+		}
+		add(name, f.Flags)
+	}
+	return l
+}
+
+// sieveFlags sets the flags and keywords from a sieve result on m. Invalid
+// keywords are ignored.
+func sieveFlags(log mlog.Log, m *store.Message, flags []string) {
+	var l []string
+	for _, f := range flags {
+		if _, _, err := store.ParseFlagsKeywords([]string{f}); err != nil {
+			log.Debugx("ignoring invalid flag from sieve script", err, slog.String("flag", f))
+			continue
+		}
+		l = append(l, f)
+	}
+	fl, keywords, _ := store.ParseFlagsKeywords(l)
+	m.Flags = m.Flags.Set(fl, fl)
+	m.Keywords = keywords
+}
+
+// sieveMailboxKeywords adds keywords set by a sieve script on a delivered message
+// to the keywords of its mailbox, so clients learn about them. Must be called with
+// the account write lock held.
+func sieveMailboxKeywords(ctx context.Context, acc *store.Account, mailboxID int64, keywords []string) error {
+	var changes []store.Change
+	err := acc.DB.Write(ctx, func(tx *bstore.Tx) error {
+		mb := store.Mailbox{ID: mailboxID}
+		if err := tx.Get(&mb); err != nil {
+			return fmt.Errorf("get mailbox: %v", err)
+		}
+		var changed bool
+		mb.Keywords, changed = store.MergeKeywords(mb.Keywords, keywords)
+		if !changed {
+			return nil
+		}
+		changes = append(changes, mb.ChangeKeywords())
+		return tx.Update(&mb)
+	})
+	if err == nil && len(changes) > 0 {
+		store.BroadcastChanges(acc, changes)
+	}
+	return err
+}
+
+// sieveRejectMessage turns a reject reason from a script into a single line for
+// an SMTP response.
+func sieveRejectMessage(reason string) string {
+	s := strings.Join(strings.Fields(reason), " ")
+	if len(s) > 200 {
+		s = s[:200] + "..."
+	}
+	if s == "" {
+		s = "message rejected by recipient"
+	}
+	return s
+}
+
+// deliveredTo returns whether the header has a Delivered-To header for addr
+// from an earlier delivery, indicating a forwarding loop. The header includes our
+// own Delivered-To header for this delivery. ../rfc/9228:240
+func deliveredTo(h textproto.MIMEHeader, addr smtp.Path) bool {
+	var n int
+	for _, v := range h.Values("Delivered-To") {
+		if strings.EqualFold(strings.TrimSpace(v), addr.String()) {
+			n++
+		}
+	}
+	return n > 1
+}
+
+// sieveVacation returns the automatic response for a sieve vacation action. The
+// :from address of the script is only used if it is an address of the account,
+// like for message submission, otherwise the response is sent from rcptTo.
+func sieveVacation(log mlog.Log, accountName string, v sieve.Vacation, rcptTo smtp.Path) autoReply {
+	from := smtp.Address{Localpart: rcptTo.Localpart, Domain: rcptTo.IPDomain.Domain}
+	if v.From != "" {
+		if addr, err := smtp.ParseAddress(v.From); err != nil {
+			log.Debugx("parsing sieve vacation from address, using recipient address", err, slog.String("from", v.From))
+		} else if accName, _, _, err := mox.FindAccount(addr.Localpart, addr.Domain, false); err != nil || accName != accountName {
+			log.Info("sieve vacation from address not an address of account, using recipient address", slog.Any("err", err), slog.String("from", v.From))
+		} else {
+			from = addr
+		}
+	}
+	sum := sha256.Sum256([]byte(v.Handle))
+	return autoReply{
+		handle:    "sieve-vacation:" + base64.RawURLEncoding.EncodeToString(sum[:]),
+		interval:  time.Duration(v.Days) * 24 * time.Hour,
+		from:      from,
+		subject:   v.Subject,
+		body:      v.Reason,
+		mime:      v.Mime,
+		addresses: append([]string{rcptTo.String()}, v.Addresses...),
+	}
+}
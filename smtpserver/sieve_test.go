@@ -0,0 +1,117 @@
+package smtpserver
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/sieve"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+	"github.com/mjl-/mox/store"
+)
+
+// Test delivery with an active sieve script.
+func TestSieve(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"other.example.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"other.example."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtpservercatchall/mox.conf"), resolver)
+	defer ts.close()
+
+	const script = `require ["fileinto", "imap4flags", "reject", "vacation", "copy"];
+if header :contains "subject" "reject" {
+	reject "not wanted";
+	stop;
+}
+if header :contains "subject" "archive" {
+	fileinto :flags "\\Seen $label" "Archive";
+	stop;
+}
+redirect :copy "other@remote.example";
+vacation :days 1 "I'm away.";
+`
+	err := ts.acc.SieveScriptPut(ctxbg, "test", script)
+	tcheck(t, err, "put sieve script")
+	err = ts.acc.SieveScriptActivate(ctxbg, "test")
+	tcheck(t, err, "activate sieve script")
+
+	testDeliver := func(subject string, expErr *smtpclient.Error) {
+		t.Helper()
+		msg := strings.Replace(deliverMessage, "Subject: test", "Subject: "+subject, 1)
+		ts.run(func(err error, client *smtpclient.Client) {
+			t.Helper()
+			mailFrom := "remote@other.example"
+			if err == nil {
+				err = client.Deliver(ctxbg, mailFrom, "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			}
+			var cerr smtpclient.Error
+			if expErr == nil && err != nil || expErr != nil && (err == nil || !errors.As(err, &cerr) || cerr.Secode != expErr.Secode) {
+				t.Fatalf("got err %#v, expected %#v", err, expErr)
+			}
+		})
+	}
+
+	checkQueued := func(exp int) {
+		t.Helper()
+		n, err := queue.Count(ctxbg)
+		tcheck(t, err, "count queue")
+		tcompare(t, n, exp)
+	}
+
+	// Delivered to inbox, redirected and vacation response sent.
+	testDeliver("test", nil)
+	checkQueued(2)
+	msgs, err := queue.List(ctxbg)
+	tcheck(t, err, "list queue")
+	var nullSender bool
+	for _, qm := range msgs {
+		nullSender = nullSender || qm.Sender().IsZero()
+	}
+	tcompare(t, nullSender, true)
+
+	// Vacation response is not sent again.
+	testDeliver("test", nil)
+	checkQueued(3)
+
+	testDeliver("reject this", &smtpclient.Error{Code: smtp.C550MailboxUnavail, Secode: smtp.SePol7Other0})
+	checkQueued(3)
+
+	testDeliver("archive this", nil)
+	checkQueued(3)
+
+	mb, err := bstore.QueryDB[store.Mailbox](ctxbg, ts.acc.DB).FilterNonzero(store.Mailbox{Name: "Archive"}).Get()
+	tcheck(t, err, "get archive mailbox")
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).FilterNonzero(store.Message{MailboxID: mb.ID}).Get()
+	tcheck(t, err, "get archived message")
+	tcompare(t, m.Seen, true)
+	tcompare(t, m.Keywords, []string{"$label"})
+	tcompare(t, mb.Keywords, []string{"$label"})
+
+	n, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Count()
+	tcheck(t, err, "count messages")
+	tcompare(t, n, 3)
+
+	// Vacation responses are only sent from an address of the account.
+	rcptTo := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "mox.example"}}}
+	testFrom := func(from, expFrom string) {
+		t.Helper()
+		reply := sieveVacation(pkglog, "mjl", sieve.Vacation{From: from}, rcptTo)
+		tcompare(t, reply.from.String(), expFrom)
+	}
+	testFrom("", "mjl@mox.example")
+	testFrom("mjl+vacation@mox.example", "mjl+vacation@mox.example")
+	testFrom("other@mox.example", "mjl@mox.example") // Catchall account.
+	testFrom("other@remote.example", "mjl@mox.example")
+	testFrom("invalid", "mjl@mox.example")
+}
@@ -0,0 +1,117 @@
+// Package srs implements the Sender Rewriting Scheme (SRS) for the envelope
+// sender of forwarded messages.
+//
+// When forwarding a message, the original envelope sender (SMTP MAIL FROM)
+// cannot be kept: SPF checks for the original sender domain would fail for the
+// IP address of the forwarding mail server. The envelope sender is rewritten to
+// an address in a domain of the forwarding mail server, with the original
+// address encoded in its localpart, along with a timestamp and a signature.
+// Delivery status notifications for the forwarded message are sent to the
+// rewritten address, and the forwarding mail server can route them back to the
+// original sender after verifying the signature and timestamp.
+//
+// Only the SRS0 form is generated: "SRS0=hash=tt=domain=localpart@forwarding.domain".
+// Forwarding an already rewritten address rewrites it again.
+package srs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+var (
+	ErrNotSRS    = errors.New("srs: not an srs address")
+	ErrSyntax    = errors.New("srs: malformed address")
+	ErrSignature = errors.New("srs: bad signature")
+	ErrExpired   = errors.New("srs: address expired")
+)
+
+// MaxAge is how long rewritten addresses are accepted after generating them, for
+// delivery status notifications that are sent late.
+const MaxAge = 21 * 24 * time.Hour
+
+const prefix = "SRS0="
+
+// Timestamps are days since the epoch modulo 1024, in two base32 characters.
+const (
+	tsBase32  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	tsModulus = 1024
+)
+
+var hashEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// IsSRS returns whether localpart looks like an SRS address.
+func IsSRS(localpart smtp.Localpart) bool {
+	return len(localpart) > len(prefix) && strings.EqualFold(string(localpart[:len(prefix)]), prefix)
+}
+
+// Forward returns the rewritten address for sender, for use as envelope sender
+// when forwarding a message from domain. The null reverse path, and addresses
+// with an IP address instead of domain, are not rewritten.
+func Forward(key []byte, sender smtp.Path, domain dns.Domain, now time.Time) smtp.Path {
+	if sender.IsZero() || sender.IPDomain.Domain.IsZero() {
+		return sender
+	}
+	ts := timestamp(now)
+	origDomain := strings.ToLower(sender.IPDomain.Domain.ASCII)
+	origLocal := string(sender.Localpart)
+	lp := prefix + hash(key, ts, origDomain, origLocal) + "=" + ts + "=" + origDomain + "=" + origLocal
+	return smtp.Path{Localpart: smtp.Localpart(lp), IPDomain: dns.IPDomain{Domain: domain}}
+}
+
+// Reverse returns the original address from an address created by Forward, after
+// checking its signature and that it isn't older than MaxAge.
+func Reverse(key []byte, addr smtp.Path, now time.Time) (smtp.Path, error) {
+	if !IsSRS(addr.Localpart) {
+		return smtp.Path{}, ErrNotSRS
+	}
+	t := strings.SplitN(string(addr.Localpart[len(prefix):]), "=", 4)
+	if len(t) != 4 || t[2] == "" || t[3] == "" {
+		return smtp.Path{}, ErrSyntax
+	}
+	h, ts, origDomain, origLocal := t[0], strings.ToUpper(t[1]), t[2], t[3]
+
+	// Hashes are compared case-insensitively, mail servers may change the case of
+	// localparts.
+	exp := hash(key, ts, strings.ToLower(origDomain), origLocal)
+	if !hmac.Equal([]byte(strings.ToUpper(h)), []byte(exp)) {
+		return smtp.Path{}, ErrSignature
+	}
+
+	if len(ts) != 2 || !strings.Contains(tsBase32, ts[:1]) || !strings.Contains(tsBase32, ts[1:]) {
+		return smtp.Path{}, ErrSyntax
+	}
+	then := int64(strings.IndexByte(tsBase32, ts[0]))<<5 | int64(strings.IndexByte(tsBase32, ts[1]))
+	today := now.Unix() / (24 * 3600) % tsModulus
+	age := (today - then + tsModulus) % tsModulus
+	if age > int64(MaxAge/(24*time.Hour)) {
+		return smtp.Path{}, ErrExpired
+	}
+
+	d, err := dns.ParseDomain(origDomain)
+	if err != nil {
+		return smtp.Path{}, fmt.Errorf("%w: parsing original domain: %v", ErrSyntax, err)
+	}
+	return smtp.Path{Localpart: smtp.Localpart(origLocal), IPDomain: dns.IPDomain{Domain: d}}, nil
+}
+
+func timestamp(now time.Time) string {
+	days := now.Unix() / (24 * 3600) % tsModulus
+	return string([]byte{tsBase32[days>>5], tsBase32[days&31]})
+}
+
+// hash returns the signature over the fields of the rewritten address. The
+// localpart is case-sensitive, but the domain is lower-cased.
+func hash(key []byte, ts, domain, localpart string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ts + "=" + domain + "=" + localpart))
+	return hashEncoding.EncodeToString(mac.Sum(nil)[:5])
+}
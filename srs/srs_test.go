@@ -0,0 +1,71 @@
+package srs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+func TestSRS(t *testing.T) {
+	key := []byte("secret")
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	fwdDomain := dns.Domain{ASCII: "forward.example"}
+
+	sender := smtp.Path{Localpart: "User+tag", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "sender.example"}}}
+	fwd := Forward(key, sender, fwdDomain, now)
+	if !IsSRS(fwd.Localpart) || fwd.IPDomain.Domain != fwdDomain {
+		t.Fatalf("bad forward address %s", fwd)
+	}
+	if !strings.HasSuffix(string(fwd.Localpart), "=sender.example=User+tag") {
+		t.Fatalf("unexpected forward localpart %s", fwd.Localpart)
+	}
+
+	check := func(addr smtp.Path, now time.Time, expErr error) {
+		t.Helper()
+		orig, err := Reverse(key, addr, now)
+		if expErr != nil {
+			if !errors.Is(err, expErr) {
+				t.Fatalf("got err %v, expected %v", err, expErr)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("reverse: %v", err)
+		}
+		if orig.String() != sender.String() {
+			t.Fatalf("got %s, expected %s", orig, sender)
+		}
+	}
+
+	check(fwd, now, nil)
+	check(fwd, now.Add(MaxAge), nil)
+	check(fwd, now.Add(MaxAge+24*time.Hour), ErrExpired)
+
+	// Case of hash and timestamp doesn't matter.
+	lower := fwd
+	t0 := strings.SplitN(string(fwd.Localpart), "=", 4)
+	lower.Localpart = smtp.Localpart("srs0=" + strings.ToLower(t0[1]) + "=" + strings.ToLower(t0[2]) + "=" + t0[3])
+	check(lower, now, nil)
+
+	// Modified original address.
+	bad := fwd
+	bad.Localpart = smtp.Localpart(strings.Replace(string(fwd.Localpart), "User", "Other", 1))
+	check(bad, now, ErrSignature)
+
+	check(sender, now, ErrNotSRS)
+	check(smtp.Path{Localpart: "SRS0=bogus"}, now, ErrSyntax)
+
+	// Signed with other key.
+	if _, err := Reverse([]byte("other"), fwd, now); !errors.Is(err, ErrSignature) {
+		t.Fatalf("got err %v, expected ErrSignature", err)
+	}
+
+	// Null reverse path is not rewritten.
+	if p := Forward(key, smtp.Path{}, fwdDomain, now); !p.IsZero() {
+		t.Fatalf("null reverse path rewritten to %s", p)
+	}
+}
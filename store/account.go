@@ -25,8 +25,6 @@ import (
 	"context"
 	"crypto/md5"
 	cryptorand "crypto/rand"
-	"crypto/sha1"
-	"crypto/sha256"
 	"encoding"
 	"encoding/json"
 	"errors"
@@ -43,6 +41,7 @@ import (
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 	"golang.org/x/exp/slog"
 	"golang.org/x/text/unicode/norm"
@@ -57,7 +56,6 @@ import (
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxio"
 	"github.com/mjl-/mox/publicsuffix"
-	"github.com/mjl-/mox/scram"
 	"github.com/mjl-/mox/smtp"
 )
 
@@ -303,6 +301,28 @@ type Subscription struct {
 	Name string
 }
 
+// Annotation is a per-mailbox or account-wide annotation, for the IMAP METADATA
+// extension. ../rfc/5464
+type Annotation struct {
+	ID int64
+
+	// Zero for account-wide annotations, also called "server annotations" in IMAP.
+	MailboxID int64 `bstore:"ref Mailbox,unique MailboxID+Account+Key"`
+
+	// For /private entries on a mailbox shared with another account, the name of
+	// that account. Each account has its own private entries. Empty for the private
+	// entries of the owner of the mailbox, and for /shared entries.
+	Account string
+
+	// Also called "entry name", e.g. "/private/comment" or "/shared/vendor/x". Always
+	// starts with /private/ or /shared/. Stored in lower case, entry names are
+	// case-insensitive.
+	Key string `bstore:"nonzero"`
+
+	IsString bool // If true, the value was set as a string, otherwise as binary data.
+	Value    []byte
+}
+
 // Flags for a mail message.
 type Flags struct {
 	Seen      bool
@@ -402,6 +422,11 @@ type Message struct {
 
 	Received time.Time `bstore:"default now,index"`
 
+	// Time the message was added to its current mailbox, through delivery, IMAP
+	// APPEND, COPY or MOVE. Nil for messages saved before the save date was kept, in
+	// which case Received can be used instead. ../rfc/8514
+	SaveDate *time.Time
+
 	// Full IP address of remote SMTP server. Empty if not delivered over SMTP. The
 	// masked IPs are used to classify incoming messages. They are left empty for
 	// messages matching a ruleset for forwarded messages.
@@ -502,6 +527,24 @@ type Message struct {
 	// database.
 	// todo: once replaced with non-json storage, remove date fixup in ../message/part.go.
 	ParsedBuf []byte
+
+	// Preview of the message text, for IMAP PREVIEW. Nil if not yet generated. It is
+	// generated when first requested, and then stored. Can be empty.
+	Preview *string
+
+	// Whether the message file is stored compressed and/or encrypted, see
+	// CompressMessages and EncryptMessages in the account config. Set by mox when
+	// writing the file, never derived from the file contents.
+	FileCompressed bool
+	FileEncrypted  bool
+
+	// Text extracted from attachments by PrepareAttachmentText, before delivery. Not
+	// stored in the database, see AttachmentText.
+	attachmentText *string
+
+	// Set by SubaddressMailbox, for checking the maximum number of subaddress
+	// mailboxes during delivery. Not stored in the database.
+	subaddress *subaddressMailbox
 }
 
 // MailboxCounts returns the delta to counts this message means for its
@@ -713,7 +756,7 @@ type LoginSession struct {
 }
 
 // Types stored in DB.
-var DBTypes = []any{NextUIDValidity{}, Message{}, Recipient{}, Mailbox{}, Subscription{}, Outgoing{}, Password{}, Subjectpass{}, SyncState{}, Upgrade{}, RecipientDomainTLS{}, DiskUsage{}, LoginSession{}}
+var DBTypes = []any{NextUIDValidity{}, Message{}, Recipient{}, Mailbox{}, Subscription{}, Outgoing{}, Password{}, Subjectpass{}, SyncState{}, Upgrade{}, RecipientDomainTLS{}, DiskUsage{}, LoginSession{}, Annotation{}, MailboxACL{}, SavedSearch{}, WordTerm{}, WordSuffix{}, WordPosting{}, AttachmentText{}, DeletedMessage{}, EncryptionKey{}, SieveScript{}, AutoResponse{}, AutoReply{}, AppPassword{}, TOTP{}}
 
 // Account holds the information about a user, includings mailboxes, messages, imap subscriptions.
 type Account struct {
@@ -741,6 +784,11 @@ type Account struct {
 type Upgrade struct {
 	ID      byte
 	Threads byte // 0: None, 1: Adding MessageID's completed, 2: Adding ThreadID's completed.
+
+	// Whether all messages are in the word index, and it can be used for searches.
+	// Set for new accounts, and after WordIndexRebuild. Accounts without word index
+	// get it built in the background when opened.
+	WordIndex bool
 }
 
 // InitialUIDValidity returns a UIDValidity used for initializing an account.
@@ -836,7 +884,7 @@ func OpenAccountDB(log mlog.Log, accountDir, accountName string) (a *Account, re
 	}
 
 	if isNew {
-		if err := initAccount(db); err != nil {
+		if err := initAccount(db, acc.StoreMessageText()); err != nil {
 			return nil, fmt.Errorf("initializing account: %v", err)
 		}
 		close(acc.threadsCompleted)
@@ -882,7 +930,10 @@ func OpenAccountDB(log mlog.Log, accountDir, accountName string) (a *Account, re
 		return nil, fmt.Errorf("calculating counts for mailbox: %v", err)
 	}
 
-	// Start adding threading if needed.
+	// Start adding threading if needed. Accounts that encrypt messages don't have a
+	// word index, an index from before EncryptMessages was enabled is removed.
+	storeText := acc.StoreMessageText()
+	var clearWordIndex bool
 	up := Upgrade{ID: 1}
 	err = db.Write(context.TODO(), func(tx *bstore.Tx) error {
 		err := tx.Get(&up)
@@ -892,6 +943,16 @@ func OpenAccountDB(log mlog.Log, accountDir, accountName string) (a *Account, re
 			}
 			err = nil
 		}
+		if err != nil || storeText {
+			return err
+		}
+		clearWordIndex = up.WordIndex
+		if !clearWordIndex {
+			clearWordIndex, err = bstore.QueryTx[WordTerm](tx).Exists()
+		}
+		if err == nil && !clearWordIndex {
+			clearWordIndex, err = bstore.QueryTx[AttachmentText](tx).Exists()
+		}
 		return err
 	})
 	if err != nil {
@@ -899,7 +960,9 @@ func OpenAccountDB(log mlog.Log, accountDir, accountName string) (a *Account, re
 	}
 	if up.Threads == 2 {
 		close(acc.threadsCompleted)
-		return acc, nil
+		if up.WordIndex && storeText || !storeText && !clearWordIndex {
+			return acc, nil
+		}
 	}
 
 	// Increase account use before holding on to account in background.
@@ -907,40 +970,52 @@ func OpenAccountDB(log mlog.Log, accountDir, accountName string) (a *Account, re
 	// closeAccount.
 	acc.nused++
 
-	// Ensure all messages have a MessageID and SubjectBase, which are needed when
-	// matching threads.
-	// Then assign messages to threads, in the same way we do during imports.
-	log.Info("upgrading account for threading, in background", slog.String("account", acc.Name))
 	go func() {
 		defer func() {
 			err := closeAccount(acc)
-			log.Check(err, "closing use of account after upgrading account storage for threads", slog.String("account", a.Name))
-		}()
-
-		defer func() {
-			x := recover() // Should not happen, but don't take program down if it does.
-			if x != nil {
-				log.Error("upgradeThreads panic", slog.Any("err", x))
-				debug.PrintStack()
-				metrics.PanicInc(metrics.Upgradethreads)
-				acc.threadsErr = fmt.Errorf("panic during upgradeThreads: %v", x)
-			}
-
-			// Mark that upgrade has finished, possibly error is indicated in threadsErr.
-			close(acc.threadsCompleted)
+			log.Check(err, "closing use of account after upgrading account storage", slog.String("account", a.Name))
 		}()
 
-		err := upgradeThreads(mox.Shutdown, log, acc, &up)
-		if err != nil {
-			a.threadsErr = err
-			log.Errorx("upgrading account for threading, aborted", err, slog.String("account", a.Name))
-		} else {
-			log.Info("upgrading account for threading, completed", slog.String("account", a.Name))
+		if up.Threads != 2 {
+			acc.runUpgradeThreads(log, &up)
+		}
+		// After the threads upgrade, which also writes the Upgrade record.
+		if !up.WordIndex && storeText || clearWordIndex {
+			upgradeWordIndex(mox.Shutdown, log, acc)
 		}
 	}()
 	return acc, nil
 }
 
+// runUpgradeThreads runs the one-time threading upgrade for the account, and
+// closes threadsCompleted when done.
+func (a *Account) runUpgradeThreads(log mlog.Log, up *Upgrade) {
+	defer func() {
+		x := recover() // Should not happen, but don't take program down if it does.
+		if x != nil {
+			log.Error("upgradeThreads panic", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Upgradethreads)
+			a.threadsErr = fmt.Errorf("panic during upgradeThreads: %v", x)
+		}
+
+		// Mark that upgrade has finished, possibly error is indicated in threadsErr.
+		close(a.threadsCompleted)
+	}()
+
+	// Ensure all messages have a MessageID and SubjectBase, which are needed when
+	// matching threads.
+	// Then assign messages to threads, in the same way we do during imports.
+	log.Info("upgrading account for threading, in background", slog.String("account", a.Name))
+	err := upgradeThreads(mox.Shutdown, log, a, up)
+	if err != nil {
+		a.threadsErr = err
+		log.Errorx("upgrading account for threading, aborted", err, slog.String("account", a.Name))
+	} else {
+		log.Info("upgrading account for threading, completed", slog.String("account", a.Name))
+	}
+}
+
 // ThreadingWait blocks until the one-time account threading upgrade for the
 // account has completed, and returns an error if not successful.
 //
@@ -957,11 +1032,13 @@ func (a *Account) ThreadingWait(log mlog.Log) error {
 	return a.threadsErr
 }
 
-func initAccount(db *bstore.DB) error {
+// initAccount initializes the database of a new account. The word index is
+// complete, unless the account doesn't have one because it encrypts messages.
+func initAccount(db *bstore.DB, wordIndex bool) error {
 	return db.Write(context.TODO(), func(tx *bstore.Tx) error {
 		uidvalidity := InitialUIDValidity()
 
-		if err := tx.Insert(&Upgrade{ID: 1, Threads: 2}); err != nil {
+		if err := tx.Insert(&Upgrade{ID: 1, Threads: 2, WordIndex: wordIndex}); err != nil {
 			return err
 		}
 		if err := tx.Insert(&DiskUsage{ID: 1}); err != nil {
@@ -1128,12 +1205,12 @@ func (a *Account) CheckConsistency() error {
 				return nil
 			}
 			p := a.MessagePath(m.ID)
-			st, err := os.Stat(p)
+			filesize, err := MessageFileSize(p, m.FileCompressed, m.FileEncrypted)
 			if err != nil {
 				existserr := fmt.Sprintf("message %d in mailbox %q (id %d) on-disk file %s: %v", m.ID, mb.Name, mb.ID, p, err)
 				fileErrors = append(fileErrors, existserr)
-			} else if len(fileErrors) < 20 && m.Size != int64(len(m.MsgPrefix))+st.Size() {
-				sizeerr := fmt.Sprintf("message %d in mailbox %q (id %d) has size %d != len msgprefix %d + on-disk file size %d = %d", m.ID, mb.Name, mb.ID, m.Size, len(m.MsgPrefix), st.Size(), int64(len(m.MsgPrefix))+st.Size())
+			} else if len(fileErrors) < 20 && m.Size != int64(len(m.MsgPrefix))+filesize {
+				sizeerr := fmt.Sprintf("message %d in mailbox %q (id %d) has size %d != len msgprefix %d + on-disk file size %d = %d", m.ID, mb.Name, mb.ID, m.Size, len(m.MsgPrefix), filesize, int64(len(m.MsgPrefix))+filesize)
 				fileErrors = append(fileErrors, sizeerr)
 			}
 
@@ -1301,6 +1378,8 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 	if err := tx.Update(&mb); err != nil {
 		return fmt.Errorf("updating mailbox nextuid: %w", err)
 	}
+	now := time.Now()
+	m.SaveDate = &now
 
 	if updateDiskUsage {
 		du := DiskUsage{ID: 1}
@@ -1316,7 +1395,11 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 	conf, _ := a.Conf()
 	m.JunkFlagsForMailbox(mb, conf)
 
-	mr := FileMsgReader(m.MsgPrefix, msgFile) // We don't close, it would close the msgFile.
+	// The message file is only compressed and/or encrypted for recovered messages.
+	mr := fileMsgReader(m.MsgPrefix, msgFile, m.FileCompressed, m.FileEncrypted) // We don't close, it would close the msgFile.
+	if (m.FileCompressed || m.FileEncrypted) && mr.err == nil && mr.Size() != m.Size {
+		return fmt.Errorf("message file has size %d, expected %d", mr.Size(), m.Size)
+	}
 	var part *message.Part
 	if m.ParsedBuf == nil {
 		p, err := message.EnsurePart(log.Logger, false, mr, m.Size)
@@ -1335,6 +1418,7 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 		if err := json.Unmarshal(m.ParsedBuf, &p); err != nil {
 			log.Errorx("unmarshal parsed message, continuing", err, slog.String("parse", ""))
 		} else {
+			p.SetReaderAt(mr)
 			part = &p
 		}
 	}
@@ -1378,6 +1462,23 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 		}
 	}
 
+	// Compress and/or encrypt if configured, unless the file already is, for a
+	// recovered message.
+	var encryptPublicKey []byte
+	if conf.EncryptMessages {
+		ek := EncryptionKey{ID: 1}
+		if err := tx.Get(&ek); err == nil {
+			encryptPublicKey = ek.PublicKey
+		} else if err != bstore.ErrAbsent {
+			return fmt.Errorf("get encryption key: %v", err)
+		}
+	}
+	encode := !m.FileCompressed && !m.FileEncrypted && (conf.CompressMessages || encryptPublicKey != nil)
+	if encode {
+		m.FileCompressed = conf.CompressMessages
+		m.FileEncrypted = encryptPublicKey != nil
+	}
+
 	if err := tx.Insert(m); err != nil {
 		return fmt.Errorf("inserting message: %w", err)
 	}
@@ -1423,6 +1524,13 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 		}
 	}
 
+	if part != nil && a.StoreMessageText() {
+		part.SetReaderAt(mr)
+		if err := wordIndexAdd(log, tx, m, part); err != nil {
+			return fmt.Errorf("adding message to word index: %w", err)
+		}
+	}
+
 	msgPath := a.MessagePath(m.ID)
 	msgDir := filepath.Dir(msgPath)
 	os.MkdirAll(msgDir, 0770)
@@ -1434,7 +1542,11 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 		}
 	}
 
-	if err := moxio.LinkOrCopy(log, msgPath, msgFile.Name(), &moxio.AtReader{R: msgFile}, true); err != nil {
+	if encode {
+		if err := writeMessageFile(log, msgPath, msgFile, sync, conf.CompressMessages, encryptPublicKey); err != nil {
+			return fmt.Errorf("writing message to new file: %w", err)
+		}
+	} else if err := moxio.LinkOrCopy(log, msgPath, msgFile.Name(), &moxio.AtReader{R: msgFile}, true); err != nil {
 		return fmt.Errorf("linking/copying message to new file: %w", err)
 	}
 
@@ -1468,49 +1580,33 @@ func (a *Account) SetPassword(log mlog.Log, password string) error {
 	}
 
 	err = a.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
-		if _, err := bstore.QueryTx[Password](tx).Delete(); err != nil {
-			return fmt.Errorf("deleting existing password: %v", err)
-		}
 		var pw Password
 		pw.Hash = string(hash)
 
-		// CRAM-MD5 calculates an HMAC-MD5, with the password as key, over a per-attempt
-		// unique text that includes a timestamp. HMAC performs two hashes. Both times, the
-		// first block is based on the key/password. We hash those first blocks now, and
-		// store the hash state in the database. When we actually authenticate, we'll
-		// complete the HMAC by hashing only the text. We cannot store crypto/hmac's hash,
-		// because it does not expose its internal state and isn't a BinaryMarshaler.
-		// ../rfc/2104:121
-		pw.CRAMMD5.Ipad = md5.New()
-		pw.CRAMMD5.Opad = md5.New()
-		key := []byte(password)
-		if len(key) > 64 {
-			t := md5.Sum(key)
-			key = t[:]
-		}
-		ipad := make([]byte, md5.BlockSize)
-		opad := make([]byte, md5.BlockSize)
-		copy(ipad, key)
-		copy(opad, key)
-		for i := range ipad {
-			ipad[i] ^= 0x36
-			opad[i] ^= 0x5c
-		}
-		pw.CRAMMD5.Ipad.Write(ipad)
-		pw.CRAMMD5.Opad.Write(opad)
-
-		pw.SCRAMSHA1.Salt = scram.MakeRandom()
-		pw.SCRAMSHA1.Iterations = 2 * 4096
-		pw.SCRAMSHA1.SaltedPassword = scram.SaltPassword(sha1.New, password, pw.SCRAMSHA1.Salt, pw.SCRAMSHA1.Iterations)
-
-		pw.SCRAMSHA256.Salt = scram.MakeRandom()
-		pw.SCRAMSHA256.Iterations = 4096
-		pw.SCRAMSHA256.SaltedPassword = scram.SaltPassword(sha256.New, password, pw.SCRAMSHA256.Salt, pw.SCRAMSHA256.Iterations)
+		// App passwords are derived with the SCRAM salts of the account password. Keep the
+		// salts if there are app passwords, so they remain valid for SCRAM.
+		if n, err := bstore.QueryTx[AppPassword](tx).Count(); err != nil {
+			return fmt.Errorf("counting app passwords: %v", err)
+		} else if n > 0 {
+			if prev, err := bstore.QueryTx[Password](tx).Get(); err == nil {
+				pw.SCRAMSHA1 = SCRAM{Salt: prev.SCRAMSHA1.Salt, Iterations: prev.SCRAMSHA1.Iterations}
+				pw.SCRAMSHA256 = SCRAM{Salt: prev.SCRAMSHA256.Salt, Iterations: prev.SCRAMSHA256.Iterations}
+			}
+		}
+
+		if _, err := bstore.QueryTx[Password](tx).Delete(); err != nil {
+			return fmt.Errorf("deleting existing password: %v", err)
+		}
+		deriveSecrets(&pw, password)
 
 		if err := tx.Insert(&pw); err != nil {
 			return fmt.Errorf("inserting new password: %v", err)
 		}
 
+		if err := a.encryptionKeyReseal(tx, password); err != nil {
+			return err
+		}
+
 		return sessionRemoveAll(context.TODO(), log, tx, a.Name)
 	})
 	if err == nil {
@@ -1716,6 +1812,12 @@ ruleset:
 			}
 		}
 
+		if rs.SubaddressRegexpCompiled != nil {
+			if !rs.SubaddressRegexpCompiled.MatchString(messageSubaddress(m)) {
+				continue ruleset
+			}
+		}
+
 	header:
 		for _, t := range rs.HeadersRegexpCompiled {
 			for k, vl := range header {
@@ -1737,6 +1839,105 @@ ruleset:
 	return nil
 }
 
+// messageSubaddress returns the subaddress of the recipient of m, for domains with
+// a catchall separator.
+func messageSubaddress(m *Message) string {
+	d, err := dns.ParseDomain(m.RcptToDomain)
+	if err != nil {
+		return ""
+	}
+	dc, ok := mox.Conf.Domain(d)
+	if !ok {
+		return ""
+	}
+	return mox.LocalpartSubaddress(m.RcptToLocalpart, dc)
+}
+
+// SubaddressMailbox returns the mailbox for delivering m based on the subaddress
+// of its recipient, for domains with SubaddressMailboxes configured. Only
+// subaddresses matching one of the AllowRegexp of the domain get a mailbox. The
+// mailbox may not exist yet, it is created during delivery. An empty string is
+// returned if the message should be delivered to the regular mailbox.
+//
+// The maximum number of subaddress mailboxes is checked by DeliverMailbox, in the
+// transaction that would create the mailbox. If the maximum has been reached, the
+// message is delivered to mailbox regular instead.
+func (a *Account) SubaddressMailbox(log mlog.Log, m *Message, regular string) string {
+	d, err := dns.ParseDomain(m.RcptToDomain)
+	if err != nil {
+		return ""
+	}
+	dc, ok := mox.Conf.Domain(d)
+	if !ok || dc.SubaddressMailboxes == nil {
+		return ""
+	}
+	sm := dc.SubaddressMailboxes
+	sub := mox.LocalpartSubaddress(m.RcptToLocalpart, dc)
+	if sub == "" || strings.Contains(sub, "/") {
+		return ""
+	}
+	var allow bool
+	for _, r := range sm.AllowRegexpCompiled {
+		if r.MatchString(sub) {
+			allow = true
+			break
+		}
+	}
+	if !allow {
+		return ""
+	}
+
+	parent := sm.Parent
+	if parent == "" {
+		parent = "Plus"
+	}
+	name, _, err := CheckMailboxName(parent+"/"+norm.NFC.String(sub), false)
+	if err != nil {
+		log.Debugx("invalid mailbox name for subaddress, delivering to regular mailbox", err, slog.String("subaddress", sub))
+		return ""
+	}
+	limit := sm.MaxMailboxes
+	if limit == 0 {
+		limit = 100
+	}
+	m.subaddress = &subaddressMailbox{name, parent, limit, regular}
+	return name
+}
+
+// subaddressMailbox is set on a message by SubaddressMailbox, for checking the
+// maximum number of subaddress mailboxes during delivery.
+type subaddressMailbox struct {
+	mailbox string
+	parent  string
+	limit   int
+	regular string // Mailbox to deliver to when the limit has been reached.
+}
+
+// subaddressMailboxLimited returns the regular mailbox if mailbox is a subaddress
+// mailbox for m that does not exist yet, and the maximum number of subaddress
+// mailboxes has been reached. Otherwise mailbox is returned.
+func subaddressMailboxLimited(log mlog.Log, tx *bstore.Tx, m *Message, mailbox string) (string, error) {
+	sm := m.subaddress
+	if sm == nil || sm.mailbox != mailbox {
+		return mailbox, nil
+	}
+	exists, err := bstore.QueryTx[Mailbox](tx).FilterNonzero(Mailbox{Name: mailbox}).Exists()
+	if err != nil || exists {
+		return mailbox, err
+	}
+	n, err := bstore.QueryTx[Mailbox](tx).FilterFn(func(mb Mailbox) bool {
+		return strings.HasPrefix(mb.Name, sm.parent+"/")
+	}).Count()
+	if err != nil {
+		return "", fmt.Errorf("counting subaddress mailboxes: %v", err)
+	}
+	if n >= sm.limit {
+		log.Info("maximum number of subaddress mailboxes reached, delivering to regular mailbox", slog.String("mailbox", mailbox), slog.Int("max", sm.limit))
+		return sm.regular, nil
+	}
+	return mailbox, nil
+}
+
 // MessagePath returns the file system path of a message.
 func (a *Account) MessagePath(messageID int64) string {
 	return strings.Join(append([]string{a.Dir, "msg"}, messagePathElems(messageID)...), string(filepath.Separator))
@@ -1745,7 +1946,7 @@ func (a *Account) MessagePath(messageID int64) string {
 // MessageReader opens a message for reading, transparently combining the
 // message prefix with the original incoming message.
 func (a *Account) MessageReader(m Message) *MsgReader {
-	return &MsgReader{prefix: m.MsgPrefix, path: a.MessagePath(m.ID), size: m.Size}
+	return &MsgReader{prefix: m.MsgPrefix, path: a.MessagePath(m.ID), size: m.Size, compressed: m.FileCompressed, encrypted: m.FileEncrypted}
 }
 
 // DeliverDestination delivers an email to dest, based on the configured rulesets.
@@ -1756,14 +1957,15 @@ func (a *Account) MessageReader(m Message) *MsgReader {
 // Message delivery, possible mailbox creation, and updated mailbox counts are
 // broadcasted.
 func (a *Account) DeliverDestination(log mlog.Log, dest config.Destination, m *Message, msgFile *os.File) error {
-	var mailbox string
+	mailbox := dest.Mailbox
+	if mailbox == "" {
+		mailbox = "Inbox"
+	}
 	rs := MessageRuleset(log, dest, m, m.MsgPrefix, msgFile)
 	if rs != nil {
 		mailbox = rs.Mailbox
-	} else if dest.Mailbox == "" {
-		mailbox = "Inbox"
-	} else {
-		mailbox = dest.Mailbox
+	} else if mb := a.SubaddressMailbox(log, m, mailbox); mb != "" {
+		mailbox = mb
 	}
 	return a.DeliverMailbox(log, mailbox, m, msgFile)
 }
@@ -1783,7 +1985,16 @@ func (a *Account) DeliverMailbox(log mlog.Log, mailbox string, m *Message, msgFi
 		} else if !ok {
 			return ErrOverQuota
 		}
+		if ok, _, err := a.CanAddMessages(tx, 1); err != nil {
+			return err
+		} else if !ok {
+			return ErrOverQuota
+		}
 
+		mailbox, err := subaddressMailboxLimited(log, tx, m, mailbox)
+		if err != nil {
+			return err
+		}
 		mb, chl, err := a.MailboxEnsure(tx, mailbox, true)
 		if err != nil {
 			return fmt.Errorf("ensuring mailbox: %w", err)
@@ -1853,7 +2064,7 @@ func (a *Account) TidyRejectsMailbox(log mlog.Log, rejectsMailbox string) (hasSp
 			return fmt.Errorf("listing old messages: %w", err)
 		}
 
-		changes, err = a.rejectsRemoveMessages(context.TODO(), log, tx, mb, remove)
+		changes, err = a.expungeMessages(context.TODO(), log, tx, mb, remove)
 		if err != nil {
 			return fmt.Errorf("removing messages: %w", err)
 		}
@@ -1881,67 +2092,162 @@ func (a *Account) TidyRejectsMailbox(log mlog.Log, rejectsMailbox string) (hasSp
 	return hasSpace, nil
 }
 
-func (a *Account) rejectsRemoveMessages(ctx context.Context, log mlog.Log, tx *bstore.Tx, mb *Mailbox, l []Message) ([]Change, error) {
+// ExpungeMessages marks messages l in mailbox mb as expunged with modseq, as used
+// by IMAP, the webmail and retention. Message recipients and word index entries
+// are removed, the counts of mailbox mb are updated and stored, the disk usage is
+// updated and the junk filter is untrained. The messages in l are updated. The
+// message files must be removed after the transaction is committed, with
+// ExpungedFilesRemove. The caller broadcasts changes.
+func (a *Account) ExpungeMessages(ctx context.Context, log mlog.Log, tx *bstore.Tx, mb *Mailbox, l []Message, modseq ModSeq) error {
 	if len(l) == 0 {
-		return nil, nil
+		return nil
 	}
 	ids := make([]int64, len(l))
 	anyids := make([]any, len(l))
+	var totalSize int64
 	for i, m := range l {
 		ids[i] = m.ID
 		anyids[i] = m.ID
+		mb.Sub(m.MailboxCounts())
+		totalSize += m.Size
+		l[i].Expunged = true
+		l[i].ModSeq = modseq
 	}
 
-	// Remove any message recipients. Should not happen, but a user can move messages
-	// from a Sent mailbox to the rejects mailbox...
+	// Remove any message recipients. E.g. for messages moved from a Sent mailbox to
+	// the rejects mailbox.
 	qdmr := bstore.QueryTx[Recipient](tx)
 	qdmr.FilterEqual("MessageID", anyids...)
 	if _, err := qdmr.Delete(); err != nil {
-		return nil, fmt.Errorf("deleting from message recipient: %w", err)
+		return fmt.Errorf("deleting from message recipient: %w", err)
 	}
-
-	// Assign new modseq.
-	modseq, err := a.NextModSeq(tx)
-	if err != nil {
-		return nil, fmt.Errorf("assign next modseq: %w", err)
+	if err := a.WordIndexRemove(tx, ids...); err != nil {
+		return err
 	}
 
-	// Expunge the messages.
 	qx := bstore.QueryTx[Message](tx)
 	qx.FilterIDs(ids)
-	var expunged []Message
-	qx.Gather(&expunged)
-	if _, err := qx.UpdateNonzero(Message{ModSeq: modseq, Expunged: true}); err != nil {
-		return nil, fmt.Errorf("expunging messages: %w", err)
+	n, err := qx.UpdateNonzero(Message{ModSeq: modseq, Expunged: true})
+	if err == nil && n != len(ids) {
+		err = fmt.Errorf("only %d messages set to expunged, expected %d", n, len(ids))
 	}
-
-	var totalSize int64
-	for _, m := range expunged {
-		m.Expunged = false // Was set by update, but would cause wrong count.
-		mb.MailboxCounts.Sub(m.MailboxCounts())
-		totalSize += m.Size
+	if err != nil {
+		return fmt.Errorf("expunging messages: %w", err)
 	}
+
 	if err := tx.Update(mb); err != nil {
-		return nil, fmt.Errorf("updating mailbox counts: %w", err)
+		return fmt.Errorf("updating mailbox counts: %w", err)
 	}
 	if err := a.AddMessageSize(log, tx, -totalSize); err != nil {
-		return nil, fmt.Errorf("updating disk usage: %w", err)
+		return fmt.Errorf("updating disk usage: %w", err)
 	}
 
 	// Mark as neutral and train so junk filter gets untrained with these (junk) messages.
-	for i := range expunged {
-		expunged[i].Junk = false
-		expunged[i].Notjunk = false
+	for i := range l {
+		l[i].Junk = false
+		l[i].Notjunk = false
 	}
-	if err := a.RetrainMessages(ctx, log, tx, expunged, true); err != nil {
-		return nil, fmt.Errorf("retraining expunged messages: %w", err)
+	if err := a.RetrainMessages(ctx, log, tx, l, true); err != nil {
+		return fmt.Errorf("retraining expunged messages: %w", err)
 	}
+	return nil
+}
 
-	changes := make([]Change, len(l), len(l)+1)
+// expungeMessages expunges messages l in mailbox mb with a new modseq, see
+// ExpungeMessages. Changes to broadcast are returned.
+func (a *Account) expungeMessages(ctx context.Context, log mlog.Log, tx *bstore.Tx, mb *Mailbox, l []Message) ([]Change, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+	modseq, err := a.NextModSeq(tx)
+	if err != nil {
+		return nil, fmt.Errorf("assign next modseq: %w", err)
+	}
+	if err := a.ExpungeMessages(ctx, log, tx, mb, l, modseq); err != nil {
+		return nil, err
+	}
+	uids := make([]UID, len(l))
 	for i, m := range l {
-		changes[i] = ChangeRemoveUIDs{mb.ID, []UID{m.UID}, modseq}
+		uids[i] = m.UID
+	}
+	slices.Sort(uids)
+	return []Change{ChangeRemoveUIDs{mb.ID, uids, modseq}, mb.ChangeCounts()}, nil
+}
+
+// moveMessages moves messages l from mailbox mb to mailbox dst, like a move in
+// IMAP or the webmail. Records with the old UIDs are kept as expunged messages. The
+// mailboxes are updated in the database, and the junk filter is retrained. Changes
+// to broadcast are returned.
+func (a *Account) moveMessages(ctx context.Context, log mlog.Log, tx *bstore.Tx, mb, dst *Mailbox, l []Message) ([]Change, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	modseq, err := a.NextModSeq(tx)
+	if err != nil {
+		return nil, fmt.Errorf("assign next modseq: %w", err)
+	}
+	conf, _ := a.Conf()
+	now := time.Now()
+
+	changes := make([]Change, 0, len(l)+4) // n adds, 1 remove, 2 counts, keywords.
+	uids := make([]UID, len(l))
+	keywords := map[string]struct{}{}
+	for i, m := range l {
+		uids[i] = m.UID
+
+		// Copy of message record that we'll insert when UID is freed up.
+		om := m
+		om.PrepareExpunge()
+		om.ID = 0 // Assign new ID.
+		om.ModSeq = modseq
+
+		mb.Sub(m.MailboxCounts())
+
+		if dst.Trash {
+			m.Seen = true
+		}
+		m.MailboxID = dst.ID
+		m.SaveDate = &now
+		m.UID = dst.UIDNext
+		m.ModSeq = modseq
+		dst.UIDNext++
+		m.JunkFlagsForMailbox(*dst, conf)
+		if err := tx.Update(&m); err != nil {
+			return nil, fmt.Errorf("updating moved message: %w", err)
+		}
+
+		// Now that UID is unused, we can insert the old record again.
+		if err := tx.Insert(&om); err != nil {
+			return nil, fmt.Errorf("inserting record for expunge after moving message: %w", err)
+		}
+
+		dst.Add(m.MailboxCounts())
+		changes = append(changes, m.ChangeAddUID())
+		l[i] = m
+		for _, kw := range m.Keywords {
+			keywords[kw] = struct{}{}
+		}
+	}
+
+	changes = append(changes, ChangeRemoveUIDs{mb.ID, uids, modseq}, mb.ChangeCounts(), dst.ChangeCounts())
+
+	// Ensure destination mailbox has keywords of the moved messages.
+	var mbKwChanged bool
+	dst.Keywords, mbKwChanged = MergeKeywords(dst.Keywords, maps.Keys(keywords))
+	if mbKwChanged {
+		changes = append(changes, dst.ChangeKeywords())
+	}
+
+	if err := tx.Update(mb); err != nil {
+		return nil, fmt.Errorf("updating source mailbox: %w", err)
+	}
+	if err := tx.Update(dst); err != nil {
+		return nil, fmt.Errorf("updating destination mailbox: %w", err)
+	}
+	if err := a.RetrainMessages(ctx, log, tx, l, false); err != nil {
+		return nil, fmt.Errorf("retraining moved messages: %w", err)
 	}
-	changes = append(changes, mb.ChangeCounts())
 	return changes, nil
 }
 
@@ -1977,7 +2283,7 @@ func (a *Account) RejectsRemove(log mlog.Log, rejectsMailbox, messageID string)
 			return fmt.Errorf("listing messages to remove: %w", err)
 		}
 
-		changes, err = a.rejectsRemoveMessages(context.TODO(), log, tx, mb, remove)
+		changes, err = a.expungeMessages(context.TODO(), log, tx, mb, remove)
 		if err != nil {
 			return fmt.Errorf("removing messages: %w", err)
 		}
@@ -2024,6 +2330,46 @@ func (a *Account) QuotaMessageSize() int64 {
 	return size
 }
 
+// QuotaMessageCount returns the effective maximum number of messages for an
+// account. Returns 0 if there is no maximum.
+func (a *Account) QuotaMessageCount() int64 {
+	conf, _ := a.Conf()
+	n := conf.QuotaMessageCount
+	if n == 0 {
+		n = mox.Conf.Static.QuotaMessageCount
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// MessageCount returns the number of messages in the account, including those
+// marked \Deleted, but not expunged messages.
+func (a *Account) MessageCount(tx *bstore.Tx) (int64, error) {
+	var n int64
+	err := bstore.QueryTx[Mailbox](tx).ForEach(func(mb Mailbox) error {
+		n += mb.Total + mb.Deleted
+		return nil
+	})
+	return n, err
+}
+
+// CanAddMessages checks if n messages can be added, depending on the number of
+// messages and configured quota for account.
+func (a *Account) CanAddMessages(tx *bstore.Tx, n int64) (ok bool, maxCount int64, err error) {
+	maxCount = a.QuotaMessageCount()
+	if maxCount <= 0 {
+		return true, 0, nil
+	}
+
+	count, err := a.MessageCount(tx)
+	if err != nil {
+		return false, maxCount, fmt.Errorf("counting messages: %v", err)
+	}
+	return count+n <= maxCount, maxCount, nil
+}
+
 // CanAddMessageSize checks if a message of size bytes can be added, depending on
 // total message size and configured quota for account.
 func (a *Account) CanAddMessageSize(tx *bstore.Tx, size int64) (ok bool, maxSize int64, err error) {
@@ -2068,7 +2414,11 @@ func manageAuthCache() {
 // OpenEmailAuth opens an account given an email address and password.
 //
 // The email address may contain a catchall separator.
-func OpenEmailAuth(log mlog.Log, email string, password string) (acc *Account, rerr error) {
+//
+// Except for AuthScopeWeb, app passwords valid for scope are accepted too. The
+// name of the app password is returned, or an empty string if the account
+// password was used.
+func OpenEmailAuth(log mlog.Log, email string, password string, scope AuthScope) (acc *Account, appPassword string, rerr error) {
 	acc, _, rerr = OpenEmail(log, email)
 	if rerr != nil {
 		return
@@ -2085,23 +2435,36 @@ func OpenEmailAuth(log mlog.Log, email string, password string) (acc *Account, r
 	pw, err := bstore.QueryDB[Password](context.TODO(), acc.DB).Get()
 	if err != nil {
 		if err == bstore.ErrAbsent {
-			return acc, ErrUnknownCredentials
+			return acc, "", ErrUnknownCredentials
 		}
-		return acc, fmt.Errorf("looking up password: %v", err)
+		return acc, "", fmt.Errorf("looking up password: %v", err)
 	}
 	authCache.Lock()
 	ok := len(password) >= 8 && authCache.success[authKey{email, pw.Hash}] == password
 	authCache.Unlock()
-	if ok {
-		return
-	}
-	if err := bcrypt.CompareHashAndPassword([]byte(pw.Hash), []byte(password)); err != nil {
-		rerr = ErrUnknownCredentials
-	} else {
+	if !ok {
+		if err := bcrypt.CompareHashAndPassword([]byte(pw.Hash), []byte(password)); err != nil {
+			if scope == AuthScopeWeb {
+				return acc, "", ErrUnknownCredentials
+			}
+			ap, ok, err := acc.authAppPassword(email, password, scope)
+			if err != nil {
+				return acc, "", err
+			} else if !ok {
+				return acc, "", ErrUnknownCredentials
+			}
+			acc.AppPasswordUsed(log, ap)
+			err = acc.encryptionUnlockAppPassword(ap, password)
+			log.Check(err, "unlocking encryption key of account with app password")
+			return acc, ap.Name, nil
+		}
 		authCache.Lock()
 		authCache.success[authKey{email, pw.Hash}] = password
 		authCache.Unlock()
 	}
+	// With the password, we can make the key for encrypted messages available.
+	err = acc.encryptionUnlock(log, password)
+	log.Check(err, "unlocking encryption key of account")
 	return
 }
 
@@ -2517,8 +2880,9 @@ func (a *Account) MailboxRename(tx *bstore.Tx, mbsrc Mailbox, dst string) (chang
 // MailboxDelete deletes a mailbox by ID. If it has children, the return value
 // indicates that and an error is returned.
 //
-// Caller should broadcast the changes and remove files for the removed message IDs.
-func (a *Account) MailboxDelete(ctx context.Context, log mlog.Log, tx *bstore.Tx, mailbox Mailbox) (changes []Change, removeMessageIDs []int64, hasChildren bool, rerr error) {
+// Caller should broadcast the changes and remove files for the removed messages,
+// with ExpungedFilesRemove.
+func (a *Account) MailboxDelete(ctx context.Context, log mlog.Log, tx *bstore.Tx, mailbox Mailbox) (changes []Change, removeMessages []Message, hasChildren bool, rerr error) {
 	// Look for existence of child mailboxes. There is a lot of text in the IMAP RFCs about
 	// NoInferior and NoSelect. We just require only leaf mailboxes are deleted.
 	qmb := bstore.QueryTx[Mailbox](tx)
@@ -2543,14 +2907,19 @@ func (a *Account) MailboxDelete(ctx context.Context, log mlog.Log, tx *bstore.Tx
 
 	if len(remove) > 0 {
 		removeIDs := make([]any, len(remove))
+		wordIDs := make([]int64, len(remove))
 		for i, m := range remove {
 			removeIDs[i] = m.ID
+			wordIDs[i] = m.ID
 		}
 		qmr := bstore.QueryTx[Recipient](tx)
 		qmr.FilterEqual("MessageID", removeIDs...)
 		if _, err = qmr.Delete(); err != nil {
 			return nil, nil, false, fmt.Errorf("removing message recipients for messages: %v", err)
 		}
+		if err := a.WordIndexRemove(tx, wordIDs...); err != nil {
+			return nil, nil, false, err
+		}
 
 		qm = bstore.QueryTx[Message](tx)
 		qm.FilterNonzero(Message{MailboxID: mailbox.ID})
@@ -2561,7 +2930,7 @@ func (a *Account) MailboxDelete(ctx context.Context, log mlog.Log, tx *bstore.Tx
 		var totalSize int64
 		for _, m := range remove {
 			if !m.Expunged {
-				removeMessageIDs = append(removeMessageIDs, m.ID)
+				removeMessages = append(removeMessages, m)
 				totalSize += m.Size
 			}
 		}
@@ -2586,10 +2955,22 @@ func (a *Account) MailboxDelete(ctx context.Context, log mlog.Log, tx *bstore.Tx
 		}
 	}
 
+	qa := bstore.QueryTx[Annotation](tx)
+	qa.FilterNonzero(Annotation{MailboxID: mailbox.ID})
+	if _, err := qa.Delete(); err != nil {
+		return nil, nil, false, fmt.Errorf("removing annotations for mailbox: %v", err)
+	}
+
+	qacl := bstore.QueryTx[MailboxACL](tx)
+	qacl.FilterNonzero(MailboxACL{MailboxID: mailbox.ID})
+	if _, err := qacl.Delete(); err != nil {
+		return nil, nil, false, fmt.Errorf("removing access control lists for mailbox: %v", err)
+	}
+
 	if err := tx.Delete(&Mailbox{ID: mailbox.ID}); err != nil {
 		return nil, nil, false, fmt.Errorf("removing mailbox: %v", err)
 	}
-	return []Change{ChangeRemoveMailbox{MailboxID: mailbox.ID, Name: mailbox.Name}}, removeMessageIDs, false, nil
+	return []Change{ChangeRemoveMailbox{MailboxID: mailbox.ID, Name: mailbox.Name}}, removeMessages, false, nil
 }
 
 // CheckMailboxName checks if name is valid, returning an INBOX-normalized name.
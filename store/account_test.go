@@ -16,6 +16,7 @@ import (
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
 )
 
 var ctxbg = context.Background()
@@ -223,30 +224,30 @@ func TestMailbox(t *testing.T) {
 
 	// Run the auth tests twice for possible cache effects.
 	for i := 0; i < 2; i++ {
-		_, err := OpenEmailAuth(log, "mjl@mox.example", "bogus")
+		_, _, err := OpenEmailAuth(log, "mjl@mox.example", "bogus", AuthScopeIMAP)
 		if err != ErrUnknownCredentials {
 			t.Fatalf("got %v, expected ErrUnknownCredentials", err)
 		}
 	}
 
 	for i := 0; i < 2; i++ {
-		acc2, err := OpenEmailAuth(log, "mjl@mox.example", "testtest")
+		acc2, _, err := OpenEmailAuth(log, "mjl@mox.example", "testtest", AuthScopeIMAP)
 		tcheck(t, err, "open for email with auth")
 		err = acc2.Close()
 		tcheck(t, err, "close account")
 	}
 
-	acc2, err := OpenEmailAuth(log, "other@mox.example", "testtest")
+	acc2, _, err := OpenEmailAuth(log, "other@mox.example", "testtest", AuthScopeIMAP)
 	tcheck(t, err, "open for email with auth")
 	err = acc2.Close()
 	tcheck(t, err, "close account")
 
-	_, err = OpenEmailAuth(log, "bogus@mox.example", "testtest")
+	_, _, err = OpenEmailAuth(log, "bogus@mox.example", "testtest", AuthScopeIMAP)
 	if err != ErrUnknownCredentials {
 		t.Fatalf("got %v, expected ErrUnknownCredentials", err)
 	}
 
-	_, err = OpenEmailAuth(log, "mjl@test.example", "testtest")
+	_, _, err = OpenEmailAuth(log, "mjl@test.example", "testtest", AuthScopeIMAP)
 	if err != ErrUnknownCredentials {
 		t.Fatalf("got %v, expected ErrUnknownCredentials", err)
 	}
@@ -300,3 +301,89 @@ test
 
 	// todo: test the SMTPMailFrom and VerifiedDomains rule.
 }
+
+func TestSubaddressMailbox(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+	}()
+	defer Switchboard()()
+
+	test := func(localpart string, expMailbox string) {
+		t.Helper()
+		m := Message{RcptToLocalpart: smtp.Localpart(localpart), RcptToDomain: "mox.example"}
+		mb := acc.SubaddressMailbox(log, &m, "Other")
+		if mb != expMailbox {
+			t.Fatalf("got mailbox %q, expected %q", mb, expMailbox)
+		}
+	}
+
+	test("other", "")
+	test("other+Shop", "Plus/shop")
+	test("other+unknown", "")
+	test("other+", "")
+
+	deliver := func(localpart string) Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "account-test")
+		tcheck(t, err, "create temp message file")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		const msg = "Subject: test\r\n\r\ntest\r\n"
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Received: time.Now(), Size: int64(len(msg)), RcptToLocalpart: smtp.Localpart(localpart), RcptToDomain: "mox.example"}
+		acc.WithWLock(func() {
+			conf, _ := acc.Conf()
+			err := acc.DeliverDestination(log, conf.Destinations["other@mox.example"], &m, msgFile)
+			tcheck(t, err, "deliver")
+		})
+		return m
+	}
+
+	// Mailboxes are created during delivery, up to the maximum.
+	deliver("other+shop")
+	deliver("other+travel")
+	test("other+shop", "Plus/shop")
+	_, err = bstore.QueryDB[Mailbox](ctxbg, acc.DB).FilterNonzero(Mailbox{Name: "Plus/travel"}).Get()
+	tcheck(t, err, "get subaddress mailbox")
+
+	// The maximum is checked during delivery, the message goes to the regular mailbox.
+	m := deliver("other+news")
+	mb, err := bstore.QueryDB[Mailbox](ctxbg, acc.DB).FilterNonzero(Mailbox{Name: "Other"}).Get()
+	tcheck(t, err, "get regular mailbox")
+	if m.MailboxID != mb.ID {
+		t.Fatalf("message delivered to mailbox %d, expected regular mailbox %d", m.MailboxID, mb.ID)
+	}
+	exists, err := bstore.QueryDB[Mailbox](ctxbg, acc.DB).FilterNonzero(Mailbox{Name: "Plus/news"}).Exists()
+	tcheck(t, err, "check subaddress mailbox")
+	if exists {
+		t.Fatalf("subaddress mailbox created beyond maximum")
+	}
+
+	// Rulesets can match on the subaddress.
+	f, err := CreateMessageTemp(log, "msgruleset")
+	tcheck(t, err, "creating temp msg file")
+	defer os.Remove(f.Name())
+	defer f.Close()
+	dest := config.Destination{
+		Rulesets: []config.Ruleset{
+			{SubaddressRegexp: "^shop$", SubaddressRegexpCompiled: regexp.MustCompile("^shop$"), Mailbox: "Shopping"},
+		},
+	}
+	msgBuf := []byte("Subject: test\r\n\r\ntest\r\n")
+	rs := MessageRuleset(log, dest, &Message{RcptToLocalpart: "other+SHOP", RcptToDomain: "mox.example"}, msgBuf, f)
+	if rs == nil || rs.Mailbox != "Shopping" {
+		t.Fatalf("expected ruleset match, got %v", rs)
+	}
+	rs = MessageRuleset(log, dest, &Message{RcptToLocalpart: "other+travel", RcptToDomain: "mox.example"}, msgBuf, f)
+	if rs != nil {
+		t.Fatalf("expected no ruleset match")
+	}
+}
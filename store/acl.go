@@ -0,0 +1,240 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// Rights on a mailbox, as a string with a letter per right, in the order of
+// AllRights. ../rfc/4314
+type Rights string
+
+// AllRights are the rights of the owner of a mailbox. Rights "p" (post), "k"
+// (create child mailboxes) and "x" (delete mailbox) can be granted, but don't give
+// access to anything: other accounts cannot post, or create, rename or delete
+// mailboxes.
+const AllRights Rights = "lrswipkxtea"
+
+// Identifier for ACL entries that grant rights to all accounts. ../rfc/4314
+const ACLAnyone = "anyone"
+
+// OtherUsersPrefix is the mailbox name prefix for mailboxes shared by other
+// accounts, followed by the account name and the mailbox name of that account,
+// e.g. "#Other Users/support/Inbox". Mailboxes of an account cannot start with
+// "#", so cannot have this prefix. ../rfc/2342
+const OtherUsersPrefix = "#Other Users/"
+
+// ParseRights parses rights as used in IMAP. The obsolete rights "c" and "d" are
+// replaced by the rights they stand for. An error is returned for unknown rights.
+func ParseRights(s string) (Rights, error) {
+	// ../rfc/4314
+	s = strings.ReplaceAll(s, "c", "k")
+	s = strings.ReplaceAll(s, "d", "xte")
+	var r string
+	for _, c := range s {
+		if !strings.ContainsRune(string(AllRights), c) {
+			return "", fmt.Errorf("unknown right %q", c)
+		}
+	}
+	for _, c := range AllRights {
+		if strings.ContainsRune(s, c) {
+			r += string(c)
+		}
+	}
+	return Rights(r), nil
+}
+
+// Has returns whether all rights in l are present in r.
+func (r Rights) Has(l string) bool {
+	for _, c := range l {
+		if !strings.ContainsRune(string(r), c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Union returns the rights present in r or o.
+func (r Rights) Union(o Rights) Rights {
+	nr, _ := ParseRights(string(r + o))
+	return nr
+}
+
+// Remove returns the rights in r that are not in o.
+func (r Rights) Remove(o Rights) Rights {
+	var nr string
+	for _, c := range r {
+		if !strings.ContainsRune(string(o), c) {
+			nr += string(c)
+		}
+	}
+	return Rights(nr)
+}
+
+// MailboxACL grants rights on a mailbox to another account, or to all accounts.
+// Stored in the database of the account that owns the mailbox. The owner always
+// has all rights, it does not have MailboxACLs. ../rfc/4314
+type MailboxACL struct {
+	ID        int64
+	MailboxID int64 `bstore:"nonzero,ref Mailbox,unique MailboxID+Identifier"`
+
+	// Account name, or "anyone" for all accounts.
+	Identifier string `bstore:"nonzero,index"`
+
+	Rights Rights `bstore:"nonzero"`
+}
+
+// Accounts known to have or not have MailboxACLs, for quickly skipping accounts
+// that don't share mailboxes in SharedMailboxes. Accounts missing from the map
+// are opened to find out.
+var aclAccounts = struct {
+	sync.Mutex
+	names map[string]bool
+}{names: map[string]bool{}}
+
+// MailboxRights returns the rights of account accountName on a mailbox of a. The
+// owner of the mailbox has all rights. Rights granted to "anyone" are added to the
+// rights granted to the account.
+func (a *Account) MailboxRights(tx *bstore.Tx, mailboxID int64, accountName string) (Rights, error) {
+	if accountName == a.Name {
+		return AllRights, nil
+	}
+	q := bstore.QueryTx[MailboxACL](tx)
+	q.FilterNonzero(MailboxACL{MailboxID: mailboxID})
+	q.FilterEqual("Identifier", accountName, ACLAnyone)
+	var r Rights
+	err := q.ForEach(func(acl MailboxACL) error {
+		r = r.Union(acl.Rights)
+		return nil
+	})
+	return r, err
+}
+
+// MailboxACLs returns the grants on a mailbox, sorted by identifier.
+func (a *Account) MailboxACLs(tx *bstore.Tx, mailboxID int64) ([]MailboxACL, error) {
+	q := bstore.QueryTx[MailboxACL](tx)
+	q.FilterNonzero(MailboxACL{MailboxID: mailboxID})
+	q.SortAsc("Identifier")
+	return q.List()
+}
+
+// MailboxACLSet sets the rights for identifier, an account name or "anyone", on a
+// mailbox of a. Empty rights remove the grant. The owner of the mailbox cannot be
+// granted rights, it always has all rights.
+func (a *Account) MailboxACLSet(tx *bstore.Tx, mailboxID int64, identifier string, rights Rights) error {
+	if identifier == a.Name {
+		return fmt.Errorf("cannot change rights of owner of mailbox")
+	}
+
+	q := bstore.QueryTx[MailboxACL](tx)
+	q.FilterNonzero(MailboxACL{MailboxID: mailboxID, Identifier: identifier})
+	if _, err := q.Delete(); err != nil {
+		return fmt.Errorf("removing existing rights: %v", err)
+	}
+	if rights == "" {
+		return nil
+	}
+	acl := MailboxACL{MailboxID: mailboxID, Identifier: identifier, Rights: rights}
+	if err := tx.Insert(&acl); err != nil {
+		return fmt.Errorf("inserting rights: %v", err)
+	}
+
+	aclAccounts.Lock()
+	aclAccounts.names[a.Name] = true
+	aclAccounts.Unlock()
+	return nil
+}
+
+// SharedMailbox is a mailbox of another account that an account has rights on.
+type SharedMailbox struct {
+	Account string // Owner of the mailbox.
+	Mailbox Mailbox
+	Rights  Rights
+}
+
+// SharedMailboxes returns the mailboxes of other accounts that accountName has
+// been granted rights on, directly or through "anyone", sorted by account and
+// mailbox name.
+func SharedMailboxes(log mlog.Log, accountName string) ([]SharedMailbox, error) {
+	names := mox.Conf.Accounts()
+	sort.Strings(names)
+
+	var l []SharedMailbox
+	for _, name := range names {
+		if name == accountName {
+			continue
+		}
+		aclAccounts.Lock()
+		has, known := aclAccounts.names[name]
+		aclAccounts.Unlock()
+		if known && !has {
+			continue
+		}
+
+		shared, err := accountSharedMailboxes(log, name, accountName)
+		if err != nil {
+			return nil, fmt.Errorf("looking up shared mailboxes in account %q: %v", name, err)
+		}
+		l = append(l, shared...)
+	}
+	return l, nil
+}
+
+// accountSharedMailboxes returns the mailboxes of account name shared with
+// accountName.
+func accountSharedMailboxes(log mlog.Log, name, accountName string) (l []SharedMailbox, err error) {
+	acc, err := OpenAccount(log, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+		exists, err := bstore.QueryTx[MailboxACL](tx).Exists()
+		if err != nil {
+			return err
+		}
+		aclAccounts.Lock()
+		aclAccounts.names[name] = aclAccounts.names[name] || exists
+		aclAccounts.Unlock()
+		if !exists {
+			return nil
+		}
+
+		rights := map[int64]Rights{}
+		q := bstore.QueryTx[MailboxACL](tx)
+		q.FilterEqual("Identifier", accountName, ACLAnyone)
+		err = q.ForEach(func(acl MailboxACL) error {
+			rights[acl.MailboxID] = rights[acl.MailboxID].Union(acl.Rights)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(rights) == 0 {
+			return nil
+		}
+
+		qmb := bstore.QueryTx[Mailbox](tx)
+		qmb.FilterFn(func(mb Mailbox) bool {
+			return rights[mb.ID] != ""
+		})
+		qmb.SortAsc("Name")
+		return qmb.ForEach(func(mb Mailbox) error {
+			l = append(l, SharedMailbox{name, mb, rights[mb.ID]})
+			return nil
+		})
+	})
+	return l, err
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"testing"
+)
+
+func TestRights(t *testing.T) {
+	test := func(s string, exp Rights, expErr bool) {
+		t.Helper()
+		r, err := ParseRights(s)
+		if (err != nil) != expErr {
+			t.Fatalf("parse rights %q: got err %v, expected error %v", s, err, expErr)
+		}
+		if r != exp {
+			t.Fatalf("parse rights %q: got %q, expected %q", s, r, exp)
+		}
+	}
+
+	test("", "", false)
+	test("rl", "lr", false)
+	test("lrswipkxtea", AllRights, false)
+	test("llr", "lr", false)
+	test("c", "k", false)   // Obsolete.
+	test("d", "xte", false) // Obsolete.
+	test("lrz", "", true)   // Unknown right.
+	test("LR", "", true)    // Rights are lower case.
+
+	r := Rights("lrs")
+	if !r.Has("rl") || r.Has("lw") || !r.Has("") {
+		t.Fatalf("bad Has")
+	}
+	if x := r.Union("wl"); x != "lrsw" {
+		t.Fatalf("union, got %q", x)
+	}
+	if x := r.Remove("sw"); x != "lr" {
+		t.Fatalf("remove, got %q", x)
+	}
+}
@@ -0,0 +1,361 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/scram"
+)
+
+// ErrAppPassword is returned for invalid app password parameters.
+var ErrAppPassword = errors.New("invalid app password")
+
+// AuthScope is the protocol credentials are verified for. The account password
+// is valid for all scopes, app passwords only for the scopes they were created
+// for.
+type AuthScope string
+
+const (
+	AuthScopeIMAP       AuthScope = "imap" // Also for ManageSieve.
+	AuthScopeSubmission AuthScope = "submission"
+	AuthScopeWeb        AuthScope = "web" // App passwords are never valid for web logins.
+)
+
+// AppPassword is an application-specific password for an account, to configure
+// in an email client instead of the account password, so access can be revoked
+// per client. App passwords are generated by mox, are only valid for IMAP and/or
+// SMTP submission, and cannot be used to log in to the web interfaces.
+//
+// The SCRAM secrets are derived with the salts of the account password, so SCRAM
+// authentication can be attempted with all credentials of an account.
+//
+// The first group of an app password is an identifier, stored in plain text, so a
+// login attempt only has to check the bcrypt hash of a single app password.
+//
+// For accounts with encrypted messages, the private key is also sealed with the
+// app password when it is created, so a login with the app password unlocks the
+// key like a login with the account password. The key must be unlocked when
+// adding an app password.
+type AppPassword struct {
+	ID         int64
+	Name       string    `bstore:"nonzero,unique"`
+	IMAP       bool      // Valid for IMAP and ManageSieve.
+	Submission bool      // Valid for SMTP submission.
+	Created    time.Time `bstore:"nonzero,default now"`
+	LastUsed   time.Time // Zero if never used. Updated at most once per minute.
+
+	Prefix        string        `bstore:"nonzero,index" json:"-"` // First group of the password.
+	Credentials   Password      `json:"-"`
+	EncryptionKey EncryptionKey `json:"-"` // Zero if account had no encryption key.
+}
+
+// appPasswordPrefix returns the identifying prefix of password, if it has the
+// format of an app password.
+func appPasswordPrefix(password string) (string, bool) {
+	if len(password) != 24 {
+		return "", false
+	}
+	for i := 4; i < len(password); i += 5 {
+		if password[i] != '-' {
+			return "", false
+		}
+	}
+	return password[:4], true
+}
+
+// Allowed returns whether the app password is valid for scope.
+func (ap AppPassword) Allowed(scope AuthScope) bool {
+	switch scope {
+	case AuthScopeIMAP:
+		return ap.IMAP
+	case AuthScopeSubmission:
+		return ap.Submission
+	}
+	return false
+}
+
+// deriveSecrets sets the CRAM-MD5 and SCRAM secrets in pw for password. The SCRAM
+// salts and iterations in pw are used if set, new salts are generated otherwise.
+func deriveSecrets(pw *Password, password string) {
+	// CRAM-MD5 calculates an HMAC-MD5, with the password as key, over a per-attempt
+	// unique text that includes a timestamp. HMAC performs two hashes. Both times, the
+	// first block is based on the key/password. We hash those first blocks now, and
+	// store the hash state in the database. When we actually authenticate, we'll
+	// complete the HMAC by hashing only the text. We cannot store crypto/hmac's hash,
+	// because it does not expose its internal state and isn't a BinaryMarshaler.
+	// ../rfc/2104:121
+	pw.CRAMMD5.Ipad = md5.New()
+	pw.CRAMMD5.Opad = md5.New()
+	key := []byte(password)
+	if len(key) > 64 {
+		t := md5.Sum(key)
+		key = t[:]
+	}
+	ipad := make([]byte, md5.BlockSize)
+	opad := make([]byte, md5.BlockSize)
+	copy(ipad, key)
+	copy(opad, key)
+	for i := range ipad {
+		ipad[i] ^= 0x36
+		opad[i] ^= 0x5c
+	}
+	pw.CRAMMD5.Ipad.Write(ipad)
+	pw.CRAMMD5.Opad.Write(opad)
+
+	if len(pw.SCRAMSHA1.Salt) == 0 || pw.SCRAMSHA1.Iterations == 0 {
+		pw.SCRAMSHA1.Salt = scram.MakeRandom()
+		pw.SCRAMSHA1.Iterations = 2 * 4096
+	}
+	pw.SCRAMSHA1.SaltedPassword = scram.SaltPassword(sha1.New, password, pw.SCRAMSHA1.Salt, pw.SCRAMSHA1.Iterations)
+
+	if len(pw.SCRAMSHA256.Salt) == 0 || pw.SCRAMSHA256.Iterations == 0 {
+		pw.SCRAMSHA256.Salt = scram.MakeRandom()
+		pw.SCRAMSHA256.Iterations = 4096
+	}
+	pw.SCRAMSHA256.SaltedPassword = scram.SaltPassword(sha256.New, password, pw.SCRAMSHA256.Salt, pw.SCRAMSHA256.Iterations)
+}
+
+// AppPasswordList returns the app passwords of the account, without credentials.
+func (a *Account) AppPasswordList(ctx context.Context) ([]AppPassword, error) {
+	l, err := bstore.QueryDB[AppPassword](ctx, a.DB).SortAsc("Name").List()
+	for i := range l {
+		l[i].Credentials = Password{}
+		l[i].EncryptionKey = EncryptionKey{}
+	}
+	return l, err
+}
+
+// AppPasswordAdd generates a new app password with name, valid for IMAP and/or
+// SMTP submission. The generated password is returned, it is not stored and
+// cannot be retrieved later. If the account has an encryption key, it must be
+// unlocked, ErrEncryptionLocked is returned otherwise.
+func (a *Account) AppPasswordAdd(ctx context.Context, log mlog.Log, name string, imap, submission bool) (AppPassword, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return AppPassword{}, "", fmt.Errorf("%w: name required", ErrAppPassword)
+	} else if !imap && !submission {
+		return AppPassword{}, "", fmt.Errorf("%w: must be valid for imap and/or submission", ErrAppPassword)
+	}
+
+	// First group is the prefix, the remaining 80 bits are secret.
+	buf := make([]byte, 15)
+	if _, err := rand.Read(buf); err != nil {
+		return AppPassword{}, "", fmt.Errorf("generating password: %v", err)
+	}
+	s := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+	password := s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20]
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return AppPassword{}, "", fmt.Errorf("generating password hash: %w", err)
+	}
+
+	ap := AppPassword{Name: name, IMAP: imap, Submission: submission, Prefix: password[:4]}
+	err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		if exists, err := bstore.QueryTx[AppPassword](tx).FilterNonzero(AppPassword{Name: name}).Exists(); err != nil {
+			return fmt.Errorf("looking up app password: %v", err)
+		} else if exists {
+			return fmt.Errorf("%w: name already exists", ErrAppPassword)
+		}
+
+		// Use the SCRAM salts of the account password, see AppPassword.
+		pw, err := bstore.QueryTx[Password](tx).Get()
+		if err == bstore.ErrAbsent {
+			return fmt.Errorf("%w: account has no password", ErrAppPassword)
+		} else if err != nil {
+			return fmt.Errorf("looking up password: %v", err)
+		}
+		ap.Credentials = Password{
+			Hash:        string(hash),
+			SCRAMSHA1:   SCRAM{Salt: pw.SCRAMSHA1.Salt, Iterations: pw.SCRAMSHA1.Iterations},
+			SCRAMSHA256: SCRAM{Salt: pw.SCRAMSHA256.Salt, Iterations: pw.SCRAMSHA256.Iterations},
+		}
+		deriveSecrets(&ap.Credentials, password)
+
+		// Seal the encryption key with the app password, so logins with it can unlock it.
+		ek := EncryptionKey{ID: 1}
+		if err := tx.Get(&ek); err == nil {
+			key := unlockedKeyLookup(ek.PublicKey)
+			if key == nil {
+				return ErrEncryptionLocked
+			}
+			ap.EncryptionKey, err = sealEncryptionKey(key, password)
+			if err != nil {
+				return fmt.Errorf("sealing encryption key: %v", err)
+			}
+		} else if err != bstore.ErrAbsent {
+			return fmt.Errorf("get encryption key: %v", err)
+		}
+		return tx.Insert(&ap)
+	})
+	if err != nil {
+		return AppPassword{}, "", err
+	}
+	log.Info("app password added", slog.String("account", a.Name), slog.String("name", name), slog.Bool("imap", imap), slog.Bool("submission", submission))
+	ap.Credentials = Password{}
+	ap.EncryptionKey = EncryptionKey{}
+	return ap, password, nil
+}
+
+// AppPasswordRemove revokes an app password. Sessions that authenticated with the
+// app password are not closed.
+func (a *Account) AppPasswordRemove(ctx context.Context, log mlog.Log, id int64) error {
+	ap := AppPassword{ID: id}
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		if err := tx.Get(&ap); err == bstore.ErrAbsent {
+			return fmt.Errorf("%w: unknown app password", ErrAppPassword)
+		} else if err != nil {
+			return err
+		}
+		return tx.Delete(&ap)
+	})
+	if err != nil {
+		return err
+	}
+	authCacheRemove(ap.Credentials.Hash)
+	log.Info("app password removed", slog.String("account", a.Name), slog.String("name", ap.Name))
+	return nil
+}
+
+// AppPasswordsForScope returns the app passwords valid for scope, with their
+// credentials, for verifying authentication attempts with CRAM-MD5 and SCRAM.
+func AppPasswordsForScope(tx *bstore.Tx, scope AuthScope) ([]AppPassword, error) {
+	return bstore.QueryTx[AppPassword](tx).FilterFn(func(ap AppPassword) bool {
+		return ap.Allowed(scope)
+	}).List()
+}
+
+// AppPasswordUsed records the use of an app password for a successful
+// authentication.
+func (a *Account) AppPasswordUsed(log mlog.Log, ap AppPassword) {
+	now := time.Now()
+	if now.Sub(ap.LastUsed) < time.Minute {
+		return
+	}
+	err := a.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
+		xap := AppPassword{ID: ap.ID}
+		if err := tx.Get(&xap); err != nil {
+			return err
+		}
+		xap.LastUsed = now
+		return tx.Update(&xap)
+	})
+	log.Check(err, "updating last use of app password")
+}
+
+// authAppPassword checks password against the app passwords valid for scope,
+// returning the matching app password. Only app passwords with the prefix of
+// password are checked, normally at most one.
+func (a *Account) authAppPassword(email, password string, scope AuthScope) (AppPassword, bool, error) {
+	prefix, ok := appPasswordPrefix(password)
+	if !ok {
+		return AppPassword{}, false, nil
+	}
+	var l []AppPassword
+	err := a.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+		var err error
+		q := bstore.QueryTx[AppPassword](tx)
+		q.FilterNonzero(AppPassword{Prefix: prefix})
+		q.FilterFn(func(ap AppPassword) bool {
+			return ap.Allowed(scope)
+		})
+		l, err = q.List()
+		return err
+	})
+	if err != nil {
+		return AppPassword{}, false, fmt.Errorf("listing app passwords: %v", err)
+	}
+	for _, ap := range l {
+		key := authKey{email, ap.Credentials.Hash}
+		authCache.Lock()
+		ok := len(password) >= 8 && authCache.success[key] == password
+		authCache.Unlock()
+		if ok {
+			return ap, true, nil
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(ap.Credentials.Hash), []byte(password)); err == nil {
+			authCache.Lock()
+			authCache.success[key] = password
+			authCache.Unlock()
+			return ap, true, nil
+		}
+	}
+	return AppPassword{}, false, nil
+}
+
+// authCacheRemove removes cached successful authentications for a password hash.
+func authCacheRemove(hash string) {
+	authCache.Lock()
+	defer authCache.Unlock()
+	for k := range authCache.success {
+		if k.hash == hash {
+			delete(authCache.success, k)
+		}
+	}
+}
+
+// AuthCRAMMD5 verifies the hexadecimal digest of a CRAM-MD5 response for
+// challenge against the secrets of the account password pw and of the app
+// passwords. If an app password matched, its use is recorded and its name
+// returned.
+func (a *Account) AuthCRAMMD5(log mlog.Log, pw Password, appPasswords []AppPassword, challenge, digest string) (ok bool, appPassword string) {
+	verify := func(c CRAMMD5) bool {
+		if c.Ipad == nil || c.Opad == nil {
+			return false
+		}
+		// ../rfc/2195:138 ../rfc/2104:142
+		c.Ipad.Write([]byte(challenge))
+		c.Opad.Write(c.Ipad.Sum(nil))
+		return fmt.Sprintf("%x", c.Opad.Sum(nil)) == digest
+	}
+	if verify(pw.CRAMMD5) {
+		return true, ""
+	}
+	for _, ap := range appPasswords {
+		if verify(ap.Credentials.CRAMMD5) {
+			a.AppPasswordUsed(log, ap)
+			return true, ap.Name
+		}
+	}
+	return false, ""
+}
+
+// AuthSCRAMFinish finishes a SCRAM authentication, verifying the client proof
+// against the salted account password in xscram, and if that fails, against the
+// app passwords with the same salt and iterations. If an app password matched,
+// its use is recorded and its name returned.
+func (a *Account) AuthSCRAMFinish(log mlog.Log, ss *scram.Server, clientFinal []byte, xscram SCRAM, appPasswords []AppPassword, sha256 bool) (serverFinal string, appPassword string, rerr error) {
+	serverFinal, rerr = ss.Finish(clientFinal, xscram.SaltedPassword)
+	if !errors.Is(rerr, scram.ErrInvalidProof) {
+		return serverFinal, "", rerr
+	}
+	for _, ap := range appPasswords {
+		s := ap.Credentials.SCRAMSHA1
+		if sha256 {
+			s = ap.Credentials.SCRAMSHA256
+		}
+		if !bytes.Equal(s.Salt, xscram.Salt) || s.Iterations != xscram.Iterations || len(s.SaltedPassword) == 0 {
+			continue
+		}
+		if sf, err := ss.Finish(clientFinal, s.SaltedPassword); err == nil {
+			a.AppPasswordUsed(log, ap)
+			return sf, ap.Name, nil
+		}
+	}
+	return serverFinal, "", rerr
+}
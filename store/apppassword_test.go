@@ -0,0 +1,163 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/scram"
+)
+
+func TestAppPassword(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+	}()
+	defer Switchboard()()
+
+	// App passwords need the SCRAM salts of the account password.
+	_, _, err = acc.AppPasswordAdd(ctxbg, log, "phone", true, false)
+	if !errors.Is(err, ErrAppPassword) {
+		t.Fatalf("got err %v, expected ErrAppPassword for account without password", err)
+	}
+
+	err = acc.SetPassword(log, "testtest")
+	tcheck(t, err, "set password")
+
+	_, _, err = acc.AppPasswordAdd(ctxbg, log, "", true, false)
+	if !errors.Is(err, ErrAppPassword) {
+		t.Fatalf("got err %v, expected ErrAppPassword for empty name", err)
+	}
+	_, _, err = acc.AppPasswordAdd(ctxbg, log, "phone", false, false)
+	if !errors.Is(err, ErrAppPassword) {
+		t.Fatalf("got err %v, expected ErrAppPassword without scopes", err)
+	}
+
+	phone, phonePassword, err := acc.AppPasswordAdd(ctxbg, log, "phone", true, false)
+	tcheck(t, err, "add app password")
+	_, _, err = acc.AppPasswordAdd(ctxbg, log, "phone", true, true)
+	if !errors.Is(err, ErrAppPassword) {
+		t.Fatalf("got err %v, expected ErrAppPassword for duplicate name", err)
+	}
+	laptop, laptopPassword, err := acc.AppPasswordAdd(ctxbg, log, "laptop", true, true)
+	tcheck(t, err, "add app password")
+
+	l, err := acc.AppPasswordList(ctxbg)
+	tcheck(t, err, "list app passwords")
+	if len(l) != 2 || l[0].Name != "laptop" || l[1].Name != "phone" || l[0].Credentials.Hash != "" {
+		t.Fatalf("unexpected app passwords %v", l)
+	}
+
+	auth := func(password string, scope AuthScope, expErr error, expAppPassword string) {
+		t.Helper()
+		acc2, appPassword, err := OpenEmailAuth(log, "mjl@mox.example", password, scope)
+		if err != expErr {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+		if err != nil {
+			return
+		}
+		err = acc2.Close()
+		tcheck(t, err, "close account")
+		if appPassword != expAppPassword {
+			t.Fatalf("got app password %q, expected %q", appPassword, expAppPassword)
+		}
+	}
+
+	// Run twice for possible cache effects.
+	for i := 0; i < 2; i++ {
+		auth("testtest", AuthScopeWeb, nil, "")
+		auth("testtest", AuthScopeSubmission, nil, "")
+		auth(phonePassword, AuthScopeIMAP, nil, "phone")
+		auth(phonePassword, AuthScopeSubmission, ErrUnknownCredentials, "")
+		auth(phonePassword, AuthScopeWeb, ErrUnknownCredentials, "")
+		auth(laptopPassword, AuthScopeSubmission, nil, "laptop")
+		auth(laptopPassword, AuthScopeWeb, ErrUnknownCredentials, "")
+		// Same prefix, different secret.
+		auth(laptopPassword[:5]+"aaaa-aaaa-aaaa-aaaa", AuthScopeSubmission, ErrUnknownCredentials, "")
+	}
+
+	xphone := AppPassword{ID: phone.ID}
+	err = acc.DB.Get(ctxbg, &xphone)
+	tcheck(t, err, "get app password")
+	if xphone.LastUsed.IsZero() {
+		t.Fatalf("last use of app password not recorded")
+	}
+
+	// CRAM-MD5 and SCRAM verify against all credentials.
+	var pw Password
+	var appPasswords []AppPassword
+	err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+		pw, err = bstore.QueryTx[Password](tx).Get()
+		tcheck(t, err, "get password")
+		appPasswords, err = AppPasswordsForScope(tx, AuthScopeSubmission)
+		return err
+	})
+	tcheck(t, err, "read app passwords")
+	if len(appPasswords) != 1 || appPasswords[0].Name != "laptop" {
+		t.Fatalf("unexpected app passwords for submission %v", appPasswords)
+	}
+
+	cram := func(password string, expOK bool, expAppPassword string) {
+		t.Helper()
+		const chal = "<123.456@mox.example>"
+		mac := hmac.New(md5.New, []byte(password))
+		mac.Write([]byte(chal))
+		ok, appPassword := acc.AuthCRAMMD5(log, pw, appPasswords, chal, fmt.Sprintf("%x", mac.Sum(nil)))
+		if ok != expOK || appPassword != expAppPassword {
+			t.Fatalf("cram-md5: got %v %q, expected %v %q", ok, appPassword, expOK, expAppPassword)
+		}
+	}
+	cram("testtest", true, "")
+	cram(laptopPassword, true, "laptop")
+	cram(phonePassword, false, "")
+
+	xscram := func(password string, expErr error, expAppPassword string) {
+		t.Helper()
+		sc := scram.NewClient(sha256.New, "mjl@mox.example", "", false, nil)
+		clientFirst, err := sc.ClientFirst()
+		tcheck(t, err, "scram client first")
+		ss, err := scram.NewServer(sha256.New, []byte(clientFirst), nil, false)
+		tcheck(t, err, "scram new server")
+		serverFirst, err := ss.ServerFirst(pw.SCRAMSHA256.Iterations, pw.SCRAMSHA256.Salt)
+		tcheck(t, err, "scram server first")
+		clientFinal, err := sc.ServerFirst([]byte(serverFirst), password)
+		tcheck(t, err, "scram client final")
+		_, appPassword, err := acc.AuthSCRAMFinish(log, ss, []byte(clientFinal), pw.SCRAMSHA256, appPasswords, true)
+		if !errors.Is(err, expErr) || appPassword != expAppPassword {
+			t.Fatalf("scram: got %v %q, expected %v %q", err, appPassword, expErr, expAppPassword)
+		}
+	}
+	xscram("testtest", nil, "")
+	xscram(laptopPassword, nil, "laptop")
+	xscram(phonePassword, scram.ErrInvalidProof, "")
+
+	// Changing the account password keeps app passwords working.
+	err = acc.SetPassword(log, "testtest2")
+	tcheck(t, err, "set password")
+	auth(laptopPassword, AuthScopeSubmission, nil, "laptop")
+
+	// Removed app passwords are no longer accepted, also not from the cache.
+	err = acc.AppPasswordRemove(ctxbg, log, laptop.ID)
+	tcheck(t, err, "remove app password")
+	auth(laptopPassword, AuthScopeSubmission, ErrUnknownCredentials, "")
+	err = acc.AppPasswordRemove(ctxbg, log, laptop.ID)
+	if !errors.Is(err, ErrAppPassword) {
+		t.Fatalf("got err %v, expected ErrAppPassword for removed app password", err)
+	}
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/textextract"
+)
+
+// AttachmentText is the text extracted from the attachments of a message during
+// delivery, for searching with WordSearch and the word index. Only stored for
+// messages with text in attachments.
+type AttachmentText struct {
+	ID   int64 // Same as Message.ID.
+	Text string
+}
+
+// Limits for extracting text from attachments. Extraction happens before
+// delivery with PrepareAttachmentText, or otherwise during delivery, with the
+// account write lock held.
+const (
+	attachmentTextMaxSize = 32 * 1024 * 1024 // Larger attachments are skipped.
+	attachmentTextMax     = 1024 * 1024      // Max text for all attachments of a message.
+	attachmentTextTimeout = 10 * time.Second // For all attachments of a message.
+)
+
+// Attachments with text that are not already searched as text part, because they
+// have a non-text content-type, e.g. application/octet-stream.
+var attachmentTextExtensions = map[string]bool{
+	".txt":  true,
+	".text": true,
+	".csv":  true,
+	".md":   true,
+	".log":  true,
+}
+
+// extractAttachmentText returns the text of the PDF, OpenDocument, Office Open
+// XML and plain text attachments of p, which must have a reader set. Extraction is
+// best-effort, errors are logged.
+func extractAttachmentText(log mlog.Log, p *message.Part) string {
+	ctx, cancel := context.WithTimeout(mox.Context, attachmentTextTimeout)
+	defer cancel()
+
+	var b strings.Builder
+	attachmentParts(p, func(ap *message.Part) {
+		remaining := attachmentTextMax - b.Len()
+		if remaining <= 0 || ctx.Err() != nil || ap.MediaType == "TEXT" {
+			return
+		}
+
+		var extract func(ctx context.Context, r io.ReaderAt, size int64, maxText int) (string, error)
+		name := TryDecodeParam(log, ap.ContentTypeParams["name"])
+		switch attachmentType(log, ap) {
+		case AttachmentPDF:
+			extract = textextract.PDF
+		case AttachmentDocument, AttachmentSpreadsheet, AttachmentPresentation:
+			extract = textextract.Office
+		default:
+			if !attachmentTextExtensions[strings.ToLower(filepath.Ext(name))] {
+				return
+			}
+			extract = func(ctx context.Context, r io.ReaderAt, size int64, maxText int) (string, error) {
+				buf, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+				if len(buf) > maxText {
+					buf = buf[:maxText]
+				}
+				return strings.ToValidUTF8(string(buf), ""), err
+			}
+		}
+
+		buf, err := io.ReadAll(io.LimitReader(ap.ReaderUTF8OrBinary(), attachmentTextMaxSize+1))
+		if err != nil {
+			log.Debugx("reading attachment for text extraction", err, slog.String("name", name))
+			return
+		} else if len(buf) > attachmentTextMaxSize {
+			log.Debug("attachment too large for text extraction", slog.String("name", name))
+			return
+		}
+		text, err := extractRecover(ctx, log, extract, bytes.NewReader(buf), int64(len(buf)), remaining)
+		if err != nil {
+			log.Debugx("extracting text from attachment, continuing", err, slog.String("name", name))
+		}
+		if text != "" {
+			b.WriteString(text)
+			b.WriteString("\n")
+		}
+	})
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Info("timeout extracting text from attachments, text may be incomplete")
+	}
+	return b.String()
+}
+
+// extractRecover calls extract, returning a panic as error. The extractors parse
+// complicated file formats from untrusted messages, a bug must not take down
+// delivery.
+func extractRecover(ctx context.Context, log mlog.Log, extract func(ctx context.Context, r io.ReaderAt, size int64, maxText int) (string, error), r io.ReaderAt, size int64, maxText int) (text string, rerr error) {
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("extracting text from attachment panic", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Store)
+			rerr = fmt.Errorf("panic during text extraction: %v", x)
+		}
+	}()
+	return extract(ctx, r, size, maxText)
+}
+
+// PrepareAttachmentText parses message m in msgFile if it isn't parsed yet, and
+// extracts the text from its attachments, for storing by DeliverMessage.
+// Extraction can take a while, so this should be called before taking the
+// account write lock for delivery. Without it, text is extracted during delivery.
+// No text is extracted for accounts that don't store message text, see
+// StoreMessageText.
+func (a *Account) PrepareAttachmentText(log mlog.Log, m *Message, msgFile *os.File) error {
+	mr := fileMsgReader(m.MsgPrefix, msgFile, m.FileCompressed, m.FileEncrypted) // We don't close, it would close the msgFile.
+	var p message.Part
+	if m.ParsedBuf == nil {
+		var err error
+		p, err = message.EnsurePart(log.Logger, false, mr, m.Size)
+		if err != nil {
+			log.Infox("parsing message, continuing", err, slog.String("parse", ""))
+			// We continue, p is still valid.
+		}
+		m.ParsedBuf, err = json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("marshal parsed message: %w", err)
+		}
+	} else if err := json.Unmarshal(m.ParsedBuf, &p); err != nil {
+		return fmt.Errorf("unmarshal parsed message: %w", err)
+	}
+	if !a.StoreMessageText() {
+		return nil
+	}
+	p.SetReaderAt(mr)
+	text := extractAttachmentText(log, &p)
+	m.attachmentText = &text
+	return nil
+}
+
+// attachmentTextAdd extracts the text from the attachments of message m with
+// parsed part p, unless already done by PrepareAttachmentText, stores it, and
+// returns it.
+func attachmentTextAdd(log mlog.Log, tx *bstore.Tx, m *Message, p *message.Part) (string, error) {
+	var text string
+	if m.attachmentText != nil {
+		text = *m.attachmentText
+	} else {
+		text = extractAttachmentText(log, p)
+	}
+	if text == "" {
+		return "", nil
+	}
+	if err := tx.Insert(&AttachmentText{ID: m.ID, Text: text}); err != nil {
+		return "", fmt.Errorf("inserting attachment text: %w", err)
+	}
+	return text, nil
+}
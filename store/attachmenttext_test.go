@@ -0,0 +1,21 @@
+package store
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+func TestExtractRecover(t *testing.T) {
+	log := mlog.New("store", nil)
+	extract := func(ctx context.Context, r io.ReaderAt, size int64, maxText int) (string, error) {
+		panic("bad document")
+	}
+	text, err := extractRecover(ctxbg, log, extract, strings.NewReader("test"), 4, 100)
+	if err == nil || text != "" {
+		t.Fatalf("got text %q, err %v, expected error for panic", text, err)
+	}
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// ErrAutoReply is returned for invalid auto-responder settings.
+var ErrAutoReply = errors.New("invalid automatic reply")
+
+// AutoReply holds the settings for the vacation/out-of-office auto-responder of
+// an account, managed through the account web interface. There is at most one,
+// with ID 1.
+type AutoReply struct {
+	ID      int64
+	Enabled bool
+	Start   *time.Time // If set, no replies are sent before this time.
+	End     *time.Time // If set, no replies are sent after this time.
+	Subject string     // If empty, the subject of the incoming message prefixed with "Auto: ".
+	Body    string     // Plain text.
+}
+
+// Active returns whether automatic replies should be sent at time now.
+func (ar AutoReply) Active(now time.Time) bool {
+	return ar.Enabled && (ar.Start == nil || !now.Before(*ar.Start)) && (ar.End == nil || now.Before(*ar.End))
+}
+
+// AutoReplyGet returns the auto-responder settings. If never saved, a zero
+// value with ID 1 is returned.
+func (a *Account) AutoReplyGet(ctx context.Context) (AutoReply, error) {
+	ar := AutoReply{ID: 1}
+	err := a.DB.Get(ctx, &ar)
+	if err == bstore.ErrAbsent {
+		err = nil
+	}
+	return ar, err
+}
+
+// AutoReplySave stores the auto-responder settings. An enabled auto-responder
+// must have a body, and an end after its start if both are set.
+func (a *Account) AutoReplySave(ctx context.Context, ar AutoReply) error {
+	if ar.Enabled && ar.Body == "" {
+		return fmt.Errorf("%w: body required", ErrAutoReply)
+	} else if ar.Start != nil && ar.End != nil && !ar.End.After(*ar.Start) {
+		return fmt.Errorf("%w: end of period must be after start", ErrAutoReply)
+	}
+	ar.ID = 1
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		exists, err := bstore.QueryTx[AutoReply](tx).Exists()
+		if err != nil {
+			return fmt.Errorf("looking up automatic reply: %v", err)
+		} else if exists {
+			return tx.Update(&ar)
+		}
+		return tx.Insert(&ar)
+	})
+}
@@ -0,0 +1,474 @@
+package store
+
+import (
+	"bufio"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// Message files can be stored compressed, see CompressMessages in the account
+// config. A compressed message file starts with a header with a magic value,
+// followed by blocks of DEFLATE-compressed data that each hold a fixed amount of
+// the original file, followed by an index with the file offsets of the blocks.
+// Reading at an offset only requires decompressing the blocks holding the data,
+// needed for io.ReaderAt users like message parsing and IMAP partial fetches.
+//
+// Whether a file is compressed is recorded in Message.FileCompressed when mox
+// writes the file. Files are never recognized as compressed by their contents,
+// which come from outside, e.g. through SMTP or IMAP APPEND. Compressed and
+// uncompressed files can be mixed, and message files can still be linked and
+// copied as is, e.g. for backups and when copying messages.
+//
+// Layout, integers are big endian:
+//
+//	magic "\x00moxz01\n" (8 bytes)
+//	uncompressed size (8 bytes)
+//	block size (4 bytes)
+//	compressed blocks
+//	file offsets of the compressed blocks (8 bytes each)
+//	file offset of the block offsets (8 bytes)
+const (
+	compressMagic      = "\x00moxz01\n"
+	compressHeaderSize = len(compressMagic) + 8 + 4
+	compressBlockSize  = 128 * 1024
+)
+
+// compressedReader reads from a compressed message file.
+type compressedReader struct {
+	f         io.ReaderAt
+	size      int64   // Uncompressed size.
+	blockSize int64   // Uncompressed size of each block, except the last.
+	offsets   []int64 // File offsets of blocks, with the offset of the index as last element.
+
+	sync.Mutex
+	block int    // Block currently in buf, -1 if none.
+	buf   []byte // Decompressed data of block.
+}
+
+// openCompressed returns a reader for the compressed file f of fileSize bytes.
+func openCompressed(f io.ReaderAt, fileSize int64) (*compressedReader, error) {
+	if fileSize < int64(compressHeaderSize)+8 {
+		return nil, fmt.Errorf("compressed message file too small")
+	}
+	var hdr [compressHeaderSize]byte
+	if _, err := f.ReadAt(hdr[:], 0); err != nil {
+		return nil, fmt.Errorf("reading message file header: %w", err)
+	}
+	if string(hdr[:len(compressMagic)]) != compressMagic {
+		return nil, fmt.Errorf("message file is not compressed")
+	}
+	size := int64(binary.BigEndian.Uint64(hdr[len(compressMagic):]))
+	blockSize := int64(binary.BigEndian.Uint32(hdr[len(compressMagic)+8:]))
+	if size < 0 || blockSize != compressBlockSize {
+		return nil, fmt.Errorf("invalid compressed message file header")
+	}
+	nblocks := (size + blockSize - 1) / blockSize
+
+	var buf [8]byte
+	if _, err := f.ReadAt(buf[:], fileSize-8); err != nil {
+		return nil, fmt.Errorf("reading compressed message file index offset: %w", err)
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(buf[:]))
+	if indexOffset < int64(compressHeaderSize) || fileSize-8-indexOffset != nblocks*8 {
+		return nil, fmt.Errorf("invalid compressed message file index offset %d", indexOffset)
+	}
+	index := make([]byte, nblocks*8)
+	if _, err := f.ReadAt(index, indexOffset); err != nil {
+		return nil, fmt.Errorf("reading compressed message file index: %w", err)
+	}
+	offsets := make([]int64, nblocks+1)
+	last := int64(compressHeaderSize)
+	for i := range offsets[:nblocks] {
+		o := int64(binary.BigEndian.Uint64(index[i*8:]))
+		if o < last || o > indexOffset {
+			return nil, fmt.Errorf("invalid offset %d for block %d in compressed message file", o, i)
+		}
+		offsets[i] = o
+		last = o
+	}
+	offsets[nblocks] = indexOffset
+
+	return &compressedReader{f: f, size: size, blockSize: blockSize, offsets: offsets, block: -1}, nil
+}
+
+// ReadAt reads uncompressed data at offset off.
+func (r *compressedReader) ReadAt(buf []byte, off int64) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	var n int
+	for n < len(buf) {
+		if off >= r.size {
+			return n, io.EOF
+		}
+		b := int(off / r.blockSize)
+		if err := r.load(b); err != nil {
+			return n, err
+		}
+		k := copy(buf[n:], r.buf[off-int64(b)*r.blockSize:])
+		n += k
+		off += int64(k)
+	}
+	return n, nil
+}
+
+// load decompresses block b into buf.
+func (r *compressedReader) load(b int) error {
+	if r.block == b {
+		return nil
+	}
+	r.block = -1
+
+	size := r.blockSize
+	if rem := r.size - int64(b)*r.blockSize; rem < size {
+		size = rem
+	}
+	if int64(cap(r.buf)) < size {
+		r.buf = make([]byte, size)
+	}
+	r.buf = r.buf[:size]
+
+	start, end := r.offsets[b], r.offsets[b+1]
+	fr := flate.NewReader(io.NewSectionReader(r.f, start, end-start))
+	defer fr.Close()
+	if _, err := io.ReadFull(fr, r.buf); err != nil {
+		return fmt.Errorf("decompressing block %d of message file: %w", b, err)
+	}
+	r.block = b
+	return nil
+}
+
+// msgFileReader returns a reader for the data of message file f, decrypted and
+// decompressed as indicated by compressed and encrypted, along with the size of
+// that data.
+func msgFileReader(f *os.File, compressed, encrypted bool) (io.ReaderAt, int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	var r io.ReaderAt = f
+	size := fi.Size()
+	if encrypted {
+		er, err := openEncrypted(f, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		r, size = er, er.size
+	}
+	if compressed {
+		cr, err := openCompressed(r, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		r, size = cr, cr.size
+	}
+	return r, size, nil
+}
+
+// MessageFileSize returns the size of the message file at path, stored
+// compressed and/or encrypted as indicated. For compressed and/or encrypted files,
+// the size of the original data is returned, which does not require the key for
+// encrypted files.
+func MessageFileSize(path string, compressed, encrypted bool) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if encrypted {
+		return encryptedFileSize(f, fi.Size())
+	}
+	_, size, err := msgFileReader(f, compressed, false)
+	return size, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(buf []byte) (int, error) {
+	n, err := w.w.Write(buf)
+	w.n += int64(n)
+	return n, err
+}
+
+// writeCompressed writes the size bytes read from src to w in compressed form.
+func writeCompressed(w io.Writer, src io.Reader, size int64) error {
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	var hdr [compressHeaderSize]byte
+	copy(hdr[:], compressMagic)
+	binary.BigEndian.PutUint64(hdr[len(compressMagic):], uint64(size))
+	binary.BigEndian.PutUint32(hdr[len(compressMagic)+8:], compressBlockSize)
+	if _, err := cw.Write(hdr[:]); err != nil {
+		return err
+	}
+
+	fw, err := flate.NewWriter(cw, flate.DefaultCompression)
+	if err != nil {
+		return fmt.Errorf("making compressor: %v", err)
+	}
+	var offsets []int64
+	for o := int64(0); o < size; o += compressBlockSize {
+		offsets = append(offsets, cw.n)
+		n := int64(compressBlockSize)
+		if size-o < n {
+			n = size - o
+		}
+		fw.Reset(cw)
+		if _, err := io.CopyN(fw, src, n); err != nil {
+			return fmt.Errorf("compressing block: %w", err)
+		}
+		if err := fw.Close(); err != nil {
+			return fmt.Errorf("compressing block: %w", err)
+		}
+	}
+
+	indexOffset := cw.n
+	var buf [8]byte
+	for _, o := range append(offsets, indexOffset) {
+		binary.BigEndian.PutUint64(buf[:], uint64(o))
+		if _, err := cw.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeMessageFile creates a new file at path with the contents of plain message
+// file src, compressed and/or encrypted to the account public key if not nil.
+func writeMessageFile(log mlog.Log, path string, src *os.File, sync, compress bool, encryptPublicKey []byte) (rerr error) {
+	fi, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("stat message file: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return fmt.Errorf("create message file: %w", err)
+	}
+	defer func() {
+		if f != nil {
+			err := f.Close()
+			log.Check(err, "closing message file after error")
+		}
+		if rerr != nil {
+			err := os.Remove(path)
+			log.Check(err, "removing message file after error", slog.String("path", path))
+		}
+	}()
+	if err := encodeMessageFile(f, src, fi.Size(), false, compress, encryptPublicKey); err != nil {
+		return err
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("fsync message file: %w", err)
+		}
+	}
+	err = f.Close()
+	f = nil
+	return err
+}
+
+// encodeMessageFile writes the size bytes from message file src to w, compressed
+// if compress is set and src isn't already compressed, and encrypted to the
+// account public key if not nil.
+func encodeMessageFile(w io.Writer, src io.ReaderAt, size int64, srcCompressed, compress bool, encryptPublicKey []byte) error {
+	dataSize := size
+	if srcCompressed {
+		cr, err := openCompressed(src, size)
+		if err != nil {
+			return err
+		}
+		dataSize = cr.size
+	}
+
+	var ew *encryptWriter
+	if encryptPublicKey != nil {
+		var err error
+		ew, err = newEncryptWriter(w, encryptPublicKey, dataSize)
+		if err != nil {
+			return fmt.Errorf("encrypting message file: %w", err)
+		}
+		w = ew
+	}
+	r := io.NewSectionReader(src, 0, size)
+	if compress && !srcCompressed {
+		if err := writeCompressed(w, r, size); err != nil {
+			return fmt.Errorf("writing compressed message file: %w", err)
+		}
+	} else if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("writing message file: %w", err)
+	}
+	if ew != nil {
+		if err := ew.Close(); err != nil {
+			return fmt.Errorf("encrypting message file: %w", err)
+		}
+	}
+	return nil
+}
+
+// recodeMessageFile replaces the file of message m with a compressed and/or
+// encrypted version, updating the file encoding of m in the database in tx before
+// replacing the file. Encrypted files are left as is. Without encryption, files
+// are left as is if already compressed, or when compression doesn't make them
+// smaller. Returns the sizes of the file before and after.
+func (a *Account) recodeMessageFile(log mlog.Log, tx *bstore.Tx, m *Message, compress bool, encryptPublicKey []byte) (changed bool, size, newSize int64, rerr error) {
+	if m.FileEncrypted || encryptPublicKey == nil && (m.FileCompressed || !compress) {
+		return false, 0, 0, nil
+	}
+
+	path := a.MessagePath(m.ID)
+	f, err := os.Open(path)
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("open message file: %w", err)
+	}
+	defer func() {
+		err := f.Close()
+		log.Check(err, "closing message file")
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("stat message file: %v", err)
+	}
+	size = fi.Size()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "recode-*.tmp")
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("create temporary file: %v", err)
+	}
+	defer func() {
+		if tmp != nil {
+			err := tmp.Close()
+			log.Check(err, "closing temporary file")
+			err = os.Remove(tmp.Name())
+			log.Check(err, "removing temporary file", slog.String("path", tmp.Name()))
+		}
+	}()
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return false, 0, 0, fmt.Errorf("set mode of temporary file: %v", err)
+	}
+	if err := encodeMessageFile(tmp, f, size, m.FileCompressed, compress, encryptPublicKey); err != nil {
+		return false, 0, 0, err
+	}
+	tfi, err := tmp.Stat()
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("stat temporary file: %v", err)
+	}
+	if encryptPublicKey == nil && tfi.Size() >= size {
+		return false, 0, 0, nil
+	}
+	if err := tmp.Sync(); err != nil {
+		return false, 0, 0, fmt.Errorf("fsync new message file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return false, 0, 0, fmt.Errorf("closing new message file: %v", err)
+	}
+
+	m.FileCompressed = m.FileCompressed || compress
+	m.FileEncrypted = encryptPublicKey != nil
+	if err := tx.Update(m); err != nil {
+		return false, 0, 0, fmt.Errorf("updating message file encoding: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		err = fmt.Errorf("replacing message file: %v", err)
+		xerr := os.Remove(tmp.Name())
+		log.Check(xerr, "removing temporary file", slog.String("path", tmp.Name()))
+		tmp = nil
+		return false, 0, 0, err
+	}
+	tmp = nil
+	return true, size, tfi.Size(), nil
+}
+
+// CompressMessages compresses the message files of all messages in the account
+// that are not yet compressed, replacing the files. Files that would not become
+// smaller, and encrypted files, are left as is. The number of compressed messages,
+// and their total file sizes before and after compression, are returned.
+func (a *Account) CompressMessages(ctx context.Context, log mlog.Log, batchSize int) (n int, size, compressedSize int64, rerr error) {
+	return a.recodeMessages(ctx, log, batchSize, true, nil)
+}
+
+// recodeMessages calls recodeMessageFile for all messages. Messages are processed
+// in batches, holding the account write lock, so message files aren't removed
+// while being replaced. Each message is recoded in its own transaction, so the
+// file encoding in the database matches the file.
+func (a *Account) recodeMessages(ctx context.Context, log mlog.Log, batchSize int, compress bool, encryptPublicKey []byte) (n int, size, newSize int64, rerr error) {
+	var lastID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, size, newSize, err
+		}
+
+		var ids []int64
+		var err error
+		a.WithWLock(func() {
+			err = a.DB.Read(ctx, func(tx *bstore.Tx) error {
+				q := bstore.QueryTx[Message](tx)
+				q.FilterEqual("Expunged", false)
+				q.FilterGreater("ID", lastID)
+				q.SortAsc("ID")
+				q.Limit(batchSize)
+				return q.IDs(&ids)
+			})
+			if err != nil {
+				err = fmt.Errorf("listing messages: %w", err)
+				return
+			}
+
+			for _, id := range ids {
+				var changed bool
+				var origSize, xsize int64
+				xerr := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+					m := Message{ID: id}
+					if err := tx.Get(&m); err != nil {
+						return fmt.Errorf("get message: %w", err)
+					}
+					var err error
+					changed, origSize, xsize, err = a.recodeMessageFile(log, tx, &m, compress, encryptPublicKey)
+					return err
+				})
+				if errors.Is(xerr, os.ErrNotExist) {
+					log.Errorx("replacing message file, continuing", xerr, slog.Int64("msgid", id))
+					continue
+				} else if xerr != nil {
+					err = fmt.Errorf("replacing message file for message %d: %w", id, xerr)
+					return
+				}
+				if changed {
+					n++
+					size += origSize
+					newSize += xsize
+				}
+			}
+		})
+		if err != nil {
+			return n, size, newSize, err
+		}
+		if len(ids) < batchSize {
+			return n, size, newSize, nil
+		}
+		lastID = ids[len(ids)-1]
+	}
+}
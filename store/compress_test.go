@@ -0,0 +1,218 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestCompressedFile(t *testing.T) {
+	// Spans multiple blocks, with a partial last block.
+	var data []byte
+	for i := 0; len(data) < 3*compressBlockSize+1000; i++ {
+		data = append(data, fmt.Sprintf("line %d\r\n", i)...)
+	}
+
+	var buf bytes.Buffer
+	err := writeCompressed(&buf, bytes.NewReader(data), int64(len(data)))
+	tcheck(t, err, "write compressed")
+	if buf.Len() >= len(data) {
+		t.Fatalf("compressed size %d not smaller than original %d", buf.Len(), len(data))
+	}
+
+	cr, err := openCompressed(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	tcheck(t, err, "open compressed")
+	if cr == nil || cr.size != int64(len(data)) {
+		t.Fatalf("bad compressed reader %v", cr)
+	}
+	for _, off := range []int64{0, 1, compressBlockSize - 10, 2*compressBlockSize + 5, int64(len(data)) - 10} {
+		xbuf := make([]byte, 100)
+		n, err := cr.ReadAt(xbuf, off)
+		exp := data[off:]
+		if len(exp) > len(xbuf) {
+			exp = exp[:len(xbuf)]
+		} else if err != io.EOF {
+			t.Fatalf("readat at end, got err %v, expected io.EOF", err)
+		} else {
+			err = nil
+		}
+		tcheck(t, err, "readat")
+		if !bytes.Equal(xbuf[:n], exp) {
+			t.Fatalf("readat at offset %d, got %q, expected %q", off, xbuf[:n], exp)
+		}
+	}
+	if n, err := cr.ReadAt(make([]byte, 1), int64(len(data))); n != 0 || err != io.EOF {
+		t.Fatalf("readat beyond end, got n %d, err %v, expected io.EOF", n, err)
+	}
+
+	// Uncompressed data is an error, also when empty.
+	for _, s := range []string{"", "Subject: test\r\n\r\ntest with enough data to not be too short\r\n"} {
+		if _, err := openCompressed(bytes.NewReader([]byte(s)), int64(len(s))); err == nil {
+			t.Fatalf("open uncompressed data, expected error")
+		}
+	}
+
+	// Only our own block size is accepted, a header from outside cannot make us
+	// allocate large buffers.
+	xdata := append([]byte{}, buf.Bytes()...)
+	binary.BigEndian.PutUint32(xdata[len(compressMagic)+8:], 1<<31)
+	if _, err := openCompressed(bytes.NewReader(xdata), int64(len(xdata))); err == nil {
+		t.Fatalf("open compressed data with bad block size, expected error")
+	}
+
+	// Empty data, without blocks.
+	buf.Reset()
+	err = writeCompressed(&buf, bytes.NewReader(nil), 0)
+	tcheck(t, err, "write compressed")
+	cr, err = openCompressed(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	tcheck(t, err, "open compressed")
+	if cr == nil || cr.size != 0 {
+		t.Fatalf("bad compressed reader for empty data %v", cr)
+	}
+
+	// Corrupt index is an error.
+	xdata = append([]byte{}, buf.Bytes()...)
+	xdata[len(xdata)-1]++
+	if _, err := openCompressed(bytes.NewReader(xdata), int64(len(xdata))); err == nil {
+		t.Fatalf("open corrupt compressed data, expected error")
+	}
+}
+
+func TestCompressMessages(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+	}()
+	defer Switchboard()()
+
+	accConf := mox.Conf.Dynamic.Accounts["mjl"]
+	defer func() {
+		accConf.CompressMessages = false
+		mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	}()
+
+	msg := "Subject: compressed\r\n\r\n" + string(bytes.Repeat([]byte("test test test\r\n"), 1000))
+	prefix := []byte("Received: from localhost\r\n")
+
+	deliver := func() Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "compress-test")
+		tcheck(t, err, "create temp file")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		var m Message
+		acc.WithWLock(func() {
+			err := acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+				mb, err := acc.MailboxFind(tx, "Inbox")
+				tcheck(t, err, "get mailbox")
+				m = Message{
+					MailboxID:     mb.ID,
+					MailboxOrigID: mb.ID,
+					MsgPrefix:     prefix,
+					Size:          int64(len(prefix) + len(msg)),
+				}
+				err = acc.DeliverMessage(log, tx, &m, msgFile, false, true, false, true)
+				tcheck(t, err, "deliver message")
+				err = tx.Get(mb)
+				tcheck(t, err, "get mailbox")
+				mb.Add(m.MailboxCounts())
+				return tx.Update(mb)
+			})
+			tcheck(t, err, "deliver")
+		})
+		return m
+	}
+
+	// Check the message can be read through a MsgReader, and whether the file is compressed.
+	check := func(m Message, expCompressed bool) {
+		t.Helper()
+		err := acc.DB.Get(ctxbg, &m)
+		tcheck(t, err, "get message")
+		if m.FileCompressed != expCompressed {
+			t.Fatalf("message file compressed %v, expected %v", m.FileCompressed, expCompressed)
+		}
+		buf, err := io.ReadAll(acc.MessageReader(m))
+		tcheck(t, err, "read message")
+		if string(buf) != string(prefix)+msg {
+			t.Fatalf("message read back differs")
+		}
+
+		p := acc.MessagePath(m.ID)
+		f, err := os.Open(p)
+		tcheck(t, err, "open message file")
+		defer f.Close()
+		mr := fileMsgReader(m.MsgPrefix, f, m.FileCompressed, m.FileEncrypted)
+		if mr.Size() != m.Size {
+			t.Fatalf("file msg reader size %d, expected %d", mr.Size(), m.Size)
+		}
+		fi, err := f.Stat()
+		tcheck(t, err, "stat message file")
+		if compressed := fi.Size() < int64(len(msg)); compressed != expCompressed {
+			t.Fatalf("message file is compressed %v, expected %v", compressed, expCompressed)
+		}
+		size, err := MessageFileSize(p, m.FileCompressed, m.FileEncrypted)
+		tcheck(t, err, "message file size")
+		if size != int64(len(msg)) {
+			t.Fatalf("message file size %d, expected %d", size, len(msg))
+		}
+	}
+
+	m0 := deliver()
+	check(m0, false)
+
+	accConf.CompressMessages = true
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	m1 := deliver()
+	check(m1, true)
+
+	// Only the uncompressed message is compressed.
+	n, size, compressedSize, err := acc.CompressMessages(ctxbg, log, 1)
+	tcheck(t, err, "compress messages")
+	if n != 1 || size != int64(len(msg)) || compressedSize >= size {
+		t.Fatalf("compressed %d messages, from %d to %d bytes, expected 1 message from %d bytes", n, size, compressedSize, len(msg))
+	}
+	check(m0, true)
+	check(m1, true)
+
+	err = acc.CheckConsistency()
+	tcheck(t, err, "check consistency")
+
+	// An incoming message that looks like a compressed file is stored as is.
+	var xbuf bytes.Buffer
+	err = writeCompressed(&xbuf, strings.NewReader(msg), int64(len(msg)))
+	tcheck(t, err, "write compressed")
+	msg = xbuf.String()
+	prefix = nil
+	accConf.CompressMessages = false
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	m2 := deliver()
+	check(m2, false)
+
+	// A compressed file with a different size than the message in the database
+	// results in a read error.
+	err = acc.DB.Get(ctxbg, &m1)
+	tcheck(t, err, "get message")
+	m1.Size++
+	if _, err := io.ReadAll(acc.MessageReader(m1)); err == nil {
+		t.Fatalf("reading message with size mismatch, expected error")
+	}
+}
@@ -0,0 +1,302 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+)
+
+var ErrUnknownDeletedMessage = errors.New("no such deleted message")
+
+// DeletedMessage is a message that was expunged, e.g. by emptying the Trash
+// mailbox, but whose message file is kept for the period configured in the
+// account's KeepDeletedPeriod, so it can be recovered.
+type DeletedMessage struct {
+	ID int64 // Same as Message.ID of the expunged message, its message file is kept.
+
+	Expunged time.Time `bstore:"default now,index"`
+
+	// Mailbox the message was expunged from, and is recovered to. If the mailbox no
+	// longer exists, the message is recovered to Inbox.
+	MailboxID   int64
+	MailboxName string
+
+	// Fields from the expunged message, to recover it.
+	Received  time.Time
+	Size      int64
+	MsgPrefix []byte
+	Flags
+	Keywords       []string
+	FileCompressed bool
+	FileEncrypted  bool
+
+	// From the parsed message, to show to users.
+	Subject string
+	From    string
+}
+
+// ExpungedFilesRemove removes the message files of messages that have been
+// expunged, or if the account is configured to keep deleted messages, keeps the
+// files and stores the messages as DeletedMessage so they can be recovered.
+//
+// Must be called after the transaction that marked the messages as expunged has
+// been committed. Errors are logged.
+func (a *Account) ExpungedFilesRemove(log mlog.Log, l []Message) {
+	if len(l) == 0 {
+		return
+	}
+
+	conf, _ := a.Conf()
+	if conf.KeepDeletedPeriod > 0 {
+		err := a.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
+			mailboxNames := map[int64]string{}
+			now := time.Now()
+			for _, m := range l {
+				name, ok := mailboxNames[m.MailboxID]
+				if !ok {
+					mb := Mailbox{ID: m.MailboxID}
+					if err := tx.Get(&mb); err == nil {
+						name = mb.Name
+					} else if err != bstore.ErrAbsent {
+						return fmt.Errorf("get mailbox: %v", err)
+					}
+					mailboxNames[m.MailboxID] = name
+				}
+
+				dm := DeletedMessage{
+					ID:          m.ID,
+					Expunged:    now,
+					MailboxID:   m.MailboxID,
+					MailboxName: name,
+					Received:    m.Received,
+					Size:        m.Size,
+					MsgPrefix:   m.MsgPrefix,
+					Flags:       m.Flags,
+					Keywords:    m.Keywords,
+
+					FileCompressed: m.FileCompressed,
+					FileEncrypted:  m.FileEncrypted,
+				}
+				dm.Deleted = false
+				var p message.Part
+				if err := json.Unmarshal(m.ParsedBuf, &p); err != nil {
+					log.Debugx("parsing message for deleted message, continuing", err, slog.Int64("msgid", m.ID))
+				} else if p.Envelope != nil {
+					dm.Subject = p.Envelope.Subject
+					if len(p.Envelope.From) > 0 {
+						f := p.Envelope.From[0]
+						dm.From = f.User + "@" + f.Host
+						if f.Name != "" {
+							dm.From = f.Name + " <" + dm.From + ">"
+						}
+					}
+				}
+				if err := tx.Insert(&dm); err != nil {
+					return fmt.Errorf("inserting deleted message: %v", err)
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			return
+		}
+		log.Errorx("keeping expunged messages as deleted messages, removing message files", err)
+	}
+
+	for _, m := range l {
+		p := a.MessagePath(m.ID)
+		err := os.Remove(p)
+		log.Check(err, "removing message file for expunged message", slog.String("path", p))
+	}
+}
+
+// DeletedList returns the deleted messages that can be recovered, most recently
+// expunged first.
+func (a *Account) DeletedList(ctx context.Context) ([]DeletedMessage, error) {
+	q := bstore.QueryDB[DeletedMessage](ctx, a.DB)
+	q.SortDesc("Expunged")
+	q.SortDesc("ID")
+	return q.List()
+}
+
+// DeletedRecover adds the deleted messages with ids as new messages to the
+// mailboxes they were expunged from, or Inbox if those mailboxes no longer exist.
+// Returns ErrUnknownDeletedMessage for unknown ids, and ErrOverQuota if the
+// account would be over quota.
+//
+// Must be called without the account lock held. Changes are broadcast.
+func (a *Account) DeletedRecover(ctx context.Context, log mlog.Log, ids []int64) (recovered []Message, rerr error) {
+	a.WithWLock(func() {
+		var changes []Change
+
+		rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+			var l []DeletedMessage
+			var totalSize int64
+			seen := map[int64]bool{}
+			for _, id := range ids {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				dm := DeletedMessage{ID: id}
+				if err := tx.Get(&dm); err == bstore.ErrAbsent {
+					return fmt.Errorf("%w: id %d", ErrUnknownDeletedMessage, id)
+				} else if err != nil {
+					return fmt.Errorf("get deleted message: %v", err)
+				}
+				l = append(l, dm)
+				totalSize += dm.Size
+			}
+
+			if ok, maxSize, err := a.CanAddMessageSize(tx, totalSize); err != nil {
+				return err
+			} else if !ok {
+				return fmt.Errorf("%w: max size %d bytes", ErrOverQuota, maxSize)
+			}
+			if ok, maxCount, err := a.CanAddMessages(tx, int64(len(l))); err != nil {
+				return err
+			} else if !ok {
+				return fmt.Errorf("%w: max %d messages", ErrOverQuota, maxCount)
+			}
+
+			mailboxes := map[int64]Mailbox{} // For the count changes.
+			for _, dm := range l {
+				mbID := dm.MailboxID
+				if exists, err := bstore.QueryTx[Mailbox](tx).FilterID(mbID).Exists(); err != nil {
+					return fmt.Errorf("checking if mailbox exists: %v", err)
+				} else if !exists {
+					inbox, err := a.MailboxFind(tx, "Inbox")
+					if err != nil {
+						return fmt.Errorf("looking up inbox: %v", err)
+					} else if inbox == nil {
+						return fmt.Errorf("%w: inbox", ErrUnknownMailbox)
+					}
+					mbID = inbox.ID
+				}
+
+				m := Message{
+					MailboxID:     mbID,
+					MailboxOrigID: mbID,
+					Received:      dm.Received,
+					Size:          dm.Size,
+					MsgPrefix:     dm.MsgPrefix,
+					Flags:         dm.Flags,
+					Keywords:      dm.Keywords,
+
+					FileCompressed: dm.FileCompressed,
+					FileEncrypted:  dm.FileEncrypted,
+				}
+				if err := a.deliverDeleted(log, tx, &m, a.MessagePath(dm.ID)); err != nil {
+					return err
+				}
+				recovered = append(recovered, m)
+
+				// DeliverMessage has updated the mailbox.
+				mb := Mailbox{ID: mbID}
+				if err := tx.Get(&mb); err != nil {
+					return fmt.Errorf("get mailbox: %v", err)
+				}
+				mb.Add(m.MailboxCounts())
+				var kwChanged bool
+				mb.Keywords, kwChanged = MergeKeywords(mb.Keywords, m.Keywords)
+				if err := tx.Update(&mb); err != nil {
+					return fmt.Errorf("updating mailbox: %v", err)
+				}
+				mailboxes[mb.ID] = mb
+
+				changes = append(changes, m.ChangeAddUID())
+				if kwChanged {
+					changes = append(changes, mb.ChangeKeywords())
+				}
+
+				if err := tx.Delete(&dm); err != nil {
+					return fmt.Errorf("removing deleted message: %v", err)
+				}
+			}
+			for _, mb := range mailboxes {
+				changes = append(changes, mb.ChangeCounts())
+			}
+			return nil
+		})
+		if rerr != nil {
+			for _, m := range recovered {
+				p := a.MessagePath(m.ID)
+				err := os.Remove(p)
+				log.Check(err, "removing message file for recovered message after error", slog.String("path", p))
+			}
+			recovered = nil
+			return
+		}
+
+		BroadcastChanges(a, changes)
+	})
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	for _, id := range ids {
+		p := a.MessagePath(id)
+		err := os.Remove(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // Duplicate id.
+		}
+		log.Check(err, "removing message file of recovered deleted message", slog.String("path", p))
+	}
+	return recovered, nil
+}
+
+// deliverDeleted delivers m with the message file at path.
+func (a *Account) deliverDeleted(log mlog.Log, tx *bstore.Tx, m *Message, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open message file of deleted message: %v", err)
+	}
+	defer func() {
+		err := f.Close()
+		log.Check(err, "closing message file of deleted message")
+	}()
+	if err := a.DeliverMessage(log, tx, m, f, false, false, false, true); err != nil {
+		return fmt.Errorf("delivering deleted message: %w", err)
+	}
+	return nil
+}
+
+// DeletedRemoveExpired removes the deleted messages, and their message files, that
+// were expunged longer ago than the account's KeepDeletedPeriod. All deleted
+// messages are removed when KeepDeletedPeriod is zero. The number of removed
+// messages is returned.
+func (a *Account) DeletedRemoveExpired(ctx context.Context, log mlog.Log, now time.Time) (int, error) {
+	conf, _ := a.Conf()
+	var l []DeletedMessage
+	var err error
+	a.WithWLock(func() {
+		err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+			q := bstore.QueryTx[DeletedMessage](tx)
+			q.FilterLess("Expunged", now.Add(-conf.KeepDeletedPeriod))
+			q.Gather(&l)
+			_, err := q.Delete()
+			return err
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("removing expired deleted messages: %w", err)
+	}
+
+	for _, dm := range l {
+		p := a.MessagePath(dm.ID)
+		err := os.Remove(p)
+		log.Check(err, "removing message file of expired deleted message", slog.String("path", p))
+	}
+	return len(l), nil
+}
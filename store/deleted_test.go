@@ -0,0 +1,175 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestDeleted(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+	}()
+	defer Switchboard()()
+
+	accConf := mox.Conf.Dynamic.Accounts["mjl"]
+	accConf.KeepDeletedPeriod = 30 * 24 * time.Hour
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	defer func() {
+		accConf.KeepDeletedPeriod = 0
+		mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	}()
+
+	// Deliver a message and expunge it, keeping it as deleted message.
+	deliverExpunge := func(mailbox string) Message {
+		t.Helper()
+		msg := "From: <mjl@mox.example>\r\nSubject: deleted\r\n\r\ntest\r\n"
+		msgFile, err := CreateMessageTemp(log, "deleted-test")
+		tcheck(t, err, "create temp file")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		var m Message
+		var remove []Message
+		acc.WithWLock(func() {
+			err := acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+				mb, _, err := acc.MailboxEnsure(tx, mailbox, false)
+				tcheck(t, err, "ensure mailbox")
+				m = Message{
+					MailboxID:     mb.ID,
+					MailboxOrigID: mb.ID,
+					Size:          int64(len(msg)),
+					Flags:         Flags{Seen: true},
+					Keywords:      []string{"$label1"},
+				}
+				err = acc.DeliverMessage(log, tx, &m, msgFile, false, true, false, true)
+				tcheck(t, err, "deliver message")
+				err = tx.Get(&mb)
+				tcheck(t, err, "get mailbox")
+				mb.Add(m.MailboxCounts())
+				err = tx.Update(&mb)
+				tcheck(t, err, "update mailbox")
+
+				if mailbox == "Inbox" {
+					remove = []Message{m}
+					_, err = acc.expungeMessages(ctxbg, log, tx, &mb, remove)
+					tcheck(t, err, "expunge message")
+				} else {
+					_, remove, _, err = acc.MailboxDelete(ctxbg, log, tx, mb)
+					tcheck(t, err, "delete mailbox")
+				}
+				return nil
+			})
+			tcheck(t, err, "deliver and expunge")
+		})
+		acc.ExpungedFilesRemove(log, remove)
+		return m
+	}
+
+	checkDeleted := func(exp ...int64) []DeletedMessage {
+		t.Helper()
+		l, err := acc.DeletedList(ctxbg)
+		tcheck(t, err, "list deleted messages")
+		if len(l) != len(exp) {
+			t.Fatalf("got %d deleted messages, expected %d", len(l), len(exp))
+		}
+		for i, dm := range l {
+			if dm.ID != exp[i] {
+				t.Fatalf("deleted message %d has id %d, expected %d", i, dm.ID, exp[i])
+			}
+			if _, err := os.Stat(acc.MessagePath(dm.ID)); err != nil {
+				t.Fatalf("message file of deleted message: %v", err)
+			}
+		}
+		return l
+	}
+
+	inboxTotal := func() int64 {
+		t.Helper()
+		var mb *Mailbox
+		err := acc.DB.Read(ctxbg, func(tx *bstore.Tx) (err error) {
+			mb, err = acc.MailboxFind(tx, "Inbox")
+			return err
+		})
+		tcheck(t, err, "get inbox")
+		return mb.Total
+	}
+
+	m0 := deliverExpunge("Inbox")
+	l := checkDeleted(m0.ID)
+	if l[0].MailboxName != "Inbox" || l[0].Subject != "deleted" || l[0].From != "mjl@mox.example" || !l[0].Seen {
+		t.Fatalf("unexpected deleted message %#v", l[0])
+	}
+	m1 := deliverExpunge("Lost") // Mailbox is removed.
+	l = checkDeleted(m1.ID, m0.ID)
+	if l[0].MailboxName != "" {
+		t.Fatalf("deleted message has mailbox name %q for removed mailbox", l[0].MailboxName)
+	}
+
+	_, err = acc.DeletedRecover(ctxbg, log, []int64{m1.ID + 1000})
+	if !errors.Is(err, ErrUnknownDeletedMessage) {
+		t.Fatalf("recovering unknown deleted message, got err %v, expected ErrUnknownDeletedMessage", err)
+	}
+
+	// Both are recovered to the Inbox.
+	recovered, err := acc.DeletedRecover(ctxbg, log, []int64{m0.ID, m1.ID})
+	tcheck(t, err, "recover deleted messages")
+	if len(recovered) != 2 || inboxTotal() != 2 {
+		t.Fatalf("got %d recovered messages, %d in inbox, expected 2", len(recovered), inboxTotal())
+	}
+	for _, m := range recovered {
+		if !m.Seen || len(m.Keywords) != 1 {
+			t.Fatalf("recovered message misses flags or keywords")
+		}
+		if _, err := os.Stat(acc.MessagePath(m.ID)); err != nil {
+			t.Fatalf("message file of recovered message: %v", err)
+		}
+	}
+	if _, err := os.Stat(acc.MessagePath(m0.ID)); err == nil {
+		t.Fatalf("message file of deleted message still present after recovering")
+	}
+	checkDeleted()
+
+	// Expired deleted messages are removed.
+	m2 := deliverExpunge("Inbox")
+	checkDeleted(m2.ID)
+	n, err := acc.DeletedRemoveExpired(ctxbg, log, time.Now())
+	tcheck(t, err, "remove expired deleted messages")
+	if n != 0 {
+		t.Fatalf("removed %d deleted messages, expected none", n)
+	}
+	n, err = acc.DeletedRemoveExpired(ctxbg, log, time.Now().Add(31*24*time.Hour))
+	tcheck(t, err, "remove expired deleted messages")
+	if n != 1 {
+		t.Fatalf("removed %d deleted messages, expected 1", n)
+	}
+	checkDeleted()
+	if _, err := os.Stat(acc.MessagePath(m2.ID)); err == nil {
+		t.Fatalf("message file of expired deleted message still present")
+	}
+
+	// Without KeepDeletedPeriod, message files are removed immediately.
+	accConf.KeepDeletedPeriod = 0
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	m3 := deliverExpunge("Inbox")
+	checkDeleted()
+	if _, err := os.Stat(acc.MessagePath(m3.ID)); err == nil {
+		t.Fatalf("message file of expunged message still present")
+	}
+}
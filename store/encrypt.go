@@ -0,0 +1,528 @@
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// Message files can be stored encrypted, see EncryptMessages in the account
+// config. An account has an X25519 key pair. The private key is stored in the
+// account database, sealed with a key derived from the account password, so it
+// can only be used after a login with the password. Messages are delivered while
+// the user is logged out by encrypting with the public key.
+//
+// Each file is encrypted with AES-256-GCM, with a key derived from an X25519 key
+// exchange between a new ephemeral key pair and the public key of the account. The
+// data is sealed in chunks, so reading at an offset only requires opening the
+// chunks holding the data. The nonce holds the chunk index and a flag for the last
+// chunk, so reordering and truncation are detected. The file header is
+// authenticated as additional data. Encryption is applied after compression.
+// Whether a file is encrypted is recorded in Message.FileEncrypted, files are
+// never recognized as encrypted by their contents.
+//
+// Layout:
+//
+//	magic "\x00moxe01\n" (8 bytes)
+//	account public key (32 bytes)
+//	ephemeral public key (32 bytes)
+//	size of message data after decryption and decompression (8 bytes, big endian)
+//	sealed chunks, each of encryptChunkSize bytes except the last, plus 16 bytes tag
+const (
+	encryptMagic      = "\x00moxe01\n"
+	encryptHeaderSize = len(encryptMagic) + 32 + 32 + 8
+	encryptChunkSize  = 64 * 1024
+	encryptTagSize    = 16
+
+	// Iterations for deriving the key that seals the private key from the password.
+	encryptKeyIterations = 100000
+)
+
+// ErrEncryptionLocked is returned when reading an encrypted message file while
+// the private key of the account is not available, i.e. not unlocked by a login
+// with password, or when setting a new password while the key is locked.
+var ErrEncryptionLocked = errors.New("account encryption key is locked, log in with password to unlock")
+
+// EncryptionKey is the key pair for encrypting message files of the account.
+// There is at most one, with ID 1.
+type EncryptionKey struct {
+	ID int64
+
+	PublicKey []byte // X25519.
+
+	// Salt and iterations for deriving the key to seal the private key with from the
+	// password, with PBKDF2-SHA256.
+	Salt       []byte
+	Iterations int
+
+	// Nonce followed by the private key, sealed with AES-256-GCM.
+	SealedPrivateKey []byte
+}
+
+// Private keys of accounts that were unlocked by a login with password. Kept in
+// memory until not used for the lifetime of a session.
+var unlockedKeys = struct {
+	sync.Mutex
+	m map[string]*unlockedKey // Key is public key.
+}{m: map[string]*unlockedKey{}}
+
+type unlockedKey struct {
+	key     *ecdh.PrivateKey
+	lastUse time.Time
+}
+
+// unlockedKeyLookup returns the unlocked private key for public key pub, or nil if
+// it isn't unlocked.
+func unlockedKeyLookup(pub []byte) *ecdh.PrivateKey {
+	unlockedKeys.Lock()
+	defer unlockedKeys.Unlock()
+	uk := unlockedKeys.m[string(pub)]
+	if uk == nil {
+		return nil
+	} else if time.Since(uk.lastUse) > sessionLifetime {
+		delete(unlockedKeys.m, string(pub))
+		return nil
+	}
+	uk.lastUse = time.Now()
+	return uk.key
+}
+
+func unlockedKeyAdd(key *ecdh.PrivateKey) {
+	unlockedKeys.Lock()
+	defer unlockedKeys.Unlock()
+	unlockedKeys.m[string(key.PublicKey().Bytes())] = &unlockedKey{key, time.Now()}
+}
+
+// passwordAEAD returns the cipher for sealing the private key with a key derived
+// from the password.
+func passwordAEAD(password string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key := pbkdf2.Key([]byte(password), salt, iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealEncryptionKey returns an EncryptionKey with the private key sealed with the
+// password.
+func sealEncryptionKey(key *ecdh.PrivateKey, password string) (EncryptionKey, error) {
+	ek := EncryptionKey{
+		ID:         1,
+		PublicKey:  key.PublicKey().Bytes(),
+		Salt:       make([]byte, 16),
+		Iterations: encryptKeyIterations,
+	}
+	if _, err := cryptorand.Read(ek.Salt); err != nil {
+		return EncryptionKey{}, fmt.Errorf("generating salt: %v", err)
+	}
+	aead, err := passwordAEAD(password, ek.Salt, ek.Iterations)
+	if err != nil {
+		return EncryptionKey{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := cryptorand.Read(nonce); err != nil {
+		return EncryptionKey{}, fmt.Errorf("generating nonce: %v", err)
+	}
+	ek.SealedPrivateKey = aead.Seal(nonce, nonce, key.Bytes(), ek.PublicKey)
+	return ek, nil
+}
+
+// open returns the private key, unsealed with the password.
+func (ek EncryptionKey) open(password string) (*ecdh.PrivateKey, error) {
+	aead, err := passwordAEAD(password, ek.Salt, ek.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(ek.SealedPrivateKey) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed private key too short")
+	}
+	nonce := ek.SealedPrivateKey[:aead.NonceSize()]
+	buf, err := aead.Open(nil, nonce, ek.SealedPrivateKey[aead.NonceSize():], ek.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("opening sealed private key: %v", err)
+	}
+	return ecdh.X25519().NewPrivateKey(buf)
+}
+
+// StoreMessageText returns whether text from messages is stored in the account
+// database: in the word index, as attachment text, and as preview. Accounts that
+// encrypt messages don't store text, because the database is not encrypted.
+// Searches in those accounts read the (decrypted) message files.
+func (a *Account) StoreMessageText() bool {
+	conf, _ := a.Conf()
+	return !conf.EncryptMessages
+}
+
+// encryptionKeyEnsure returns the encryption key of the account. If there is none,
+// and the account is configured to encrypt messages, a new key is generated,
+// sealed with password and unlocked. Otherwise nil is returned.
+func (a *Account) encryptionKeyEnsure(tx *bstore.Tx, password string) (*EncryptionKey, error) {
+	ek := EncryptionKey{ID: 1}
+	if err := tx.Get(&ek); err == nil {
+		return &ek, nil
+	} else if err != bstore.ErrAbsent {
+		return nil, fmt.Errorf("get encryption key: %v", err)
+	}
+	if conf, _ := a.Conf(); !conf.EncryptMessages {
+		return nil, nil
+	}
+
+	key, err := ecdh.X25519().GenerateKey(cryptorand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating encryption key: %v", err)
+	}
+	ek, err = sealEncryptionKey(key, password)
+	if err != nil {
+		return nil, fmt.Errorf("sealing encryption key: %v", err)
+	}
+	if err := tx.Insert(&ek); err != nil {
+		return nil, fmt.Errorf("inserting encryption key: %v", err)
+	}
+	unlockedKeyAdd(key)
+	return &ek, nil
+}
+
+// encryptionUnlock makes the private key of the account available for reading
+// encrypted messages, for an authenticated login with password. A key is
+// generated if the account should have one but doesn't yet.
+func (a *Account) encryptionUnlock(log mlog.Log, password string) error {
+	var ek EncryptionKey
+	err := a.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+		ek = EncryptionKey{ID: 1}
+		return tx.Get(&ek)
+	})
+	if err == bstore.ErrAbsent {
+		if conf, _ := a.Conf(); !conf.EncryptMessages {
+			return nil
+		}
+		return a.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
+			_, err := a.encryptionKeyEnsure(tx, password)
+			if err == nil {
+				log.Info("new encryption key for account", slog.String("account", a.Name))
+			}
+			return err
+		})
+	} else if err != nil {
+		return fmt.Errorf("get encryption key: %v", err)
+	}
+
+	if unlockedKeyLookup(ek.PublicKey) != nil {
+		return nil
+	}
+	key, err := ek.open(password)
+	if err != nil {
+		return err
+	}
+	unlockedKeyAdd(key)
+	return nil
+}
+
+// encryptionUnlockAppPassword makes the private key of the account available
+// after a login with an app password, if the key was sealed with the app password.
+func (a *Account) encryptionUnlockAppPassword(ap AppPassword, password string) error {
+	if len(ap.EncryptionKey.PublicKey) == 0 || unlockedKeyLookup(ap.EncryptionKey.PublicKey) != nil {
+		return nil
+	}
+	key, err := ap.EncryptionKey.open(password)
+	if err != nil {
+		return err
+	}
+	unlockedKeyAdd(key)
+	return nil
+}
+
+// encryptionKeyReseal seals the unlocked private key of the account with a new
+// password. Returns ErrEncryptionLocked if the key isn't unlocked. If the account
+// has no key yet but should, one is generated.
+func (a *Account) encryptionKeyReseal(tx *bstore.Tx, password string) error {
+	ek := EncryptionKey{ID: 1}
+	if err := tx.Get(&ek); err == bstore.ErrAbsent {
+		_, err := a.encryptionKeyEnsure(tx, password)
+		return err
+	} else if err != nil {
+		return fmt.Errorf("get encryption key: %v", err)
+	}
+	key := unlockedKeyLookup(ek.PublicKey)
+	if key == nil {
+		return ErrEncryptionLocked
+	}
+	nek, err := sealEncryptionKey(key, password)
+	if err != nil {
+		return fmt.Errorf("sealing encryption key: %v", err)
+	}
+	if err := tx.Update(&nek); err != nil {
+		return fmt.Errorf("updating encryption key: %v", err)
+	}
+	return nil
+}
+
+// encryptAEAD returns the cipher for a message file, from the X25519 shared
+// secret and both public keys.
+func encryptAEAD(shared, ephemeralPub, accountPub []byte) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write(shared)
+	h.Write(ephemeralPub)
+	h.Write(accountPub)
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptNonce returns the nonce for chunk i.
+func encryptNonce(i int64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(i))
+	if last {
+		nonce[8] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts data written to it. Close must be called to write the
+// last chunk, it does not close the underlying writer.
+type encryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	hdr   []byte
+	buf   []byte
+	chunk int64
+}
+
+// newEncryptWriter writes the header for a message file encrypted to the account
+// public key, with dataSize the size of the message data when read back.
+func newEncryptWriter(w io.Writer, accountPub []byte, dataSize int64) (*encryptWriter, error) {
+	pub, err := ecdh.X25519().NewPublicKey(accountPub)
+	if err != nil {
+		return nil, fmt.Errorf("parsing account public key: %v", err)
+	}
+	eph, err := ecdh.X25519().GenerateKey(cryptorand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating ephemeral key: %v", err)
+	}
+	shared, err := eph.ECDH(pub)
+	if err != nil {
+		return nil, fmt.Errorf("key exchange: %v", err)
+	}
+	aead, err := encryptAEAD(shared, eph.PublicKey().Bytes(), accountPub)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := make([]byte, 0, encryptHeaderSize)
+	hdr = append(hdr, encryptMagic...)
+	hdr = append(hdr, accountPub...)
+	hdr = append(hdr, eph.PublicKey().Bytes()...)
+	hdr = binary.BigEndian.AppendUint64(hdr, uint64(dataSize))
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, hdr: hdr}, nil
+}
+
+func (w *encryptWriter) Write(buf []byte) (int, error) {
+	w.buf = append(w.buf, buf...)
+	// Keep at least some data for the last chunk.
+	for len(w.buf) > encryptChunkSize {
+		if err := w.seal(w.buf[:encryptChunkSize], false); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[encryptChunkSize:]...)
+	}
+	return len(buf), nil
+}
+
+func (w *encryptWriter) seal(buf []byte, last bool) error {
+	sealed := w.aead.Seal(nil, encryptNonce(w.chunk, last), buf, w.hdr)
+	w.chunk++
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+// Close writes the last chunk.
+func (w *encryptWriter) Close() error {
+	err := w.seal(w.buf, true)
+	w.buf = nil
+	return err
+}
+
+// readEncryptedHeader reads the header of encrypted file f of fileSize bytes.
+func readEncryptedHeader(f io.ReaderAt, fileSize int64) ([]byte, error) {
+	if fileSize < int64(encryptHeaderSize+encryptTagSize) {
+		return nil, fmt.Errorf("encrypted message file too small")
+	}
+	hdr := make([]byte, encryptHeaderSize)
+	if _, err := f.ReadAt(hdr, 0); err != nil {
+		return nil, fmt.Errorf("reading message file header: %w", err)
+	}
+	if string(hdr[:len(encryptMagic)]) != encryptMagic {
+		return nil, fmt.Errorf("message file is not encrypted")
+	}
+	return hdr, nil
+}
+
+// encryptedFileSize returns the size of the message data of encrypted file f after
+// decrypting and decompressing it. The private key is not needed.
+func encryptedFileSize(f io.ReaderAt, fileSize int64) (int64, error) {
+	hdr, err := readEncryptedHeader(f, fileSize)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(hdr[encryptHeaderSize-8:])), nil
+}
+
+// encryptedReader reads from an encrypted message file.
+type encryptedReader struct {
+	f       io.ReaderAt
+	hdr     []byte
+	aead    cipher.AEAD
+	size    int64 // Size of decrypted data.
+	nchunks int64
+
+	sync.Mutex
+	chunk int64  // Chunk currently in buf, -1 if none.
+	buf   []byte // Decrypted data of chunk.
+}
+
+// openEncrypted returns a reader for the encrypted file f of fileSize bytes. If
+// the private key is not unlocked, ErrEncryptionLocked is returned.
+func openEncrypted(f io.ReaderAt, fileSize int64) (*encryptedReader, error) {
+	hdr, err := readEncryptedHeader(f, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	accountPub := hdr[len(encryptMagic) : len(encryptMagic)+32]
+	ephemeralPub := hdr[len(encryptMagic)+32 : len(encryptMagic)+64]
+
+	key := unlockedKeyLookup(accountPub)
+	if key == nil {
+		return nil, ErrEncryptionLocked
+	}
+	eph, err := ecdh.X25519().NewPublicKey(ephemeralPub)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ephemeral public key: %v", err)
+	}
+	shared, err := key.ECDH(eph)
+	if err != nil {
+		return nil, fmt.Errorf("key exchange: %v", err)
+	}
+	aead, err := encryptAEAD(shared, ephemeralPub, accountPub)
+	if err != nil {
+		return nil, err
+	}
+
+	n := fileSize - int64(encryptHeaderSize)
+	sealedChunkSize := int64(encryptChunkSize + encryptTagSize)
+	nchunks := (n + sealedChunkSize - 1) / sealedChunkSize
+	size := n - nchunks*encryptTagSize
+	if size < 0 {
+		return nil, fmt.Errorf("invalid size of encrypted message file")
+	}
+	return &encryptedReader{f: f, hdr: hdr, aead: aead, size: size, nchunks: nchunks, chunk: -1}, nil
+}
+
+// ReadAt reads decrypted data at offset off.
+func (r *encryptedReader) ReadAt(buf []byte, off int64) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	var n int
+	for n < len(buf) {
+		if off >= r.size {
+			// Verify the last chunk for truncation, also for empty files.
+			if err := r.load(r.nchunks - 1); err != nil {
+				return n, err
+			}
+			return n, io.EOF
+		}
+		c := off / encryptChunkSize
+		if err := r.load(c); err != nil {
+			return n, err
+		}
+		k := copy(buf[n:], r.buf[off-c*encryptChunkSize:])
+		n += k
+		off += int64(k)
+	}
+	return n, nil
+}
+
+// load decrypts chunk c into buf.
+func (r *encryptedReader) load(c int64) error {
+	if r.chunk == c {
+		return nil
+	}
+	r.chunk = -1
+
+	size := int64(encryptChunkSize)
+	if rem := r.size - c*encryptChunkSize; rem < size {
+		size = rem
+	}
+	sealed := make([]byte, size+encryptTagSize)
+	if _, err := r.f.ReadAt(sealed, int64(encryptHeaderSize)+c*(encryptChunkSize+encryptTagSize)); err != nil {
+		return fmt.Errorf("reading chunk %d of encrypted message file: %w", c, err)
+	}
+	buf, err := r.aead.Open(r.buf[:0], encryptNonce(c, c == r.nchunks-1), sealed, r.hdr)
+	if err != nil {
+		return fmt.Errorf("decrypting chunk %d of message file: %w", c, err)
+	}
+	r.buf = buf
+	r.chunk = c
+	return nil
+}
+
+// EncryptMessages encrypts the message files of all messages in the account that
+// are not yet encrypted, compressing them first if the account is configured to
+// compress messages. The account must have an encryption key, which is created at
+// the first login with password after enabling EncryptMessages. The number of
+// encrypted messages, and their total file sizes before and after, are returned.
+//
+// Text of messages stored in the account database, the word index, attachment
+// text and previews, is removed first.
+func (a *Account) EncryptMessages(ctx context.Context, log mlog.Log, batchSize int) (n int, size, encryptedSize int64, rerr error) {
+	ek := EncryptionKey{ID: 1}
+	if err := a.DB.Get(ctx, &ek); err == bstore.ErrAbsent {
+		return 0, 0, 0, errors.New("account has no encryption key, enable EncryptMessages and log in with password first")
+	} else if err != nil {
+		return 0, 0, 0, fmt.Errorf("get encryption key: %v", err)
+	}
+	var err error
+	a.WithWLock(func() {
+		err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+			if err := wordIndexClear(tx); err != nil {
+				return err
+			}
+			q := bstore.QueryTx[Message](tx)
+			q.FilterFn(func(m Message) bool { return m.Preview != nil })
+			if _, err := q.UpdateField("Preview", (*string)(nil)); err != nil {
+				return fmt.Errorf("removing previews: %w", err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("removing message text from database: %w", err)
+	}
+	conf, _ := a.Conf()
+	return a.recodeMessages(ctx, log, batchSize, conf.CompressMessages, ek.PublicKey)
+}
@@ -0,0 +1,346 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestEncrypt(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+	}()
+	defer Switchboard()()
+
+	accConf := mox.Conf.Dynamic.Accounts["mjl"]
+	defer func() {
+		accConf.EncryptMessages = false
+		accConf.CompressMessages = false
+		mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	}()
+
+	// Large enough for multiple chunks, and compressible.
+	msg := "Subject: encrypted\r\n\r\n" + string(bytes.Repeat([]byte("test test test\r\n"), 10000))
+
+	deliver := func() Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "encrypt-test")
+		tcheck(t, err, "create temp file")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		var m Message
+		acc.WithWLock(func() {
+			err := acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+				mb, err := acc.MailboxFind(tx, "Inbox")
+				tcheck(t, err, "get mailbox")
+				m = Message{
+					MailboxID:     mb.ID,
+					MailboxOrigID: mb.ID,
+					Size:          int64(len(msg)),
+				}
+				err = acc.DeliverMessage(log, tx, &m, msgFile, false, true, false, true)
+				tcheck(t, err, "deliver message")
+				err = tx.Get(mb)
+				tcheck(t, err, "get mailbox")
+				mb.Add(m.MailboxCounts())
+				return tx.Update(mb)
+			})
+			tcheck(t, err, "deliver")
+		})
+		return m
+	}
+
+	read := func(m Message) error {
+		t.Helper()
+		err := acc.DB.Get(ctxbg, &m)
+		tcheck(t, err, "get message")
+		mr := acc.MessageReader(m)
+		defer mr.Close()
+		buf, err := io.ReadAll(mr)
+		if err == nil && string(buf) != msg {
+			t.Fatalf("message read back differs")
+		}
+		return err
+	}
+
+	checkEncrypted := func(m Message, exp bool) {
+		t.Helper()
+		err := acc.DB.Get(ctxbg, &m)
+		tcheck(t, err, "get message")
+		buf, err := os.ReadFile(acc.MessagePath(m.ID))
+		tcheck(t, err, "read message file")
+		if encrypted := bytes.HasPrefix(buf, []byte(encryptMagic)); encrypted != exp || m.FileEncrypted != exp {
+			t.Fatalf("message file encrypted %v, in database %v, expected %v", encrypted, m.FileEncrypted, exp)
+		}
+		size, err := MessageFileSize(acc.MessagePath(m.ID), m.FileCompressed, m.FileEncrypted)
+		tcheck(t, err, "message file size")
+		if size != int64(len(msg)) {
+			t.Fatalf("message file size %d, expected %d", size, len(msg))
+		}
+	}
+
+	lock := func() {
+		unlockedKeys.Lock()
+		unlockedKeys.m = map[string]*unlockedKey{}
+		unlockedKeys.Unlock()
+	}
+
+	// Without key, messages are not encrypted.
+	m0 := deliver()
+	checkEncrypted(m0, false)
+
+	accConf.EncryptMessages = true
+	accConf.CompressMessages = true
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+
+	// Setting the password creates the key.
+	err = acc.SetPassword(log, "testtest")
+	tcheck(t, err, "set password")
+
+	m1 := deliver()
+	checkEncrypted(m1, true)
+	err = read(m1)
+	tcheck(t, err, "read encrypted message")
+
+	n, _, _, err := acc.EncryptMessages(ctxbg, log, 1)
+	tcheck(t, err, "encrypt messages")
+	if n != 1 {
+		t.Fatalf("encrypted %d messages, expected 1", n)
+	}
+	checkEncrypted(m0, true)
+	err = read(m0)
+	tcheck(t, err, "read encrypted message")
+
+	// When locked, messages can still be delivered, but not read.
+	lock()
+	m2 := deliver()
+	checkEncrypted(m2, true)
+	if err := read(m2); !errors.Is(err, ErrEncryptionLocked) {
+		t.Fatalf("reading message while locked, got err %v, expected ErrEncryptionLocked", err)
+	}
+	err = acc.CheckConsistency()
+	tcheck(t, err, "check consistency")
+
+	// Password cannot be changed while locked.
+	if err := acc.SetPassword(log, "testtest2"); !errors.Is(err, ErrEncryptionLocked) {
+		t.Fatalf("setting password while locked, got err %v, expected ErrEncryptionLocked", err)
+	}
+
+	// Login with password unlocks.
+	acc2, _, err := OpenEmailAuth(log, "mjl@mox.example", "testtest", AuthScopeIMAP)
+	tcheck(t, err, "open with password")
+	err = acc2.Close()
+	tcheck(t, err, "close account")
+	err = read(m2)
+	tcheck(t, err, "read encrypted message after unlock")
+
+	// New password seals the key again.
+	err = acc.SetPassword(log, "testtest2")
+	tcheck(t, err, "set password")
+	lock()
+	acc2, _, err = OpenEmailAuth(log, "mjl@mox.example", "testtest2", AuthScopeIMAP)
+	tcheck(t, err, "open with new password")
+	err = acc2.Close()
+	tcheck(t, err, "close account")
+	err = read(m0)
+	tcheck(t, err, "read encrypted message after unlock with new password")
+
+	// App passwords are only added while the key is unlocked, and a login with an app
+	// password unlocks the key too.
+	_, phonePassword, err := acc.AppPasswordAdd(ctxbg, log, "phone", true, false)
+	tcheck(t, err, "add app password")
+	lock()
+	if _, _, err := acc.AppPasswordAdd(ctxbg, log, "laptop", true, false); !errors.Is(err, ErrEncryptionLocked) {
+		t.Fatalf("adding app password while locked, got err %v, expected ErrEncryptionLocked", err)
+	}
+	acc2, appPassword, err := OpenEmailAuth(log, "mjl@mox.example", phonePassword, AuthScopeIMAP)
+	tcheck(t, err, "open with app password")
+	err = acc2.Close()
+	tcheck(t, err, "close account")
+	if appPassword != "phone" {
+		t.Fatalf("got app password %q, expected phone", appPassword)
+	}
+	err = read(m0)
+	tcheck(t, err, "read encrypted message after unlock with app password")
+
+	// Corrupt data is detected.
+	p := acc.MessagePath(m1.ID)
+	buf, err := os.ReadFile(p)
+	tcheck(t, err, "read message file")
+	buf[len(buf)-1]++
+	err = os.WriteFile(p, buf, 0660)
+	tcheck(t, err, "write message file")
+	if err := read(m1); err == nil {
+		t.Fatalf("reading corrupt message, expected error")
+	}
+
+	// An incoming message that looks like an encrypted file is stored and read back
+	// as a regular message.
+	msg = encryptMagic + "Subject: not encrypted\r\n\r\ntest\r\n"
+	accConf.EncryptMessages = false
+	accConf.CompressMessages = false
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	m3 := deliver()
+	err = acc.DB.Get(ctxbg, &m3)
+	tcheck(t, err, "get message")
+	if m3.FileEncrypted || m3.FileCompressed {
+		t.Fatalf("message with encryption magic stored as encrypted %v, compressed %v, expected plain", m3.FileEncrypted, m3.FileCompressed)
+	}
+	err = read(m3)
+	tcheck(t, err, "read message with encryption magic")
+}
+
+// Accounts with encrypted messages don't store message text in the database.
+func TestEncryptMessageText(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+	}()
+	defer Switchboard()()
+
+	accConf := mox.Conf.Dynamic.Accounts["mjl"]
+	defer func() {
+		accConf.EncryptMessages = false
+		mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	}()
+
+	deliver := func(word string) Message {
+		t.Helper()
+		msg := strings.ReplaceAll(`Subject: report
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=x
+
+--x
+Content-Type: text/plain
+
+body`+word+`
+--x
+Content-Type: application/octet-stream; name="notes.txt"
+Content-Transfer-Encoding: base64
+
+`+base64.StdEncoding.EncodeToString([]byte("attachment"+word))+`
+--x--
+`, "\n", "\r\n")
+
+		msgFile, err := CreateMessageTemp(log, "encrypt-test")
+		tcheck(t, err, "create temp file")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		var m Message
+		acc.WithWLock(func() {
+			err := acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+				mb, err := acc.MailboxFind(tx, "Inbox")
+				tcheck(t, err, "get mailbox")
+				m = Message{
+					MailboxID:     mb.ID,
+					MailboxOrigID: mb.ID,
+					Size:          int64(len(msg)),
+				}
+				err = acc.PrepareAttachmentText(log, &m, msgFile)
+				tcheck(t, err, "prepare attachment text")
+				err = acc.DeliverMessage(log, tx, &m, msgFile, false, true, false, true)
+				tcheck(t, err, "deliver message")
+				if acc.StoreMessageText() {
+					// As cached by the IMAP FETCH PREVIEW command.
+					preview := "body" + word
+					m.Preview = &preview
+					err = tx.Update(&m)
+					tcheck(t, err, "set preview")
+				}
+				err = tx.Get(mb)
+				tcheck(t, err, "get mailbox")
+				mb.Add(m.MailboxCounts())
+				return tx.Update(mb)
+			})
+			tcheck(t, err, "deliver")
+		})
+		return m
+	}
+
+	checkDB := func(word string, exp bool) {
+		t.Helper()
+		buf, err := os.ReadFile(acc.DBPath)
+		tcheck(t, err, "read database file")
+		for _, s := range []string{"body" + word, "attachment" + word} {
+			if found := bytes.Contains(buf, []byte(s)); found != exp {
+				t.Fatalf("database contains %q: %v, expected %v", s, found, exp)
+			}
+		}
+	}
+
+	checkCount := func(exp int) {
+		t.Helper()
+		for _, fn := range []func() (int, error){
+			bstore.QueryDB[WordTerm](ctxbg, acc.DB).Count,
+			bstore.QueryDB[AttachmentText](ctxbg, acc.DB).Count,
+			bstore.QueryDB[Message](ctxbg, acc.DB).FilterFn(func(m Message) bool { return m.Preview != nil }).Count,
+		} {
+			n, err := fn()
+			tcheck(t, err, "count")
+			if n == 0 && exp != 0 || n != 0 && exp == 0 {
+				t.Fatalf("got %d records with message text, expected %d", n, exp)
+			}
+		}
+	}
+
+	// Without encryption, the message text is stored, verifying the checks below
+	// would notice.
+	deliver("plainword")
+	checkDB("plainword", true)
+	checkCount(1)
+
+	accConf.EncryptMessages = true
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	err = acc.SetPassword(log, "testtest")
+	tcheck(t, err, "set password")
+
+	// Encrypting existing messages removes the stored message text.
+	_, _, _, err = acc.EncryptMessages(ctxbg, log, 10)
+	tcheck(t, err, "encrypt messages")
+	checkCount(0)
+
+	// New messages don't get their text stored.
+	deliver("secretword")
+	checkDB("secretword", false)
+	checkCount(0)
+
+	// Word index lookups fall back to reading the message files.
+	err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+		_, ok, err := acc.WordIndexMatch(tx, []string{"bodysecretword"})
+		tcheck(t, err, "match")
+		if ok {
+			t.Fatalf("word index match for account with encrypted messages, expected fallback")
+		}
+		return nil
+	})
+	tcheck(t, err, "read")
+}
@@ -314,16 +314,16 @@ func ExportMessages(ctx context.Context, log mlog.Log, db *bstore.DB, accountDir
 				err := mf.Close()
 				log.Check(err, "closing message file after export")
 			}()
-			st, err := mf.Stat()
-			if err != nil {
-				errors += fmt.Sprintf("stat message file for id %d, path %s: %v (message skipped)\n", m.ID, mp, err)
+			fmr := fileMsgReader(m.MsgPrefix, mf, m.FileCompressed, m.FileEncrypted)
+			if fmr.err != nil {
+				errors += fmt.Sprintf("stat message file for id %d, path %s: %v (message skipped)\n", m.ID, mp, fmr.err)
 				return nil
 			}
-			size := st.Size() + int64(len(m.MsgPrefix))
+			size := fmr.Size()
 			if size != m.Size {
-				errors += fmt.Sprintf("message size mismatch for message id %d, database has %d, size is %d+%d=%d, using calculated size\n", m.ID, m.Size, len(m.MsgPrefix), st.Size(), size)
+				errors += fmt.Sprintf("message size mismatch for message id %d, database has %d, size is %d+%d=%d, using calculated size\n", m.ID, m.Size, len(m.MsgPrefix), size-int64(len(m.MsgPrefix)), size)
 			}
-			mr = FileMsgReader(m.MsgPrefix, mf)
+			mr = fmr
 		}
 
 		if maildir {
@@ -0,0 +1,627 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	"golang.org/x/text/encoding/ianaindex"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// AttachmentType is for filtering by attachment type.
+type AttachmentType string
+
+const (
+	AttachmentIndifferent  AttachmentType = ""
+	AttachmentNone         AttachmentType = "none"
+	AttachmentAny          AttachmentType = "any"
+	AttachmentImage        AttachmentType = "image" // png, jpg, gif, ...
+	AttachmentPDF          AttachmentType = "pdf"
+	AttachmentArchive      AttachmentType = "archive"      // zip files, tgz, ...
+	AttachmentSpreadsheet  AttachmentType = "spreadsheet"  // ods, xlsx, ...
+	AttachmentDocument     AttachmentType = "document"     // odt, docx, ...
+	AttachmentPresentation AttachmentType = "presentation" // odp, pptx, ...
+)
+
+// Filter selects the messages to return. Fields that are set must all match,
+// for slices each element by match ("and").
+type Filter struct {
+	// If -1, then all mailboxes except Trash/Junk/Rejects. Otherwise, only active if > 0.
+	MailboxID int64
+
+	// If true, also submailboxes are included in the search.
+	MailboxChildrenIncluded bool
+
+	// In case client doesn't know mailboxes and their IDs yet. Only used during sse
+	// connection setup, where it is turned into a MailboxID. Filtering only looks at
+	// MailboxID.
+	MailboxName string
+
+	Words       []string // Case insensitive substring match for each string.
+	From        []string
+	To          []string // Including Cc and Bcc.
+	Oldest      *time.Time
+	Newest      *time.Time
+	Subject     []string
+	Attachments AttachmentType
+	Labels      []string
+	Headers     [][2]string // Header values can be empty, it's a check if the header is present, regardless of value.
+	SizeMin     int64
+	SizeMax     int64
+}
+
+// NotFilter matches messages that don't match these fields.
+type NotFilter struct {
+	Words       []string
+	From        []string
+	To          []string
+	Subject     []string
+	Attachments AttachmentType
+	Labels      []string
+}
+
+// PrepareMailboxIDs prepares the first half of filters for mailboxes, based on
+// f.MailboxID (-1 is special). matchMailboxes indicates whether the IDs in
+// mailboxIDs must or must not match. mailboxPrefixes is for use with
+// GatherMailboxIDs to gather children of the mailboxIDs.
+func PrepareMailboxIDs(tx *bstore.Tx, f Filter, rejectsMailbox string) (matchMailboxes bool, mailboxIDs map[int64]bool, mailboxPrefixes []string, rerr error) {
+	matchMailboxes = true
+	mailboxIDs = map[int64]bool{}
+	if f.MailboxID == -1 {
+		matchMailboxes = false
+		// Add the trash, junk and account rejects mailbox.
+		err := bstore.QueryTx[Mailbox](tx).ForEach(func(mb Mailbox) error {
+			if mb.Trash || mb.Junk || mb.Name == rejectsMailbox {
+				mailboxPrefixes = append(mailboxPrefixes, mb.Name+"/")
+				mailboxIDs[mb.ID] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return false, nil, nil, fmt.Errorf("finding trash/junk/rejects mailbox: %w", err)
+		}
+	} else if f.MailboxID > 0 {
+		mb := Mailbox{ID: f.MailboxID}
+		if err := tx.Get(&mb); err != nil {
+			return false, nil, nil, fmt.Errorf("get mailbox: %w", err)
+		}
+		mailboxIDs[f.MailboxID] = true
+		mailboxPrefixes = []string{mb.Name + "/"}
+	}
+	return
+}
+
+// GatherMailboxIDs adds all mailboxes with a prefix matching any of
+// mailboxPrefixes to mailboxIDs, to expand filtering to children of mailboxes.
+func GatherMailboxIDs(tx *bstore.Tx, mailboxIDs map[int64]bool, mailboxPrefixes []string) error {
+	// Gather more mailboxes to filter on, based on mailboxPrefixes.
+	if len(mailboxPrefixes) == 0 {
+		return nil
+	}
+	err := bstore.QueryTx[Mailbox](tx).ForEach(func(mb Mailbox) error {
+		for _, p := range mailboxPrefixes {
+			if strings.HasPrefix(mb.Name, p) {
+				mailboxIDs[mb.ID] = true
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("gathering mailboxes: %w", err)
+	}
+	return nil
+}
+
+// MessageFilter checks messages against a Filter and NotFilter, except for the
+// mailbox filters, see PrepareMailboxIDs.
+//
+// While checking the filters on a message, we may need to get more message
+// details as each filter passes. We check the filters that need the basic
+// information first, and load and cache more details for the next filters. The
+// cached details are reset for a next message. An error while loading details
+// causes the message to not match, the error is available through Err. Close
+// must be called when done.
+type MessageFilter struct {
+	log       mlog.Log
+	acc       *Account
+	tx        *bstore.Tx
+	filter    Filter
+	notFilter NotFilter
+
+	flagFilter  func(Flags, []string) bool // Nil if no filters on flags.
+	partFilters []func(m Message) bool     // Filters needing the parsed message.
+	words       *WordSearch                // Nil if no word filters.
+
+	err  error // Once set, doesn't get cleared.
+	m    Message
+	part *message.Part // Will be without Reader when msgr is nil.
+	msgr *MsgReader
+}
+
+// NewMessageFilter returns a MessageFilter for messages of acc, to be used within
+// transaction tx.
+func NewMessageFilter(log mlog.Log, acc *Account, tx *bstore.Tx, f Filter, nf NotFilter) *MessageFilter {
+	mf := &MessageFilter{log: log, acc: acc, tx: tx, filter: f, notFilter: nf}
+	mf.flagFilter = mf.flagFilterFn()
+	for _, fn := range []func(m Message) bool{mf.attachmentFilterFn(), mf.envFilterFn(), mf.headerFilterFn(), mf.wordsFilterFn()} {
+		if fn != nil {
+			mf.partFilters = append(mf.partFilters, fn)
+		}
+	}
+	return mf
+}
+
+// UseWordIndex looks up the words of the filter in the word index, if available,
+// so MatchMessage can skip reading messages that cannot match. For use when
+// checking many messages.
+func (mf *MessageFilter) UseWordIndex() error {
+	if mf.words == nil {
+		return nil
+	}
+	return mf.words.UseWordIndex(mf.tx, mf.acc)
+}
+
+// Err returns the first error encountered while checking messages.
+func (mf *MessageFilter) Err() error {
+	return mf.err
+}
+
+// Close releases the message reader, if any.
+func (mf *MessageFilter) Close() {
+	mf.clear()
+}
+
+func (mf *MessageFilter) clear() {
+	if mf.msgr != nil {
+		mf.msgr.Close()
+		mf.msgr = nil
+	}
+	mf.m = Message{}
+	mf.part = nil
+}
+
+// MatchFlags returns whether flags and keywords match the label filters.
+func (mf *MessageFilter) MatchFlags(flags Flags, keywords []string) bool {
+	return mf.flagFilter == nil || mf.flagFilter(flags, keywords)
+}
+
+// MessageNeeded returns whether MatchMessage has filters to check, callers can
+// skip fetching the message otherwise.
+func (mf *MessageFilter) MessageNeeded() bool {
+	f := mf.filter
+	return f.Oldest != nil || f.Newest != nil || f.SizeMin > 0 || f.SizeMax > 0 || len(mf.partFilters) > 0
+}
+
+// MatchMessage returns whether m matches the filters on received time, size and
+// message contents. Flags are not checked, see MatchFlags.
+func (mf *MessageFilter) MatchMessage(m Message) bool {
+	f := mf.filter
+	if f.Oldest != nil && m.Received.Before(*f.Oldest) || f.Newest != nil && m.Received.After(*f.Newest) {
+		return false
+	}
+	if f.SizeMin > 0 && m.Size < f.SizeMin || f.SizeMax > 0 && m.Size > f.SizeMax {
+		return false
+	}
+	if mf.words != nil && !mf.words.Candidate(m.ID) {
+		return false
+	}
+	for _, fn := range mf.partFilters {
+		if !fn(m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (mf *MessageFilter) ensurePart(m Message, withMsgReader bool) bool {
+	if m.ID != mf.m.ID {
+		mf.clear()
+	}
+	mf.m = m
+
+	if mf.err == nil {
+		if mf.part == nil {
+			if m.ParsedBuf == nil {
+				mf.err = fmt.Errorf("message %d not parsed", m.ID)
+				return false
+			}
+			var p message.Part
+			if err := json.Unmarshal(m.ParsedBuf, &p); err != nil {
+				mf.err = fmt.Errorf("load part for message %d: %w", m.ID, err)
+				return false
+			}
+			mf.part = &p
+		}
+		if withMsgReader && mf.msgr == nil {
+			mf.msgr = mf.acc.MessageReader(m)
+			mf.part.SetReaderAt(mf.msgr)
+		}
+	}
+	return mf.part != nil
+}
+
+// flagFilterFn returns a function that applies the flag/keyword/"label"-related
+// filters. A nil function is returned if there are no flags to filter on.
+func (mf *MessageFilter) flagFilterFn() func(Flags, []string) bool {
+	labels := map[string]bool{}
+	for _, k := range mf.filter.Labels {
+		labels[k] = true
+	}
+	for _, k := range mf.notFilter.Labels {
+		labels[k] = false
+	}
+
+	if len(labels) == 0 {
+		return nil
+	}
+
+	var mask, flags Flags
+	systemflags := map[string][]*bool{
+		`\answered`:  {&mask.Answered, &flags.Answered},
+		`\flagged`:   {&mask.Flagged, &flags.Flagged},
+		`\deleted`:   {&mask.Deleted, &flags.Deleted},
+		`\seen`:      {&mask.Seen, &flags.Seen},
+		`\draft`:     {&mask.Draft, &flags.Draft},
+		`$junk`:      {&mask.Junk, &flags.Junk},
+		`$notjunk`:   {&mask.Notjunk, &flags.Notjunk},
+		`$forwarded`: {&mask.Forwarded, &flags.Forwarded},
+		`$phishing`:  {&mask.Phishing, &flags.Phishing},
+		`$mdnsent`:   {&mask.MDNSent, &flags.MDNSent},
+	}
+	keywords := map[string]bool{}
+	for k, v := range labels {
+		k = strings.ToLower(k)
+		if mf, ok := systemflags[k]; ok {
+			*mf[0] = true
+			*mf[1] = v
+		} else {
+			keywords[k] = v
+		}
+	}
+	return func(msgFlags Flags, msgKeywords []string) bool {
+		var f Flags
+		if f.Set(mask, msgFlags) != flags {
+			return false
+		}
+		for k, v := range keywords {
+			if slices.Contains(msgKeywords, k) != v {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// attachmentFilterFn returns a function that filters for the attachment-related
+// filter. A nil function is returned if there are attachment filters.
+func (mf *MessageFilter) attachmentFilterFn() func(m Message) bool {
+	f, nf := mf.filter, mf.notFilter
+	if f.Attachments == AttachmentIndifferent && nf.Attachments == AttachmentIndifferent {
+		return nil
+	}
+
+	return func(m Message) bool {
+		if !mf.ensurePart(m, false) {
+			return false
+		}
+		types := attachmentTypes(mf.log, *mf.part)
+		return (f.Attachments == AttachmentIndifferent || types[f.Attachments]) && (nf.Attachments == AttachmentIndifferent || !types[nf.Attachments])
+	}
+}
+
+var attachmentMimetypes = map[string]AttachmentType{
+	"application/pdf":                                AttachmentPDF,
+	"application/zip":                                AttachmentArchive,
+	"application/x-rar-compressed":                   AttachmentArchive,
+	"application/vnd.oasis.opendocument.spreadsheet": AttachmentSpreadsheet,
+	"application/vnd.ms-excel":                       AttachmentSpreadsheet,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": AttachmentSpreadsheet,
+	"application/vnd.oasis.opendocument.text":                           AttachmentDocument,
+	"application/msword": AttachmentDocument,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   AttachmentDocument,
+	"application/vnd.oasis.opendocument.presentation":                           AttachmentPresentation,
+	"application/vnd.ms-powerpoint":                                             AttachmentPresentation,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": AttachmentPresentation,
+}
+var attachmentExtensions = map[string]AttachmentType{
+	".pdf":     AttachmentPDF,
+	".zip":     AttachmentArchive,
+	".tar":     AttachmentArchive,
+	".tgz":     AttachmentArchive,
+	".tar.gz":  AttachmentArchive,
+	".tbz2":    AttachmentArchive,
+	".tar.bz2": AttachmentArchive,
+	".tar.lz":  AttachmentArchive,
+	".tlz":     AttachmentArchive,
+	".tar.xz":  AttachmentArchive,
+	".txz":     AttachmentArchive,
+	".tar.zst": AttachmentArchive,
+	".tar.lz4": AttachmentArchive,
+	".7z":      AttachmentArchive,
+	".rar":     AttachmentArchive,
+	".ods":     AttachmentSpreadsheet,
+	".xls":     AttachmentSpreadsheet,
+	".xlsx":    AttachmentSpreadsheet,
+	".odt":     AttachmentDocument,
+	".doc":     AttachmentDocument,
+	".docx":    AttachmentDocument,
+	".odp":     AttachmentPresentation,
+	".ppt":     AttachmentPresentation,
+	".pptx":    AttachmentPresentation,
+}
+
+// attachmentTypes returns the types of the attachments of a message.
+func attachmentTypes(log mlog.Log, p message.Part) map[AttachmentType]bool {
+	types := map[AttachmentType]bool{}
+	attachmentParts(&p, func(ap *message.Part) {
+		if t := attachmentType(log, ap); t != AttachmentIndifferent {
+			types[t] = true
+		}
+	})
+	if len(types) == 0 {
+		types[AttachmentNone] = true
+	} else {
+		types[AttachmentAny] = true
+	}
+	return types
+}
+
+// attachmentParts calls fn for each attachment of a message. Parts are considered
+// attachments like in the webmail: all non-multipart parts except text/plain and
+// text/html, and except the delivery status in DSNs.
+func attachmentParts(p *message.Part, fn func(p *message.Part)) {
+	var usePart func(p *message.Part, index int, parent *message.Part)
+	usePart = func(p *message.Part, index int, parent *message.Part) {
+		mt := p.MediaType + "/" + p.MediaSubType
+		for i := range p.Parts {
+			if mt == "MULTIPART/SIGNED" && i >= 1 {
+				continue
+			}
+			usePart(&p.Parts[i], i, p)
+		}
+		if p.MediaType == "MULTIPART" || mt == "TEXT/PLAIN" || mt == "/" || mt == "TEXT/HTML" {
+			return
+		}
+		var parentct string
+		if parent != nil {
+			parentct = parent.MediaType + "/" + parent.MediaSubType
+		}
+		if parentct == "MULTIPART/REPORT" && (index == 1 && (mt == "MESSAGE/GLOBAL-DELIVERY-STATUS" || mt == "MESSAGE/DELIVERY-STATUS") || index == 2 && (mt == "MESSAGE/GLOBAL-HEADERS" || mt == "TEXT/RFC822-HEADERS")) {
+			return
+		}
+		fn(p)
+	}
+	usePart(p, -1, nil)
+}
+
+// attachmentType returns the type of attachment p, based on its content-type or
+// the extension of its name. AttachmentIndifferent is returned for unknown types.
+func attachmentType(log mlog.Log, p *message.Part) AttachmentType {
+	mt := p.MediaType + "/" + p.MediaSubType
+	if p.MediaType == "IMAGE" {
+		return AttachmentImage
+	} else if t, ok := attachmentMimetypes[strings.ToLower(mt)]; ok {
+		return t
+	} else if ext := filepath.Ext(TryDecodeParam(log, p.ContentTypeParams["name"])); ext != "" {
+		if t, ok := attachmentExtensions[strings.ToLower(ext)]; ok {
+			return t
+		}
+	}
+	return AttachmentIndifferent
+}
+
+// envFilterFn returns a filter function for the "envelope" headers ("envelope" as
+// used by IMAP, i.e. basic message headers from/to/subject, an unfortunate name
+// clash with SMTP envelope). A nil function is returned if no filtering is needed.
+func (mf *MessageFilter) envFilterFn() func(m Message) bool {
+	f, nf := mf.filter, mf.notFilter
+	if len(f.From) == 0 && len(f.To) == 0 && len(f.Subject) == 0 && len(nf.From) == 0 && len(nf.To) == 0 && len(nf.Subject) == 0 {
+		return nil
+	}
+
+	lower := func(l []string) []string {
+		if len(l) == 0 {
+			return nil
+		}
+		r := make([]string, len(l))
+		for i, s := range l {
+			r[i] = strings.ToLower(s)
+		}
+		return r
+	}
+
+	filterSubject := lower(f.Subject)
+	notFilterSubject := lower(nf.Subject)
+	filterFrom := lower(f.From)
+	notFilterFrom := lower(nf.From)
+	filterTo := lower(f.To)
+	notFilterTo := lower(nf.To)
+
+	return func(m Message) bool {
+		if !mf.ensurePart(m, false) {
+			return false
+		}
+
+		var env message.Envelope
+		if mf.part.Envelope != nil {
+			env = *mf.part.Envelope
+		}
+
+		if len(filterSubject) > 0 || len(notFilterSubject) > 0 {
+			subject := strings.ToLower(env.Subject)
+			for _, s := range filterSubject {
+				if !strings.Contains(subject, s) {
+					return false
+				}
+			}
+			for _, s := range notFilterSubject {
+				if strings.Contains(subject, s) {
+					return false
+				}
+			}
+		}
+
+		contains := func(textLower []string, l []message.Address, all bool) bool {
+		next:
+			for _, s := range textLower {
+				for _, a := range l {
+					name := strings.ToLower(a.Name)
+					addr := strings.ToLower(fmt.Sprintf("<%s@%s>", a.User, a.Host))
+					if strings.Contains(name, s) || strings.Contains(addr, s) {
+						if !all {
+							return true
+						}
+						continue next
+					}
+				}
+				if all {
+					return false
+				}
+			}
+			return all
+		}
+
+		if len(filterFrom) > 0 && !contains(filterFrom, env.From, true) {
+			return false
+		}
+		if len(notFilterFrom) > 0 && contains(notFilterFrom, env.From, false) {
+			return false
+		}
+		if len(filterTo) > 0 || len(notFilterTo) > 0 {
+			to := append(append(append([]message.Address{}, env.To...), env.CC...), env.BCC...)
+			if len(filterTo) > 0 && !contains(filterTo, to, true) {
+				return false
+			}
+			if len(notFilterTo) > 0 && contains(notFilterTo, to, false) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// headerFilterFn returns a function that filters for the header filters. A nil
+// function is returned if there are no header filters.
+func (mf *MessageFilter) headerFilterFn() func(m Message) bool {
+	headers := mf.filter.Headers
+	if len(headers) == 0 {
+		return nil
+	}
+
+	lowerValues := make([]string, len(headers))
+	for i, t := range headers {
+		lowerValues[i] = strings.ToLower(t[1])
+	}
+
+	return func(m Message) bool {
+		if !mf.ensurePart(m, true) {
+			return false
+		}
+		hdr, err := mf.part.Header()
+		if err != nil {
+			mf.err = fmt.Errorf("reading header for message %d: %w", m.ID, err)
+			return false
+		}
+
+	next:
+		for i, t := range headers {
+			k := t[0]
+			v := lowerValues[i]
+			l := hdr.Values(k)
+			if v == "" && len(l) > 0 {
+				continue
+			}
+			for _, e := range l {
+				if strings.Contains(strings.ToLower(e), v) {
+					continue next
+				}
+			}
+			return false
+		}
+		return true
+	}
+}
+
+// wordsFilterFn returns a function that applies the word filters. A nil function
+// is returned when there is no word filter.
+func (mf *MessageFilter) wordsFilterFn() func(m Message) bool {
+	if len(mf.filter.Words) == 0 && len(mf.notFilter.Words) == 0 {
+		return nil
+	}
+
+	ws := PrepareWordSearch(mf.filter.Words, mf.notFilter.Words)
+	mf.words = &ws
+
+	return func(m Message) bool {
+		if !mf.ensurePart(m, true) {
+			return false
+		}
+
+		if ok, err := ws.MatchMessage(mf.log, mf.tx, m.ID, mf.part, true); err != nil {
+			mf.err = fmt.Errorf("searching for words in message %d: %w", m.ID, err)
+			return false
+		} else {
+			return ok
+		}
+	}
+}
+
+var wordDecoder = mime.WordDecoder{
+	CharsetReader: func(charset string, r io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "", "us-ascii", "utf-8":
+			return r, nil
+		}
+		enc, _ := ianaindex.MIME.Encoding(charset)
+		if enc == nil {
+			enc, _ = ianaindex.IANA.Encoding(charset)
+		}
+		if enc == nil {
+			return r, fmt.Errorf("unknown charset %q", charset)
+		}
+		return enc.NewDecoder().Reader(r), nil
+	},
+}
+
+// TryDecodeParam attempts to q/b-word-decode name, coming from Content-Type
+// "name" field or Content-Disposition "filename" field.
+//
+// RFC 2231 specify an encoding for non-ascii values in mime header parameters. But
+// it appears common practice to instead just q/b-word encode the values.
+// Thunderbird and gmail.com do this for the Content-Type "name" parameter.
+// gmail.com also does that for the Content-Disposition "filename" parameter, where
+// Thunderbird uses the RFC 2231-defined encoding. Go's mime.ParseMediaType parses
+// the mechanism specified in RFC 2231 only. The value for "name" we get here would
+// already be decoded properly for standards-compliant headers, like
+// "filename*0*=UTF-8”%...; filename*1*=%.... We'll look for Q/B-word encoding
+// markers ("=?"-prefix or "?="-suffix) and try to decode if present. This would
+// only cause trouble for filenames having this prefix/suffix.
+func TryDecodeParam(log mlog.Log, name string) string {
+	if name == "" || !strings.HasPrefix(name, "=?") && !strings.HasSuffix(name, "?=") {
+		return name
+	}
+	// todo: find where this is allowed. it seems quite common. perhaps we should remove the pedantic check?
+	if mox.Pedantic {
+		log.Debug("attachment contains rfc2047 q/b-word-encoded mime parameter instead of rfc2231-encoded", slog.String("name", name))
+		return name
+	}
+	s, err := wordDecoder.DecodeHeader(name)
+	if err != nil {
+		log.Debugx("q/b-word decoding mime parameter", err, slog.String("name", name))
+		return name
+	}
+	return s
+}
@@ -9,29 +9,41 @@ import (
 
 // MsgReader provides access to a message. Reads return the "msg_prefix" in the
 // database (typically received headers), followed by the on-disk msg file
-// contents. MsgReader is an io.Reader, io.ReaderAt and io.Closer.
+// contents, decrypted and decompressed if the file is stored encrypted and/or
+// compressed. MsgReader is an io.Reader, io.ReaderAt and io.Closer.
 type MsgReader struct {
-	prefix []byte   // First part of the message. Typically contains received headers.
-	path   string   // To on-disk message file.
-	size   int64    // Total size of message, including prefix and contents from path.
-	offset int64    // Current reading offset.
-	f      *os.File // Opened path, automatically opened after prefix has been read.
-	err    error    // If set, error to return for reads. Sets io.EOF for readers, but ReadAt ignores them.
+	prefix     []byte      // First part of the message. Typically contains received headers.
+	path       string      // To on-disk message file.
+	size       int64       // Total size of message, including prefix and contents from path.
+	compressed bool        // Whether file is compressed, from Message.FileCompressed.
+	encrypted  bool        // Whether file is encrypted, from Message.FileEncrypted.
+	offset     int64       // Current reading offset.
+	f          *os.File    // Opened path, automatically opened after prefix has been read.
+	r          io.ReaderAt // Reader for data in f, decrypting and decompressing if needed.
+	err        error       // If set, error to return for reads. Sets io.EOF for readers, but ReadAt ignores them.
 }
 
 var errMsgClosed = errors.New("msg is closed")
 
-// FileMsgReader makes a MsgReader for an open file.
+// FileMsgReader makes a MsgReader for an open file, which is not compressed or
+// encrypted, e.g. a message that is being delivered.
 // If initialization fails, reads will return the error.
 // Only call close on the returned MsgReader if you want to close msgFile.
 func FileMsgReader(prefix []byte, msgFile *os.File) *MsgReader {
-	mr := &MsgReader{prefix: prefix, path: msgFile.Name(), f: msgFile}
-	fi, err := msgFile.Stat()
+	return fileMsgReader(prefix, msgFile, false, false)
+}
+
+// fileMsgReader makes a MsgReader for an open file, stored compressed and/or
+// encrypted as indicated.
+func fileMsgReader(prefix []byte, msgFile *os.File, compressed, encrypted bool) *MsgReader {
+	mr := &MsgReader{prefix: prefix, path: msgFile.Name(), f: msgFile, compressed: compressed, encrypted: encrypted}
+	r, size, err := msgFileReader(msgFile, compressed, encrypted)
 	if err != nil {
 		mr.err = err
 		return mr
 	}
-	mr.size = int64(len(prefix)) + fi.Size()
+	mr.r = r
+	mr.size = int64(len(prefix)) + size
 	return mr
 }
 
@@ -79,8 +91,20 @@ func (m *MsgReader) read(buf []byte, off int64, pread bool) (int, error) {
 				break
 			}
 			m.f = f
+			r, size, err := msgFileReader(f, m.compressed, m.encrypted)
+			if err != nil {
+				m.err = err
+				break
+			}
+			// The size of compressed and encrypted data comes from the file header, which
+			// must match the size in the database.
+			if (m.compressed || m.encrypted) && int64(len(m.prefix))+size != m.size {
+				m.err = fmt.Errorf("message file has data size %d, expected %d", size, m.size-int64(len(m.prefix)))
+				break
+			}
+			m.r = r
 		}
-		n, err := m.f.ReadAt(buf[o:], off-int64(len(m.prefix)))
+		n, err := m.r.ReadAt(buf[o:], off-int64(len(m.prefix)))
 		if !pread && n > 0 {
 			m.offset += int64(n)
 		}
@@ -115,6 +139,7 @@ func (m *MsgReader) Close() error {
 			return err
 		}
 		m.f = nil
+		m.r = nil
 	}
 	if m.err == errMsgClosed {
 		return m.err
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// Interval between applying the retention rules of all accounts.
+var retentionInterval = 6 * time.Hour
+
+// StartRetention starts a goroutine that periodically applies the retention
+// rules of accounts, see config.RetentionRule, and removes deleted messages that
+// are no longer kept, see DeletedMessage. The first run is shortly after startup.
+// Stops when mox shuts down.
+func StartRetention() {
+	go manageRetention()
+}
+
+func manageRetention() {
+	log := mlog.New("retention", nil)
+
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("retention panic", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Store)
+		}
+	}()
+
+	timer := time.NewTimer(time.Minute)
+	defer timer.Stop()
+	for {
+		select {
+		case <-mox.Shutdown.Done():
+			return
+		case <-timer.C:
+		}
+
+		for _, accName := range mox.Conf.Accounts() {
+			if err := applyRetention(log, accName); err != nil {
+				log.Errorx("applying retention rules", err, slog.String("account", accName))
+			}
+		}
+		timer.Reset(retentionInterval)
+	}
+}
+
+// applyRetention applies the retention rules of the account, and removes deleted
+// messages that are no longer kept.
+func applyRetention(log mlog.Log, accName string) error {
+	acc, err := OpenAccount(log, accName)
+	if err != nil {
+		return fmt.Errorf("open account: %w", err)
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account after applying retention rules")
+	}()
+
+	conf, _ := acc.Conf()
+	if len(conf.RetentionRules) > 0 {
+		if _, _, err := acc.ApplyRetention(mox.Shutdown, log, conf.RetentionRules, time.Now()); err != nil {
+			return err
+		}
+	}
+	n, err := acc.DeletedRemoveExpired(mox.Shutdown, log, time.Now())
+	if n > 0 {
+		log.Info("removed expired deleted messages", slog.String("account", accName), slog.Int("count", n))
+	}
+	return err
+}
+
+// retentionMailbox returns the mailbox by name, or by special-use flag. Nil is
+// returned if the mailbox does not exist.
+func retentionMailbox(tx *bstore.Tx, a *Account, name, specialUse string) (*Mailbox, error) {
+	if name != "" {
+		return a.MailboxFind(tx, name)
+	}
+	var mb Mailbox
+	switch strings.ToLower(specialUse) {
+	case "archive":
+		mb.Archive = true
+	case "drafts":
+		mb.Draft = true
+	case "junk":
+		mb.Junk = true
+	case "sent":
+		mb.Sent = true
+	case "trash":
+		mb.Trash = true
+	default:
+		return nil, fmt.Errorf("unknown special-use %q", specialUse)
+	}
+	xmb, err := bstore.QueryTx[Mailbox](tx).FilterNonzero(mb).Limit(1).Get()
+	if err == bstore.ErrAbsent {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("looking up mailbox with special-use %q: %v", specialUse, err)
+	}
+	return &xmb, nil
+}
+
+// ApplyRetention applies the retention rules, in order, to the mailboxes of the
+// account. Messages added to a mailbox longer ago than the age of a rule are
+// expunged, or moved to the destination mailbox of the rule. Rules for mailboxes
+// that don't exist are skipped. Changes are broadcast. The numbers of expunged and
+// moved messages are returned.
+//
+// Must be called without the account lock held, the write lock is held for each
+// rule.
+func (a *Account) ApplyRetention(ctx context.Context, log mlog.Log, rules []config.RetentionRule, now time.Time) (expunged, moved int, rerr error) {
+	for i, rule := range rules {
+		var mbName, dstName string
+		var l []Message
+		var remove []Message
+		var changes []Change
+
+		var err error
+		a.WithWLock(func() {
+			err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+				mb, err := retentionMailbox(tx, a, rule.Mailbox, rule.SpecialUse)
+				if err != nil || mb == nil {
+					return err
+				}
+				mbName = mb.Name
+
+				var dst *Mailbox
+				if rule.MoveToMailbox != "" || rule.MoveToSpecialUse != "" {
+					dst, err = retentionMailbox(tx, a, rule.MoveToMailbox, rule.MoveToSpecialUse)
+					if err != nil {
+						return err
+					} else if dst == nil || dst.ID == mb.ID {
+						log.Info("destination mailbox for retention rule does not exist or is the same as source, skipping rule", slog.Int("rule", i+1))
+						return nil
+					}
+					dstName = dst.Name
+				}
+
+				old := now.Add(-rule.Age)
+				q := bstore.QueryTx[Message](tx)
+				q.FilterNonzero(Message{MailboxID: mb.ID})
+				q.FilterEqual("Expunged", false)
+				q.FilterFn(func(m Message) bool {
+					added := m.Received
+					if m.SaveDate != nil {
+						added = *m.SaveDate
+					}
+					return added.Before(old)
+				})
+				q.SortAsc("UID")
+				l, err = q.List()
+				if err != nil {
+					return fmt.Errorf("listing old messages: %v", err)
+				}
+
+				if dst == nil {
+					changes, err = a.expungeMessages(ctx, log, tx, mb, l)
+					remove = l
+				} else {
+					changes, err = a.moveMessages(ctx, log, tx, mb, dst, l)
+				}
+				return err
+			})
+			if err == nil {
+				BroadcastChanges(a, changes)
+			}
+		})
+		if err != nil {
+			return expunged, moved, fmt.Errorf("applying retention rule %d: %w", i+1, err)
+		}
+
+		a.ExpungedFilesRemove(log, remove)
+
+		if len(l) == 0 {
+			continue
+		}
+		if dstName == "" {
+			expunged += len(l)
+			log.Info("expunged old messages for retention rule", slog.String("mailbox", mbName), slog.Int("count", len(l)))
+		} else {
+			moved += len(l)
+			log.Info("moved old messages for retention rule", slog.String("mailbox", mbName), slog.String("destination", dstName), slog.Int("count", len(l)))
+		}
+	}
+	return expunged, moved, nil
+}
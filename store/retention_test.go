@@ -0,0 +1,119 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestRetention(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+	}()
+	defer Switchboard()()
+
+	now := time.Now()
+
+	deliver := func(mailbox string, saveDate time.Time) Message {
+		t.Helper()
+		msg := "Subject: test\r\n\r\ntest\r\n"
+		msgFile, err := CreateMessageTemp(log, "retention-test")
+		tcheck(t, err, "create temp file")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		var m Message
+		acc.WithWLock(func() {
+			err := acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+				mb, err := acc.MailboxFind(tx, mailbox)
+				tcheck(t, err, "get mailbox")
+				m = Message{
+					MailboxID:     mb.ID,
+					MailboxOrigID: mb.ID,
+					Received:      saveDate,
+					Size:          int64(len(msg)),
+				}
+				err = acc.DeliverMessage(log, tx, &m, msgFile, false, true, false, true)
+				tcheck(t, err, "deliver message")
+				m.SaveDate = &saveDate // Set to now during delivery.
+				err = tx.Update(&m)
+				tcheck(t, err, "update message")
+				err = tx.Get(mb)
+				tcheck(t, err, "get mailbox")
+				mb.Add(m.MailboxCounts())
+				return tx.Update(mb)
+			})
+			tcheck(t, err, "deliver")
+		})
+		return m
+	}
+
+	checkCount := func(mailbox string, exp int64) {
+		t.Helper()
+		err := acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+			mb, err := acc.MailboxFind(tx, mailbox)
+			tcheck(t, err, "get mailbox")
+			n, err := bstore.QueryTx[Message](tx).FilterNonzero(Message{MailboxID: mb.ID}).FilterEqual("Expunged", false).Count()
+			tcheck(t, err, "count messages")
+			if int64(n) != exp || mb.Total != exp {
+				t.Fatalf("mailbox %s: got %d messages, total %d, expected %d", mailbox, n, mb.Total, exp)
+			}
+			return nil
+		})
+		tcheck(t, err, "read")
+	}
+
+	day := 24 * time.Hour
+	trashOld := deliver("Trash", now.Add(-40*day))
+	deliver("Trash", now.Add(-10*day))
+	inboxOld := deliver("Inbox", now.Add(-400*day))
+	deliver("Inbox", now.Add(-10*day))
+
+	rules := []config.RetentionRule{
+		{SpecialUse: "Trash", Age: 30 * day},
+		{Mailbox: "Inbox", Age: 365 * day, MoveToSpecialUse: "Archive"},
+		{Mailbox: "Inbox", Age: day, MoveToMailbox: "Absent"}, // Skipped.
+		{Mailbox: "Absent", Age: day},                         // Skipped.
+	}
+	expunged, moved, err := acc.ApplyRetention(ctxbg, log, rules, now)
+	tcheck(t, err, "apply retention")
+	if expunged != 1 || moved != 1 {
+		t.Fatalf("got %d expunged and %d moved, expected 1 and 1", expunged, moved)
+	}
+	checkCount("Trash", 1)
+	checkCount("Inbox", 1)
+	checkCount("Archive", 1)
+
+	if _, err := os.Stat(acc.MessagePath(trashOld.ID)); err == nil {
+		t.Fatalf("message file of expunged message still present")
+	}
+	m := Message{ID: inboxOld.ID}
+	err = acc.DB.Get(ctxbg, &m)
+	tcheck(t, err, "get moved message")
+	if m.SaveDate == nil || m.SaveDate.Before(now) {
+		t.Fatalf("save date of moved message not updated")
+	}
+
+	// Applying again doesn't change anything, moved message has new save date.
+	expunged, moved, err = acc.ApplyRetention(ctxbg, log, rules, now)
+	tcheck(t, err, "apply retention")
+	if expunged != 0 || moved != 0 {
+		t.Fatalf("got %d expunged and %d moved, expected none", expunged, moved)
+	}
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// VirtualPrefix is the mailbox name prefix for saved searches in IMAP, where they
+// are virtual mailboxes, e.g. "#Virtual/All unread". Mailboxes of an account
+// cannot start with "#", so cannot have this prefix.
+const VirtualPrefix = "#Virtual/"
+
+// SavedSearch is a search for messages, stored in the account. Saved searches are
+// shown as virtual mailboxes in IMAP and webmail. Messages in a virtual mailbox
+// are the messages in regular mailboxes matching the search. Flags can be changed
+// through a virtual mailbox, but messages cannot be added or removed.
+type SavedSearch struct {
+	ID   int64
+	Name string `bstore:"nonzero,unique"` // Without VirtualPrefix.
+
+	// Search query as entered in the webmail, shown when opening the saved search.
+	// Optional, the filters determine the matching messages.
+	Query string
+
+	Filter    Filter
+	NotFilter NotFilter
+
+	// If > 0, only messages received in the last MaxAgeDays days match.
+	MaxAgeDays int
+
+	// Whether the virtual mailbox has the special-use flag \All, for clients that
+	// show all messages of an account. ../rfc/6154
+	All bool
+
+	// For IMAP. The UIDs in virtual mailboxes are message IDs, which are unique and
+	// stable within an account. A new UIDValidity is assigned when the filters change.
+	UIDValidity uint32
+}
+
+// ErrSavedSearchInvalid is returned by SavedSearchSave for invalid or duplicate
+// names, and invalid parameters.
+var ErrSavedSearchInvalid = errors.New("invalid saved search")
+
+// CheckSavedSearchName checks if name is valid for a saved search. Names cannot
+// contain a slash, virtual mailboxes cannot have children.
+func CheckSavedSearchName(name string) error {
+	if strings.Contains(name, "/") {
+		return errors.New("name of saved search cannot contain slash")
+	}
+	_, _, err := CheckMailboxName(name, false)
+	return err
+}
+
+// SavedSearchSave inserts a new saved search, if ss.ID is 0, or updates an
+// existing saved search. A new UIDValidity is assigned for new saved searches and
+// when filters change.
+func (a *Account) SavedSearchSave(tx *bstore.Tx, ss *SavedSearch) error {
+	if err := CheckSavedSearchName(ss.Name); err != nil {
+		return fmt.Errorf("%w: bad name: %v", ErrSavedSearchInvalid, err)
+	}
+	if ss.MaxAgeDays < 0 {
+		return fmt.Errorf("%w: max age days must be >= 0", ErrSavedSearchInvalid)
+	}
+
+	var renew bool
+	if ss.ID == 0 {
+		renew = true
+	} else {
+		oss := SavedSearch{ID: ss.ID}
+		if err := tx.Get(&oss); err != nil {
+			return fmt.Errorf("get saved search: %w", err)
+		}
+		ss.UIDValidity = oss.UIDValidity
+		renew = !oss.filtersEqual(*ss)
+	}
+	if renew {
+		uidvalidity, err := a.NextUIDValidity(tx)
+		if err != nil {
+			return fmt.Errorf("assigning uidvalidity: %v", err)
+		}
+		ss.UIDValidity = uidvalidity
+	}
+
+	exists, err := bstore.QueryTx[SavedSearch](tx).FilterNonzero(SavedSearch{Name: ss.Name}).FilterNotEqual("ID", ss.ID).Exists()
+	if err != nil {
+		return fmt.Errorf("checking for existing saved search: %v", err)
+	} else if exists {
+		return fmt.Errorf("%w: saved search with name %q already exists", ErrSavedSearchInvalid, ss.Name)
+	}
+
+	if ss.ID == 0 {
+		err = tx.Insert(ss)
+	} else {
+		err = tx.Update(ss)
+	}
+	if err != nil {
+		return fmt.Errorf("storing saved search: %v", err)
+	}
+	return nil
+}
+
+// filtersEqual returns whether the filters of ss and o match the same messages.
+func (ss SavedSearch) filtersEqual(o SavedSearch) bool {
+	return reflect.DeepEqual(ss.Filter, o.Filter) && reflect.DeepEqual(ss.NotFilter, o.NotFilter) && ss.MaxAgeDays == o.MaxAgeDays
+}
+
+// SavedSearchMatcher checks if messages match a saved search.
+type SavedSearchMatcher struct {
+	none           bool // If the mailbox of the filter no longer exists.
+	matchMailboxes bool
+	mailboxIDs     map[int64]bool
+	oldest         time.Time
+	mf             *MessageFilter
+}
+
+// NewSavedSearchMatcher returns a matcher for ss, for messages in the account. The
+// matcher must be closed after use.
+func (a *Account) NewSavedSearchMatcher(log mlog.Log, tx *bstore.Tx, ss SavedSearch) (*SavedSearchMatcher, error) {
+	accConf, _ := a.Conf()
+	sm := &SavedSearchMatcher{}
+	var prefixes []string
+	var err error
+	sm.matchMailboxes, sm.mailboxIDs, prefixes, err = PrepareMailboxIDs(tx, ss.Filter, accConf.RejectsMailbox)
+	if errors.Is(err, bstore.ErrAbsent) {
+		sm.none = true
+	} else if err != nil {
+		return nil, err
+	}
+	if ss.Filter.MailboxChildrenIncluded {
+		if err := GatherMailboxIDs(tx, sm.mailboxIDs, prefixes); err != nil {
+			return nil, err
+		}
+	}
+	if ss.MaxAgeDays > 0 {
+		sm.oldest = time.Now().Add(-time.Duration(ss.MaxAgeDays) * 24 * time.Hour)
+	}
+	sm.mf = NewMessageFilter(log, a, tx, ss.Filter, ss.NotFilter)
+	return sm, nil
+}
+
+// Close releases resources of the matcher.
+func (sm *SavedSearchMatcher) Close() {
+	sm.mf.Close()
+}
+
+// Match returns whether m matches the saved search. Expunged messages never match.
+func (sm *SavedSearchMatcher) Match(m Message) (bool, error) {
+	if sm.none || m.Expunged {
+		return false, nil
+	}
+	if len(sm.mailboxIDs) > 0 && sm.mailboxIDs[m.MailboxID] != sm.matchMailboxes {
+		return false, nil
+	}
+	if !sm.oldest.IsZero() && m.Received.Before(sm.oldest) {
+		return false, nil
+	}
+	if !sm.mf.MatchFlags(m.Flags, m.Keywords) || !sm.mf.MatchMessage(m) {
+		return false, sm.mf.Err()
+	}
+	return true, sm.mf.Err()
+}
+
+// SavedSearchMessages calls fn for each message matching ss, in order of message
+// ID, i.e. the UID in the virtual mailbox.
+func (a *Account) SavedSearchMessages(log mlog.Log, tx *bstore.Tx, ss SavedSearch, fn func(m Message) error) error {
+	sm, err := a.NewSavedSearchMatcher(log, tx, ss)
+	if err != nil {
+		return err
+	}
+	defer sm.Close()
+	if err := sm.mf.UseWordIndex(); err != nil {
+		return err
+	}
+
+	q := bstore.QueryTx[Message](tx)
+	q.FilterEqual("Expunged", false)
+	q.SortAsc("ID")
+	return q.ForEach(func(m Message) error {
+		if ok, err := sm.Match(m); err != nil {
+			return err
+		} else if ok {
+			return fn(m)
+		}
+		return nil
+	})
+}
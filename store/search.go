@@ -2,11 +2,14 @@ package store
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/mjl-/bstore"
+
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
 )
@@ -16,6 +19,9 @@ import (
 type WordSearch struct {
 	words, notWords    [][]byte
 	searchBuf, keepBuf []byte
+
+	// If non-nil, set by UseWordIndex, only these messages can match.
+	candidates map[int64]struct{}
 }
 
 // PrepareWordSearch returns a search context that can be used to match multiple
@@ -50,7 +56,34 @@ func PrepareWordSearch(words, notWords []string) WordSearch {
 	keepBuf := make([]byte, keep)
 	searchBuf := make([]byte, bufSize)
 
-	return WordSearch{wl, nwl, searchBuf, keepBuf}
+	return WordSearch{wl, nwl, searchBuf, keepBuf, nil}
+}
+
+// UseWordIndex looks up the words in the word index of acc, if it is complete,
+// for use by Candidate. Must be called before searching messages.
+func (ws *WordSearch) UseWordIndex(tx *bstore.Tx, acc *Account) error {
+	words := make([]string, len(ws.words))
+	for i, w := range ws.words {
+		words[i] = string(w)
+	}
+	ids, ok, err := acc.WordIndexMatch(tx, words)
+	if err != nil {
+		return fmt.Errorf("looking up words in index: %w", err)
+	} else if ok {
+		ws.candidates = ids
+	}
+	return nil
+}
+
+// Candidate returns whether message messageID can match, based on the word index
+// (see UseWordIndex). If false, the message cannot match and MatchPart does not
+// have to be called.
+func (ws WordSearch) Candidate(messageID int64) bool {
+	if ws.candidates == nil {
+		return true
+	}
+	_, ok := ws.candidates[messageID]
+	return ok
 }
 
 // MatchPart returns whether the part/mail message p matches the search.
@@ -64,6 +97,26 @@ func (ws WordSearch) MatchPart(log mlog.Log, p *message.Part, headerToo bool) (b
 	return match, err
 }
 
+// MatchMessage is like MatchPart, for message messageID with part p, but also
+// searches the text extracted from attachments during delivery, see
+// AttachmentText.
+func (ws WordSearch) MatchMessage(log mlog.Log, tx *bstore.Tx, messageID int64, p *message.Part, headerToo bool) (bool, error) {
+	seen := map[int]bool{}
+	miss, err := ws.matchPart(log, p, headerToo, seen)
+	if err == nil && !miss && !ws.isQuickHit(seen) {
+		at := AttachmentText{ID: messageID}
+		if err = tx.Get(&at); err == bstore.ErrAbsent {
+			err = nil
+		} else if err != nil {
+			err = fmt.Errorf("get attachment text: %w", err)
+		} else {
+			miss, err = ws.searchReader(log, strings.NewReader(at.Text), seen)
+		}
+	}
+	match := err == nil && !miss && len(seen) == len(ws.words)
+	return match, err
+}
+
 // If all words are seen, and we there are no not-words that force us to search
 // till the end, we know we have a match.
 func (ws WordSearch) isQuickHit(seen map[int]bool) bool {
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/sieve"
+)
+
+// Limits for sieve scripts, per account.
+const (
+	SieveMaxScriptSize = 64 * 1024
+	SieveMaxScripts    = 32
+)
+
+var (
+	ErrUnknownSieveScript  = errors.New("no such sieve script")
+	ErrSieveScriptExists   = errors.New("sieve script already exists")
+	ErrSieveScriptActive   = errors.New("sieve script is active")
+	ErrSieveScriptName     = errors.New("invalid sieve script name")
+	ErrSieveScriptTooLarge = errors.New("sieve script too large")
+	ErrSieveScriptsTooMany = errors.New("too many sieve scripts")
+)
+
+// SieveScript is a Sieve filtering script, RFC 5228, typically managed through
+// ManageSieve. At most one script is active, it is evaluated during delivery.
+type SieveScript struct {
+	ID       int64
+	Name     string `bstore:"nonzero,unique"`
+	Script   string
+	Active   bool
+	Modified time.Time `bstore:"default now"`
+}
+
+// AutoResponse records an automatic response sent to an address, e.g. for a
+// Sieve vacation action. It is used to prevent sending the same response to
+// the same sender too often.
+type AutoResponse struct {
+	ID     int64
+	Handle string    `bstore:"nonzero,unique Handle+Sender"` // Identifies the response, e.g. a hash of the sieve vacation handle.
+	Sender string    `bstore:"nonzero"`                      // Address the response was sent to, lower-cased.
+	Sent   time.Time `bstore:"nonzero"`
+}
+
+// CheckSieveScriptName returns an error if name is not a valid script name, RFC 5804.
+func CheckSieveScriptName(name string) error {
+	// ../rfc/5804:361
+	if name == "" || len(name) > 255 || !utf8.ValidString(name) {
+		return ErrSieveScriptName
+	}
+	for _, c := range name {
+		if c < 0x20 || c >= 0x7f && c <= 0x9f || c == 0x2028 || c == 0x2029 {
+			return ErrSieveScriptName
+		}
+	}
+	return nil
+}
+
+// SieveScripts returns all sieve scripts of the account, ordered by name.
+func (a *Account) SieveScripts(ctx context.Context) ([]SieveScript, error) {
+	return bstore.QueryDB[SieveScript](ctx, a.DB).SortAsc("Name").List()
+}
+
+// SieveScriptGet returns the sieve script by name.
+func (a *Account) SieveScriptGet(ctx context.Context, name string) (SieveScript, error) {
+	s, err := bstore.QueryDB[SieveScript](ctx, a.DB).FilterNonzero(SieveScript{Name: name}).Get()
+	if err == bstore.ErrAbsent {
+		err = ErrUnknownSieveScript
+	}
+	return s, err
+}
+
+// SieveScriptPut adds or replaces the script by name, after checking it parses.
+// Syntax errors wrap sieve.ErrSyntax.
+func (a *Account) SieveScriptPut(ctx context.Context, name, script string) error {
+	if err := CheckSieveScriptName(name); err != nil {
+		return err
+	} else if len(script) > SieveMaxScriptSize {
+		return ErrSieveScriptTooLarge
+	} else if _, err := sieve.Parse(script); err != nil {
+		return err
+	}
+
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		s, err := bstore.QueryTx[SieveScript](tx).FilterNonzero(SieveScript{Name: name}).Get()
+		if err == bstore.ErrAbsent {
+			n, err := bstore.QueryTx[SieveScript](tx).Count()
+			if err != nil {
+				return fmt.Errorf("counting scripts: %v", err)
+			} else if n >= SieveMaxScripts {
+				return ErrSieveScriptsTooMany
+			}
+			return tx.Insert(&SieveScript{Name: name, Script: script, Modified: time.Now()})
+		} else if err != nil {
+			return fmt.Errorf("get script: %v", err)
+		}
+		s.Script = script
+		s.Modified = time.Now()
+		return tx.Update(&s)
+	})
+}
+
+// SieveScriptActivate makes the script by name the active script. If name is
+// empty, no script will be active.
+func (a *Account) SieveScriptActivate(ctx context.Context, name string) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		if name != "" {
+			exists, err := bstore.QueryTx[SieveScript](tx).FilterNonzero(SieveScript{Name: name}).Exists()
+			if err != nil {
+				return fmt.Errorf("looking up script: %v", err)
+			} else if !exists {
+				return ErrUnknownSieveScript
+			}
+		}
+		if _, err := bstore.QueryTx[SieveScript](tx).FilterEqual("Active", true).UpdateField("Active", false); err != nil {
+			return fmt.Errorf("deactivating scripts: %v", err)
+		}
+		if name == "" {
+			return nil
+		}
+		_, err := bstore.QueryTx[SieveScript](tx).FilterNonzero(SieveScript{Name: name}).UpdateField("Active", true)
+		return err
+	})
+}
+
+// SieveScriptDelete removes the script by name. Active scripts cannot be removed.
+func (a *Account) SieveScriptDelete(ctx context.Context, name string) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		s, err := bstore.QueryTx[SieveScript](tx).FilterNonzero(SieveScript{Name: name}).Get()
+		if err == bstore.ErrAbsent {
+			return ErrUnknownSieveScript
+		} else if err != nil {
+			return fmt.Errorf("get script: %v", err)
+		} else if s.Active {
+			// ../rfc/5804:1183
+			return ErrSieveScriptActive
+		}
+		return tx.Delete(&s)
+	})
+}
+
+// SieveScriptRename renames a script, keeping whether it is active.
+func (a *Account) SieveScriptRename(ctx context.Context, oldName, newName string) error {
+	if err := CheckSieveScriptName(newName); err != nil {
+		return err
+	}
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		s, err := bstore.QueryTx[SieveScript](tx).FilterNonzero(SieveScript{Name: oldName}).Get()
+		if err == bstore.ErrAbsent {
+			return ErrUnknownSieveScript
+		} else if err != nil {
+			return fmt.Errorf("get script: %v", err)
+		}
+		exists, err := bstore.QueryTx[SieveScript](tx).FilterNonzero(SieveScript{Name: newName}).Exists()
+		if err != nil {
+			return fmt.Errorf("looking up script: %v", err)
+		} else if exists {
+			return ErrSieveScriptExists
+		}
+		s.Name = newName
+		s.Modified = time.Now()
+		return tx.Update(&s)
+	})
+}
+
+// SieveActive returns the parsed active sieve script, or nil if no script is
+// active.
+func (a *Account) SieveActive(tx *bstore.Tx) (*sieve.Script, error) {
+	s, err := bstore.QueryTx[SieveScript](tx).FilterEqual("Active", true).Get()
+	if err == bstore.ErrAbsent {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("get active sieve script: %v", err)
+	}
+	return sieve.Parse(s.Script)
+}
+
+// AutoResponseCheck returns whether a response identified by handle may be sent
+// to sender, which is the case if no such response was sent within interval. If
+// so, the response is recorded as sent.
+func (a *Account) AutoResponseCheck(ctx context.Context, handle, sender string, interval time.Duration) (bool, error) {
+	var ok bool
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		now := time.Now()
+		ar, err := bstore.QueryTx[AutoResponse](tx).FilterNonzero(AutoResponse{Handle: handle, Sender: sender}).Get()
+		if err == bstore.ErrAbsent {
+			ok = true
+			return tx.Insert(&AutoResponse{Handle: handle, Sender: sender, Sent: now})
+		} else if err != nil {
+			return fmt.Errorf("get auto response: %v", err)
+		} else if now.Sub(ar.Sent) < interval {
+			return nil
+		}
+		ok = true
+		ar.Sent = now
+		return tx.Update(&ar)
+	})
+	return ok, err
+}
@@ -83,6 +83,12 @@ type ChangeAddSubscription struct {
 	Flags []string // For additional IMAP flags like \NonExistent.
 }
 
+// ChangeRemoveSubscription is sent for a removed subscription of a mailbox.
+type ChangeRemoveSubscription struct {
+	Name  string
+	Flags []string // For additional IMAP flags like \NonExistent.
+}
+
 // ChangeMailboxCounts is sent when the number of total/deleted/unseen/unread messages changes.
 type ChangeMailboxCounts struct {
 	MailboxID   int64
@@ -105,6 +111,14 @@ type ChangeMailboxKeywords struct {
 	Keywords    []string
 }
 
+// ChangeAnnotation is sent when an annotation is added, updated or removed, for a
+// mailbox or account-wide. The value is not included.
+type ChangeAnnotation struct {
+	MailboxID   int64  // Zero for account-wide annotations.
+	MailboxName string // Empty for account-wide annotations.
+	Key         string // Entry name, e.g. "/private/comment".
+}
+
 var switchboardBusy atomic.Bool
 
 // Switchboard distributes changes to accounts to interested listeners. See Comm and Change.
@@ -212,6 +226,17 @@ func (c *Comm) Get() []Change {
 	return l
 }
 
+// Unget puts changes retrieved with Get back in front of the pending changes,
+// for changes that cannot be processed yet. Pending is not signaled.
+func (c *Comm) Unget(l []Change) {
+	if len(l) == 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.changes = append(append([]Change{}, l...), c.changes...)
+}
+
 // BroadcastChanges ensures changes are sent to all listeners on the accoount.
 func BroadcastChanges(acc *Account, ch []Change) {
 	if len(ch) == 0 {
@@ -121,9 +121,10 @@ func TestThreadingUpgrade(t *testing.T) {
 	// We open the database file directly, so we don't trigger the consistency checker.
 	db, err := bstore.Open(ctxbg, dbpath, &bstore.Options{Timeout: 5 * time.Second, Perm: 0660}, DBTypes...)
 	err = db.Write(ctxbg, func(tx *bstore.Tx) error {
-		up := Upgrade{ID: 1}
-		err := tx.Delete(&up)
-		tcheck(t, err, "delete upgrade")
+		// Keep the word index, so only the threading upgrade runs.
+		up := Upgrade{ID: 1, WordIndex: true}
+		err := tx.Update(&up)
+		tcheck(t, err, "reset upgrade")
 
 		q := bstore.QueryTx[Message](tx)
 		_, err = q.UpdateFields(map[string]any{
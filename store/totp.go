@@ -0,0 +1,239 @@
+package store
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/totp"
+)
+
+// ErrTOTP is returned for invalid two-factor authentication requests, e.g. a bad
+// code during enrolment.
+var ErrTOTP = errors.New("two-factor authentication")
+
+// Number of recovery codes generated when enabling two-factor authentication.
+const totpRecoveryCodes = 10
+
+// TOTP holds the state of two-factor authentication with time-based one-time
+// passwords, RFC 6238, for logins to the web interfaces. There is at most one per
+// account, with ID 1. The admin uses the same type, stored in a file.
+//
+// During enrolment, a new secret is stored but not yet enabled. Two-factor
+// authentication is enabled once a code for the secret has been verified.
+type TOTP struct {
+	ID            int64
+	Secret        []byte
+	Enabled       bool
+	LastCounter   int64    // Time step of the last accepted code, codes can only be used once.
+	RecoveryCodes []string // Hex SHA-256 hashes of unused recovery codes.
+}
+
+// NewTOTP returns a TOTP with a new secret, not yet enabled.
+func NewTOTP() (TOTP, error) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return TOTP{}, fmt.Errorf("generating secret: %v", err)
+	}
+	return TOTP{ID: 1, Secret: secret}, nil
+}
+
+func totpRecoveryHash(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(code, " ", ""))
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
+
+// Enable verifies a code for the secret and enables two-factor authentication.
+// New recovery codes are returned, only their hashes are kept.
+func (t *TOTP) Enable(code string, now time.Time) ([]string, error) {
+	if t.Enabled {
+		return nil, fmt.Errorf("%w: already enabled", ErrTOTP)
+	} else if len(t.Secret) == 0 {
+		return nil, fmt.Errorf("%w: no enrolment in progress", ErrTOTP)
+	}
+	counter, ok := totp.Verify(t.Secret, code, now, 0)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid code, check the time on your device", ErrTOTP)
+	}
+
+	codes := make([]string, totpRecoveryCodes)
+	hashes := make([]string, totpRecoveryCodes)
+	for i := range codes {
+		buf := make([]byte, 10)
+		if _, err := cryptorand.Read(buf); err != nil {
+			return nil, fmt.Errorf("generating recovery code: %v", err)
+		}
+		s := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+		codes[i] = s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16]
+		hashes[i] = totpRecoveryHash(codes[i])
+	}
+	t.Enabled = true
+	t.LastCounter = counter
+	t.RecoveryCodes = hashes
+	return codes, nil
+}
+
+// Verify checks a code for an enabled TOTP, either a code from the authenticator
+// app or an unused recovery code. On success, the last accepted time step is
+// updated or the recovery code removed, and t must be saved.
+func (t *TOTP) Verify(code string, now time.Time) bool {
+	if !t.Enabled {
+		return false
+	}
+	if counter, ok := totp.Verify(t.Secret, code, now, t.LastCounter); ok {
+		t.LastCounter = counter
+		return true
+	}
+	h := totpRecoveryHash(code)
+	for i, rh := range t.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(rh)) == 1 {
+			t.RecoveryCodes = append(t.RecoveryCodes[:i:i], t.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// TOTPGet returns the two-factor authentication state. If never enrolled, a zero
+// value with ID 1 is returned.
+func (a *Account) TOTPGet(ctx context.Context) (TOTP, error) {
+	t := TOTP{ID: 1}
+	err := a.DB.Get(ctx, &t)
+	if err == bstore.ErrAbsent {
+		err = nil
+	}
+	return t, err
+}
+
+// TOTPEnrollStart starts enrolment for two-factor authentication, storing a new
+// secret that is enabled with TOTPEnrollFinish.
+func (a *Account) TOTPEnrollStart(ctx context.Context, log mlog.Log) (TOTP, error) {
+	t, err := NewTOTP()
+	if err != nil {
+		return TOTP{}, err
+	}
+	err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		cur := TOTP{ID: 1}
+		if err := tx.Get(&cur); err == bstore.ErrAbsent {
+			return tx.Insert(&t)
+		} else if err != nil {
+			return fmt.Errorf("looking up two-factor authentication: %v", err)
+		} else if cur.Enabled {
+			return fmt.Errorf("%w: already enabled", ErrTOTP)
+		}
+		return tx.Update(&t)
+	})
+	if err != nil {
+		return TOTP{}, err
+	}
+	log.Info("two-factor authentication enrolment started", slog.String("account", a.Name))
+	return t, nil
+}
+
+// TOTPEnrollFinish verifies a code for the secret from TOTPEnrollStart and
+// enables two-factor authentication, returning new recovery codes. Existing
+// sessions, created without second factor, are removed.
+func (a *Account) TOTPEnrollFinish(ctx context.Context, log mlog.Log, code string) ([]string, error) {
+	var codes []string
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		t := TOTP{ID: 1}
+		if err := tx.Get(&t); err == bstore.ErrAbsent {
+			return fmt.Errorf("%w: no enrolment in progress", ErrTOTP)
+		} else if err != nil {
+			return fmt.Errorf("looking up two-factor authentication: %v", err)
+		}
+		var err error
+		codes, err = t.Enable(code, time.Now())
+		if err != nil {
+			return err
+		}
+		if err := tx.Update(&t); err != nil {
+			return fmt.Errorf("updating two-factor authentication: %v", err)
+		}
+		return sessionRemoveAll(ctx, log, tx, a.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Info("two-factor authentication enabled", slog.String("account", a.Name))
+	return codes, nil
+}
+
+// TOTPDisable disables two-factor authentication, or aborts an enrolment. If
+// two-factor authentication is enabled, code must be a current code from the
+// authenticator app, an unused recovery code, or the account password, so a
+// stolen session cannot be used to remove the second factor. An enrolment in
+// progress can be aborted without code.
+func (a *Account) TOTPDisable(ctx context.Context, log mlog.Log, code string) error {
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		t := TOTP{ID: 1}
+		if err := tx.Get(&t); err == bstore.ErrAbsent {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("looking up two-factor authentication: %v", err)
+		}
+		if t.Enabled && !t.Verify(code, time.Now()) {
+			pw, err := bstore.QueryTx[Password](tx).Get()
+			if err != nil && err != bstore.ErrAbsent {
+				return fmt.Errorf("looking up password: %v", err)
+			} else if err == bstore.ErrAbsent || bcrypt.CompareHashAndPassword([]byte(pw.Hash), []byte(code)) != nil {
+				return fmt.Errorf("%w: invalid code or password", ErrTOTP)
+			}
+		}
+		return tx.Delete(&t)
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("two-factor authentication disabled", slog.String("account", a.Name))
+	return nil
+}
+
+// TOTPReset disables two-factor authentication without requiring a code, for
+// administrators to restore access to an account that lost its second factor.
+func (a *Account) TOTPReset(ctx context.Context, log mlog.Log) error {
+	_, err := bstore.QueryDB[TOTP](ctx, a.DB).Delete()
+	if err != nil {
+		return err
+	}
+	log.Info("two-factor authentication reset", slog.String("account", a.Name))
+	return nil
+}
+
+// TOTPVerify checks a code for two-factor authentication, see TOTP.Verify.
+// Enabled is false if two-factor authentication is not enabled for the account.
+func (a *Account) TOTPVerify(ctx context.Context, log mlog.Log, code string) (enabled, ok bool, rerr error) {
+	rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		t := TOTP{ID: 1}
+		if err := tx.Get(&t); err == bstore.ErrAbsent {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		enabled = t.Enabled
+		n := len(t.RecoveryCodes)
+		ok = t.Verify(code, time.Now())
+		if !ok {
+			return nil
+		}
+		if len(t.RecoveryCodes) < n {
+			log.Info("recovery code used for two-factor authentication", slog.String("account", a.Name), slog.Int("remaining", len(t.RecoveryCodes)))
+		}
+		return tx.Update(&t)
+	})
+	return
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/totp"
+)
+
+func TestTOTP(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+	}()
+	defer Switchboard()()
+
+	verify := func(code string, expEnabled, expOK bool) {
+		t.Helper()
+		enabled, ok, err := acc.TOTPVerify(ctxbg, log, code)
+		tcheck(t, err, "verify")
+		if enabled != expEnabled || ok != expOK {
+			t.Fatalf("verify %q: got enabled %v, ok %v, expected %v, %v", code, enabled, ok, expEnabled, expOK)
+		}
+	}
+
+	// Not enrolled.
+	verify("123456", false, false)
+	_, err = acc.TOTPEnrollFinish(ctxbg, log, "123456")
+	if !errors.Is(err, ErrTOTP) {
+		t.Fatalf("got err %v, expected ErrTOTP without enrolment", err)
+	}
+
+	tt, err := acc.TOTPEnrollStart(ctxbg, log)
+	tcheck(t, err, "enroll start")
+	verify("123456", false, false)
+
+	now := time.Now()
+	counter := totp.Counter(now)
+	badCode := totp.Code(tt.Secret, counter+10)
+	_, err = acc.TOTPEnrollFinish(ctxbg, log, badCode)
+	if !errors.Is(err, ErrTOTP) {
+		t.Fatalf("got err %v, expected ErrTOTP for bad code", err)
+	}
+
+	// Codes from the time step before, so the code for the current time step can
+	// still be verified below.
+	codes, err := acc.TOTPEnrollFinish(ctxbg, log, totp.Code(tt.Secret, counter-1))
+	tcheck(t, err, "enroll finish")
+	if len(codes) != totpRecoveryCodes {
+		t.Fatalf("got %d recovery codes, expected %d", len(codes), totpRecoveryCodes)
+	}
+
+	_, err = acc.TOTPEnrollStart(ctxbg, log)
+	if !errors.Is(err, ErrTOTP) {
+		t.Fatalf("got err %v, expected ErrTOTP for enroll start while enabled", err)
+	}
+
+	verify(badCode, true, false)
+	verify(totp.Code(tt.Secret, counter-1), true, false) // Already used.
+	verify(totp.Code(tt.Secret, counter), true, true)
+	verify(totp.Code(tt.Secret, counter), true, false) // Replay.
+
+	// Recovery codes can be used once, case and spaces are ignored.
+	verify(codes[0], true, true)
+	verify(codes[0], true, false)
+	verify(" "+codes[1][:5]+" "+codes[1][5:]+" ", true, true)
+	xt, err := acc.TOTPGet(ctxbg)
+	tcheck(t, err, "get totp")
+	if !xt.Enabled || len(xt.RecoveryCodes) != totpRecoveryCodes-2 {
+		t.Fatalf("unexpected totp state, enabled %v, %d recovery codes", xt.Enabled, len(xt.RecoveryCodes))
+	}
+
+	// Disabling requires a code, recovery code or the password.
+	err = acc.TOTPDisable(ctxbg, log, "")
+	if !errors.Is(err, ErrTOTP) {
+		t.Fatalf("got err %v, expected ErrTOTP for disable without code", err)
+	}
+	err = acc.TOTPDisable(ctxbg, log, badCode)
+	if !errors.Is(err, ErrTOTP) {
+		t.Fatalf("got err %v, expected ErrTOTP for disable with bad code", err)
+	}
+	err = acc.TOTPDisable(ctxbg, log, codes[2])
+	tcheck(t, err, "disable")
+	verify(codes[3], false, false)
+	xt, err = acc.TOTPGet(ctxbg)
+	tcheck(t, err, "get totp")
+	if xt.Enabled || len(xt.Secret) != 0 {
+		t.Fatalf("unexpected totp state after disable %v", xt)
+	}
+
+	enable := func() {
+		t.Helper()
+		tt, err := acc.TOTPEnrollStart(ctxbg, log)
+		tcheck(t, err, "enroll start")
+		_, err = acc.TOTPEnrollFinish(ctxbg, log, totp.Code(tt.Secret, totp.Counter(time.Now())))
+		tcheck(t, err, "enroll finish")
+	}
+
+	enable()
+	err = acc.SetPassword(log, "test1234")
+	tcheck(t, err, "set password")
+	err = acc.TOTPDisable(ctxbg, log, "bad1234")
+	if !errors.Is(err, ErrTOTP) {
+		t.Fatalf("got err %v, expected ErrTOTP for disable with bad password", err)
+	}
+	err = acc.TOTPDisable(ctxbg, log, "test1234")
+	tcheck(t, err, "disable with password")
+
+	// Administrators can reset without code.
+	enable()
+	err = acc.TOTPReset(ctxbg, log)
+	tcheck(t, err, "reset")
+	xt, err = acc.TOTPGet(ctxbg)
+	tcheck(t, err, "get totp")
+	if xt.Enabled || len(xt.Secret) != 0 {
+		t.Fatalf("unexpected totp state after reset %v", xt)
+	}
+}
@@ -0,0 +1,493 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/exp/slog"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+)
+
+// The word index is an inverted index of the words in messages, for full-text
+// search without reading all message files. Words are the lower-cased runs of
+// letters and digits in the headers and text parts (including HTML parts, as
+// text) of a message, and in the text extracted from its attachments (see
+// AttachmentText), the same content that WordSearch searches in.
+//
+// Searches are substring searches. For each search word, the index gives the
+// messages that have indexed words containing the letter/digit runs of the
+// search word. Those messages are candidates, and must still be checked with
+// WordSearch, which is exact. Messages not in the candidate set cannot match.
+// Words containing a search word are found with a prefix lookup in the indexed
+// suffixes of all words (see WordSuffix).
+//
+// Long words are indexed as overlapping windows of wordMax runes, every wordMax/2
+// runes. Search words are looked up by their first wordMax/2 runes, so they are
+// always found in one of the windows.
+const (
+	wordMin = 3  // Shorter search words match too many indexed words to be of use.
+	wordMax = 40 // In runes.
+)
+
+// WordTerm is a word in the word index.
+type WordTerm struct {
+	ID   int64
+	Word string `bstore:"nonzero,unique"` // Lower-case.
+}
+
+// WordSuffix is a suffix of at least wordMin runes of a word in the word index. A
+// word contains a search word if one of its suffixes starts with the search word,
+// which can be looked up with a range scan on the index.
+type WordSuffix struct {
+	ID     int64
+	Suffix string `bstore:"nonzero,index"`
+	TermID int64  `bstore:"nonzero"`
+}
+
+// WordPosting records that a message contains a word.
+type WordPosting struct {
+	ID        int64
+	TermID    int64 `bstore:"nonzero,index TermID+MessageID"`
+	MessageID int64 `bstore:"nonzero,ref Message"` // Ref gives it its own index, for fast removal.
+}
+
+// wordTokens calls fn for each word in r, lower-cased. Words are runs of letters
+// and digits. Long words are returned as overlapping windows.
+func wordTokens(r io.Reader, fn func(w string) error) error {
+	br := bufio.NewReader(r)
+	var word []rune
+	flush := func() error {
+		defer func() {
+			word = word[:0]
+		}()
+		if len(word) <= wordMax {
+			if len(word) == 0 {
+				return nil
+			}
+			return fn(string(word))
+		}
+		for i := 0; ; i += wordMax / 2 {
+			end := i + wordMax
+			if end > len(word) {
+				end = len(word)
+			}
+			if err := fn(string(word[i:end])); err != nil {
+				return err
+			}
+			if end == len(word) {
+				return nil
+			}
+		}
+	}
+	for {
+		c, _, err := br.ReadRune()
+		if err == io.EOF {
+			return flush()
+		} else if err != nil {
+			return err
+		}
+		c = unicode.ToLower(c)
+		if unicode.IsLetter(c) || unicode.IsDigit(c) || unicode.IsMark(c) {
+			word = append(word, c)
+		} else if err := flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// partWords gathers the words of the headers and text parts of p, recursively,
+// like WordSearch.MatchPart with headers.
+func partWords(p *message.Part, words map[string]struct{}) error {
+	add := func(w string) error {
+		words[w] = struct{}{}
+		return nil
+	}
+	if err := wordTokens(p.HeaderReader(), add); err != nil {
+		return err
+	}
+	if len(p.Parts) == 0 && p.MediaType == "TEXT" {
+		if err := wordTokens(p.ReaderUTF8OrBinary(), add); err != nil {
+			return err
+		}
+	}
+	for _, pp := range p.Parts {
+		if pp.Message != nil {
+			if err := pp.SetMessageReaderAt(); err != nil {
+				return err
+			}
+			pp = *pp.Message
+		}
+		if err := partWords(&pp, words); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wordIndexAdd adds the words of message m with parsed part p, which must have a
+// reader set, to the word index. Text is extracted from attachments, and stored as
+// AttachmentText.
+func wordIndexAdd(log mlog.Log, tx *bstore.Tx, m *Message, p *message.Part) error {
+	words := map[string]struct{}{}
+	if err := partWords(p, words); err != nil {
+		return fmt.Errorf("reading words: %w", err)
+	}
+	text, err := attachmentTextAdd(log, tx, m, p)
+	if err != nil {
+		return err
+	}
+	err = wordTokens(strings.NewReader(text), func(w string) error {
+		words[w] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("reading words of attachment text: %w", err)
+	}
+	for w := range words {
+		t, err := bstore.QueryTx[WordTerm](tx).FilterNonzero(WordTerm{Word: w}).Get()
+		if err == bstore.ErrAbsent {
+			t = WordTerm{Word: w}
+			err = tx.Insert(&t)
+			if err == nil {
+				err = wordSuffixesAdd(tx, t)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("get or insert word: %w", err)
+		}
+		if err := tx.Insert(&WordPosting{TermID: t.ID, MessageID: m.ID}); err != nil {
+			return fmt.Errorf("inserting word posting: %w", err)
+		}
+	}
+	return nil
+}
+
+// wordSuffixesAdd adds the suffixes of new word t to the index.
+func wordSuffixesAdd(tx *bstore.Tx, t WordTerm) error {
+	r := []rune(t.Word)
+	for i := 0; i+wordMin <= len(r); i++ {
+		if err := tx.Insert(&WordSuffix{Suffix: string(r[i:]), TermID: t.ID}); err != nil {
+			return fmt.Errorf("inserting word suffix: %w", err)
+		}
+	}
+	return nil
+}
+
+// wordIndexAddMessage adds message m to the word index, reading the message file.
+func (a *Account) wordIndexAddMessage(log mlog.Log, tx *bstore.Tx, m Message) error {
+	var p message.Part
+	if m.ParsedBuf == nil {
+		return fmt.Errorf("message %d not parsed", m.ID)
+	} else if err := json.Unmarshal(m.ParsedBuf, &p); err != nil {
+		return fmt.Errorf("load part for message %d: %w", m.ID, err)
+	}
+	mr := a.MessageReader(m)
+	defer mr.Close()
+	p.SetReaderAt(mr)
+	return wordIndexAdd(log, tx, &m, &p)
+}
+
+// WordIndexRemove removes the messages from the word index, and removes their
+// attachment text. Called when messages are expunged. Words are not removed from
+// the index, only by WordIndexRebuild.
+func (a *Account) WordIndexRemove(tx *bstore.Tx, messageIDs ...int64) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	ids := make([]any, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = id
+	}
+	q := bstore.QueryTx[WordPosting](tx)
+	q.FilterEqual("MessageID", ids...)
+	if _, err := q.Delete(); err != nil {
+		return fmt.Errorf("removing messages from word index: %w", err)
+	}
+	qat := bstore.QueryTx[AttachmentText](tx)
+	qat.FilterIDs(messageIDs)
+	if _, err := qat.Delete(); err != nil {
+		return fmt.Errorf("removing attachment text: %w", err)
+	}
+	return nil
+}
+
+// WordIndexCopy adds the words of message origID to the index for message newID,
+// and copies its attachment text, for a copy of a message.
+func (a *Account) WordIndexCopy(tx *bstore.Tx, origID, newID int64) error {
+	if !a.StoreMessageText() {
+		return nil
+	}
+
+	at := AttachmentText{ID: origID}
+	if err := tx.Get(&at); err == nil {
+		at.ID = newID
+		if err := tx.Insert(&at); err != nil {
+			return fmt.Errorf("inserting attachment text: %w", err)
+		}
+	} else if err != bstore.ErrAbsent {
+		return fmt.Errorf("get attachment text: %w", err)
+	}
+
+	q := bstore.QueryTx[WordPosting](tx)
+	q.FilterNonzero(WordPosting{MessageID: origID})
+	l, err := q.List()
+	if err != nil {
+		return fmt.Errorf("listing word postings: %w", err)
+	}
+	for _, wp := range l {
+		wp.ID = 0
+		wp.MessageID = newID
+		if err := tx.Insert(&wp); err != nil {
+			return fmt.Errorf("inserting word posting: %w", err)
+		}
+	}
+	return nil
+}
+
+// WordIndexMatch returns the IDs of messages that may contain all words, which
+// must be lower-case. If ok is false, the index cannot be used: it is not
+// complete, or none of the words is long enough to be selective. Callers must
+// check the returned messages, e.g. with WordSearch.
+func (a *Account) WordIndexMatch(tx *bstore.Tx, words []string) (ids map[int64]struct{}, ok bool, rerr error) {
+	up := Upgrade{ID: 1}
+	if err := tx.Get(&up); err != nil {
+		return nil, false, fmt.Errorf("get upgrade state: %w", err)
+	} else if !up.WordIndex || !a.StoreMessageText() {
+		return nil, false, nil
+	}
+
+	// Gather the tokens, each must be a substring of an indexed word.
+	var tokens []string
+	for _, w := range words {
+		err := wordTokens(strings.NewReader(w), func(t string) error {
+			if r := []rune(t); len(r) > wordMax/2 {
+				t = string(r[:wordMax/2])
+			} else if len(r) < wordMin {
+				return nil
+			}
+			tokens = append(tokens, t)
+			return nil
+		})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, false, nil
+	}
+
+	// Find the matching words for each token, through the suffixes starting with the
+	// token. No UTF-8 string has 0xff bytes, so it ends the range.
+	termIDs := make([]map[int64]struct{}, len(tokens))
+	for i, tok := range tokens {
+		termIDs[i] = map[int64]struct{}{}
+		q := bstore.QueryTx[WordSuffix](tx)
+		q.FilterGreaterEqual("Suffix", tok)
+		q.FilterLess("Suffix", tok+"\xff")
+		err := q.ForEach(func(ws WordSuffix) error {
+			termIDs[i][ws.TermID] = struct{}{}
+			return nil
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("looking up words: %w", err)
+		}
+	}
+
+	// Intersect the messages of all tokens.
+	for i, l := range termIDs {
+		tokenIDs := map[int64]struct{}{}
+		for termID := range l {
+			q := bstore.QueryTx[WordPosting](tx)
+			q.FilterNonzero(WordPosting{TermID: termID})
+			err := q.ForEach(func(wp WordPosting) error {
+				if _, have := ids[wp.MessageID]; i == 0 || have {
+					tokenIDs[wp.MessageID] = struct{}{}
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, false, fmt.Errorf("looking up word postings: %w", err)
+			}
+		}
+		ids = tokenIDs
+		if len(ids) == 0 {
+			break
+		}
+	}
+	return ids, true, nil
+}
+
+// wordIndexClear removes all words, postings and attachment texts, and marks the
+// word index as incomplete.
+func wordIndexClear(tx *bstore.Tx) error {
+	up := Upgrade{ID: 1}
+	if err := tx.Get(&up); err != nil {
+		return fmt.Errorf("get upgrade state: %w", err)
+	}
+	up.WordIndex = false
+	if err := tx.Update(&up); err != nil {
+		return fmt.Errorf("updating upgrade state: %w", err)
+	}
+	if _, err := bstore.QueryTx[WordPosting](tx).Delete(); err != nil {
+		return fmt.Errorf("removing word postings: %w", err)
+	}
+	if _, err := bstore.QueryTx[WordSuffix](tx).Delete(); err != nil {
+		return fmt.Errorf("removing word suffixes: %w", err)
+	}
+	if _, err := bstore.QueryTx[WordTerm](tx).Delete(); err != nil {
+		return fmt.Errorf("removing words: %w", err)
+	}
+	if _, err := bstore.QueryTx[AttachmentText](tx).Delete(); err != nil {
+		return fmt.Errorf("removing attachment texts: %w", err)
+	}
+	return nil
+}
+
+// WordIndexRebuild clears the word index and adds all messages to it again,
+// in batches of batchSize messages, extracting the text from attachments again.
+// Searches use the index again once it is complete. Progress is written to progressWriter. The number of indexed messages
+// is returned. For accounts with EncryptMessages, the index is only cleared.
+//
+// Must not be called with the account lock held, the account write lock is held
+// during each batch.
+func (a *Account) WordIndexRebuild(ctx context.Context, log mlog.Log, batchSize int, progressWriter io.Writer) (int, error) {
+	// Clear the index. Messages added after this are indexed during delivery, and
+	// have an ID higher than lastID. Copies made during the rebuild may get words of
+	// messages not yet indexed, we index those again at the end.
+	var lastID int64
+	var err error
+	a.WithWLock(func() {
+		err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+			if err := wordIndexClear(tx); err != nil {
+				return err
+			}
+			m, err := bstore.QueryTx[Message](tx).SortDesc("ID").Limit(1).Get()
+			if err == bstore.ErrAbsent {
+				return nil
+			}
+			lastID = m.ID
+			return err
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("clearing word index: %w", err)
+	}
+	if !a.StoreMessageText() {
+		// No word index for accounts with encrypted messages.
+		return 0, nil
+	}
+
+	var total int
+	indexBatch := func(tx *bstore.Tx, q *bstore.Query[Message], removeFirst bool) (n int, lastBatchID int64, rerr error) {
+		err := q.ForEach(func(m Message) error {
+			if removeFirst {
+				if err := a.WordIndexRemove(tx, m.ID); err != nil {
+					return err
+				}
+			}
+			if err := a.wordIndexAddMessage(log, tx, m); err != nil {
+				log.Errorx("adding message to word index, continuing", err, slog.Int64("msgid", m.ID))
+			}
+			n++
+			lastBatchID = m.ID
+			return nil
+		})
+		return n, lastBatchID, err
+	}
+
+	var nextID int64
+	for nextID < lastID {
+		var n int
+		a.WithWLock(func() {
+			err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+				q := bstore.QueryTx[Message](tx)
+				q.FilterEqual("Expunged", false)
+				q.FilterGreater("ID", nextID)
+				q.FilterLessEqual("ID", lastID)
+				q.SortAsc("ID")
+				q.Limit(batchSize)
+				var batchID int64
+				var err error
+				n, batchID, err = indexBatch(tx, q, false)
+				if n < batchSize {
+					nextID = lastID
+				} else {
+					nextID = batchID
+				}
+				return err
+			})
+		})
+		if err != nil {
+			return total, fmt.Errorf("adding messages to word index: %w", err)
+		}
+		total += n
+		if _, err := fmt.Fprintf(progressWriter, "%d message(s) added to word index...\n", total); err != nil {
+			return total, fmt.Errorf("writing progress: %w", err)
+		}
+	}
+
+	// Index messages added during the rebuild again, and mark the index as complete.
+	a.WithWLock(func() {
+		err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+			q := bstore.QueryTx[Message](tx)
+			q.FilterEqual("Expunged", false)
+			q.FilterGreater("ID", lastID)
+			n, _, err := indexBatch(tx, q, true)
+			if err != nil {
+				return err
+			}
+			total += n
+
+			up := Upgrade{ID: 1}
+			if err := tx.Get(&up); err != nil {
+				return fmt.Errorf("get upgrade state: %w", err)
+			}
+			up.WordIndex = true
+			return tx.Update(&up)
+		})
+	})
+	if err != nil {
+		return total, fmt.Errorf("completing word index: %w", err)
+	}
+	return total, nil
+}
+
+// upgradeWordIndex builds the word index for an account that doesn't have one,
+// e.g. after upgrading from a version without word index. Run in the background
+// after opening the account. Searches don't use the index until it is complete.
+func upgradeWordIndex(ctx context.Context, log mlog.Log, acc *Account) {
+	defer func() {
+		x := recover() // Should not happen, but don't take program down if it does.
+		if x != nil {
+			log.Error("upgradeWordIndex panic", slog.Any("err", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Upgradewordindex)
+		}
+	}()
+
+	if !acc.StoreMessageText() {
+		log.Info("removing word index for account with encrypted messages, in background", slog.String("account", acc.Name))
+	} else {
+		log.Info("building word index for account, in background", slog.String("account", acc.Name))
+	}
+	t0 := time.Now()
+	const batchSize = 1000
+	total, err := acc.WordIndexRebuild(ctx, log, batchSize, io.Discard)
+	if err != nil {
+		log.Errorx("building word index for account, aborted", err, slog.String("account", acc.Name))
+	} else {
+		log.Info("building word index for account, completed", slog.String("account", acc.Name), slog.Int("messages", total), slog.Duration("duration", time.Since(t0)))
+	}
+}
@@ -0,0 +1,282 @@
+package store
+
+import (
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestWordTokens(t *testing.T) {
+	test := func(s string, exp []string) {
+		t.Helper()
+		var l []string
+		err := wordTokens(strings.NewReader(s), func(w string) error {
+			l = append(l, w)
+			return nil
+		})
+		tcheck(t, err, "tokens")
+		if !reflect.DeepEqual(l, exp) {
+			t.Fatalf("tokens for %q: got %q, expected %q", s, l, exp)
+		}
+	}
+
+	test("", nil)
+	test("Hello, World!", []string{"hello", "world"})
+	test("mjl@mox.example 2024", []string{"mjl", "mox", "example", "2024"})
+	test("Ünïcode", []string{"ünïcode"})
+	long := strings.Repeat("a", 20) + strings.Repeat("b", 20) + strings.Repeat("c", 10)
+	test(long, []string{long[:40], long[20:]})
+}
+
+func TestWordIndex(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+	}()
+	defer Switchboard()()
+
+	deliver := func(tx *bstore.Tx, msg string) Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "wordindex-test")
+		tcheck(t, err, "create temp file")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+
+		mb, err := bstore.QueryTx[Mailbox](tx).FilterNonzero(Mailbox{Name: "Inbox"}).Get()
+		tcheck(t, err, "get inbox")
+		m := Message{
+			MailboxID:     mb.ID,
+			MailboxOrigID: mb.ID,
+			Received:      time.Now(),
+			Size:          int64(len(msg)),
+		}
+		// Attachment text is extracted before delivery here, and during delivery for the
+		// rebuild below.
+		err = acc.PrepareAttachmentText(log, &m, msgFile)
+		tcheck(t, err, "prepare attachment text")
+		err = acc.DeliverMessage(log, tx, &m, msgFile, false, true, false, true)
+		tcheck(t, err, "deliver message")
+		err = tx.Get(&mb)
+		tcheck(t, err, "get inbox")
+		mb.Add(m.MailboxCounts())
+		err = tx.Update(&mb)
+		tcheck(t, err, "update inbox")
+		return m
+	}
+
+	attachmentMsg := strings.ReplaceAll(`Subject: report
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=x
+
+--x
+Content-Type: text/plain
+
+see attached
+--x
+Content-Type: application/octet-stream; name="notes.txt"
+Content-Transfer-Encoding: base64
+
+`+base64.StdEncoding.EncodeToString([]byte("Quarterly figures"))+`
+--x--
+`, "\n", "\r\n")
+
+	var m0, m1, m2, m3 Message
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		m0 = deliver(tx, "Subject: greetings\r\nContent-Type: text/plain\r\n\r\nhello world\r\n")
+		m1 = deliver(tx, "Subject: other\r\nContent-Type: text/html\r\n\r\n<p>Hello there</p>\r\n")
+		m2 = deliver(tx, "Subject: attachment\r\nContent-Type: application/octet-stream\r\n\r\nhello\r\n")
+		m3 = deliver(tx, attachmentMsg)
+		return nil
+	})
+	tcheck(t, err, "deliver messages")
+
+	test := func(words []string, expOK bool, expIDs ...int64) {
+		t.Helper()
+		err := acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+			ids, ok, err := acc.WordIndexMatch(tx, words)
+			tcheck(t, err, "match")
+			if ok != expOK {
+				t.Fatalf("match %q: got ok %v, expected %v", words, ok, expOK)
+			}
+			exp := map[int64]struct{}{}
+			for _, id := range expIDs {
+				exp[id] = struct{}{}
+			}
+			if ok && !reflect.DeepEqual(ids, exp) {
+				t.Fatalf("match %q: got %v, expected %v", words, ids, exp)
+			}
+			return nil
+		})
+		tcheck(t, err, "read")
+	}
+
+	test([]string{"hello"}, true, m0.ID, m1.ID)                 // Not in non-text part of m2.
+	test([]string{"ell"}, true, m0.ID, m1.ID)                   // Substring.
+	test([]string{"hello world"}, true, m0.ID)                  // Multiple tokens.
+	test([]string{"hello", "greetings"}, true, m0.ID)           // All words.
+	test([]string{"there", "greetings"}, true)                  // None.
+	test([]string{"subject"}, true, m0.ID, m1.ID, m2.ID, m3.ID) // Headers.
+	test([]string{"p"}, false)                                  // Too short.
+	test([]string{"quarterly figures"}, true, m3.ID)            // Text extracted from attachment.
+
+	// Copies get the same words, expunged messages are removed.
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		if err := acc.WordIndexCopy(tx, m0.ID, m2.ID); err != nil {
+			return err
+		}
+		if err := acc.WordIndexCopy(tx, m3.ID, m1.ID); err != nil {
+			return err
+		}
+		return acc.WordIndexRemove(tx, m1.ID)
+	})
+	tcheck(t, err, "copy and remove")
+	test([]string{"hello"}, true, m0.ID, m2.ID)
+	test([]string{"quarterly"}, true, m3.ID)
+	err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+		n, err := bstore.QueryTx[AttachmentText](tx).Count()
+		tcheck(t, err, "count attachment texts")
+		if n != 1 {
+			t.Fatalf("got %d attachment texts, expected 1", n)
+		}
+		return nil
+	})
+	tcheck(t, err, "read")
+
+	// Incomplete index isn't used.
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		return tx.Update(&Upgrade{ID: 1, Threads: 2})
+	})
+	tcheck(t, err, "mark index incomplete")
+	test([]string{"hello"}, false)
+
+	// Rebuild, restoring the index to the message contents.
+	n, err := acc.WordIndexRebuild(ctxbg, log, 2, io.Discard)
+	tcheck(t, err, "rebuild")
+	if n != 4 {
+		t.Fatalf("rebuild indexed %d messages, expected 4", n)
+	}
+	test([]string{"hello"}, true, m0.ID, m1.ID)
+	test([]string{"quarterly"}, true, m3.ID)
+
+	// Searching with the index gives the same results.
+	err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+		for _, word := range []string{"hello", "figures"} {
+			for _, m := range []Message{m0, m1, m2, m3} {
+				ws := PrepareWordSearch([]string{word}, nil)
+				err := ws.UseWordIndex(tx, acc)
+				tcheck(t, err, "use word index")
+
+				mr := acc.MessageReader(m)
+				p, err := m.LoadPart(mr)
+				tcheck(t, err, "load part")
+				match, err := ws.MatchMessage(log, tx, m.ID, &p, true)
+				tcheck(t, err, "match message")
+				mr.Close()
+				if match && !ws.Candidate(m.ID) {
+					t.Fatalf("message %d matches but is not a candidate", m.ID)
+				}
+				if expMatch := word == "figures" && m.ID == m3.ID || word == "hello" && m.ID != m3.ID && m.ID != m2.ID; match != expMatch {
+					t.Fatalf("message %d, word %q: got match %v, expected %v", m.ID, word, match, expMatch)
+				}
+			}
+		}
+		return nil
+	})
+	tcheck(t, err, "search")
+}
+
+func TestWordIndexUpgrade(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer Switchboard()()
+
+	msg := "Subject: upgrade\r\nContent-Type: text/plain\r\n\r\nhello world\r\n"
+	msgFile, err := CreateMessageTemp(log, "wordindex-test")
+	tcheck(t, err, "create temp file")
+	defer os.Remove(msgFile.Name())
+	defer msgFile.Close()
+	_, err = msgFile.Write([]byte(msg))
+	tcheck(t, err, "write message")
+	m := Message{Received: time.Now(), Size: int64(len(msg))}
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+	})
+	tcheck(t, err, "deliver message")
+
+	// Remove the word index, like for an account from before word indexes.
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		if _, err := bstore.QueryTx[WordPosting](tx).Delete(); err != nil {
+			return err
+		}
+		if _, err := bstore.QueryTx[WordSuffix](tx).Delete(); err != nil {
+			return err
+		}
+		if _, err := bstore.QueryTx[WordTerm](tx).Delete(); err != nil {
+			return err
+		}
+		return tx.Update(&Upgrade{ID: 1, Threads: 2})
+	})
+	tcheck(t, err, "remove word index")
+	err = acc.Close()
+	tcheck(t, err, "close account")
+
+	// Opening the account builds the index in the background.
+	acc, err = OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	for i := 0; ; i++ {
+		var ok bool
+		err := acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+			var ids map[int64]struct{}
+			var err error
+			ids, ok, err = acc.WordIndexMatch(tx, []string{"world"})
+			if ok && len(ids) != 1 {
+				t.Fatalf("got %v, expected message %d", ids, m.ID)
+			}
+			return err
+		})
+		tcheck(t, err, "match")
+		if ok {
+			break
+		} else if i == 100 {
+			t.Fatalf("word index not built")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = acc.Close()
+	tcheck(t, err, "close account")
+
+	// Wait for the background build to release the account.
+	for i := 0; ; i++ {
+		openAccounts.Lock()
+		_, open := openAccounts.names["mjl"]
+		openAccounts.Unlock()
+		if !open {
+			break
+		} else if i == 100 {
+			t.Fatalf("account still open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
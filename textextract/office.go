@@ -0,0 +1,141 @@
+package textextract
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+)
+
+// Files with text in OpenDocument (odt, ods, odp) and Office Open XML (docx,
+// xlsx, pptx) zip files.
+var officeFiles = []string{
+	"content.xml", // OpenDocument.
+
+	"word/document.xml",
+	"word/header*.xml",
+	"word/footer*.xml",
+	"word/footnotes.xml",
+	"word/endnotes.xml",
+	"xl/sharedStrings.xml",
+	"xl/worksheets/sheet*.xml",
+	"ppt/slides/slide*.xml",
+	"ppt/notesSlides/notesSlide*.xml",
+}
+
+// Elements that end a paragraph or row, and that separate words. By local name,
+// the formats use different namespaces.
+var (
+	officeNewline = map[string]bool{"p": true, "h": true, "br": true, "line-break": true, "tr": true, "table-row": true, "row": true, "si": true}
+	officeSpace   = map[string]bool{"tab": true, "s": true, "tc": true, "table-cell": true, "c": true}
+)
+
+// maxOfficeFile is the maximum size of a decompressed XML file in a zip file.
+const maxOfficeFile = 64 * 1024 * 1024
+
+// Office returns the text of an OpenDocument or Office Open XML file, read from r
+// of size size, up to maxText bytes. Files in older binary formats, e.g. .doc,
+// are not supported.
+func Office(ctx context.Context, r io.ReaderAt, size int64, maxText int) (string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", fmt.Errorf("%w: opening zip file: %v", ErrUnsupported, err)
+	}
+
+	w := &textWriter{max: maxText}
+	var found bool
+	for _, f := range zr.File {
+		if !officeFile(f.Name) {
+			continue
+		}
+		found = true
+		if err := ctx.Err(); err != nil {
+			return w.text(), err
+		}
+		if f.UncompressedSize64 > maxOfficeFile {
+			return w.text(), fmt.Errorf("%w: file %s in zip file", ErrTooLarge, f.Name)
+		}
+		if err := officeXML(ctx, w, f); err == errTextLimit {
+			break
+		} else if err != nil {
+			return w.text(), fmt.Errorf("reading %s from zip file: %w", f.Name, err)
+		}
+		if err := w.writeRune('\n'); err == errTextLimit {
+			break
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("%w: no known document files in zip file", ErrUnsupported)
+	}
+	return w.text(), nil
+}
+
+func officeFile(name string) bool {
+	for _, pat := range officeFiles {
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// officeXML writes the character data of XML file f to w.
+func officeXML(ctx context.Context, w *textWriter, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	d := xml.NewDecoder(io.LimitReader(rc, maxOfficeFile))
+	d.Strict = false
+
+	// Cells in xlsx worksheets with type "s" have an index into the shared strings as
+	// value, which we skip. The shared strings are read separately.
+	var sharedStringCell bool
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "c" {
+				sharedStringCell = false
+				for _, a := range t.Attr {
+					if a.Name.Local == "t" && a.Value == "s" {
+						sharedStringCell = true
+					}
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "c" {
+				sharedStringCell = false
+			}
+			var err error
+			if officeNewline[t.Name.Local] {
+				err = w.writeRune('\n')
+			} else if officeSpace[t.Name.Local] {
+				err = w.writeRune(' ')
+			}
+			if err != nil {
+				return err
+			}
+		case xml.CharData:
+			if sharedStringCell {
+				continue
+			}
+			if err := w.writeString(string(t)); err != nil {
+				return err
+			}
+		}
+	}
+}
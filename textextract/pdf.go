@@ -0,0 +1,829 @@
+package textextract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// PDF objects, as parsed. Strings are []byte, numbers are float64.
+type (
+	pdfName    string
+	pdfKeyword string // Operators in content streams, and true/false/null.
+	pdfRef     int    // Object number of an indirect reference, the generation is ignored.
+	pdfDict    map[pdfName]any
+	pdfArray   []any
+	pdfStream  struct {
+		dict pdfDict
+		data []byte // Still encoded.
+	}
+)
+
+const (
+	maxPDFDepth   = 32                // Max nesting of objects, references, page tree and form xobjects.
+	maxPDFStream  = 64 * 1024 * 1024  // Max decoded size of a single stream.
+	maxPDFDecoded = 256 * 1024 * 1024 // Max decoded size of all streams in a document.
+)
+
+var pdfObjRegexp = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+
+// pdfDoc is a PDF file being extracted. Instead of reading the cross-reference
+// tables, which are often damaged, objects are found by scanning the file. Text is
+// read from the content streams of all pages, and the forms they reference.
+// Character codes are mapped to text through the ToUnicode maps of fonts, or
+// Differences in their Encoding, falling back to WinAnsiEncoding.
+//
+// Not supported: encryption, streams with filters other than FlateDecode and
+// ASCIIHexDecode, and fonts with non-standard encodings without a ToUnicode map.
+type pdfDoc struct {
+	ctx        context.Context
+	w          *textWriter
+	buf        []byte
+	offsets    map[int]int         // Object number to offset in buf, after "obj".
+	compressed map[int]pdfObjEntry // Objects in object streams.
+	objs       map[int]any         // Parsed objects.
+	fonts      map[pdfRef]*pdfFont
+	budget     int // Remaining bytes for decoding streams.
+}
+
+// pdfObjEntry is an object in a decoded object stream.
+type pdfObjEntry struct {
+	data   []byte
+	offset int
+}
+
+// PDF returns the text of PDF file r of size size, up to maxText bytes.
+func PDF(ctx context.Context, r io.ReaderAt, size int64, maxText int) (string, error) {
+	buf := make([]byte, size)
+	if n, err := r.ReadAt(buf, 0); n != len(buf) {
+		return "", fmt.Errorf("reading pdf file: %w", err)
+	}
+	head := buf
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if !bytes.Contains(head, []byte("%PDF-")) {
+		return "", fmt.Errorf("%w: not a pdf file", ErrUnsupported)
+	}
+	if bytes.Contains(buf, []byte("/Encrypt")) {
+		return "", fmt.Errorf("%w: encrypted pdf file", ErrUnsupported)
+	}
+
+	doc := &pdfDoc{
+		ctx:        ctx,
+		w:          &textWriter{max: maxText},
+		buf:        buf,
+		offsets:    map[int]int{},
+		compressed: map[int]pdfObjEntry{},
+		objs:       map[int]any{},
+		fonts:      map[pdfRef]*pdfFont{},
+		budget:     maxPDFDecoded,
+	}
+	// Later definitions, from incremental updates, replace earlier ones.
+	for _, l := range pdfObjRegexp.FindAllSubmatchIndex(buf, -1) {
+		if num, err := strconv.Atoi(string(buf[l[2]:l[3]])); err == nil {
+			doc.offsets[num] = l[1]
+		}
+	}
+	if err := doc.objectStreams(); err != nil {
+		return doc.w.text(), err
+	}
+
+	// We process pages in order of object number instead of walking the page tree.
+	// The order is often the same, and does not matter for searching.
+	var nums []int
+	for num := range doc.offsets {
+		nums = append(nums, num)
+	}
+	for num := range doc.compressed {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		if err := ctx.Err(); err != nil {
+			return doc.w.text(), err
+		}
+		d, ok := doc.object(num).(pdfDict)
+		if !ok || d["Type"] != pdfName("Page") {
+			continue
+		}
+		if err := doc.page(d); err == errTextLimit {
+			break
+		} else if err != nil {
+			return doc.w.text(), err
+		}
+	}
+	return doc.w.text(), nil
+}
+
+// objectStreams finds the objects stored in object streams.
+func (doc *pdfDoc) objectStreams() error {
+	for _, o := range doc.offsets {
+		end := o + 1024
+		if end > len(doc.buf) {
+			end = len(doc.buf)
+		}
+		if !bytes.Contains(doc.buf[o:end], []byte("/ObjStm")) {
+			continue
+		}
+		p := &pdfParser{buf: doc.buf, o: o}
+		v, err := p.object(0)
+		s, ok := v.(pdfStream)
+		if err != nil || !ok || s.dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		n, _ := doc.resolve(s.dict["N"]).(float64)
+		first, _ := doc.resolve(s.dict["First"]).(float64)
+		data, err := doc.decode(s)
+		if errors.Is(err, ErrTooLarge) {
+			return err
+		} else if err != nil {
+			continue
+		}
+		hp := &pdfParser{buf: data, content: true}
+		for i := 0; i < int(n); i++ {
+			num, err0 := hp.object(0)
+			offset, err1 := hp.object(0)
+			objnum, ok0 := num.(float64)
+			objoff, ok1 := offset.(float64)
+			if err0 != nil || err1 != nil || !ok0 || !ok1 {
+				break
+			}
+			if _, ok := doc.offsets[int(objnum)]; !ok && int(first)+int(objoff) < len(data) {
+				doc.compressed[int(objnum)] = pdfObjEntry{data, int(first) + int(objoff)}
+			}
+		}
+	}
+	return nil
+}
+
+// object returns the parsed object num, or nil if it doesn't exist or cannot be
+// parsed.
+func (doc *pdfDoc) object(num int) any {
+	if v, ok := doc.objs[num]; ok {
+		return v
+	}
+	doc.objs[num] = nil // Prevent loops.
+	var p *pdfParser
+	if o, ok := doc.offsets[num]; ok {
+		p = &pdfParser{buf: doc.buf, o: o}
+	} else if e, ok := doc.compressed[num]; ok {
+		p = &pdfParser{buf: e.data, o: e.offset}
+	} else {
+		return nil
+	}
+	v, err := p.object(0)
+	if err != nil {
+		return nil
+	}
+	doc.objs[num] = v
+	return v
+}
+
+// resolve follows references.
+func (doc *pdfDoc) resolve(v any) any {
+	for i := 0; i < maxPDFDepth; i++ {
+		r, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		v = doc.object(int(r))
+	}
+	return nil
+}
+
+// decode returns the decoded data of stream s.
+func (doc *pdfDoc) decode(s pdfStream) ([]byte, error) {
+	var filters []any
+	switch f := doc.resolve(s.dict["Filter"]).(type) {
+	case pdfName:
+		filters = []any{f}
+	case pdfArray:
+		filters = f
+	}
+	data := s.data
+	for _, f := range filters {
+		switch doc.resolve(f) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("decompressing stream: %v", err)
+			}
+			limit := maxPDFStream
+			if doc.budget < limit {
+				limit = doc.budget
+			}
+			buf, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+			if len(buf) > limit {
+				return nil, ErrTooLarge
+			} else if err != nil && len(buf) == 0 {
+				// Streams with errors at the end are common, we use the data we could read.
+				return nil, fmt.Errorf("decompressing stream: %v", err)
+			}
+			doc.budget -= len(buf)
+			data = buf
+		case pdfName("ASCIIHexDecode"), pdfName("AHx"):
+			p := &pdfParser{buf: append(append([]byte{'<'}, data...), '>')}
+			buf, err := p.hexString()
+			if err != nil {
+				return nil, err
+			}
+			data = buf
+		default:
+			return nil, fmt.Errorf("%w: stream filter %v", ErrUnsupported, f)
+		}
+	}
+	return data, nil
+}
+
+// page writes the text of a page.
+func (doc *pdfDoc) page(d pdfDict) error {
+	// Resources can be inherited from the page tree.
+	var res pdfDict
+	for i, pd := 0, d; i < maxPDFDepth && pd != nil; i++ {
+		if r, ok := doc.resolve(pd["Resources"]).(pdfDict); ok {
+			res = r
+			break
+		}
+		pd, _ = doc.resolve(pd["Parent"]).(pdfDict)
+	}
+
+	var streams []any
+	switch c := doc.resolve(d["Contents"]).(type) {
+	case pdfStream:
+		streams = []any{c}
+	case pdfArray:
+		streams = c
+	}
+	// A page's content can be split over streams at arbitrary tokens.
+	var data []byte
+	for _, v := range streams {
+		s, ok := doc.resolve(v).(pdfStream)
+		if !ok {
+			continue
+		}
+		buf, err := doc.decode(s)
+		if errors.Is(err, ErrTooLarge) {
+			return err
+		} else if err == nil {
+			data = append(append(data, buf...), '\n')
+		}
+	}
+	if err := doc.content(data, res, 0); err != nil {
+		return err
+	}
+	return doc.w.writeRune('\n')
+}
+
+// content writes the text shown by the operators in a content stream.
+func (doc *pdfDoc) content(data []byte, res pdfDict, depth int) error {
+	fonts, _ := doc.resolve(res["Font"]).(pdfDict)
+	xobjects, _ := doc.resolve(res["XObject"]).(pdfDict)
+	font := &pdfFont{}
+
+	show := func(v any) error {
+		if s, ok := v.([]byte); ok {
+			return doc.w.writeString(font.text(s))
+		}
+		return nil
+	}
+
+	p := &pdfParser{buf: data, content: true}
+	var operands []any
+	for i := 0; ; i++ {
+		if i%1024 == 0 {
+			if err := doc.ctx.Err(); err != nil {
+				return err
+			}
+		}
+		v, err := p.object(0)
+		if err != nil {
+			// End of stream, or invalid syntax, we keep the text so far.
+			return nil
+		}
+		op, ok := v.(pdfKeyword)
+		if !ok {
+			operands = append(operands, v)
+			continue
+		}
+		var last any
+		if len(operands) > 0 {
+			last = operands[len(operands)-1]
+		}
+		switch op {
+		case "Tf":
+			if len(operands) == 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					font = doc.font(fonts[name])
+				}
+			}
+		case "Tj":
+			err = show(last)
+		case "'", `"`:
+			if err = doc.w.writeRune('\n'); err == nil {
+				err = show(last)
+			}
+		case "TJ":
+			l, _ := last.(pdfArray)
+			for _, e := range l {
+				if n, ok := e.(float64); ok && n < -200 {
+					// Large adjustments, in thousandths of text space, are likely spaces.
+					err = doc.w.writeRune(' ')
+				} else {
+					err = show(e)
+				}
+				if err != nil {
+					break
+				}
+			}
+		case "T*", "ET":
+			err = doc.w.writeRune('\n')
+		case "Td", "TD":
+			if ty, ok := last.(float64); ok && ty != 0 {
+				err = doc.w.writeRune('\n')
+			} else {
+				err = doc.w.writeRune(' ')
+			}
+		case "Tm":
+			err = doc.w.writeRune(' ')
+		case "ID":
+			p.skipInlineImage()
+		case "Do":
+			name, _ := last.(pdfName)
+			s, ok := doc.resolve(xobjects[name]).(pdfStream)
+			if ok && depth < maxPDFDepth && s.dict["Subtype"] == pdfName("Form") {
+				fres, ok := doc.resolve(s.dict["Resources"]).(pdfDict)
+				if !ok {
+					fres = res
+				}
+				if buf, xerr := doc.decode(s); errors.Is(xerr, ErrTooLarge) {
+					err = xerr
+				} else if xerr == nil {
+					err = doc.content(buf, fres, depth+1)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		operands = operands[:0]
+	}
+}
+
+// pdfFont maps character codes in strings to text.
+type pdfFont struct {
+	twoByte bool              // Composite font, we assume 2-byte codes, as with Identity-H.
+	codes   map[uint32]string // From ToUnicode map, or Differences for simple fonts.
+}
+
+// font returns the font for the font resource v.
+func (doc *pdfDoc) font(v any) *pdfFont {
+	ref, isRef := v.(pdfRef)
+	if f, ok := doc.fonts[ref]; isRef && ok {
+		return f
+	}
+	f := &pdfFont{codes: map[uint32]string{}}
+	if isRef {
+		doc.fonts[ref] = f
+	}
+	d, _ := doc.resolve(v).(pdfDict)
+	if d == nil {
+		return f
+	}
+	f.twoByte = doc.resolve(d["Subtype"]) == pdfName("Type0")
+	if enc, ok := doc.resolve(d["Encoding"]).(pdfDict); ok && !f.twoByte {
+		diffs, _ := doc.resolve(enc["Differences"]).(pdfArray)
+		var code uint32
+		for _, e := range diffs {
+			switch e := e.(type) {
+			case float64:
+				code = uint32(e)
+			case pdfName:
+				if s := glyphText(string(e)); s != "" {
+					f.codes[code] = s
+				}
+				code++
+			}
+		}
+	}
+	if s, ok := doc.resolve(d["ToUnicode"]).(pdfStream); ok {
+		if data, err := doc.decode(s); err == nil {
+			parseCMap(data, f.codes)
+		}
+	}
+	return f
+}
+
+// text returns the text for a string shown with the font.
+func (f *pdfFont) text(s []byte) string {
+	var b strings.Builder
+	if f.twoByte {
+		for i := 0; i+1 < len(s); i += 2 {
+			b.WriteString(f.codes[uint32(s[i])<<8|uint32(s[i+1])])
+		}
+		return b.String()
+	}
+	for _, c := range s {
+		if t, ok := f.codes[uint32(c)]; ok {
+			b.WriteString(t)
+		} else {
+			b.WriteRune(charmap.Windows1252.DecodeByte(c))
+		}
+	}
+	return b.String()
+}
+
+// Glyph names that are not a single character, for simple fonts with Differences.
+var glyphNames = map[string]string{
+	"space": " ", "period": ".", "comma": ",", "colon": ":", "semicolon": ";",
+	"hyphen": "-", "quoteright": "’", "quoteleft": "‘", "quotedbl": `"`, "quotesingle": "'",
+	"parenleft": "(", "parenright": ")", "slash": "/", "at": "@", "ampersand": "&",
+	"fi": "fi", "fl": "fl", "ff": "ff", "ffi": "ffi", "ffl": "ffl",
+	"zero": "0", "one": "1", "two": "2", "three": "3", "four": "4",
+	"five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9",
+}
+
+// glyphText returns the text for a glyph name, or the empty string if unknown.
+func glyphText(name string) string {
+	if len(name) == 1 {
+		return name
+	} else if s, ok := glyphNames[name]; ok {
+		return s
+	} else if strings.HasPrefix(name, "uni") && len(name) == 7 {
+		if c, err := strconv.ParseUint(name[3:], 16, 16); err == nil {
+			return string(rune(c))
+		}
+	}
+	return ""
+}
+
+// parseCMap adds the mappings from the bfchar and bfrange sections of a ToUnicode
+// CMap to codes.
+func parseCMap(data []byte, codes map[uint32]string) {
+	code := func(v any) (uint32, bool) {
+		b, ok := v.([]byte)
+		if !ok || len(b) == 0 || len(b) > 4 {
+			return 0, false
+		}
+		var c uint32
+		for _, x := range b {
+			c = c<<8 | uint32(x)
+		}
+		return c, true
+	}
+
+	p := &pdfParser{buf: data, content: true}
+	var operands []any
+	for len(codes) < 1<<20 {
+		v, err := p.object(0)
+		if err != nil {
+			return
+		}
+		op, ok := v.(pdfKeyword)
+		if !ok {
+			operands = append(operands, v)
+			continue
+		}
+		switch op {
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok := code(operands[i])
+				dst, ok2 := operands[i+1].([]byte)
+				if ok && ok2 {
+					codes[src] = utf16BE(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok0 := code(operands[i])
+				hi, ok1 := code(operands[i+1])
+				if !ok0 || !ok1 || hi < lo || hi-lo > 0xffff {
+					continue
+				}
+				switch dst := operands[i+2].(type) {
+				case []byte:
+					// The last character is incremented for each code in the range.
+					r := []rune(utf16BE(dst))
+					if len(r) == 0 {
+						continue
+					}
+					for j := uint32(0); j <= hi-lo; j++ {
+						codes[lo+j] = string(r[:len(r)-1]) + string(r[len(r)-1]+rune(j))
+					}
+				case pdfArray:
+					for j, e := range dst {
+						if b, ok := e.([]byte); ok && uint32(j) <= hi-lo {
+							codes[lo+uint32(j)] = utf16BE(b)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+func utf16BE(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return string(utf16.Decode(u))
+}
+
+// pdfParser parses PDF objects, and content streams.
+type pdfParser struct {
+	buf     []byte
+	o       int
+	content bool // Content streams have no references or streams.
+}
+
+func isPDFSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelim(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func (p *pdfParser) skipSpace() {
+	for p.o < len(p.buf) {
+		c := p.buf[p.o]
+		if c == '%' {
+			for p.o < len(p.buf) && p.buf[p.o] != '\n' && p.buf[p.o] != '\r' {
+				p.o++
+			}
+		} else if isPDFSpace(c) {
+			p.o++
+		} else {
+			return
+		}
+	}
+}
+
+// token reads a name, number or keyword.
+func (p *pdfParser) token() string {
+	s := p.o
+	for p.o < len(p.buf) && !isPDFSpace(p.buf[p.o]) && !isPDFDelim(p.buf[p.o]) {
+		p.o++
+	}
+	return string(p.buf[s:p.o])
+}
+
+func (p *pdfParser) hasPrefix(s string) bool {
+	return bytes.HasPrefix(p.buf[p.o:], []byte(s))
+}
+
+func isPDFInt(s string) bool {
+	_, err := strconv.ParseInt(s, 10, 32)
+	return err == nil && s[0] != '+' && s[0] != '-'
+}
+
+// object parses the next object. At the end of the data, io.EOF is returned.
+func (p *pdfParser) object(depth int) (any, error) {
+	if depth > maxPDFDepth {
+		return nil, errors.New("objects nested too deeply")
+	}
+	p.skipSpace()
+	if p.o >= len(p.buf) {
+		return nil, io.EOF
+	}
+	switch c := p.buf[p.o]; {
+	case c == '/':
+		p.o++
+		name := p.token()
+		if strings.Contains(name, "#") {
+			// Escaped characters, e.g. #20 for a space.
+			var b []byte
+			for i := 0; i < len(name); i++ {
+				if name[i] == '#' && i+2 < len(name) {
+					if x, err := hex.DecodeString(name[i+1 : i+3]); err == nil {
+						b = append(b, x[0])
+						i += 2
+						continue
+					}
+				}
+				b = append(b, name[i])
+			}
+			name = string(b)
+		}
+		return pdfName(name), nil
+	case c == '(':
+		return p.literalString()
+	case p.hasPrefix("<<"):
+		p.o += 2
+		d := pdfDict{}
+		for {
+			p.skipSpace()
+			if p.hasPrefix(">>") {
+				p.o += 2
+				break
+			}
+			k, err := p.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := k.(pdfName)
+			if !ok {
+				return nil, errors.New("dictionary key not a name")
+			}
+			v, err := p.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			d[name] = v
+		}
+		if p.content {
+			return d, nil
+		}
+		return p.stream(d), nil
+	case c == '<':
+		return p.hexString()
+	case c == '[':
+		p.o++
+		l := pdfArray{}
+		for {
+			p.skipSpace()
+			if p.hasPrefix("]") {
+				p.o++
+				return l, nil
+			}
+			v, err := p.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+	case c == '{' || c == '}':
+		// In PostScript functions.
+		p.o++
+		return pdfKeyword([]byte{c}), nil
+	case isPDFDelim(c):
+		return nil, fmt.Errorf("unexpected %q", c)
+	}
+
+	tok := p.token()
+	if c := tok[0]; c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.' {
+		n, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", tok)
+		}
+		if !p.content && isPDFInt(tok) {
+			// Possibly a reference, "<num> <generation> R".
+			o := p.o
+			p.skipSpace()
+			if gen := p.token(); gen != "" && isPDFInt(gen) {
+				p.skipSpace()
+				if p.hasPrefix("R") && (p.o+1 == len(p.buf) || isPDFSpace(p.buf[p.o+1]) || isPDFDelim(p.buf[p.o+1])) {
+					p.o++
+					return pdfRef(n), nil
+				}
+			}
+			p.o = o
+		}
+		return n, nil
+	}
+	return pdfKeyword(tok), nil
+}
+
+// stream returns a stream if the dictionary d is followed by stream data, and d
+// otherwise.
+func (p *pdfParser) stream(d pdfDict) any {
+	o := p.o
+	p.skipSpace()
+	if !p.hasPrefix("stream") {
+		p.o = o
+		return d
+	}
+	p.o += len("stream")
+	if p.hasPrefix("\r\n") {
+		p.o += 2
+	} else if p.hasPrefix("\n") || p.hasPrefix("\r") {
+		p.o++
+	}
+	start := p.o
+
+	// The length can be an indirect object, and is sometimes wrong. We look for
+	// "endstream" if the length doesn't fit.
+	if n, ok := d["Length"].(float64); ok && n >= 0 && start+int(n) <= len(p.buf) {
+		p.o = start + int(n)
+		p.skipSpace()
+		if p.hasPrefix("endstream") {
+			p.o += len("endstream")
+			return pdfStream{d, p.buf[start : start+int(n)]}
+		}
+	}
+	end := bytes.Index(p.buf[start:], []byte("endstream"))
+	if end < 0 {
+		p.o = len(p.buf)
+		return pdfStream{d, p.buf[start:]}
+	}
+	p.o = start + end + len("endstream")
+	data := p.buf[start : start+end]
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	data = bytes.TrimSuffix(data, []byte("\r"))
+	return pdfStream{d, data}
+}
+
+func (p *pdfParser) literalString() ([]byte, error) {
+	p.o++ // (
+	var s []byte
+	depth := 1
+	for p.o < len(p.buf) {
+		c := p.buf[p.o]
+		p.o++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s, nil
+			}
+		case '\\':
+			if p.o >= len(p.buf) {
+				continue
+			}
+			c = p.buf[p.o]
+			p.o++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// Line continuation.
+				if c == '\r' && p.hasPrefix("\n") {
+					p.o++
+				}
+				continue
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				v := int(c - '0')
+				for i := 0; i < 2 && p.o < len(p.buf) && p.buf[p.o] >= '0' && p.buf[p.o] <= '7'; i++ {
+					v = v*8 + int(p.buf[p.o]-'0')
+					p.o++
+				}
+				c = byte(v)
+			}
+		}
+		s = append(s, c)
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (p *pdfParser) hexString() ([]byte, error) {
+	p.o++ // <
+	var digits []byte
+	for p.o < len(p.buf) {
+		c := p.buf[p.o]
+		p.o++
+		if c == '>' {
+			if len(digits)%2 == 1 {
+				digits = append(digits, '0')
+			}
+			s := make([]byte, len(digits)/2)
+			if _, err := hex.Decode(s, digits); err != nil {
+				return nil, fmt.Errorf("bad hex string: %v", err)
+			}
+			return s, nil
+		} else if !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+// skipInlineImage skips the binary data of an inline image, after the ID
+// operator, up to and including the EI operator.
+func (p *pdfParser) skipInlineImage() {
+	for i := p.o; ; {
+		j := bytes.Index(p.buf[i:], []byte("EI"))
+		if j < 0 {
+			p.o = len(p.buf)
+			return
+		}
+		j += i
+		if j > 0 && isPDFSpace(p.buf[j-1]) && (j+2 == len(p.buf) || isPDFSpace(p.buf[j+2])) {
+			p.o = j + 2
+			return
+		}
+		i = j + 2
+	}
+}
@@ -0,0 +1,70 @@
+// Package textextract extracts plain text from documents, such as PDF files and
+// OpenDocument/Office Open XML files, for full-text search of attachments.
+//
+// Extraction is best-effort: the goal is to find the words in documents, not to
+// reproduce their layout. Text is separated by spaces and newlines where the
+// document has separate words, lines or paragraphs.
+package textextract
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// ErrUnsupported is returned for documents that cannot be parsed, e.g. due to an
+	// unknown format or encryption.
+	ErrUnsupported = errors.New("unsupported document")
+
+	// ErrTooLarge is returned when document data, after decompression, would exceed
+	// a limit.
+	ErrTooLarge = errors.New("document data too large")
+)
+
+// errTextLimit is returned by textWriter when the maximum text size was reached.
+// Extraction stops, and the text gathered so far is returned.
+var errTextLimit = errors.New("text limit reached")
+
+// textWriter gathers extracted text, up to a maximum size. Control characters
+// are replaced with spaces, and repeated whitespace is collapsed.
+type textWriter struct {
+	b    strings.Builder
+	max  int
+	last rune // Last written rune, 0 initially.
+}
+
+func (w *textWriter) text() string {
+	return strings.TrimSpace(w.b.String())
+}
+
+// writeRune writes c, returning errTextLimit when the text is full. Whitespace is
+// only written if the text so far does not end with whitespace.
+func (w *textWriter) writeRune(c rune) error {
+	if c == utf8.RuneError || c == 0 {
+		return nil
+	}
+	if c != '\n' && (unicode.IsControl(c) || unicode.IsSpace(c)) {
+		c = ' '
+	}
+	if (c == ' ' || c == '\n') && (w.last == 0 || w.last == ' ' || w.last == '\n') {
+		return nil
+	}
+	if w.b.Len()+utf8.RuneLen(c) > w.max {
+		return errTextLimit
+	}
+	w.b.WriteRune(c)
+	w.last = c
+	return nil
+}
+
+// writeString writes the runes of s.
+func (w *textWriter) writeString(s string) error {
+	for _, c := range s {
+		if err := w.writeRune(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
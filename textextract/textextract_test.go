@@ -0,0 +1,149 @@
+package textextract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+var ctxbg = context.Background()
+
+func flate(s string) string {
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write([]byte(s))
+	zw.Close()
+	return b.String()
+}
+
+// makePDF returns a pdf file with objects, with the cross-reference table left out.
+func makePDF(objs ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, o := range objs {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Root 1 0 R /Size %d >>\n%%%%EOF\n", len(objs)+1)
+	return b.Bytes()
+}
+
+func stream(dict, data string) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func TestPDF(t *testing.T) {
+	test := func(buf []byte, maxText int, exp string, expErr error) {
+		t.Helper()
+		s, err := PDF(ctxbg, bytes.NewReader(buf), int64(len(buf)), maxText)
+		if (err == nil) != (expErr == nil) || err != nil && !errors.Is(err, expErr) {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+		if s != exp {
+			t.Fatalf("got text %q, expected %q", s, exp)
+		}
+	}
+
+	cmap := `/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar
+<0001> <0049>
+<0002> <006E>
+endbfchar
+2 beginbfrange
+<0003> <0005> [<0076> <006F> <0069>]
+<0006> <0008> <0063>
+endbfrange
+endcmap
+CMapName currentdict /CMap defineresource pop
+end
+end`
+
+	content := `BT /F1 12 Tf 72 712 Td (Hello \(pdf\) world) Tj 0 -14 Td [(Kern)-30(ed)-500(text)] TJ ET
+BT /F2 12 Tf <0001000200030004000500060008> Tj ET
+q /Fm1 Do Q
+BI /W 1 /H 1 /BPC 8 /CS /G ID ` + "\xff\x00 EI" + `
+BT /F1 12 Tf (caf\351) Tj ET`
+
+	pdf := makePDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 /Resources << /Font << /F1 5 0 R /F2 6 0 R >> /XObject << /Fm1 8 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+		stream("/Filter /FlateDecode", flate(content)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type0 /BaseFont /Test /Encoding /Identity-H /ToUnicode 7 0 R >>",
+		stream("", cmap),
+		stream("/Type /XObject /Subtype /Form /Resources << /Font << /F1 5 0 R >> >>", "BT /F1 12 Tf (Form text) Tj ET"),
+	)
+	test(pdf, 1024, "Hello (pdf) world\nKerned text\nInvoice\nForm text\ncafé", nil)
+	test(pdf, 20, "Hello (pdf) world\nKe", nil)
+	test([]byte("not a pdf"), 1024, "", ErrUnsupported)
+	test(makePDF("<< /Encrypt 2 0 R >>"), 1024, "", ErrUnsupported)
+
+	// Objects in an object stream, with Differences in the font encoding.
+	obj10 := "<< /Type /Page /Contents 3 0 R /Resources << /Font << /F1 11 0 R >> >> >>"
+	obj11 := "<< /Type /Font /Subtype /Type1 /Encoding << /Differences [65 /uni00E9 /fi] >> >>"
+	header := fmt.Sprintf("10 0 11 %d ", len(obj10)+1)
+	pdf = makePDF(
+		"<< /Type /Catalog >>",
+		stream(fmt.Sprintf("/Type /ObjStm /N 2 /First %d /Filter /FlateDecode", len(header)), flate(header+obj10+" "+obj11)),
+		stream("", "BT /F1 12 Tf (ABC) Tj ET"),
+	)
+	test(pdf, 1024, "éfiC", nil)
+}
+
+func makeZip(files ...string) []byte {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for i := 0; i+1 < len(files); i += 2 {
+		w, _ := zw.Create(files[i])
+		w.Write([]byte(files[i+1]))
+	}
+	zw.Close()
+	return b.Bytes()
+}
+
+func TestOffice(t *testing.T) {
+	test := func(buf []byte, maxText int, exp string, expErr error) {
+		t.Helper()
+		s, err := Office(ctxbg, bytes.NewReader(buf), int64(len(buf)), maxText)
+		if (err == nil) != (expErr == nil) || err != nil && !errors.Is(err, expErr) {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+		if s != exp {
+			t.Fatalf("got text %q, expected %q", s, exp)
+		}
+	}
+
+	docx := makeZip(
+		"[Content_Types].xml", `<?xml version="1.0"?><Types/>`,
+		"word/document.xml", `<?xml version="1.0"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body><w:p><w:r><w:t>Invoice</w:t></w:r><w:r><w:t xml:space="preserve"> number</w:t></w:r></w:p><w:p><w:r><w:t>Total</w:t><w:tab/><w:t>€ 12</w:t></w:r></w:p></w:body></w:document>`,
+	)
+	test(docx, 1024, "Invoice number\nTotal € 12", nil)
+	test(docx, 10, "Invoice nu", nil)
+
+	xlsx := makeZip(
+		"xl/sharedStrings.xml", `<sst><si><t>Name</t></si><si><t>Amount</t></si></sst>`,
+		"xl/worksheets/sheet1.xml", `<worksheet><sheetData><row><c t="s"><v>0</v></c><c t="s"><v>1</v></c></row><row><c t="inlineStr"><is><t>Widgets</t></is></c><c><v>1234</v></c></row></sheetData></worksheet>`,
+	)
+	test(xlsx, 1024, "Name\nAmount\nWidgets 1234", nil)
+
+	pptx := makeZip(
+		"ppt/slides/slide1.xml", `<p:sld xmlns:p="p" xmlns:a="a"><a:p><a:r><a:t>Slide title</a:t></a:r></a:p></p:sld>`,
+	)
+	test(pptx, 1024, "Slide title", nil)
+
+	odt := makeZip(
+		"mimetype", "application/vnd.oasis.opendocument.text",
+		"content.xml", `<office:document-content xmlns:office="o" xmlns:text="t"><office:body><office:text><text:h>Report</text:h><text:p>First<text:s/>line</text:p></office:text></office:body></office:document-content>`,
+	)
+	test(odt, 1024, "Report\nFirst line", nil)
+
+	test(makeZip("other.txt", "text"), 1024, "", ErrUnsupported)
+	test([]byte("not a zip"), 1024, "", ErrUnsupported)
+}
@@ -0,0 +1,108 @@
+// Package totp implements time-based one-time passwords, RFC 6238, as used by
+// authenticator apps for two-factor authentication.
+//
+// Codes are 6 digits, with a time step of 30 seconds and HMAC-SHA1, the defaults
+// of RFC 6238 and the only parameters widely supported by authenticator apps.
+package totp
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Step is the time step for a code, RFC 6238 section 4.1.
+	Step = 30 * time.Second
+
+	// Digits is the number of digits of a code.
+	Digits = 6
+
+	// Skew is the number of time steps before and after the current time step for
+	// which codes are accepted, to account for clock drift and for the time it takes
+	// a user to type the code, RFC 6238 section 5.2.
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a new random secret of 160 bits, the size of the HMAC-SHA1
+// output, as recommended by RFC 4226 section 4.
+func NewSecret() ([]byte, error) {
+	buf := make([]byte, 20)
+	if _, err := cryptorand.Read(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Key returns the secret in base32 without padding, for manually entering in an
+// authenticator app.
+func Key(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// URI returns an "otpauth" URI for the secret, typically shown as QR code for
+// scanning with an authenticator app. Issuer is the service name, e.g. the host
+// name, and name identifies the user at the issuer, e.g. an email address.
+func URI(secret []byte, issuer, name string) string {
+	v := url.Values{}
+	v.Set("secret", Key(secret))
+	v.Set("issuer", issuer)
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + name,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// Counter returns the time step counter for t, RFC 6238 section 4.2.
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(Step/time.Second)
+}
+
+// Code returns the code for the secret and time step counter, RFC 4226 section
+// 5.3.
+func Code(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation.
+	offset := sum[len(sum)-1] & 0xf
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, v%1000000)
+}
+
+// Verify checks code against the secret for the time steps around now. Codes for
+// time steps up to and including lastCounter are not accepted, so a code can only
+// be used once, RFC 6238 section 5.2.
+//
+// If the code is valid, the time step counter of the code is returned, to be
+// passed as lastCounter in a next call.
+func Verify(secret []byte, code string, now time.Time, lastCounter int64) (counter int64, ok bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+	cur := Counter(now)
+	for c := cur - Skew; c <= cur+Skew; c++ {
+		if c <= lastCounter {
+			continue
+		}
+		if hmac.Equal([]byte(Code(secret, c)), []byte(code)) {
+			return c, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Secret from the test vectors in RFC 4226 and RFC 6238.
+var secret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// RFC 4226 appendix D.
+	hotp := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for i, exp := range hotp {
+		if code := Code(secret, int64(i)); code != exp {
+			t.Fatalf("code for counter %d: got %q, expected %q", i, code, exp)
+		}
+	}
+
+	// RFC 6238 appendix B for SHA1, with the last 6 of the 8 digits.
+	totp := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tc := range totp {
+		if code := Code(secret, Counter(time.Unix(tc.unix, 0))); code != tc.code {
+			t.Fatalf("code for time %d: got %q, expected %q", tc.unix, code, tc.code)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	cur := Counter(now)
+
+	test := func(code string, lastCounter int64, expCounter int64, expOK bool) {
+		t.Helper()
+		counter, ok := Verify(secret, code, now, lastCounter)
+		if ok != expOK || counter != expCounter {
+			t.Fatalf("verify %q: got %d %v, expected %d %v", code, counter, ok, expCounter, expOK)
+		}
+	}
+
+	test(Code(secret, cur), 0, cur, true)
+	test("050 471", 0, cur, true)
+	test(Code(secret, cur-1), 0, cur-1, true)
+	test(Code(secret, cur+1), 0, cur+1, true)
+	test(Code(secret, cur-2), 0, 0, false)
+	test(Code(secret, cur+2), 0, 0, false)
+	test(Code(secret, cur), cur, 0, false) // Already used.
+	test(Code(secret, cur+1), cur, cur+1, true)
+	test("", 0, 0, false)
+	test("12345", 0, 0, false)
+	test("1234567", 0, 0, false)
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI(secret, "mox.example", "mjl@mox.example"))
+	if err != nil {
+		t.Fatalf("parsing uri: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/mox.example:mjl@mox.example" {
+		t.Fatalf("unexpected uri %s", u)
+	}
+	q := u.Query()
+	if q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || q.Get("issuer") != "mox.example" {
+		t.Fatalf("unexpected uri parameters %s", u)
+	}
+}
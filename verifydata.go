@@ -19,6 +19,7 @@ import (
 
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/junk"
+	"github.com/mjl-/mox/mlist"
 	"github.com/mjl-/mox/moxvar"
 	"github.com/mjl-/mox/mtastsdb"
 	"github.com/mjl-/mox/queue"
@@ -148,12 +149,12 @@ possibly making them potentially no longer readable by the previous version.
 		checkf(err, path, "checking database file")
 	}
 
-	checkFile := func(dbpath, path string, prefixSize int, size int64) {
-		st, err := os.Stat(path)
+	checkFile := func(dbpath, path string, prefixSize int, size int64, compressed, encrypted bool) {
+		// Size of original data for compressed and/or encrypted message files.
+		filesize, err := store.MessageFileSize(path, compressed, encrypted)
 		checkf(err, path, "checking if file exists")
-		if !skipSizeCheck && err == nil && int64(prefixSize)+st.Size() != size {
-			filesize := st.Size()
-			checkf(fmt.Errorf("%s: message size is %d, should be %d (length of MsgPrefix %d + file size %d), see \"mox fixmsgsize\"", path, size, int64(prefixSize)+st.Size(), prefixSize, filesize), dbpath, "checking message size")
+		if !skipSizeCheck && err == nil && int64(prefixSize)+filesize != size {
+			checkf(fmt.Errorf("%s: message size is %d, should be %d (length of MsgPrefix %d + file size %d), see \"mox fixmsgsize\"", path, size, int64(prefixSize)+filesize, prefixSize, filesize), dbpath, "checking message size")
 		}
 	}
 
@@ -170,7 +171,7 @@ possibly making them potentially no longer readable by the previous version.
 				mp := store.MessagePath(m.ID)
 				seen[mp] = struct{}{}
 				p := filepath.Join(dataDir, "queue", mp)
-				checkFile(dbpath, p, len(m.MsgPrefix), m.Size)
+				checkFile(dbpath, p, len(m.MsgPrefix), m.Size, false, false)
 				return nil
 			})
 			checkf(err, dbpath, "reading messages in queue database to check files")
@@ -288,7 +289,7 @@ possibly making them potentially no longer readable by the previous version.
 				mp := store.MessagePath(m.ID)
 				seen[mp] = struct{}{}
 				p := filepath.Join(accdir, "msg", mp)
-				checkFile(dbpath, p, len(m.MsgPrefix), m.Size)
+				checkFile(dbpath, p, len(m.MsgPrefix), m.Size, m.FileCompressed, m.FileEncrypted)
 
 				if up.Threads != 2 {
 					return nil
@@ -319,6 +320,16 @@ possibly making them potentially no longer readable by the previous version.
 			})
 			checkf(err, dbpath, "reading messages in account database to check files")
 
+			// Deleted messages that can still be recovered have their message files kept.
+			err = bstore.QueryDB[store.DeletedMessage](ctxbg, db).ForEach(func(dm store.DeletedMessage) error {
+				mp := store.MessagePath(dm.ID)
+				seen[mp] = struct{}{}
+				p := filepath.Join(accdir, "msg", mp)
+				checkFile(dbpath, p, len(dm.MsgPrefix), dm.Size, dm.FileCompressed, dm.FileEncrypted)
+				return nil
+			})
+			checkf(err, dbpath, "reading deleted messages in account database to check files")
+
 			haveCounts := true
 			for _, mb := range mailboxes {
 				// We only check if database doesn't have zero values, i.e. not yet set.
@@ -420,7 +431,7 @@ possibly making them potentially no longer readable by the previous version.
 				p = p[len(dataDir)+1:]
 			}
 			switch p {
-			case "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "receivedid.key", "lastknownversion":
+			case "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "mlist.db", "receivedid.key", "lastknownversion":
 				return nil
 			case "acme", "queue", "accounts", "tmp", "moved":
 				return fs.SkipDir
@@ -443,6 +454,7 @@ possibly making them potentially no longer readable by the previous version.
 	checkDB(true, filepath.Join(dataDir, "mtasts.db"), mtastsdb.DBTypes)
 	checkDB(true, filepath.Join(dataDir, "tlsrpt.db"), tlsrptdb.ReportDBTypes)
 	checkDB(false, filepath.Join(dataDir, "tlsrptresult.db"), tlsrptdb.ResultDBTypes) // After v0.0.7.
+	checkDB(false, filepath.Join(dataDir, "mlist.db"), mlist.DBTypes)
 	checkQueue()
 	checkAccounts()
 	checkOther()
@@ -31,7 +31,9 @@ import (
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/totp"
 	"github.com/mjl-/mox/webauth"
 )
 
@@ -361,12 +363,15 @@ func (w Account) LoginPrep(ctx context.Context) string {
 }
 
 // Login returns a session token for the credentials, or fails with error code
-// "user:badLogin". Call LoginPrep to get a loginToken.
-func (w Account) Login(ctx context.Context, loginToken, username, password string) store.CSRFToken {
+// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+// authentication is enabled, totpCode must be a code from the authenticator app
+// or a recovery code, the call fails with error code "user:totpRequired" if it is
+// empty.
+func (w Account) Login(ctx context.Context, loginToken, username, password, totpCode string) store.CSRFToken {
 	log := pkglog.WithContext(ctx)
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 
-	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webaccount", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password)
+	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webaccount", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password, totpCode)
 	if _, ok := err.(*sherpa.Error); ok {
 		panic(err)
 	}
@@ -405,6 +410,9 @@ func (Account) SetPassword(ctx context.Context, password string) {
 	xcheckf(ctx, err, "get session")
 
 	err = acc.SetPassword(log, password)
+	if errors.Is(err, store.ErrEncryptionLocked) {
+		xcheckuserf(ctx, err, "setting password")
+	}
 	xcheckf(ctx, err, "setting password")
 
 	// Session has been invalidated. Add it again.
@@ -453,6 +461,11 @@ func (Account) DestinationSave(ctx context.Context, destName string, oldDest, ne
 		xcheckuserf(ctx, errors.New("modified"), "checking stored destination")
 	}
 
+	for _, s := range newDest.ForwardTo {
+		_, err := smtp.ParseAddress(s)
+		xcheckuserf(ctx, err, "parsing forward address %q", s)
+	}
+
 	// Keep fields we manage.
 	newDest.DMARCReports = curDest.DMARCReports
 	newDest.HostTLSReports = curDest.HostTLSReports
@@ -462,6 +475,233 @@ func (Account) DestinationSave(ctx context.Context, destName string, oldDest, ne
 	xcheckf(ctx, err, "saving destination")
 }
 
+// DeletedList returns the messages that were deleted, e.g. by emptying the Trash
+// mailbox, but can still be recovered, most recently deleted first. Messages are
+// only kept if the account is configured with a KeepDeletedPeriod.
+func (Account) DeletedList(ctx context.Context) []store.DeletedMessage {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.DeletedList(ctx)
+	xcheckf(ctx, err, "listing deleted messages")
+	return l
+}
+
+// DeletedRecover recovers deleted messages, adding them to the mailbox they were
+// deleted from, or Inbox if that mailbox no longer exists. The number of recovered
+// messages is returned.
+func (Account) DeletedRecover(ctx context.Context, messageIDs []int64) int {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	recovered, err := acc.DeletedRecover(ctx, log, messageIDs)
+	if errors.Is(err, store.ErrUnknownDeletedMessage) || errors.Is(err, store.ErrOverQuota) {
+		xcheckuserf(ctx, err, "recovering deleted messages")
+	}
+	xcheckf(ctx, err, "recovering deleted messages")
+	return len(recovered)
+}
+
+// AutoReplyGet returns the settings for the vacation/out-of-office
+// auto-responder.
+func (Account) AutoReplyGet(ctx context.Context) store.AutoReply {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	ar, err := acc.AutoReplyGet(ctx)
+	xcheckf(ctx, err, "get automatic reply settings")
+	return ar
+}
+
+// AutoReplySave saves the settings for the vacation/out-of-office
+// auto-responder. While enabled, and between the optional start and end time,
+// incoming messages get an automatic reply, at most once a week per sender.
+// Messages from mailing lists, junk, delivery status notifications and reports
+// don't get a reply.
+func (Account) AutoReplySave(ctx context.Context, ar store.AutoReply) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.AutoReplySave(ctx, ar)
+	if errors.Is(err, store.ErrAutoReply) {
+		xcheckuserf(ctx, err, "saving automatic reply settings")
+	}
+	xcheckf(ctx, err, "saving automatic reply settings")
+}
+
+// AppPasswords returns the app passwords of the account, for use in email
+// clients instead of the account password.
+func (Account) AppPasswords(ctx context.Context) []store.AppPassword {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.AppPasswordList(ctx)
+	xcheckf(ctx, err, "listing app passwords")
+	return l
+}
+
+// AppPasswordAdd generates a new app password, valid for IMAP and/or SMTP
+// submission. The generated password is returned, it cannot be retrieved later.
+func (Account) AppPasswordAdd(ctx context.Context, name string, imap, submission bool) (store.AppPassword, string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	ap, password, err := acc.AppPasswordAdd(ctx, log, name, imap, submission)
+	if errors.Is(err, store.ErrAppPassword) || errors.Is(err, store.ErrEncryptionLocked) {
+		xcheckuserf(ctx, err, "adding app password")
+	}
+	xcheckf(ctx, err, "adding app password")
+	return ap, password
+}
+
+// AppPasswordRemove revokes an app password. New authentication attempts with it
+// fail, existing connections are not closed.
+func (Account) AppPasswordRemove(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.AppPasswordRemove(ctx, log, id)
+	if errors.Is(err, store.ErrAppPassword) {
+		xcheckuserf(ctx, err, "removing app password")
+	}
+	xcheckf(ctx, err, "removing app password")
+}
+
+// TOTPGet returns whether two-factor authentication is enabled for logins to the
+// web interfaces, and the number of unused recovery codes.
+func (Account) TOTPGet(ctx context.Context) (enabled bool, recoveryCodes int) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	t, err := acc.TOTPGet(ctx)
+	xcheckf(ctx, err, "get two-factor authentication")
+	return t.Enabled, len(t.RecoveryCodes)
+}
+
+// TOTPEnrollStart starts enrolment in two-factor authentication with a new
+// secret. The secret is returned as key for manual entry, as otpauth URI, and as
+// QR code (PNG data URL) of the URI, for adding to an authenticator app.
+// Two-factor authentication is enabled by TOTPEnrollFinish.
+func (Account) TOTPEnrollStart(ctx context.Context) (key, uri, qrcode string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	t, err := acc.TOTPEnrollStart(ctx, log)
+	if errors.Is(err, store.ErrTOTP) {
+		xcheckuserf(ctx, err, "starting two-factor authentication enrolment")
+	}
+	xcheckf(ctx, err, "starting two-factor authentication enrolment")
+
+	uri = totp.URI(t.Secret, mox.Conf.Static.HostnameDomain.Name(), reqInfo.LoginAddress)
+	qrcode, err = webauth.TOTPQRCode(uri)
+	xcheckf(ctx, err, "generating qr code")
+	return totp.Key(t.Secret), uri, qrcode
+}
+
+// TOTPEnrollFinish enables two-factor authentication after verifying a code from
+// the authenticator app for the secret from TOTPEnrollStart. Recovery codes are
+// returned, each can be used once instead of a code, e.g. when the device with the
+// authenticator app is lost. Other sessions are logged out.
+func (Account) TOTPEnrollFinish(ctx context.Context, code string) []string {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	// Retrieve session, enabling two-factor authentication invalidates it.
+	ls, err := store.SessionUse(ctx, log, reqInfo.AccountName, reqInfo.SessionToken, "")
+	xcheckf(ctx, err, "get session")
+
+	codes, err := acc.TOTPEnrollFinish(ctx, log, code)
+	if errors.Is(err, store.ErrTOTP) {
+		xcheckuserf(ctx, err, "enabling two-factor authentication")
+	}
+	xcheckf(ctx, err, "enabling two-factor authentication")
+
+	// Session has been invalidated. Add it again.
+	err = store.SessionAddToken(ctx, log, &ls)
+	xcheckf(ctx, err, "restoring session after enabling two-factor authentication")
+	return codes
+}
+
+// TOTPDisable disables two-factor authentication, or aborts an enrolment. If
+// enabled, code must be a current code from the authenticator app, an unused
+// recovery code, or the account password.
+func (Account) TOTPDisable(ctx context.Context, code string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.TOTPDisable(ctx, log, code)
+	if errors.Is(err, store.ErrTOTP) {
+		xcheckuserf(ctx, err, "disabling two-factor authentication")
+	}
+	xcheckf(ctx, err, "disabling two-factor authentication")
+}
+
 // ImportAbort aborts an import that is in progress. If the import exists and isn't
 // finished, no changes will have been made by the import.
 func (Account) ImportAbort(ctx context.Context, importToken string) error {
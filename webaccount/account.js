@@ -226,13 +226,16 @@ const [dom, style, attr, prop] = (function () {
 // NOTE: GENERATED by github.com/mjl-/sherpats, DO NOT MODIFY
 var api;
 (function (api) {
-	api.structTypes = { "Destination": true, "Domain": true, "ImportProgress": true, "Ruleset": true };
+	api.structTypes = { "AppPassword": true, "AutoReply": true, "DeletedMessage": true, "Destination": true, "Domain": true, "ImportProgress": true, "Ruleset": true };
 	api.stringsTypes = { "CSRFToken": true };
 	api.intsTypes = {};
 	api.types = {
 		"Domain": { "Name": "Domain", "Docs": "", "Fields": [{ "Name": "ASCII", "Docs": "", "Typewords": ["string"] }, { "Name": "Unicode", "Docs": "", "Typewords": ["string"] }] },
-		"Destination": { "Name": "Destination", "Docs": "", "Fields": [{ "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Rulesets", "Docs": "", "Typewords": ["[]", "Ruleset"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }] },
-		"Ruleset": { "Name": "Ruleset", "Docs": "", "Fields": [{ "Name": "SMTPMailFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "HeadersRegexp", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListAllowDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "AcceptRejectsToMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ListAllowDNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
+		"Destination": { "Name": "Destination", "Docs": "", "Fields": [{ "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Rulesets", "Docs": "", "Typewords": ["[]", "Ruleset"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "ForwardTo", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ForwardKeepCopy", "Docs": "", "Typewords": ["bool"] }] },
+		"Ruleset": { "Name": "Ruleset", "Docs": "", "Fields": [{ "Name": "SMTPMailFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "SubaddressRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "HeadersRegexp", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListAllowDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "AcceptRejectsToMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ListAllowDNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
+		"DeletedMessage": { "Name": "DeletedMessage", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Expunged", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "MailboxID", "Docs": "", "Typewords": ["int64"] }, { "Name": "MailboxName", "Docs": "", "Typewords": ["string"] }, { "Name": "Received", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "MsgPrefix", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Seen", "Docs": "", "Typewords": ["bool"] }, { "Name": "Answered", "Docs": "", "Typewords": ["bool"] }, { "Name": "Flagged", "Docs": "", "Typewords": ["bool"] }, { "Name": "Forwarded", "Docs": "", "Typewords": ["bool"] }, { "Name": "Junk", "Docs": "", "Typewords": ["bool"] }, { "Name": "Notjunk", "Docs": "", "Typewords": ["bool"] }, { "Name": "Deleted", "Docs": "", "Typewords": ["bool"] }, { "Name": "Draft", "Docs": "", "Typewords": ["bool"] }, { "Name": "Phishing", "Docs": "", "Typewords": ["bool"] }, { "Name": "MDNSent", "Docs": "", "Typewords": ["bool"] }, { "Name": "Keywords", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "FileCompressed", "Docs": "", "Typewords": ["bool"] }, { "Name": "FileEncrypted", "Docs": "", "Typewords": ["bool"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "From", "Docs": "", "Typewords": ["string"] }] },
+		"AutoReply": { "Name": "AutoReply", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Enabled", "Docs": "", "Typewords": ["bool"] }, { "Name": "Start", "Docs": "", "Typewords": ["nullable", "timestamp"] }, { "Name": "End", "Docs": "", "Typewords": ["nullable", "timestamp"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "Body", "Docs": "", "Typewords": ["string"] }] },
+		"AppPassword": { "Name": "AppPassword", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "IMAP", "Docs": "", "Typewords": ["bool"] }, { "Name": "Submission", "Docs": "", "Typewords": ["bool"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastUsed", "Docs": "", "Typewords": ["timestamp"] }] },
 		"ImportProgress": { "Name": "ImportProgress", "Docs": "", "Fields": [{ "Name": "Token", "Docs": "", "Typewords": ["string"] }] },
 		"CSRFToken": { "Name": "CSRFToken", "Docs": "", "Values": null },
 	};
@@ -240,6 +243,9 @@ var api;
 		Domain: (v) => api.parse("Domain", v),
 		Destination: (v) => api.parse("Destination", v),
 		Ruleset: (v) => api.parse("Ruleset", v),
+		DeletedMessage: (v) => api.parse("DeletedMessage", v),
+		AutoReply: (v) => api.parse("AutoReply", v),
+		AppPassword: (v) => api.parse("AppPassword", v),
 		ImportProgress: (v) => api.parse("ImportProgress", v),
 		CSRFToken: (v) => api.parse("CSRFToken", v),
 	};
@@ -278,12 +284,15 @@ var api;
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Login returns a session token for the credentials, or fails with error code
-		// "user:badLogin". Call LoginPrep to get a loginToken.
-		async Login(loginToken, username, password) {
+		// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+		// authentication is enabled, totpCode must be a code from the authenticator app
+		// or a recovery code, the call fails with error code "user:totpRequired" if it is
+		// empty.
+		async Login(loginToken, username, password, totpCode) {
 			const fn = "Login";
-			const paramTypes = [["string"], ["string"], ["string"]];
+			const paramTypes = [["string"], ["string"], ["string"], ["string"]];
 			const returnTypes = [["CSRFToken"]];
-			const params = [loginToken, username, password];
+			const params = [loginToken, username, password, totpCode];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Logout invalidates the session token.
@@ -332,6 +341,115 @@ var api;
 			const params = [destName, oldDest, newDest];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// DeletedList returns the messages that were deleted, e.g. by emptying the Trash
+		// mailbox, but can still be recovered, most recently deleted first. Messages are
+		// only kept if the account is configured with a KeepDeletedPeriod.
+		async DeletedList() {
+			const fn = "DeletedList";
+			const paramTypes = [];
+			const returnTypes = [["[]", "DeletedMessage"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DeletedRecover recovers deleted messages, adding them to the mailbox they were
+		// deleted from, or Inbox if that mailbox no longer exists. The number of recovered
+		// messages is returned.
+		async DeletedRecover(messageIDs) {
+			const fn = "DeletedRecover";
+			const paramTypes = [["[]", "int64"]];
+			const returnTypes = [["int32"]];
+			const params = [messageIDs];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AutoReplyGet returns the settings for the vacation/out-of-office
+		// auto-responder.
+		async AutoReplyGet() {
+			const fn = "AutoReplyGet";
+			const paramTypes = [];
+			const returnTypes = [["AutoReply"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AutoReplySave saves the settings for the vacation/out-of-office
+		// auto-responder. While enabled, and between the optional start and end time,
+		// incoming messages get an automatic reply, at most once a week per sender.
+		// Messages from mailing lists, junk, delivery status notifications and reports
+		// don't get a reply.
+		async AutoReplySave(ar) {
+			const fn = "AutoReplySave";
+			const paramTypes = [["AutoReply"]];
+			const returnTypes = [];
+			const params = [ar];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AppPasswords returns the app passwords of the account, for use in email
+		// clients instead of the account password.
+		async AppPasswords() {
+			const fn = "AppPasswords";
+			const paramTypes = [];
+			const returnTypes = [["[]", "AppPassword"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AppPasswordAdd generates a new app password, valid for IMAP and/or SMTP
+		// submission. The generated password is returned, it cannot be retrieved later.
+		async AppPasswordAdd(name, imap, submission) {
+			const fn = "AppPasswordAdd";
+			const paramTypes = [["string"], ["bool"], ["bool"]];
+			const returnTypes = [["AppPassword"], ["string"]];
+			const params = [name, imap, submission];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AppPasswordRemove revokes an app password. New authentication attempts with it
+		// fail, existing connections are not closed.
+		async AppPasswordRemove(id) {
+			const fn = "AppPasswordRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPGet returns whether two-factor authentication is enabled for logins to the
+		// web interfaces, and the number of unused recovery codes.
+		async TOTPGet() {
+			const fn = "TOTPGet";
+			const paramTypes = [];
+			const returnTypes = [["bool"], ["int32"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPEnrollStart starts enrolment in two-factor authentication with a new
+		// secret. The secret is returned as key for manual entry, as otpauth URI, and as
+		// QR code (PNG data URL) of the URI, for adding to an authenticator app.
+		// Two-factor authentication is enabled by TOTPEnrollFinish.
+		async TOTPEnrollStart() {
+			const fn = "TOTPEnrollStart";
+			const paramTypes = [];
+			const returnTypes = [["string"], ["string"], ["string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPEnrollFinish enables two-factor authentication after verifying a code from
+		// the authenticator app for the secret from TOTPEnrollStart. Recovery codes are
+		// returned, each can be used once instead of a code, e.g. when the device with the
+		// authenticator app is lost. Other sessions are logged out.
+		async TOTPEnrollFinish(code) {
+			const fn = "TOTPEnrollFinish";
+			const paramTypes = [["string"]];
+			const returnTypes = [["[]", "string"]];
+			const params = [code];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPDisable disables two-factor authentication, or aborts an enrolment. If
+		// enabled, code must be a current code from the authenticator app, an unused
+		// recovery code, or the account password.
+		async TOTPDisable(code) {
+			const fn = "TOTPDisable";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [code];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// ImportAbort aborts an import that is in progress. If the import exists and isn't
 		// finished, no changes will have been made by the import.
 		async ImportAbort(importToken) {
@@ -709,14 +827,17 @@ const login = async (reason) => {
 		let autosize;
 		let username;
 		let password;
+		let totpBox;
+		let totpCode;
 		const root = dom.div(style({ position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in' }), dom.div(reasonElem = reason ? dom.div(style({ marginBottom: '2ex', textAlign: 'center' }), reason) : dom.div(), dom.div(style({ backgroundColor: 'white', borderRadius: '.25em', padding: '1em', boxShadow: '0 0 20px rgba(0, 0, 0, 0.1)', border: '1px solid #ddd', maxWidth: '95vw', overflowX: 'auto', maxHeight: '95vh', overflowY: 'auto', marginBottom: '20vh' }), dom.form(async function submit(e) {
 			e.preventDefault();
 			e.stopPropagation();
 			reasonElem.remove();
+			let totpRequired = false;
 			try {
 				fieldset.disabled = true;
 				const loginToken = await client.LoginPrep();
-				const token = await client.Login(loginToken, username.value, password.value);
+				const token = await client.Login(loginToken, username.value, password.value, totpCode.value);
 				try {
 					window.localStorage.setItem('webaccountaddress', username.value);
 					window.localStorage.setItem('webaccountcsrftoken', token);
@@ -732,12 +853,23 @@ const login = async (reason) => {
 			}
 			catch (err) {
 				console.log('login error', err);
-				window.alert('Error: ' + errmsg(err));
+				if (err.code === 'user:totpRequired') {
+					// Password is valid, a code for two-factor authentication is required too.
+					totpRequired = true;
+				}
+				else {
+					window.alert('Error: ' + errmsg(err));
+				}
 			}
 			finally {
 				fieldset.disabled = false;
 			}
-		}, fieldset = dom.fieldset(dom.h1('Account'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Email address', style({ marginBottom: '.5ex' })), autosize = dom.span(dom._class('autosize'), username = dom.input(attr.required(''), attr.placeholder('jane@example.org'), function change() { autosize.dataset.value = username.value; }, function input() { autosize.dataset.value = username.value; }))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.required(''))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
+			if (totpRequired) {
+				totpBox.style.display = 'block';
+				totpCode.required = true;
+				totpCode.focus();
+			}
+		}, fieldset = dom.fieldset(dom.h1('Account'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Email address', style({ marginBottom: '.5ex' })), autosize = dom.span(dom._class('autosize'), username = dom.input(attr.required(''), attr.placeholder('jane@example.org'), function change() { autosize.dataset.value = username.value; }, function input() { autosize.dataset.value = username.value; }))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.required(''))), totpBox = dom.label(style({ display: 'none', marginBottom: '2ex' }), dom.div('Two-factor authentication code', style({ marginBottom: '.5ex' })), totpCode = dom.input(attr.autocomplete('one-time-code'), attr.title('Code from your authenticator app, or one of your recovery codes.'))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
 		document.body.appendChild(root);
 		username.focus();
 	});
@@ -809,6 +941,10 @@ const red = '#ff7443';
 const blue = '#8bc8ff';
 const index = async () => {
 	const [accountFullName, domain, destinations] = await client.Account();
+	const deleted = await client.DeletedList() || [];
+	const autoReply = await client.AutoReplyGet();
+	const appPasswords = await client.AppPasswords() || [];
+	const [totpEnabled, totpRecoveryCodes] = await client.TOTPGet();
 	let fullNameForm;
 	let fullNameFieldset;
 	let fullName;
@@ -817,12 +953,123 @@ const index = async () => {
 	let password1;
 	let password2;
 	let passwordHint;
+	let appPasswordsBody;
+	let appPasswordForm;
+	let appPasswordFieldset;
+	let appPasswordName;
+	let appPasswordIMAP;
+	let appPasswordSubmission;
+	let appPasswordNew;
+	let totpBox;
+	let autoReplyFieldset;
+	let autoReplyEnabled;
+	let autoReplyStart;
+	let autoReplyEnd;
+	let autoReplySubject;
+	let autoReplyBody;
 	let importForm;
 	let importFieldset;
 	let mailboxFileHint;
 	let mailboxPrefixHint;
 	let importProgress;
 	let importAbortBox;
+	const renderAppPasswords = (l) => {
+		dom._kids(appPasswordsBody, l.length === 0 ? dom.tr(dom.td(attr.colspan('5'), 'No app passwords.')) : [], l.map(ap => {
+			const row = dom.tr(dom.td(ap.Name), dom.td([ap.IMAP ? 'IMAP' : '', ap.Submission ? 'Submission' : ''].filter(s => s).join(', ')), dom.td(ap.Created.toLocaleString()), 
+			// Zero time if never used.
+			dom.td(ap.LastUsed.getUTCFullYear() <= 1 ? 'Never' : ap.LastUsed.toLocaleString()), dom.td(dom.clickbutton('Remove', async function click(e) {
+				if (!window.confirm('Are you sure you want to remove app password "' + ap.Name + '"? Email clients using it will no longer be able to log in.')) {
+					return;
+				}
+				const b = e.target;
+				try {
+					b.disabled = true;
+					await client.AppPasswordRemove(ap.ID);
+					renderAppPasswords(await client.AppPasswords() || []);
+				}
+				catch (err) {
+					console.log({ err });
+					window.alert('Error: ' + errmsg(err));
+				}
+				finally {
+					b.disabled = false;
+				}
+			})));
+			return row;
+		}));
+	};
+	// If recoveryCodes is set, two-factor authentication was just enabled and the new
+	// recovery codes are shown.
+	const renderTOTP = (enabled, remaining, recoveryCodes) => {
+		if (enabled) {
+			dom._kids(totpBox, recoveryCodes ? box(blue, 'Two-factor authentication has been enabled. Store these recovery codes in a safe place now, they will not be shown again. Each can be used once instead of a code from your authenticator app, e.g. when you lose your device.', dom.ul(recoveryCodes.map(c => dom.li(dom.span(style({ fontFamily: 'monospace', fontWeight: 'bold' }), c))))) : [], dom.p('Two-factor authentication is enabled. Recovery codes left: ' + remaining + '.'), dom.clickbutton('Disable two-factor authentication', async function click(e) {
+				const code = window.prompt('To disable two-factor authentication, enter a code from your authenticator app, a recovery code, or your password. Logins to the web interface will then only require your password.');
+				if (!code) {
+					return;
+				}
+				const b = e.target;
+				try {
+					b.disabled = true;
+					await client.TOTPDisable(code);
+					renderTOTP(false, 0);
+				}
+				catch (err) {
+					console.log({ err });
+					window.alert('Error: ' + errmsg(err));
+				}
+				finally {
+					b.disabled = false;
+				}
+			}));
+			return;
+		}
+		dom._kids(totpBox, dom.p('Two-factor authentication is not enabled.'), dom.clickbutton('Enable two-factor authentication', async function click(e) {
+			const b = e.target;
+			try {
+				b.disabled = true;
+				const [key, uri, qrcode] = await client.TOTPEnrollStart();
+				renderTOTPEnroll(key, uri, qrcode);
+			}
+			catch (err) {
+				console.log({ err });
+				window.alert('Error: ' + errmsg(err));
+			}
+			finally {
+				b.disabled = false;
+			}
+		}));
+	};
+	const renderTOTPEnroll = (key, uri, qrcode) => {
+		let fieldset;
+		let code;
+		dom._kids(totpBox, dom.p('Scan the QR code with your authenticator app, or enter the key manually. Then enter the code shown by the app to enable two-factor authentication.'), dom.img(attr.src(qrcode), attr.title(uri)), dom.div('Key: ', dom.span(style({ fontFamily: 'monospace' }), key)), dom.br(), dom.form(fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Code', dom.br(), code = dom.input(attr.required(''), attr.autocomplete('one-time-code'))), ' ', dom.submitbutton('Enable'), ' ', dom.clickbutton('Cancel', function click() {
+			renderTOTP(false, 0);
+		})), async function submit(e) {
+			e.stopPropagation();
+			e.preventDefault();
+			fieldset.disabled = true;
+			try {
+				const codes = await client.TOTPEnrollFinish(code.value) || [];
+				renderTOTP(true, codes.length, codes);
+			}
+			catch (err) {
+				console.log({ err });
+				window.alert('Error: ' + errmsg(err));
+			}
+			finally {
+				fieldset.disabled = false;
+			}
+		}));
+		code.focus();
+	};
+	// Local date as used in date input fields, yyyy-mm-dd.
+	const dateString = (d) => [d.getFullYear(), d.getMonth() + 1, d.getDate()].map(v => v < 10 ? '0' + v : '' + v).join('-');
+	// Start of day for date from date input field, in local time, optionally for a later day.
+	const dateStart = (s, days) => {
+		const d = new Date(s + 'T00:00:00');
+		d.setDate(d.getDate() + days);
+		return d;
+	};
 	const importTrack = async (token) => {
 		const importConnection = dom.div('Waiting for updates...');
 		importProgress.appendChild(importConnection);
@@ -953,7 +1200,69 @@ const index = async () => {
 		finally {
 			passwordFieldset.disabled = false;
 		}
-	}), dom.br(), dom.h2('Export'), dom.p('Export all messages in all mailboxes. In maildir or mbox format, as .zip or .tgz file.'), dom.table(dom._class('slim'), dom.tr(dom.td('Maildirs in .tgz'), dom.td(exportForm('mail-export-maildir.tgz'))), dom.tr(dom.td('Maildirs in .zip'), dom.td(exportForm('mail-export-maildir.zip'))), dom.tr(dom.td('Mbox files in .tgz'), dom.td(exportForm('mail-export-mbox.tgz'))), dom.tr(dom.td('Mbox files in .zip'), dom.td(exportForm('mail-export-mbox.zip')))), dom.br(), dom.h2('Import'), dom.p('Import messages from a .zip or .tgz file with maildirs and/or mbox files.'), importForm = dom.form(async function submit(e) {
+	}), dom.br(), dom.h2('App passwords'), dom.p('App passwords are generated passwords to configure in email clients instead of your account password. Each is valid for IMAP and/or SMTP submission, and can be removed to revoke access for a single client. App passwords cannot be used to log in to the web interface.'), dom.table(dom.thead(dom.tr(dom.th('Name'), dom.th('Valid for'), dom.th('Created'), dom.th('Last used'), dom.th('Action'))), appPasswordsBody = dom.tbody()), dom.br(), appPasswordForm = dom.form(appPasswordFieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Name', dom.br(), appPasswordName = dom.input(attr.required(''), attr.placeholder('e.g. phone'))), ' ', dom.label(appPasswordIMAP = dom.input(attr.type('checkbox'), attr.checked('')), ' IMAP'), ' ', dom.label(appPasswordSubmission = dom.input(attr.type('checkbox'), attr.checked('')), ' Submission'), ' ', dom.submitbutton('Add app password')), appPasswordNew = dom.div(), async function submit(e) {
+		e.stopPropagation();
+		e.preventDefault();
+		appPasswordFieldset.disabled = true;
+		try {
+			const [ap, password] = await client.AppPasswordAdd(appPasswordName.value, appPasswordIMAP.checked, appPasswordSubmission.checked);
+			appPasswordForm.reset();
+			dom._kids(appPasswordNew, box(blue, 'App password "' + ap.Name + '" has been added. Configure it in your email client now, it will not be shown again: ', dom.span(style({ fontFamily: 'monospace', fontWeight: 'bold' }), password)));
+			renderAppPasswords(await client.AppPasswords() || []);
+		}
+		catch (err) {
+			console.log({ err });
+			window.alert('Error: ' + errmsg(err));
+		}
+		finally {
+			appPasswordFieldset.disabled = false;
+		}
+	}), dom.br(), dom.h2('Two-factor authentication'), dom.p('With two-factor authentication, logging in to the web interface requires a code from an authenticator app on your phone in addition to your password. It does not apply to email clients, use app passwords for those.'), totpBox = dom.div(), dom.br(), dom.h2('Automatic reply'), dom.p('Send an automatic reply to incoming messages, e.g. while on vacation or out of office. Each sender gets at most one reply per week. No replies are sent to mailing lists, junk, delivery status notifications and reports.'), dom.form(autoReplyFieldset = dom.fieldset(dom.label(autoReplyEnabled = dom.input(attr.type('checkbox'), autoReply.Enabled ? attr.checked('') : []), ' Enabled'), dom.div(style({ marginTop: '.5ex' }), dom.label(style({ display: 'inline-block' }), 'First day (optional)', dom.br(), autoReplyStart = dom.input(attr.type('date'), attr.value(autoReply.Start ? dateString(autoReply.Start) : ''))), ' ', dom.label(style({ display: 'inline-block' }), 'Last day (optional)', dom.br(), autoReplyEnd = dom.input(attr.type('date'), attr.value(autoReply.End ? dateString(new Date(autoReply.End.getTime() - 1)) : '')))), dom.div(style({ marginTop: '.5ex' }), dom.label(style({ display: 'inline-block' }), 'Subject (optional)', dom.br(), autoReplySubject = dom.input(attr.value(autoReply.Subject), attr.placeholder('Auto: <original subject>')))), dom.div(style({ marginTop: '.5ex' }), dom.label(style({ display: 'block' }), 'Message', dom.br(), autoReplyBody = dom.textarea(attr.rows('6'), style({ width: '100%', maxWidth: '60em' }), autoReply.Body))), dom.div(style({ marginTop: '.5ex' }), dom.submitbutton('Save'))), async function submit(e) {
+		e.stopPropagation();
+		e.preventDefault();
+		const ar = {
+			ID: autoReply.ID,
+			Enabled: autoReplyEnabled.checked,
+			Start: autoReplyStart.value ? dateStart(autoReplyStart.value, 0) : null,
+			End: autoReplyEnd.value ? dateStart(autoReplyEnd.value, 1) : null,
+			Subject: autoReplySubject.value,
+			Body: autoReplyBody.value,
+		};
+		autoReplyFieldset.disabled = true;
+		try {
+			await client.AutoReplySave(ar);
+			window.alert('Automatic reply settings have been saved.');
+		}
+		catch (err) {
+			console.log({ err });
+			window.alert('Error: ' + errmsg(err));
+		}
+		finally {
+			autoReplyFieldset.disabled = false;
+		}
+	}), dom.br(), deleted.length === 0 ? [] : [
+		dom.h2('Deleted messages'),
+		dom.p('Messages that were deleted, e.g. by emptying the Trash mailbox, and can still be recovered. Messages are recovered to the mailbox they were deleted from, or to Inbox if that mailbox no longer exists.'),
+		dom.table(dom.thead(dom.tr(dom.th('Deleted'), dom.th('Mailbox'), dom.th('From'), dom.th('Subject'), dom.th('Action'))), dom.tbody(deleted.map(dm => {
+			const row = dom.tr(dom.td(dm.Expunged.toLocaleString()), dom.td(dm.MailboxName || '(removed)'), dom.td(dm.From), dom.td(dm.Subject), dom.td(dom.clickbutton('Recover', async function click(e) {
+				const b = e.target;
+				try {
+					b.disabled = true;
+					await client.DeletedRecover([dm.ID]);
+					row.remove();
+				}
+				catch (err) {
+					console.log({ err });
+					window.alert('Error: ' + errmsg(err));
+				}
+				finally {
+					b.disabled = false;
+				}
+			})));
+			return row;
+		}))),
+		dom.br(),
+	], dom.h2('Export'), dom.p('Export all messages in all mailboxes. In maildir or mbox format, as .zip or .tgz file.'), dom.table(dom._class('slim'), dom.tr(dom.td('Maildirs in .tgz'), dom.td(exportForm('mail-export-maildir.tgz'))), dom.tr(dom.td('Maildirs in .zip'), dom.td(exportForm('mail-export-maildir.zip'))), dom.tr(dom.td('Mbox files in .tgz'), dom.td(exportForm('mail-export-mbox.tgz'))), dom.tr(dom.td('Mbox files in .zip'), dom.td(exportForm('mail-export-mbox.zip')))), dom.br(), dom.h2('Import'), dom.p('Import messages from a .zip or .tgz file with maildirs and/or mbox files.'), importForm = dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		const request = async () => {
@@ -1019,6 +1328,8 @@ const index = async () => {
 		mailboxPrefixHint.style.display = '';
 	})), mailboxPrefixHint = dom.p(style({ display: 'none', fontStyle: 'italic', marginTop: '.5ex' }), 'If set, any mbox/maildir path with this prefix will have it stripped before importing. For example, if all mailboxes are in a directory "Takeout", specify that path in the field above so mailboxes like "Takeout/Inbox.mbox" are imported into a mailbox called "Inbox" instead of "Takeout/Inbox".')), dom.div(dom.submitbutton('Upload and import'), dom.p(style({ fontStyle: 'italic', marginTop: '.5ex' }), 'The file is uploaded first, then its messages are imported, finally messages are matched for threading. Importing is done in a transaction, you can abort the entire import before it is finished.')))), importAbortBox = dom.div(), // Outside fieldset because it gets disabled, above progress because may be scrolling it down quickly with problems.
 	importProgress = dom.div(style({ display: 'none' })), footer);
+	renderAppPasswords(appPasswords);
+	renderTOTP(totpEnabled, totpRecoveryCodes);
 	// Try to show the progress of an earlier import session. The user may have just
 	// refreshed the browser.
 	let importToken;
@@ -1081,11 +1392,12 @@ const destination = async (name) => {
 		};
 		let smtpMailFromRegexp;
 		let verifiedDomain;
+		let subaddressRegexp;
 		let isForward; // Checkbox
 		let listAllowDomain;
 		let acceptRejectsToMailbox;
 		let mailbox;
-		const root = dom.tr(dom.td(smtpMailFromRegexp = dom.input(attr.value(rs.SMTPMailFromRegexp || ''))), dom.td(verifiedDomain = dom.input(attr.value(rs.VerifiedDomain || ''))), headersCell, dom.td(dom.label(isForward = dom.input(attr.type('checkbox'), rs.IsForward ? attr.checked('') : []))), dom.td(listAllowDomain = dom.input(attr.value(rs.ListAllowDomain || ''))), dom.td(acceptRejectsToMailbox = dom.input(attr.value(rs.AcceptRejectsToMailbox || ''))), dom.td(mailbox = dom.input(attr.value(rs.Mailbox || ''))), dom.td(dom.clickbutton('Remove ruleset', function click() {
+		const root = dom.tr(dom.td(smtpMailFromRegexp = dom.input(attr.value(rs.SMTPMailFromRegexp || ''))), dom.td(verifiedDomain = dom.input(attr.value(rs.VerifiedDomain || ''))), dom.td(subaddressRegexp = dom.input(attr.value(rs.SubaddressRegexp || ''))), headersCell, dom.td(dom.label(isForward = dom.input(attr.type('checkbox'), rs.IsForward ? attr.checked('') : []))), dom.td(listAllowDomain = dom.input(attr.value(rs.ListAllowDomain || ''))), dom.td(acceptRejectsToMailbox = dom.input(attr.value(rs.AcceptRejectsToMailbox || ''))), dom.td(mailbox = dom.input(attr.value(rs.Mailbox || ''))), dom.td(dom.clickbutton('Remove ruleset', function click() {
 			row.root.remove();
 			rulesetsRows = rulesetsRows.filter(e => e !== row);
 		})));
@@ -1093,6 +1405,7 @@ const destination = async (name) => {
 			root: root,
 			smtpMailFromRegexp: smtpMailFromRegexp,
 			verifiedDomain: verifiedDomain,
+			subaddressRegexp: subaddressRegexp,
 			headers: [],
 			isForward: isForward,
 			listAllowDomain: listAllowDomain,
@@ -1115,12 +1428,15 @@ const destination = async (name) => {
 	});
 	let defaultMailbox;
 	let fullName;
+	let forwardTo;
+	let forwardKeepCopy;
 	let saveButton;
 	const addresses = [name, ...Object.keys(destinations).filter(a => !a.startsWith('@') && a !== name)];
-	dom._kids(page, crumbs(crumblink('Mox Account', '#'), 'Destination ' + name), dom.div(dom.span('Default mailbox', attr.title('Default mailbox where email for this recipient is delivered to if it does not match any ruleset. Default is Inbox.')), dom.br(), defaultMailbox = dom.input(attr.value(dest.Mailbox), attr.placeholder('Inbox'))), dom.br(), dom.div(dom.span('Full name', attr.title('Name to use in From header when composing messages. If not set, the account default full name is used.')), dom.br(), fullName = dom.input(attr.value(dest.FullName))), dom.br(), dom.h2('Rulesets'), dom.p('Incoming messages are checked against the rulesets. If a ruleset matches, the message is delivered to the mailbox configured for the ruleset instead of to the default mailbox.'), dom.p('"Is Forward" does not affect matching, but changes prevents the sending mail server from being included in future junk classifications by clearing fields related to the forwarding email server (IP address, EHLO domain, MAIL FROM domain and a matching DKIM domain), and prevents DMARC rejects for forwarded messages.'), dom.p('"List allow domain" does not affect matching, but skips the regular spam checks if one of the verified domains is a (sub)domain of the domain mentioned here.'), dom.p('"Accept rejects to mailbox" does not affect matching, but causes messages classified as junk to be accepted and delivered to this mailbox, instead of being rejected during the SMTP transaction. Useful for incoming forwarded messages where rejecting incoming messages may cause the forwarding server to stop forwarding.'), dom.table(dom.thead(dom.tr(dom.th('SMTP "MAIL FROM" regexp', attr.title('Matches if this regular expression matches (a substring of) the SMTP MAIL FROM address (not the message From-header). E.g. user@example.org.')), dom.th('Verified domain', attr.title('Matches if this domain matches an SPF- and/or DKIM-verified (sub)domain.')), dom.th('Headers regexp', attr.title('Matches if these header field/value regular expressions all match (substrings of) the message headers. Header fields and valuees are converted to lower case before matching. Whitespace is trimmed from the value before matching. A header field can occur multiple times in a message, only one instance has to match. For mailing lists, you could match on ^list-id$ with the value typically the mailing list address in angled brackets with @ replaced with a dot, e.g. <name\\.lists\\.example\\.org>.')), dom.th('Is Forward', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. Can only be used together with SMTPMailFromRegexp and VerifiedDomain. SMTPMailFromRegexp must be set to the address used to deliver the forwarded message, e.g. '^user(|\\+.*)@forward\\.example$'. Changes to junk analysis: 1. Messages are not rejected for failing a DMARC policy, because a legitimate forwarded message without valid/intact/aligned DKIM signature would be rejected because any verified SPF domain will be 'unaligned', of the forwarding mail server. 2. The sending mail server IP address, and sending EHLO and MAIL FROM domains and matching DKIM domain aren't used in future reputation-based spam classifications (but other verified DKIM domains are) because the forwarding server is not a useful spam signal for future messages.")), dom.th('List allow domain', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. If this domain matches an SPF- and/or DKIM-verified (sub)domain, the message is accepted without further spam checks, such as a junk filter or DMARC reject evaluation. DMARC rejects should not apply for mailing lists that are not configured to rewrite the From-header of messages that don't have a passing DKIM signature of the From-domain. Otherwise, by rejecting messages, you may be automatically unsubscribed from the mailing list. The assumption is that mailing lists do their own spam filtering/moderation.")), dom.th('Allow rejects to mailbox', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. If a message is classified as spam, it isn't rejected during the SMTP transaction (the normal behaviour), but accepted during the SMTP transaction and delivered to the specified mailbox. The specified mailbox is not automatically cleaned up like the account global Rejects mailbox, unless set to that Rejects mailbox.")), dom.th('Mailbox', attr.title('Mailbox to deliver to if this ruleset matches.')), dom.th('Action'))), rulesetsTbody, dom.tfoot(dom.tr(dom.td(attr.colspan('7')), dom.td(dom.clickbutton('Add ruleset', function click() {
+	dom._kids(page, crumbs(crumblink('Mox Account', '#'), 'Destination ' + name), dom.div(dom.span('Default mailbox', attr.title('Default mailbox where email for this recipient is delivered to if it does not match any ruleset. Default is Inbox.')), dom.br(), defaultMailbox = dom.input(attr.value(dest.Mailbox), attr.placeholder('Inbox'))), dom.br(), dom.div(dom.span('Full name', attr.title('Name to use in From header when composing messages. If not set, the account default full name is used.')), dom.br(), fullName = dom.input(attr.value(dest.FullName))), dom.br(), dom.div(dom.span('Forward to', attr.title('Forward incoming messages to these addresses, one per line. The envelope sender (SMTP MAIL FROM) of forwarded messages is rewritten with the Sender Rewriting Scheme (SRS), so SPF checks at the receiving mail server pass, and delivery status notifications are sent back to the original sender. Messages rejected as junk are not forwarded.')), dom.br(), forwardTo = dom.textarea(attr.rows('3'), style({ width: '100%', maxWidth: '30em' }), (dest.ForwardTo || []).join('\n'))), dom.div(dom.label(forwardKeepCopy = dom.input(attr.type('checkbox'), dest.ForwardKeepCopy ? attr.checked('') : []), ' Keep a copy of forwarded messages in this account')), dom.br(), dom.h2('Rulesets'), dom.p('Incoming messages are checked against the rulesets. If a ruleset matches, the message is delivered to the mailbox configured for the ruleset instead of to the default mailbox.'), dom.p('"Is Forward" does not affect matching, but changes prevents the sending mail server from being included in future junk classifications by clearing fields related to the forwarding email server (IP address, EHLO domain, MAIL FROM domain and a matching DKIM domain), and prevents DMARC rejects for forwarded messages.'), dom.p('"List allow domain" does not affect matching, but skips the regular spam checks if one of the verified domains is a (sub)domain of the domain mentioned here.'), dom.p('"Accept rejects to mailbox" does not affect matching, but causes messages classified as junk to be accepted and delivered to this mailbox, instead of being rejected during the SMTP transaction. Useful for incoming forwarded messages where rejecting incoming messages may cause the forwarding server to stop forwarding.'), dom.table(dom.thead(dom.tr(dom.th('SMTP "MAIL FROM" regexp', attr.title('Matches if this regular expression matches (a substring of) the SMTP MAIL FROM address (not the message From-header). E.g. user@example.org.')), dom.th('Verified domain', attr.title('Matches if this domain matches an SPF- and/or DKIM-verified (sub)domain.')), dom.th('Subaddress regexp', attr.title("Matches if this regular expression matches (a substring of) the subaddress of the recipient address, i.e. the part after the catchall separator of the domain, converted to lower case. E.g. '^shop$' for you+shop@example.com.")), dom.th('Headers regexp', attr.title('Matches if these header field/value regular expressions all match (substrings of) the message headers. Header fields and valuees are converted to lower case before matching. Whitespace is trimmed from the value before matching. A header field can occur multiple times in a message, only one instance has to match. For mailing lists, you could match on ^list-id$ with the value typically the mailing list address in angled brackets with @ replaced with a dot, e.g. <name\\.lists\\.example\\.org>.')), dom.th('Is Forward', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. Can only be used together with SMTPMailFromRegexp and VerifiedDomain. SMTPMailFromRegexp must be set to the address used to deliver the forwarded message, e.g. '^user(|\\+.*)@forward\\.example$'. Changes to junk analysis: 1. Messages are not rejected for failing a DMARC policy, because a legitimate forwarded message without valid/intact/aligned DKIM signature would be rejected because any verified SPF domain will be 'unaligned', of the forwarding mail server. 2. The sending mail server IP address, and sending EHLO and MAIL FROM domains and matching DKIM domain aren't used in future reputation-based spam classifications (but other verified DKIM domains are) because the forwarding server is not a useful spam signal for future messages.")), dom.th('List allow domain', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. If this domain matches an SPF- and/or DKIM-verified (sub)domain, the message is accepted without further spam checks, such as a junk filter or DMARC reject evaluation. DMARC rejects should not apply for mailing lists that are not configured to rewrite the From-header of messages that don't have a passing DKIM signature of the From-domain. Otherwise, by rejecting messages, you may be automatically unsubscribed from the mailing list. The assumption is that mailing lists do their own spam filtering/moderation.")), dom.th('Allow rejects to mailbox', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. If a message is classified as spam, it isn't rejected during the SMTP transaction (the normal behaviour), but accepted during the SMTP transaction and delivered to the specified mailbox. The specified mailbox is not automatically cleaned up like the account global Rejects mailbox, unless set to that Rejects mailbox.")), dom.th('Mailbox', attr.title('Mailbox to deliver to if this ruleset matches.')), dom.th('Action'))), rulesetsTbody, dom.tfoot(dom.tr(dom.td(attr.colspan('8')), dom.td(dom.clickbutton('Add ruleset', function click() {
 		addRulesetsRow({
 			SMTPMailFromRegexp: '',
 			VerifiedDomain: '',
+			SubaddressRegexp: '',
 			HeadersRegexp: {},
 			IsForward: false,
 			ListAllowDomain: '',
@@ -1135,10 +1451,13 @@ const destination = async (name) => {
 			const newDest = {
 				Mailbox: defaultMailbox.value,
 				FullName: fullName.value,
+				ForwardTo: forwardTo.value.split('\n').map(s => s.trim()).filter(s => s),
+				ForwardKeepCopy: forwardKeepCopy.checked,
 				Rulesets: rulesetsRows.map(row => {
 					return {
 						SMTPMailFromRegexp: row.smtpMailFromRegexp.value,
 						VerifiedDomain: row.verifiedDomain.value,
+						SubaddressRegexp: row.subaddressRegexp.value,
 						HeadersRegexp: Object.fromEntries(row.headers.map(h => [h.key.value, h.value.value])),
 						IsForward: row.isForward.checked,
 						ListAllowDomain: row.listAllowDomain.value,
@@ -12,6 +12,8 @@ const login = async (reason: string) => {
 		let autosize: HTMLElement
 		let username: HTMLInputElement
 		let password: HTMLInputElement
+		let totpBox: HTMLElement
+		let totpCode: HTMLInputElement
 
 		const root = dom.div(
 			style({position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in'}),
@@ -26,10 +28,11 @@ const login = async (reason: string) => {
 
 							reasonElem.remove()
 
+							let totpRequired = false
 							try {
 								fieldset.disabled = true
 								const loginToken = await client.LoginPrep()
-								const token = await client.Login(loginToken, username.value, password.value)
+								const token = await client.Login(loginToken, username.value, password.value, totpCode.value)
 								try {
 									window.localStorage.setItem('webaccountaddress', username.value)
 									window.localStorage.setItem('webaccountcsrftoken', token)
@@ -43,10 +46,20 @@ const login = async (reason: string) => {
 								resolve(token)
 							} catch (err) {
 								console.log('login error', err)
-								window.alert('Error: ' + errmsg(err))
+								if ((err as any).code === 'user:totpRequired') {
+									// Password is valid, a code for two-factor authentication is required too.
+									totpRequired = true
+								} else {
+									window.alert('Error: ' + errmsg(err))
+								}
 							} finally {
 								fieldset.disabled = false
 							}
+							if (totpRequired) {
+								totpBox.style.display = 'block'
+								totpCode.required = true
+								totpCode.focus()
+							}
 						},
 						fieldset=dom.fieldset(
 							dom.h1('Account'),
@@ -67,6 +80,11 @@ const login = async (reason: string) => {
 								dom.div('Password', style({marginBottom: '.5ex'})),
 								password=dom.input(attr.type('password'), attr.required('')),
 							),
+							totpBox=dom.label(
+								style({display: 'none', marginBottom: '2ex'}),
+								dom.div('Two-factor authentication code', style({marginBottom: '.5ex'})),
+								totpCode=dom.input(attr.autocomplete('one-time-code'), attr.title('Code from your authenticator app, or one of your recovery codes.')),
+							),
 							dom.div(
 								style({textAlign: 'center'}),
 								dom.submitbutton('Login'),
@@ -169,6 +187,10 @@ const blue = '#8bc8ff'
 
 const index = async () => {
 	const [accountFullName, domain, destinations] = await client.Account()
+	const deleted = await client.DeletedList() || []
+	const autoReply = await client.AutoReplyGet()
+	const appPasswords = await client.AppPasswords() || []
+	const [totpEnabled, totpRecoveryCodes] = await client.TOTPGet()
 
 	let fullNameForm: HTMLFormElement
 	let fullNameFieldset: HTMLFieldSetElement
@@ -178,6 +200,20 @@ const index = async () => {
 	let password1: HTMLInputElement
 	let password2: HTMLInputElement
 	let passwordHint: HTMLElement
+	let appPasswordsBody: HTMLElement
+	let appPasswordForm: HTMLFormElement
+	let appPasswordFieldset: HTMLFieldSetElement
+	let appPasswordName: HTMLInputElement
+	let appPasswordIMAP: HTMLInputElement
+	let appPasswordSubmission: HTMLInputElement
+	let appPasswordNew: HTMLElement
+	let totpBox: HTMLElement
+	let autoReplyFieldset: HTMLFieldSetElement
+	let autoReplyEnabled: HTMLInputElement
+	let autoReplyStart: HTMLInputElement
+	let autoReplyEnd: HTMLInputElement
+	let autoReplySubject: HTMLInputElement
+	let autoReplyBody: HTMLTextAreaElement
 
 	let importForm: HTMLFormElement
 	let importFieldset: HTMLFieldSetElement
@@ -186,6 +222,140 @@ const index = async () => {
 	let importProgress: HTMLElement
 	let importAbortBox: HTMLElement
 
+	const renderAppPasswords = (l: api.AppPassword[]) => {
+		dom._kids(appPasswordsBody,
+			l.length === 0 ? dom.tr(dom.td(attr.colspan('5'), 'No app passwords.')) : [],
+			l.map(ap => {
+				const row = dom.tr(
+					dom.td(ap.Name),
+					dom.td([ap.IMAP ? 'IMAP' : '', ap.Submission ? 'Submission' : ''].filter(s => s).join(', ')),
+					dom.td(ap.Created.toLocaleString()),
+					// Zero time if never used.
+					dom.td(ap.LastUsed.getUTCFullYear() <= 1 ? 'Never' : ap.LastUsed.toLocaleString()),
+					dom.td(
+						dom.clickbutton('Remove', async function click(e: MouseEvent) {
+							if (!window.confirm('Are you sure you want to remove app password "' + ap.Name + '"? Email clients using it will no longer be able to log in.')) {
+								return
+							}
+							const b = e.target! as HTMLButtonElement
+							try {
+								b.disabled = true
+								await client.AppPasswordRemove(ap.ID)
+								renderAppPasswords(await client.AppPasswords() || [])
+							} catch (err) {
+								console.log({err})
+								window.alert('Error: ' + errmsg(err))
+							} finally {
+								b.disabled = false
+							}
+						}),
+					),
+				)
+				return row
+			}),
+		)
+	}
+
+	// If recoveryCodes is set, two-factor authentication was just enabled and the new
+	// recovery codes are shown.
+	const renderTOTP = (enabled: boolean, remaining: number, recoveryCodes?: string[]) => {
+		if (enabled) {
+			dom._kids(totpBox,
+				recoveryCodes ? box(blue,
+					'Two-factor authentication has been enabled. Store these recovery codes in a safe place now, they will not be shown again. Each can be used once instead of a code from your authenticator app, e.g. when you lose your device.',
+					dom.ul(recoveryCodes.map(c => dom.li(dom.span(style({fontFamily: 'monospace', fontWeight: 'bold'}), c)))),
+				) : [],
+				dom.p('Two-factor authentication is enabled. Recovery codes left: ' + remaining + '.'),
+				dom.clickbutton('Disable two-factor authentication', async function click(e: MouseEvent) {
+					const code = window.prompt('To disable two-factor authentication, enter a code from your authenticator app, a recovery code, or your password. Logins to the web interface will then only require your password.')
+					if (!code) {
+						return
+					}
+					const b = e.target! as HTMLButtonElement
+					try {
+						b.disabled = true
+						await client.TOTPDisable(code)
+						renderTOTP(false, 0)
+					} catch (err) {
+						console.log({err})
+						window.alert('Error: ' + errmsg(err))
+					} finally {
+						b.disabled = false
+					}
+				}),
+			)
+			return
+		}
+
+		dom._kids(totpBox,
+			dom.p('Two-factor authentication is not enabled.'),
+			dom.clickbutton('Enable two-factor authentication', async function click(e: MouseEvent) {
+				const b = e.target! as HTMLButtonElement
+				try {
+					b.disabled = true
+					const [key, uri, qrcode] = await client.TOTPEnrollStart()
+					renderTOTPEnroll(key, uri, qrcode)
+				} catch (err) {
+					console.log({err})
+					window.alert('Error: ' + errmsg(err))
+				} finally {
+					b.disabled = false
+				}
+			}),
+		)
+	}
+
+	const renderTOTPEnroll = (key: string, uri: string, qrcode: string) => {
+		let fieldset: HTMLFieldSetElement
+		let code: HTMLInputElement
+		dom._kids(totpBox,
+			dom.p('Scan the QR code with your authenticator app, or enter the key manually. Then enter the code shown by the app to enable two-factor authentication.'),
+			dom.img(attr.src(qrcode), attr.title(uri)),
+			dom.div('Key: ', dom.span(style({fontFamily: 'monospace'}), key)),
+			dom.br(),
+			dom.form(
+				fieldset=dom.fieldset(
+					dom.label(
+						style({display: 'inline-block'}),
+						'Code',
+						dom.br(),
+						code=dom.input(attr.required(''), attr.autocomplete('one-time-code')),
+					),
+					' ',
+					dom.submitbutton('Enable'),
+					' ',
+					dom.clickbutton('Cancel', function click() {
+						renderTOTP(false, 0)
+					}),
+				),
+				async function submit(e: SubmitEvent) {
+					e.stopPropagation()
+					e.preventDefault()
+					fieldset.disabled = true
+					try {
+						const codes = await client.TOTPEnrollFinish(code.value) || []
+						renderTOTP(true, codes.length, codes)
+					} catch (err) {
+						console.log({err})
+						window.alert('Error: ' + errmsg(err))
+					} finally {
+						fieldset.disabled = false
+					}
+				},
+			),
+		)
+		code.focus()
+	}
+
+	// Local date as used in date input fields, yyyy-mm-dd.
+	const dateString = (d: Date) => [d.getFullYear(), d.getMonth()+1, d.getDate()].map(v => v < 10 ? '0'+v : ''+v).join('-')
+	// Start of day for date from date input field, in local time, optionally for a later day.
+	const dateStart = (s: string, days: number) => {
+		const d = new Date(s+'T00:00:00')
+		d.setDate(d.getDate()+days)
+		return d
+	}
+
 	const importTrack = async (token: string) => {
 		const importConnection = dom.div('Waiting for updates...')
 		importProgress.appendChild(importConnection)
@@ -414,6 +584,162 @@ const index = async () => {
 			},
 		),
 		dom.br(),
+		dom.h2('App passwords'),
+		dom.p('App passwords are generated passwords to configure in email clients instead of your account password. Each is valid for IMAP and/or SMTP submission, and can be removed to revoke access for a single client. App passwords cannot be used to log in to the web interface.'),
+		dom.table(
+			dom.thead(
+				dom.tr(dom.th('Name'), dom.th('Valid for'), dom.th('Created'), dom.th('Last used'), dom.th('Action')),
+			),
+			appPasswordsBody=dom.tbody(),
+		),
+		dom.br(),
+		appPasswordForm=dom.form(
+			appPasswordFieldset=dom.fieldset(
+				dom.label(
+					style({display: 'inline-block'}),
+					'Name',
+					dom.br(),
+					appPasswordName=dom.input(attr.required(''), attr.placeholder('e.g. phone')),
+				),
+				' ',
+				dom.label(appPasswordIMAP=dom.input(attr.type('checkbox'), attr.checked('')), ' IMAP'),
+				' ',
+				dom.label(appPasswordSubmission=dom.input(attr.type('checkbox'), attr.checked('')), ' Submission'),
+				' ',
+				dom.submitbutton('Add app password'),
+			),
+			appPasswordNew=dom.div(),
+			async function submit(e: SubmitEvent) {
+				e.stopPropagation()
+				e.preventDefault()
+				appPasswordFieldset.disabled = true
+				try {
+					const [ap, password] = await client.AppPasswordAdd(appPasswordName.value, appPasswordIMAP.checked, appPasswordSubmission.checked)
+					appPasswordForm.reset()
+					dom._kids(appPasswordNew,
+						box(blue,
+							'App password "' + ap.Name + '" has been added. Configure it in your email client now, it will not be shown again: ',
+							dom.span(style({fontFamily: 'monospace', fontWeight: 'bold'}), password),
+						),
+					)
+					renderAppPasswords(await client.AppPasswords() || [])
+				} catch (err) {
+					console.log({err})
+					window.alert('Error: ' + errmsg(err))
+				} finally {
+					appPasswordFieldset.disabled = false
+				}
+			},
+		),
+		dom.br(),
+		dom.h2('Two-factor authentication'),
+		dom.p('With two-factor authentication, logging in to the web interface requires a code from an authenticator app on your phone in addition to your password. It does not apply to email clients, use app passwords for those.'),
+		totpBox=dom.div(),
+		dom.br(),
+		dom.h2('Automatic reply'),
+		dom.p('Send an automatic reply to incoming messages, e.g. while on vacation or out of office. Each sender gets at most one reply per week. No replies are sent to mailing lists, junk, delivery status notifications and reports.'),
+		dom.form(
+			autoReplyFieldset=dom.fieldset(
+				dom.label(
+					autoReplyEnabled=dom.input(attr.type('checkbox'), autoReply.Enabled ? attr.checked('') : []),
+					' Enabled',
+				),
+				dom.div(
+					style({marginTop: '.5ex'}),
+					dom.label(
+						style({display: 'inline-block'}),
+						'First day (optional)',
+						dom.br(),
+						autoReplyStart=dom.input(attr.type('date'), attr.value(autoReply.Start ? dateString(autoReply.Start) : '')),
+					),
+					' ',
+					dom.label(
+						style({display: 'inline-block'}),
+						'Last day (optional)',
+						dom.br(),
+						autoReplyEnd=dom.input(attr.type('date'), attr.value(autoReply.End ? dateString(new Date(autoReply.End.getTime()-1)) : '')),
+					),
+				),
+				dom.div(
+					style({marginTop: '.5ex'}),
+					dom.label(
+						style({display: 'inline-block'}),
+						'Subject (optional)',
+						dom.br(),
+						autoReplySubject=dom.input(attr.value(autoReply.Subject), attr.placeholder('Auto: <original subject>')),
+					),
+				),
+				dom.div(
+					style({marginTop: '.5ex'}),
+					dom.label(
+						style({display: 'block'}),
+						'Message',
+						dom.br(),
+						autoReplyBody=dom.textarea(attr.rows('6'), style({width: '100%', maxWidth: '60em'}), autoReply.Body),
+					),
+				),
+				dom.div(style({marginTop: '.5ex'}), dom.submitbutton('Save')),
+			),
+			async function submit(e: SubmitEvent) {
+				e.stopPropagation()
+				e.preventDefault()
+				const ar: api.AutoReply = {
+					ID: autoReply.ID,
+					Enabled: autoReplyEnabled.checked,
+					Start: autoReplyStart.value ? dateStart(autoReplyStart.value, 0) : null,
+					End: autoReplyEnd.value ? dateStart(autoReplyEnd.value, 1) : null,
+					Subject: autoReplySubject.value,
+					Body: autoReplyBody.value,
+				}
+				autoReplyFieldset.disabled = true
+				try {
+					await client.AutoReplySave(ar)
+					window.alert('Automatic reply settings have been saved.')
+				} catch (err) {
+					console.log({err})
+					window.alert('Error: ' + errmsg(err))
+				} finally {
+					autoReplyFieldset.disabled = false
+				}
+			},
+		),
+		dom.br(),
+		deleted.length === 0 ? [] : [
+			dom.h2('Deleted messages'),
+			dom.p('Messages that were deleted, e.g. by emptying the Trash mailbox, and can still be recovered. Messages are recovered to the mailbox they were deleted from, or to Inbox if that mailbox no longer exists.'),
+			dom.table(
+				dom.thead(
+					dom.tr(dom.th('Deleted'), dom.th('Mailbox'), dom.th('From'), dom.th('Subject'), dom.th('Action')),
+				),
+				dom.tbody(
+					deleted.map(dm => {
+						const row = dom.tr(
+							dom.td(dm.Expunged.toLocaleString()),
+							dom.td(dm.MailboxName || '(removed)'),
+							dom.td(dm.From),
+							dom.td(dm.Subject),
+							dom.td(
+								dom.clickbutton('Recover', async function click(e: MouseEvent) {
+									const b = e.target! as HTMLButtonElement
+									try {
+										b.disabled = true
+										await client.DeletedRecover([dm.ID])
+										row.remove()
+									} catch (err) {
+										console.log({err})
+										window.alert('Error: ' + errmsg(err))
+									} finally {
+										b.disabled = false
+									}
+								}),
+							),
+						)
+						return row
+					}),
+				),
+			),
+			dom.br(),
+		],
 		dom.h2('Export'),
 		dom.p('Export all messages in all mailboxes. In maildir or mbox format, as .zip or .tgz file.'),
 		dom.table(dom._class('slim'),
@@ -536,6 +862,8 @@ const index = async () => {
 		),
 		footer,
 	)
+	renderAppPasswords(appPasswords)
+	renderTOTP(totpEnabled, totpRecoveryCodes)
 
 	// Try to show the progress of an earlier import session. The user may have just
 	// refreshed the browser.
@@ -588,6 +916,7 @@ const destination = async (name: string) => {
 
 		smtpMailFromRegexp: HTMLInputElement
 		verifiedDomain: HTMLInputElement
+		subaddressRegexp: HTMLInputElement
 		headers: Header[]
 		isForward: HTMLInputElement // Checkbox
 		listAllowDomain: HTMLInputElement
@@ -635,6 +964,7 @@ const destination = async (name: string) => {
 
 		let smtpMailFromRegexp: HTMLInputElement
 		let verifiedDomain: HTMLInputElement
+		let subaddressRegexp: HTMLInputElement
 		let isForward: HTMLInputElement // Checkbox
 		let listAllowDomain: HTMLInputElement
 		let acceptRejectsToMailbox: HTMLInputElement
@@ -643,6 +973,7 @@ const destination = async (name: string) => {
 		const root = dom.tr(
 			dom.td(smtpMailFromRegexp=dom.input(attr.value(rs.SMTPMailFromRegexp || ''))),
 			dom.td(verifiedDomain=dom.input(attr.value(rs.VerifiedDomain || ''))),
+			dom.td(subaddressRegexp=dom.input(attr.value(rs.SubaddressRegexp || ''))),
 			headersCell,
 			dom.td(dom.label(isForward=dom.input(attr.type('checkbox'), rs.IsForward ? attr.checked('') : [] ))),
 			dom.td(listAllowDomain=dom.input(attr.value(rs.ListAllowDomain || ''))),
@@ -659,6 +990,7 @@ const destination = async (name: string) => {
 			root: root,
 			smtpMailFromRegexp: smtpMailFromRegexp,
 			verifiedDomain: verifiedDomain,
+			subaddressRegexp: subaddressRegexp,
 			headers: [],
 			isForward: isForward,
 			listAllowDomain: listAllowDomain,
@@ -687,6 +1019,8 @@ const destination = async (name: string) => {
 
 	let defaultMailbox: HTMLInputElement
 	let fullName: HTMLInputElement
+	let forwardTo: HTMLTextAreaElement
+	let forwardKeepCopy: HTMLInputElement
 	let saveButton: HTMLButtonElement
 
 	const addresses = [name, ...Object.keys(destinations).filter(a => !a.startsWith('@') && a !== name)]
@@ -708,6 +1042,18 @@ const destination = async (name: string) => {
 			fullName=dom.input(attr.value(dest.FullName)),
 		),
 		dom.br(),
+		dom.div(
+			dom.span('Forward to', attr.title('Forward incoming messages to these addresses, one per line. The envelope sender (SMTP MAIL FROM) of forwarded messages is rewritten with the Sender Rewriting Scheme (SRS), so SPF checks at the receiving mail server pass, and delivery status notifications are sent back to the original sender. Messages rejected as junk are not forwarded.')),
+			dom.br(),
+			forwardTo=dom.textarea(attr.rows('3'), style({width: '100%', maxWidth: '30em'}), (dest.ForwardTo || []).join('\n')),
+		),
+		dom.div(
+			dom.label(
+				forwardKeepCopy=dom.input(attr.type('checkbox'), dest.ForwardKeepCopy ? attr.checked('') : []),
+				' Keep a copy of forwarded messages in this account',
+			),
+		),
+		dom.br(),
 		dom.h2('Rulesets'),
 		dom.p('Incoming messages are checked against the rulesets. If a ruleset matches, the message is delivered to the mailbox configured for the ruleset instead of to the default mailbox.'),
 		dom.p('"Is Forward" does not affect matching, but changes prevents the sending mail server from being included in future junk classifications by clearing fields related to the forwarding email server (IP address, EHLO domain, MAIL FROM domain and a matching DKIM domain), and prevents DMARC rejects for forwarded messages.'),
@@ -718,6 +1064,7 @@ const destination = async (name: string) => {
 				dom.tr(
 					dom.th('SMTP "MAIL FROM" regexp', attr.title('Matches if this regular expression matches (a substring of) the SMTP MAIL FROM address (not the message From-header). E.g. user@example.org.')),
 					dom.th('Verified domain', attr.title('Matches if this domain matches an SPF- and/or DKIM-verified (sub)domain.')),
+					dom.th('Subaddress regexp', attr.title("Matches if this regular expression matches (a substring of) the subaddress of the recipient address, i.e. the part after the catchall separator of the domain, converted to lower case. E.g. '^shop$' for you+shop@example.com.")),
 					dom.th('Headers regexp', attr.title('Matches if these header field/value regular expressions all match (substrings of) the message headers. Header fields and valuees are converted to lower case before matching. Whitespace is trimmed from the value before matching. A header field can occur multiple times in a message, only one instance has to match. For mailing lists, you could match on ^list-id$ with the value typically the mailing list address in angled brackets with @ replaced with a dot, e.g. <name\\.lists\\.example\\.org>.')),
 					dom.th('Is Forward', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. Can only be used together with SMTPMailFromRegexp and VerifiedDomain. SMTPMailFromRegexp must be set to the address used to deliver the forwarded message, e.g. '^user(|\\+.*)@forward\\.example$'. Changes to junk analysis: 1. Messages are not rejected for failing a DMARC policy, because a legitimate forwarded message without valid/intact/aligned DKIM signature would be rejected because any verified SPF domain will be 'unaligned', of the forwarding mail server. 2. The sending mail server IP address, and sending EHLO and MAIL FROM domains and matching DKIM domain aren't used in future reputation-based spam classifications (but other verified DKIM domains are) because the forwarding server is not a useful spam signal for future messages.")),
 					dom.th('List allow domain', attr.title("Influences spam filtering only, this option does not change whether a message matches this ruleset. If this domain matches an SPF- and/or DKIM-verified (sub)domain, the message is accepted without further spam checks, such as a junk filter or DMARC reject evaluation. DMARC rejects should not apply for mailing lists that are not configured to rewrite the From-header of messages that don't have a passing DKIM signature of the From-domain. Otherwise, by rejecting messages, you may be automatically unsubscribed from the mailing list. The assumption is that mailing lists do their own spam filtering/moderation.")),
@@ -729,12 +1076,13 @@ const destination = async (name: string) => {
 			rulesetsTbody,
 			dom.tfoot(
 				dom.tr(
-					dom.td(attr.colspan('7')),
+					dom.td(attr.colspan('8')),
 					dom.td(
 						dom.clickbutton('Add ruleset', function click() {
 							addRulesetsRow({
 								SMTPMailFromRegexp: '',
 								VerifiedDomain: '',
+								SubaddressRegexp: '',
 								HeadersRegexp: {},
 								IsForward: false,
 								ListAllowDomain: '',
@@ -755,10 +1103,13 @@ const destination = async (name: string) => {
 				const newDest = {
 					Mailbox: defaultMailbox.value,
 					FullName: fullName.value,
+					ForwardTo: forwardTo.value.split('\n').map(s => s.trim()).filter(s => s),
+					ForwardKeepCopy: forwardKeepCopy.checked,
 					Rulesets: rulesetsRows.map(row => {
 						return {
 							SMTPMailFromRegexp: row.smtpMailFromRegexp.value,
 							VerifiedDomain: row.verifiedDomain.value,
+							SubaddressRegexp: row.subaddressRegexp.value,
 							HeadersRegexp: Object.fromEntries(row.headers.map(h => [h.key.value, h.value.value])),
 							IsForward: row.isForward.checked,
 							ListAllowDomain: row.listAllowDomain.value,
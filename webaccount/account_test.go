@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/base32"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -20,6 +21,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/mjl-/bstore"
 	"github.com/mjl-/sherpa"
@@ -27,6 +29,7 @@ import (
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/totp"
 	"github.com/mjl-/mox/webauth"
 )
 
@@ -99,14 +102,14 @@ func TestAccount(t *testing.T) {
 	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	// Missing login token.
-	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "mjl@mox.example", "test1234") })
+	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "mjl@mox.example", "test1234", "") })
 
 	// Login with loginToken.
 	loginCookie := &http.Cookie{Name: "webaccountlogin"}
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
 
-	csrfToken := api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234")
+	csrfToken := api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234", "")
 	var sessionCookie *http.Cookie
 	for _, c := range respRec.Result().Cookies() {
 		if c.Name == "webaccountsession" {
@@ -121,9 +124,9 @@ func TestAccount(t *testing.T) {
 	// Valid loginToken, but bad credentials.
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "mjl@mox.example", "badauth") })
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@mox.example", "badauth") })
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@baddomain.example", "badauth") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "mjl@mox.example", "badauth", "") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@mox.example", "badauth", "") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@baddomain.example", "badauth", "") })
 
 	type httpHeaders [][2]string
 	ctJSON := [2]string{"Content-Type", "application/json; charset=utf-8"}
@@ -219,9 +222,78 @@ func TestAccount(t *testing.T) {
 	fullName, _, dests := api.Account(ctx)
 	api.DestinationSave(ctx, "mjl@mox.example", dests["mjl@mox.example"], dests["mjl@mox.example"]) // todo: save modified value and compare it afterwards
 
+	fwdDest := dests["mjl@mox.example"]
+	fwdDest.ForwardTo = []string{"other@remote.example"}
+	api.DestinationSave(ctx, "mjl@mox.example", dests["mjl@mox.example"], fwdDest)
+	_, _, fwdDests := api.Account(ctx)
+	if l := fwdDests["mjl@mox.example"].ForwardTo; len(l) != 1 || l[0] != "other@remote.example" {
+		t.Fatalf("got forward addresses %v, expected other@remote.example", l)
+	}
+	api.DestinationSave(ctx, "mjl@mox.example", fwdDest, dests["mjl@mox.example"])
+	fwdDest.ForwardTo = []string{"bogus"}
+	tneedErrorCode(t, "user:error", func() { api.DestinationSave(ctx, "mjl@mox.example", dests["mjl@mox.example"], fwdDest) })
+
 	api.AccountSaveFullName(ctx, fullName+" changed") // todo: check if value was changed
 	api.AccountSaveFullName(ctx, fullName)
 
+	api.AutoReplySave(ctx, store.AutoReply{Enabled: true, Subject: "Away", Body: "I'm away."})
+	ar := api.AutoReplyGet(ctx)
+	if !ar.Enabled || ar.Body != "I'm away." {
+		t.Fatalf("got auto reply %#v, expected enabled with body", ar)
+	}
+	tneedErrorCode(t, "user:error", func() { api.AutoReplySave(ctx, store.AutoReply{Enabled: true}) })
+
+	ap, apPassword := api.AppPasswordAdd(ctx, "phone", true, false)
+	if ap.Name != "phone" || apPassword == "" {
+		t.Fatalf("got app password %#v, %q, expected name phone and password", ap, apPassword)
+	}
+	tneedErrorCode(t, "user:error", func() { api.AppPasswordAdd(ctx, "phone", true, true) })
+	tneedErrorCode(t, "user:error", func() { api.AppPasswordAdd(ctx, "laptop", false, false) })
+	if l := api.AppPasswords(ctx); len(l) != 1 || l[0].ID != ap.ID {
+		t.Fatalf("got app passwords %v, expected one", l)
+	}
+	api.AppPasswordRemove(ctx, ap.ID)
+	tneedErrorCode(t, "user:error", func() { api.AppPasswordRemove(ctx, ap.ID) })
+
+	// Enable two-factor authentication.
+	if enabled, _ := api.TOTPGet(ctx); enabled {
+		t.Fatalf("two-factor authentication enabled, expected disabled")
+	}
+	key, _, qrcode := api.TOTPEnrollStart(ctx)
+	if !strings.HasPrefix(qrcode, "data:image/png;base64,") {
+		t.Fatalf("got qrcode %q, expected png data url", qrcode)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(key)
+	tcheck(t, err, "decode totp key")
+	counter := totp.Counter(time.Now())
+	tneedErrorCode(t, "user:error", func() { api.TOTPEnrollFinish(ctx, totp.Code(secret, counter+10)) })
+	recoveryCodes := api.TOTPEnrollFinish(ctx, totp.Code(secret, counter-1))
+	if enabled, n := api.TOTPGet(ctx); !enabled || n != len(recoveryCodes) {
+		t.Fatalf("got enabled %v with %d recovery codes, expected enabled with %d", enabled, n, len(recoveryCodes))
+	}
+	// Current session is kept.
+	_, err = store.SessionUse(ctx, log, "mjl", sessionToken, "")
+	tcheck(t, err, "use session after enabling two-factor authentication")
+
+	// Logins now need a code.
+	totpLogin := func(code string) {
+		t.Helper()
+		loginCookie.Value = api.LoginPrep(ctx)
+		reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
+		api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234", code)
+	}
+	tneedErrorCode(t, "user:totpRequired", func() { totpLogin("") })
+	tneedErrorCode(t, "user:loginFailed", func() { totpLogin(totp.Code(secret, counter+10)) })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "mjl@mox.example", "badauth", totp.Code(secret, counter)) })
+	totpLogin(totp.Code(secret, counter))
+	tneedErrorCode(t, "user:loginFailed", func() { totpLogin(totp.Code(secret, counter)) }) // Replay.
+	totpLogin(recoveryCodes[0])
+	tneedErrorCode(t, "user:loginFailed", func() { totpLogin(recoveryCodes[0]) })
+
+	tneedErrorCode(t, "user:error", func() { api.TOTPDisable(ctx, "") })
+	api.TOTPDisable(ctx, recoveryCodes[1])
+	totpLogin("")
+
 	go ImportManage()
 
 	// Import mbox/maildir tgz/zip.
@@ -15,11 +15,14 @@ export interface Destination {
 	Mailbox: string
 	Rulesets?: Ruleset[] | null
 	FullName: string
+	ForwardTo?: string[] | null
+	ForwardKeepCopy: boolean
 }
 
 export interface Ruleset {
 	SMTPMailFromRegexp: string
 	VerifiedDomain: string
+	SubaddressRegexp: string
 	HeadersRegexp?: { [key: string]: string }
 	IsForward: boolean  // todo: once we implement ARC, we can use dkim domains that we cannot verify but that the arc-verified forwarding mail server was able to verify.
 	ListAllowDomain: string
@@ -29,6 +32,64 @@ export interface Ruleset {
 	ListAllowDNSDomain: Domain
 }
 
+// DeletedMessage is a message that was expunged, e.g. by emptying the Trash
+// mailbox, but whose message file is kept for the period configured in the
+// account's KeepDeletedPeriod, so it can be recovered.
+export interface DeletedMessage {
+	ID: number  // Same as Message.ID of the expunged message, its message file is kept.
+	Expunged: Date
+	MailboxID: number  // Mailbox the message was expunged from, and is recovered to. If the mailbox no longer exists, the message is recovered to Inbox.
+	MailboxName: string
+	Received: Date  // Fields from the expunged message, to recover it.
+	Size: number
+	MsgPrefix?: string | null
+	Seen: boolean
+	Answered: boolean
+	Flagged: boolean
+	Forwarded: boolean
+	Junk: boolean
+	Notjunk: boolean
+	Deleted: boolean
+	Draft: boolean
+	Phishing: boolean
+	MDNSent: boolean
+	Keywords?: string[] | null
+	FileCompressed: boolean
+	FileEncrypted: boolean
+	Subject: string  // From the parsed message, to show to users.
+	From: string
+}
+
+// AutoReply holds the settings for the vacation/out-of-office auto-responder of
+// an account, managed through the account web interface. There is at most one,
+// with ID 1.
+export interface AutoReply {
+	ID: number
+	Enabled: boolean
+	Start?: Date | null  // If set, no replies are sent before this time.
+	End?: Date | null  // If set, no replies are sent after this time.
+	Subject: string  // If empty, the subject of the incoming message prefixed with "Auto: ".
+	Body: string  // Plain text.
+}
+
+// AppPassword is an application-specific password for an account, to configure
+// in an email client instead of the account password, so access can be revoked
+// per client. App passwords are generated by mox, are only valid for IMAP and/or
+// SMTP submission, and cannot be used to log in to the web interfaces.
+// 
+// The SCRAM secrets are derived with the salts of the account password, so SCRAM
+// authentication can be attempted with all credentials of an account. App
+// passwords cannot unlock the encryption key of an account with encrypted
+// messages, a login with the account password is needed for that.
+export interface AppPassword {
+	ID: number
+	Name: string
+	IMAP: boolean  // Valid for IMAP and ManageSieve.
+	Submission: boolean  // Valid for SMTP submission.
+	Created: Date
+	LastUsed: Date  // Zero if never used. Updated at most once per minute.
+}
+
 // ImportProgress is returned after uploading a file to import.
 export interface ImportProgress {
 	Token: string  // For fetching progress, or cancelling an import.
@@ -36,13 +97,16 @@ export interface ImportProgress {
 
 export type CSRFToken = string
 
-export const structTypes: {[typename: string]: boolean} = {"Destination":true,"Domain":true,"ImportProgress":true,"Ruleset":true}
+export const structTypes: {[typename: string]: boolean} = {"AppPassword":true,"AutoReply":true,"DeletedMessage":true,"Destination":true,"Domain":true,"ImportProgress":true,"Ruleset":true}
 export const stringsTypes: {[typename: string]: boolean} = {"CSRFToken":true}
 export const intsTypes: {[typename: string]: boolean} = {}
 export const types: TypenameMap = {
 	"Domain": {"Name":"Domain","Docs":"","Fields":[{"Name":"ASCII","Docs":"","Typewords":["string"]},{"Name":"Unicode","Docs":"","Typewords":["string"]}]},
-	"Destination": {"Name":"Destination","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Rulesets","Docs":"","Typewords":["[]","Ruleset"]},{"Name":"FullName","Docs":"","Typewords":["string"]}]},
-	"Ruleset": {"Name":"Ruleset","Docs":"","Fields":[{"Name":"SMTPMailFromRegexp","Docs":"","Typewords":["string"]},{"Name":"VerifiedDomain","Docs":"","Typewords":["string"]},{"Name":"HeadersRegexp","Docs":"","Typewords":["{}","string"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"ListAllowDomain","Docs":"","Typewords":["string"]},{"Name":"AcceptRejectsToMailbox","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"VerifiedDNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"ListAllowDNSDomain","Docs":"","Typewords":["Domain"]}]},
+	"Destination": {"Name":"Destination","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Rulesets","Docs":"","Typewords":["[]","Ruleset"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"ForwardTo","Docs":"","Typewords":["[]","string"]},{"Name":"ForwardKeepCopy","Docs":"","Typewords":["bool"]}]},
+	"Ruleset": {"Name":"Ruleset","Docs":"","Fields":[{"Name":"SMTPMailFromRegexp","Docs":"","Typewords":["string"]},{"Name":"VerifiedDomain","Docs":"","Typewords":["string"]},{"Name":"SubaddressRegexp","Docs":"","Typewords":["string"]},{"Name":"HeadersRegexp","Docs":"","Typewords":["{}","string"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"ListAllowDomain","Docs":"","Typewords":["string"]},{"Name":"AcceptRejectsToMailbox","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"VerifiedDNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"ListAllowDNSDomain","Docs":"","Typewords":["Domain"]}]},
+	"DeletedMessage": {"Name":"DeletedMessage","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Expunged","Docs":"","Typewords":["timestamp"]},{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"MsgPrefix","Docs":"","Typewords":["nullable","string"]},{"Name":"Seen","Docs":"","Typewords":["bool"]},{"Name":"Answered","Docs":"","Typewords":["bool"]},{"Name":"Flagged","Docs":"","Typewords":["bool"]},{"Name":"Forwarded","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Notjunk","Docs":"","Typewords":["bool"]},{"Name":"Deleted","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Phishing","Docs":"","Typewords":["bool"]},{"Name":"MDNSent","Docs":"","Typewords":["bool"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"FileCompressed","Docs":"","Typewords":["bool"]},{"Name":"FileEncrypted","Docs":"","Typewords":["bool"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["string"]}]},
+	"AutoReply": {"Name":"AutoReply","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Enabled","Docs":"","Typewords":["bool"]},{"Name":"Start","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"End","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"Body","Docs":"","Typewords":["string"]}]},
+	"AppPassword": {"Name":"AppPassword","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"IMAP","Docs":"","Typewords":["bool"]},{"Name":"Submission","Docs":"","Typewords":["bool"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"LastUsed","Docs":"","Typewords":["timestamp"]}]},
 	"ImportProgress": {"Name":"ImportProgress","Docs":"","Fields":[{"Name":"Token","Docs":"","Typewords":["string"]}]},
 	"CSRFToken": {"Name":"CSRFToken","Docs":"","Values":null},
 }
@@ -51,6 +115,9 @@ export const parser = {
 	Domain: (v: any) => parse("Domain", v) as Domain,
 	Destination: (v: any) => parse("Destination", v) as Destination,
 	Ruleset: (v: any) => parse("Ruleset", v) as Ruleset,
+	DeletedMessage: (v: any) => parse("DeletedMessage", v) as DeletedMessage,
+	AutoReply: (v: any) => parse("AutoReply", v) as AutoReply,
+	AppPassword: (v: any) => parse("AppPassword", v) as AppPassword,
 	ImportProgress: (v: any) => parse("ImportProgress", v) as ImportProgress,
 	CSRFToken: (v: any) => parse("CSRFToken", v) as CSRFToken,
 }
@@ -96,12 +163,15 @@ export class Client {
 	}
 
 	// Login returns a session token for the credentials, or fails with error code
-	// "user:badLogin". Call LoginPrep to get a loginToken.
-	async Login(loginToken: string, username: string, password: string): Promise<CSRFToken> {
+	// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+	// authentication is enabled, totpCode must be a code from the authenticator app
+	// or a recovery code, the call fails with error code "user:totpRequired" if it is
+	// empty.
+	async Login(loginToken: string, username: string, password: string, totpCode: string): Promise<CSRFToken> {
 		const fn: string = "Login"
-		const paramTypes: string[][] = [["string"],["string"],["string"]]
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"]]
 		const returnTypes: string[][] = [["CSRFToken"]]
-		const params: any[] = [loginToken, username, password]
+		const params: any[] = [loginToken, username, password, totpCode]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
 	}
 
@@ -156,6 +226,126 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// DeletedList returns the messages that were deleted, e.g. by emptying the Trash
+	// mailbox, but can still be recovered, most recently deleted first. Messages are
+	// only kept if the account is configured with a KeepDeletedPeriod.
+	async DeletedList(): Promise<DeletedMessage[] | null> {
+		const fn: string = "DeletedList"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","DeletedMessage"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as DeletedMessage[] | null
+	}
+
+	// DeletedRecover recovers deleted messages, adding them to the mailbox they were
+	// deleted from, or Inbox if that mailbox no longer exists. The number of recovered
+	// messages is returned.
+	async DeletedRecover(messageIDs: number[] | null): Promise<number> {
+		const fn: string = "DeletedRecover"
+		const paramTypes: string[][] = [["[]","int64"]]
+		const returnTypes: string[][] = [["int32"]]
+		const params: any[] = [messageIDs]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as number
+	}
+
+	// AutoReplyGet returns the settings for the vacation/out-of-office
+	// auto-responder.
+	async AutoReplyGet(): Promise<AutoReply> {
+		const fn: string = "AutoReplyGet"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["AutoReply"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as AutoReply
+	}
+
+	// AutoReplySave saves the settings for the vacation/out-of-office
+	// auto-responder. While enabled, and between the optional start and end time,
+	// incoming messages get an automatic reply, at most once a week per sender.
+	// Messages from mailing lists, junk, delivery status notifications and reports
+	// don't get a reply.
+	async AutoReplySave(ar: AutoReply): Promise<void> {
+		const fn: string = "AutoReplySave"
+		const paramTypes: string[][] = [["AutoReply"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [ar]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// AppPasswords returns the app passwords of the account, for use in email
+	// clients instead of the account password.
+	async AppPasswords(): Promise<AppPassword[] | null> {
+		const fn: string = "AppPasswords"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","AppPassword"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as AppPassword[] | null
+	}
+
+	// AppPasswordAdd generates a new app password, valid for IMAP and/or SMTP
+	// submission. The generated password is returned, it cannot be retrieved later.
+	async AppPasswordAdd(name: string, imap: boolean, submission: boolean): Promise<[AppPassword, string]> {
+		const fn: string = "AppPasswordAdd"
+		const paramTypes: string[][] = [["string"],["bool"],["bool"]]
+		const returnTypes: string[][] = [["AppPassword"],["string"]]
+		const params: any[] = [name, imap, submission]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [AppPassword, string]
+	}
+
+	// AppPasswordRemove revokes an app password. New authentication attempts with it
+	// fail, existing connections are not closed.
+	async AppPasswordRemove(id: number): Promise<void> {
+		const fn: string = "AppPasswordRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// TOTPGet returns whether two-factor authentication is enabled for logins to the
+	// web interfaces, and the number of unused recovery codes.
+	async TOTPGet(): Promise<[boolean, number]> {
+		const fn: string = "TOTPGet"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["bool"],["int32"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [boolean, number]
+	}
+
+	// TOTPEnrollStart starts enrolment in two-factor authentication with a new
+	// secret. The secret is returned as key for manual entry, as otpauth URI, and as
+	// QR code (PNG data URL) of the URI, for adding to an authenticator app.
+	// Two-factor authentication is enabled by TOTPEnrollFinish.
+	async TOTPEnrollStart(): Promise<[string, string, string]> {
+		const fn: string = "TOTPEnrollStart"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"],["string"],["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [string, string, string]
+	}
+
+	// TOTPEnrollFinish enables two-factor authentication after verifying a code from
+	// the authenticator app for the secret from TOTPEnrollStart. Recovery codes are
+	// returned, each can be used once instead of a code, e.g. when the device with the
+	// authenticator app is lost. Other sessions are logged out.
+	async TOTPEnrollFinish(code: string): Promise<string[] | null> {
+		const fn: string = "TOTPEnrollFinish"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["[]","string"]]
+		const params: any[] = [code]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string[] | null
+	}
+
+	// TOTPDisable disables two-factor authentication, or aborts an enrolment. If
+	// enabled, code must be a current code from the authenticator app, an unused
+	// recovery code, or the account password.
+	async TOTPDisable(code: string): Promise<void> {
+		const fn: string = "TOTPDisable"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [code]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// ImportAbort aborts an import that is in progress. If the import exists and isn't
 	// finished, no changes will have been made by the import.
 	async ImportAbort(importToken: string): Promise<void> {
@@ -377,6 +377,13 @@ func importMessages(ctx context.Context, log mlog.Log, token string, acc *store.
 	ximportcheckf(err, "get disk usage")
 	var addSize int64
 
+	maxCount := acc.QuotaMessageCount()
+	var count, addCount int64
+	if maxCount > 0 {
+		count, err = acc.MessageCount(tx)
+		ximportcheckf(err, "count messages")
+	}
+
 	// For maildirs, we are likely to get a possible dovecot-keywords file after having
 	// imported the messages. Once we see the keywords, we use them. But before that
 	// time we remember which messages miss a keywords. Once the keywords become
@@ -500,6 +507,10 @@ func importMessages(ctx context.Context, log mlog.Log, token string, acc *store.
 		if maxSize > 0 && du.MessageSize+addSize > maxSize {
 			ximportcheckf(fmt.Errorf("account over maximum total size %d", maxSize), "checking quota")
 		}
+		addCount++
+		if maxCount > 0 && count+addCount > maxCount {
+			ximportcheckf(fmt.Errorf("account over maximum number of messages %d", maxCount), "checking quota")
+		}
 
 		if modseq == 0 {
 			var err error
@@ -34,6 +34,7 @@ import (
 	_ "embed"
 
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"golang.org/x/exp/slog"
 
 	"github.com/mjl-/adns"
@@ -63,6 +64,7 @@ import (
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrpt"
 	"github.com/mjl-/mox/tlsrptdb"
+	"github.com/mjl-/mox/totp"
 	"github.com/mjl-/mox/webauth"
 )
 
@@ -140,9 +142,38 @@ type ctxKey string
 var requestInfoCtxKey ctxKey = "requestInfo"
 
 type requestInfo struct {
+	LoginAddress string // Only for domain administrators.
+	AccountName  string // Only for domain administrators, empty for the administrator.
 	SessionToken store.SessionToken
 	Response     http.ResponseWriter
 	Request      *http.Request // For Proto and TLS connection state during message submit.
+	Targets      *auditTargets // For the audit record of the API call, nil if not recorded.
+}
+
+// auditTargets holds the accounts, domains and addresses an API call operates on.
+// They are gathered during the access checks, and logged with the API call.
+type auditTargets struct {
+	accounts  []string
+	domains   []string
+	addresses []string
+}
+
+// auditTarget records the account, domain and/or address (if not empty) an API
+// call operates on.
+func auditTarget(ctx context.Context, accountName, domain, address string) {
+	reqInfo, _ := ctx.Value(requestInfoCtxKey).(requestInfo)
+	t := reqInfo.Targets
+	if t == nil {
+		return
+	}
+	add := func(l *[]string, s string) {
+		if s != "" && !slices.Contains(*l, s) {
+			*l = append(*l, s)
+		}
+	}
+	add(&t.accounts, accountName)
+	add(&t.domains, domain)
+	add(&t.addresses, address)
 }
 
 func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r *http.Request) {
@@ -168,10 +199,11 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 	}
 
 	// All other URLs, except the login endpoint require some authentication.
+	var accountName, loginAddress string
 	var sessionToken store.SessionToken
 	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" {
 		var ok bool
-		_, sessionToken, _, ok = webauth.Check(ctx, log, webauth.Admin, "webadmin", isForwarded, w, r, isAPI, isAPI, false)
+		accountName, sessionToken, loginAddress, ok = webauth.Check(ctx, log, webauth.Admin, "webadmin", isForwarded, w, r, isAPI, isAPI, false)
 		if !ok {
 			// Response has been written already.
 			return
@@ -179,15 +211,160 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 	}
 
 	if isAPI {
-		reqInfo := requestInfo{sessionToken, w, r}
+		fn := strings.TrimPrefix(r.URL.Path, "/api/")
+		if accountName != "" && !domainAdminFunctions[fn] {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			var result = struct {
+				Error sherpa.Error `json:"error"`
+			}{
+				sherpa.Error{Code: "user:error", Message: "not allowed for domain administrators"},
+			}
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+
+		reqInfo := requestInfo{loginAddress, accountName, sessionToken, w, r, &auditTargets{}}
 		ctx = context.WithValue(ctx, requestInfoCtxKey, reqInfo)
 		apiHandler.ServeHTTP(w, r.WithContext(ctx))
+
+		// Record API calls with the acting administrator, and the accounts, domains and
+		// addresses they were checked for access to. Changes are logged by the functions
+		// making them, with the same cid.
+		if fn != "" && fn != "LoginPrep" && fn != "Login" {
+			admin := "admin"
+			if accountName != "" {
+				admin = loginAddress
+			}
+			attrs := []slog.Attr{slog.String("function", fn), slog.String("admin", admin)}
+			if t := reqInfo.Targets; len(t.accounts) > 0 {
+				attrs = append(attrs, slog.Any("accounts", t.accounts))
+			}
+			if t := reqInfo.Targets; len(t.domains) > 0 {
+				attrs = append(attrs, slog.Any("domains", t.domains))
+			}
+			if t := reqInfo.Targets; len(t.addresses) > 0 {
+				attrs = append(attrs, slog.Any("addresses", t.addresses))
+			}
+			log.Info("admin api call", attrs...)
+		}
 		return
 	}
 
 	http.NotFound(w, r)
 }
 
+// API functions domain administrators can call, in addition to documentation. The
+// functions check that the domains and accounts they operate on are administered by
+// the domain administrator. All other functions are for the administrator only.
+var domainAdminFunctions = map[string]bool{
+	"":                    true,
+	"_docs":               true,
+	"sherpa.js":           true,
+	"sherpa.json":         true,
+	"Logout":              true,
+	"Identity":            true,
+	"CheckDomain":         true,
+	"Domains":             true,
+	"Domain":              true,
+	"ParseDomain":         true,
+	"DomainLocalparts":    true,
+	"DomainAdmins":        true,
+	"Accounts":            true,
+	"Account":             true,
+	"TLSReports":          true,
+	"TLSReportID":         true,
+	"TLSRPTSummaries":     true,
+	"DMARCReports":        true,
+	"DMARCReportID":       true,
+	"DMARCSummaries":      true,
+	"DomainRecords":       true,
+	"AccountAdd":          true,
+	"AccountRemove":       true,
+	"AddressAdd":          true,
+	"AddressRemove":       true,
+	"DomainAliases":       true,
+	"AliasAdd":            true,
+	"AliasUpdate":         true,
+	"AliasRemove":         true,
+	"SetPassword":         true,
+	"AccountTOTPReset":    true,
+	"ClientConfigsDomain": true,
+}
+
+// accountAccess returns whether the administrator of the request can manage the
+// account. Domain administrators can only manage accounts with a default domain
+// and addresses in domains they administer, and that don't administer other
+// domains, to prevent taking over such an account to gain access to more domains.
+// Calls without request, e.g. from other packages, are from the administrator.
+func accountAccess(ctx context.Context, accountName string) bool {
+	reqInfo, _ := ctx.Value(requestInfoCtxKey).(requestInfo)
+	if reqInfo.AccountName == "" {
+		return true
+	}
+	domains := mox.Conf.AdminDomains(reqInfo.AccountName)
+	acc, ok := mox.Conf.Account(accountName)
+	if !ok || !slices.Contains(domains, acc.DNSDomain.Name()) {
+		return false
+	}
+	for _, addr := range mox.Conf.AccountAddresses(accountName) {
+		if !slices.Contains(domains, addr[strings.LastIndex(addr, "@")+1:]) {
+			return false
+		}
+	}
+	for _, d := range mox.Conf.AdminDomains(accountName) {
+		if !slices.Contains(domains, d) {
+			return false
+		}
+	}
+	return true
+}
+
+// xcheckAccountAccess panics with a user error if the administrator of the request
+// cannot manage the account.
+func xcheckAccountAccess(ctx context.Context, accountName string) {
+	auditTarget(ctx, accountName, "", "")
+	if !accountAccess(ctx, accountName) {
+		xcheckuserf(ctx, errors.New("account not managed by domain administrator"), "checking access to account %q", accountName)
+	}
+}
+
+// domainAccess returns whether the administrator of the request can manage the
+// domain. Domain administrators can only manage the domains they administer.
+func domainAccess(ctx context.Context, d dns.Domain) bool {
+	reqInfo, _ := ctx.Value(requestInfoCtxKey).(requestInfo)
+	return reqInfo.AccountName == "" || slices.Contains(mox.Conf.AdminDomains(reqInfo.AccountName), d.Name())
+}
+
+// xcheckDomainAccess panics with a user error if the administrator of the request
+// cannot manage the domain.
+func xcheckDomainAccess(ctx context.Context, d dns.Domain) {
+	auditTarget(ctx, "", d.Name(), "")
+	if !domainAccess(ctx, d) {
+		xcheckuserf(ctx, errors.New("domain not administered by domain administrator"), "checking access to domain %s", d)
+	}
+}
+
+// xcheckDomainNameAccess is like xcheckDomainAccess, but for a domain name that is
+// only parsed for domain administrators. The administrator can pass an empty name,
+// e.g. for reports of all domains.
+func xcheckDomainNameAccess(ctx context.Context, domain string) {
+	reqInfo, _ := ctx.Value(requestInfoCtxKey).(requestInfo)
+	if reqInfo.AccountName == "" {
+		auditTarget(ctx, "", domain, "")
+		return
+	}
+	d, err := dns.ParseDomain(domain)
+	xcheckuserf(ctx, err, "parsing domain")
+	xcheckDomainAccess(ctx, d)
+}
+
+// xcheckAddressAccess checks access to the domain of an email address, or a
+// catchall address starting with "@".
+func xcheckAddressAccess(ctx context.Context, address string) {
+	auditTarget(ctx, "", "", address)
+	xcheckDomainNameAccess(ctx, address[strings.LastIndex(address, "@")+1:])
+}
+
 func xcheckf(ctx context.Context, err error, format string, args ...any) {
 	if err == nil {
 		return
@@ -229,12 +406,18 @@ func (w Admin) LoginPrep(ctx context.Context) string {
 }
 
 // Login returns a session token for the credentials, or fails with error code
-// "user:badLogin". Call LoginPrep to get a loginToken.
-func (w Admin) Login(ctx context.Context, loginToken, password string) store.CSRFToken {
+// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+// authentication is enabled, totpCode must be a code from the authenticator app
+// or a recovery code, the call fails with error code "user:totpRequired" if it is
+// empty.
+//
+// The administrator logs in with an empty username and the admin password. Domain
+// administrators log in with the email address and password of their account.
+func (w Admin) Login(ctx context.Context, loginToken, username, password, totpCode string) store.CSRFToken {
 	log := pkglog.WithContext(ctx)
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 
-	csrfToken, err := webauth.Login(ctx, log, webauth.Admin, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, "", password)
+	csrfToken, err := webauth.Login(ctx, log, webauth.Admin, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password, totpCode)
 	if _, ok := err.(*sherpa.Error); ok {
 		panic(err)
 	}
@@ -247,10 +430,66 @@ func (w Admin) Logout(ctx context.Context) {
 	log := pkglog.WithContext(ctx)
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 
-	err := webauth.Logout(ctx, log, webauth.Admin, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, "", reqInfo.SessionToken)
+	err := webauth.Logout(ctx, log, webauth.Admin, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, reqInfo.AccountName, reqInfo.SessionToken)
 	xcheckf(ctx, err, "logout")
 }
 
+// Identity returns the email address a domain administrator logged in with, or an
+// empty string for the administrator.
+func (Admin) Identity(ctx context.Context) string {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	return reqInfo.LoginAddress
+}
+
+// TOTPGet returns whether two-factor authentication is enabled for the admin,
+// and the number of unused recovery codes.
+func (Admin) TOTPGet(ctx context.Context) (enabled bool, recoveryCodes int) {
+	t, err := webauth.AdminTOTPGet()
+	xcheckf(ctx, err, "get two-factor authentication")
+	return t.Enabled, len(t.RecoveryCodes)
+}
+
+// TOTPEnrollStart starts enrolment in two-factor authentication for the admin
+// with a new secret. The secret is returned as key for manual entry, as otpauth
+// URI, and as QR code (PNG data URL) of the URI, for adding to an authenticator
+// app. Two-factor authentication is enabled by TOTPEnrollFinish.
+func (Admin) TOTPEnrollStart(ctx context.Context) (key, uri, qrcode string) {
+	log := pkglog.WithContext(ctx)
+	t, err := webauth.AdminTOTPEnrollStart(log)
+	if errors.Is(err, store.ErrTOTP) {
+		xcheckuserf(ctx, err, "starting two-factor authentication enrolment")
+	}
+	xcheckf(ctx, err, "starting two-factor authentication enrolment")
+
+	uri = totp.URI(t.Secret, mox.Conf.Static.HostnameDomain.Name(), "admin")
+	qrcode, err = webauth.TOTPQRCode(uri)
+	xcheckf(ctx, err, "generating qr code")
+	return totp.Key(t.Secret), uri, qrcode
+}
+
+// TOTPEnrollFinish enables two-factor authentication for the admin after
+// verifying a code from the authenticator app for the secret from
+// TOTPEnrollStart. Recovery codes are returned, each can be used once instead of a
+// code. Other admin sessions are logged out.
+func (Admin) TOTPEnrollFinish(ctx context.Context, code string) []string {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	codes, err := webauth.AdminTOTPEnrollFinish(log, code, reqInfo.SessionToken)
+	if errors.Is(err, store.ErrTOTP) {
+		xcheckuserf(ctx, err, "enabling two-factor authentication")
+	}
+	xcheckf(ctx, err, "enabling two-factor authentication")
+	return codes
+}
+
+// TOTPDisable disables two-factor authentication for the admin, or aborts an
+// enrolment.
+func (Admin) TOTPDisable(ctx context.Context) {
+	log := pkglog.WithContext(ctx)
+	err := webauth.AdminTOTPDisable(log)
+	xcheckf(ctx, err, "disabling two-factor authentication")
+}
+
 type Result struct {
 	Errors       []string
 	Warnings     []string
@@ -413,6 +652,8 @@ func xsendingIPs(ctx context.Context) []net.IP {
 func (Admin) CheckDomain(ctx context.Context, domainName string) (r CheckResult) {
 	// todo future: should run these checks without a DNS cache so recent changes are picked up.
 
+	xcheckDomainNameAccess(ctx, domainName)
+
 	resolver := dns.StrictResolver{Pkg: "check", Log: pkglog.WithContext(ctx).Logger}
 	dialer := &net.Dialer{Timeout: 10 * time.Second}
 	nctx, cancel := context.WithTimeout(ctx, 30*time.Second)
@@ -1481,12 +1722,15 @@ When enabling MTA-STS, or updating a policy, always update the policy first (thr
 	return
 }
 
-// Domains returns all configured domain names, in UTF-8 for IDNA domains.
+// Domains returns all configured domain names, in UTF-8 for IDNA domains. For
+// domain administrators, only the domains they administer are returned.
 func (Admin) Domains(ctx context.Context) []dns.Domain {
 	l := []dns.Domain{}
 	for _, s := range mox.Conf.Domains() {
 		d, _ := dns.ParseDomain(s)
-		l = append(l, d)
+		if domainAccess(ctx, d) {
+			l = append(l, d)
+		}
 	}
 	return l
 }
@@ -1495,6 +1739,7 @@ func (Admin) Domains(ctx context.Context) []dns.Domain {
 func (Admin) Domain(ctx context.Context, domain string) dns.Domain {
 	d, err := dns.ParseDomain(domain)
 	xcheckuserf(ctx, err, "parse domain")
+	xcheckDomainAccess(ctx, d)
 	_, ok := mox.Conf.Domain(d)
 	if !ok {
 		xcheckuserf(ctx, errors.New("no such domain"), "looking up domain")
@@ -1513,6 +1758,7 @@ func (Admin) ParseDomain(ctx context.Context, domain string) dns.Domain {
 func (Admin) DomainLocalparts(ctx context.Context, domain string) (localpartAccounts map[string]string) {
 	d, err := dns.ParseDomain(domain)
 	xcheckuserf(ctx, err, "parsing domain")
+	xcheckDomainAccess(ctx, d)
 	_, ok := mox.Conf.Domain(d)
 	if !ok {
 		xcheckuserf(ctx, errors.New("no such domain"), "looking up domain")
@@ -1520,9 +1766,15 @@ func (Admin) DomainLocalparts(ctx context.Context, domain string) (localpartAcco
 	return mox.Conf.DomainLocalparts(d)
 }
 
-// Accounts returns the names of all configured accounts.
+// Accounts returns the names of all configured accounts. For domain
+// administrators, only the accounts they can manage are returned.
 func (Admin) Accounts(ctx context.Context) []string {
-	l := mox.Conf.Accounts()
+	l := []string{}
+	for _, name := range mox.Conf.Accounts() {
+		if accountAccess(ctx, name) {
+			l = append(l, name)
+		}
+	}
 	sort.Slice(l, func(i, j int) bool {
 		return l[i] < l[j]
 	})
@@ -1531,6 +1783,7 @@ func (Admin) Accounts(ctx context.Context) []string {
 
 // Account returns the parsed configuration of an account.
 func (Admin) Account(ctx context.Context, account string) map[string]any {
+	xcheckAccountAccess(ctx, account)
 	ac, ok := mox.Conf.Account(account)
 	if !ok {
 		xcheckuserf(ctx, errors.New("no such account"), "looking up account")
@@ -1566,6 +1819,7 @@ func (Admin) MTASTSPolicies(ctx context.Context) (records []mtastsdb.PolicyRecor
 // policy domain (or all domains if empty). The reports are sorted first by period
 // end (most recent first), then by policy domain.
 func (Admin) TLSReports(ctx context.Context, start, end time.Time, policyDomain string) (reports []tlsrptdb.TLSReportRecord) {
+	xcheckDomainNameAccess(ctx, policyDomain)
 	var polDom dns.Domain
 	if policyDomain != "" {
 		var err error
@@ -1588,6 +1842,7 @@ func (Admin) TLSReports(ctx context.Context, start, end time.Time, policyDomain
 
 // TLSReportID returns a single TLS report.
 func (Admin) TLSReportID(ctx context.Context, domain string, reportID int64) tlsrptdb.TLSReportRecord {
+	xcheckDomainNameAccess(ctx, domain)
 	record, err := tlsrptdb.RecordID(ctx, reportID)
 	if err == nil && record.Domain != domain {
 		err = bstore.ErrAbsent
@@ -1612,6 +1867,7 @@ type TLSRPTSummary struct {
 // period start/end for one or all domains (when domain is empty).
 // The returned summaries are ordered by domain name.
 func (Admin) TLSRPTSummaries(ctx context.Context, start, end time.Time, policyDomain string) (domainSummaries []TLSRPTSummary) {
+	xcheckDomainNameAccess(ctx, policyDomain)
 	var polDom dns.Domain
 	if policyDomain != "" {
 		var err error
@@ -1654,6 +1910,7 @@ func (Admin) TLSRPTSummaries(ctx context.Context, start, end time.Time, policyDo
 // given domain (or all domains if empty). The reports are sorted first by period
 // end (most recent first), then by domain.
 func (Admin) DMARCReports(ctx context.Context, start, end time.Time, domain string) (reports []dmarcdb.DomainFeedback) {
+	xcheckDomainNameAccess(ctx, domain)
 	reports, err := dmarcdb.RecordsPeriodDomain(ctx, start, end, domain)
 	xcheckf(ctx, err, "fetching dmarc aggregate reports from database")
 	sort.Slice(reports, func(i, j int) bool {
@@ -1669,6 +1926,7 @@ func (Admin) DMARCReports(ctx context.Context, start, end time.Time, domain stri
 
 // DMARCReportID returns a single DMARC report.
 func (Admin) DMARCReportID(ctx context.Context, domain string, reportID int64) (report dmarcdb.DomainFeedback) {
+	xcheckDomainNameAccess(ctx, domain)
 	report, err := dmarcdb.RecordID(ctx, reportID)
 	if err == nil && report.Domain != domain {
 		err = bstore.ErrAbsent
@@ -1697,6 +1955,7 @@ type DMARCSummary struct {
 // period start/end for one or all domains (when domain is empty).
 // The returned summaries are ordered by domain name.
 func (Admin) DMARCSummaries(ctx context.Context, start, end time.Time, domain string) (domainSummaries []DMARCSummary) {
+	xcheckDomainNameAccess(ctx, domain)
 	reports, err := dmarcdb.RecordsPeriodDomain(ctx, start, end, domain)
 	xcheckf(ctx, err, "fetching dmarc aggregate reports from database")
 	summaries := map[string]DMARCSummary{}
@@ -1807,6 +2066,7 @@ func dnsblsStatus(ctx context.Context, log mlog.Log, resolver dns.Resolver) map[
 // DomainRecords returns lines describing DNS records that should exist for the
 // configured domain.
 func (Admin) DomainRecords(ctx context.Context, domain string) []string {
+	xcheckDomainNameAccess(ctx, domain)
 	log := pkglog.WithContext(ctx)
 	return DomainRecords(ctx, log, domain)
 }
@@ -1866,32 +2126,130 @@ func (Admin) DomainRemove(ctx context.Context, domain string) {
 // AccountAdd adds existing a new account, with an initial email address, and
 // reloads the configuration.
 func (Admin) AccountAdd(ctx context.Context, accountName, address string) {
+	xcheckAddressAccess(ctx, address)
 	err := mox.AccountAdd(ctx, accountName, address)
 	xcheckf(ctx, err, "adding account")
 }
 
 // AccountRemove removes an existing account and reloads the configuration.
 func (Admin) AccountRemove(ctx context.Context, accountName string) {
+	xcheckAccountAccess(ctx, accountName)
 	err := mox.AccountRemove(ctx, accountName)
 	xcheckf(ctx, err, "removing account")
 }
 
 // AddressAdd adds a new address to the account, which must already exist.
 func (Admin) AddressAdd(ctx context.Context, address, accountName string) {
+	xcheckAddressAccess(ctx, address)
+	xcheckAccountAccess(ctx, accountName)
 	err := mox.AddressAdd(ctx, address, accountName)
 	xcheckf(ctx, err, "adding address")
 }
 
 // AddressRemove removes an existing address.
 func (Admin) AddressRemove(ctx context.Context, address string) {
+	xcheckAddressAccess(ctx, address)
+	if ad, ok := mox.Conf.AccountDestination(address); ok {
+		xcheckAccountAccess(ctx, ad.Account)
+	}
 	err := mox.AddressRemove(ctx, address)
 	xcheckf(ctx, err, "removing address")
 }
 
+// DomainAdmins returns the accounts that are domain administrators for the domain.
+func (Admin) DomainAdmins(ctx context.Context, domain string) []string {
+	d, err := dns.ParseDomain(domain)
+	xcheckuserf(ctx, err, "parsing domain")
+	xcheckDomainAccess(ctx, d)
+	dc, ok := mox.Conf.Domain(d)
+	if !ok {
+		xcheckuserf(ctx, errors.New("no such domain"), "looking up domain")
+	}
+	if dc.Admins == nil {
+		return []string{}
+	}
+	return dc.Admins
+}
+
+// DomainAdminsSave sets the accounts that are domain administrators for the
+// domain. Domain administrators log in to the admin web interface with the email
+// address and password of their account, and can only manage accounts, addresses
+// and aliases of the domains they administer.
+func (Admin) DomainAdminsSave(ctx context.Context, domain string, accounts []string) {
+	d, err := dns.ParseDomain(domain)
+	xcheckuserf(ctx, err, "parsing domain")
+	err = mox.DomainAdminsSave(ctx, d, accounts)
+	xcheckf(ctx, err, "saving domain administrators")
+}
+
+// DomainAliases returns the aliases configured in domain, keyed by localpart.
+func (Admin) DomainAliases(ctx context.Context, domain string) map[string]config.Alias {
+	d, err := dns.ParseDomain(domain)
+	xcheckuserf(ctx, err, "parsing domain")
+	xcheckDomainAccess(ctx, d)
+	dc, ok := mox.Conf.Domain(d)
+	if !ok {
+		xcheckuserf(ctx, errors.New("no such domain"), "looking up domain")
+	}
+	if dc.Aliases == nil {
+		return map[string]config.Alias{}
+	}
+	return dc.Aliases
+}
+
+// AliasAdd adds a new alias to a domain. Addresses of members can be local
+// account addresses or external addresses.
+func (Admin) AliasAdd(ctx context.Context, aliaslp string, domainName string, alias config.Alias) {
+	addr := xparseAliasAddress(ctx, aliaslp, domainName)
+	xcheckAddressAccess(ctx, addr.String())
+	xcheckAliasMembersAccess(ctx, alias)
+	err := mox.AliasAdd(ctx, addr, alias)
+	xcheckf(ctx, err, "adding alias")
+}
+
+// AliasUpdate replaces the members and posting settings of an existing alias.
+func (Admin) AliasUpdate(ctx context.Context, aliaslp string, domainName string, alias config.Alias) {
+	addr := xparseAliasAddress(ctx, aliaslp, domainName)
+	xcheckAddressAccess(ctx, addr.String())
+	xcheckAliasMembersAccess(ctx, alias)
+	err := mox.AliasUpdate(ctx, addr, alias)
+	xcheckf(ctx, err, "updating alias")
+}
+
+// AliasRemove removes an alias.
+func (Admin) AliasRemove(ctx context.Context, aliaslp string, domainName string) {
+	addr := xparseAliasAddress(ctx, aliaslp, domainName)
+	xcheckAddressAccess(ctx, addr.String())
+	err := mox.AliasRemove(ctx, addr)
+	xcheckf(ctx, err, "removing alias")
+}
+
+// xcheckAliasMembersAccess checks access to the domains of the local members of an
+// alias, so domain administrators cannot have messages delivered to accounts of
+// domains they don't administer. External members are not checked.
+func xcheckAliasMembersAccess(ctx context.Context, alias config.Alias) {
+	for _, s := range alias.Addresses {
+		addr, err := smtp.ParseAddress(s)
+		xcheckuserf(ctx, err, "parsing alias member address %q", s)
+		if _, ok := mox.Conf.Domain(addr.Domain); ok {
+			xcheckAddressAccess(ctx, addr.String())
+		}
+	}
+}
+
+func xparseAliasAddress(ctx context.Context, aliaslp string, domainName string) smtp.Address {
+	lp, err := smtp.ParseLocalpart(aliaslp)
+	xcheckuserf(ctx, err, "parsing alias localpart")
+	d, err := dns.ParseDomain(domainName)
+	xcheckuserf(ctx, err, "parsing domain")
+	return smtp.NewAddress(lp, d)
+}
+
 // SetPassword saves a new password for an account, invalidating the previous password.
 // Sessions are not interrupted, and will keep working. New login attempts must use the new password.
 // Password must be at least 8 characters.
 func (Admin) SetPassword(ctx context.Context, accountName, password string) {
+	xcheckAccountAccess(ctx, accountName)
 	log := pkglog.WithContext(ctx)
 	if len(password) < 8 {
 		panic(&sherpa.Error{Code: "user:error", Message: "password must be at least 8 characters"})
@@ -1903,9 +2261,27 @@ func (Admin) SetPassword(ctx context.Context, accountName, password string) {
 		log.WithContext(ctx).Check(err, "closing account")
 	}()
 	err = acc.SetPassword(log, password)
+	if errors.Is(err, store.ErrEncryptionLocked) {
+		xcheckuserf(ctx, err, "setting password")
+	}
 	xcheckf(ctx, err, "setting password")
 }
 
+// AccountTOTPReset disables two-factor authentication for an account, e.g. after
+// the user lost both the authenticator app and the recovery codes.
+func (Admin) AccountTOTPReset(ctx context.Context, accountName string) {
+	xcheckAccountAccess(ctx, accountName)
+	log := pkglog.WithContext(ctx)
+	acc, err := store.OpenAccount(log, accountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.WithContext(ctx).Check(err, "closing account")
+	}()
+	err = acc.TOTPReset(ctx, log)
+	xcheckf(ctx, err, "resetting two-factor authentication")
+}
+
 // SetAccountLimits set new limits on outgoing messages for an account.
 func (Admin) SetAccountLimits(ctx context.Context, accountName string, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay int, maxMsgSize int64) {
 	err := mox.AccountLimitsSave(ctx, accountName, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay, maxMsgSize)
@@ -1917,6 +2293,7 @@ func (Admin) SetAccountLimits(ctx context.Context, accountName string, maxOutgoi
 func (Admin) ClientConfigsDomain(ctx context.Context, domain string) mox.ClientConfigs {
 	d, err := dns.ParseDomain(domain)
 	xcheckuserf(ctx, err, "parsing domain")
+	xcheckDomainAccess(ctx, d)
 
 	cc, err := mox.ClientConfigsDomain(d)
 	xcheckf(ctx, err, "client config for domain")
@@ -333,7 +333,7 @@ var api;
 		SPFResult["SPFTemperror"] = "temperror";
 		SPFResult["SPFPermerror"] = "permerror";
 	})(SPFResult = api.SPFResult || (api.SPFResult = {}));
-	api.structTypes = { "AuthResults": true, "AutoconfCheckResult": true, "AutodiscoverCheckResult": true, "AutodiscoverSRV": true, "CheckResult": true, "ClientConfigs": true, "ClientConfigsEntry": true, "DANECheckResult": true, "DKIMAuthResult": true, "DKIMCheckResult": true, "DKIMRecord": true, "DMARCCheckResult": true, "DMARCRecord": true, "DMARCSummary": true, "DNSSECResult": true, "DateRange": true, "Directive": true, "Domain": true, "DomainFeedback": true, "Evaluation": true, "EvaluationStat": true, "Extension": true, "FailureDetails": true, "IPDomain": true, "IPRevCheckResult": true, "Identifiers": true, "MTASTSCheckResult": true, "MTASTSRecord": true, "MX": true, "MXCheckResult": true, "Modifier": true, "Msg": true, "Pair": true, "Policy": true, "PolicyEvaluated": true, "PolicyOverrideReason": true, "PolicyPublished": true, "PolicyRecord": true, "Record": true, "Report": true, "ReportMetadata": true, "ReportRecord": true, "Result": true, "ResultPolicy": true, "Reverse": true, "Row": true, "SMTPAuth": true, "SPFAuthResult": true, "SPFCheckResult": true, "SPFRecord": true, "SRV": true, "SRVConfCheckResult": true, "STSMX": true, "Summary": true, "SuppressAddress": true, "TLSCheckResult": true, "TLSRPTCheckResult": true, "TLSRPTDateRange": true, "TLSRPTRecord": true, "TLSRPTSummary": true, "TLSRPTSuppressAddress": true, "TLSReportRecord": true, "TLSResult": true, "Transport": true, "TransportSMTP": true, "TransportSocks": true, "URI": true, "WebForward": true, "WebHandler": true, "WebRedirect": true, "WebStatic": true, "WebserverConfig": true };
+	api.structTypes = { "Alias": true, "AuthResults": true, "AutoconfCheckResult": true, "AutodiscoverCheckResult": true, "AutodiscoverSRV": true, "CheckResult": true, "ClientConfigs": true, "ClientConfigsEntry": true, "DANECheckResult": true, "DKIMAuthResult": true, "DKIMCheckResult": true, "DKIMRecord": true, "DMARCCheckResult": true, "DMARCRecord": true, "DMARCSummary": true, "DNSSECResult": true, "DateRange": true, "Directive": true, "Domain": true, "DomainFeedback": true, "Evaluation": true, "EvaluationStat": true, "Extension": true, "FailureDetails": true, "IPDomain": true, "IPRevCheckResult": true, "Identifiers": true, "MTASTSCheckResult": true, "MTASTSRecord": true, "MX": true, "MXCheckResult": true, "Modifier": true, "Msg": true, "Pair": true, "Policy": true, "PolicyEvaluated": true, "PolicyOverrideReason": true, "PolicyPublished": true, "PolicyRecord": true, "Record": true, "Report": true, "ReportMetadata": true, "ReportRecord": true, "Result": true, "ResultPolicy": true, "Reverse": true, "Row": true, "SMTPAuth": true, "SPFAuthResult": true, "SPFCheckResult": true, "SPFRecord": true, "SRV": true, "SRVConfCheckResult": true, "STSMX": true, "Summary": true, "SuppressAddress": true, "TLSCheckResult": true, "TLSRPTCheckResult": true, "TLSRPTDateRange": true, "TLSRPTRecord": true, "TLSRPTSummary": true, "TLSRPTSuppressAddress": true, "TLSReportRecord": true, "TLSResult": true, "Transport": true, "TransportSMTP": true, "TransportSocks": true, "URI": true, "WebForward": true, "WebHandler": true, "WebRedirect": true, "WebStatic": true, "WebserverConfig": true };
 	api.stringsTypes = { "Align": true, "Alignment": true, "CSRFToken": true, "DKIMResult": true, "DMARCPolicy": true, "DMARCResult": true, "Disposition": true, "IP": true, "Localpart": true, "Mode": true, "PolicyOverride": true, "PolicyType": true, "RUA": true, "ResultType": true, "SPFDomainScope": true, "SPFResult": true };
 	api.intsTypes = {};
 	api.types = {
@@ -391,6 +391,7 @@ var api;
 		"SPFAuthResult": { "Name": "SPFAuthResult", "Docs": "", "Fields": [{ "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Scope", "Docs": "", "Typewords": ["SPFDomainScope"] }, { "Name": "Result", "Docs": "", "Typewords": ["SPFResult"] }] },
 		"DMARCSummary": { "Name": "DMARCSummary", "Docs": "", "Fields": [{ "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Total", "Docs": "", "Typewords": ["int32"] }, { "Name": "DispositionNone", "Docs": "", "Typewords": ["int32"] }, { "Name": "DispositionQuarantine", "Docs": "", "Typewords": ["int32"] }, { "Name": "DispositionReject", "Docs": "", "Typewords": ["int32"] }, { "Name": "DKIMFail", "Docs": "", "Typewords": ["int32"] }, { "Name": "SPFFail", "Docs": "", "Typewords": ["int32"] }, { "Name": "PolicyOverrides", "Docs": "", "Typewords": ["{}", "int32"] }] },
 		"Reverse": { "Name": "Reverse", "Docs": "", "Fields": [{ "Name": "Hostnames", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"Alias": { "Name": "Alias", "Docs": "", "Fields": [{ "Name": "Addresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "PostPublic", "Docs": "", "Typewords": ["bool"] }, { "Name": "AllowMsgFrom", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"ClientConfigs": { "Name": "ClientConfigs", "Docs": "", "Fields": [{ "Name": "Entries", "Docs": "", "Typewords": ["[]", "ClientConfigsEntry"] }] },
 		"ClientConfigsEntry": { "Name": "ClientConfigsEntry", "Docs": "", "Fields": [{ "Name": "Protocol", "Docs": "", "Typewords": ["string"] }, { "Name": "Host", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Port", "Docs": "", "Typewords": ["int32"] }, { "Name": "Listener", "Docs": "", "Typewords": ["string"] }, { "Name": "Note", "Docs": "", "Typewords": ["string"] }] },
 		"Msg": { "Name": "Msg", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Queued", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "SenderAccount", "Docs": "", "Typewords": ["string"] }, { "Name": "SenderLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "SenderDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "RecipientLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "RecipientDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "RecipientDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Attempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "DialedIPs", "Docs": "", "Typewords": ["{}", "[]", "IP"] }, { "Name": "NextAttempt", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastAttempt", "Docs": "", "Typewords": ["nullable", "timestamp"] }, { "Name": "LastError", "Docs": "", "Typewords": ["string"] }, { "Name": "Has8bit", "Docs": "", "Typewords": ["bool"] }, { "Name": "SMTPUTF8", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsDMARCReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsTLSReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgPrefix", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "DSNUTF8", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "RequireTLS", "Docs": "", "Typewords": ["nullable", "bool"] }] },
@@ -481,6 +482,7 @@ var api;
 		SPFAuthResult: (v) => api.parse("SPFAuthResult", v),
 		DMARCSummary: (v) => api.parse("DMARCSummary", v),
 		Reverse: (v) => api.parse("Reverse", v),
+		Alias: (v) => api.parse("Alias", v),
 		ClientConfigs: (v) => api.parse("ClientConfigs", v),
 		ClientConfigsEntry: (v) => api.parse("ClientConfigsEntry", v),
 		Msg: (v) => api.parse("Msg", v),
@@ -551,12 +553,18 @@ var api;
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Login returns a session token for the credentials, or fails with error code
-		// "user:badLogin". Call LoginPrep to get a loginToken.
-		async Login(loginToken, password) {
+		// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+		// authentication is enabled, totpCode must be a code from the authenticator app
+		// or a recovery code, the call fails with error code "user:totpRequired" if it is
+		// empty.
+		// 
+		// The administrator logs in with an empty username and the admin password. Domain
+		// administrators log in with the email address and password of their account.
+		async Login(loginToken, username, password, totpCode) {
 			const fn = "Login";
-			const paramTypes = [["string"], ["string"]];
+			const paramTypes = [["string"], ["string"], ["string"], ["string"]];
 			const returnTypes = [["CSRFToken"]];
-			const params = [loginToken, password];
+			const params = [loginToken, username, password, totpCode];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Logout invalidates the session token.
@@ -567,6 +575,55 @@ var api;
 			const params = [];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// Identity returns the email address a domain administrator logged in with, or an
+		// empty string for the administrator.
+		async Identity() {
+			const fn = "Identity";
+			const paramTypes = [];
+			const returnTypes = [["string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPGet returns whether two-factor authentication is enabled for the admin,
+		// and the number of unused recovery codes.
+		async TOTPGet() {
+			const fn = "TOTPGet";
+			const paramTypes = [];
+			const returnTypes = [["bool"], ["int32"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPEnrollStart starts enrolment in two-factor authentication for the admin
+		// with a new secret. The secret is returned as key for manual entry, as otpauth
+		// URI, and as QR code (PNG data URL) of the URI, for adding to an authenticator
+		// app. Two-factor authentication is enabled by TOTPEnrollFinish.
+		async TOTPEnrollStart() {
+			const fn = "TOTPEnrollStart";
+			const paramTypes = [];
+			const returnTypes = [["string"], ["string"], ["string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPEnrollFinish enables two-factor authentication for the admin after
+		// verifying a code from the authenticator app for the secret from
+		// TOTPEnrollStart. Recovery codes are returned, each can be used once instead of a
+		// code. Other admin sessions are logged out.
+		async TOTPEnrollFinish(code) {
+			const fn = "TOTPEnrollFinish";
+			const paramTypes = [["string"]];
+			const returnTypes = [["[]", "string"]];
+			const params = [code];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPDisable disables two-factor authentication for the admin, or aborts an
+		// enrolment.
+		async TOTPDisable() {
+			const fn = "TOTPDisable";
+			const paramTypes = [];
+			const returnTypes = [];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// CheckDomain checks the configuration for the domain, such as MX, SMTP STARTTLS,
 		// SPF, DKIM, DMARC, TLSRPT, MTASTS, autoconfig, autodiscover.
 		async CheckDomain(domainName) {
@@ -576,7 +633,8 @@ var api;
 			const params = [domainName];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
-		// Domains returns all configured domain names, in UTF-8 for IDNA domains.
+		// Domains returns all configured domain names, in UTF-8 for IDNA domains. For
+		// domain administrators, only the domains they administer are returned.
 		async Domains() {
 			const fn = "Domains";
 			const paramTypes = [];
@@ -608,7 +666,8 @@ var api;
 			const params = [domain];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
-		// Accounts returns the names of all configured accounts.
+		// Accounts returns the names of all configured accounts. For domain
+		// administrators, only the accounts they can manage are returned.
 		async Accounts() {
 			const fn = "Accounts";
 			const paramTypes = [];
@@ -776,6 +835,58 @@ var api;
 			const params = [address];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// DomainAdmins returns the accounts that are domain administrators for the domain.
+		async DomainAdmins(domain) {
+			const fn = "DomainAdmins";
+			const paramTypes = [["string"]];
+			const returnTypes = [["[]", "string"]];
+			const params = [domain];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DomainAdminsSave sets the accounts that are domain administrators for the
+		// domain. Domain administrators log in to the admin web interface with the email
+		// address and password of their account, and can only manage accounts, addresses
+		// and aliases of the domains they administer.
+		async DomainAdminsSave(domain, accounts) {
+			const fn = "DomainAdminsSave";
+			const paramTypes = [["string"], ["[]", "string"]];
+			const returnTypes = [];
+			const params = [domain, accounts];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DomainAliases returns the aliases configured in domain, keyed by localpart.
+		async DomainAliases(domain) {
+			const fn = "DomainAliases";
+			const paramTypes = [["string"]];
+			const returnTypes = [["{}", "Alias"]];
+			const params = [domain];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AliasAdd adds a new alias to a domain. Addresses of members can be local
+		// account addresses or external addresses.
+		async AliasAdd(aliaslp, domainName, alias) {
+			const fn = "AliasAdd";
+			const paramTypes = [["string"], ["string"], ["Alias"]];
+			const returnTypes = [];
+			const params = [aliaslp, domainName, alias];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AliasUpdate replaces the members and posting settings of an existing alias.
+		async AliasUpdate(aliaslp, domainName, alias) {
+			const fn = "AliasUpdate";
+			const paramTypes = [["string"], ["string"], ["Alias"]];
+			const returnTypes = [];
+			const params = [aliaslp, domainName, alias];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AliasRemove removes an alias.
+		async AliasRemove(aliaslp, domainName) {
+			const fn = "AliasRemove";
+			const paramTypes = [["string"], ["string"]];
+			const returnTypes = [];
+			const params = [aliaslp, domainName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// SetPassword saves a new password for an account, invalidating the previous password.
 		// Sessions are not interrupted, and will keep working. New login attempts must use the new password.
 		// Password must be at least 8 characters.
@@ -786,6 +897,15 @@ var api;
 			const params = [accountName, password];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// AccountTOTPReset disables two-factor authentication for an account, e.g. after
+		// the user lost both the authenticator app and the recovery codes.
+		async AccountTOTPReset(accountName) {
+			const fn = "AccountTOTPReset";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [accountName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// SetAccountLimits set new limits on outgoing messages for an account.
 		async SetAccountLimits(accountName, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay, maxMsgSize) {
 			const fn = "SetAccountLimits";
@@ -1385,15 +1505,19 @@ const login = async (reason) => {
 		const origFocus = document.activeElement;
 		let reasonElem;
 		let fieldset;
+		let username;
 		let password;
+		let totpBox;
+		let totpCode;
 		const root = dom.div(style({ position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in' }), dom.div(reasonElem = reason ? dom.div(style({ marginBottom: '2ex', textAlign: 'center' }), reason) : dom.div(), dom.div(style({ backgroundColor: 'white', borderRadius: '.25em', padding: '1em', boxShadow: '0 0 20px rgba(0, 0, 0, 0.1)', border: '1px solid #ddd', maxWidth: '95vw', overflowX: 'auto', maxHeight: '95vh', overflowY: 'auto', marginBottom: '20vh' }), dom.form(async function submit(e) {
 			e.preventDefault();
 			e.stopPropagation();
 			reasonElem.remove();
+			let totpRequired = false;
 			try {
 				fieldset.disabled = true;
 				const loginToken = await client.LoginPrep();
-				const token = await client.Login(loginToken, password.value);
+				const token = await client.Login(loginToken, username.value, password.value, totpCode.value);
 				try {
 					window.localStorage.setItem('webadmincsrftoken', token);
 				}
@@ -1408,12 +1532,23 @@ const login = async (reason) => {
 			}
 			catch (err) {
 				console.log('login error', err);
-				window.alert('Error: ' + errmsg(err));
+				if (err.code === 'user:totpRequired') {
+					// Password is valid, a code for two-factor authentication is required too.
+					totpRequired = true;
+				}
+				else {
+					window.alert('Error: ' + errmsg(err));
+				}
 			}
 			finally {
 				fieldset.disabled = false;
 			}
-		}, fieldset = dom.fieldset(dom.h1('Admin'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.required(''))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
+			if (totpRequired) {
+				totpBox.style.display = 'block';
+				totpCode.required = true;
+				totpCode.focus();
+			}
+		}, fieldset = dom.fieldset(dom.h1('Admin'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Email address (domain administrators only)', style({ marginBottom: '.5ex' })), username = dom.input(attr.autocomplete('username'), attr.title('Domain administrators log in with the email address and password of their account. Leave empty to log in as administrator with the admin password.'))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.required(''))), totpBox = dom.label(style({ display: 'none', marginBottom: '2ex' }), dom.div('Two-factor authentication code', style({ marginBottom: '.5ex' })), totpCode = dom.input(attr.autocomplete('one-time-code'), attr.title('Code from your authenticator app, or one of your recovery codes.'))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
 		document.body.appendChild(root);
 		password.focus();
 	});
@@ -1587,6 +1722,14 @@ const formatSize = (n) => {
 	return n + ' bytes';
 };
 const index = async () => {
+	const identity = await client.Identity();
+	if (identity) {
+		// Domain administrator, only managing accounts and addresses of its domains.
+		const domains = await client.Domains();
+		dom._kids(page, crumbs('Mox Admin'), dom.p('Logged in as domain administrator ', dom.b(identity), '.'), dom.p(dom.a('Accounts', attr.href('#accounts')), dom.br()), dom.h2('Domains'), (domains || []).length === 0 ? box(red, 'No domains') :
+			dom.ul((domains || []).map(d => dom.li(dom.a(attr.href('#domains/' + domainName(d)), domainString(d))))), footer);
+		return;
+	}
 	const [domains, queueSize, checkUpdatesEnabled] = await Promise.all([
 		client.Domains(),
 		client.QueueSize(),
@@ -1615,12 +1758,81 @@ const index = async () => {
 		window.location.hash = '#domains/' + domain.value;
 	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Domain', dom.br(), domain = dom.input(attr.required(''))), ' ', dom.label(style({ display: 'inline-block' }), 'Postmaster/reporting account', dom.br(), account = dom.input(attr.required(''))), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Localpart (optional)', attr.title('Must be set if and only if account does not yet exist. The localpart for the user of this domain. E.g. postmaster.')), dom.br(), localpart = dom.input()), ' ', dom.submitbutton('Add domain', attr.title('Domain will be added and the config reloaded. You should add the required DNS records after adding the domain.')))), dom.br(), dom.h2('Reports'), dom.div(dom.a('DMARC', attr.href('#dmarc/reports'))), dom.div(dom.a('TLS', attr.href('#tlsrpt/reports'))), dom.br(), dom.h2('Operations'), dom.div(dom.a('MTA-STS policies', attr.href('#mtasts'))), dom.div(dom.a('DMARC evaluations', attr.href('#dmarc/evaluations'))), dom.div(dom.a('TLS connection results', attr.href('#tlsrpt/results'))), 
 	// todo: routing, globally, per domain and per account
-	dom.br(), dom.h2('DNS blocklist status'), dom.div(dom.a('DNSBL status', attr.href('#dnsbl'))), dom.br(), dom.h2('Configuration'), dom.div(dom.a('Webserver', attr.href('#webserver'))), dom.div(dom.a('Files', attr.href('#config'))), dom.div(dom.a('Log levels', attr.href('#loglevels'))), footer);
+	dom.br(), dom.h2('DNS blocklist status'), dom.div(dom.a('DNSBL status', attr.href('#dnsbl'))), dom.br(), dom.h2('Configuration'), dom.div(dom.a('Webserver', attr.href('#webserver'))), dom.div(dom.a('Files', attr.href('#config'))), dom.div(dom.a('Log levels', attr.href('#loglevels'))), dom.div(dom.a('Two-factor authentication', attr.href('#twofactor'))), footer);
 };
 const config = async () => {
 	const [staticPath, dynamicPath, staticText, dynamicText] = await client.ConfigFiles();
 	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Config'), dom.h2(staticPath), dom.pre(dom._class('literal'), staticText), dom.h2(dynamicPath), dom.pre(dom._class('literal'), dynamicText));
 };
+const twofactor = async () => {
+	const [enabled, remaining] = await client.TOTPGet();
+	let totpBox;
+	// If recoveryCodes is set, two-factor authentication was just enabled and the new
+	// recovery codes are shown.
+	const render = (enabled, remaining, recoveryCodes) => {
+		if (enabled) {
+			dom._kids(totpBox, recoveryCodes ? box(blue, 'Two-factor authentication has been enabled. Store these recovery codes in a safe place now, they will not be shown again. Each can be used once instead of a code from your authenticator app.', dom.ul(recoveryCodes.map(c => dom.li(dom.span(style({ fontFamily: 'monospace', fontWeight: 'bold' }), c))))) : [], dom.p('Two-factor authentication is enabled. Recovery codes left: ' + remaining + '.'), dom.clickbutton('Disable two-factor authentication', async function click(e) {
+				if (!window.confirm('Are you sure you want to disable two-factor authentication? Logins to the admin interface will only require the admin password.')) {
+					return;
+				}
+				const b = e.target;
+				try {
+					b.disabled = true;
+					await client.TOTPDisable();
+					render(false, 0);
+				}
+				catch (err) {
+					console.log({ err });
+					window.alert('Error: ' + errmsg(err));
+				}
+				finally {
+					b.disabled = false;
+				}
+			}));
+			return;
+		}
+		dom._kids(totpBox, dom.p('Two-factor authentication is not enabled.'), dom.clickbutton('Enable two-factor authentication', async function click(e) {
+			const b = e.target;
+			try {
+				b.disabled = true;
+				const [key, uri, qrcode] = await client.TOTPEnrollStart();
+				renderEnroll(key, uri, qrcode);
+			}
+			catch (err) {
+				console.log({ err });
+				window.alert('Error: ' + errmsg(err));
+			}
+			finally {
+				b.disabled = false;
+			}
+		}));
+	};
+	const renderEnroll = (key, uri, qrcode) => {
+		let fieldset;
+		let code;
+		dom._kids(totpBox, dom.p('Scan the QR code with your authenticator app, or enter the key manually. Then enter the code shown by the app to enable two-factor authentication.'), dom.img(attr.src(qrcode), attr.title(uri)), dom.div('Key: ', dom.span(style({ fontFamily: 'monospace' }), key)), dom.br(), dom.form(fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Code', dom.br(), code = dom.input(attr.required(''), attr.autocomplete('one-time-code'))), ' ', dom.submitbutton('Enable'), ' ', dom.clickbutton('Cancel', function click() {
+			render(false, 0);
+		})), async function submit(e) {
+			e.stopPropagation();
+			e.preventDefault();
+			fieldset.disabled = true;
+			try {
+				const codes = await client.TOTPEnrollFinish(code.value) || [];
+				render(true, codes.length, codes);
+			}
+			catch (err) {
+				console.log({ err });
+				window.alert('Error: ' + errmsg(err));
+			}
+			finally {
+				fieldset.disabled = false;
+			}
+		}));
+		code.focus();
+	};
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Two-factor authentication'), dom.p('With two-factor authentication, logging in to the admin interface requires a code from an authenticator app on your phone in addition to the admin password. Other admin sessions are logged out when it is enabled.'), totpBox = dom.div(), footer);
+	render(enabled, remaining);
+};
 const loglevels = async () => {
 	const loglevels = await client.LogLevels();
 	const levels = ['error', 'info', 'warn', 'debug', 'trace', 'traceauth', 'tracedata'];
@@ -1723,7 +1935,10 @@ const accounts = async () => {
 	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Account name', dom.br(), account = dom.input(attr.required(''))), ' ', dom.label(style({ display: 'inline-block' }), 'Email address', dom.br(), email = dom.input(attr.type('email'), attr.required(''))), ' ', dom.submitbutton('Add account', attr.title('The account will be added and the config reloaded.')))));
 };
 const account = async (name) => {
-	const config = await client.Account(name);
+	const [config, identity] = await Promise.all([
+		client.Account(name),
+		client.Identity(),
+	]);
 	let form;
 	let fieldset;
 	let email;
@@ -1841,23 +2056,29 @@ const account = async (name) => {
 		}
 		form.reset();
 		window.location.reload(); // todo: only reload the destinations
-	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Email address or localpart', attr.title('If empty, or localpart is empty, a catchall address is configured for the domain.')), dom.br(), email = dom.input()), ' ', dom.submitbutton('Add address'))), dom.br(), dom.h2('Limits'), dom.form(fieldsetLimits = dom.fieldset(dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Maximum outgoing messages per day', attr.title('Maximum number of outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 1000. MaxOutgoingMessagesPerDay in configuration file.')), dom.br(), maxOutgoingMessagesPerDay = dom.input(attr.type('number'), attr.required(''), attr.value(config.MaxOutgoingMessagesPerDay || 1000))), dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Maximum first-time recipients per day', attr.title('Maximum number of first-time recipients in outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 200. MaxFirstTimeRecipientsPerDay in configuration file.')), dom.br(), maxFirstTimeRecipientsPerDay = dom.input(attr.type('number'), attr.required(''), attr.value(config.MaxFirstTimeRecipientsPerDay || 200))), dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Disk usage quota: Maximum total message size ', attr.title('Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage.')), dom.br(), quotaMessageSize = dom.input(attr.value(formatQuotaSize(config.QuotaMessageSize)))), dom.submitbutton('Save')), async function submit(e) {
-		e.stopPropagation();
-		e.preventDefault();
-		fieldsetLimits.disabled = true;
-		try {
-			await client.SetAccountLimits(name, parseInt(maxOutgoingMessagesPerDay.value) || 0, parseInt(maxFirstTimeRecipientsPerDay.value) || 0, xparseSize(quotaMessageSize.value));
-			window.alert('Limits saved.');
-		}
-		catch (err) {
-			console.log({ err });
-			window.alert('Error: ' + errmsg(err));
-			return;
-		}
-		finally {
-			fieldsetLimits.disabled = false;
-		}
-	}), dom.br(), dom.h2('Set new password'), formPassword = dom.form(fieldsetPassword = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'New password', dom.br(), password = dom.input(attr.type('password'), attr.autocomplete('new-password'), attr.required(''), function focus() {
+	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Email address or localpart', attr.title('If empty, or localpart is empty, a catchall address is configured for the domain.')), dom.br(), email = dom.input()), ' ', dom.submitbutton('Add address'))),
+	// Limits are server-wide policy, not for domain administrators.
+	identity ? [] : [
+		dom.br(),
+		dom.h2('Limits'),
+		dom.form(fieldsetLimits = dom.fieldset(dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Maximum outgoing messages per day', attr.title('Maximum number of outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 1000. MaxOutgoingMessagesPerDay in configuration file.')), dom.br(), maxOutgoingMessagesPerDay = dom.input(attr.type('number'), attr.required(''), attr.value(config.MaxOutgoingMessagesPerDay || 1000))), dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Maximum first-time recipients per day', attr.title('Maximum number of first-time recipients in outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 200. MaxFirstTimeRecipientsPerDay in configuration file.')), dom.br(), maxFirstTimeRecipientsPerDay = dom.input(attr.type('number'), attr.required(''), attr.value(config.MaxFirstTimeRecipientsPerDay || 200))), dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Disk usage quota: Maximum total message size ', attr.title('Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage.')), dom.br(), quotaMessageSize = dom.input(attr.value(formatQuotaSize(config.QuotaMessageSize)))), dom.submitbutton('Save')), async function submit(e) {
+			e.stopPropagation();
+			e.preventDefault();
+			fieldsetLimits.disabled = true;
+			try {
+				await client.SetAccountLimits(name, parseInt(maxOutgoingMessagesPerDay.value) || 0, parseInt(maxFirstTimeRecipientsPerDay.value) || 0, xparseSize(quotaMessageSize.value));
+				window.alert('Limits saved.');
+			}
+			catch (err) {
+				console.log({ err });
+				window.alert('Error: ' + errmsg(err));
+				return;
+			}
+			finally {
+				fieldsetLimits.disabled = false;
+			}
+		}),
+	], dom.br(), dom.h2('Set new password'), formPassword = dom.form(fieldsetPassword = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'New password', dom.br(), password = dom.input(attr.type('password'), attr.autocomplete('new-password'), attr.required(''), function focus() {
 		passwordHint.style.display = '';
 	})), ' ', dom.submitbutton('Change password')), passwordHint = dom.div(style({ display: 'none', marginTop: '.5ex' }), dom.clickbutton('Generate random password', function click(e) {
 		e.preventDefault();
@@ -1890,7 +2111,25 @@ const account = async (name) => {
 		finally {
 			fieldsetPassword.disabled = false;
 		}
-	}), dom.br(), dom.h2('Danger'), dom.clickbutton('Remove account', async function click(e) {
+	}), dom.br(), dom.h2('Danger'), dom.clickbutton('Reset two-factor authentication', async function click(e) {
+		e.preventDefault();
+		if (!window.confirm('Are you sure you want to disable two-factor authentication for this account? Logins to the web interface will only require the password.')) {
+			return;
+		}
+		const target = e.target;
+		target.disabled = true;
+		try {
+			await client.AccountTOTPReset(name);
+			window.alert('Two-factor authentication has been reset.');
+		}
+		catch (err) {
+			console.log({ err });
+			window.alert('Error: ' + errmsg(err));
+		}
+		finally {
+			target.disabled = false;
+		}
+	}), ' ', dom.clickbutton('Remove account', async function click(e) {
 		e.preventDefault();
 		if (!window.confirm('Are you sure you want to remove this account?')) {
 			return;
@@ -1914,17 +2153,27 @@ const account = async (name) => {
 const domain = async (d) => {
 	const end = new Date();
 	const start = new Date(new Date().getTime() - 30 * 24 * 3600 * 1000);
-	const [dmarcSummaries, tlsrptSummaries, localpartAccounts, dnsdomain, clientConfigs] = await Promise.all([
+	const [dmarcSummaries, tlsrptSummaries, localpartAccounts, aliases, dnsdomain, clientConfigs, admins, identity] = await Promise.all([
 		client.DMARCSummaries(start, end, d),
 		client.TLSRPTSummaries(start, end, d),
 		client.DomainLocalparts(d),
+		client.DomainAliases(d),
 		client.Domain(d),
 		client.ClientConfigsDomain(d),
+		client.DomainAdmins(d),
+		client.Identity(),
 	]);
 	let form;
 	let fieldset;
 	let localpart;
 	let account;
+	let aliasForm;
+	let aliasFieldset;
+	let aliasLocalpart;
+	let aliasAddresses;
+	let aliasPostPublic;
+	let adminsFieldset;
+	let adminsAccounts;
 	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Domain ' + domainString(dnsdomain)), dom.ul(dom.li(dom.a('Required DNS records', attr.href('#domains/' + d + '/dnsrecords'))), dom.li(dom.a('Check current actual DNS records and domain configuration', attr.href('#domains/' + d + '/dnscheck')))), dom.br(), dom.h2('Client configuration'), dom.p('If autoconfig/autodiscover does not work with an email client, use the settings below for this domain. Authenticate with email address and password. ', dom.span('Explicitly configure', attr.title('To prevent authentication mechanism downgrade attempts that may result in clients sending plain text passwords to a MitM.')), ' the first supported authentication mechanism: SCRAM-SHA-256-PLUS, SCRAM-SHA-1-PLUS, SCRAM-SHA-256, SCRAM-SHA-1, CRAM-MD5.'), dom.table(dom.thead(dom.tr(dom.th('Protocol'), dom.th('Host'), dom.th('Port'), dom.th('Listener'), dom.th('Note'))), dom.tbody((clientConfigs.Entries || []).map(e => dom.tr(dom.td(e.Protocol), dom.td(domainString(e.Host)), dom.td('' + e.Port), dom.td('' + e.Listener), dom.td('' + e.Note))))), dom.br(), dom.h2('DMARC aggregate reports summary'), renderDMARCSummaries(dmarcSummaries || []), dom.br(), dom.h2('TLS reports summary'), renderTLSRPTSummaries(tlsrptSummaries || []), dom.br(), dom.h2('Addresses'), dom.table(dom.thead(dom.tr(dom.th('Address'), dom.th('Account'), dom.th('Action'))), dom.tbody(Object.entries(localpartAccounts).map(t => dom.tr(dom.td(t[0] || '(catchall)'), dom.td(dom.a(t[1], attr.href('#accounts/' + t[1]))), dom.td(dom.clickbutton('Remove', async function click(e) {
 		e.preventDefault();
 		if (!window.confirm('Are you sure you want to remove this address?')) {
@@ -1961,15 +2210,112 @@ const domain = async (d) => {
 		}
 		form.reset();
 		window.location.reload(); // todo: only reload the addresses
-	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Localpart', attr.title('An empty localpart is the catchall destination/address for the domain.')), dom.br(), localpart = dom.input()), ' ', dom.label(style({ display: 'inline-block' }), 'Account', dom.br(), account = dom.input(attr.required(''))), ' ', dom.submitbutton('Add address', attr.title('Address will be added and the config reloaded.')))), dom.br(), dom.h2('External checks'), dom.ul(dom.li(link('https://internet.nl/mail/' + dnsdomain.ASCII + '/', 'Check configuration at internet.nl'))), dom.br(), dom.h2('Danger'), dom.clickbutton('Remove domain', async function click(e) {
+	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Localpart', attr.title('An empty localpart is the catchall destination/address for the domain.')), dom.br(), localpart = dom.input()), ' ', dom.label(style({ display: 'inline-block' }), 'Account', dom.br(), account = dom.input(attr.required(''))), ' ', dom.submitbutton('Add address', attr.title('Address will be added and the config reloaded.')))), dom.br(), dom.h2('Aliases'), dom.p('Messages sent to an alias are delivered to all its members. Members can be addresses of accounts, or external addresses to forward messages to.'), Object.keys(aliases).length === 0 ? dom.p('No aliases.') : dom.table(dom.thead(dom.tr(dom.th('Alias'), dom.th('Members'), dom.th('Who can send'))), dom.tbody(Object.entries(aliases).sort((a, b) => a[0] < b[0] ? -1 : 1).map(t => dom.tr(dom.td(dom.a(t[0] + '@' + domainName(dnsdomain), attr.href('#domains/' + d + '/alias/' + encodeURIComponent(t[0])))), dom.td((t[1].Addresses || []).join(', ')), dom.td(t[1].PostPublic ? 'Anyone' : 'Members'))))), dom.br(), dom.h2('Add alias'), aliasForm = dom.form(async function submit(e) {
 		e.preventDefault();
-		if (!window.confirm('Are you sure you want to remove this domain?')) {
+		e.stopPropagation();
+		aliasFieldset.disabled = true;
+		try {
+			await client.AliasAdd(aliasLocalpart.value, d, {
+				Addresses: aliasAddresses.value.split('\n').map(s => s.trim()).filter(s => s),
+				PostPublic: aliasPostPublic.checked,
+				AllowMsgFrom: [],
+			});
+		}
+		catch (err) {
+			console.log({ err });
+			window.alert('Error: ' + errmsg(err));
+			return;
+		}
+		finally {
+			aliasFieldset.disabled = false;
+		}
+		aliasForm.reset();
+		window.location.reload(); // todo: only reload the aliases
+	}, aliasFieldset = dom.fieldset(dom.label(style({ display: 'inline-block', verticalAlign: 'top' }), dom.span('Localpart', attr.title('Localpart of the alias address, in lower case unless the domain is configured with case-sensitive localparts.')), dom.br(), aliasLocalpart = dom.input(attr.required(''))), ' ', dom.label(style({ display: 'inline-block', verticalAlign: 'top' }), dom.span('Addresses', attr.title('Addresses of the members, one per line. Local addresses must be addresses of accounts. Messages are forwarded to external addresses.')), dom.br(), aliasAddresses = dom.textarea(attr.required(''), attr.rows('3'))), ' ', dom.label(style({ display: 'inline-block', verticalAlign: 'top' }), aliasPostPublic = dom.input(attr.type('checkbox')), ' Anyone can send', attr.title('If not checked, only members can send messages to the alias.')), ' ', dom.submitbutton('Add alias', attr.title('Alias will be added and the config reloaded.')))), dom.br(), dom.h2('Domain administrators'), dom.p('Domain administrators log in to this admin interface with the email address and password of their account. They can manage accounts, addresses and aliases of this domain, but cannot change server-wide settings.'), identity ? ((admins || []).length === 0 ? dom.p('None') : dom.ul((admins || []).map(a => dom.li(a)))) :
+		dom.form(async function submit(e) {
+			e.preventDefault();
+			e.stopPropagation();
+			adminsFieldset.disabled = true;
+			try {
+				await client.DomainAdminsSave(d, adminsAccounts.value.split(',').map(s => s.trim()).filter(s => !!s));
+				window.alert('Domain administrators saved.');
+			}
+			catch (err) {
+				console.log({ err });
+				window.alert('Error: ' + errmsg(err));
+				return;
+			}
+			finally {
+				adminsFieldset.disabled = false;
+			}
+		}, adminsFieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Accounts', attr.title('Names of accounts that can administer this domain, separated by commas.')), dom.br(), adminsAccounts = dom.input(attr.value((admins || []).join(', ')))), ' ', dom.submitbutton('Save'))), dom.br(), dom.h2('External checks'), dom.ul(dom.li(link('https://internet.nl/mail/' + dnsdomain.ASCII + '/', 'Check configuration at internet.nl'))), identity ? [] : [
+		dom.br(),
+		dom.h2('Danger'),
+		dom.clickbutton('Remove domain', async function click(e) {
+			e.preventDefault();
+			if (!window.confirm('Are you sure you want to remove this domain?')) {
+				return;
+			}
+			const target = e.target;
+			target.disabled = true;
+			try {
+				await client.DomainRemove(d);
+			}
+			catch (err) {
+				console.log({ err });
+				window.alert('Error: ' + errmsg(err));
+				return;
+			}
+			finally {
+				target.disabled = false;
+			}
+			window.location.hash = '#';
+		}),
+	]);
+};
+const domainAlias = async (d, aliasLocalpart) => {
+	const [aliases, dnsdomain] = await Promise.all([
+		client.DomainAliases(d),
+		client.Domain(d),
+	]);
+	const alias = aliases[aliasLocalpart];
+	if (!alias) {
+		throw new Error('alias not found');
+	}
+	let fieldset;
+	let addresses;
+	let postPublic;
+	let allowMsgFrom;
+	const lines = (s) => s.split('\n').map(s => s.trim()).filter(s => s);
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), crumblink('Domain ' + domainString(dnsdomain), '#domains/' + d), 'Alias ' + aliasLocalpart + '@' + domainName(dnsdomain)), dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		fieldset.disabled = true;
+		try {
+			await client.AliasUpdate(aliasLocalpart, d, {
+				Addresses: lines(addresses.value),
+				PostPublic: postPublic.checked,
+				AllowMsgFrom: lines(allowMsgFrom.value),
+			});
+		}
+		catch (err) {
+			console.log({ err });
+			window.alert('Error: ' + errmsg(err));
+			return;
+		}
+		finally {
+			fieldset.disabled = false;
+		}
+		window.location.reload(); // todo: only reload the alias
+	}, fieldset = dom.fieldset(dom.div(dom.span('Addresses', attr.title('Addresses of the members, one per line. Local addresses must be addresses of accounts, messages are delivered to their accounts. Messages are forwarded to external addresses, after spam filtering with the account of the first local address.')), dom.br(), addresses = dom.textarea(attr.required(''), attr.rows('6'), style({ width: '100%', maxWidth: '30em' }), (alias.Addresses || []).join('\n'))), dom.br(), dom.div(dom.label(postPublic = dom.input(attr.type('checkbox'), alias.PostPublic ? attr.checked('') : []), ' Anyone can send messages to the alias', attr.title('If not checked, only members and the additional addresses below can send messages to the alias. The message From address must be verified by DKIM and/or SPF, aligned as with DMARC.'))), dom.br(), dom.div(dom.span('Additional addresses that can send', attr.title('Addresses that are not members but can send messages to the alias, one per line. Only used if not everyone can send.')), dom.br(), allowMsgFrom = dom.textarea(attr.rows('3'), style({ width: '100%', maxWidth: '30em' }), (alias.AllowMsgFrom || []).join('\n'))), dom.br(), dom.submitbutton('Save', attr.title('Alias will be saved and the config reloaded.')))), dom.br(), dom.h2('Danger'), dom.clickbutton('Remove alias', async function click(e) {
+		e.preventDefault();
+		if (!window.confirm('Are you sure you want to remove this alias?')) {
 			return;
 		}
 		const target = e.target;
 		target.disabled = true;
 		try {
-			await client.DomainRemove(d);
+			await client.AliasRemove(aliasLocalpart, d);
 		}
 		catch (err) {
 			console.log({ err });
@@ -1979,7 +2325,7 @@ const domain = async (d) => {
 		finally {
 			target.disabled = false;
 		}
-		window.location.hash = '#';
+		window.location.hash = '#domains/' + d;
 	}));
 };
 const domainDNSRecords = async (d) => {
@@ -3042,6 +3388,9 @@ const init = async () => {
 			else if (h === 'loglevels') {
 				await loglevels();
 			}
+			else if (h === 'twofactor') {
+				await twofactor();
+			}
 			else if (h === 'accounts') {
 				await accounts();
 			}
@@ -3063,6 +3412,9 @@ const init = async () => {
 			else if (t[0] === 'domains' && t.length === 3 && t[2] === 'dnsrecords') {
 				await domainDNSRecords(t[1]);
 			}
+			else if (t[0] === 'domains' && t.length === 4 && t[2] === 'alias') {
+				await domainAlias(t[1], t[3]);
+			}
 			else if (h === 'queue') {
 				await queueList();
 			}
@@ -9,7 +9,10 @@ const login = async (reason: string) => {
 		const origFocus = document.activeElement
 		let reasonElem: HTMLElement
 		let fieldset: HTMLFieldSetElement
+		let username: HTMLInputElement
 		let password: HTMLInputElement
+		let totpBox: HTMLElement
+		let totpCode: HTMLInputElement
 		const root = dom.div(
 			style({position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in'}),
 			dom.div(
@@ -23,10 +26,11 @@ const login = async (reason: string) => {
 
 							reasonElem.remove()
 
+							let totpRequired = false
 							try {
 								fieldset.disabled = true
 								const loginToken = await client.LoginPrep()
-								const token = await client.Login(loginToken, password.value)
+								const token = await client.Login(loginToken, username.value, password.value, totpCode.value)
 								try {
 									window.localStorage.setItem('webadmincsrftoken', token)
 								} catch (err) {
@@ -39,18 +43,38 @@ const login = async (reason: string) => {
 								resolve(token)
 							} catch (err) {
 								console.log('login error', err)
-								window.alert('Error: ' + errmsg(err))
+								if ((err as any).code === 'user:totpRequired') {
+									// Password is valid, a code for two-factor authentication is required too.
+									totpRequired = true
+								} else {
+									window.alert('Error: ' + errmsg(err))
+								}
 							} finally {
 								fieldset.disabled = false
 							}
+							if (totpRequired) {
+								totpBox.style.display = 'block'
+								totpCode.required = true
+								totpCode.focus()
+							}
 						},
 						fieldset=dom.fieldset(
 							dom.h1('Admin'),
+							dom.label(
+								style({display: 'block', marginBottom: '2ex'}),
+								dom.div('Email address (domain administrators only)', style({marginBottom: '.5ex'})),
+								username=dom.input(attr.autocomplete('username'), attr.title('Domain administrators log in with the email address and password of their account. Leave empty to log in as administrator with the admin password.')),
+							),
 							dom.label(
 								style({display: 'block', marginBottom: '2ex'}),
 								dom.div('Password', style({marginBottom: '.5ex'})),
 								password=dom.input(attr.type('password'), attr.required('')),
 							),
+							totpBox=dom.label(
+								style({display: 'none', marginBottom: '2ex'}),
+								dom.div('Two-factor authentication code', style({marginBottom: '.5ex'})),
+								totpCode=dom.input(attr.autocomplete('one-time-code'), attr.title('Code from your authenticator app, or one of your recovery codes.')),
+							),
 							dom.div(
 								style({textAlign: 'center'}),
 								dom.submitbutton('Login'),
@@ -254,6 +278,26 @@ const formatSize = (n: number) => {
 }
 
 const index = async () => {
+	const identity = await client.Identity()
+	if (identity) {
+		// Domain administrator, only managing accounts and addresses of its domains.
+		const domains = await client.Domains()
+		dom._kids(page,
+			crumbs('Mox Admin'),
+			dom.p('Logged in as domain administrator ', dom.b(identity), '.'),
+			dom.p(
+				dom.a('Accounts', attr.href('#accounts')), dom.br(),
+			),
+			dom.h2('Domains'),
+			(domains || []).length === 0 ? box(red, 'No domains') :
+			dom.ul(
+				(domains || []).map(d => dom.li(dom.a(attr.href('#domains/'+domainName(d)), domainString(d)))),
+			),
+			footer,
+		)
+		return
+	}
+
 	const [domains, queueSize, checkUpdatesEnabled] = await Promise.all([
 		client.Domains(),
 		client.QueueSize(),
@@ -338,6 +382,7 @@ const index = async () => {
 		dom.div(dom.a('Webserver', attr.href('#webserver'))),
 		dom.div(dom.a('Files', attr.href('#config'))),
 		dom.div(dom.a('Log levels', attr.href('#loglevels'))),
+		dom.div(dom.a('Two-factor authentication', attr.href('#twofactor'))),
 		footer,
 	)
 }
@@ -357,6 +402,113 @@ const config = async () => {
 	)
 }
 
+const twofactor = async () => {
+	const [enabled, remaining] = await client.TOTPGet()
+
+	let totpBox: HTMLElement
+
+	// If recoveryCodes is set, two-factor authentication was just enabled and the new
+	// recovery codes are shown.
+	const render = (enabled: boolean, remaining: number, recoveryCodes?: string[]) => {
+		if (enabled) {
+			dom._kids(totpBox,
+				recoveryCodes ? box(blue,
+					'Two-factor authentication has been enabled. Store these recovery codes in a safe place now, they will not be shown again. Each can be used once instead of a code from your authenticator app.',
+					dom.ul(recoveryCodes.map(c => dom.li(dom.span(style({fontFamily: 'monospace', fontWeight: 'bold'}), c)))),
+				) : [],
+				dom.p('Two-factor authentication is enabled. Recovery codes left: ' + remaining + '.'),
+				dom.clickbutton('Disable two-factor authentication', async function click(e: MouseEvent) {
+					if (!window.confirm('Are you sure you want to disable two-factor authentication? Logins to the admin interface will only require the admin password.')) {
+						return
+					}
+					const b = e.target! as HTMLButtonElement
+					try {
+						b.disabled = true
+						await client.TOTPDisable()
+						render(false, 0)
+					} catch (err) {
+						console.log({err})
+						window.alert('Error: ' + errmsg(err))
+					} finally {
+						b.disabled = false
+					}
+				}),
+			)
+			return
+		}
+
+		dom._kids(totpBox,
+			dom.p('Two-factor authentication is not enabled.'),
+			dom.clickbutton('Enable two-factor authentication', async function click(e: MouseEvent) {
+				const b = e.target! as HTMLButtonElement
+				try {
+					b.disabled = true
+					const [key, uri, qrcode] = await client.TOTPEnrollStart()
+					renderEnroll(key, uri, qrcode)
+				} catch (err) {
+					console.log({err})
+					window.alert('Error: ' + errmsg(err))
+				} finally {
+					b.disabled = false
+				}
+			}),
+		)
+	}
+
+	const renderEnroll = (key: string, uri: string, qrcode: string) => {
+		let fieldset: HTMLFieldSetElement
+		let code: HTMLInputElement
+		dom._kids(totpBox,
+			dom.p('Scan the QR code with your authenticator app, or enter the key manually. Then enter the code shown by the app to enable two-factor authentication.'),
+			dom.img(attr.src(qrcode), attr.title(uri)),
+			dom.div('Key: ', dom.span(style({fontFamily: 'monospace'}), key)),
+			dom.br(),
+			dom.form(
+				fieldset=dom.fieldset(
+					dom.label(
+						style({display: 'inline-block'}),
+						'Code',
+						dom.br(),
+						code=dom.input(attr.required(''), attr.autocomplete('one-time-code')),
+					),
+					' ',
+					dom.submitbutton('Enable'),
+					' ',
+					dom.clickbutton('Cancel', function click() {
+						render(false, 0)
+					}),
+				),
+				async function submit(e: SubmitEvent) {
+					e.stopPropagation()
+					e.preventDefault()
+					fieldset.disabled = true
+					try {
+						const codes = await client.TOTPEnrollFinish(code.value) || []
+						render(true, codes.length, codes)
+					} catch (err) {
+						console.log({err})
+						window.alert('Error: ' + errmsg(err))
+					} finally {
+						fieldset.disabled = false
+					}
+				},
+			),
+		)
+		code.focus()
+	}
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Two-factor authentication',
+		),
+		dom.p('With two-factor authentication, logging in to the admin interface requires a code from an authenticator app on your phone in addition to the admin password. Other admin sessions are logged out when it is enabled.'),
+		totpBox=dom.div(),
+		footer,
+	)
+	render(enabled, remaining)
+}
+
 const loglevels = async () => {
 	const loglevels = await client.LogLevels()
 
@@ -552,7 +704,10 @@ const accounts = async () => {
 }
 
 const account = async (name: string) => {
-	const config = await client.Account(name)
+	const [config, identity] = await Promise.all([
+		client.Account(name),
+		client.Identity(),
+	])
 
 	let form: HTMLFormElement
 	let fieldset: HTMLFieldSetElement
@@ -709,46 +864,49 @@ const account = async (name: string) => {
 				dom.submitbutton('Add address'),
 			),
 		),
-		dom.br(),
-		dom.h2('Limits'),
-		dom.form(
-			fieldsetLimits=dom.fieldset(
-				dom.label(
-					style({display: 'block', marginBottom: '.5ex'}),
-					dom.span('Maximum outgoing messages per day', attr.title('Maximum number of outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 1000. MaxOutgoingMessagesPerDay in configuration file.')),
-					dom.br(),
-					maxOutgoingMessagesPerDay=dom.input(attr.type('number'), attr.required(''), attr.value(config.MaxOutgoingMessagesPerDay || 1000)),
-				),
-				dom.label(
-					style({display: 'block', marginBottom: '.5ex'}),
-					dom.span('Maximum first-time recipients per day', attr.title('Maximum number of first-time recipients in outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 200. MaxFirstTimeRecipientsPerDay in configuration file.')),
-					dom.br(),
-					maxFirstTimeRecipientsPerDay=dom.input(attr.type('number'), attr.required(''), attr.value(config.MaxFirstTimeRecipientsPerDay || 200)),
-				),
-				dom.label(
-					style({display: 'block', marginBottom: '.5ex'}),
-					dom.span('Disk usage quota: Maximum total message size ', attr.title('Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage.')),
-					dom.br(),
-					quotaMessageSize=dom.input(attr.value(formatQuotaSize(config.QuotaMessageSize))),
+		// Limits are server-wide policy, not for domain administrators.
+		identity ? [] : [
+			dom.br(),
+			dom.h2('Limits'),
+			dom.form(
+				fieldsetLimits=dom.fieldset(
+					dom.label(
+						style({display: 'block', marginBottom: '.5ex'}),
+						dom.span('Maximum outgoing messages per day', attr.title('Maximum number of outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 1000. MaxOutgoingMessagesPerDay in configuration file.')),
+						dom.br(),
+						maxOutgoingMessagesPerDay=dom.input(attr.type('number'), attr.required(''), attr.value(config.MaxOutgoingMessagesPerDay || 1000)),
+					),
+					dom.label(
+						style({display: 'block', marginBottom: '.5ex'}),
+						dom.span('Maximum first-time recipients per day', attr.title('Maximum number of first-time recipients in outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 200. MaxFirstTimeRecipientsPerDay in configuration file.')),
+						dom.br(),
+						maxFirstTimeRecipientsPerDay=dom.input(attr.type('number'), attr.required(''), attr.value(config.MaxFirstTimeRecipientsPerDay || 200)),
+					),
+					dom.label(
+						style({display: 'block', marginBottom: '.5ex'}),
+						dom.span('Disk usage quota: Maximum total message size ', attr.title('Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage.')),
+						dom.br(),
+						quotaMessageSize=dom.input(attr.value(formatQuotaSize(config.QuotaMessageSize))),
+					),
+					dom.submitbutton('Save'),
 				),
-				dom.submitbutton('Save'),
+				async function submit(e: SubmitEvent) {
+					e.stopPropagation()
+					e.preventDefault()
+					fieldsetLimits.disabled = true
+					try {
+						await client.SetAccountLimits(name, parseInt(maxOutgoingMessagesPerDay.value) || 0, parseInt(maxFirstTimeRecipientsPerDay.value) || 0, xparseSize(quotaMessageSize.value))
+						window.alert('Limits saved.')
+					} catch (err) {
+						console.log({err})
+						window.alert('Error: ' + errmsg(err))
+						return
+					} finally {
+						fieldsetLimits.disabled = false
+					}
+				},
 			),
-			async function submit(e: SubmitEvent) {
-				e.stopPropagation()
-				e.preventDefault()
-				fieldsetLimits.disabled = true
-				try {
-					await client.SetAccountLimits(name, parseInt(maxOutgoingMessagesPerDay.value) || 0, parseInt(maxFirstTimeRecipientsPerDay.value) || 0, xparseSize(quotaMessageSize.value))
-					window.alert('Limits saved.')
-				} catch (err) {
-					console.log({err})
-					window.alert('Error: ' + errmsg(err))
-					return
-				} finally {
-					fieldsetLimits.disabled = false
-				}
-			},
-		),
+		],
 		dom.br(),
 		dom.h2('Set new password'),
 		formPassword=dom.form(
@@ -804,6 +962,24 @@ const account = async (name: string) => {
 		),
 		dom.br(),
 		dom.h2('Danger'),
+		dom.clickbutton('Reset two-factor authentication', async function click(e: MouseEvent) {
+			e.preventDefault()
+			if (!window.confirm('Are you sure you want to disable two-factor authentication for this account? Logins to the web interface will only require the password.')) {
+				return
+			}
+			const target = e.target! as HTMLButtonElement
+			target.disabled = true
+			try {
+				await client.AccountTOTPReset(name)
+				window.alert('Two-factor authentication has been reset.')
+			} catch (err) {
+				console.log({err})
+				window.alert('Error: ' + errmsg(err))
+			} finally {
+				target.disabled = false
+			}
+		}),
+		' ',
 		dom.clickbutton('Remove account', async function click(e: MouseEvent) {
 			e.preventDefault()
 			if (!window.confirm('Are you sure you want to remove this account?')) {
@@ -828,12 +1004,15 @@ const account = async (name: string) => {
 const domain = async (d: string) => {
 	const end = new Date()
 	const start = new Date(new Date().getTime() - 30*24*3600*1000)
-	const [dmarcSummaries, tlsrptSummaries, localpartAccounts, dnsdomain, clientConfigs] = await Promise.all([
+	const [dmarcSummaries, tlsrptSummaries, localpartAccounts, aliases, dnsdomain, clientConfigs, admins, identity] = await Promise.all([
 		client.DMARCSummaries(start, end, d),
 		client.TLSRPTSummaries(start, end, d),
 		client.DomainLocalparts(d),
+		client.DomainAliases(d),
 		client.Domain(d),
 		client.ClientConfigsDomain(d),
+		client.DomainAdmins(d),
+		client.Identity(),
 	])
 
 	let form: HTMLFormElement
@@ -841,6 +1020,15 @@ const domain = async (d: string) => {
 	let localpart: HTMLInputElement
 	let account: HTMLInputElement
 
+	let aliasForm: HTMLFormElement
+	let aliasFieldset: HTMLFieldSetElement
+	let aliasLocalpart: HTMLInputElement
+	let aliasAddresses: HTMLTextAreaElement
+	let aliasPostPublic: HTMLInputElement
+
+	let adminsFieldset: HTMLFieldSetElement
+	let adminsAccounts: HTMLInputElement
+
 	dom._kids(page,
 		crumbs(
 			crumblink('Mox Admin', '#'),
@@ -952,21 +1140,211 @@ const domain = async (d: string) => {
 			),
 		),
 		dom.br(),
+		dom.h2('Aliases'),
+		dom.p('Messages sent to an alias are delivered to all its members. Members can be addresses of accounts, or external addresses to forward messages to.'),
+		Object.keys(aliases).length === 0 ? dom.p('No aliases.') : dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Alias'), dom.th('Members'), dom.th('Who can send'),
+				),
+			),
+			dom.tbody(
+				Object.entries(aliases).sort((a, b) => a[0] < b[0] ? -1 : 1).map(t =>
+					dom.tr(
+						dom.td(dom.a(t[0] + '@' + domainName(dnsdomain), attr.href('#domains/' + d + '/alias/' + encodeURIComponent(t[0])))),
+						dom.td((t[1].Addresses || []).join(', ')),
+						dom.td(t[1].PostPublic ? 'Anyone' : 'Members'),
+					),
+				),
+			),
+		),
+		dom.br(),
+		dom.h2('Add alias'),
+		aliasForm=dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				aliasFieldset.disabled = true
+				try {
+					await client.AliasAdd(aliasLocalpart.value, d, {
+						Addresses: aliasAddresses.value.split('\n').map(s => s.trim()).filter(s => s),
+						PostPublic: aliasPostPublic.checked,
+						AllowMsgFrom: [],
+					})
+				} catch (err) {
+					console.log({err})
+					window.alert('Error: ' + errmsg(err))
+					return
+				} finally {
+					aliasFieldset.disabled = false
+				}
+				aliasForm.reset()
+				window.location.reload() // todo: only reload the aliases
+			},
+			aliasFieldset=dom.fieldset(
+				dom.label(
+					style({display: 'inline-block', verticalAlign: 'top'}),
+					dom.span('Localpart', attr.title('Localpart of the alias address, in lower case unless the domain is configured with case-sensitive localparts.')),
+					dom.br(),
+					aliasLocalpart=dom.input(attr.required('')),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block', verticalAlign: 'top'}),
+					dom.span('Addresses', attr.title('Addresses of the members, one per line. Local addresses must be addresses of accounts. Messages are forwarded to external addresses.')),
+					dom.br(),
+					aliasAddresses=dom.textarea(attr.required(''), attr.rows('3')),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block', verticalAlign: 'top'}),
+					aliasPostPublic=dom.input(attr.type('checkbox')),
+					' Anyone can send',
+					attr.title('If not checked, only members can send messages to the alias.'),
+				),
+				' ',
+				dom.submitbutton('Add alias', attr.title('Alias will be added and the config reloaded.')),
+			),
+		),
+		dom.br(),
+		dom.h2('Domain administrators'),
+		dom.p('Domain administrators log in to this admin interface with the email address and password of their account. They can manage accounts, addresses and aliases of this domain, but cannot change server-wide settings.'),
+		identity ? ((admins || []).length === 0 ? dom.p('None') : dom.ul((admins || []).map(a => dom.li(a)))) :
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				adminsFieldset.disabled = true
+				try {
+					await client.DomainAdminsSave(d, adminsAccounts.value.split(',').map(s => s.trim()).filter(s => !!s))
+					window.alert('Domain administrators saved.')
+				} catch (err) {
+					console.log({err})
+					window.alert('Error: ' + errmsg(err))
+					return
+				} finally {
+					adminsFieldset.disabled = false
+				}
+			},
+			adminsFieldset=dom.fieldset(
+				dom.label(
+					style({display: 'inline-block'}),
+					dom.span('Accounts', attr.title('Names of accounts that can administer this domain, separated by commas.')),
+					dom.br(),
+					adminsAccounts=dom.input(attr.value((admins || []).join(', '))),
+				),
+				' ',
+				dom.submitbutton('Save'),
+			),
+		),
+		dom.br(),
 		dom.h2('External checks'),
 		dom.ul(
 			dom.li(link('https://internet.nl/mail/'+dnsdomain.ASCII+'/', 'Check configuration at internet.nl')),
 		),
+		identity ? [] : [
+			dom.br(),
+			dom.h2('Danger'),
+			dom.clickbutton('Remove domain', async function click(e: MouseEvent) {
+				e.preventDefault()
+				if (!window.confirm('Are you sure you want to remove this domain?')) {
+					return
+				}
+				const target = e.target! as HTMLButtonElement
+				target.disabled = true
+				try {
+					await client.DomainRemove(d)
+				} catch (err) {
+					console.log({err})
+					window.alert('Error: ' + errmsg(err))
+					return
+				} finally {
+					target.disabled = false
+				}
+				window.location.hash = '#'
+			}),
+		],
+	)
+}
+
+const domainAlias = async (d: string, aliasLocalpart: string) => {
+	const [aliases, dnsdomain] = await Promise.all([
+		client.DomainAliases(d),
+		client.Domain(d),
+	])
+	const alias = aliases[aliasLocalpart]
+	if (!alias) {
+		throw new Error('alias not found')
+	}
+
+	let fieldset: HTMLFieldSetElement
+	let addresses: HTMLTextAreaElement
+	let postPublic: HTMLInputElement
+	let allowMsgFrom: HTMLTextAreaElement
+
+	const lines = (s: string) => s.split('\n').map(s => s.trim()).filter(s => s)
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			crumblink('Domain ' + domainString(dnsdomain), '#domains/'+d),
+			'Alias ' + aliasLocalpart + '@' + domainName(dnsdomain),
+		),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				fieldset.disabled = true
+				try {
+					await client.AliasUpdate(aliasLocalpart, d, {
+						Addresses: lines(addresses.value),
+						PostPublic: postPublic.checked,
+						AllowMsgFrom: lines(allowMsgFrom.value),
+					})
+				} catch (err) {
+					console.log({err})
+					window.alert('Error: ' + errmsg(err))
+					return
+				} finally {
+					fieldset.disabled = false
+				}
+				window.location.reload() // todo: only reload the alias
+			},
+			fieldset=dom.fieldset(
+				dom.div(
+					dom.span('Addresses', attr.title('Addresses of the members, one per line. Local addresses must be addresses of accounts, messages are delivered to their accounts. Messages are forwarded to external addresses, after spam filtering with the account of the first local address.')),
+					dom.br(),
+					addresses=dom.textarea(attr.required(''), attr.rows('6'), style({width: '100%', maxWidth: '30em'}), (alias.Addresses || []).join('\n')),
+				),
+				dom.br(),
+				dom.div(
+					dom.label(
+						postPublic=dom.input(attr.type('checkbox'), alias.PostPublic ? attr.checked('') : []),
+						' Anyone can send messages to the alias',
+						attr.title('If not checked, only members and the additional addresses below can send messages to the alias. The message From address must be verified by DKIM and/or SPF, aligned as with DMARC.'),
+					),
+				),
+				dom.br(),
+				dom.div(
+					dom.span('Additional addresses that can send', attr.title('Addresses that are not members but can send messages to the alias, one per line. Only used if not everyone can send.')),
+					dom.br(),
+					allowMsgFrom=dom.textarea(attr.rows('3'), style({width: '100%', maxWidth: '30em'}), (alias.AllowMsgFrom || []).join('\n')),
+				),
+				dom.br(),
+				dom.submitbutton('Save', attr.title('Alias will be saved and the config reloaded.')),
+			),
+		),
 		dom.br(),
 		dom.h2('Danger'),
-		dom.clickbutton('Remove domain', async function click(e: MouseEvent) {
+		dom.clickbutton('Remove alias', async function click(e: MouseEvent) {
 			e.preventDefault()
-			if (!window.confirm('Are you sure you want to remove this domain?')) {
+			if (!window.confirm('Are you sure you want to remove this alias?')) {
 				return
 			}
 			const target = e.target! as HTMLButtonElement
 			target.disabled = true
 			try {
-				await client.DomainRemove(d)
+				await client.AliasRemove(aliasLocalpart, d)
 			} catch (err) {
 				console.log({err})
 				window.alert('Error: ' + errmsg(err))
@@ -974,7 +1352,7 @@ const domain = async (d: string) => {
 			} finally {
 				target.disabled = false
 			}
-			window.location.hash = '#'
+			window.location.hash = '#domains/' + d
 		}),
 	)
 }
@@ -3022,6 +3400,8 @@ const init = async () => {
 				await config()
 			} else if (h === 'loglevels') {
 				await loglevels()
+			} else if (h === 'twofactor') {
+				await twofactor()
 			} else if (h === 'accounts') {
 				await accounts()
 			} else if (t[0] === 'accounts' && t.length === 2) {
@@ -3036,6 +3416,8 @@ const init = async () => {
 				await domainDNSCheck(t[1])
 			} else if (t[0] === 'domains' && t.length === 3 && t[2] === 'dnsrecords') {
 				await domainDNSRecords(t[1])
+			} else if (t[0] === 'domains' && t.length === 4 && t[2] === 'alias') {
+				await domainAlias(t[1], t[3])
 			} else if (h === 'queue') {
 				await queueList()
 			} else if (h === 'tlsrpt') {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/ed25519"
+	"encoding/base32"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -26,6 +27,7 @@ import (
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/totp"
 	"github.com/mjl-/mox/webauth"
 )
 
@@ -85,24 +87,25 @@ func TestAdminAuth(t *testing.T) {
 	err = os.WriteFile(path, adminpwhash, 0660)
 	tcheck(t, err, "write password file")
 	defer os.Remove(path)
+	defer os.Remove(path + ".totp")
 
 	api := Admin{cookiePath: "/admin/"}
 	apiHandler, err := makeSherpaHandler(api.cookiePath, false)
 	tcheck(t, err, "sherpa handler")
 
 	respRec := httptest.NewRecorder()
-	reqInfo := requestInfo{"", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	reqInfo := requestInfo{"", "", "", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}, nil}
 	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	// Missing login token.
-	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "moxtest123") })
+	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "", "moxtest123", "") })
 
 	// Login with loginToken.
 	loginCookie := &http.Cookie{Name: "webadminlogin"}
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
 
-	csrfToken := api.Login(ctx, loginCookie.Value, "moxtest123")
+	csrfToken := api.Login(ctx, loginCookie.Value, "", "moxtest123", "")
 	var sessionCookie *http.Cookie
 	for _, c := range respRec.Result().Cookies() {
 		if c.Name == "webadminsession" {
@@ -117,7 +120,7 @@ func TestAdminAuth(t *testing.T) {
 	// Valid loginToken, but bad credentials.
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "badauth") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "", "badauth", "") })
 
 	type httpHeaders [][2]string
 	ctJSON := [2]string{"Content-Type", "application/json; charset=utf-8"}
@@ -194,14 +197,170 @@ func TestAdminAuth(t *testing.T) {
 	testHTTPAuthAPI("GET", "/api/Transports", http.StatusMethodNotAllowed, nil, nil)
 	testHTTPAuthAPI("POST", "/api/Transports", http.StatusOK, httpHeaders{ctJSON}, nil)
 
-	// Logout needs session token.
+	// Logout and enabling two-factor authentication need session token.
 	reqInfo.SessionToken = store.SessionToken(strings.SplitN(sessionCookie.Value, " ", 2)[0])
 	ctx = context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
+	key, _, _ := api.TOTPEnrollStart(ctx)
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(key)
+	tcheck(t, err, "decode totp key")
+	counter := totp.Counter(time.Now())
+	tneedErrorCode(t, "user:error", func() { api.TOTPEnrollFinish(ctx, totp.Code(secret, counter+10)) })
+	recoveryCodes := api.TOTPEnrollFinish(ctx, totp.Code(secret, counter-1))
+	if enabled, n := api.TOTPGet(ctx); !enabled || n != len(recoveryCodes) {
+		t.Fatalf("got enabled %v with %d recovery codes, expected enabled with %d", enabled, n, len(recoveryCodes))
+	}
+	tneedErrorCode(t, "user:error", func() { api.TOTPEnrollStart(ctx) })
+
+	totpLogin := func(code string) {
+		t.Helper()
+		loginCookie.Value = api.LoginPrep(ctx)
+		reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
+		api.Login(ctx, loginCookie.Value, "", "moxtest123", code)
+	}
+	tneedErrorCode(t, "user:totpRequired", func() { totpLogin("") })
+	tneedErrorCode(t, "user:loginFailed", func() { totpLogin(totp.Code(secret, counter+10)) })
+	totpLogin(totp.Code(secret, counter))
+	tneedErrorCode(t, "user:loginFailed", func() { totpLogin(totp.Code(secret, counter)) }) // Replay.
+	totpLogin(recoveryCodes[0])
+	tneedErrorCode(t, "user:loginFailed", func() { totpLogin(recoveryCodes[0]) })
+
+	api.TOTPDisable(ctx)
+	totpLogin("")
+
 	api.Logout(ctx)
 	tneedErrorCode(t, "server:error", func() { api.Logout(ctx) })
 }
 
+func TestDomainAdmin(t *testing.T) {
+	os.RemoveAll("../testdata/webadmin/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/webadmin/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+	log := mlog.New("webadmin", nil)
+	defer store.Switchboard()()
+
+	// Changing aliases below writes the configuration file, restore it afterwards.
+	domainsConf, err := os.ReadFile(mox.ConfigDynamicPath)
+	tcheck(t, err, "read domains.conf")
+	defer func() {
+		err := os.WriteFile(mox.ConfigDynamicPath, domainsConf, 0660)
+		tcheck(t, err, "restore domains.conf")
+	}()
+
+	for _, name := range []string{"mjl", "otheradmin"} {
+		acc, err := store.OpenAccount(log, name)
+		tcheck(t, err, "open account")
+		err = acc.SetPassword(log, "test1234")
+		tcheck(t, err, "set password")
+		err = acc.Close()
+		tcheck(t, err, "close account")
+	}
+
+	api := Admin{cookiePath: "/admin/"}
+	apiHandler, err := makeSherpaHandler(api.cookiePath, false)
+	tcheck(t, err, "sherpa handler")
+
+	respRec := httptest.NewRecorder()
+	reqInfo := requestInfo{"", "", "", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}, nil}
+	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
+
+	login := func(username, password string) store.CSRFToken {
+		t.Helper()
+		loginCookie := &http.Cookie{Name: "webadminlogin"}
+		loginCookie.Value = api.LoginPrep(ctx)
+		reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
+		return api.Login(ctx, loginCookie.Value, username, password, "")
+	}
+
+	// Only accounts that are domain administrators can log in.
+	tneedErrorCode(t, "user:loginFailed", func() { login("mjl@mox.example", "test1234") })
+	tneedErrorCode(t, "user:loginFailed", func() { login("admin@other.example", "badpassword") })
+	csrfToken := login("admin@other.example", "test1234")
+	var sessionCookie *http.Cookie
+	for _, c := range respRec.Result().Cookies() {
+		if c.Name == "webadminsession" && strings.HasSuffix(c.Value, " otheradmin") {
+			sessionCookie = c
+		}
+	}
+	if sessionCookie == nil {
+		t.Fatalf("missing session cookie for domain administrator")
+	}
+
+	// Domain administrators can only call some functions.
+	testAPI := func(fn string, expErrCode string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/"+fn, strings.NewReader(`{"params": []}`))
+		req.Header.Add("Cookie", sessionCookie.String())
+		req.Header.Add("x-mox-csrf", string(csrfToken))
+		req.Header.Add("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		handle(apiHandler, false, rr, req)
+		var response struct {
+			Error *sherpa.Error `json:"error"`
+		}
+		err := json.NewDecoder(rr.Body).Decode(&response)
+		tcheck(t, err, "parsing response as json")
+		if expErrCode == "" && response.Error != nil || expErrCode != "" && (response.Error == nil || response.Error.Code != expErrCode) {
+			t.Fatalf("%s: got error %v, expected code %q", fn, response.Error, expErrCode)
+		}
+	}
+	testAPI("Domains", "")
+	testAPI("Identity", "")
+	testAPI("QueueSize", "user:error")
+	testAPI("Transports", "user:error")
+	testAPI("TOTPGet", "user:error")
+
+	reqInfo = requestInfo{"admin@other.example", "otheradmin", "", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}, nil}
+	ctx = context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
+
+	if s := api.Identity(ctx); s != "admin@other.example" {
+		t.Fatalf("got identity %q, expected admin@other.example", s)
+	}
+	if l := api.Domains(ctx); len(l) != 1 || l[0].Name() != "other.example" {
+		t.Fatalf("got domains %v, expected other.example", l)
+	}
+	if l := api.Accounts(ctx); len(l) != 2 || l[0] != "other" || l[1] != "otheradmin" {
+		t.Fatalf("got accounts %v, expected other and otheradmin", l)
+	}
+	if l := api.DomainAdmins(ctx, "other.example"); len(l) != 1 || l[0] != "otheradmin" {
+		t.Fatalf("got domain admins %v, expected otheradmin", l)
+	}
+	api.Domain(ctx, "other.example")
+	api.Account(ctx, "other")
+	api.DomainLocalparts(ctx, "other.example")
+	api.SetPassword(ctx, "other", "test12345")
+	api.AccountTOTPReset(ctx, "other")
+
+	tneedErrorCode(t, "user:error", func() { api.Domain(ctx, "mox.example") })
+	tneedErrorCode(t, "user:error", func() { api.DomainLocalparts(ctx, "mox.example") })
+	tneedErrorCode(t, "user:error", func() { api.DomainAliases(ctx, "mox.example") })
+	tneedErrorCode(t, "user:error", func() { api.DMARCSummaries(ctx, time.Now().Add(-time.Hour), time.Now(), "") })
+	tneedErrorCode(t, "user:error", func() { api.Account(ctx, "mjl") })
+	tneedErrorCode(t, "user:error", func() { api.SetPassword(ctx, "mjl", "test12345") })
+	tneedErrorCode(t, "user:error", func() { api.AccountTOTPReset(ctx, "mjl") })
+	// Account moxadmin only has addresses in other.example, but administers mox.example.
+	tneedErrorCode(t, "user:error", func() { api.AccountTOTPReset(ctx, "moxadmin") })
+	tneedErrorCode(t, "user:error", func() { api.SetPassword(ctx, "moxadmin", "test12345") })
+	tneedErrorCode(t, "user:error", func() { api.AccountRemove(ctx, "moxadmin") })
+	tneedErrorCode(t, "user:error", func() { api.AccountRemove(ctx, "mjl") })
+	tneedErrorCode(t, "user:error", func() { api.AccountAdd(ctx, "new", "new@mox.example") })
+	tneedErrorCode(t, "user:error", func() { api.AddressAdd(ctx, "new@mox.example", "other") })
+	tneedErrorCode(t, "user:error", func() { api.AddressAdd(ctx, "new@other.example", "mjl") })
+	tneedErrorCode(t, "user:error", func() { api.AddressRemove(ctx, "mjl@mox.example") })
+	tneedErrorCode(t, "user:error", func() { api.AliasAdd(ctx, "team", "mox.example", config.Alias{Addresses: []string{"mjl@mox.example"}}) })
+
+	// Local members of aliases must be in administered domains, external members are allowed.
+	tneedErrorCode(t, "user:error", func() {
+		api.AliasAdd(ctx, "team", "other.example", config.Alias{Addresses: []string{"other@other.example", "mjl@mox.example"}})
+	})
+	api.AliasAdd(ctx, "team", "other.example", config.Alias{Addresses: []string{"other@other.example", "remote@external.example"}})
+	tneedErrorCode(t, "user:error", func() {
+		api.AliasUpdate(ctx, "team", "other.example", config.Alias{Addresses: []string{"other@other.example", "mjl@mox.example"}})
+	})
+	api.AliasRemove(ctx, "team", "other.example")
+}
+
 func TestCheckDomain(t *testing.T) {
 	// NOTE: we aren't currently looking at the results, having the code paths executed is better than nothing.
 
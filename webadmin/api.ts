@@ -451,6 +451,12 @@ export interface Reverse {
 	Hostnames?: string[] | null
 }
 
+export interface Alias {
+	Addresses?: string[] | null
+	PostPublic: boolean
+	AllowMsgFrom?: string[] | null
+}
+
 // ClientConfigs holds the client configuration for IMAP/Submission for a
 // domain.
 export interface ClientConfigs {
@@ -776,7 +782,7 @@ export type Localpart = string
 // be an IPv4 address.
 export type IP = string
 
-export const structTypes: {[typename: string]: boolean} = {"AuthResults":true,"AutoconfCheckResult":true,"AutodiscoverCheckResult":true,"AutodiscoverSRV":true,"CheckResult":true,"ClientConfigs":true,"ClientConfigsEntry":true,"DANECheckResult":true,"DKIMAuthResult":true,"DKIMCheckResult":true,"DKIMRecord":true,"DMARCCheckResult":true,"DMARCRecord":true,"DMARCSummary":true,"DNSSECResult":true,"DateRange":true,"Directive":true,"Domain":true,"DomainFeedback":true,"Evaluation":true,"EvaluationStat":true,"Extension":true,"FailureDetails":true,"IPDomain":true,"IPRevCheckResult":true,"Identifiers":true,"MTASTSCheckResult":true,"MTASTSRecord":true,"MX":true,"MXCheckResult":true,"Modifier":true,"Msg":true,"Pair":true,"Policy":true,"PolicyEvaluated":true,"PolicyOverrideReason":true,"PolicyPublished":true,"PolicyRecord":true,"Record":true,"Report":true,"ReportMetadata":true,"ReportRecord":true,"Result":true,"ResultPolicy":true,"Reverse":true,"Row":true,"SMTPAuth":true,"SPFAuthResult":true,"SPFCheckResult":true,"SPFRecord":true,"SRV":true,"SRVConfCheckResult":true,"STSMX":true,"Summary":true,"SuppressAddress":true,"TLSCheckResult":true,"TLSRPTCheckResult":true,"TLSRPTDateRange":true,"TLSRPTRecord":true,"TLSRPTSummary":true,"TLSRPTSuppressAddress":true,"TLSReportRecord":true,"TLSResult":true,"Transport":true,"TransportSMTP":true,"TransportSocks":true,"URI":true,"WebForward":true,"WebHandler":true,"WebRedirect":true,"WebStatic":true,"WebserverConfig":true}
+export const structTypes: {[typename: string]: boolean} = {"Alias":true,"AuthResults":true,"AutoconfCheckResult":true,"AutodiscoverCheckResult":true,"AutodiscoverSRV":true,"CheckResult":true,"ClientConfigs":true,"ClientConfigsEntry":true,"DANECheckResult":true,"DKIMAuthResult":true,"DKIMCheckResult":true,"DKIMRecord":true,"DMARCCheckResult":true,"DMARCRecord":true,"DMARCSummary":true,"DNSSECResult":true,"DateRange":true,"Directive":true,"Domain":true,"DomainFeedback":true,"Evaluation":true,"EvaluationStat":true,"Extension":true,"FailureDetails":true,"IPDomain":true,"IPRevCheckResult":true,"Identifiers":true,"MTASTSCheckResult":true,"MTASTSRecord":true,"MX":true,"MXCheckResult":true,"Modifier":true,"Msg":true,"Pair":true,"Policy":true,"PolicyEvaluated":true,"PolicyOverrideReason":true,"PolicyPublished":true,"PolicyRecord":true,"Record":true,"Report":true,"ReportMetadata":true,"ReportRecord":true,"Result":true,"ResultPolicy":true,"Reverse":true,"Row":true,"SMTPAuth":true,"SPFAuthResult":true,"SPFCheckResult":true,"SPFRecord":true,"SRV":true,"SRVConfCheckResult":true,"STSMX":true,"Summary":true,"SuppressAddress":true,"TLSCheckResult":true,"TLSRPTCheckResult":true,"TLSRPTDateRange":true,"TLSRPTRecord":true,"TLSRPTSummary":true,"TLSRPTSuppressAddress":true,"TLSReportRecord":true,"TLSResult":true,"Transport":true,"TransportSMTP":true,"TransportSocks":true,"URI":true,"WebForward":true,"WebHandler":true,"WebRedirect":true,"WebStatic":true,"WebserverConfig":true}
 export const stringsTypes: {[typename: string]: boolean} = {"Align":true,"Alignment":true,"CSRFToken":true,"DKIMResult":true,"DMARCPolicy":true,"DMARCResult":true,"Disposition":true,"IP":true,"Localpart":true,"Mode":true,"PolicyOverride":true,"PolicyType":true,"RUA":true,"ResultType":true,"SPFDomainScope":true,"SPFResult":true}
 export const intsTypes: {[typename: string]: boolean} = {}
 export const types: TypenameMap = {
@@ -834,6 +840,7 @@ export const types: TypenameMap = {
 	"SPFAuthResult": {"Name":"SPFAuthResult","Docs":"","Fields":[{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Scope","Docs":"","Typewords":["SPFDomainScope"]},{"Name":"Result","Docs":"","Typewords":["SPFResult"]}]},
 	"DMARCSummary": {"Name":"DMARCSummary","Docs":"","Fields":[{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Total","Docs":"","Typewords":["int32"]},{"Name":"DispositionNone","Docs":"","Typewords":["int32"]},{"Name":"DispositionQuarantine","Docs":"","Typewords":["int32"]},{"Name":"DispositionReject","Docs":"","Typewords":["int32"]},{"Name":"DKIMFail","Docs":"","Typewords":["int32"]},{"Name":"SPFFail","Docs":"","Typewords":["int32"]},{"Name":"PolicyOverrides","Docs":"","Typewords":["{}","int32"]}]},
 	"Reverse": {"Name":"Reverse","Docs":"","Fields":[{"Name":"Hostnames","Docs":"","Typewords":["[]","string"]}]},
+	"Alias": {"Name":"Alias","Docs":"","Fields":[{"Name":"Addresses","Docs":"","Typewords":["[]","string"]},{"Name":"PostPublic","Docs":"","Typewords":["bool"]},{"Name":"AllowMsgFrom","Docs":"","Typewords":["[]","string"]}]},
 	"ClientConfigs": {"Name":"ClientConfigs","Docs":"","Fields":[{"Name":"Entries","Docs":"","Typewords":["[]","ClientConfigsEntry"]}]},
 	"ClientConfigsEntry": {"Name":"ClientConfigsEntry","Docs":"","Fields":[{"Name":"Protocol","Docs":"","Typewords":["string"]},{"Name":"Host","Docs":"","Typewords":["Domain"]},{"Name":"Port","Docs":"","Typewords":["int32"]},{"Name":"Listener","Docs":"","Typewords":["string"]},{"Name":"Note","Docs":"","Typewords":["string"]}]},
 	"Msg": {"Name":"Msg","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Queued","Docs":"","Typewords":["timestamp"]},{"Name":"SenderAccount","Docs":"","Typewords":["string"]},{"Name":"SenderLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"SenderDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"RecipientLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"RecipientDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"RecipientDomainStr","Docs":"","Typewords":["string"]},{"Name":"Attempts","Docs":"","Typewords":["int32"]},{"Name":"MaxAttempts","Docs":"","Typewords":["int32"]},{"Name":"DialedIPs","Docs":"","Typewords":["{}","[]","IP"]},{"Name":"NextAttempt","Docs":"","Typewords":["timestamp"]},{"Name":"LastAttempt","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"LastError","Docs":"","Typewords":["string"]},{"Name":"Has8bit","Docs":"","Typewords":["bool"]},{"Name":"SMTPUTF8","Docs":"","Typewords":["bool"]},{"Name":"IsDMARCReport","Docs":"","Typewords":["bool"]},{"Name":"IsTLSReport","Docs":"","Typewords":["bool"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"MsgPrefix","Docs":"","Typewords":["nullable","string"]},{"Name":"DSNUTF8","Docs":"","Typewords":["nullable","string"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"RequireTLS","Docs":"","Typewords":["nullable","bool"]}]},
@@ -925,6 +932,7 @@ export const parser = {
 	SPFAuthResult: (v: any) => parse("SPFAuthResult", v) as SPFAuthResult,
 	DMARCSummary: (v: any) => parse("DMARCSummary", v) as DMARCSummary,
 	Reverse: (v: any) => parse("Reverse", v) as Reverse,
+	Alias: (v: any) => parse("Alias", v) as Alias,
 	ClientConfigs: (v: any) => parse("ClientConfigs", v) as ClientConfigs,
 	ClientConfigsEntry: (v: any) => parse("ClientConfigsEntry", v) as ClientConfigsEntry,
 	Msg: (v: any) => parse("Msg", v) as Msg,
@@ -1002,12 +1010,18 @@ export class Client {
 	}
 
 	// Login returns a session token for the credentials, or fails with error code
-	// "user:badLogin". Call LoginPrep to get a loginToken.
-	async Login(loginToken: string, password: string): Promise<CSRFToken> {
+	// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+	// authentication is enabled, totpCode must be a code from the authenticator app
+	// or a recovery code, the call fails with error code "user:totpRequired" if it is
+	// empty.
+	// 
+	// The administrator logs in with an empty username and the admin password. Domain
+	// administrators log in with the email address and password of their account.
+	async Login(loginToken: string, username: string, password: string, totpCode: string): Promise<CSRFToken> {
 		const fn: string = "Login"
-		const paramTypes: string[][] = [["string"],["string"]]
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"]]
 		const returnTypes: string[][] = [["CSRFToken"]]
-		const params: any[] = [loginToken, password]
+		const params: any[] = [loginToken, username, password, totpCode]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
 	}
 
@@ -1020,6 +1034,60 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// Identity returns the email address a domain administrator logged in with, or an
+	// empty string for the administrator.
+	async Identity(): Promise<string> {
+		const fn: string = "Identity"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// TOTPGet returns whether two-factor authentication is enabled for the admin,
+	// and the number of unused recovery codes.
+	async TOTPGet(): Promise<[boolean, number]> {
+		const fn: string = "TOTPGet"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["bool"],["int32"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [boolean, number]
+	}
+
+	// TOTPEnrollStart starts enrolment in two-factor authentication for the admin
+	// with a new secret. The secret is returned as key for manual entry, as otpauth
+	// URI, and as QR code (PNG data URL) of the URI, for adding to an authenticator
+	// app. Two-factor authentication is enabled by TOTPEnrollFinish.
+	async TOTPEnrollStart(): Promise<[string, string, string]> {
+		const fn: string = "TOTPEnrollStart"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"],["string"],["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [string, string, string]
+	}
+
+	// TOTPEnrollFinish enables two-factor authentication for the admin after
+	// verifying a code from the authenticator app for the secret from
+	// TOTPEnrollStart. Recovery codes are returned, each can be used once instead of a
+	// code. Other admin sessions are logged out.
+	async TOTPEnrollFinish(code: string): Promise<string[] | null> {
+		const fn: string = "TOTPEnrollFinish"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["[]","string"]]
+		const params: any[] = [code]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string[] | null
+	}
+
+	// TOTPDisable disables two-factor authentication for the admin, or aborts an
+	// enrolment.
+	async TOTPDisable(): Promise<void> {
+		const fn: string = "TOTPDisable"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = []
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// CheckDomain checks the configuration for the domain, such as MX, SMTP STARTTLS,
 	// SPF, DKIM, DMARC, TLSRPT, MTASTS, autoconfig, autodiscover.
 	async CheckDomain(domainName: string): Promise<CheckResult> {
@@ -1030,7 +1098,8 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CheckResult
 	}
 
-	// Domains returns all configured domain names, in UTF-8 for IDNA domains.
+	// Domains returns all configured domain names, in UTF-8 for IDNA domains. For
+	// domain administrators, only the domains they administer are returned.
 	async Domains(): Promise<Domain[] | null> {
 		const fn: string = "Domains"
 		const paramTypes: string[][] = []
@@ -1066,7 +1135,8 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as { [key: string]: string }
 	}
 
-	// Accounts returns the names of all configured accounts.
+	// Accounts returns the names of all configured accounts. For domain
+	// administrators, only the accounts they can manage are returned.
 	async Accounts(): Promise<string[] | null> {
 		const fn: string = "Accounts"
 		const paramTypes: string[][] = []
@@ -1253,6 +1323,64 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// DomainAdmins returns the accounts that are domain administrators for the domain.
+	async DomainAdmins(domain: string): Promise<string[] | null> {
+		const fn: string = "DomainAdmins"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["[]","string"]]
+		const params: any[] = [domain]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string[] | null
+	}
+
+	// DomainAdminsSave sets the accounts that are domain administrators for the
+	// domain. Domain administrators log in to the admin web interface with the email
+	// address and password of their account, and can only manage accounts, addresses
+	// and aliases of the domains they administer.
+	async DomainAdminsSave(domain: string, accounts: string[] | null): Promise<void> {
+		const fn: string = "DomainAdminsSave"
+		const paramTypes: string[][] = [["string"],["[]","string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [domain, accounts]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// DomainAliases returns the aliases configured in domain, keyed by localpart.
+	async DomainAliases(domain: string): Promise<{ [key: string]: Alias }> {
+		const fn: string = "DomainAliases"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["{}","Alias"]]
+		const params: any[] = [domain]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as { [key: string]: Alias }
+	}
+
+	// AliasAdd adds a new alias to a domain. Addresses of members can be local
+	// account addresses or external addresses.
+	async AliasAdd(aliaslp: string, domainName: string, alias: Alias): Promise<void> {
+		const fn: string = "AliasAdd"
+		const paramTypes: string[][] = [["string"],["string"],["Alias"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [aliaslp, domainName, alias]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// AliasUpdate replaces the members and posting settings of an existing alias.
+	async AliasUpdate(aliaslp: string, domainName: string, alias: Alias): Promise<void> {
+		const fn: string = "AliasUpdate"
+		const paramTypes: string[][] = [["string"],["string"],["Alias"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [aliaslp, domainName, alias]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// AliasRemove removes an alias.
+	async AliasRemove(aliaslp: string, domainName: string): Promise<void> {
+		const fn: string = "AliasRemove"
+		const paramTypes: string[][] = [["string"],["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [aliaslp, domainName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// SetPassword saves a new password for an account, invalidating the previous password.
 	// Sessions are not interrupted, and will keep working. New login attempts must use the new password.
 	// Password must be at least 8 characters.
@@ -1264,6 +1392,16 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// AccountTOTPReset disables two-factor authentication for an account, e.g. after
+	// the user lost both the authenticator app and the recovery codes.
+	async AccountTOTPReset(accountName: string): Promise<void> {
+		const fn: string = "AccountTOTPReset"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [accountName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// SetAccountLimits set new limits on outgoing messages for an account.
 	async SetAccountLimits(accountName: string, maxOutgoingMessagesPerDay: number, maxFirstTimeRecipientsPerDay: number, maxMsgSize: number): Promise<void> {
 		const fn: string = "SetAccountLimits"
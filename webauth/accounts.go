@@ -15,7 +15,7 @@ var Accounts SessionAuth = accountSessionAuth{}
 type accountSessionAuth struct{}
 
 func (accountSessionAuth) login(ctx context.Context, log mlog.Log, username, password string) (bool, string, error) {
-	acc, err := store.OpenEmailAuth(log, username, password)
+	acc, _, err := store.OpenEmailAuth(log, username, password, store.AuthScopeWeb)
 	if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
 		return false, "", nil
 	} else if err != nil {
@@ -28,6 +28,18 @@ func (accountSessionAuth) login(ctx context.Context, log mlog.Log, username, pas
 	return true, acc.Name, nil
 }
 
+func (accountSessionAuth) totp(ctx context.Context, log mlog.Log, accountName, code string) (bool, bool, error) {
+	acc, err := store.OpenAccount(log, accountName)
+	if err != nil {
+		return false, false, err
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	return acc.TOTPVerify(ctx, log, code)
+}
+
 func (accountSessionAuth) add(ctx context.Context, log mlog.Log, accountName string, loginAddress string) (sessionToken store.SessionToken, csrfToken store.CSRFToken, rerr error) {
 	return store.SessionAdd(ctx, log, accountName, loginAddress)
 }
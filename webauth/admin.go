@@ -4,13 +4,17 @@ import (
 	"context"
 	cryptorand "crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/exp/slog"
 
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
@@ -19,7 +23,13 @@ import (
 
 // Admin is for admin logins, with authentication by password, and sessions only
 // stored in memory only, with lifetime 12 hour after last use, with a maximum of
-// 10 active sessions.
+// 10 active sessions per administrator.
+//
+// The administrator logs in with an empty username and the admin password. Domain
+// administrators log in with an email address and password of their account,
+// which must be configured as administrator for at least one domain. Sessions of
+// domain administrators have the account name, and are no longer valid when the
+// account is not an administrator for any domain anymore.
 var Admin SessionAuth = &adminSessionAuth{
 	sessions: map[store.SessionToken]adminSession{},
 }
@@ -31,6 +41,8 @@ type adminSession struct {
 	sessionToken store.SessionToken
 	csrfToken    store.CSRFToken
 	expires      time.Time
+	accountName  string // Empty for the administrator, set for domain administrators.
+	loginAddress string // Only for domain administrators.
 }
 
 type adminSessionAuth struct {
@@ -39,6 +51,19 @@ type adminSessionAuth struct {
 }
 
 func (a *adminSessionAuth) login(ctx context.Context, log mlog.Log, username, password string) (bool, string, error) {
+	if username != "" {
+		// Domain administrator.
+		valid, accountName, err := accountSessionAuth{}.login(ctx, log, username, password)
+		if err != nil || !valid {
+			return false, "", err
+		}
+		if len(mox.Conf.AdminDomains(accountName)) == 0 {
+			log.Info("login for admin interface by account that is not a domain administrator", slog.String("account", accountName))
+			return false, "", nil
+		}
+		return true, accountName, nil
+	}
+
 	a.Lock()
 	defer a.Unlock()
 
@@ -55,6 +80,25 @@ func (a *adminSessionAuth) login(ctx context.Context, log mlog.Log, username, pa
 	return true, "", nil
 }
 
+func (a *adminSessionAuth) totp(ctx context.Context, log mlog.Log, accountName, code string) (bool, bool, error) {
+	if accountName != "" {
+		// Domain administrators use two-factor authentication of their account.
+		return accountSessionAuth{}.totp(ctx, log, accountName, code)
+	}
+
+	adminTOTPLock.Lock()
+	defer adminTOTPLock.Unlock()
+
+	t, err := adminTOTPRead()
+	if err != nil || !t.Enabled {
+		return false, false, err
+	}
+	if !t.Verify(code, time.Now()) {
+		return true, false, nil
+	}
+	return true, true, adminTOTPWrite(t)
+}
+
 func (a *adminSessionAuth) add(ctx context.Context, log mlog.Log, accountName string, loginAddress string) (sessionToken store.SessionToken, csrfToken store.CSRFToken, rerr error) {
 	a.Lock()
 	defer a.Unlock()
@@ -66,15 +110,21 @@ func (a *adminSessionAuth) add(ctx context.Context, log mlog.Log, accountName st
 		}
 	}
 
-	// Ensure we have at most 10 sessions.
-	if len(a.sessions) > 10 {
-		var oldest *store.SessionToken
-		for _, s := range a.sessions {
-			if oldest == nil || s.expires.Before(a.sessions[*oldest].expires) {
-				oldest = &s.sessionToken
-			}
+	// Ensure we have at most 10 sessions per administrator, so domain administrators
+	// cannot push out sessions of others.
+	var oldest adminSession
+	var n int
+	for _, s := range a.sessions {
+		if s.accountName != accountName {
+			continue
+		}
+		n++
+		if n == 1 || s.expires.Before(oldest.expires) {
+			oldest = s
 		}
-		delete(a.sessions, *oldest)
+	}
+	if n >= 10 {
+		delete(a.sessions, oldest.sessionToken)
 	}
 
 	// Generate new tokens.
@@ -89,7 +139,10 @@ func (a *adminSessionAuth) add(ctx context.Context, log mlog.Log, accountName st
 	csrfToken = store.CSRFToken(base64.RawURLEncoding.EncodeToString(csrfData[:]))
 
 	// Register session.
-	a.sessions[sessionToken] = adminSession{sessionToken, csrfToken, time.Now().Add(adminSessionLifetime)}
+	if accountName == "" {
+		loginAddress = ""
+	}
+	a.sessions[sessionToken] = adminSession{sessionToken, csrfToken, time.Now().Add(adminSessionLifetime), accountName, loginAddress}
 	return sessionToken, csrfToken, nil
 }
 
@@ -98,25 +151,158 @@ func (a *adminSessionAuth) use(ctx context.Context, log mlog.Log, accountName st
 	defer a.Unlock()
 
 	s, ok := a.sessions[sessionToken]
-	if !ok {
+	if !ok || s.accountName != accountName {
 		return "", fmt.Errorf("unknown session")
 	} else if time.Until(s.expires) < 0 {
 		return "", fmt.Errorf("session expired")
 	} else if csrfToken != "" && csrfToken != s.csrfToken {
 		return "", fmt.Errorf("mismatch between csrf and session tokens")
+	} else if accountName != "" && len(mox.Conf.AdminDomains(accountName)) == 0 {
+		delete(a.sessions, sessionToken)
+		return "", fmt.Errorf("account is no longer a domain administrator")
 	}
 	s.expires = time.Now().Add(adminSessionLifetime)
 	a.sessions[sessionToken] = s
-	return "", nil
+	return s.loginAddress, nil
 }
 
 func (a *adminSessionAuth) remove(ctx context.Context, log mlog.Log, accountName string, sessionToken store.SessionToken) error {
 	a.Lock()
 	defer a.Unlock()
 
-	if _, ok := a.sessions[sessionToken]; !ok {
+	if s, ok := a.sessions[sessionToken]; !ok || s.accountName != accountName {
 		return fmt.Errorf("unknown session")
 	}
 	delete(a.sessions, sessionToken)
 	return nil
 }
+
+// Serializes access to the file with the two-factor authentication state of the
+// admin.
+var adminTOTPLock sync.Mutex
+
+// adminTOTPPath returns the path to the file with the two-factor authentication
+// state of the admin, next to the admin password file.
+func adminTOTPPath() (string, error) {
+	if mox.Conf.Static.AdminPasswordFile == "" {
+		return "", fmt.Errorf("no admin password file configured")
+	}
+	return mox.ConfigDirPath(mox.Conf.Static.AdminPasswordFile) + ".totp", nil
+}
+
+// must be called with adminTOTPLock held.
+func adminTOTPRead() (store.TOTP, error) {
+	var t store.TOTP
+	p, err := adminTOTPPath()
+	if err != nil {
+		return t, err
+	}
+	buf, err := os.ReadFile(p)
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	} else if err != nil {
+		return t, fmt.Errorf("reading two-factor authentication file: %v", err)
+	}
+	if err := json.Unmarshal(buf, &t); err != nil {
+		return t, fmt.Errorf("parsing two-factor authentication file: %v", err)
+	}
+	return t, nil
+}
+
+// must be called with adminTOTPLock held.
+func adminTOTPWrite(t store.TOTP) error {
+	p, err := adminTOTPPath()
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, buf, 0660); err != nil {
+		return fmt.Errorf("writing two-factor authentication file: %v", err)
+	}
+	return nil
+}
+
+// AdminTOTPGet returns the two-factor authentication state of the admin. The
+// state is stored in a file next to the admin password file, with ".totp"
+// appended to its name. Removing the file disables two-factor authentication for
+// the admin, e.g. when both the authenticator app and recovery codes are lost.
+func AdminTOTPGet() (store.TOTP, error) {
+	adminTOTPLock.Lock()
+	defer adminTOTPLock.Unlock()
+	return adminTOTPRead()
+}
+
+// AdminTOTPEnrollStart starts enrolment for two-factor authentication of the
+// admin, storing a new secret that is enabled with AdminTOTPEnrollFinish.
+func AdminTOTPEnrollStart(log mlog.Log) (store.TOTP, error) {
+	adminTOTPLock.Lock()
+	defer adminTOTPLock.Unlock()
+
+	if t, err := adminTOTPRead(); err != nil {
+		return store.TOTP{}, err
+	} else if t.Enabled {
+		return store.TOTP{}, fmt.Errorf("%w: already enabled", store.ErrTOTP)
+	}
+	t, err := store.NewTOTP()
+	if err != nil {
+		return store.TOTP{}, err
+	}
+	if err := adminTOTPWrite(t); err != nil {
+		return store.TOTP{}, err
+	}
+	log.Info("two-factor authentication enrolment started for admin")
+	return t, nil
+}
+
+// AdminTOTPEnrollFinish verifies a code for the secret from AdminTOTPEnrollStart
+// and enables two-factor authentication for the admin, returning new recovery
+// codes. Admin sessions other than sessionToken, created without second factor,
+// are removed. Sessions of domain administrators are kept.
+func AdminTOTPEnrollFinish(log mlog.Log, code string, sessionToken store.SessionToken) ([]string, error) {
+	adminTOTPLock.Lock()
+	defer adminTOTPLock.Unlock()
+
+	t, err := adminTOTPRead()
+	if err != nil {
+		return nil, err
+	}
+	codes, err := t.Enable(code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := adminTOTPWrite(t); err != nil {
+		return nil, err
+	}
+
+	a := Admin.(*adminSessionAuth)
+	a.Lock()
+	for st, s := range a.sessions {
+		if st != sessionToken && s.accountName == "" {
+			delete(a.sessions, st)
+		}
+	}
+	a.Unlock()
+
+	log.Info("two-factor authentication enabled for admin")
+	return codes, nil
+}
+
+// AdminTOTPDisable disables two-factor authentication for the admin, or aborts an
+// enrolment.
+func AdminTOTPDisable(log mlog.Log) error {
+	adminTOTPLock.Lock()
+	defer adminTOTPLock.Unlock()
+
+	p, err := adminTOTPPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing two-factor authentication file: %v", err)
+	}
+	log.Info("two-factor authentication disabled for admin")
+	return nil
+}
@@ -18,6 +18,13 @@ along with login credentials. If the loginToken is missing, the login attempt
 fails before checking any credentials. This should prevent third party websites
 from tricking a browser into logging in.
 
+If two-factor authentication is enabled for an account or the admin, Login also
+requires a time-based one-time password (RFC 6238) from an authenticator app, or
+one of the recovery codes generated during enrolment. A Login call with valid
+credentials but without code fails with error code "user:totpRequired", after
+which the frontend asks for the code and calls LoginPrep and Login again. Code
+attempts are rate limited like password attempts.
+
 Sessions are stored server-side, and their lifetime automatically extended each
 time they are used. This makes it easy to invalidate existing sessions after a
 password change, and keeps the frontend free from handling long-term vs
@@ -25,7 +32,10 @@ short-term sessions.
 
 Sessions for the admin interface have a lifetime of 12 hours after last use,
 are only stored in memory (don't survive a server restart), and only 10
-sessions can exist at a time (the oldest session is dropped).
+sessions can exist at a time per administrator (the oldest session is dropped).
+Besides the administrator, who logs in with the admin password, domain
+administrators can log in to the admin interface with the credentials of their
+account.
 
 Sessions for the account and mail interfaces have a lifetime of 24 hours after
 last use, are kept in memory and stored in the database (do survive a server
@@ -36,6 +46,7 @@ package webauth
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -44,6 +55,7 @@ import (
 	"time"
 
 	"golang.org/x/exp/slog"
+	"rsc.io/qr"
 
 	"github.com/mjl-/sherpa"
 
@@ -61,6 +73,11 @@ var BadAuthDelay = time.Second
 type SessionAuth interface {
 	login(ctx context.Context, log mlog.Log, username, password string) (valid bool, accountName string, rerr error)
 
+	// Check a code for two-factor authentication after a valid login for accountName.
+	// Enabled must be false if two-factor authentication is not enabled. The code can
+	// be from an authenticator app, or a recovery code.
+	totp(ctx context.Context, log mlog.Log, accountName, code string) (enabled, valid bool, rerr error)
+
 	// Add a new session for account and login address.
 	add(ctx context.Context, log mlog.Log, accountName string, loginAddress string) (sessionToken store.SessionToken, csrfToken store.CSRFToken, rerr error)
 
@@ -143,11 +160,12 @@ func Check(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind stri
 
 	authResult := "badcreds"
 	defer func() {
-		metrics.AuthenticationInc(kind, "websession", authResult)
+		metrics.AuthenticationInc(kind, "websession", "session", authResult)
 	}()
 
 	// Cookie values are of the form: token SP accountname.
-	// For admin sessions, the accountname is empty (there is no login address either).
+	// For admin sessions, the accountname is empty (there is no login address either),
+	// except for domain administrators.
 	t := strings.SplitN(cookie.Value, " ", 2)
 	if len(t) != 2 {
 		time.Sleep(BadAuthDelay)
@@ -213,12 +231,15 @@ func LoginPrep(ctx context.Context, log mlog.Log, kind, cookiePath string, isFor
 }
 
 // Login handles a login attempt, checking against the rate limiter, verifying the
-// credentials through sessionAuth, and setting a session token cookie on the HTTP
-// response and returning the associated CSRF token.
+// credentials and the code for two-factor authentication (if enabled) through
+// sessionAuth, and setting a session token cookie on the HTTP response and
+// returning the associated CSRF token.
 //
 // In case of a user error, a *sherpa.Error is returned that sherpa handlers can
-// pass to panic. For bad credentials, the error code is "user:loginFailed".
-func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, loginToken, username, password string) (store.CSRFToken, error) {
+// pass to panic. For bad credentials, the error code is "user:loginFailed". If
+// two-factor authentication is enabled and totpCode is empty, the error code is
+// "user:totpRequired".
+func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, loginToken, username, password, totpCode string) (store.CSRFToken, error) {
 	loginCookie, _ := r.Cookie(kind + "login")
 	if loginCookie == nil || loginCookie.Value != loginToken {
 		return "", &sherpa.Error{Code: "user:error", Message: "missing login token"}
@@ -236,8 +257,9 @@ func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, coo
 
 	valid, accountName, err := sessionAuth.login(ctx, log, username, password)
 	var authResult string
+	authCredential := "password"
 	defer func() {
-		metrics.AuthenticationInc(kind, "weblogin", authResult)
+		metrics.AuthenticationInc(kind, "weblogin", authCredential, authResult)
 	}()
 	if err != nil {
 		authResult = "error"
@@ -247,6 +269,23 @@ func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, coo
 		authResult = "badcreds"
 		return "", &sherpa.Error{Code: "user:loginFailed", Message: "invalid credentials"}
 	}
+
+	// The limiter is only reset after a successful second factor, so attempts at
+	// guessing codes are rate limited like passwords.
+	if enabled, ok, err := sessionAuth.totp(ctx, log, accountName, totpCode); err != nil {
+		authResult = "error"
+		return "", fmt.Errorf("evaluating two-factor authentication code: %v", err)
+	} else if enabled && totpCode == "" {
+		authResult = "totprequired"
+		return "", &sherpa.Error{Code: "user:totpRequired", Message: "two-factor authentication code required"}
+	} else if enabled && !ok {
+		time.Sleep(BadAuthDelay)
+		authCredential = "totp"
+		authResult = "badcreds"
+		return "", &sherpa.Error{Code: "user:loginFailed", Message: "invalid two-factor authentication code"}
+	} else if enabled {
+		authCredential = "totp"
+	}
 	authResult = "ok"
 	mox.LimiterFailedAuth.Reset(ip, start)
 
@@ -281,6 +320,16 @@ func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, coo
 	return csrfToken, nil
 }
 
+// TOTPQRCode returns a QR code for an otpauth URI for enrolment in two-factor
+// authentication, as PNG in a data URL, for scanning with an authenticator app.
+func TOTPQRCode(uri string) (string, error) {
+	code, err := qr.Encode(uri, qr.M)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()), nil
+}
+
 // Logout removes the session token through sessionAuth, and clears the session
 // cookie through the HTTP response.
 func Logout(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, accountName string, sessionToken store.SessionToken) error {
@@ -14,7 +14,6 @@ import (
 	"net/http"
 	"net/mail"
 	"net/textproto"
-	"os"
 	"runtime/debug"
 	"sort"
 	"strings"
@@ -103,12 +102,15 @@ func (w Webmail) LoginPrep(ctx context.Context) string {
 }
 
 // Login returns a session token for the credentials, or fails with error code
-// "user:badLogin". Call LoginPrep to get a loginToken.
-func (w Webmail) Login(ctx context.Context, loginToken, username, password string) store.CSRFToken {
+// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+// authentication is enabled, totpCode must be a code from the authenticator app
+// or a recovery code, the call fails with error code "user:totpRequired" if it is
+// empty.
+func (w Webmail) Login(ctx context.Context, loginToken, username, password, totpCode string) store.CSRFToken {
 	log := pkglog.WithContext(ctx)
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 
-	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password)
+	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password, totpCode)
 	if _, ok := err.(*sherpa.Error); ok {
 		panic(err)
 	}
@@ -580,7 +582,7 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 							ap = ap.Parts[xp]
 						}
 
-						filename := tryDecodeParam(log, ap.ContentTypeParams["name"])
+						filename := store.TryDecodeParam(log, ap.ContentTypeParams["name"])
 						if filename == "" {
 							filename = "unnamed.bin"
 						}
@@ -645,6 +647,16 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 
 	var modseq store.ModSeq // Only set if needed.
 
+	// Parse the message for the Sent mailbox and extract text from its attachments
+	// before taking the lock.
+	sentm := store.Message{
+		Flags:     store.Flags{Notjunk: true, Seen: true},
+		Size:      int64(len(msgPrefix)) + xc.Size,
+		MsgPrefix: []byte(msgPrefix),
+	}
+	err = acc.PrepareAttachmentText(log, &sentm, dataFile)
+	xcheckf(ctx, err, "preparing message for sent mailbox")
+
 	// Append message to Sent mailbox and mark original messages as answered/forwarded.
 	acc.WithRLock(func() {
 		var changes []store.Change
@@ -695,21 +707,21 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 				xcheckf(ctx, err, "next modseq")
 			}
 
-			sentm := store.Message{
-				CreateSeq:     modseq,
-				ModSeq:        modseq,
-				MailboxID:     sentmb.ID,
-				MailboxOrigID: sentmb.ID,
-				Flags:         store.Flags{Notjunk: true, Seen: true},
-				Size:          int64(len(msgPrefix)) + xc.Size,
-				MsgPrefix:     []byte(msgPrefix),
-			}
+			sentm.CreateSeq = modseq
+			sentm.ModSeq = modseq
+			sentm.MailboxID = sentmb.ID
+			sentm.MailboxOrigID = sentmb.ID
 
 			if ok, maxSize, err := acc.CanAddMessageSize(tx, sentm.Size); err != nil {
 				xcheckf(ctx, err, "checking quota")
 			} else if !ok {
 				xcheckuserf(ctx, fmt.Errorf("account over maximum total message size %d", maxSize), "checking quota")
 			}
+			if ok, maxCount, err := acc.CanAddMessages(tx, 1); err != nil {
+				xcheckf(ctx, err, "checking quota")
+			} else if !ok {
+				xcheckuserf(ctx, fmt.Errorf("account over maximum number of messages %d", maxCount), "checking quota")
+			}
 
 			// Update mailbox before delivery, which changes uidnext.
 			sentmb.Add(sentm.MailboxCounts())
@@ -802,6 +814,8 @@ func (Webmail) MessageMove(ctx context.Context, messageIDs []int64, mailboxID in
 				}
 				conf, _ := acc.Conf()
 				m.MailboxID = mbDst.ID
+				now := time.Now()
+				m.SaveDate = &now
 				if m.IsReject && m.MailboxDestinedID != 0 {
 					// Incorrectly delivered to Rejects mailbox. Adjust MailboxOrigID so this message
 					// is used for reputation calculation during future deliveries.
@@ -877,86 +891,87 @@ func (Webmail) MessageDelete(ctx context.Context, messageIDs []int64) {
 		return
 	}
 
+	var remove []store.Message
+
 	acc.WithWLock(func() {
-		removeChanges := map[int64]store.ChangeRemoveUIDs{}
-		changes := make([]store.Change, 0, len(messageIDs)+1) // n remove, 1 mailbox counts
+		var changes, removeChanges []store.Change // Mailbox counts first.
 
 		xdbwrite(ctx, acc, func(tx *bstore.Tx) {
-			var modseq store.ModSeq
-			var mb store.Mailbox
-			remove := make([]store.Message, 0, len(messageIDs))
+			modseq, err := acc.NextModSeq(tx)
+			xcheckf(ctx, err, "assigning next modseq")
 
-			var totalSize int64
+			// Gather the messages per mailbox.
+			var mailboxIDs []int64
+			mailboxMsgs := map[int64][]store.Message{}
 			for _, mid := range messageIDs {
 				m := xmessageID(ctx, tx, mid)
-				totalSize += m.Size
-
-				if m.MailboxID != mb.ID {
-					if mb.ID != 0 {
-						err := tx.Update(&mb)
-						xcheckf(ctx, err, "updating mailbox counts")
-						changes = append(changes, mb.ChangeCounts())
-					}
-					mb = xmailboxID(ctx, tx, m.MailboxID)
+				if _, ok := mailboxMsgs[m.MailboxID]; !ok {
+					mailboxIDs = append(mailboxIDs, m.MailboxID)
 				}
+				mailboxMsgs[m.MailboxID] = append(mailboxMsgs[m.MailboxID], m)
+			}
 
-				qmr := bstore.QueryTx[store.Recipient](tx)
-				qmr.FilterEqual("MessageID", m.ID)
-				_, err = qmr.Delete()
-				xcheckf(ctx, err, "removing message recipients")
-
-				mb.Sub(m.MailboxCounts())
+			for _, mbID := range mailboxIDs {
+				mb := xmailboxID(ctx, tx, mbID)
+				l := mailboxMsgs[mbID]
+				err := acc.ExpungeMessages(ctx, log, tx, &mb, l, modseq)
+				xcheckf(ctx, err, "expunging messages")
+				remove = append(remove, l...)
 
-				if modseq == 0 {
-					modseq, err = acc.NextModSeq(tx)
-					xcheckf(ctx, err, "assigning next modseq")
+				uids := make([]store.UID, len(l))
+				for i, m := range l {
+					uids[i] = m.UID
 				}
-				m.Expunged = true
-				m.ModSeq = modseq
-				err = tx.Update(&m)
-				xcheckf(ctx, err, "marking message as expunged")
-
-				ch := removeChanges[m.MailboxID]
-				ch.UIDs = append(ch.UIDs, m.UID)
-				ch.MailboxID = m.MailboxID
-				ch.ModSeq = modseq
-				removeChanges[m.MailboxID] = ch
-				remove = append(remove, m)
-			}
-
-			if mb.ID != 0 {
-				err := tx.Update(&mb)
-				xcheckf(ctx, err, "updating count in mailbox")
+				sort.Slice(uids, func(i, j int) bool {
+					return uids[i] < uids[j]
+				})
 				changes = append(changes, mb.ChangeCounts())
+				removeChanges = append(removeChanges, store.ChangeRemoveUIDs{MailboxID: mb.ID, UIDs: uids, ModSeq: modseq})
 			}
-
-			err = acc.AddMessageSize(log, tx, -totalSize)
-			xcheckf(ctx, err, "updating disk usage")
-
-			// Mark removed messages as not needing training, then retrain them, so if they
-			// were trained, they get untrained.
-			for i := range remove {
-				remove[i].Junk = false
-				remove[i].Notjunk = false
-			}
-			err = acc.RetrainMessages(ctx, log, tx, remove, true)
-			xcheckf(ctx, err, "untraining deleted messages")
 		})
 
-		for _, ch := range removeChanges {
-			sort.Slice(ch.UIDs, func(i, j int) bool {
-				return ch.UIDs[i] < ch.UIDs[j]
-			})
-			changes = append(changes, ch)
-		}
-		store.BroadcastChanges(acc, changes)
+		store.BroadcastChanges(acc, append(changes, removeChanges...))
 	})
 
-	for _, mID := range messageIDs {
-		p := acc.MessagePath(mID)
-		err := os.Remove(p)
-		log.Check(err, "removing message file for expunge")
+	acc.ExpungedFilesRemove(log, remove)
+}
+
+// DeletedList returns the messages that were permanently deleted, e.g. by
+// emptying the Trash mailbox, but can still be recovered, most recently deleted
+// first. Messages are only kept if the account is configured with a
+// KeepDeletedPeriod.
+func (Webmail) DeletedList(ctx context.Context) []store.DeletedMessage {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.DeletedList(ctx)
+	xcheckf(ctx, err, "listing deleted messages")
+	return l
+}
+
+// DeletedRecover recovers deleted messages, adding them as new messages to the
+// mailbox they were deleted from, or Inbox if that mailbox no longer exists.
+func (Webmail) DeletedRecover(ctx context.Context, messageIDs []int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	_, err = acc.DeletedRecover(ctx, log, messageIDs)
+	if errors.Is(err, store.ErrUnknownDeletedMessage) || errors.Is(err, store.ErrOverQuota) {
+		xcheckuserf(ctx, err, "recovering deleted messages")
 	}
+	xcheckf(ctx, err, "recovering deleted messages")
 }
 
 // FlagsAdd adds flags, either system flags like \Seen or custom keywords. The
@@ -1163,7 +1178,7 @@ func (Webmail) MailboxDelete(ctx context.Context, mailboxID int64) {
 	}()
 
 	// Messages to remove after having broadcasted the removal of messages.
-	var removeMessageIDs []int64
+	var removeMessages []store.Message
 
 	acc.WithWLock(func() {
 		var changes []store.Change
@@ -1177,7 +1192,7 @@ func (Webmail) MailboxDelete(ctx context.Context, mailboxID int64) {
 
 			var hasChildren bool
 			var err error
-			changes, removeMessageIDs, hasChildren, err = acc.MailboxDelete(ctx, log, tx, mb)
+			changes, removeMessages, hasChildren, err = acc.MailboxDelete(ctx, log, tx, mb)
 			if hasChildren {
 				xcheckuserf(ctx, errors.New("mailbox has children"), "deleting mailbox")
 			}
@@ -1187,11 +1202,7 @@ func (Webmail) MailboxDelete(ctx context.Context, mailboxID int64) {
 		store.BroadcastChanges(acc, changes)
 	})
 
-	for _, mID := range removeMessageIDs {
-		p := acc.MessagePath(mID)
-		err := os.Remove(p)
-		log.Check(err, "removing message file for mailbox delete", slog.String("path", p))
-	}
+	acc.ExpungedFilesRemove(log, removeMessages)
 }
 
 // MailboxEmpty empties a mailbox, removing all messages from the mailbox, but not
@@ -1217,47 +1228,21 @@ func (Webmail) MailboxEmpty(ctx context.Context, mailboxID int64) {
 			modseq, err := acc.NextModSeq(tx)
 			xcheckf(ctx, err, "next modseq")
 
-			// Mark messages as expunged.
 			qm := bstore.QueryTx[store.Message](tx)
 			qm.FilterNonzero(store.Message{MailboxID: mb.ID})
 			qm.FilterEqual("Expunged", false)
 			qm.SortAsc("UID")
-			qm.Gather(&expunged)
-			_, err = qm.UpdateNonzero(store.Message{ModSeq: modseq, Expunged: true})
-			xcheckf(ctx, err, "deleting messages")
+			expunged, err = qm.List()
+			xcheckf(ctx, err, "listing messages")
 
-			// Remove Recipients.
-			anyIDs := make([]any, len(expunged))
-			for i, m := range expunged {
-				anyIDs[i] = m.ID
-			}
-			qmr := bstore.QueryTx[store.Recipient](tx)
-			qmr.FilterEqual("MessageID", anyIDs...)
-			_, err = qmr.Delete()
-			xcheckf(ctx, err, "removing message recipients")
+			err = acc.ExpungeMessages(ctx, log, tx, &mb, expunged, modseq)
+			xcheckf(ctx, err, "expunging messages")
 
-			// Adjust mailbox counts, gather UIDs for broadcasted change, prepare for untraining.
-			var totalSize int64
 			uids := make([]store.UID, len(expunged))
 			for i, m := range expunged {
-				m.Expunged = false // Gather returns updated values.
-				mb.Sub(m.MailboxCounts())
-				totalSize += m.Size
 				uids[i] = m.UID
-
-				expunged[i].Junk = false
-				expunged[i].Notjunk = false
 			}
 
-			err = tx.Update(&mb)
-			xcheckf(ctx, err, "updating mailbox for counts")
-
-			err = acc.AddMessageSize(log, tx, -totalSize)
-			xcheckf(ctx, err, "updating disk usage")
-
-			err = acc.RetrainMessages(ctx, log, tx, expunged, true)
-			xcheckf(ctx, err, "retraining expunged messages")
-
 			chremove := store.ChangeRemoveUIDs{MailboxID: mb.ID, UIDs: uids, ModSeq: modseq}
 			changes = []store.Change{chremove, mb.ChangeCounts()}
 		})
@@ -1265,11 +1250,7 @@ func (Webmail) MailboxEmpty(ctx context.Context, mailboxID int64) {
 		store.BroadcastChanges(acc, changes)
 	})
 
-	for _, m := range expunged {
-		p := acc.MessagePath(m.ID)
-		err := os.Remove(p)
-		log.Check(err, "removing message file after emptying mailbox", slog.String("path", p))
-	}
+	acc.ExpungedFilesRemove(log, expunged)
 }
 
 // MailboxRename renames a mailbox, possibly moving it to a new parent. The mailbox
@@ -1790,6 +1771,190 @@ func slicesAny[T any](l []T) []any {
 	return r
 }
 
+// SharedMailbox is a mailbox of another account that has been shared with the
+// account, with the rights to see and read messages.
+type SharedMailbox struct {
+	Account   string // Owner of the mailbox.
+	MailboxID int64
+	Name      string // Name in the account of the owner.
+	Total     int64  // Number of messages.
+	Unread    int64  // Number of messages without \Seen flag.
+}
+
+// SharedMailboxes returns the mailboxes of other accounts that have been shared
+// with the account, with rights to see and read the messages.
+func (Webmail) SharedMailboxes(ctx context.Context) (l []SharedMailbox) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	shared, err := store.SharedMailboxes(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "listing shared mailboxes")
+	for _, sm := range shared {
+		if sm.Rights.Has("lr") {
+			mb := sm.Mailbox
+			l = append(l, SharedMailbox{sm.Account, mb.ID, mb.Name, mb.Total + mb.Deleted, mb.Unread})
+		}
+	}
+	return l
+}
+
+// Maximum number of messages returned by a SharedMessages call.
+const sharedMessagesMax = 100
+
+// SharedMessages returns messages of a mailbox shared by another account, most
+// recently received first, starting at offset. At most 100 messages are returned.
+// Shared mailboxes are read-only in the webmail, messages are not marked as read.
+func (Webmail) SharedMessages(ctx context.Context, accountName string, mailboxID int64, offset int) (l []MessageItem) {
+	log := pkglog.WithContext(ctx)
+	xsharedMailbox(ctx, accountName, mailboxID, func(acc *store.Account, tx *bstore.Tx, mb store.Mailbox) {
+		state := msgState{acc: acc}
+		defer state.clear()
+
+		q := bstore.QueryTx[store.Message](tx)
+		q.FilterNonzero(store.Message{MailboxID: mb.ID})
+		q.FilterEqual("Expunged", false)
+		q.SortDesc("Received")
+		q.Limit(offset + sharedMessagesMax)
+		var i int
+		err := q.ForEach(func(m store.Message) error {
+			i++
+			if i <= offset {
+				return nil
+			}
+			mi, err := messageItem(log, m, &state)
+			if err != nil {
+				return fmt.Errorf("making message item for message %d: %v", m.ID, err)
+			}
+			l = append(l, mi)
+			return nil
+		})
+		xcheckf(ctx, err, "listing messages")
+	})
+	return l
+}
+
+// SharedParsedMessage is like ParsedMessage, but for a message in a mailbox
+// shared by another account.
+func (Webmail) SharedParsedMessage(ctx context.Context, accountName string, mailboxID, msgID int64) (pm ParsedMessage) {
+	log := pkglog.WithContext(ctx)
+	xsharedMailbox(ctx, accountName, mailboxID, func(acc *store.Account, tx *bstore.Tx, mb store.Mailbox) {
+		m := xmessageID(ctx, tx, msgID)
+		if m.MailboxID != mb.ID {
+			xcheckuserf(ctx, errors.New("message not in mailbox"), "getting message")
+		}
+
+		state := msgState{acc: acc}
+		defer state.clear()
+		var err error
+		pm, err = parsedMessage(log, m, &state, true, false)
+		xcheckf(ctx, err, "parsing message")
+	})
+	return
+}
+
+// xsharedMailbox opens the account of a mailbox shared with the account of the
+// request, and calls fn with a read transaction on that account. Mailboxes
+// without the rights to see and read messages are treated as non-existent.
+func xsharedMailbox(ctx context.Context, accountName string, mailboxID int64, fn func(acc *store.Account, tx *bstore.Tx, mb store.Mailbox)) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	errUnknown := errors.New("unknown shared mailbox")
+	if accountName == reqInfo.AccountName {
+		xcheckuserf(ctx, errUnknown, "looking up shared mailbox")
+	}
+
+	acc, err := store.OpenAccount(log, accountName)
+	if err == store.ErrAccountUnknown {
+		xcheckuserf(ctx, errUnknown, "looking up shared mailbox")
+	}
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	xdbread(ctx, acc, func(tx *bstore.Tx) {
+		rights, err := acc.MailboxRights(tx, mailboxID, reqInfo.AccountName)
+		xcheckf(ctx, err, "looking up rights for mailbox")
+		if !rights.Has("lr") {
+			xcheckuserf(ctx, errUnknown, "looking up shared mailbox")
+		}
+		mb := xmailboxID(ctx, tx, mailboxID)
+		fn(acc, tx, mb)
+	})
+}
+
+// SavedSearches returns the saved searches of the account, sorted by name. Saved
+// searches are also available as virtual mailboxes over IMAP.
+func (Webmail) SavedSearches(ctx context.Context) (l []store.SavedSearch) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	xdbread(ctx, acc, func(tx *bstore.Tx) {
+		l, err = bstore.QueryTx[store.SavedSearch](tx).SortAsc("Name").List()
+		xcheckf(ctx, err, "listing saved searches")
+	})
+	return l
+}
+
+// SavedSearchSave adds a new saved search if ss.ID is 0, or updates an existing
+// saved search. The stored saved search is returned.
+func (Webmail) SavedSearchSave(ctx context.Context, ss store.SavedSearch) store.SavedSearch {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	// Not stored, only used by the webmail during SSE connection setup.
+	ss.Filter.MailboxName = ""
+
+	acc.WithWLock(func() {
+		xdbwrite(ctx, acc, func(tx *bstore.Tx) {
+			if ss.Filter.MailboxID > 0 {
+				xmailboxID(ctx, tx, ss.Filter.MailboxID)
+			}
+			err := acc.SavedSearchSave(tx, &ss)
+			if errors.Is(err, bstore.ErrAbsent) || errors.Is(err, store.ErrSavedSearchInvalid) {
+				xcheckuserf(ctx, err, "saving saved search")
+			}
+			xcheckf(ctx, err, "saving saved search")
+		})
+	})
+	return ss
+}
+
+// SavedSearchRemove removes a saved search.
+func (Webmail) SavedSearchRemove(ctx context.Context, savedSearchID int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	acc.WithWLock(func() {
+		xdbwrite(ctx, acc, func(tx *bstore.Tx) {
+			err := tx.Delete(&store.SavedSearch{ID: savedSearchID})
+			if err == bstore.ErrAbsent {
+				xcheckuserf(ctx, errors.New("unknown saved search"), "removing saved search")
+			}
+			xcheckf(ctx, err, "removing saved search")
+		})
+	})
+}
+
 // SSETypes exists to ensure the generated API contains the types, for use in SSE events.
 func (Webmail) SSETypes() (start EventStart, viewErr EventViewErr, viewReset EventViewReset, viewMsgs EventViewMsgs, viewChanges EventViewChanges, msgAdd ChangeMsgAdd, msgRemove ChangeMsgRemove, msgFlags ChangeMsgFlags, msgThread ChangeMsgThread, mailboxRemove ChangeMailboxRemove, mailboxAdd ChangeMailboxAdd, mailboxRename ChangeMailboxRename, mailboxCounts ChangeMailboxCounts, mailboxSpecialUse ChangeMailboxSpecialUse, mailboxKeywords ChangeMailboxKeywords, flags store.Flags) {
 	return
@@ -159,6 +159,34 @@ export interface ForwardAttachments {
 	Paths?: (number[] | null)[] | null  // List of attachments, each path is a list of indices into the top-level message.Part.Parts.
 }
 
+// DeletedMessage is a message that was expunged, e.g. by emptying the Trash
+// mailbox, but whose message file is kept for the period configured in the
+// account's KeepDeletedPeriod, so it can be recovered.
+export interface DeletedMessage {
+	ID: number  // Same as Message.ID of the expunged message, its message file is kept.
+	Expunged: Date
+	MailboxID: number  // Mailbox the message was expunged from, and is recovered to. If the mailbox no longer exists, the message is recovered to Inbox.
+	MailboxName: string
+	Received: Date  // Fields from the expunged message, to recover it.
+	Size: number
+	MsgPrefix?: string | null
+	Seen: boolean
+	Answered: boolean
+	Flagged: boolean
+	Forwarded: boolean
+	Junk: boolean
+	Notjunk: boolean
+	Deleted: boolean
+	Draft: boolean
+	Phishing: boolean
+	MDNSent: boolean
+	Keywords?: string[] | null
+	FileCompressed: boolean
+	FileEncrypted: boolean
+	Subject: string  // From the parsed message, to show to users.
+	From: string
+}
+
 // Mailbox is collection of messages, e.g. Inbox or Sent.
 export interface Mailbox {
 	ID: number
@@ -189,53 +217,14 @@ export interface RecipientSecurity {
 	RequireTLS: SecurityResult  // Whether recipient domain is known to implement the REQUIRETLS SMTP extension. Will be "unknown" if no delivery to the domain has been attempted yet.
 }
 
-// EventStart is the first message sent on an SSE connection, giving the client
-// basic data to populate its UI. After this event, messages will follow quickly in
-// an EventViewMsgs event.
-export interface EventStart {
-	SSEID: number
-	LoginAddress: MessageAddress
-	Addresses?: MessageAddress[] | null
-	DomainAddressConfigs?: { [key: string]: DomainAddressConfig }  // ASCII domain to address config.
-	MailboxName: string
-	Mailboxes?: Mailbox[] | null
-	RejectsMailbox: string
-	Version: string
-}
-
-// DomainAddressConfig has the address (localpart) configuration for a domain, so
-// the webmail client can decide if an address matches the addresses of the
-// account.
-export interface DomainAddressConfig {
-	LocalpartCatchallSeparator: string  // Can be empty.
-	LocalpartCaseSensitive: boolean
-}
-
-// EventViewErr indicates an error during a query for messages. The request is
-// aborted, no more request-related messages will be sent until the next request.
-export interface EventViewErr {
-	ViewID: number
-	RequestID: number
-	Err: string  // To be displayed in client.
-}
-
-// EventViewReset indicates that a request for the next set of messages in a few
-// could not be fulfilled, e.g. because the anchor message does not exist anymore.
-// The client should clear its list of messages. This can happen before
-// EventViewMsgs events are sent.
-export interface EventViewReset {
-	ViewID: number
-	RequestID: number
-}
-
-// EventViewMsgs contains messages for a view, possibly a continuation of an
-// earlier list of messages.
-export interface EventViewMsgs {
-	ViewID: number
-	RequestID: number
-	MessageItems?: (MessageItem[] | null)[] | null  // If empty, this was the last message for the request. If non-empty, a list of thread messages. Each with the first message being the reason this thread is included and can be used as AnchorID in followup requests. If the threading mode is "off" in the query, there will always be only a single message. If a thread is sent, all messages in the thread are sent, including those that don't match the query (e.g. from another mailbox). Threads can be displayed based on the ThreadParentIDs field, with possibly slightly different display based on field ThreadMissingLink.
-	ParsedMessage?: ParsedMessage | null  // If set, will match the target page.DestMessageID from the request.
-	ViewEnd: boolean  // If set, there are no more messages in this view at this moment. Messages can be added, typically via Change messages, e.g. for new deliveries.
+// SharedMailbox is a mailbox of another account that has been shared with the
+// account, with the rights to see and read messages.
+export interface SharedMailbox {
+	Account: string  // Owner of the mailbox.
+	MailboxID: number
+	Name: string  // Name in the account of the owner.
+	Total: number  // Number of messages.
+	Unread: number  // Number of messages without \Seen flag.
 }
 
 // MessageItem is sent by queries, it has derived information analyzed from
@@ -270,6 +259,7 @@ export interface Message {
 	MailboxOrigID: number  // MailboxOrigID is the mailbox the message was originally delivered to. Typically Inbox or Rejects, but can also be a mailbox configured in a Ruleset, or Postmaster, TLS/DMARC reporting addresses. MailboxOrigID is not changed when the message is moved to another mailbox, e.g. Archive/Trash/Junk. Used for per-mailbox reputation.  MailboxDestinedID is normally 0, but when a message is delivered to the Rejects mailbox, it is set to the intended mailbox according to delivery rules, typically that of Inbox. When such a message is moved out of Rejects, the MailboxOrigID is corrected by setting it to MailboxDestinedID. This ensures the message is used for reputation calculation for future deliveries to that mailbox.  These are not bstore references to prevent having to update all messages in a mailbox when the original mailbox is removed. Use of these fields requires checking if the mailbox still exists.
 	MailboxDestinedID: number
 	Received: Date
+	SaveDate?: Date | null  // Time the message was added to its current mailbox, through delivery, IMAP APPEND, COPY or MOVE. Nil for messages saved before the save date was kept, in which case Received can be used instead. ../rfc/8514
 	RemoteIP: string  // Full IP address of remote SMTP server. Empty if not delivered over SMTP. The masked IPs are used to classify incoming messages. They are left empty for messages matching a ruleset for forwarded messages.
 	RemoteIPMasked1: string  // For IPv4 /32, for IPv6 /64, for reputation.
 	RemoteIPMasked2: string  // For IPv4 /26, for IPv6 /48.
@@ -319,6 +309,9 @@ export interface Message {
 	TrainedJunk?: boolean | null  // If nil, no training done yet. Otherwise, true is trained as junk, false trained as nonjunk.
 	MsgPrefix?: string | null  // Typically holds received headers and/or header separator.
 	ParsedBuf?: string | null  // ParsedBuf message structure. Currently saved as JSON of message.Part because bstore cannot yet store recursive types. Created when first needed, and saved in the database. todo: once replaced with non-json storage, remove date fixup in ../message/part.go.
+	Preview?: string | null  // Preview of the message text, for IMAP PREVIEW. Nil if not yet generated. It is generated when first requested, and then stored. Can be empty.
+	FileCompressed: boolean  // Whether the message file is stored compressed and/or encrypted, see CompressMessages and EncryptMessages in the account config. Set by mox when writing the file, never derived from the file contents.
+	FileEncrypted: boolean
 }
 
 // MessageEnvelope is like message.Envelope, as used in message.Part, but including
@@ -344,6 +337,70 @@ export interface Attachment {
 	Part: Part
 }
 
+// SavedSearch is a search for messages, stored in the account. Saved searches are
+// shown as virtual mailboxes in IMAP and webmail. Messages in a virtual mailbox
+// are the messages in regular mailboxes matching the search. Flags can be changed
+// through a virtual mailbox, but messages cannot be added or removed.
+export interface SavedSearch {
+	ID: number
+	Name: string  // Without VirtualPrefix.
+	Query: string  // Search query as entered in the webmail, shown when opening the saved search. Optional, the filters determine the matching messages.
+	Filter: Filter
+	NotFilter: NotFilter
+	MaxAgeDays: number  // If > 0, only messages received in the last MaxAgeDays days match.
+	All: boolean  // Whether the virtual mailbox has the special-use flag \All, for clients that show all messages of an account. ../rfc/6154
+	UIDValidity: number  // For IMAP. The UIDs in virtual mailboxes are message IDs, which are unique and stable within an account. A new UIDValidity is assigned when the filters change.
+}
+
+// EventStart is the first message sent on an SSE connection, giving the client
+// basic data to populate its UI. After this event, messages will follow quickly in
+// an EventViewMsgs event.
+export interface EventStart {
+	SSEID: number
+	LoginAddress: MessageAddress
+	Addresses?: MessageAddress[] | null
+	DomainAddressConfigs?: { [key: string]: DomainAddressConfig }  // ASCII domain to address config.
+	MailboxName: string
+	Mailboxes?: Mailbox[] | null
+	RejectsMailbox: string
+	Version: string
+}
+
+// DomainAddressConfig has the address (localpart) configuration for a domain, so
+// the webmail client can decide if an address matches the addresses of the
+// account.
+export interface DomainAddressConfig {
+	LocalpartCatchallSeparator: string  // Can be empty.
+	LocalpartCaseSensitive: boolean
+}
+
+// EventViewErr indicates an error during a query for messages. The request is
+// aborted, no more request-related messages will be sent until the next request.
+export interface EventViewErr {
+	ViewID: number
+	RequestID: number
+	Err: string  // To be displayed in client.
+}
+
+// EventViewReset indicates that a request for the next set of messages in a few
+// could not be fulfilled, e.g. because the anchor message does not exist anymore.
+// The client should clear its list of messages. This can happen before
+// EventViewMsgs events are sent.
+export interface EventViewReset {
+	ViewID: number
+	RequestID: number
+}
+
+// EventViewMsgs contains messages for a view, possibly a continuation of an
+// earlier list of messages.
+export interface EventViewMsgs {
+	ViewID: number
+	RequestID: number
+	MessageItems?: (MessageItem[] | null)[] | null  // If empty, this was the last message for the request. If non-empty, a list of thread messages. Each with the first message being the reason this thread is included and can be used as AnchorID in followup requests. If the threading mode is "off" in the query, there will always be only a single message. If a thread is sent, all messages in the thread are sent, including those that don't match the query (e.g. from another mailbox). Threads can be displayed based on the ThreadParentIDs field, with possibly slightly different display based on field ThreadMissingLink.
+	ParsedMessage?: ParsedMessage | null  // If set, will match the target page.DestMessageID from the request.
+	ViewEnd: boolean  // If set, there are no more messages in this view at this moment. Messages can be added, typically via Change messages, e.g. for new deliveries.
+}
+
 // EventViewChanges contain one or more changes relevant for the client, either
 // with new mailbox total/unseen message counts, or messages added/removed/modified
 // (flags) for the current view.
@@ -516,7 +573,7 @@ export enum SecurityResult {
 // An empty string can be a valid localpart.
 export type Localpart = string
 
-export const structTypes: {[typename: string]: boolean} = {"Address":true,"Attachment":true,"ChangeMailboxAdd":true,"ChangeMailboxCounts":true,"ChangeMailboxKeywords":true,"ChangeMailboxRemove":true,"ChangeMailboxRename":true,"ChangeMailboxSpecialUse":true,"ChangeMsgAdd":true,"ChangeMsgFlags":true,"ChangeMsgRemove":true,"ChangeMsgThread":true,"Domain":true,"DomainAddressConfig":true,"Envelope":true,"EventStart":true,"EventViewChanges":true,"EventViewErr":true,"EventViewMsgs":true,"EventViewReset":true,"File":true,"Filter":true,"Flags":true,"ForwardAttachments":true,"Mailbox":true,"Message":true,"MessageAddress":true,"MessageEnvelope":true,"MessageItem":true,"NotFilter":true,"Page":true,"ParsedMessage":true,"Part":true,"Query":true,"RecipientSecurity":true,"Request":true,"SpecialUse":true,"SubmitMessage":true}
+export const structTypes: {[typename: string]: boolean} = {"Address":true,"Attachment":true,"ChangeMailboxAdd":true,"ChangeMailboxCounts":true,"ChangeMailboxKeywords":true,"ChangeMailboxRemove":true,"ChangeMailboxRename":true,"ChangeMailboxSpecialUse":true,"ChangeMsgAdd":true,"ChangeMsgFlags":true,"ChangeMsgRemove":true,"ChangeMsgThread":true,"DeletedMessage":true,"Domain":true,"DomainAddressConfig":true,"Envelope":true,"EventStart":true,"EventViewChanges":true,"EventViewErr":true,"EventViewMsgs":true,"EventViewReset":true,"File":true,"Filter":true,"Flags":true,"ForwardAttachments":true,"Mailbox":true,"Message":true,"MessageAddress":true,"MessageEnvelope":true,"MessageItem":true,"NotFilter":true,"Page":true,"ParsedMessage":true,"Part":true,"Query":true,"RecipientSecurity":true,"Request":true,"SavedSearch":true,"SharedMailbox":true,"SpecialUse":true,"SubmitMessage":true}
 export const stringsTypes: {[typename: string]: boolean} = {"AttachmentType":true,"CSRFToken":true,"Localpart":true,"SecurityResult":true,"ThreadMode":true}
 export const intsTypes: {[typename: string]: boolean} = {"ModSeq":true,"UID":true,"Validation":true}
 export const types: TypenameMap = {
@@ -534,17 +591,20 @@ export const types: TypenameMap = {
 	"SubmitMessage": {"Name":"SubmitMessage","Docs":"","Fields":[{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"To","Docs":"","Typewords":["[]","string"]},{"Name":"Cc","Docs":"","Typewords":["[]","string"]},{"Name":"Bcc","Docs":"","Typewords":["[]","string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"TextBody","Docs":"","Typewords":["string"]},{"Name":"Attachments","Docs":"","Typewords":["[]","File"]},{"Name":"ForwardAttachments","Docs":"","Typewords":["ForwardAttachments"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"ResponseMessageID","Docs":"","Typewords":["int64"]},{"Name":"ReplyTo","Docs":"","Typewords":["string"]},{"Name":"UserAgent","Docs":"","Typewords":["string"]},{"Name":"RequireTLS","Docs":"","Typewords":["nullable","bool"]}]},
 	"File": {"Name":"File","Docs":"","Fields":[{"Name":"Filename","Docs":"","Typewords":["string"]},{"Name":"DataURI","Docs":"","Typewords":["string"]}]},
 	"ForwardAttachments": {"Name":"ForwardAttachments","Docs":"","Fields":[{"Name":"MessageID","Docs":"","Typewords":["int64"]},{"Name":"Paths","Docs":"","Typewords":["[]","[]","int32"]}]},
+	"DeletedMessage": {"Name":"DeletedMessage","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Expunged","Docs":"","Typewords":["timestamp"]},{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"MsgPrefix","Docs":"","Typewords":["nullable","string"]},{"Name":"Seen","Docs":"","Typewords":["bool"]},{"Name":"Answered","Docs":"","Typewords":["bool"]},{"Name":"Flagged","Docs":"","Typewords":["bool"]},{"Name":"Forwarded","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Notjunk","Docs":"","Typewords":["bool"]},{"Name":"Deleted","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Phishing","Docs":"","Typewords":["bool"]},{"Name":"MDNSent","Docs":"","Typewords":["bool"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"FileCompressed","Docs":"","Typewords":["bool"]},{"Name":"FileEncrypted","Docs":"","Typewords":["bool"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["string"]}]},
 	"Mailbox": {"Name":"Mailbox","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"UIDValidity","Docs":"","Typewords":["uint32"]},{"Name":"UIDNext","Docs":"","Typewords":["UID"]},{"Name":"Archive","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Sent","Docs":"","Typewords":["bool"]},{"Name":"Trash","Docs":"","Typewords":["bool"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"HaveCounts","Docs":"","Typewords":["bool"]},{"Name":"Total","Docs":"","Typewords":["int64"]},{"Name":"Deleted","Docs":"","Typewords":["int64"]},{"Name":"Unread","Docs":"","Typewords":["int64"]},{"Name":"Unseen","Docs":"","Typewords":["int64"]},{"Name":"Size","Docs":"","Typewords":["int64"]}]},
 	"RecipientSecurity": {"Name":"RecipientSecurity","Docs":"","Fields":[{"Name":"STARTTLS","Docs":"","Typewords":["SecurityResult"]},{"Name":"MTASTS","Docs":"","Typewords":["SecurityResult"]},{"Name":"DNSSEC","Docs":"","Typewords":["SecurityResult"]},{"Name":"DANE","Docs":"","Typewords":["SecurityResult"]},{"Name":"RequireTLS","Docs":"","Typewords":["SecurityResult"]}]},
+	"SharedMailbox": {"Name":"SharedMailbox","Docs":"","Fields":[{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Total","Docs":"","Typewords":["int64"]},{"Name":"Unread","Docs":"","Typewords":["int64"]}]},
+	"MessageItem": {"Name":"MessageItem","Docs":"","Fields":[{"Name":"Message","Docs":"","Typewords":["Message"]},{"Name":"Envelope","Docs":"","Typewords":["MessageEnvelope"]},{"Name":"Attachments","Docs":"","Typewords":["[]","Attachment"]},{"Name":"IsSigned","Docs":"","Typewords":["bool"]},{"Name":"IsEncrypted","Docs":"","Typewords":["bool"]},{"Name":"FirstLine","Docs":"","Typewords":["string"]},{"Name":"MatchQuery","Docs":"","Typewords":["bool"]}]},
+	"Message": {"Name":"Message","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"UID","Docs":"","Typewords":["UID"]},{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"ModSeq","Docs":"","Typewords":["ModSeq"]},{"Name":"CreateSeq","Docs":"","Typewords":["ModSeq"]},{"Name":"Expunged","Docs":"","Typewords":["bool"]},{"Name":"IsReject","Docs":"","Typewords":["bool"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"MailboxOrigID","Docs":"","Typewords":["int64"]},{"Name":"MailboxDestinedID","Docs":"","Typewords":["int64"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"SaveDate","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked1","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked2","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked3","Docs":"","Typewords":["string"]},{"Name":"EHLODomain","Docs":"","Typewords":["string"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"MailFromLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"MailFromDomain","Docs":"","Typewords":["string"]},{"Name":"RcptToLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"RcptToDomain","Docs":"","Typewords":["string"]},{"Name":"MsgFromLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"MsgFromDomain","Docs":"","Typewords":["string"]},{"Name":"MsgFromOrgDomain","Docs":"","Typewords":["string"]},{"Name":"EHLOValidated","Docs":"","Typewords":["bool"]},{"Name":"MailFromValidated","Docs":"","Typewords":["bool"]},{"Name":"MsgFromValidated","Docs":"","Typewords":["bool"]},{"Name":"EHLOValidation","Docs":"","Typewords":["Validation"]},{"Name":"MailFromValidation","Docs":"","Typewords":["Validation"]},{"Name":"MsgFromValidation","Docs":"","Typewords":["Validation"]},{"Name":"DKIMDomains","Docs":"","Typewords":["[]","string"]},{"Name":"OrigEHLODomain","Docs":"","Typewords":["string"]},{"Name":"OrigDKIMDomains","Docs":"","Typewords":["[]","string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"SubjectBase","Docs":"","Typewords":["string"]},{"Name":"MessageHash","Docs":"","Typewords":["nullable","string"]},{"Name":"ThreadID","Docs":"","Typewords":["int64"]},{"Name":"ThreadParentIDs","Docs":"","Typewords":["[]","int64"]},{"Name":"ThreadMissingLink","Docs":"","Typewords":["bool"]},{"Name":"ThreadMuted","Docs":"","Typewords":["bool"]},{"Name":"ThreadCollapsed","Docs":"","Typewords":["bool"]},{"Name":"IsMailingList","Docs":"","Typewords":["bool"]},{"Name":"ReceivedTLSVersion","Docs":"","Typewords":["uint16"]},{"Name":"ReceivedTLSCipherSuite","Docs":"","Typewords":["uint16"]},{"Name":"ReceivedRequireTLS","Docs":"","Typewords":["bool"]},{"Name":"Seen","Docs":"","Typewords":["bool"]},{"Name":"Answered","Docs":"","Typewords":["bool"]},{"Name":"Flagged","Docs":"","Typewords":["bool"]},{"Name":"Forwarded","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Notjunk","Docs":"","Typewords":["bool"]},{"Name":"Deleted","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Phishing","Docs":"","Typewords":["bool"]},{"Name":"MDNSent","Docs":"","Typewords":["bool"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"TrainedJunk","Docs":"","Typewords":["nullable","bool"]},{"Name":"MsgPrefix","Docs":"","Typewords":["nullable","string"]},{"Name":"ParsedBuf","Docs":"","Typewords":["nullable","string"]},{"Name":"Preview","Docs":"","Typewords":["nullable","string"]},{"Name":"FileCompressed","Docs":"","Typewords":["bool"]},{"Name":"FileEncrypted","Docs":"","Typewords":["bool"]}]},
+	"MessageEnvelope": {"Name":"MessageEnvelope","Docs":"","Fields":[{"Name":"Date","Docs":"","Typewords":["timestamp"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"Sender","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"ReplyTo","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"To","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"CC","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"BCC","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"InReplyTo","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]}]},
+	"Attachment": {"Name":"Attachment","Docs":"","Fields":[{"Name":"Path","Docs":"","Typewords":["[]","int32"]},{"Name":"Filename","Docs":"","Typewords":["string"]},{"Name":"Part","Docs":"","Typewords":["Part"]}]},
+	"SavedSearch": {"Name":"SavedSearch","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Query","Docs":"","Typewords":["string"]},{"Name":"Filter","Docs":"","Typewords":["Filter"]},{"Name":"NotFilter","Docs":"","Typewords":["NotFilter"]},{"Name":"MaxAgeDays","Docs":"","Typewords":["int32"]},{"Name":"All","Docs":"","Typewords":["bool"]},{"Name":"UIDValidity","Docs":"","Typewords":["uint32"]}]},
 	"EventStart": {"Name":"EventStart","Docs":"","Fields":[{"Name":"SSEID","Docs":"","Typewords":["int64"]},{"Name":"LoginAddress","Docs":"","Typewords":["MessageAddress"]},{"Name":"Addresses","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"DomainAddressConfigs","Docs":"","Typewords":["{}","DomainAddressConfig"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Mailboxes","Docs":"","Typewords":["[]","Mailbox"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"Version","Docs":"","Typewords":["string"]}]},
 	"DomainAddressConfig": {"Name":"DomainAddressConfig","Docs":"","Fields":[{"Name":"LocalpartCatchallSeparator","Docs":"","Typewords":["string"]},{"Name":"LocalpartCaseSensitive","Docs":"","Typewords":["bool"]}]},
 	"EventViewErr": {"Name":"EventViewErr","Docs":"","Fields":[{"Name":"ViewID","Docs":"","Typewords":["int64"]},{"Name":"RequestID","Docs":"","Typewords":["int64"]},{"Name":"Err","Docs":"","Typewords":["string"]}]},
 	"EventViewReset": {"Name":"EventViewReset","Docs":"","Fields":[{"Name":"ViewID","Docs":"","Typewords":["int64"]},{"Name":"RequestID","Docs":"","Typewords":["int64"]}]},
 	"EventViewMsgs": {"Name":"EventViewMsgs","Docs":"","Fields":[{"Name":"ViewID","Docs":"","Typewords":["int64"]},{"Name":"RequestID","Docs":"","Typewords":["int64"]},{"Name":"MessageItems","Docs":"","Typewords":["[]","[]","MessageItem"]},{"Name":"ParsedMessage","Docs":"","Typewords":["nullable","ParsedMessage"]},{"Name":"ViewEnd","Docs":"","Typewords":["bool"]}]},
-	"MessageItem": {"Name":"MessageItem","Docs":"","Fields":[{"Name":"Message","Docs":"","Typewords":["Message"]},{"Name":"Envelope","Docs":"","Typewords":["MessageEnvelope"]},{"Name":"Attachments","Docs":"","Typewords":["[]","Attachment"]},{"Name":"IsSigned","Docs":"","Typewords":["bool"]},{"Name":"IsEncrypted","Docs":"","Typewords":["bool"]},{"Name":"FirstLine","Docs":"","Typewords":["string"]},{"Name":"MatchQuery","Docs":"","Typewords":["bool"]}]},
-	"Message": {"Name":"Message","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"UID","Docs":"","Typewords":["UID"]},{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"ModSeq","Docs":"","Typewords":["ModSeq"]},{"Name":"CreateSeq","Docs":"","Typewords":["ModSeq"]},{"Name":"Expunged","Docs":"","Typewords":["bool"]},{"Name":"IsReject","Docs":"","Typewords":["bool"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"MailboxOrigID","Docs":"","Typewords":["int64"]},{"Name":"MailboxDestinedID","Docs":"","Typewords":["int64"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked1","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked2","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked3","Docs":"","Typewords":["string"]},{"Name":"EHLODomain","Docs":"","Typewords":["string"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"MailFromLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"MailFromDomain","Docs":"","Typewords":["string"]},{"Name":"RcptToLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"RcptToDomain","Docs":"","Typewords":["string"]},{"Name":"MsgFromLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"MsgFromDomain","Docs":"","Typewords":["string"]},{"Name":"MsgFromOrgDomain","Docs":"","Typewords":["string"]},{"Name":"EHLOValidated","Docs":"","Typewords":["bool"]},{"Name":"MailFromValidated","Docs":"","Typewords":["bool"]},{"Name":"MsgFromValidated","Docs":"","Typewords":["bool"]},{"Name":"EHLOValidation","Docs":"","Typewords":["Validation"]},{"Name":"MailFromValidation","Docs":"","Typewords":["Validation"]},{"Name":"MsgFromValidation","Docs":"","Typewords":["Validation"]},{"Name":"DKIMDomains","Docs":"","Typewords":["[]","string"]},{"Name":"OrigEHLODomain","Docs":"","Typewords":["string"]},{"Name":"OrigDKIMDomains","Docs":"","Typewords":["[]","string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"SubjectBase","Docs":"","Typewords":["string"]},{"Name":"MessageHash","Docs":"","Typewords":["nullable","string"]},{"Name":"ThreadID","Docs":"","Typewords":["int64"]},{"Name":"ThreadParentIDs","Docs":"","Typewords":["[]","int64"]},{"Name":"ThreadMissingLink","Docs":"","Typewords":["bool"]},{"Name":"ThreadMuted","Docs":"","Typewords":["bool"]},{"Name":"ThreadCollapsed","Docs":"","Typewords":["bool"]},{"Name":"IsMailingList","Docs":"","Typewords":["bool"]},{"Name":"ReceivedTLSVersion","Docs":"","Typewords":["uint16"]},{"Name":"ReceivedTLSCipherSuite","Docs":"","Typewords":["uint16"]},{"Name":"ReceivedRequireTLS","Docs":"","Typewords":["bool"]},{"Name":"Seen","Docs":"","Typewords":["bool"]},{"Name":"Answered","Docs":"","Typewords":["bool"]},{"Name":"Flagged","Docs":"","Typewords":["bool"]},{"Name":"Forwarded","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Notjunk","Docs":"","Typewords":["bool"]},{"Name":"Deleted","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Phishing","Docs":"","Typewords":["bool"]},{"Name":"MDNSent","Docs":"","Typewords":["bool"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"TrainedJunk","Docs":"","Typewords":["nullable","bool"]},{"Name":"MsgPrefix","Docs":"","Typewords":["nullable","string"]},{"Name":"ParsedBuf","Docs":"","Typewords":["nullable","string"]}]},
-	"MessageEnvelope": {"Name":"MessageEnvelope","Docs":"","Fields":[{"Name":"Date","Docs":"","Typewords":["timestamp"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"Sender","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"ReplyTo","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"To","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"CC","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"BCC","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"InReplyTo","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]}]},
-	"Attachment": {"Name":"Attachment","Docs":"","Fields":[{"Name":"Path","Docs":"","Typewords":["[]","int32"]},{"Name":"Filename","Docs":"","Typewords":["string"]},{"Name":"Part","Docs":"","Typewords":["Part"]}]},
 	"EventViewChanges": {"Name":"EventViewChanges","Docs":"","Fields":[{"Name":"ViewID","Docs":"","Typewords":["int64"]},{"Name":"Changes","Docs":"","Typewords":["[]","[]","any"]}]},
 	"ChangeMsgAdd": {"Name":"ChangeMsgAdd","Docs":"","Fields":[{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"UID","Docs":"","Typewords":["UID"]},{"Name":"ModSeq","Docs":"","Typewords":["ModSeq"]},{"Name":"Flags","Docs":"","Typewords":["Flags"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"MessageItems","Docs":"","Typewords":["[]","MessageItem"]}]},
 	"Flags": {"Name":"Flags","Docs":"","Fields":[{"Name":"Seen","Docs":"","Typewords":["bool"]},{"Name":"Answered","Docs":"","Typewords":["bool"]},{"Name":"Flagged","Docs":"","Typewords":["bool"]},{"Name":"Forwarded","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Notjunk","Docs":"","Typewords":["bool"]},{"Name":"Deleted","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Phishing","Docs":"","Typewords":["bool"]},{"Name":"MDNSent","Docs":"","Typewords":["bool"]}]},
@@ -583,17 +643,20 @@ export const parser = {
 	SubmitMessage: (v: any) => parse("SubmitMessage", v) as SubmitMessage,
 	File: (v: any) => parse("File", v) as File,
 	ForwardAttachments: (v: any) => parse("ForwardAttachments", v) as ForwardAttachments,
+	DeletedMessage: (v: any) => parse("DeletedMessage", v) as DeletedMessage,
 	Mailbox: (v: any) => parse("Mailbox", v) as Mailbox,
 	RecipientSecurity: (v: any) => parse("RecipientSecurity", v) as RecipientSecurity,
+	SharedMailbox: (v: any) => parse("SharedMailbox", v) as SharedMailbox,
+	MessageItem: (v: any) => parse("MessageItem", v) as MessageItem,
+	Message: (v: any) => parse("Message", v) as Message,
+	MessageEnvelope: (v: any) => parse("MessageEnvelope", v) as MessageEnvelope,
+	Attachment: (v: any) => parse("Attachment", v) as Attachment,
+	SavedSearch: (v: any) => parse("SavedSearch", v) as SavedSearch,
 	EventStart: (v: any) => parse("EventStart", v) as EventStart,
 	DomainAddressConfig: (v: any) => parse("DomainAddressConfig", v) as DomainAddressConfig,
 	EventViewErr: (v: any) => parse("EventViewErr", v) as EventViewErr,
 	EventViewReset: (v: any) => parse("EventViewReset", v) as EventViewReset,
 	EventViewMsgs: (v: any) => parse("EventViewMsgs", v) as EventViewMsgs,
-	MessageItem: (v: any) => parse("MessageItem", v) as MessageItem,
-	Message: (v: any) => parse("Message", v) as Message,
-	MessageEnvelope: (v: any) => parse("MessageEnvelope", v) as MessageEnvelope,
-	Attachment: (v: any) => parse("Attachment", v) as Attachment,
 	EventViewChanges: (v: any) => parse("EventViewChanges", v) as EventViewChanges,
 	ChangeMsgAdd: (v: any) => parse("ChangeMsgAdd", v) as ChangeMsgAdd,
 	Flags: (v: any) => parse("Flags", v) as Flags,
@@ -655,12 +718,15 @@ export class Client {
 	}
 
 	// Login returns a session token for the credentials, or fails with error code
-	// "user:badLogin". Call LoginPrep to get a loginToken.
-	async Login(loginToken: string, username: string, password: string): Promise<CSRFToken> {
+	// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+	// authentication is enabled, totpCode must be a code from the authenticator app
+	// or a recovery code, the call fails with error code "user:totpRequired" if it is
+	// empty.
+	async Login(loginToken: string, username: string, password: string, totpCode: string): Promise<CSRFToken> {
 		const fn: string = "Login"
-		const paramTypes: string[][] = [["string"],["string"],["string"]]
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"]]
 		const returnTypes: string[][] = [["CSRFToken"]]
-		const params: any[] = [loginToken, username, password]
+		const params: any[] = [loginToken, username, password, totpCode]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
 	}
 
@@ -740,6 +806,28 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// DeletedList returns the messages that were permanently deleted, e.g. by
+	// emptying the Trash mailbox, but can still be recovered, most recently deleted
+	// first. Messages are only kept if the account is configured with a
+	// KeepDeletedPeriod.
+	async DeletedList(): Promise<DeletedMessage[] | null> {
+		const fn: string = "DeletedList"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","DeletedMessage"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as DeletedMessage[] | null
+	}
+
+	// DeletedRecover recovers deleted messages, adding them as new messages to the
+	// mailbox they were deleted from, or Inbox if that mailbox no longer exists.
+	async DeletedRecover(messageIDs: number[] | null): Promise<void> {
+		const fn: string = "DeletedRecover"
+		const paramTypes: string[][] = [["[]","int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [messageIDs]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// FlagsAdd adds flags, either system flags like \Seen or custom keywords. The
 	// flags should be lower-case, but will be converted and verified.
 	async FlagsAdd(messageIDs: number[] | null, flaglist: string[] | null): Promise<void> {
@@ -848,6 +936,66 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as RecipientSecurity
 	}
 
+	// SharedMailboxes returns the mailboxes of other accounts that have been shared
+	// with the account, with rights to see and read the messages.
+	async SharedMailboxes(): Promise<SharedMailbox[] | null> {
+		const fn: string = "SharedMailboxes"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","SharedMailbox"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as SharedMailbox[] | null
+	}
+
+	// SharedMessages returns messages of a mailbox shared by another account, most
+	// recently received first, starting at offset. At most 100 messages are returned.
+	// Shared mailboxes are read-only in the webmail, messages are not marked as read.
+	async SharedMessages(accountName: string, mailboxID: number, offset: number): Promise<MessageItem[] | null> {
+		const fn: string = "SharedMessages"
+		const paramTypes: string[][] = [["string"],["int64"],["int32"]]
+		const returnTypes: string[][] = [["[]","MessageItem"]]
+		const params: any[] = [accountName, mailboxID, offset]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as MessageItem[] | null
+	}
+
+	// SharedParsedMessage is like ParsedMessage, but for a message in a mailbox
+	// shared by another account.
+	async SharedParsedMessage(accountName: string, mailboxID: number, msgID: number): Promise<ParsedMessage> {
+		const fn: string = "SharedParsedMessage"
+		const paramTypes: string[][] = [["string"],["int64"],["int64"]]
+		const returnTypes: string[][] = [["ParsedMessage"]]
+		const params: any[] = [accountName, mailboxID, msgID]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as ParsedMessage
+	}
+
+	// SavedSearches returns the saved searches of the account, sorted by name. Saved
+	// searches are also available as virtual mailboxes over IMAP.
+	async SavedSearches(): Promise<SavedSearch[] | null> {
+		const fn: string = "SavedSearches"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","SavedSearch"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as SavedSearch[] | null
+	}
+
+	// SavedSearchSave adds a new saved search if ss.ID is 0, or updates an existing
+	// saved search. The stored saved search is returned.
+	async SavedSearchSave(ss: SavedSearch): Promise<SavedSearch> {
+		const fn: string = "SavedSearchSave"
+		const paramTypes: string[][] = [["SavedSearch"]]
+		const returnTypes: string[][] = [["SavedSearch"]]
+		const params: any[] = [ss]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as SavedSearch
+	}
+
+	// SavedSearchRemove removes a saved search.
+	async SavedSearchRemove(savedSearchID: number): Promise<void> {
+		const fn: string = "SavedSearchRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [savedSearchID]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// SSETypes exists to ensure the generated API contains the types, for use in SSE events.
 	async SSETypes(): Promise<[EventStart, EventViewErr, EventViewReset, EventViewMsgs, EventViewChanges, ChangeMsgAdd, ChangeMsgRemove, ChangeMsgFlags, ChangeMsgThread, ChangeMailboxRemove, ChangeMailboxAdd, ChangeMailboxRename, ChangeMailboxCounts, ChangeMailboxSpecialUse, ChangeMailboxKeywords, Flags]> {
 		const fn: string = "SSETypes"
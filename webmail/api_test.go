@@ -91,7 +91,7 @@ func TestAPI(t *testing.T) {
 	loginctx := context.WithValue(ctxbg, requestInfoCtxKey, loginReqInfo)
 
 	// Missing login token.
-	tneedErrorCode(t, "user:error", func() { api.Login(loginctx, "", "mjl@mox.example", "test1234") })
+	tneedErrorCode(t, "user:error", func() { api.Login(loginctx, "", "mjl@mox.example", "test1234", "") })
 
 	// Login with loginToken.
 	loginCookie := &http.Cookie{Name: "webmaillogin"}
@@ -116,7 +116,7 @@ func TestAPI(t *testing.T) {
 			}
 		}()
 
-		api.Login(loginctx, loginCookie.Value, username, password)
+		api.Login(loginctx, loginCookie.Value, username, password, "")
 	}
 	testLogin("mjl@mox.example", "test1234")
 	testLogin("mjl@mox.example", "bad", "user:loginFailed")
@@ -430,4 +430,63 @@ func TestAPI(t *testing.T) {
 	rs, err = recipientSecurity(ctx, resolver, "mjl@a.mox.example")
 	tcompare(t, err, nil)
 	tcompare(t, rs, RecipientSecurity{SecurityResultYes, SecurityResultNo, SecurityResultNo, SecurityResultNo, SecurityResultNo})
+
+	// Shared mailboxes, mjl shares its Inbox with account other.
+	otherReqInfo := requestInfo{"other@mox.example", "other", "", nil, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	otherctx := context.WithValue(ctxbg, requestInfoCtxKey, otherReqInfo)
+	tcompare(t, len(api.SharedMailboxes(otherctx)), 0)
+	setRights := func(rights store.Rights) {
+		t.Helper()
+		err := acc.DB.Write(ctx, func(tx *bstore.Tx) error {
+			return acc.MailboxACLSet(tx, inbox.ID, "other", rights)
+		})
+		tcheck(t, err, "set rights")
+	}
+	setRights("l")
+	tcompare(t, len(api.SharedMailboxes(otherctx)), 0)                         // Reading messages requires "r".
+	tneedError(t, func() { api.SharedMessages(otherctx, "mjl", inbox.ID, 0) }) // Idem.
+	setRights("lr")
+	shared := api.SharedMailboxes(otherctx)
+	tcompare(t, len(shared), 1)
+	tcompare(t, shared[0].Account, "mjl")
+	tcompare(t, shared[0].Name, "Inbox")
+	sharedMsgs := api.SharedMessages(otherctx, "mjl", inbox.ID, 0)
+	tcompare(t, int64(len(sharedMsgs)), shared[0].Total)
+	tcompare(t, len(api.SharedMessages(otherctx, "mjl", inbox.ID, len(sharedMsgs))), 0)
+	api.SharedParsedMessage(otherctx, "mjl", inbox.ID, sharedMsgs[0].Message.ID)
+	tneedError(t, func() { api.SharedMessages(ctx, "mjl", inbox.ID, 0) })                                     // Own account.
+	tneedError(t, func() { api.SharedMessages(otherctx, "bogus", inbox.ID, 0) })                              // Unknown account.
+	tneedError(t, func() { api.SharedMessages(otherctx, "mjl", testbox1.ID, 0) })                             // Not shared.
+	tneedError(t, func() { api.SharedParsedMessage(otherctx, "mjl", inbox.ID, testbox1Alt.ID) })              // Not in mailbox.
+	tneedError(t, func() { api.SharedParsedMessage(otherctx, "mjl", testbox1.ID, sharedMsgs[0].Message.ID) }) // Not shared.
+
+	// Saved searches.
+	tcompare(t, len(api.SavedSearches(ctx)), 0)
+	unread := store.SavedSearch{
+		Name:      "Unread",
+		Query:     `-label:\Seen`,
+		Filter:    store.Filter{MailboxID: -1, MailboxName: "ignored"},
+		NotFilter: store.NotFilter{Labels: []string{`\Seen`}},
+	}
+	unread = api.SavedSearchSave(ctx, unread)
+	tcompare(t, unread.ID != 0, true)
+	tcompare(t, unread.Filter.MailboxName, "")
+	uidvalidity := unread.UIDValidity
+	unread.Query = "changed"
+	unread = api.SavedSearchSave(ctx, unread)
+	tcompare(t, unread.UIDValidity, uidvalidity) // Filters unchanged, uidvalidity remains.
+	unread.MaxAgeDays = 7
+	unread = api.SavedSearchSave(ctx, unread)
+	tcompare(t, unread.UIDValidity != uidvalidity, true)
+	tcompare(t, api.SavedSearches(ctx), []store.SavedSearch{unread})
+	tneedError(t, func() { api.SavedSearchSave(ctx, store.SavedSearch{Name: "Unread"}) })              // Duplicate name.
+	tneedError(t, func() { api.SavedSearchSave(ctx, store.SavedSearch{Name: "a/b"}) })                 // Bad name.
+	tneedError(t, func() { api.SavedSearchSave(ctx, store.SavedSearch{Name: ""}) })                    // Empty name.
+	tneedError(t, func() { api.SavedSearchSave(ctx, store.SavedSearch{Name: "Old", MaxAgeDays: -1}) }) // Bad max age.
+	unknownMailbox := store.SavedSearch{Name: "Mailbox", Filter: store.Filter{MailboxID: 999}}
+	tneedError(t, func() { api.SavedSearchSave(ctx, unknownMailbox) })
+	tneedError(t, func() { api.SavedSearchSave(ctx, store.SavedSearch{ID: 999, Name: "Unknown"}) }) // Unknown ID.
+	api.SavedSearchRemove(ctx, unread.ID)
+	tneedError(t, func() { api.SavedSearchRemove(ctx, unread.ID) })
+	tcompare(t, len(api.SavedSearches(ctx)), 0)
 }
@@ -1,7 +1,6 @@
 package webmail
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"mime"
@@ -9,12 +8,10 @@ import (
 	"strings"
 
 	"golang.org/x/exp/slog"
-	"golang.org/x/text/encoding/ianaindex"
 
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
-	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxio"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
@@ -22,53 +19,6 @@ import (
 
 // todo: we should have all needed information for messageItem in store.Message (perhaps some data in message.Part) for fast access, not having to parse the on-disk message file.
 
-var wordDecoder = mime.WordDecoder{
-	CharsetReader: func(charset string, r io.Reader) (io.Reader, error) {
-		switch strings.ToLower(charset) {
-		case "", "us-ascii", "utf-8":
-			return r, nil
-		}
-		enc, _ := ianaindex.MIME.Encoding(charset)
-		if enc == nil {
-			enc, _ = ianaindex.IANA.Encoding(charset)
-		}
-		if enc == nil {
-			return r, fmt.Errorf("unknown charset %q", charset)
-		}
-		return enc.NewDecoder().Reader(r), nil
-	},
-}
-
-// Attempt q/b-word-decode name, coming from Content-Type "name" field or
-// Content-Disposition "filename" field.
-//
-// RFC 2231 specify an encoding for non-ascii values in mime header parameters. But
-// it appears common practice to instead just q/b-word encode the values.
-// Thunderbird and gmail.com do this for the Content-Type "name" parameter.
-// gmail.com also does that for the Content-Disposition "filename" parameter, where
-// Thunderbird uses the RFC 2231-defined encoding. Go's mime.ParseMediaType parses
-// the mechanism specified in RFC 2231 only. The value for "name" we get here would
-// already be decoded properly for standards-compliant headers, like
-// "filename*0*=UTF-8”%...; filename*1*=%.... We'll look for Q/B-word encoding
-// markers ("=?"-prefix or "?="-suffix) and try to decode if present. This would
-// only cause trouble for filenames having this prefix/suffix.
-func tryDecodeParam(log mlog.Log, name string) string {
-	if name == "" || !strings.HasPrefix(name, "=?") && !strings.HasSuffix(name, "?=") {
-		return name
-	}
-	// todo: find where this is allowed. it seems quite common. perhaps we should remove the pedantic check?
-	if mox.Pedantic {
-		log.Debug("attachment contains rfc2047 q/b-word-encoded mime parameter instead of rfc2231-encoded", slog.String("name", name))
-		return name
-	}
-	s, err := wordDecoder.DecodeHeader(name)
-	if err != nil {
-		log.Debugx("q/b-word decoding mime parameter", err, slog.String("name", name))
-		return name
-	}
-	return s
-}
-
 // todo: mime.FormatMediaType does not wrap long lines. should do it ourselves, and split header into several parts (if commonly supported).
 
 func messageItem(log mlog.Log, m store.Message, state *msgState) (MessageItem, error) {
@@ -82,104 +32,6 @@ func messageItem(log mlog.Log, m store.Message, state *msgState) (MessageItem, e
 	return MessageItem{m, pm.envelope, pm.attachments, pm.isSigned, pm.isEncrypted, pm.firstLine, true}, nil
 }
 
-// formatFirstLine returns a line the client can display next to the subject line
-// in a mailbox. It will replace quoted text, and any prefixing "On ... write:"
-// line with "[...]" so only new and useful information will be displayed.
-// Trailing signatures are not included.
-func formatFirstLine(r io.Reader) (string, error) {
-	// We look quite a bit of lines ahead for trailing signatures with trailing empty lines.
-	var lines []string
-	scanner := bufio.NewScanner(r)
-	ensureLines := func() {
-		for len(lines) < 10 && scanner.Scan() {
-			lines = append(lines, strings.TrimSpace(scanner.Text()))
-		}
-	}
-	ensureLines()
-
-	isSnipped := func(s string) bool {
-		return s == "[...]" || s == "[…]" || s == "..."
-	}
-
-	nextLineQuoted := func(i int) bool {
-		if i+1 < len(lines) && lines[i+1] == "" {
-			i++
-		}
-		return i+1 < len(lines) && (strings.HasPrefix(lines[i+1], ">") || isSnipped(lines[i+1]))
-	}
-
-	// Remainder is signature if we see a line with only and minimum 2 dashes, and
-	// there are no more empty lines, and there aren't more than 5 lines left.
-	isSignature := func() bool {
-		if len(lines) == 0 || !strings.HasPrefix(lines[0], "--") || strings.Trim(strings.TrimSpace(lines[0]), "-") != "" {
-			return false
-		}
-		l := lines[1:]
-		for len(l) > 0 && l[len(l)-1] == "" {
-			l = l[:len(l)-1]
-		}
-		if len(l) >= 5 {
-			return false
-		}
-		for _, line := range l {
-			if line == "" {
-				return false
-			}
-		}
-		return true
-	}
-
-	result := ""
-
-	resultSnipped := func() bool {
-		return strings.HasSuffix(result, "[...]\n") || strings.HasSuffix(result, "[…]")
-	}
-
-	// Quick check for initial wrapped "On ... wrote:" line.
-	if len(lines) > 3 && strings.HasPrefix(lines[0], "On ") && !strings.HasSuffix(lines[0], "wrote:") && strings.HasSuffix(lines[1], ":") && nextLineQuoted(1) {
-		result = "[...]\n"
-		lines = lines[3:]
-		ensureLines()
-	}
-
-	for ; len(lines) > 0 && !isSignature(); ensureLines() {
-		line := lines[0]
-		if strings.HasPrefix(line, ">") {
-			if !resultSnipped() {
-				result += "[...]\n"
-			}
-			lines = lines[1:]
-			continue
-		}
-		if line == "" {
-			lines = lines[1:]
-			continue
-		}
-		// Check for a "On <date>, <person> wrote:", we require digits before a quoted
-		// line, with an optional empty line in between. If we don't have any text yet, we
-		// don't require the digits.
-		if strings.HasSuffix(line, ":") && (strings.ContainsAny(line, "0123456789") || result == "") && nextLineQuoted(0) {
-			if !resultSnipped() {
-				result += "[...]\n"
-			}
-			lines = lines[1:]
-			continue
-		}
-		// Skip possibly duplicate snipping by author.
-		if !isSnipped(line) || !resultSnipped() {
-			result += line + "\n"
-		}
-		lines = lines[1:]
-		if len(result) > 250 {
-			break
-		}
-	}
-	if len(result) > 250 {
-		result = result[:230] + "..."
-	}
-	return result, scanner.Err()
-}
-
 func parsedMessage(log mlog.Log, m store.Message, state *msgState, full, msgitem bool) (pm ParsedMessage, rerr error) {
 	if full || msgitem {
 		if !state.ensurePart(m, true) {
@@ -265,9 +117,9 @@ func parsedMessage(log mlog.Log, m store.Message, state *msgState, full, msgitem
 					disp, params, err := mime.ParseMediaType(cp)
 					log.Check(err, "parsing content-disposition", slog.String("cp", cp))
 					if strings.EqualFold(disp, "attachment") {
-						name := tryDecodeParam(log, p.ContentTypeParams["name"])
+						name := store.TryDecodeParam(log, p.ContentTypeParams["name"])
 						if name == "" {
-							name = tryDecodeParam(log, params["filename"])
+							name = store.TryDecodeParam(log, params["filename"])
 						}
 						pm.attachments = append(pm.attachments, Attachment{path, name, p})
 						return
@@ -284,7 +136,7 @@ func parsedMessage(log mlog.Log, m store.Message, state *msgState, full, msgitem
 				pm.Texts = append(pm.Texts, string(buf))
 			}
 			if msgitem && pm.firstLine == "" {
-				pm.firstLine, rerr = formatFirstLine(p.ReaderUTF8OrBinary())
+				pm.firstLine, rerr = message.FormatFirstLine(p.ReaderUTF8OrBinary())
 				if rerr != nil {
 					rerr = fmt.Errorf("reading text for first line snippet: %v", rerr)
 					return
@@ -337,7 +189,7 @@ func parsedMessage(log mlog.Log, m store.Message, state *msgState, full, msgitem
 					return
 				}
 
-				name := tryDecodeParam(log, p.ContentTypeParams["name"])
+				name := store.TryDecodeParam(log, p.ContentTypeParams["name"])
 				if name == "" && (full || msgitem) {
 					// todo: should have this, and perhaps all content-* headers, preparsed in message.Part?
 					h, err := p.Header()
@@ -346,7 +198,7 @@ func parsedMessage(log mlog.Log, m store.Message, state *msgState, full, msgitem
 					if cp != "" {
 						_, params, err := mime.ParseMediaType(cp)
 						log.Check(err, "parsing content-disposition", slog.String("cp", cp))
-						name = tryDecodeParam(log, params["filename"])
+						name = store.TryDecodeParam(log, params["filename"])
 					}
 				}
 				pm.attachments = append(pm.attachments, Attachment{path, name, p})
@@ -11,7 +11,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"path/filepath"
 	"reflect"
 	"runtime/debug"
 	"strconv"
@@ -66,60 +65,8 @@ const (
 type Query struct {
 	OrderAsc  bool // Order by received ascending or desending.
 	Threading ThreadMode
-	Filter    Filter
-	NotFilter NotFilter
-}
-
-// AttachmentType is for filtering by attachment type.
-type AttachmentType string
-
-const (
-	AttachmentIndifferent  AttachmentType = ""
-	AttachmentNone         AttachmentType = "none"
-	AttachmentAny          AttachmentType = "any"
-	AttachmentImage        AttachmentType = "image" // png, jpg, gif, ...
-	AttachmentPDF          AttachmentType = "pdf"
-	AttachmentArchive      AttachmentType = "archive"      // zip files, tgz, ...
-	AttachmentSpreadsheet  AttachmentType = "spreadsheet"  // ods, xlsx, ...
-	AttachmentDocument     AttachmentType = "document"     // odt, docx, ...
-	AttachmentPresentation AttachmentType = "presentation" // odp, pptx, ...
-)
-
-// Filter selects the messages to return. Fields that are set must all match,
-// for slices each element by match ("and").
-type Filter struct {
-	// If -1, then all mailboxes except Trash/Junk/Rejects. Otherwise, only active if > 0.
-	MailboxID int64
-
-	// If true, also submailboxes are included in the search.
-	MailboxChildrenIncluded bool
-
-	// In case client doesn't know mailboxes and their IDs yet. Only used during sse
-	// connection setup, where it is turned into a MailboxID. Filtering only looks at
-	// MailboxID.
-	MailboxName string
-
-	Words       []string // Case insensitive substring match for each string.
-	From        []string
-	To          []string // Including Cc and Bcc.
-	Oldest      *time.Time
-	Newest      *time.Time
-	Subject     []string
-	Attachments AttachmentType
-	Labels      []string
-	Headers     [][2]string // Header values can be empty, it's a check if the header is present, regardless of value.
-	SizeMin     int64
-	SizeMax     int64
-}
-
-// NotFilter matches messages that don't match these fields.
-type NotFilter struct {
-	Words       []string
-	From        []string
-	To          []string
-	Subject     []string
-	Attachments AttachmentType
-	Labels      []string
+	Filter    store.Filter
+	NotFilter store.NotFilter
 }
 
 // Page holds pagination parameters for a request.
@@ -677,8 +624,8 @@ func serveEvents(ctx context.Context, log mlog.Log, w http.ResponseWriter, r *ht
 	})
 
 	// Find the designated mailbox if a mailbox name is set, or there are no filters at all.
-	var zerofilter Filter
-	var zeronotfilter NotFilter
+	var zerofilter store.Filter
+	var zeronotfilter store.NotFilter
 	var mailbox store.Mailbox
 	var mailboxPrefixes []string
 	var matchMailboxes bool
@@ -833,7 +780,9 @@ func serveEvents(ctx context.Context, log mlog.Log, w http.ResponseWriter, r *ht
 		for _, change := range changes {
 			switch c := change.(type) {
 			case store.ChangeAddUID:
-				ok, err := v.matches(log, acc, true, 0, c.MailboxID, c.UID, c.Flags, c.Keywords, getmsg)
+				err := ensureTx()
+				xcheckf(ctx, err, "transaction")
+				ok, err := v.matches(log, acc, xtx, true, 0, c.MailboxID, c.UID, c.Flags, c.Keywords, getmsg)
 				xcheckf(ctx, err, "matching new message against view")
 				m, err := getmsg(0, c.MailboxID, c.UID)
 				xcheckf(ctx, err, "get message")
@@ -906,9 +855,12 @@ func serveEvents(ctx context.Context, log mlog.Log, w http.ResponseWriter, r *ht
 			case store.ChangeMailboxKeywords:
 				taggedChanges = append(taggedChanges, [2]any{"ChangeMailboxKeywords", ChangeMailboxKeywords{c}})
 
-			case store.ChangeAddSubscription:
+			case store.ChangeAddSubscription, store.ChangeRemoveSubscription:
 				// Webmail does not care about subscriptions.
 
+			case store.ChangeAnnotation:
+				// Not used by webmail.
+
 			default:
 				panic(fmt.Sprintf("missing case for change %T", c))
 			}
@@ -1065,50 +1017,18 @@ func serveEvents(ctx context.Context, log mlog.Log, w http.ResponseWriter, r *ht
 	}
 }
 
-// xprepareMailboxIDs prepare the first half of filters for mailboxes, based on
-// f.MailboxID (-1 is special). matchMailboxes indicates whether the IDs in
-// mailboxIDs must or must not match. mailboxPrefixes is for use with
-// xgatherMailboxIDs to gather children of the mailboxIDs.
-func xprepareMailboxIDs(ctx context.Context, tx *bstore.Tx, f Filter, rejectsMailbox string) (matchMailboxes bool, mailboxIDs map[int64]bool, mailboxPrefixes []string) {
-	matchMailboxes = true
-	mailboxIDs = map[int64]bool{}
-	if f.MailboxID == -1 {
-		matchMailboxes = false
-		// Add the trash, junk and account rejects mailbox.
-		err := bstore.QueryTx[store.Mailbox](tx).ForEach(func(mb store.Mailbox) error {
-			if mb.Trash || mb.Junk || mb.Name == rejectsMailbox {
-				mailboxPrefixes = append(mailboxPrefixes, mb.Name+"/")
-				mailboxIDs[mb.ID] = true
-			}
-			return nil
-		})
-		xcheckf(ctx, err, "finding trash/junk/rejects mailbox")
-	} else if f.MailboxID > 0 {
-		mb := store.Mailbox{ID: f.MailboxID}
-		err := tx.Get(&mb)
-		xcheckf(ctx, err, "get mailbox")
-		mailboxIDs[f.MailboxID] = true
-		mailboxPrefixes = []string{mb.Name + "/"}
-	}
+// xprepareMailboxIDs prepare the first half of filters for mailboxes, see
+// store.PrepareMailboxIDs.
+func xprepareMailboxIDs(ctx context.Context, tx *bstore.Tx, f store.Filter, rejectsMailbox string) (matchMailboxes bool, mailboxIDs map[int64]bool, mailboxPrefixes []string) {
+	matchMailboxes, mailboxIDs, mailboxPrefixes, err := store.PrepareMailboxIDs(tx, f, rejectsMailbox)
+	xcheckf(ctx, err, "preparing mailboxes for filter")
 	return
 }
 
 // xgatherMailboxIDs adds all mailboxes with a prefix matching any of
 // mailboxPrefixes to mailboxIDs, to expand filtering to children of mailboxes.
 func xgatherMailboxIDs(ctx context.Context, tx *bstore.Tx, mailboxIDs map[int64]bool, mailboxPrefixes []string) {
-	// Gather more mailboxes to filter on, based on mailboxPrefixes.
-	if len(mailboxPrefixes) == 0 {
-		return
-	}
-	err := bstore.QueryTx[store.Mailbox](tx).ForEach(func(mb store.Mailbox) error {
-		for _, p := range mailboxPrefixes {
-			if strings.HasPrefix(mb.Name, p) {
-				mailboxIDs[mb.ID] = true
-				break
-			}
-		}
-		return nil
-	})
+	err := store.GatherMailboxIDs(tx, mailboxIDs, mailboxPrefixes)
 	xcheckf(ctx, err, "gathering mailboxes")
 }
 
@@ -1128,8 +1048,9 @@ func (v view) inRange(m store.Message) bool {
 // also if within the range of sent messages based on sort order and the last seen
 // message). getmsg retrieves the message, which may be necessary depending on the
 // active filters. Used to determine if a store.Change with a new message should be
-// sent, and for the destination and anchor messages in view requests.
-func (v view) matches(log mlog.Log, acc *store.Account, checkRange bool, messageID int64, mailboxID int64, uid store.UID, flags store.Flags, keywords []string, getmsg func(int64, int64, store.UID) (store.Message, error)) (match bool, rerr error) {
+// sent, and for the destination and anchor messages in view requests. Message
+// contents are searched within transaction tx.
+func (v view) matches(log mlog.Log, acc *store.Account, tx *bstore.Tx, checkRange bool, messageID int64, mailboxID int64, uid store.UID, flags store.Flags, keywords []string, getmsg func(int64, int64, store.UID) (store.Message, error)) (match bool, rerr error) {
 	var m store.Message
 	ensureMessage := func() bool {
 		if m.ID == 0 && rerr == nil {
@@ -1147,50 +1068,17 @@ func (v view) matches(log mlog.Log, acc *store.Account, checkRange bool, message
 		return false, rerr
 	}
 	// note: anchorMessageID is not relevant for matching.
-	flagfilter := q.flagFilterFn()
-	if flagfilter != nil && !flagfilter(flags, keywords) {
-		return false, rerr
-	}
-
-	if q.Filter.Oldest != nil && (!ensureMessage() || m.Received.Before(*q.Filter.Oldest)) {
-		return false, rerr
-	}
-	if q.Filter.Newest != nil && (!ensureMessage() || !m.Received.Before(*q.Filter.Newest)) {
-		return false, rerr
-	}
-
-	if q.Filter.SizeMin > 0 && (!ensureMessage() || m.Size < q.Filter.SizeMin) {
-		return false, rerr
-	}
-	if q.Filter.SizeMax > 0 && (!ensureMessage() || m.Size > q.Filter.SizeMax) {
-		return false, rerr
-	}
-
-	state := msgState{acc: acc}
+	mf := store.NewMessageFilter(log, acc, tx, q.Filter, q.NotFilter)
 	defer func() {
-		if rerr == nil && state.err != nil {
-			rerr = state.err
+		if rerr == nil && mf.Err() != nil {
+			rerr = mf.Err()
 		}
-		state.clear()
+		mf.Close()
 	}()
-
-	attachmentFilter := q.attachmentFilterFn(log, acc, &state)
-	if attachmentFilter != nil && (!ensureMessage() || !attachmentFilter(m)) {
-		return false, rerr
-	}
-
-	envFilter := q.envFilterFn(log, &state)
-	if envFilter != nil && (!ensureMessage() || !envFilter(m)) {
+	if !mf.MatchFlags(flags, keywords) {
 		return false, rerr
 	}
-
-	headerFilter := q.headerFilterFn(log, &state)
-	if headerFilter != nil && (!ensureMessage() || !headerFilter(m)) {
-		return false, rerr
-	}
-
-	wordsFilter := q.wordsFilterFn(log, &state)
-	if wordsFilter != nil && (!ensureMessage() || !wordsFilter(m)) {
+	if mf.MessageNeeded() && (!ensureMessage() || !mf.MatchMessage(m)) {
 		return false, rerr
 	}
 
@@ -1328,7 +1216,7 @@ func queryMessages(ctx context.Context, log mlog.Log, acc *store.Account, tx *bs
 		} else if err != nil {
 			return false, err
 		} else {
-			return v.matches(log, acc, false, m.ID, m.MailboxID, m.UID, m.Flags, m.Keywords, func(int64, int64, store.UID) (store.Message, error) {
+			return v.matches(log, acc, tx, false, m.ID, m.MailboxID, m.UID, m.Flags, m.Keywords, func(int64, int64, store.UID) (store.Message, error) {
 				return m, nil
 			})
 		}
@@ -1394,19 +1282,21 @@ func queryMessages(ctx context.Context, log mlog.Log, acc *store.Account, tx *bs
 	}
 
 	// We may be added filters the the query below. The FilterFn signature does not
-	// implement reporting errors, or anything else, just a bool. So when making the
-	// filter functions, we give them a place to store parsed message state, and an
-	// error. We check the error during and after query execution.
+	// implement reporting errors, or anything else, just a bool. So the message
+	// filter keeps the error. We check the error during and after query execution.
 	state := msgState{acc: acc}
 	defer state.clear()
-
-	flagfilter := query.flagFilterFn()
-	if flagfilter != nil {
-		q.FilterFn(func(m store.Message) bool {
-			return flagfilter(m.Flags, m.Keywords)
-		})
+	mf := store.NewMessageFilter(log, acc, tx, query.Filter, query.NotFilter)
+	defer mf.Close()
+	if err := mf.UseWordIndex(); err != nil {
+		mrc <- msgResp{err: fmt.Errorf("using word index: %v", err)}
+		return
 	}
 
+	q.FilterFn(func(m store.Message) bool {
+		return mf.MatchFlags(m.Flags, m.Keywords)
+	})
+
 	if query.Filter.Oldest != nil {
 		q.FilterGreaterEqual("Received", *query.Filter.Oldest)
 	}
@@ -1421,24 +1311,8 @@ func queryMessages(ctx context.Context, log mlog.Log, acc *store.Account, tx *bs
 		q.FilterLessEqual("Size", query.Filter.SizeMax)
 	}
 
-	attachmentFilter := query.attachmentFilterFn(log, acc, &state)
-	if attachmentFilter != nil {
-		q.FilterFn(attachmentFilter)
-	}
-
-	envFilter := query.envFilterFn(log, &state)
-	if envFilter != nil {
-		q.FilterFn(envFilter)
-	}
-
-	headerFilter := query.headerFilterFn(log, &state)
-	if headerFilter != nil {
-		q.FilterFn(headerFilter)
-	}
-
-	wordsFilter := query.wordsFilterFn(log, &state)
-	if wordsFilter != nil {
-		q.FilterFn(wordsFilter)
+	if mf.MessageNeeded() {
+		q.FilterFn(mf.MatchMessage)
 	}
 
 	if query.OrderAsc {
@@ -1451,8 +1325,8 @@ func queryMessages(ctx context.Context, log mlog.Log, acc *store.Account, tx *bs
 	have := 0
 	err := q.ForEach(func(m store.Message) error {
 		// Check for an error in one of the filters, propagate it.
-		if state.err != nil {
-			return state.err
+		if err := mf.Err(); err != nil {
+			return err
 		}
 
 		if have >= page.Count && found || have > 10000 {
@@ -1536,8 +1410,8 @@ func queryMessages(ctx context.Context, log mlog.Log, acc *store.Account, tx *bs
 	})
 	// Check for an error in one of the filters again. Check in ForEach would not
 	// trigger if the last message has the error.
-	if err == nil && state.err != nil {
-		err = state.err
+	if err == nil && mf.Err() != nil {
+		err = mf.Err()
 	}
 	if err != nil {
 		mrc <- msgResp{err: fmt.Errorf("querying messages: %v", err)}
@@ -1577,7 +1451,7 @@ func gatherThread(log mlog.Log, tx *bstore.Tx, acc *store.Account, v view, m sto
 			if err != nil {
 				return fmt.Errorf("making messageitem for message %d, for thread %d: %v", tm.ID, m.ThreadID, err)
 			}
-			mi.MatchQuery, err = v.matches(log, acc, false, tm.ID, tm.MailboxID, tm.UID, tm.Flags, tm.Keywords, func(int64, int64, store.UID) (store.Message, error) {
+			mi.MatchQuery, err = v.matches(log, acc, tx, false, tm.ID, tm.MailboxID, tm.UID, tm.Flags, tm.Keywords, func(int64, int64, store.UID) (store.Message, error) {
 				return tm, nil
 			})
 			if err != nil {
@@ -1666,302 +1540,3 @@ func (ms *msgState) ensurePart(m store.Message, withMsgReader bool) bool {
 	}
 	return ms.part != nil
 }
-
-// flagFilterFn returns a function that applies the flag/keyword/"label"-related
-// filters for a query. A nil function is returned if there are no flags to filter
-// on.
-func (q Query) flagFilterFn() func(store.Flags, []string) bool {
-	labels := map[string]bool{}
-	for _, k := range q.Filter.Labels {
-		labels[k] = true
-	}
-	for _, k := range q.NotFilter.Labels {
-		labels[k] = false
-	}
-
-	if len(labels) == 0 {
-		return nil
-	}
-
-	var mask, flags store.Flags
-	systemflags := map[string][]*bool{
-		`\answered`:  {&mask.Answered, &flags.Answered},
-		`\flagged`:   {&mask.Flagged, &flags.Flagged},
-		`\deleted`:   {&mask.Deleted, &flags.Deleted},
-		`\seen`:      {&mask.Seen, &flags.Seen},
-		`\draft`:     {&mask.Draft, &flags.Draft},
-		`$junk`:      {&mask.Junk, &flags.Junk},
-		`$notjunk`:   {&mask.Notjunk, &flags.Notjunk},
-		`$forwarded`: {&mask.Forwarded, &flags.Forwarded},
-		`$phishing`:  {&mask.Phishing, &flags.Phishing},
-		`$mdnsent`:   {&mask.MDNSent, &flags.MDNSent},
-	}
-	keywords := map[string]bool{}
-	for k, v := range labels {
-		k = strings.ToLower(k)
-		if mf, ok := systemflags[k]; ok {
-			*mf[0] = true
-			*mf[1] = v
-		} else {
-			keywords[k] = v
-		}
-	}
-	return func(msgFlags store.Flags, msgKeywords []string) bool {
-		var f store.Flags
-		if f.Set(mask, msgFlags) != flags {
-			return false
-		}
-		for k, v := range keywords {
-			if slices.Contains(msgKeywords, k) != v {
-				return false
-			}
-		}
-		return true
-	}
-}
-
-// attachmentFilterFn returns a function that filters for the attachment-related
-// filter from the query. A nil function is returned if there are attachment
-// filters.
-func (q Query) attachmentFilterFn(log mlog.Log, acc *store.Account, state *msgState) func(m store.Message) bool {
-	if q.Filter.Attachments == AttachmentIndifferent && q.NotFilter.Attachments == AttachmentIndifferent {
-		return nil
-	}
-
-	return func(m store.Message) bool {
-		if !state.ensurePart(m, false) {
-			return false
-		}
-		types, err := attachmentTypes(log, m, state)
-		if err != nil {
-			state.err = err
-			return false
-		}
-		return (q.Filter.Attachments == AttachmentIndifferent || types[q.Filter.Attachments]) && (q.NotFilter.Attachments == AttachmentIndifferent || !types[q.NotFilter.Attachments])
-	}
-}
-
-var attachmentMimetypes = map[string]AttachmentType{
-	"application/pdf":                                AttachmentPDF,
-	"application/zip":                                AttachmentArchive,
-	"application/x-rar-compressed":                   AttachmentArchive,
-	"application/vnd.oasis.opendocument.spreadsheet": AttachmentSpreadsheet,
-	"application/vnd.ms-excel":                       AttachmentSpreadsheet,
-	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         AttachmentSpreadsheet,
-	"application/vnd.oasis.opendocument.text":                                   AttachmentDocument,
-	"application/vnd.oasis.opendocument.presentation":                           AttachmentPresentation,
-	"application/vnd.ms-powerpoint":                                             AttachmentPresentation,
-	"application/vnd.openxmlformats-officedocument.presentationml.presentation": AttachmentPresentation,
-}
-var attachmentExtensions = map[string]AttachmentType{
-	".pdf":     AttachmentPDF,
-	".zip":     AttachmentArchive,
-	".tar":     AttachmentArchive,
-	".tgz":     AttachmentArchive,
-	".tar.gz":  AttachmentArchive,
-	".tbz2":    AttachmentArchive,
-	".tar.bz2": AttachmentArchive,
-	".tar.lz":  AttachmentArchive,
-	".tlz":     AttachmentArchive,
-	".tar.xz":  AttachmentArchive,
-	".txz":     AttachmentArchive,
-	".tar.zst": AttachmentArchive,
-	".tar.lz4": AttachmentArchive,
-	".7z":      AttachmentArchive,
-	".rar":     AttachmentArchive,
-	".ods":     AttachmentSpreadsheet,
-	".xls":     AttachmentSpreadsheet,
-	".xlsx":    AttachmentSpreadsheet,
-	".odt":     AttachmentDocument,
-	".doc":     AttachmentDocument,
-	".docx":    AttachmentDocument,
-	".odp":     AttachmentPresentation,
-	".ppt":     AttachmentPresentation,
-	".pptx":    AttachmentPresentation,
-}
-
-func attachmentTypes(log mlog.Log, m store.Message, state *msgState) (map[AttachmentType]bool, error) {
-	types := map[AttachmentType]bool{}
-
-	pm, err := parsedMessage(log, m, state, false, false)
-	if err != nil {
-		return nil, fmt.Errorf("parsing message for attachments: %w", err)
-	}
-	for _, a := range pm.attachments {
-		if a.Part.MediaType == "IMAGE" {
-			types[AttachmentImage] = true
-			continue
-		}
-		mt := strings.ToLower(a.Part.MediaType + "/" + a.Part.MediaSubType)
-		if t, ok := attachmentMimetypes[mt]; ok {
-			types[t] = true
-		} else if ext := filepath.Ext(tryDecodeParam(log, a.Part.ContentTypeParams["name"])); ext != "" {
-			if t, ok := attachmentExtensions[strings.ToLower(ext)]; ok {
-				types[t] = true
-			} else {
-				continue
-			}
-		}
-	}
-
-	if len(types) == 0 {
-		types[AttachmentNone] = true
-	} else {
-		types[AttachmentAny] = true
-	}
-	return types, nil
-}
-
-// envFilterFn returns a filter function for the "envelope" headers ("envelope" as
-// used by IMAP, i.e. basic message headers from/to/subject, an unfortunate name
-// clash with SMTP envelope) for the query. A nil function is returned if no
-// filtering is needed.
-func (q Query) envFilterFn(log mlog.Log, state *msgState) func(m store.Message) bool {
-	if len(q.Filter.From) == 0 && len(q.Filter.To) == 0 && len(q.Filter.Subject) == 0 && len(q.NotFilter.From) == 0 && len(q.NotFilter.To) == 0 && len(q.NotFilter.Subject) == 0 {
-		return nil
-	}
-
-	lower := func(l []string) []string {
-		if len(l) == 0 {
-			return nil
-		}
-		r := make([]string, len(l))
-		for i, s := range l {
-			r[i] = strings.ToLower(s)
-		}
-		return r
-	}
-
-	filterSubject := lower(q.Filter.Subject)
-	notFilterSubject := lower(q.NotFilter.Subject)
-	filterFrom := lower(q.Filter.From)
-	notFilterFrom := lower(q.NotFilter.From)
-	filterTo := lower(q.Filter.To)
-	notFilterTo := lower(q.NotFilter.To)
-
-	return func(m store.Message) bool {
-		if !state.ensurePart(m, false) {
-			return false
-		}
-
-		var env message.Envelope
-		if state.part.Envelope != nil {
-			env = *state.part.Envelope
-		}
-
-		if len(filterSubject) > 0 || len(notFilterSubject) > 0 {
-			subject := strings.ToLower(env.Subject)
-			for _, s := range filterSubject {
-				if !strings.Contains(subject, s) {
-					return false
-				}
-			}
-			for _, s := range notFilterSubject {
-				if strings.Contains(subject, s) {
-					return false
-				}
-			}
-		}
-
-		contains := func(textLower []string, l []message.Address, all bool) bool {
-		next:
-			for _, s := range textLower {
-				for _, a := range l {
-					name := strings.ToLower(a.Name)
-					addr := strings.ToLower(fmt.Sprintf("<%s@%s>", a.User, a.Host))
-					if strings.Contains(name, s) || strings.Contains(addr, s) {
-						if !all {
-							return true
-						}
-						continue next
-					}
-				}
-				if all {
-					return false
-				}
-			}
-			return all
-		}
-
-		if len(filterFrom) > 0 && !contains(filterFrom, env.From, true) {
-			return false
-		}
-		if len(notFilterFrom) > 0 && contains(notFilterFrom, env.From, false) {
-			return false
-		}
-		if len(filterTo) > 0 || len(notFilterTo) > 0 {
-			to := append(append(append([]message.Address{}, env.To...), env.CC...), env.BCC...)
-			if len(filterTo) > 0 && !contains(filterTo, to, true) {
-				return false
-			}
-			if len(notFilterTo) > 0 && contains(notFilterTo, to, false) {
-				return false
-			}
-		}
-		return true
-	}
-}
-
-// headerFilterFn returns a function that filters for the header filters in the
-// query. A nil function is returned if there are no header filters.
-func (q Query) headerFilterFn(log mlog.Log, state *msgState) func(m store.Message) bool {
-	if len(q.Filter.Headers) == 0 {
-		return nil
-	}
-
-	lowerValues := make([]string, len(q.Filter.Headers))
-	for i, t := range q.Filter.Headers {
-		lowerValues[i] = strings.ToLower(t[1])
-	}
-
-	return func(m store.Message) bool {
-		if !state.ensurePart(m, true) {
-			return false
-		}
-		hdr, err := state.part.Header()
-		if err != nil {
-			state.err = fmt.Errorf("reading header for message %d: %w", m.ID, err)
-			return false
-		}
-
-	next:
-		for i, t := range q.Filter.Headers {
-			k := t[0]
-			v := lowerValues[i]
-			l := hdr.Values(k)
-			if v == "" && len(l) > 0 {
-				continue
-			}
-			for _, e := range l {
-				if strings.Contains(strings.ToLower(e), v) {
-					continue next
-				}
-			}
-			return false
-		}
-		return true
-	}
-}
-
-// wordFiltersFn returns a function that applies the word filters of the query. A
-// nil function is returned when query does not contain a word filter.
-func (q Query) wordsFilterFn(log mlog.Log, state *msgState) func(m store.Message) bool {
-	if len(q.Filter.Words) == 0 && len(q.NotFilter.Words) == 0 {
-		return nil
-	}
-
-	ws := store.PrepareWordSearch(q.Filter.Words, q.NotFilter.Words)
-
-	return func(m store.Message) bool {
-		if !state.ensurePart(m, true) {
-			return false
-		}
-
-		if ok, err := ws.MatchPart(log, state.part, true); err != nil {
-			state.err = fmt.Errorf("searching for words in message %d: %w", m.ID, err)
-			return false
-		} else {
-			return ok
-		}
-	}
-}
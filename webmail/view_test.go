@@ -52,7 +52,7 @@ func TestView(t *testing.T) {
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
 
-	api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234")
+	api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234", "")
 	var sessionCookie *http.Cookie
 	for _, c := range respRec.Result().Cookies() {
 		if c.Name == "webmailsession" {
@@ -203,7 +203,7 @@ func TestView(t *testing.T) {
 	// Connection with DestMessageID.
 	destMsgReq := Request{
 		Query: Query{
-			Filter: Filter{MailboxID: inbox.ID},
+			Filter: store.Filter{MailboxID: inbox.ID},
 		},
 		Page: Page{DestMessageID: inboxFlags.ID, Count: 10},
 	}
@@ -218,7 +218,7 @@ func TestView(t *testing.T) {
 	// Connection with missing DestMessageID, still fine.
 	badDestMsgReq := Request{
 		Query: Query{
-			Filter: Filter{MailboxID: inbox.ID},
+			Filter: store.Filter{MailboxID: inbox.ID},
 		},
 		Page: Page{DestMessageID: inboxFlags.ID + 999, Count: 10},
 	}
@@ -231,7 +231,7 @@ func TestView(t *testing.T) {
 	// Connection with missing unknown AnchorMessageID, resets view.
 	badAnchorMsgReq := Request{
 		Query: Query{
-			Filter: Filter{MailboxID: inbox.ID},
+			Filter: store.Filter{MailboxID: inbox.ID},
 		},
 		Page: Page{AnchorMessageID: inboxFlags.ID + 999, Count: 10},
 	}
@@ -247,7 +247,7 @@ func TestView(t *testing.T) {
 	// Connection that starts with a filter, without mailbox.
 	searchReq := Request{
 		Query: Query{
-			Filter: Filter{Labels: []string{`\seen`}},
+			Filter: store.Filter{Labels: []string{`\seen`}},
 		},
 		Page: Page{Count: 10},
 	}
@@ -260,13 +260,13 @@ func TestView(t *testing.T) {
 
 	// Paginate from previous last element. There is nothing new.
 	var viewID int64 = 1
-	api.Request(ctx, Request{ID: 1, SSEID: start.SSEID, ViewID: viewID, Query: Query{Filter: Filter{MailboxID: inbox.ID}}, Page: Page{Count: 10, AnchorMessageID: viewMsgs.MessageItems[len(viewMsgs.MessageItems)-1][0].Message.ID}})
+	api.Request(ctx, Request{ID: 1, SSEID: start.SSEID, ViewID: viewID, Query: Query{Filter: store.Filter{MailboxID: inbox.ID}}, Page: Page{Count: 10, AnchorMessageID: viewMsgs.MessageItems[len(viewMsgs.MessageItems)-1][0].Message.ID}})
 	evr.Get("viewMsgs", &viewMsgs)
 	tcompare(t, len(viewMsgs.MessageItems), 0)
 
 	// Request archive mailbox, empty.
 	viewID++
-	api.Request(ctx, Request{ID: 1, SSEID: start.SSEID, ViewID: viewID, Query: Query{Filter: Filter{MailboxID: archive.ID}}, Page: Page{Count: 10}})
+	api.Request(ctx, Request{ID: 1, SSEID: start.SSEID, ViewID: viewID, Query: Query{Filter: store.Filter{MailboxID: archive.ID}}, Page: Page{Count: 10}})
 	evr.Get("viewMsgs", &viewMsgs)
 	tcompare(t, len(viewMsgs.MessageItems), 0)
 	tcompare(t, viewMsgs.ViewEnd, true)
@@ -281,27 +281,27 @@ func TestView(t *testing.T) {
 
 	// Request with threading, should also include parent message from Trash mailbox (trashAlt).
 	viewID++
-	api.Request(ctx, Request{ID: 1, SSEID: start.SSEID, ViewID: viewID, Query: Query{Filter: Filter{MailboxID: inbox.ID}, Threading: "unread"}, Page: Page{Count: 10}})
+	api.Request(ctx, Request{ID: 1, SSEID: start.SSEID, ViewID: viewID, Query: Query{Filter: store.Filter{MailboxID: inbox.ID}, Threading: "unread"}, Page: Page{Count: 10}})
 	evr.Get("viewMsgs", &viewMsgs)
 	tcompare(t, len(viewMsgs.MessageItems), 3)
 	tcompare(t, threadlen(viewMsgs.MessageItems), 3+1)
 	tcompare(t, viewMsgs.ViewEnd, true)
 	// And likewise when querying Trash, should also include child message in Inbox (inboxAltReply).
 	viewID++
-	api.Request(ctx, Request{ID: 1, SSEID: start.SSEID, ViewID: viewID, Query: Query{Filter: Filter{MailboxID: trash.ID}, Threading: "on"}, Page: Page{Count: 10}})
+	api.Request(ctx, Request{ID: 1, SSEID: start.SSEID, ViewID: viewID, Query: Query{Filter: store.Filter{MailboxID: trash.ID}, Threading: "on"}, Page: Page{Count: 10}})
 	evr.Get("viewMsgs", &viewMsgs)
 	tcompare(t, len(viewMsgs.MessageItems), 3)
 	tcompare(t, threadlen(viewMsgs.MessageItems), 3+1)
 	tcompare(t, viewMsgs.ViewEnd, true)
 	// Without threading, the inbox has just 3 messages.
 	viewID++
-	api.Request(ctx, Request{ID: 1, SSEID: start.SSEID, ViewID: viewID, Query: Query{Filter: Filter{MailboxID: inbox.ID}, Threading: "off"}, Page: Page{Count: 10}})
+	api.Request(ctx, Request{ID: 1, SSEID: start.SSEID, ViewID: viewID, Query: Query{Filter: store.Filter{MailboxID: inbox.ID}, Threading: "off"}, Page: Page{Count: 10}})
 	evr.Get("viewMsgs", &viewMsgs)
 	tcompare(t, len(viewMsgs.MessageItems), 3)
 	tcompare(t, threadlen(viewMsgs.MessageItems), 3)
 	tcompare(t, viewMsgs.ViewEnd, true)
 
-	testFilter := func(orderAsc bool, f Filter, nf NotFilter, expIDs []int64) {
+	testFilter := func(orderAsc bool, f store.Filter, nf store.NotFilter, expIDs []int64) {
 		t.Helper()
 		viewID++
 		api.Request(ctx, Request{ID: 1, SSEID: start.SSEID, ViewID: viewID, Query: Query{OrderAsc: orderAsc, Filter: f, NotFilter: nf}, Page: Page{Count: 10}})
@@ -315,33 +315,33 @@ func TestView(t *testing.T) {
 	}
 
 	// Test filtering.
-	var znf NotFilter
-	testFilter(false, Filter{MailboxID: lists.ID, MailboxChildrenIncluded: true}, znf, []int64{listsGoNutsMinimal.ID, listsMinimal.ID})              // Mailbox and sub mailbox.
-	testFilter(true, Filter{MailboxID: lists.ID, MailboxChildrenIncluded: true}, znf, []int64{listsMinimal.ID, listsGoNutsMinimal.ID})               // Oldest first first.
-	testFilter(false, Filter{MailboxID: -1}, znf, []int64{inboxAltReply.ID, listsGoNutsMinimal.ID, listsMinimal.ID, inboxFlags.ID, inboxMinimal.ID}) // All except trash/junk/rejects.
-	testFilter(false, Filter{Labels: []string{`\seen`}}, znf, []int64{inboxFlags.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID}, NotFilter{Labels: []string{`\seen`}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
-	testFilter(false, Filter{Labels: []string{`testlabel`}}, znf, []int64{inboxFlags.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID}, NotFilter{Labels: []string{`testlabel`}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID, Oldest: &inboxFlags.m.Received}, znf, []int64{inboxAltReply.ID, inboxFlags.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID, Newest: &inboxMinimal.m.Received}, znf, []int64{inboxMinimal.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID, SizeMin: inboxFlags.m.Size}, znf, []int64{inboxFlags.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID, SizeMax: inboxMinimal.m.Size}, znf, []int64{inboxMinimal.ID})
-	testFilter(false, Filter{From: []string{"mjl+altrel@mox.example"}}, znf, []int64{inboxFlags.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID}, NotFilter{From: []string{"mjl+altrel@mox.example"}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
-	testFilter(false, Filter{To: []string{"mox+altrel@other.example"}}, znf, []int64{inboxFlags.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID}, NotFilter{To: []string{"mox+altrel@other.example"}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
-	testFilter(false, Filter{From: []string{"mjl+altrel@mox.example", "bogus"}}, znf, []int64{})
-	testFilter(false, Filter{To: []string{"mox+altrel@other.example", "bogus"}}, znf, []int64{})
-	testFilter(false, Filter{Subject: []string{"test", "alt", "rel"}}, znf, []int64{inboxFlags.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID}, NotFilter{Subject: []string{"alt"}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID, Words: []string{"the text body", "body", "the "}}, znf, []int64{inboxFlags.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID}, NotFilter{Words: []string{"the text body"}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
-	testFilter(false, Filter{Headers: [][2]string{{"X-Special", ""}}}, znf, []int64{inboxFlags.ID})
-	testFilter(false, Filter{Headers: [][2]string{{"X-Special", "testing"}}}, znf, []int64{inboxFlags.ID})
-	testFilter(false, Filter{Headers: [][2]string{{"X-Special", "other"}}}, znf, []int64{})
-	testFilter(false, Filter{Attachments: AttachmentImage}, znf, []int64{inboxFlags.ID})
-	testFilter(false, Filter{MailboxID: inbox.ID}, NotFilter{Attachments: AttachmentImage}, []int64{inboxAltReply.ID, inboxMinimal.ID})
+	var znf store.NotFilter
+	testFilter(false, store.Filter{MailboxID: lists.ID, MailboxChildrenIncluded: true}, znf, []int64{listsGoNutsMinimal.ID, listsMinimal.ID})              // Mailbox and sub mailbox.
+	testFilter(true, store.Filter{MailboxID: lists.ID, MailboxChildrenIncluded: true}, znf, []int64{listsMinimal.ID, listsGoNutsMinimal.ID})               // Oldest first first.
+	testFilter(false, store.Filter{MailboxID: -1}, znf, []int64{inboxAltReply.ID, listsGoNutsMinimal.ID, listsMinimal.ID, inboxFlags.ID, inboxMinimal.ID}) // All except trash/junk/rejects.
+	testFilter(false, store.Filter{Labels: []string{`\seen`}}, znf, []int64{inboxFlags.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID}, store.NotFilter{Labels: []string{`\seen`}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
+	testFilter(false, store.Filter{Labels: []string{`testlabel`}}, znf, []int64{inboxFlags.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID}, store.NotFilter{Labels: []string{`testlabel`}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID, Oldest: &inboxFlags.m.Received}, znf, []int64{inboxAltReply.ID, inboxFlags.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID, Newest: &inboxMinimal.m.Received}, znf, []int64{inboxMinimal.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID, SizeMin: inboxFlags.m.Size}, znf, []int64{inboxFlags.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID, SizeMax: inboxMinimal.m.Size}, znf, []int64{inboxMinimal.ID})
+	testFilter(false, store.Filter{From: []string{"mjl+altrel@mox.example"}}, znf, []int64{inboxFlags.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID}, store.NotFilter{From: []string{"mjl+altrel@mox.example"}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
+	testFilter(false, store.Filter{To: []string{"mox+altrel@other.example"}}, znf, []int64{inboxFlags.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID}, store.NotFilter{To: []string{"mox+altrel@other.example"}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
+	testFilter(false, store.Filter{From: []string{"mjl+altrel@mox.example", "bogus"}}, znf, []int64{})
+	testFilter(false, store.Filter{To: []string{"mox+altrel@other.example", "bogus"}}, znf, []int64{})
+	testFilter(false, store.Filter{Subject: []string{"test", "alt", "rel"}}, znf, []int64{inboxFlags.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID}, store.NotFilter{Subject: []string{"alt"}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID, Words: []string{"the text body", "body", "the "}}, znf, []int64{inboxFlags.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID}, store.NotFilter{Words: []string{"the text body"}}, []int64{inboxAltReply.ID, inboxMinimal.ID})
+	testFilter(false, store.Filter{Headers: [][2]string{{"X-Special", ""}}}, znf, []int64{inboxFlags.ID})
+	testFilter(false, store.Filter{Headers: [][2]string{{"X-Special", "testing"}}}, znf, []int64{inboxFlags.ID})
+	testFilter(false, store.Filter{Headers: [][2]string{{"X-Special", "other"}}}, znf, []int64{})
+	testFilter(false, store.Filter{Attachments: store.AttachmentImage}, znf, []int64{inboxFlags.ID})
+	testFilter(false, store.Filter{MailboxID: inbox.ID}, store.NotFilter{Attachments: store.AttachmentImage}, []int64{inboxAltReply.ID, inboxMinimal.ID})
 
 	// Test changes.
 	getChanges := func(changes ...any) {
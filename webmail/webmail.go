@@ -440,13 +440,13 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 		names := map[string]bool{}
 		for _, a := range mi.Attachments {
 			ap := a.Part
-			name := tryDecodeParam(log, ap.ContentTypeParams["name"])
+			name := store.TryDecodeParam(log, ap.ContentTypeParams["name"])
 			if name == "" {
 				// We don't check errors, this is all best-effort.
 				h, _ := ap.Header()
 				disposition := h.Get("Content-Disposition")
 				_, params, _ := mime.ParseMediaType(disposition)
-				name = tryDecodeParam(log, params["filename"])
+				name = store.TryDecodeParam(log, params["filename"])
 			}
 			if name != "" {
 				name = filepath.Base(name)
@@ -734,13 +734,13 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 		h.Set("Content-Type", ct)
 		h.Set("Cache-Control", "no-store, max-age=0")
 		if t[1] == "download" {
-			name := tryDecodeParam(log, ap.ContentTypeParams["name"])
+			name := store.TryDecodeParam(log, ap.ContentTypeParams["name"])
 			if name == "" {
 				// We don't check errors, this is all best-effort.
 				h, _ := ap.Header()
 				disposition := h.Get("Content-Disposition")
 				_, params, _ := mime.ParseMediaType(disposition)
-				name = tryDecodeParam(log, params["filename"])
+				name = store.TryDecodeParam(log, params["filename"])
 			}
 			if name == "" {
 				name = "attachment.bin"
@@ -271,7 +271,7 @@ var api;
 		// lookups.
 		SecurityResult["SecurityResultUnknown"] = "unknown";
 	})(SecurityResult = api.SecurityResult || (api.SecurityResult = {}));
-	api.structTypes = { "Address": true, "Attachment": true, "ChangeMailboxAdd": true, "ChangeMailboxCounts": true, "ChangeMailboxKeywords": true, "ChangeMailboxRemove": true, "ChangeMailboxRename": true, "ChangeMailboxSpecialUse": true, "ChangeMsgAdd": true, "ChangeMsgFlags": true, "ChangeMsgRemove": true, "ChangeMsgThread": true, "Domain": true, "DomainAddressConfig": true, "Envelope": true, "EventStart": true, "EventViewChanges": true, "EventViewErr": true, "EventViewMsgs": true, "EventViewReset": true, "File": true, "Filter": true, "Flags": true, "ForwardAttachments": true, "Mailbox": true, "Message": true, "MessageAddress": true, "MessageEnvelope": true, "MessageItem": true, "NotFilter": true, "Page": true, "ParsedMessage": true, "Part": true, "Query": true, "RecipientSecurity": true, "Request": true, "SpecialUse": true, "SubmitMessage": true };
+	api.structTypes = { "Address": true, "Attachment": true, "ChangeMailboxAdd": true, "ChangeMailboxCounts": true, "ChangeMailboxKeywords": true, "ChangeMailboxRemove": true, "ChangeMailboxRename": true, "ChangeMailboxSpecialUse": true, "ChangeMsgAdd": true, "ChangeMsgFlags": true, "ChangeMsgRemove": true, "ChangeMsgThread": true, "DeletedMessage": true, "Domain": true, "DomainAddressConfig": true, "Envelope": true, "EventStart": true, "EventViewChanges": true, "EventViewErr": true, "EventViewMsgs": true, "EventViewReset": true, "File": true, "Filter": true, "Flags": true, "ForwardAttachments": true, "Mailbox": true, "Message": true, "MessageAddress": true, "MessageEnvelope": true, "MessageItem": true, "NotFilter": true, "Page": true, "ParsedMessage": true, "Part": true, "Query": true, "RecipientSecurity": true, "Request": true, "SavedSearch": true, "SharedMailbox": true, "SpecialUse": true, "SubmitMessage": true };
 	api.stringsTypes = { "AttachmentType": true, "CSRFToken": true, "Localpart": true, "SecurityResult": true, "ThreadMode": true };
 	api.intsTypes = { "ModSeq": true, "UID": true, "Validation": true };
 	api.types = {
@@ -289,17 +289,20 @@ var api;
 		"SubmitMessage": { "Name": "SubmitMessage", "Docs": "", "Fields": [{ "Name": "From", "Docs": "", "Typewords": ["string"] }, { "Name": "To", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Cc", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Bcc", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "TextBody", "Docs": "", "Typewords": ["string"] }, { "Name": "Attachments", "Docs": "", "Typewords": ["[]", "File"] }, { "Name": "ForwardAttachments", "Docs": "", "Typewords": ["ForwardAttachments"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "ResponseMessageID", "Docs": "", "Typewords": ["int64"] }, { "Name": "ReplyTo", "Docs": "", "Typewords": ["string"] }, { "Name": "UserAgent", "Docs": "", "Typewords": ["string"] }, { "Name": "RequireTLS", "Docs": "", "Typewords": ["nullable", "bool"] }] },
 		"File": { "Name": "File", "Docs": "", "Fields": [{ "Name": "Filename", "Docs": "", "Typewords": ["string"] }, { "Name": "DataURI", "Docs": "", "Typewords": ["string"] }] },
 		"ForwardAttachments": { "Name": "ForwardAttachments", "Docs": "", "Fields": [{ "Name": "MessageID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Paths", "Docs": "", "Typewords": ["[]", "[]", "int32"] }] },
+		"DeletedMessage": { "Name": "DeletedMessage", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Expunged", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "MailboxID", "Docs": "", "Typewords": ["int64"] }, { "Name": "MailboxName", "Docs": "", "Typewords": ["string"] }, { "Name": "Received", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "MsgPrefix", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Seen", "Docs": "", "Typewords": ["bool"] }, { "Name": "Answered", "Docs": "", "Typewords": ["bool"] }, { "Name": "Flagged", "Docs": "", "Typewords": ["bool"] }, { "Name": "Forwarded", "Docs": "", "Typewords": ["bool"] }, { "Name": "Junk", "Docs": "", "Typewords": ["bool"] }, { "Name": "Notjunk", "Docs": "", "Typewords": ["bool"] }, { "Name": "Deleted", "Docs": "", "Typewords": ["bool"] }, { "Name": "Draft", "Docs": "", "Typewords": ["bool"] }, { "Name": "Phishing", "Docs": "", "Typewords": ["bool"] }, { "Name": "MDNSent", "Docs": "", "Typewords": ["bool"] }, { "Name": "Keywords", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "FileCompressed", "Docs": "", "Typewords": ["bool"] }, { "Name": "FileEncrypted", "Docs": "", "Typewords": ["bool"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "From", "Docs": "", "Typewords": ["string"] }] },
 		"Mailbox": { "Name": "Mailbox", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "UIDValidity", "Docs": "", "Typewords": ["uint32"] }, { "Name": "UIDNext", "Docs": "", "Typewords": ["UID"] }, { "Name": "Archive", "Docs": "", "Typewords": ["bool"] }, { "Name": "Draft", "Docs": "", "Typewords": ["bool"] }, { "Name": "Junk", "Docs": "", "Typewords": ["bool"] }, { "Name": "Sent", "Docs": "", "Typewords": ["bool"] }, { "Name": "Trash", "Docs": "", "Typewords": ["bool"] }, { "Name": "Keywords", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "HaveCounts", "Docs": "", "Typewords": ["bool"] }, { "Name": "Total", "Docs": "", "Typewords": ["int64"] }, { "Name": "Deleted", "Docs": "", "Typewords": ["int64"] }, { "Name": "Unread", "Docs": "", "Typewords": ["int64"] }, { "Name": "Unseen", "Docs": "", "Typewords": ["int64"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }] },
 		"RecipientSecurity": { "Name": "RecipientSecurity", "Docs": "", "Fields": [{ "Name": "STARTTLS", "Docs": "", "Typewords": ["SecurityResult"] }, { "Name": "MTASTS", "Docs": "", "Typewords": ["SecurityResult"] }, { "Name": "DNSSEC", "Docs": "", "Typewords": ["SecurityResult"] }, { "Name": "DANE", "Docs": "", "Typewords": ["SecurityResult"] }, { "Name": "RequireTLS", "Docs": "", "Typewords": ["SecurityResult"] }] },
+		"SharedMailbox": { "Name": "SharedMailbox", "Docs": "", "Fields": [{ "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "MailboxID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "Total", "Docs": "", "Typewords": ["int64"] }, { "Name": "Unread", "Docs": "", "Typewords": ["int64"] }] },
+		"MessageItem": { "Name": "MessageItem", "Docs": "", "Fields": [{ "Name": "Message", "Docs": "", "Typewords": ["Message"] }, { "Name": "Envelope", "Docs": "", "Typewords": ["MessageEnvelope"] }, { "Name": "Attachments", "Docs": "", "Typewords": ["[]", "Attachment"] }, { "Name": "IsSigned", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsEncrypted", "Docs": "", "Typewords": ["bool"] }, { "Name": "FirstLine", "Docs": "", "Typewords": ["string"] }, { "Name": "MatchQuery", "Docs": "", "Typewords": ["bool"] }] },
+		"Message": { "Name": "Message", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "UID", "Docs": "", "Typewords": ["UID"] }, { "Name": "MailboxID", "Docs": "", "Typewords": ["int64"] }, { "Name": "ModSeq", "Docs": "", "Typewords": ["ModSeq"] }, { "Name": "CreateSeq", "Docs": "", "Typewords": ["ModSeq"] }, { "Name": "Expunged", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsReject", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "MailboxOrigID", "Docs": "", "Typewords": ["int64"] }, { "Name": "MailboxDestinedID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Received", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "SaveDate", "Docs": "", "Typewords": ["nullable", "timestamp"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIPMasked1", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIPMasked2", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIPMasked3", "Docs": "", "Typewords": ["string"] }, { "Name": "EHLODomain", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFromLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "MailFromDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "RcptToLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "RcptToDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFromLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "MsgFromDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFromOrgDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "EHLOValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "MailFromValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "MsgFromValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "EHLOValidation", "Docs": "", "Typewords": ["Validation"] }, { "Name": "MailFromValidation", "Docs": "", "Typewords": ["Validation"] }, { "Name": "MsgFromValidation", "Docs": "", "Typewords": ["Validation"] }, { "Name": "DKIMDomains", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "OrigEHLODomain", "Docs": "", "Typewords": ["string"] }, { "Name": "OrigDKIMDomains", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "SubjectBase", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageHash", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "ThreadID", "Docs": "", "Typewords": ["int64"] }, { "Name": "ThreadParentIDs", "Docs": "", "Typewords": ["[]", "int64"] }, { "Name": "ThreadMissingLink", "Docs": "", "Typewords": ["bool"] }, { "Name": "ThreadMuted", "Docs": "", "Typewords": ["bool"] }, { "Name": "ThreadCollapsed", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsMailingList", "Docs": "", "Typewords": ["bool"] }, { "Name": "ReceivedTLSVersion", "Docs": "", "Typewords": ["uint16"] }, { "Name": "ReceivedTLSCipherSuite", "Docs": "", "Typewords": ["uint16"] }, { "Name": "ReceivedRequireTLS", "Docs": "", "Typewords": ["bool"] }, { "Name": "Seen", "Docs": "", "Typewords": ["bool"] }, { "Name": "Answered", "Docs": "", "Typewords": ["bool"] }, { "Name": "Flagged", "Docs": "", "Typewords": ["bool"] }, { "Name": "Forwarded", "Docs": "", "Typewords": ["bool"] }, { "Name": "Junk", "Docs": "", "Typewords": ["bool"] }, { "Name": "Notjunk", "Docs": "", "Typewords": ["bool"] }, { "Name": "Deleted", "Docs": "", "Typewords": ["bool"] }, { "Name": "Draft", "Docs": "", "Typewords": ["bool"] }, { "Name": "Phishing", "Docs": "", "Typewords": ["bool"] }, { "Name": "MDNSent", "Docs": "", "Typewords": ["bool"] }, { "Name": "Keywords", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "TrainedJunk", "Docs": "", "Typewords": ["nullable", "bool"] }, { "Name": "MsgPrefix", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "ParsedBuf", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Preview", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "FileCompressed", "Docs": "", "Typewords": ["bool"] }, { "Name": "FileEncrypted", "Docs": "", "Typewords": ["bool"] }] },
+		"MessageEnvelope": { "Name": "MessageEnvelope", "Docs": "", "Fields": [{ "Name": "Date", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "From", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "Sender", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "ReplyTo", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "To", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "CC", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "BCC", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "InReplyTo", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }] },
+		"Attachment": { "Name": "Attachment", "Docs": "", "Fields": [{ "Name": "Path", "Docs": "", "Typewords": ["[]", "int32"] }, { "Name": "Filename", "Docs": "", "Typewords": ["string"] }, { "Name": "Part", "Docs": "", "Typewords": ["Part"] }] },
+		"SavedSearch": { "Name": "SavedSearch", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "Query", "Docs": "", "Typewords": ["string"] }, { "Name": "Filter", "Docs": "", "Typewords": ["Filter"] }, { "Name": "NotFilter", "Docs": "", "Typewords": ["NotFilter"] }, { "Name": "MaxAgeDays", "Docs": "", "Typewords": ["int32"] }, { "Name": "All", "Docs": "", "Typewords": ["bool"] }, { "Name": "UIDValidity", "Docs": "", "Typewords": ["uint32"] }] },
 		"EventStart": { "Name": "EventStart", "Docs": "", "Fields": [{ "Name": "SSEID", "Docs": "", "Typewords": ["int64"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["MessageAddress"] }, { "Name": "Addresses", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "DomainAddressConfigs", "Docs": "", "Typewords": ["{}", "DomainAddressConfig"] }, { "Name": "MailboxName", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailboxes", "Docs": "", "Typewords": ["[]", "Mailbox"] }, { "Name": "RejectsMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Version", "Docs": "", "Typewords": ["string"] }] },
 		"DomainAddressConfig": { "Name": "DomainAddressConfig", "Docs": "", "Fields": [{ "Name": "LocalpartCatchallSeparator", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalpartCaseSensitive", "Docs": "", "Typewords": ["bool"] }] },
 		"EventViewErr": { "Name": "EventViewErr", "Docs": "", "Fields": [{ "Name": "ViewID", "Docs": "", "Typewords": ["int64"] }, { "Name": "RequestID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Err", "Docs": "", "Typewords": ["string"] }] },
 		"EventViewReset": { "Name": "EventViewReset", "Docs": "", "Fields": [{ "Name": "ViewID", "Docs": "", "Typewords": ["int64"] }, { "Name": "RequestID", "Docs": "", "Typewords": ["int64"] }] },
 		"EventViewMsgs": { "Name": "EventViewMsgs", "Docs": "", "Fields": [{ "Name": "ViewID", "Docs": "", "Typewords": ["int64"] }, { "Name": "RequestID", "Docs": "", "Typewords": ["int64"] }, { "Name": "MessageItems", "Docs": "", "Typewords": ["[]", "[]", "MessageItem"] }, { "Name": "ParsedMessage", "Docs": "", "Typewords": ["nullable", "ParsedMessage"] }, { "Name": "ViewEnd", "Docs": "", "Typewords": ["bool"] }] },
-		"MessageItem": { "Name": "MessageItem", "Docs": "", "Fields": [{ "Name": "Message", "Docs": "", "Typewords": ["Message"] }, { "Name": "Envelope", "Docs": "", "Typewords": ["MessageEnvelope"] }, { "Name": "Attachments", "Docs": "", "Typewords": ["[]", "Attachment"] }, { "Name": "IsSigned", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsEncrypted", "Docs": "", "Typewords": ["bool"] }, { "Name": "FirstLine", "Docs": "", "Typewords": ["string"] }, { "Name": "MatchQuery", "Docs": "", "Typewords": ["bool"] }] },
-		"Message": { "Name": "Message", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "UID", "Docs": "", "Typewords": ["UID"] }, { "Name": "MailboxID", "Docs": "", "Typewords": ["int64"] }, { "Name": "ModSeq", "Docs": "", "Typewords": ["ModSeq"] }, { "Name": "CreateSeq", "Docs": "", "Typewords": ["ModSeq"] }, { "Name": "Expunged", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsReject", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "MailboxOrigID", "Docs": "", "Typewords": ["int64"] }, { "Name": "MailboxDestinedID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Received", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIPMasked1", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIPMasked2", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIPMasked3", "Docs": "", "Typewords": ["string"] }, { "Name": "EHLODomain", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFromLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "MailFromDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "RcptToLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "RcptToDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFromLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "MsgFromDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFromOrgDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "EHLOValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "MailFromValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "MsgFromValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "EHLOValidation", "Docs": "", "Typewords": ["Validation"] }, { "Name": "MailFromValidation", "Docs": "", "Typewords": ["Validation"] }, { "Name": "MsgFromValidation", "Docs": "", "Typewords": ["Validation"] }, { "Name": "DKIMDomains", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "OrigEHLODomain", "Docs": "", "Typewords": ["string"] }, { "Name": "OrigDKIMDomains", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "SubjectBase", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageHash", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "ThreadID", "Docs": "", "Typewords": ["int64"] }, { "Name": "ThreadParentIDs", "Docs": "", "Typewords": ["[]", "int64"] }, { "Name": "ThreadMissingLink", "Docs": "", "Typewords": ["bool"] }, { "Name": "ThreadMuted", "Docs": "", "Typewords": ["bool"] }, { "Name": "ThreadCollapsed", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsMailingList", "Docs": "", "Typewords": ["bool"] }, { "Name": "ReceivedTLSVersion", "Docs": "", "Typewords": ["uint16"] }, { "Name": "ReceivedTLSCipherSuite", "Docs": "", "Typewords": ["uint16"] }, { "Name": "ReceivedRequireTLS", "Docs": "", "Typewords": ["bool"] }, { "Name": "Seen", "Docs": "", "Typewords": ["bool"] }, { "Name": "Answered", "Docs": "", "Typewords": ["bool"] }, { "Name": "Flagged", "Docs": "", "Typewords": ["bool"] }, { "Name": "Forwarded", "Docs": "", "Typewords": ["bool"] }, { "Name": "Junk", "Docs": "", "Typewords": ["bool"] }, { "Name": "Notjunk", "Docs": "", "Typewords": ["bool"] }, { "Name": "Deleted", "Docs": "", "Typewords": ["bool"] }, { "Name": "Draft", "Docs": "", "Typewords": ["bool"] }, { "Name": "Phishing", "Docs": "", "Typewords": ["bool"] }, { "Name": "MDNSent", "Docs": "", "Typewords": ["bool"] }, { "Name": "Keywords", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "TrainedJunk", "Docs": "", "Typewords": ["nullable", "bool"] }, { "Name": "MsgPrefix", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "ParsedBuf", "Docs": "", "Typewords": ["nullable", "string"] }] },
-		"MessageEnvelope": { "Name": "MessageEnvelope", "Docs": "", "Fields": [{ "Name": "Date", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "From", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "Sender", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "ReplyTo", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "To", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "CC", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "BCC", "Docs": "", "Typewords": ["[]", "MessageAddress"] }, { "Name": "InReplyTo", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }] },
-		"Attachment": { "Name": "Attachment", "Docs": "", "Fields": [{ "Name": "Path", "Docs": "", "Typewords": ["[]", "int32"] }, { "Name": "Filename", "Docs": "", "Typewords": ["string"] }, { "Name": "Part", "Docs": "", "Typewords": ["Part"] }] },
 		"EventViewChanges": { "Name": "EventViewChanges", "Docs": "", "Fields": [{ "Name": "ViewID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Changes", "Docs": "", "Typewords": ["[]", "[]", "any"] }] },
 		"ChangeMsgAdd": { "Name": "ChangeMsgAdd", "Docs": "", "Fields": [{ "Name": "MailboxID", "Docs": "", "Typewords": ["int64"] }, { "Name": "UID", "Docs": "", "Typewords": ["UID"] }, { "Name": "ModSeq", "Docs": "", "Typewords": ["ModSeq"] }, { "Name": "Flags", "Docs": "", "Typewords": ["Flags"] }, { "Name": "Keywords", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MessageItems", "Docs": "", "Typewords": ["[]", "MessageItem"] }] },
 		"Flags": { "Name": "Flags", "Docs": "", "Fields": [{ "Name": "Seen", "Docs": "", "Typewords": ["bool"] }, { "Name": "Answered", "Docs": "", "Typewords": ["bool"] }, { "Name": "Flagged", "Docs": "", "Typewords": ["bool"] }, { "Name": "Forwarded", "Docs": "", "Typewords": ["bool"] }, { "Name": "Junk", "Docs": "", "Typewords": ["bool"] }, { "Name": "Notjunk", "Docs": "", "Typewords": ["bool"] }, { "Name": "Deleted", "Docs": "", "Typewords": ["bool"] }, { "Name": "Draft", "Docs": "", "Typewords": ["bool"] }, { "Name": "Phishing", "Docs": "", "Typewords": ["bool"] }, { "Name": "MDNSent", "Docs": "", "Typewords": ["bool"] }] },
@@ -337,17 +340,20 @@ var api;
 		SubmitMessage: (v) => api.parse("SubmitMessage", v),
 		File: (v) => api.parse("File", v),
 		ForwardAttachments: (v) => api.parse("ForwardAttachments", v),
+		DeletedMessage: (v) => api.parse("DeletedMessage", v),
 		Mailbox: (v) => api.parse("Mailbox", v),
 		RecipientSecurity: (v) => api.parse("RecipientSecurity", v),
+		SharedMailbox: (v) => api.parse("SharedMailbox", v),
+		MessageItem: (v) => api.parse("MessageItem", v),
+		Message: (v) => api.parse("Message", v),
+		MessageEnvelope: (v) => api.parse("MessageEnvelope", v),
+		Attachment: (v) => api.parse("Attachment", v),
+		SavedSearch: (v) => api.parse("SavedSearch", v),
 		EventStart: (v) => api.parse("EventStart", v),
 		DomainAddressConfig: (v) => api.parse("DomainAddressConfig", v),
 		EventViewErr: (v) => api.parse("EventViewErr", v),
 		EventViewReset: (v) => api.parse("EventViewReset", v),
 		EventViewMsgs: (v) => api.parse("EventViewMsgs", v),
-		MessageItem: (v) => api.parse("MessageItem", v),
-		Message: (v) => api.parse("Message", v),
-		MessageEnvelope: (v) => api.parse("MessageEnvelope", v),
-		Attachment: (v) => api.parse("Attachment", v),
 		EventViewChanges: (v) => api.parse("EventViewChanges", v),
 		ChangeMsgAdd: (v) => api.parse("ChangeMsgAdd", v),
 		Flags: (v) => api.parse("Flags", v),
@@ -402,12 +408,15 @@ var api;
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Login returns a session token for the credentials, or fails with error code
-		// "user:badLogin". Call LoginPrep to get a loginToken.
-		async Login(loginToken, username, password) {
+		// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+		// authentication is enabled, totpCode must be a code from the authenticator app
+		// or a recovery code, the call fails with error code "user:totpRequired" if it is
+		// empty.
+		async Login(loginToken, username, password, totpCode) {
 			const fn = "Login";
-			const paramTypes = [["string"], ["string"], ["string"]];
+			const paramTypes = [["string"], ["string"], ["string"], ["string"]];
 			const returnTypes = [["CSRFToken"]];
-			const params = [loginToken, username, password];
+			const params = [loginToken, username, password, totpCode];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Logout invalidates the session token.
@@ -479,6 +488,26 @@ var api;
 			const params = [messageIDs];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// DeletedList returns the messages that were permanently deleted, e.g. by
+		// emptying the Trash mailbox, but can still be recovered, most recently deleted
+		// first. Messages are only kept if the account is configured with a
+		// KeepDeletedPeriod.
+		async DeletedList() {
+			const fn = "DeletedList";
+			const paramTypes = [];
+			const returnTypes = [["[]", "DeletedMessage"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DeletedRecover recovers deleted messages, adding them as new messages to the
+		// mailbox they were deleted from, or Inbox if that mailbox no longer exists.
+		async DeletedRecover(messageIDs) {
+			const fn = "DeletedRecover";
+			const paramTypes = [["[]", "int64"]];
+			const returnTypes = [];
+			const params = [messageIDs];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// FlagsAdd adds flags, either system flags like \Seen or custom keywords. The
 		// flags should be lower-case, but will be converted and verified.
 		async FlagsAdd(messageIDs, flaglist) {
@@ -576,6 +605,60 @@ var api;
 			const params = [messageAddressee];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// SharedMailboxes returns the mailboxes of other accounts that have been shared
+		// with the account, with rights to see and read the messages.
+		async SharedMailboxes() {
+			const fn = "SharedMailboxes";
+			const paramTypes = [];
+			const returnTypes = [["[]", "SharedMailbox"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SharedMessages returns messages of a mailbox shared by another account, most
+		// recently received first, starting at offset. At most 100 messages are returned.
+		// Shared mailboxes are read-only in the webmail, messages are not marked as read.
+		async SharedMessages(accountName, mailboxID, offset) {
+			const fn = "SharedMessages";
+			const paramTypes = [["string"], ["int64"], ["int32"]];
+			const returnTypes = [["[]", "MessageItem"]];
+			const params = [accountName, mailboxID, offset];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SharedParsedMessage is like ParsedMessage, but for a message in a mailbox
+		// shared by another account.
+		async SharedParsedMessage(accountName, mailboxID, msgID) {
+			const fn = "SharedParsedMessage";
+			const paramTypes = [["string"], ["int64"], ["int64"]];
+			const returnTypes = [["ParsedMessage"]];
+			const params = [accountName, mailboxID, msgID];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SavedSearches returns the saved searches of the account, sorted by name. Saved
+		// searches are also available as virtual mailboxes over IMAP.
+		async SavedSearches() {
+			const fn = "SavedSearches";
+			const paramTypes = [];
+			const returnTypes = [["[]", "SavedSearch"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SavedSearchSave adds a new saved search if ss.ID is 0, or updates an existing
+		// saved search. The stored saved search is returned.
+		async SavedSearchSave(ss) {
+			const fn = "SavedSearchSave";
+			const paramTypes = [["SavedSearch"]];
+			const returnTypes = [["SavedSearch"]];
+			const params = [ss];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SavedSearchRemove removes a saved search.
+		async SavedSearchRemove(savedSearchID) {
+			const fn = "SavedSearchRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [savedSearchID];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// SSETypes exists to ensure the generated API contains the types, for use in SSE events.
 		async SSETypes() {
 			const fn = "SSETypes";
@@ -1365,14 +1448,17 @@ const login = async (reason) => {
 		let autosize;
 		let username;
 		let password;
+		let totpBox;
+		let totpCode;
 		const root = dom.div(style({ position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: zindexes.login, animation: 'fadein .15s ease-in' }), dom.div(reasonElem = reason ? dom.div(style({ marginBottom: '2ex', textAlign: 'center' }), reason) : dom.div(), dom.div(style({ backgroundColor: 'white', borderRadius: '.25em', padding: '1em', boxShadow: '0 0 20px rgba(0, 0, 0, 0.1)', border: '1px solid #ddd', maxWidth: '95vw', overflowX: 'auto', maxHeight: '95vh', overflowY: 'auto', marginBottom: '20vh' }), dom.form(async function submit(e) {
 			e.preventDefault();
 			e.stopPropagation();
 			reasonElem.remove();
+			let totpRequired = false;
 			try {
 				fieldset.disabled = true;
 				const loginToken = await client.LoginPrep();
-				const token = await client.Login(loginToken, username.value, password.value);
+				const token = await client.Login(loginToken, username.value, password.value, totpCode.value);
 				try {
 					window.localStorage.setItem('webmailcsrftoken', token);
 				}
@@ -1387,12 +1473,23 @@ const login = async (reason) => {
 			}
 			catch (err) {
 				console.log('login error', err);
-				window.alert('Error: ' + errmsg(err));
+				if (err.code === 'user:totpRequired') {
+					// Password is valid, a code for two-factor authentication is required too.
+					totpRequired = true;
+				}
+				else {
+					window.alert('Error: ' + errmsg(err));
+				}
 			}
 			finally {
 				fieldset.disabled = false;
 			}
-		}, fieldset = dom.fieldset(dom.h1('Mail'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Email address', style({ marginBottom: '.5ex' })), autosize = dom.span(dom._class('autosize'), username = dom.input(attr.required(''), attr.placeholder('jane@example.org'), function change() { autosize.dataset.value = username.value; }, function input() { autosize.dataset.value = username.value; }))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.required(''))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
+			if (totpRequired) {
+				totpBox.style.display = 'block';
+				totpCode.required = true;
+				totpCode.focus();
+			}
+		}, fieldset = dom.fieldset(dom.h1('Mail'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Email address', style({ marginBottom: '.5ex' })), autosize = dom.span(dom._class('autosize'), username = dom.input(attr.required(''), attr.placeholder('jane@example.org'), function change() { autosize.dataset.value = username.value; }, function input() { autosize.dataset.value = username.value; }))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.required(''))), totpBox = dom.label(style({ display: 'none', marginBottom: '2ex' }), dom.div('Two-factor authentication code', style({ marginBottom: '.5ex' })), totpCode = dom.input(attr.autocomplete('one-time-code'), attr.title('Code from your authenticator app, or one of your recovery codes.'))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
 		document.body.appendChild(root);
 		username.focus();
 	});
@@ -2075,6 +2172,39 @@ const popup = (...kids) => {
 	content.focus();
 	return close;
 };
+// Show popup with mailboxes shared with us by other accounts, through IMAP ACLs.
+// Messages can be read, but not changed.
+const cmdShared = async () => {
+	const mailboxes = await withStatus('Listing shared mailboxes', client.SharedMailboxes()) || [];
+	let messagesElem;
+	let messageElem;
+	const openMessage = async (smb, mi) => {
+		const pm = await withStatus('Loading message', client.SharedParsedMessage(smb.Account, smb.MailboxID, mi.Message.ID));
+		const env = mi.Envelope;
+		dom._kids(messageElem, dom.h2(env.Subject || '(no subject)'), dom.div('From: ', (env.From || []).map(a => formatAddressFull(a)).join(', ')), dom.div('Date: ', env.Date ? env.Date.toLocaleString() : ''), dom.br(), dom.pre(dom._class('mono'), style({ whiteSpace: 'pre-wrap' }), (pm.Texts || []).join('\n\n') || (pm.HasHTML ? '(message only has an HTML part)' : '(no text)')));
+	};
+	const openMailbox = async (smb) => {
+		let offset = 0;
+		let moreElem;
+		const tbody = dom.tbody();
+		const more = async () => {
+			const l = await withStatus('Listing messages', client.SharedMessages(smb.Account, smb.MailboxID, offset)) || [];
+			offset += l.length;
+			dom._kids(moreElem, l.length === 100 ? dom.clickbutton('More', async function click() { await more(); }) : []);
+			tbody.append(...l.map(mi => dom.tr(dom.td(mi.Envelope.Date ? mi.Envelope.Date.toLocaleDateString() : ''), dom.td((mi.Envelope.From || []).map(a => formatAddressShort(a)).join(', ')), dom.td(dom.a(attr.href('#'), mi.Envelope.Subject || '(no subject)', async function click(e) {
+				e.preventDefault();
+				await openMessage(smb, mi);
+			})))));
+		};
+		dom._kids(messagesElem, dom.h2(smb.Account, ': ', smb.Name), dom.table(dom.thead(dom.tr(dom.th('Date'), dom.th('From'), dom.th('Subject'))), tbody), moreElem = dom.div());
+		dom._kids(messageElem);
+		await more();
+	};
+	popup(style({ minWidth: '40em' }), dom.h1('Shared mailboxes'), mailboxes.length === 0 ? dom.div('No mailboxes have been shared with you. Other accounts can share their mailboxes through the IMAP ACL extension.') : dom.ul(mailboxes.map(smb => dom.li(dom.a(attr.href('#'), smb.Account, ': ', smb.Name, async function click(e) {
+		e.preventDefault();
+		await openMailbox(smb);
+	}), ' (', '' + smb.Unread, '/', '' + smb.Total, ')'))), messagesElem = dom.div(), messageElem = dom.div());
+};
 // Show help popup, with shortcuts and basic explanation.
 const cmdHelp = async () => {
 	const remove = popup(style({ padding: '1em 1em 2em 1em' }), dom.h1('Help and keyboard shortcuts'), dom.div(style({ display: 'flex' }), dom.div(style({ width: '40em' }), dom.table(dom.tr(dom.td(attr.colspan('2'), dom.h2('Global', style({ margin: '0' })))), [
@@ -4876,9 +5006,10 @@ const newMailboxView = (xmb, mailboxlistView, otherMailbox) => {
 	};
 	return mbv;
 };
-const newMailboxlistView = (msglistView, requestNewView, updatePageTitle, setLocationHash, unloadSearch, otherMailbox) => {
+const newMailboxlistView = (msglistView, requestNewView, updatePageTitle, setLocationHash, unloadSearch, otherMailbox, openSavedSearch) => {
 	let mailboxViews = [];
 	let mailboxViewActive;
+	let savedSearches = [];
 	// Reorder mailboxes and assign new short names and indenting. Called after changing the list.
 	const updateMailboxNames = () => {
 		const draftmb = mailboxViews.find(mbv => mbv.mailbox.Draft)?.mailbox;
@@ -4975,6 +5106,41 @@ const newMailboxlistView = (msglistView, requestNewView, updatePageTitle, setLoc
 	};
 	const root = dom.div();
 	const mailboxesElem = dom.div();
+	const savedSearchesElem = dom.div();
+	// Examples of saved searches, for quickly adding commonly used virtual mailboxes.
+	const savedSearchPresets = () => {
+		const unread = newFilter();
+		unread.MailboxID = -1;
+		const unreadNot = newNotFilter();
+		unreadNot.Labels = ['\\Seen'];
+		const flagged = newFilter();
+		flagged.MailboxID = -1;
+		flagged.Labels = ['\\Flagged'];
+		return [
+			{ ID: 0, Name: 'All unread', Query: '-label:\\Seen', Filter: unread, NotFilter: unreadNot, MaxAgeDays: 0, All: false, UIDValidity: 0 },
+			{ ID: 0, Name: 'Flagged from last 7 days', Query: 'label:\\Flagged', Filter: flagged, NotFilter: newNotFilter(), MaxAgeDays: 7, All: false, UIDValidity: 0 },
+			{ ID: 0, Name: 'All', Query: 'mb:', Filter: newFilter(), NotFilter: newNotFilter(), MaxAgeDays: 0, All: true, UIDValidity: 0 },
+		];
+	};
+	const renderSavedSearches = () => {
+		dom._kids(savedSearchesElem, savedSearches.map(ss => dom.div(dom._class('mailboxitem'), attr.tabindex('0'), attr.title('Saved search, also available as virtual mailbox "#Virtual/' + ss.Name + '" over IMAP.' + (ss.MaxAgeDays > 0 ? ' Only messages from the last ' + ss.MaxAgeDays + ' days.' : '')), style({ display: 'flex', justifyContent: 'space-between' }), async function click() {
+			closeMailbox();
+			await openSavedSearch(ss);
+		}, async function keydown(e) {
+			if (e.key === 'Enter') {
+				e.stopPropagation();
+				closeMailbox();
+				await openSavedSearch(ss);
+			}
+		}, dom.div(ss.Name), dom.clickbutton('x', dom._class('mailboxhoveronly'), attr.arialabel('Remove saved search.'), attr.title('Remove saved search. Messages are not removed.'), style({ padding: '0 .25em' }), async function click(e) {
+			e.stopPropagation();
+			if (!window.confirm('Are you sure you want to remove saved search "' + ss.Name + '"?')) {
+				return;
+			}
+			await withStatus('Removing saved search', client.SavedSearchRemove(ss.ID), e.target);
+			await mblv.reloadSavedSearches();
+		}))));
+	};
 	dom._kids(root, dom.div(attr.role('region'), attr.arialabel('Mailboxes'), dom.div(dom.h1('Mailboxes', style({ display: 'inline', fontSize: 'inherit' })), ' ', dom.clickbutton('+', attr.arialabel('Create new mailbox.'), attr.title('Create new mailbox.'), style({ padding: '0 .25em' }), function click(e) {
 		let fieldset, name;
 		const remove = popover(e.target, {}, dom.form(async function submit(e) {
@@ -4982,7 +5148,13 @@ const newMailboxlistView = (msglistView, requestNewView, updatePageTitle, setLoc
 			await withStatus('Creating mailbox', client.MailboxCreate(name.value), fieldset);
 			remove();
 		}, fieldset = dom.fieldset(dom.label('Name ', name = dom.input(attr.required('yes'), focusPlaceholder('Lists/Go/Nuts'))), ' ', dom.submitbutton('Create'))));
-	})), mailboxesElem));
+	})), mailboxesElem), dom.div(attr.role('region'), attr.arialabel('Saved searches'), style({ marginTop: '1ex' }), dom.div(dom.h1('Saved searches', attr.title('Saved searches are shown as virtual mailboxes over IMAP, under "#Virtual/". Save a search through the search form.'), style({ display: 'inline', fontSize: 'inherit' })), ' ', dom.clickbutton('+', attr.arialabel('Add example saved search.'), attr.title('Add an example saved search.'), style({ padding: '0 .25em' }), function click(e) {
+		const remove = popover(e.target, {}, dom.div(savedSearchPresets().filter(ss => !savedSearches.find(o => o.Name === ss.Name)).map(ss => dom.div(dom.clickbutton(ss.Name, async function click(e) {
+			await withStatus('Saving search', client.SavedSearchSave(ss), e.target);
+			remove();
+			await mblv.reloadSavedSearches();
+		})))));
+	})), savedSearchesElem));
 	const loadMailboxes = (mailboxes, mbnameOpt) => {
 		mailboxViews = mailboxes.map(mb => newMailboxView(mb, mblv, otherMailbox));
 		updateMailboxNames();
@@ -5098,6 +5270,10 @@ const newMailboxlistView = (msglistView, requestNewView, updatePageTitle, setLoc
 			}
 			mbv.setKeywords(keywords);
 		},
+		reloadSavedSearches: async () => {
+			savedSearches = await client.SavedSearches() || [];
+			renderSavedSearches();
+		},
 	};
 	return mblv;
 };
@@ -5385,7 +5561,16 @@ const newSearchView = (searchbarElem, mailboxlistView, startSearch, searchViewCl
 			},
 		};
 		return v;
-	}), () => ' '), ' ', labels = dom.input(focusPlaceholder('todo -done "-dashingname"'), attr.title('User-defined labels.'), changeHandlers))), dom.tr(dom.td('Headers'), headersCell = dom.td(headerViews = [newHeaderView(true)])), dom.tr(dom.td('Size between'), dom.td(minsize = dom.input(style({ width: '6em' }), focusPlaceholder('10kb'), changeHandlers), ' and ', maxsize = dom.input(style({ width: '6em' }), focusPlaceholder('1mb'), changeHandlers)))), dom.div(style({ padding: '1ex', textAlign: 'right' }), dom.submitbutton('Search')), async function submit(e) {
+	}), () => ' '), ' ', labels = dom.input(focusPlaceholder('todo -done "-dashingname"'), attr.title('User-defined labels.'), changeHandlers))), dom.tr(dom.td('Headers'), headersCell = dom.td(headerViews = [newHeaderView(true)])), dom.tr(dom.td('Size between'), dom.td(minsize = dom.input(style({ width: '6em' }), focusPlaceholder('10kb'), changeHandlers), ' and ', maxsize = dom.input(style({ width: '6em' }), focusPlaceholder('1mb'), changeHandlers)))), dom.div(style({ padding: '1ex', textAlign: 'right' }), dom.clickbutton('Save search', attr.title('Save this search, to open it again later from the list of saved searches. Saved searches are also available as virtual mailboxes over IMAP.'), async function click(e) {
+		const name = window.prompt('Name for saved search');
+		if (!name) {
+			return;
+		}
+		const [f, notf, _] = parseSearch(searchbarElem.value, mailboxlistView);
+		const ss = { ID: 0, Name: name, Query: searchbarElem.value, Filter: f, NotFilter: notf, MaxAgeDays: 0, All: false, UIDValidity: 0 };
+		await withStatus('Saving search', client.SavedSearchSave(ss), e.target);
+		await mailboxlistView.reloadSavedSearches();
+	}), ' ', dom.submitbutton('Search')), async function submit(e) {
 		e.preventDefault();
 		await searchView.submit();
 	})));
@@ -5570,7 +5755,7 @@ const init = async () => {
 	const otherMailbox = (mailboxID) => requestFilter.MailboxID !== mailboxID ? (mailboxlistView.findMailboxByID(mailboxID) || null) : null;
 	const listMailboxes = () => mailboxlistView.mailboxes();
 	const msglistView = newMsglistView(msgElem, listMailboxes, setLocationHash, otherMailbox, possibleLabels, () => msglistscrollElem ? msglistscrollElem.getBoundingClientRect().height : 0, refineKeyword, viewportEnsureMessages);
-	const mailboxlistView = newMailboxlistView(msglistView, requestNewView, updatePageTitle, setLocationHash, unloadSearch, otherMailbox);
+	const mailboxlistView = newMailboxlistView(msglistView, requestNewView, updatePageTitle, setLocationHash, unloadSearch, otherMailbox, (ss) => openSavedSearch(ss));
 	let refineUnreadBtn, refineReadBtn, refineAttachmentsBtn, refineLabelBtn;
 	const refineToggleActive = (btn) => {
 		for (const e of [refineUnreadBtn, refineReadBtn, refineAttachmentsBtn, refineLabelBtn]) {
@@ -5679,6 +5864,16 @@ const init = async () => {
 		document.body.focus();
 		await withStatus('Requesting messages', requestNewView(true, f, notf));
 	};
+	// Called by mailboxlistView when a saved search is opened. The age limit is
+	// relative to now.
+	const openSavedSearch = async (ss) => {
+		const f = { ...ss.Filter };
+		if (ss.MaxAgeDays > 0) {
+			f.Oldest = new Date(Date.now() - ss.MaxAgeDays * 24 * 3600 * 1000);
+		}
+		searchbarElem.value = ss.Query;
+		await startSearch(f, ss.NotFilter);
+	};
 	// Called by searchView when it is closed, due to escape key or click on background.
 	const searchViewClose = () => {
 		if (!search.active) {
@@ -5803,7 +5998,7 @@ const init = async () => {
 		else {
 			selectLayout(layoutElem.value);
 		}
-	}), ' ', dom.clickbutton('Tooltip', attr.title('Show tooltips, based on the title attributes (underdotted text) for the focused element and all user interface elements below it. Use the keyboard shortcut "ctrl ?" instead of clicking on the tooltip button, which changes focus to the tooltip button.'), clickCmd(cmdTooltip, shortcuts)), ' ', dom.clickbutton('Help', attr.title('Show popup with basic usage information and a keyboard shortcuts.'), clickCmd(cmdHelp, shortcuts)), ' ', loginAddressElem = dom.span(), ' ', dom.clickbutton('Logout', attr.title('Logout, invalidating this session.'), async function click(e) {
+	}), ' ', dom.clickbutton('Tooltip', attr.title('Show tooltips, based on the title attributes (underdotted text) for the focused element and all user interface elements below it. Use the keyboard shortcut "ctrl ?" instead of clicking on the tooltip button, which changes focus to the tooltip button.'), clickCmd(cmdTooltip, shortcuts)), ' ', dom.clickbutton('Shared', attr.title('Show mailboxes shared with you by other accounts, and read their messages.'), clickCmd(cmdShared, shortcuts)), ' ', dom.clickbutton('Help', attr.title('Show popup with basic usage information and a keyboard shortcuts.'), clickCmd(cmdHelp, shortcuts)), ' ', loginAddressElem = dom.span(), ' ', dom.clickbutton('Logout', attr.title('Logout, invalidating this session.'), async function click(e) {
 		await withStatus('Logging out', client.Logout(), e.target);
 		localStorageRemove('webmailcsrftoken');
 		if (eventSource) {
@@ -6189,6 +6384,7 @@ const init = async () => {
 				mailboxName = (start.Mailboxes || []).find(mb => mb.ID === requestFilter.MailboxID)?.Name || '';
 			}
 			mailboxlistView.loadMailboxes(start.Mailboxes || [], search.active ? undefined : mailboxName);
+			mailboxlistView.reloadSavedSearches(); // note: async function
 			if (searchView.root.parentElement) {
 				searchView.ensureLoaded();
 			}
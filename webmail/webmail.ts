@@ -239,6 +239,8 @@ const login = async (reason: string) => {
 		let autosize: HTMLElement
 		let username: HTMLInputElement
 		let password: HTMLInputElement
+		let totpBox: HTMLElement
+		let totpCode: HTMLInputElement
 		const root = dom.div(
 			style({position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: zindexes.login, animation: 'fadein .15s ease-in'}),
 			dom.div(
@@ -252,10 +254,11 @@ const login = async (reason: string) => {
 
 							reasonElem.remove()
 
+							let totpRequired = false
 							try {
 								fieldset.disabled = true
 								const loginToken = await client.LoginPrep()
-								const token = await client.Login(loginToken, username.value, password.value)
+								const token = await client.Login(loginToken, username.value, password.value, totpCode.value)
 								try {
 									window.localStorage.setItem('webmailcsrftoken', token)
 								} catch (err) {
@@ -268,10 +271,20 @@ const login = async (reason: string) => {
 								resolve(token)
 							} catch (err) {
 								console.log('login error', err)
-								window.alert('Error: ' + errmsg(err))
+								if ((err as any).code === 'user:totpRequired') {
+									// Password is valid, a code for two-factor authentication is required too.
+									totpRequired = true
+								} else {
+									window.alert('Error: ' + errmsg(err))
+								}
 							} finally {
 								fieldset.disabled = false
 							}
+							if (totpRequired) {
+								totpBox.style.display = 'block'
+								totpCode.required = true
+								totpCode.focus()
+							}
 						},
 						fieldset=dom.fieldset(
 							dom.h1('Mail'),
@@ -292,6 +305,11 @@ const login = async (reason: string) => {
 								dom.div('Password', style({marginBottom: '.5ex'})),
 								password=dom.input(attr.type('password'), attr.required('')),
 							),
+							totpBox=dom.label(
+								style({display: 'none', marginBottom: '2ex'}),
+								dom.div('Two-factor authentication code', style({marginBottom: '.5ex'})),
+								totpCode=dom.input(attr.autocomplete('one-time-code'), attr.title('Code from your authenticator app, or one of your recovery codes.')),
+							),
 							dom.div(
 								style({textAlign: 'center'}),
 								dom.submitbutton('Login'),
@@ -1038,6 +1056,74 @@ const popup = (...kids: ElemArg[]) => {
 	return close
 }
 
+// Show popup with mailboxes shared with us by other accounts, through IMAP ACLs.
+// Messages can be read, but not changed.
+const cmdShared = async () => {
+	const mailboxes = await withStatus('Listing shared mailboxes', client.SharedMailboxes()) || []
+
+	let messagesElem: HTMLElement
+	let messageElem: HTMLElement
+
+	const openMessage = async (smb: api.SharedMailbox, mi: api.MessageItem) => {
+		const pm = await withStatus('Loading message', client.SharedParsedMessage(smb.Account, smb.MailboxID, mi.Message.ID))
+		const env = mi.Envelope
+		dom._kids(messageElem,
+			dom.h2(env.Subject || '(no subject)'),
+			dom.div('From: ', (env.From || []).map(a => formatAddressFull(a)).join(', ')),
+			dom.div('Date: ', env.Date ? env.Date.toLocaleString() : ''),
+			dom.br(),
+			dom.pre(dom._class('mono'), style({whiteSpace: 'pre-wrap'}), (pm.Texts || []).join('\n\n') || (pm.HasHTML ? '(message only has an HTML part)' : '(no text)')),
+		)
+	}
+
+	const openMailbox = async (smb: api.SharedMailbox) => {
+		let offset = 0
+		let moreElem: HTMLElement
+		const tbody = dom.tbody()
+		const more = async () => {
+			const l = await withStatus('Listing messages', client.SharedMessages(smb.Account, smb.MailboxID, offset)) || []
+			offset += l.length
+			dom._kids(moreElem, l.length === 100 ? dom.clickbutton('More', async function click() { await more() }) : [])
+			tbody.append(...l.map(mi =>
+				dom.tr(
+					dom.td(mi.Envelope.Date ? mi.Envelope.Date.toLocaleDateString() : ''),
+					dom.td((mi.Envelope.From || []).map(a => formatAddressShort(a)).join(', ')),
+					dom.td(dom.a(attr.href('#'), mi.Envelope.Subject || '(no subject)', async function click(e: MouseEvent) {
+						e.preventDefault()
+						await openMessage(smb, mi)
+					})),
+				)
+			))
+		}
+		dom._kids(messagesElem,
+			dom.h2(smb.Account, ': ', smb.Name),
+			dom.table(
+				dom.thead(dom.tr(dom.th('Date'), dom.th('From'), dom.th('Subject'))),
+				tbody,
+			),
+			moreElem=dom.div(),
+		)
+		dom._kids(messageElem)
+		await more()
+	}
+
+	popup(
+		style({minWidth: '40em'}),
+		dom.h1('Shared mailboxes'),
+		mailboxes.length === 0 ? dom.div('No mailboxes have been shared with you. Other accounts can share their mailboxes through the IMAP ACL extension.') : dom.ul(
+			mailboxes.map(smb => dom.li(
+				dom.a(attr.href('#'), smb.Account, ': ', smb.Name, async function click(e: MouseEvent) {
+					e.preventDefault()
+					await openMailbox(smb)
+				}),
+				' (', ''+smb.Unread, '/', ''+smb.Total, ')',
+			)),
+		),
+		messagesElem=dom.div(),
+		messageElem=dom.div(),
+	)
+}
+
 // Show help popup, with shortcuts and basic explanation.
 const cmdHelp = async () => {
 	const remove = popup(
@@ -4687,11 +4773,15 @@ interface MailboxlistView {
 	setMailboxCounts: (mailboxID: number, total: number, unread: number) => void
 	setMailboxSpecialUse: (mailboxID: number, specialUse: api.SpecialUse) => void
 	setMailboxKeywords: (mailboxID: number, keywords: string[]) => void
+
+	// Saved searches, shown as virtual mailboxes.
+	reloadSavedSearches: () => Promise<void>
 }
 
-const newMailboxlistView = (msglistView: MsglistView, requestNewView: requestNewView, updatePageTitle: updatePageTitle, setLocationHash: setLocationHash, unloadSearch: unloadSearch, otherMailbox: otherMailbox): MailboxlistView => {
+const newMailboxlistView = (msglistView: MsglistView, requestNewView: requestNewView, updatePageTitle: updatePageTitle, setLocationHash: setLocationHash, unloadSearch: unloadSearch, otherMailbox: otherMailbox, openSavedSearch: (ss: api.SavedSearch) => Promise<void>): MailboxlistView => {
 	let mailboxViews: MailboxView[] = []
 	let mailboxViewActive: MailboxView | null
+	let savedSearches: api.SavedSearch[] = []
 
 	// Reorder mailboxes and assign new short names and indenting. Called after changing the list.
 	const updateMailboxNames = () => {
@@ -4792,6 +4882,55 @@ const newMailboxlistView = (msglistView: MsglistView, requestNewView: requestNew
 
 	const root = dom.div()
 	const mailboxesElem = dom.div()
+	const savedSearchesElem = dom.div()
+
+	// Examples of saved searches, for quickly adding commonly used virtual mailboxes.
+	const savedSearchPresets = (): api.SavedSearch[] => {
+		const unread = newFilter()
+		unread.MailboxID = -1
+		const unreadNot = newNotFilter()
+		unreadNot.Labels = ['\\Seen']
+		const flagged = newFilter()
+		flagged.MailboxID = -1
+		flagged.Labels = ['\\Flagged']
+		return [
+			{ID: 0, Name: 'All unread', Query: '-label:\\Seen', Filter: unread, NotFilter: unreadNot, MaxAgeDays: 0, All: false, UIDValidity: 0},
+			{ID: 0, Name: 'Flagged from last 7 days', Query: 'label:\\Flagged', Filter: flagged, NotFilter: newNotFilter(), MaxAgeDays: 7, All: false, UIDValidity: 0},
+			{ID: 0, Name: 'All', Query: 'mb:', Filter: newFilter(), NotFilter: newNotFilter(), MaxAgeDays: 0, All: true, UIDValidity: 0},
+		]
+	}
+
+	const renderSavedSearches = () => {
+		dom._kids(savedSearchesElem,
+			savedSearches.map(ss =>
+				dom.div(dom._class('mailboxitem'),
+					attr.tabindex('0'),
+					attr.title('Saved search, also available as virtual mailbox "#Virtual/'+ss.Name+'" over IMAP.'+(ss.MaxAgeDays > 0 ? ' Only messages from the last '+ss.MaxAgeDays+' days.' : '')),
+					style({display: 'flex', justifyContent: 'space-between'}),
+					async function click() {
+						closeMailbox()
+						await openSavedSearch(ss)
+					},
+					async function keydown(e: KeyboardEvent) {
+						if (e.key === 'Enter') {
+							e.stopPropagation()
+							closeMailbox()
+							await openSavedSearch(ss)
+						}
+					},
+					dom.div(ss.Name),
+					dom.clickbutton('x', dom._class('mailboxhoveronly'), attr.arialabel('Remove saved search.'), attr.title('Remove saved search. Messages are not removed.'), style({padding: '0 .25em'}), async function click(e: MouseEvent) {
+						e.stopPropagation()
+						if (!window.confirm('Are you sure you want to remove saved search "'+ss.Name+'"?')) {
+							return
+						}
+						await withStatus('Removing saved search', client.SavedSearchRemove(ss.ID), e.target! as HTMLButtonElement)
+						await mblv.reloadSavedSearches()
+					}),
+				)
+			),
+		)
+	}
 
 	dom._kids(root,
 		dom.div(attr.role('region'), attr.arialabel('Mailboxes'),
@@ -4822,6 +4961,29 @@ const newMailboxlistView = (msglistView: MsglistView, requestNewView: requestNew
 			),
 			mailboxesElem,
 		),
+		dom.div(attr.role('region'), attr.arialabel('Saved searches'),
+			style({marginTop: '1ex'}),
+			dom.div(
+				dom.h1('Saved searches', attr.title('Saved searches are shown as virtual mailboxes over IMAP, under "#Virtual/". Save a search through the search form.'), style({display: 'inline', fontSize: 'inherit'})),
+				' ',
+				dom.clickbutton('+', attr.arialabel('Add example saved search.'), attr.title('Add an example saved search.'), style({padding: '0 .25em'}), function click(e: MouseEvent) {
+					const remove = popover(e.target! as HTMLElement, {},
+						dom.div(
+							savedSearchPresets().filter(ss => !savedSearches.find(o => o.Name === ss.Name)).map(ss =>
+								dom.div(
+									dom.clickbutton(ss.Name, async function click(e: MouseEvent) {
+										await withStatus('Saving search', client.SavedSearchSave(ss), e.target! as HTMLButtonElement)
+										remove()
+										await mblv.reloadSavedSearches()
+									}),
+								)
+							),
+						),
+					)
+				}),
+			),
+			savedSearchesElem,
+		),
 	)
 
 	const loadMailboxes = (mailboxes: api.Mailbox[], mbnameOpt?: string) => {
@@ -4954,6 +5116,11 @@ const newMailboxlistView = (msglistView: MsglistView, requestNewView: requestNew
 			}
 			mbv.setKeywords(keywords)
 		},
+
+		reloadSavedSearches: async (): Promise<void> => {
+			savedSearches = await client.SavedSearches() || []
+			renderSavedSearches()
+		},
 	}
 	return mblv
 }
@@ -5395,6 +5562,17 @@ const newSearchView = (searchbarElem: HTMLInputElement, mailboxlistView: Mailbox
 				),
 				dom.div(
 					style({padding: '1ex', textAlign: 'right'}),
+					dom.clickbutton('Save search', attr.title('Save this search, to open it again later from the list of saved searches. Saved searches are also available as virtual mailboxes over IMAP.'), async function click(e: MouseEvent) {
+						const name = window.prompt('Name for saved search')
+						if (!name) {
+							return
+						}
+						const [f, notf, _] = parseSearch(searchbarElem.value, mailboxlistView)
+						const ss: api.SavedSearch = {ID: 0, Name: name, Query: searchbarElem.value, Filter: f, NotFilter: notf, MaxAgeDays: 0, All: false, UIDValidity: 0}
+						await withStatus('Saving search', client.SavedSearchSave(ss), e.target! as HTMLButtonElement)
+						await mailboxlistView.reloadSavedSearches()
+					}),
+					' ',
 					dom.submitbutton('Search'),
 				),
 				async function submit(e: SubmitEvent) {
@@ -5620,7 +5798,7 @@ const init = async () => {
 	const otherMailbox = (mailboxID: number): api.Mailbox | null => requestFilter.MailboxID !== mailboxID ? (mailboxlistView.findMailboxByID(mailboxID) || null) : null
 	const listMailboxes = () => mailboxlistView.mailboxes()
 	const msglistView = newMsglistView(msgElem, listMailboxes, setLocationHash, otherMailbox, possibleLabels, () => msglistscrollElem ? msglistscrollElem.getBoundingClientRect().height : 0, refineKeyword, viewportEnsureMessages)
-	const mailboxlistView = newMailboxlistView(msglistView, requestNewView, updatePageTitle, setLocationHash, unloadSearch, otherMailbox)
+	const mailboxlistView = newMailboxlistView(msglistView, requestNewView, updatePageTitle, setLocationHash, unloadSearch, otherMailbox, (ss: api.SavedSearch) => openSavedSearch(ss))
 
 	let refineUnreadBtn: HTMLButtonElement, refineReadBtn: HTMLButtonElement, refineAttachmentsBtn: HTMLButtonElement, refineLabelBtn: HTMLButtonElement
 	const refineToggleActive = (btn: HTMLButtonElement | null): void => {
@@ -5827,6 +6005,17 @@ const init = async () => {
 		await withStatus('Requesting messages', requestNewView(true, f, notf))
 	}
 
+	// Called by mailboxlistView when a saved search is opened. The age limit is
+	// relative to now.
+	const openSavedSearch = async (ss: api.SavedSearch): Promise<void> => {
+		const f = {...ss.Filter}
+		if (ss.MaxAgeDays > 0) {
+			f.Oldest = new Date(Date.now() - ss.MaxAgeDays*24*3600*1000)
+		}
+		searchbarElem.value = ss.Query
+		await startSearch(f, ss.NotFilter)
+	}
+
 	// Called by searchView when it is closed, due to escape key or click on background.
 	const searchViewClose = () => {
 		if (!search.active) {
@@ -6008,6 +6197,8 @@ const init = async () => {
 					), ' ',
 					dom.clickbutton('Tooltip', attr.title('Show tooltips, based on the title attributes (underdotted text) for the focused element and all user interface elements below it. Use the keyboard shortcut "ctrl ?" instead of clicking on the tooltip button, which changes focus to the tooltip button.'), clickCmd(cmdTooltip, shortcuts)),
 					' ',
+					dom.clickbutton('Shared', attr.title('Show mailboxes shared with you by other accounts, and read their messages.'), clickCmd(cmdShared, shortcuts)),
+					' ',
 					dom.clickbutton('Help', attr.title('Show popup with basic usage information and a keyboard shortcuts.'), clickCmd(cmdHelp, shortcuts)),
 					' ',
 					loginAddressElem=dom.span(),
@@ -6489,6 +6680,7 @@ const init = async () => {
 				mailboxName = (start.Mailboxes || []).find(mb => mb.ID === requestFilter.MailboxID)?.Name || ''
 			}
 			mailboxlistView.loadMailboxes(start.Mailboxes || [], search.active ? undefined : mailboxName)
+			mailboxlistView.reloadSavedSearches() // note: async function
 			if (searchView.root.parentElement) {
 				searchView.ensureLoaded()
 			}